@@ -0,0 +1,149 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// accessLogSettingName is the name of the diagnostic setting of the file service of the storage accounts which the
+// broker creates
+const accessLogSettingName = "azurefilebroker-access-logs"
+
+// accessLogCategories are the categories of the logs of the file service which audit the SMB accesses
+var accessLogCategories = []string{"StorageRead", "StorageWrite", "StorageDelete"}
+
+var (
+	logAnalyticsWorkspaceIDPattern = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.OperationalInsights/workspaces/[^/]+$`)
+	loggingStorageAccountIDPattern = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Storage/storageAccounts/[^/]+$`)
+)
+
+// AccessLogDestination is where the access logs of the file shares are sent: a Log Analytics workspace, a logging
+// storage account or both
+type AccessLogDestination struct {
+	WorkspaceID      string `json:"workspace_id,omitempty"`
+	StorageAccountID string `json:"storage_account_id,omitempty"`
+}
+
+// AccessLogConfig is the destination of the access logs of the storage accounts which the broker creates and the plans
+// whose instances send them unless the provision parameter access_logs is false
+type AccessLogConfig struct {
+	AccessLogDestination
+	Plans []string
+}
+
+// NewAccessLogConfig returns the access log config of a comma separated list of plans and the full IDs of a Log
+// Analytics workspace and of a logging storage account
+func NewAccessLogConfig(plans, workspaceID, storageAccountID string) *AccessLogConfig {
+	myConf := new(AccessLogConfig)
+
+	myConf.WorkspaceID = strings.TrimSpace(workspaceID)
+	myConf.StorageAccountID = strings.TrimSpace(storageAccountID)
+	myConf.Plans = make([]string, 0)
+	for _, plan := range strings.Split(plans, ",") {
+		if plan = strings.TrimSpace(plan); plan != "" {
+			myConf.Plans = append(myConf.Plans, plan)
+		}
+	}
+
+	return myConf
+}
+
+// IsEnabled returns true if the access logs have a destination
+func (config *AccessLogConfig) IsEnabled() bool {
+	return config.WorkspaceID != "" || config.StorageAccountID != ""
+}
+
+// Validate checks the destination and that the plans are AzureFileShare plans of the catalog
+func (config *AccessLogConfig) Validate(segments *IsolationSegmentConfig, catalog *CatalogConfig, azure *AzureConfig) error {
+	if !config.IsEnabled() {
+		if len(config.Plans) > 0 {
+			return fmt.Errorf("accessLogPlans requires accessLogWorkspaceID or accessLogStorageAccountID")
+		}
+		return nil
+	}
+	if config.WorkspaceID != "" && !logAnalyticsWorkspaceIDPattern.MatchString(config.WorkspaceID) {
+		return fmt.Errorf("Invalid accessLogWorkspaceID %q: expected /subscriptions/<id>/resourceGroups/<name>/providers/Microsoft.OperationalInsights/workspaces/<name>", config.WorkspaceID)
+	}
+	if config.StorageAccountID != "" && !loggingStorageAccountIDPattern.MatchString(config.StorageAccountID) {
+		return fmt.Errorf("Invalid accessLogStorageAccountID %q: expected /subscriptions/<id>/resourceGroups/<name>/providers/Microsoft.Storage/storageAccounts/<name>", config.StorageAccountID)
+	}
+	if !azure.IsSupportAzureFileShare() {
+		return fmt.Errorf("accessLogWorkspaceID and accessLogStorageAccountID require AzureFileShare")
+	}
+	if azure.GetAPIVersions().DiagnosticSettings == "" {
+		return fmt.Errorf("accessLogWorkspaceID and accessLogStorageAccountID are not supported in the environment %q", azure.Environment)
+	}
+	planNames := optionPlanNames(segments, catalog, false)
+	for _, plan := range config.Plans {
+		if !inArray(planNames, plan) {
+			return fmt.Errorf("Unknown plan %q in accessLogPlans: expected one of %s", plan, strings.Join(planNames, ", "))
+		}
+	}
+	return nil
+}
+
+// accessLogsEnabled returns whether a new AzureFileShare instance of the plan sends the access logs of its file
+// shares. The provision parameter access_logs overrides the plans of the config.
+func (b *Broker) accessLogsEnabled(configuration Configuration, planName string) (bool, error) {
+	enabled := inArray(b.config.accessLogs.Plans, planName)
+	if configuration.AccessLogs != "" {
+		var err error
+		if enabled, err = strconv.ParseBool(configuration.AccessLogs); err != nil {
+			return false, newBrokerError(ErrCodeInvalidParameters, "Invalid access_logs %q: expected true or false", configuration.AccessLogs)
+		}
+	}
+	if enabled && !b.config.accessLogs.IsEnabled() {
+		return false, newBrokerError(ErrCodeInvalidParameters, "The parameter access_logs is not supported: the administrator has not configured a destination of the access logs")
+	}
+	return enabled, nil
+}
+
+// createStorageAccountAccessLogs sends the access logs of the file shares of a storage account which the broker has
+// created to the destination of the config, and records the destination in the instance. A failure is only logged
+// because the storage account is usable without them.
+func (b *Broker) createStorageAccountAccessLogs(logger lager.Logger, serviceInstance *ServiceInstance) {
+	if !serviceInstance.AccessLogs || !serviceInstance.IsCreatedStorageAccount || serviceInstance.AccessLogDestination != nil {
+		return
+	}
+	logger = logger.Session("create-storage-account-access-logs")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	restClient, err := b.newRESTClientOfServiceInstance(logger, serviceInstance)
+	if err != nil {
+		logger.Error("new-rest-client", err)
+		return
+	}
+	destination := b.config.accessLogs.AccessLogDestination
+	if err := restClient.CreateDiagnosticSetting(accessLogSettingName, destination); err != nil {
+		logger.Error("create-diagnostic-setting", err)
+		return
+	}
+	serviceInstance.AccessLogDestination = &destination
+	logger.Info("diagnostic-setting-created", lager.Data{"destination": destination})
+}
+
+// deleteStorageAccountAccessLogs deletes the diagnostic setting of a storage account which the broker has deleted.
+// Azure Monitor keeps the diagnostic settings of deleted resources and applies them again to a new storage account of
+// the same name. A failure is only logged because the storage account is gone.
+func (b *Broker) deleteStorageAccountAccessLogs(logger lager.Logger, serviceInstance *ServiceInstance) {
+	if serviceInstance.AccessLogDestination == nil {
+		return
+	}
+	logger = logger.Session("delete-storage-account-access-logs")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	restClient, err := b.newRESTClientOfServiceInstance(logger, serviceInstance)
+	if err != nil {
+		logger.Error("new-rest-client", err)
+		return
+	}
+	if err := restClient.DeleteDiagnosticSetting(accessLogSettingName); err != nil {
+		logger.Error("delete-diagnostic-setting", err)
+	}
+}
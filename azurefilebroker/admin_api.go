@@ -0,0 +1,291 @@
+package azurefilebroker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// AdminPathPrefix is the path under which the admin API is served
+const AdminPathPrefix = "/admin/"
+
+type adminErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"description"`
+}
+
+// AdminHandler returns the handler of the admin API for operators. It requires the same credentials as the broker API.
+//
+//	GET    /admin/instances/:instance_id/share-stats  the last collected usage of the file shares of the instance
+//	GET    /admin/instances/:instance_id/operations   the history of the operations of the instance
+//	GET    /admin/instances/:instance_id/parameters   the provision parameters of the instance without the secrets
+//	GET    /admin/instances/:instance_id/files        the root directory and the current usage of the file shares of the
+//	                                                  instance, read from Azure
+//	GET    /admin/feature-flags                       the effective value of the feature flags
+//	PUT    /admin/feature-flags/:name                 set the feature flag with the body {"enabled": true|false}
+//	DELETE /admin/feature-flags/:name                 reset the feature flag to the value when the broker started
+//	GET    /admin/share-deletions                     the file shares whose deletion is retried in the background
+//	GET    /admin/storage-accounts/:name/instances    the service instances and the bindings of the storage account
+//	GET    /admin/stale-bindings                      the bindings whose apps are missing and since when
+//	GET    /admin/stats                               the counts of the resources and the results of the operations
+//	GET    /admin/locks                               the locks which the broker processes took, their holders and ages
+//	DELETE /admin/locks/:name                         end the database session which holds the lock
+func (b *Broker) AdminHandler(credentials brokerapi.BrokerCredentials) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(AdminPathPrefix+"instances/", b.handleAdminInstances)
+	mux.HandleFunc(AdminPathPrefix+"feature-flags", b.handleAdminFeatureFlags)
+	mux.HandleFunc(AdminPathPrefix+"feature-flags/", b.handleAdminFeatureFlags)
+	mux.HandleFunc(AdminPathPrefix+"share-deletions", b.handleAdminShareDeletions)
+	mux.HandleFunc(AdminPathPrefix+"storage-accounts/", b.handleAdminStorageAccounts)
+	mux.HandleFunc(AdminPathPrefix+"stats", b.handleAdminStats)
+	mux.HandleFunc(AdminPathPrefix+"stale-bindings", b.handleAdminStaleBindings)
+	mux.HandleFunc(AdminPathPrefix+"locks", b.handleAdminLocks)
+	mux.HandleFunc(AdminPathPrefix+"locks/", b.handleAdminLocks)
+	return &adminAuthHandler{handler: mux, credentials: credentials}
+}
+
+type adminAuthHandler struct {
+	handler     http.Handler
+	credentials brokerapi.BrokerCredentials
+}
+
+func (h *adminAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok ||
+		subtle.ConstantTimeCompare([]byte(username), []byte(h.credentials.Username)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(h.credentials.Password)) != 1 {
+		writeAdminResponse(w, http.StatusUnauthorized, adminErrorResponse{Error: "Unauthorized", Description: "Invalid credentials"})
+		return
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+func (b *Broker) handleAdminInstances(w http.ResponseWriter, r *http.Request) {
+	logger := b.logger.Session("admin-instances").WithData(lager.Data{"method": r.Method, "path": requestPath(r)})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	// instances/:instance_id/:resource
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, AdminPathPrefix), "/")
+	if len(parts) != 3 || parts[1] == "" {
+		writeAdminError(w, newBrokerError(ErrCodeResourceNotFound, "Unknown path %q", requestPath(r)))
+		return
+	}
+	instanceID, resource := parts[1], parts[2]
+
+	switch {
+	case resource == "share-stats" && r.Method == http.MethodGet:
+		stats, err := b.ShareStatsOfInstance(instanceID)
+		if err != nil {
+			logger.Error("share-stats-of-instance", err)
+			writeAdminError(w, err)
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, map[string]interface{}{"instance_id": instanceID, "file_shares": stats})
+	case resource == "operations" && r.Method == http.MethodGet:
+		operations, err := b.InstanceOperations(instanceID)
+		if err != nil {
+			logger.Error("instance-operations", err)
+			writeAdminError(w, err)
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, map[string]interface{}{"instance_id": instanceID, "count": len(operations), "operations": operations})
+	case resource == "parameters" && r.Method == http.MethodGet:
+		parameters, err := b.InstanceProvisionParameters(instanceID)
+		if err != nil {
+			logger.Error("instance-provision-parameters", err)
+			writeAdminError(w, err)
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, map[string]interface{}{"instance_id": instanceID, "parameters": parameters})
+	case resource == "files" && r.Method == http.MethodGet:
+		listings, err := b.ListSharesOfInstance(instanceID)
+		if err != nil {
+			logger.Error("list-shares-of-instance", err)
+			writeAdminError(w, err)
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, map[string]interface{}{"instance_id": instanceID, "file_shares": listings})
+	default:
+		writeAdminError(w, newBrokerError(ErrCodeResourceNotFound, "Unknown path %q for %s", requestPath(r), r.Method))
+	}
+}
+
+func (b *Broker) handleAdminFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	logger := b.logger.Session("admin-feature-flags").WithData(lager.Data{"method": r.Method, "path": requestPath(r)})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	// feature-flags[/:name]
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, AdminPathPrefix+"feature-flags"), "/")
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		flags, err := b.FeatureFlags()
+		if err != nil {
+			logger.Error("feature-flags", err)
+			writeAdminError(w, err)
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, map[string]interface{}{"feature_flags": flags})
+	case name != "" && r.Method == http.MethodPut:
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+			writeAdminError(w, newBrokerError(ErrCodeInvalidParameters, "The body must be {\"enabled\": true|false}"))
+			return
+		}
+		if err := b.SetFeatureFlag(name, *body.Enabled); err != nil {
+			logger.Error("set-feature-flag", err)
+			writeAdminError(w, err)
+			return
+		}
+		b.writeAdminFeatureFlag(w, name)
+	case name != "" && r.Method == http.MethodDelete:
+		if err := b.ResetFeatureFlag(name); err != nil {
+			logger.Error("reset-feature-flag", err)
+			writeAdminError(w, err)
+			return
+		}
+		b.writeAdminFeatureFlag(w, name)
+	default:
+		writeAdminError(w, newBrokerError(ErrCodeResourceNotFound, "Unknown path %q for %s", requestPath(r), r.Method))
+	}
+}
+
+func (b *Broker) writeAdminFeatureFlag(w http.ResponseWriter, name string) {
+	flags, err := b.FeatureFlags()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	for _, flag := range flags {
+		if flag.Name == name {
+			writeAdminResponse(w, http.StatusOK, flag)
+			return
+		}
+	}
+}
+
+func (b *Broker) handleAdminShareDeletions(w http.ResponseWriter, r *http.Request) {
+	logger := b.logger.Session("admin-share-deletions").WithData(lager.Data{"method": r.Method, "path": requestPath(r)})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if r.Method != http.MethodGet {
+		writeAdminError(w, newBrokerError(ErrCodeResourceNotFound, "Unknown path %q for %s", requestPath(r), r.Method))
+		return
+	}
+	deletions, err := b.PendingShareDeletions()
+	if err != nil {
+		logger.Error("pending-share-deletions", err)
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminResponse(w, http.StatusOK, map[string]interface{}{"count": len(deletions), "share_deletions": deletions})
+}
+
+func (b *Broker) handleAdminStorageAccounts(w http.ResponseWriter, r *http.Request) {
+	logger := b.logger.Session("admin-storage-accounts").WithData(lager.Data{"method": r.Method, "path": requestPath(r)})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	// storage-accounts/:name/:resource
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, AdminPathPrefix), "/")
+	if len(parts) != 3 || parts[1] == "" {
+		writeAdminError(w, newBrokerError(ErrCodeResourceNotFound, "Unknown path %q", requestPath(r)))
+		return
+	}
+	storageAccountName, resource := parts[1], parts[2]
+
+	switch {
+	case resource == "instances" && r.Method == http.MethodGet:
+		references, err := b.StorageAccountReferences(storageAccountName)
+		if err != nil {
+			logger.Error("storage-account-references", err)
+			writeAdminError(w, err)
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, references)
+	default:
+		writeAdminError(w, newBrokerError(ErrCodeResourceNotFound, "Unknown path %q for %s", requestPath(r), r.Method))
+	}
+}
+
+func (b *Broker) handleAdminStaleBindings(w http.ResponseWriter, r *http.Request) {
+	logger := b.logger.Session("admin-stale-bindings").WithData(lager.Data{"method": r.Method, "path": requestPath(r)})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if r.Method != http.MethodGet {
+		writeAdminError(w, newBrokerError(ErrCodeResourceNotFound, "Unknown path %q for %s", requestPath(r), r.Method))
+		return
+	}
+	bindings := b.StaleBindings()
+	writeAdminResponse(w, http.StatusOK, map[string]interface{}{"count": len(bindings), "stale_bindings": bindings})
+}
+
+func (b *Broker) handleAdminLocks(w http.ResponseWriter, r *http.Request) {
+	logger := b.logger.Session("admin-locks").WithData(lager.Data{"method": r.Method, "path": requestPath(r)})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	// The names of the locks may have slashes
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, AdminPathPrefix+"locks"), "/")
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		locks, err := b.Locks(logger)
+		if err != nil {
+			logger.Error("locks", err)
+			writeAdminError(w, err)
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, map[string]interface{}{"count": len(locks), "locks": locks})
+	case name != "" && r.Method == http.MethodDelete:
+		lock, err := b.ForceUnlock(logger, name)
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, lock)
+	default:
+		writeAdminError(w, newBrokerError(ErrCodeResourceNotFound, "Unknown path %q for %s", requestPath(r), r.Method))
+	}
+}
+
+func (b *Broker) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	logger := b.logger.Session("admin-stats").WithData(lager.Data{"method": r.Method, "path": requestPath(r)})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if r.Method != http.MethodGet {
+		writeAdminError(w, newBrokerError(ErrCodeResourceNotFound, "Unknown path %q for %s", requestPath(r), r.Method))
+		return
+	}
+	stats, err := b.Stats()
+	if err != nil {
+		logger.Error("stats", err)
+		writeAdminError(w, err)
+		return
+	}
+	writeAdminResponse(w, http.StatusOK, stats)
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	if brokerError, ok := err.(*BrokerError); ok {
+		writeAdminResponse(w, brokerError.StatusCode(), adminErrorResponse{Error: brokerError.Code, Description: brokerError.Message})
+		return
+	}
+	writeAdminResponse(w, http.StatusInternalServerError, adminErrorResponse{Error: "InternalError", Description: err.Error()})
+}
+
+func writeAdminResponse(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,226 @@
+package azurefilebroker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// The metrics of the admission control
+const (
+	metricAdmissionInFlight = "azurefilebroker_admission_in_flight"
+	metricAdmissionQueued   = "azurefilebroker_admission_queued"
+	metricAdmissionRejected = "azurefilebroker_admission_rejected_total"
+)
+
+// AdmissionConfig limits the provisions and the binds which the broker serves at the same time. A request which finds
+// MaxConcurrent requests in flight waits in a queue of MaxQueued requests for at most QueueTimeout. It gets 503 with
+// Retry-After when the queue is full or its wait is over, so that a load spike does not exhaust the connections to
+// the database and to Azure.
+type AdmissionConfig struct {
+	MaxConcurrent int
+	MaxQueued     int
+	QueueTimeout  time.Duration
+	RetryAfter    time.Duration
+}
+
+func NewAdmissionConfig(maxConcurrent, maxQueued int, queueTimeout, retryAfter time.Duration) *AdmissionConfig {
+	myConf := new(AdmissionConfig)
+
+	myConf.MaxConcurrent = maxConcurrent
+	myConf.MaxQueued = maxQueued
+	myConf.QueueTimeout = queueTimeout
+	myConf.RetryAfter = retryAfter
+
+	return myConf
+}
+
+// IsEnabled returns true if the requests in flight are limited
+func (config *AdmissionConfig) IsEnabled() bool {
+	return config.MaxConcurrent > 0
+}
+
+func (config *AdmissionConfig) Validate() error {
+	if !config.IsEnabled() {
+		return nil
+	}
+	if config.MaxQueued < 0 {
+		return fmt.Errorf("admissionMaxQueued must not be negative")
+	}
+	if config.MaxQueued > 0 && config.QueueTimeout <= 0 {
+		return fmt.Errorf("admissionQueueTimeout must be positive when admissionMaxQueued is set")
+	}
+	if config.RetryAfter <= 0 {
+		return fmt.Errorf("admissionRetryAfter must be positive when admissionMaxConcurrent is set")
+	}
+	return nil
+}
+
+type admissionControl struct {
+	broker *Broker
+	config AdmissionConfig
+	next   http.Handler
+	// slots has a value for every request in flight
+	slots chan struct{}
+
+	mutex  sync.Mutex
+	queued int
+}
+
+// AdmissionControl returns a handler which limits the provisions and the binds in flight by the config. The other
+// requests, e.g. the polls of the last operations and the unbinds which free the resources, always go to next.
+func (b *Broker) AdmissionControl(config *AdmissionConfig, next http.Handler) http.Handler {
+	if !config.IsEnabled() {
+		return next
+	}
+	return &admissionControl{
+		broker: b,
+		config: *config,
+		next:   next,
+		slots:  make(chan struct{}, config.MaxConcurrent),
+	}
+}
+
+type admissionSlotKey struct{}
+
+// admissionSlot is the slot of a request in flight. It is released when the request ends, unless an operation which
+// continues in the background keeps it, e.g. an asynchronous bind.
+type admissionSlot struct {
+	mutex   sync.Mutex
+	kept    bool
+	release func()
+}
+
+// end releases the slot at the end of the request unless it is kept
+func (s *admissionSlot) end() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.kept {
+		s.release()
+	}
+}
+
+// keepAdmissionSlot keeps the admission slot of the request of the context after the request ends, and returns the
+// function which releases it once the operation in the background ends. It does nothing without an admission slot.
+func keepAdmissionSlot(ctx context.Context) func() {
+	slot, ok := ctx.Value(admissionSlotKey{}).(*admissionSlot)
+	if !ok {
+		return func() {}
+	}
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+	slot.kept = true
+	var once sync.Once
+	return func() { once.Do(slot.release) }
+}
+
+// admissionOperation returns the operation of the request which is limited, or "" if it is not limited
+func admissionOperation(r *http.Request) string {
+	if r.Method != http.MethodPut {
+		return ""
+	}
+	// /v2/service_instances/:instance_id[/service_bindings/:binding_id]
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "v2" && parts[1] == "service_instances":
+		return "provision"
+	case len(parts) == 5 && parts[0] == "v2" && parts[1] == "service_instances" && parts[3] == "service_bindings":
+		return "bind"
+	}
+	return ""
+}
+
+func (a *admissionControl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	operation := admissionOperation(r)
+	if operation == "" {
+		a.next.ServeHTTP(w, r)
+		return
+	}
+	logger := a.broker.logger.Session("admission-control").WithData(lager.Data{"operation": operation, "path": requestPath(r)})
+
+	select {
+	case a.slots <- struct{}{}:
+	default:
+		if !a.enqueue() {
+			logger.Info("rejected-queue-full", lager.Data{"maxConcurrent": a.config.MaxConcurrent, "maxQueued": a.config.MaxQueued})
+			a.reject(w, operation, "queue-full")
+			return
+		}
+		timer := a.broker.clock.NewTimer(a.config.QueueTimeout)
+		select {
+		case a.slots <- struct{}{}:
+			timer.Stop()
+			a.dequeue()
+		case <-timer.C():
+			a.dequeue()
+			logger.Info("rejected-queue-timeout", lager.Data{"queueTimeout": a.config.QueueTimeout.String()})
+			a.reject(w, operation, "queue-timeout")
+			return
+		case <-r.Context().Done():
+			timer.Stop()
+			a.dequeue()
+			logger.Info("cancelled-in-queue")
+			return
+		}
+	}
+	a.setGauges()
+	slot := &admissionSlot{release: func() {
+		<-a.slots
+		a.setGauges()
+	}}
+	defer slot.end()
+	a.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), admissionSlotKey{}, slot)))
+}
+
+// enqueue returns false when the queue is full
+func (a *admissionControl) enqueue() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.queued >= a.config.MaxQueued {
+		return false
+	}
+	a.queued++
+	a.setGaugesLocked()
+	return true
+}
+
+func (a *admissionControl) dequeue() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.queued--
+	a.setGaugesLocked()
+}
+
+func (a *admissionControl) setGauges() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.setGaugesLocked()
+}
+
+// setGaugesLocked sets the gauges of the requests in flight and in the queue. The caller must hold the mutex.
+func (a *admissionControl) setGaugesLocked() {
+	if a.broker.metrics == nil {
+		return
+	}
+	a.broker.metrics.SetGauge(metricAdmissionInFlight, nil, float64(len(a.slots)))
+	a.broker.metrics.SetGauge(metricAdmissionQueued, nil, float64(a.queued))
+}
+
+func (a *admissionControl) reject(w http.ResponseWriter, operation, reason string) {
+	if a.broker.metrics != nil {
+		a.broker.metrics.IncrementCounter(metricAdmissionRejected, map[string]string{"operation": operation, "reason": reason})
+	}
+	seconds := int(math.Ceil(a.config.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeAdminResponse(w, http.StatusServiceUnavailable, adminErrorResponse{
+		Error:       "ServiceUnavailable",
+		Description: fmt.Sprintf("The broker is serving too many provisions and binds: retry after %d seconds", seconds),
+	})
+}
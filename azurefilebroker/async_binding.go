@@ -0,0 +1,267 @@
+package azurefilebroker
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// An asynchronous bind or unbind which is still in progress after this long is taken as failed when the bind or the
+// unbind has no timeout, e.g. because the broker which ran it was restarted
+const defaultAsyncBindingExpiry = time.Hour
+
+// asyncBindingResponse is the body of the 202 response of an asynchronous bind or unbind
+type asyncBindingResponse struct {
+	Operation string `json:"operation"`
+}
+
+// lastOperationResponse is the body of the last operation of a service binding
+type lastOperationResponse struct {
+	State       brokerapi.LastOperationState `json:"state"`
+	Description string                       `json:"description,omitempty"`
+}
+
+type bindingRebuildKey struct{}
+
+// isBindingRebuild returns true if the bind of the context only rebuilds the response of an existing binding
+func isBindingRebuild(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	isRebuild, _ := ctx.Value(bindingRebuildKey{}).(bool)
+	return isRebuild
+}
+
+// AsyncBindingHandler serves the asynchronous binds and unbinds of the service broker API 2.14, which the vendored
+// service broker API does not support, and the last operations of the service bindings. A bind or an unbind with
+// accepts_incomplete=true is answered with 202 and runs in the background. Its state is kept in the history of the
+// instance so that every broker answers the last operation. The binds of preexisting shares, which do not touch Azure,
+// the binds of existing bindings and any other request are passed to the next handler, which answers them at once.
+func (b *Broker) AsyncBindingHandler(credentials brokerapi.BrokerCredentials, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		segments := strings.Split(strings.TrimPrefix(r.URL.Path, InstancesPath), "/")
+		if !strings.HasPrefix(r.URL.Path, InstancesPath) || len(segments) < 3 || segments[1] != "service_bindings" || containsString(segments, "") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		isLastOperation := len(segments) == 4 && segments[3] == "last_operation" && r.Method == http.MethodGet
+		isAsync := len(segments) == 3 && r.URL.Query().Get("accepts_incomplete") == "true" &&
+			(r.Method == http.MethodPut || r.Method == http.MethodDelete)
+		if !isLastOperation && !isAsync {
+			next.ServeHTTP(w, r)
+			return
+		}
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(credentials.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(credentials.Password)) != 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		instanceID, bindingID := segments[0], segments[2]
+
+		if isLastOperation {
+			lastOperation, err := b.BindingLastOperation(instanceID, bindingID)
+			if err != nil {
+				writeAdminError(w, err)
+				return
+			}
+			writeAdminResponse(w, http.StatusOK, lastOperationResponse{State: lastOperation.State, Description: lastOperation.Description})
+			return
+		}
+
+		var started bool
+		var err error
+		if r.Method == http.MethodPut {
+			body, readErr := ioutil.ReadAll(r.Body)
+			if readErr != nil {
+				writeAdminError(w, readErr)
+				return
+			}
+			// The service broker API answers the invalid requests and the binds which are not asynchronous
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			var details brokerapi.BindDetails
+			if json.Unmarshal(body, &details) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			started, err = b.BindAsync(r.Context(), instanceID, bindingID, details)
+		} else {
+			started, err = b.UnbindAsync(r.Context(), instanceID, bindingID, brokerapi.UnbindDetails{
+				ServiceID: r.URL.Query().Get("service_id"),
+				PlanID:    r.URL.Query().Get("plan_id"),
+			})
+		}
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		if !started {
+			next.ServeHTTP(w, r)
+			return
+		}
+		operation := "bind"
+		if r.Method == http.MethodDelete {
+			operation = "unbind"
+		}
+		writeAdminResponse(w, http.StatusAccepted, asyncBindingResponse{Operation: operation})
+	})
+}
+
+// BindAsync starts the bind in the background and returns true, or returns false if the bind must run synchronously.
+// The binds of preexisting shares and of existing bindings are synchronous, and so are the binds of instances which
+// do not exist so that the synchronous bind returns the error. A bind which is in progress is not started again.
+func (b *Broker) BindAsync(ctx context.Context, instanceID, bindingID string, details brokerapi.BindDetails) (bool, error) {
+	logger := b.logger.Session("bind-async").WithData(lager.Data{"instanceID": instanceID, "bindingID": bindingID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
+	if err != nil || serviceInstance.IsPreexisting {
+		return false, nil
+	}
+	if _, err := b.store.RetrieveBindingDetails(bindingID); err == nil {
+		return false, nil
+	}
+	return b.startBindingOperation(ctx, logger, instanceID, bindingID, "bind", func(ctx context.Context) {
+		b.Bind(ctx, instanceID, bindingID, details)
+	})
+}
+
+// UnbindAsync starts the unbind in the background and returns true, or returns false if the unbind must run
+// synchronously, e.g. because the binding does not exist. An unbind which is in progress is not started again.
+func (b *Broker) UnbindAsync(ctx context.Context, instanceID, bindingID string, details brokerapi.UnbindDetails) (bool, error) {
+	logger := b.logger.Session("unbind-async").WithData(lager.Data{"instanceID": instanceID, "bindingID": bindingID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
+	if err != nil || serviceInstance.IsPreexisting {
+		return false, nil
+	}
+	if _, err := b.store.RetrieveBindingDetails(bindingID); err != nil {
+		return false, nil
+	}
+	return b.startBindingOperation(ctx, logger, instanceID, bindingID, "unbind", func(ctx context.Context) {
+		b.Unbind(ctx, instanceID, bindingID, details)
+	})
+}
+
+// startBindingOperation records the operation in progress and runs it in the background. Bind and Unbind record its
+// result when it ends, with the error which the last operation returns. The originating identity and the admission slot
+// of the request are kept because the request ends before the operation, which is stopped once it may no longer be in
+// progress.
+func (b *Broker) startBindingOperation(ctx context.Context, logger lager.Logger, instanceID, bindingID, operation string, run func(context.Context)) (bool, error) {
+	latest, err := b.latestBindingOperation(instanceID, bindingID)
+	if err != nil {
+		logger.Error("retrieve-binding-operation", err)
+		return false, err
+	}
+	if latest != nil && latest.Operation == operation && b.isBindingOperationInProgress(latest) {
+		logger.Info("operation-in-progress")
+		return true, nil
+	}
+
+	inProgress := InstanceOperation{InstanceID: instanceID, Operation: operation, BindingID: bindingID, Result: string(brokerapi.InProgress)}
+	if err := b.createInstanceOperation(inProgress, b.clock.Now(), nil); err != nil {
+		logger.Error("create-instance-operation", err)
+		return false, newStoreError(err, "Failed to record the %s of the binding %q", operation, bindingID)
+	}
+	releaseSlot := keepAdmissionSlot(ctx)
+	runCtx, cancel := context.WithTimeout(withOriginatingIdentity(context.Background(), originatingIdentity(ctx)), b.bindingOperationExpiry(operation))
+	go func() {
+		defer releaseSlot()
+		defer cancel()
+		run(runCtx)
+	}()
+	logger.Info("operation-started", lager.Data{"operation": operation})
+	return true, nil
+}
+
+// BindingLastOperation returns the state of the last bind or unbind of the binding. An operation which has been in
+// progress for longer than it may run is failed because the broker which ran it has stopped.
+func (b *Broker) BindingLastOperation(instanceID, bindingID string) (brokerapi.LastOperation, error) {
+	logger := b.logger.Session("binding-last-operation").WithData(lager.Data{"instanceID": instanceID, "bindingID": bindingID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	latest, err := b.latestBindingOperation(instanceID, bindingID)
+	if err != nil {
+		logger.Error("retrieve-binding-operation", err)
+		return brokerapi.LastOperation{}, err
+	}
+	if latest == nil {
+		return brokerapi.LastOperation{}, newBrokerError(ErrCodeResourceNotFound, "The service binding %q has no operation", bindingID)
+	}
+	switch {
+	case b.isBindingOperationInProgress(latest):
+		return brokerapi.LastOperation{State: brokerapi.InProgress, Description: "The " + latest.Operation + " is in progress"}, nil
+	case latest.Result == string(brokerapi.InProgress):
+		return brokerapi.LastOperation{State: brokerapi.Failed, Description: "The " + latest.Operation + " was interrupted. Try again"}, nil
+	case latest.Result == string(brokerapi.Failed):
+		return brokerapi.LastOperation{State: brokerapi.Failed, Description: latest.Error}, nil
+	}
+	return brokerapi.LastOperation{State: brokerapi.Succeeded}, nil
+}
+
+// latestBindingOperation returns the last bind or unbind of the binding in the history of the instance, or nil. The
+// history is read from the primary database because the operation may have just been recorded. When an operation
+// starts as it is recorded in progress, its result wins.
+func (b *Broker) latestBindingOperation(instanceID, bindingID string) (*InstanceOperation, error) {
+	operations, err := b.store.RetrieveInstanceOperations(instanceID)
+	if err != nil {
+		return nil, newStoreError(err, "Failed to retrieve the operations of the instance %q", instanceID)
+	}
+	var latest *InstanceOperation
+	for i := range operations {
+		operation := &operations[i]
+		if operation.BindingID != bindingID || (operation.Operation != "bind" && operation.Operation != "unbind") {
+			continue
+		}
+		if latest == nil || operation.StartedAt.After(latest.StartedAt) ||
+			(operation.StartedAt.Equal(latest.StartedAt) && latest.Result == string(brokerapi.InProgress)) {
+			latest = operation
+		}
+	}
+	return latest, nil
+}
+
+// isBindingOperationInProgress returns false if the operation recorded in progress has run longer than it may
+func (b *Broker) isBindingOperationInProgress(operation *InstanceOperation) bool {
+	if operation.Result != string(brokerapi.InProgress) {
+		return false
+	}
+	return b.clock.Since(operation.StartedAt) <= b.bindingOperationExpiry(operation.Operation)
+}
+
+// bindingOperationExpiry returns how long an asynchronous bind or unbind may be in progress
+func (b *Broker) bindingOperationExpiry(operation string) time.Duration {
+	expiry := b.config.timeouts.Bind
+	if operation == "unbind" {
+		expiry = b.config.timeouts.Unbind
+	}
+	if expiry <= 0 {
+		expiry = defaultAsyncBindingExpiry
+	}
+	return expiry
+}
+
+// rebuildBinding returns the response of the existing binding of a file share of the broker from its stored bind
+// options, e.g. for the platform which fetches the binding after an asynchronous bind
+func (b *Broker) rebuildBinding(instanceID, bindingID string, bindingDetails BindingDetails) (brokerapi.Binding, error) {
+	details := bindingDetails.BindDetails
+	rawParameters, err := json.Marshal(bindingDetails.BindOptions)
+	if err != nil {
+		return brokerapi.Binding{}, err
+	}
+	details.RawParameters = rawParameters
+	return b.bind(context.WithValue(context.Background(), bindingRebuildKey{}, true), instanceID, bindingID, details)
+}
@@ -0,0 +1,113 @@
+package azurefilebroker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	resty "gopkg.in/resty.v0"
+)
+
+// The types of the audit events follow the naming of the Cloud Controller audit events
+const (
+	AuditEventServiceInstanceCreate = "audit.service_instance.create"
+	AuditEventServiceInstanceDelete = "audit.service_instance.delete"
+	AuditEventServiceBindingCreate  = "audit.service_binding.create"
+	AuditEventServiceBindingDelete  = "audit.service_binding.delete"
+	// AuditEventAuthLockout is emitted when a source is locked out after repeated failed authentications
+	AuditEventAuthLockout = "audit.broker.authentication_lockout"
+)
+
+// AuditEvent records a lifecycle operation and the Azure resources which it touched
+type AuditEvent struct {
+	Type              string    `json:"type"`
+	Timestamp         time.Time `json:"timestamp"`
+	BrokerInstanceID  string    `json:"broker_instance_id,omitempty"`
+	ServiceInstanceID string    `json:"service_instance_id"`
+	BindingID         string    `json:"service_binding_id,omitempty"`
+	OrganizationGUID  string    `json:"organization_guid,omitempty"`
+	SpaceGUID         string    `json:"space_guid,omitempty"`
+	AppGUID           string    `json:"app_guid,omitempty"`
+	ResourceIDs       []string  `json:"resource_ids,omitempty"`
+	// Inconsistency describes the state of the store which the operation found and tolerated, if any
+	Inconsistency string `json:"inconsistency,omitempty"`
+	// Source is the address of the client of a request which is not about a service instance
+	Source string `json:"source,omitempty"`
+}
+
+//go:generate counterfeiter -o ../azurefilebrokerfakes/fake_audit_event_emitter.go . AuditEventEmitter
+type AuditEventEmitter interface {
+	Emit(event AuditEvent) error
+}
+
+type webhookAuditEventEmitter struct {
+	url   string
+	token string
+}
+
+// NewWebhookAuditEventEmitter returns an emitter which POSTs every event as JSON to the url. The token is sent as a
+// bearer token if it is not empty.
+func NewWebhookAuditEventEmitter(url, token string) AuditEventEmitter {
+	return &webhookAuditEventEmitter{url: url, token: token}
+}
+
+func (e *webhookAuditEventEmitter) Emit(event AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	request := resty.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(body)
+	if e.token != "" {
+		request.SetAuthToken(e.token)
+	}
+	resp, err := request.Post(e.url)
+	if err != nil {
+		return err
+	}
+	if statusCode := resp.StatusCode(); statusCode < http.StatusOK || statusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("Error Code: %d, %v", statusCode, resp)
+	}
+	return nil
+}
+
+// SetAuditEventEmitter enables the audit events of the lifecycle operations
+func (b *Broker) SetAuditEventEmitter(emitter AuditEventEmitter) {
+	b.auditEvents = emitter
+}
+
+// emitAuditEvent emits the event of a successful operation. A failure is only logged so that the operation itself is
+// not failed by the audit.
+func (b *Broker) emitAuditEvent(logger lager.Logger, event AuditEvent) {
+	if b.auditEvents == nil {
+		return
+	}
+	event.Timestamp = b.clock.Now().UTC()
+	event.BrokerInstanceID = b.config.cloud.Azure.BrokerInstanceID
+	if err := b.auditEvents.Emit(event); err != nil {
+		logger.Error("emit-audit-event", err, lager.Data{"type": event.Type})
+		return
+	}
+	logger.Info("audit-event-emitted", lager.Data{"type": event.Type})
+}
+
+// auditResourceIDs returns the Azure resource IDs of the storage account of the instance and of the file share if
+// it is given. Preexisting shares are not Azure resources.
+func auditResourceIDs(serviceInstance *ServiceInstance, fileShareName string) []string {
+	if serviceInstance.IsPreexisting {
+		return nil
+	}
+	storageAccountID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s/%s",
+		serviceInstance.SubscriptionID,
+		serviceInstance.ResourceGroupName,
+		restAPIProviderStorage,
+		restAPIStorageAccounts,
+		serviceInstance.TargetName)
+	if fileShareName == "" {
+		return []string{storageAccountID}
+	}
+	return []string{storageAccountID, fmt.Sprintf("%s/fileServices/default/shares/%s", storageAccountID, fileShareName)}
+}
@@ -0,0 +1,301 @@
+package azurefilebroker
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// The metrics of the failed authentications
+const (
+	metricAuthFailures         = "azurefilebroker_auth_failures_total"
+	metricAuthLockouts         = "azurefilebroker_auth_lockouts_total"
+	metricAuthRejectedRequests = "azurefilebroker_auth_rejected_requests_total"
+	metricAuthLockedOutSources = "azurefilebroker_auth_locked_out_sources"
+
+	// The most sources whose failures are remembered. The oldest one is forgotten first.
+	maxAuthFailureSources = 10000
+)
+
+// AuthFailureConfig is the backoff of the sources which repeatedly fail to authenticate. A source is locked out for
+// Backoff after Threshold consecutive failures, and for twice as long after every further failure up to MaxBackoff.
+// X-Forwarded-For is only trusted in the requests which come from TrustedProxies, e.g. the routers of the platform.
+type AuthFailureConfig struct {
+	Threshold      int
+	Backoff        time.Duration
+	MaxBackoff     time.Duration
+	TrustedProxies []*net.IPNet
+
+	invalidProxies []string
+}
+
+// NewAuthFailureConfig returns the config of the lockouts. trustedProxies is a comma separated list of IP addresses
+// and CIDR ranges.
+func NewAuthFailureConfig(threshold int, backoff, maxBackoff time.Duration, trustedProxies string) *AuthFailureConfig {
+	myConf := new(AuthFailureConfig)
+
+	myConf.Threshold = threshold
+	myConf.Backoff = backoff
+	myConf.MaxBackoff = maxBackoff
+	for _, proxy := range strings.Split(trustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if network, ok := parseTrustedProxy(proxy); ok {
+			myConf.TrustedProxies = append(myConf.TrustedProxies, network)
+		} else {
+			myConf.invalidProxies = append(myConf.invalidProxies, proxy)
+		}
+	}
+
+	return myConf
+}
+
+// parseTrustedProxy parses a CIDR range, or an IP address as the range of that single address
+func parseTrustedProxy(proxy string) (*net.IPNet, bool) {
+	if strings.Contains(proxy, "/") {
+		_, network, err := net.ParseCIDR(proxy)
+		return network, err == nil
+	}
+	ip := net.ParseIP(proxy)
+	if ip == nil {
+		return nil, false
+	}
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 8 * net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, true
+}
+
+// IsEnabled returns true if the sources are locked out after repeated failures
+func (config *AuthFailureConfig) IsEnabled() bool {
+	return config.Threshold > 0
+}
+
+func (config *AuthFailureConfig) Validate() error {
+	if len(config.invalidProxies) > 0 {
+		return fmt.Errorf("Invalid addresses in authTrustedProxies: %s. Expected IP addresses or CIDR ranges", strings.Join(config.invalidProxies, ", "))
+	}
+	if !config.IsEnabled() {
+		return nil
+	}
+	if config.Backoff <= 0 {
+		return fmt.Errorf("authFailureBackoff must be positive when authFailureThreshold is set")
+	}
+	if config.MaxBackoff < config.Backoff {
+		return fmt.Errorf("authFailureMaxBackoff must not be shorter than authFailureBackoff")
+	}
+	return nil
+}
+
+// authFailures are the consecutive failed authentications of a source
+type authFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type authFailureGuard struct {
+	broker      *Broker
+	credentials brokerapi.BrokerCredentials
+	config      AuthFailureConfig
+	next        http.Handler
+
+	mutex   sync.Mutex
+	sources map[string]*authFailures
+}
+
+// AuthFailureGuard returns a handler which locks out the sources of the requests that repeatedly fail the basic
+// authentication of the broker, so that its credentials cannot be guessed. The requests of a locked out source get 429
+// with Retry-After before their credentials are checked. The other requests go to next, which authenticates them
+// again. Every failure is logged and a lockout is sent to the audit events.
+func (b *Broker) AuthFailureGuard(credentials brokerapi.BrokerCredentials, config *AuthFailureConfig, next http.Handler) http.Handler {
+	if !config.IsEnabled() {
+		return next
+	}
+	return &authFailureGuard{
+		broker:      b,
+		credentials: credentials,
+		config:      *config,
+		next:        next,
+		sources:     map[string]*authFailures{},
+	}
+}
+
+func (g *authFailureGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	source := authFailureSource(r, g.config.TrustedProxies)
+	now := g.broker.clock.Now()
+
+	if retryAfter := g.lockedOutFor(source, now); retryAfter > 0 {
+		g.incrementCounter(metricAuthRejectedRequests)
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		writeAdminResponse(w, http.StatusTooManyRequests, adminErrorResponse{
+			Error:       "TooManyRequests",
+			Description: fmt.Sprintf("Too many failed authentications: retry after %d seconds", seconds),
+		})
+		return
+	}
+
+	username, password, ok := r.BasicAuth()
+	if ok &&
+		subtle.ConstantTimeCompare([]byte(username), []byte(g.credentials.Username)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(g.credentials.Password)) == 1 {
+		g.succeeded(source)
+		g.next.ServeHTTP(w, r)
+		return
+	}
+
+	failures, lockedUntil := g.failed(source, now)
+	logger := g.broker.logger.Session("auth-failure-guard")
+	// The password is never logged
+	logger.Info("authentication-failed", lager.Data{
+		"source":   source,
+		"username": username,
+		"method":   r.Method,
+		"path":     requestPath(r),
+		"failures": failures,
+	})
+	g.incrementCounter(metricAuthFailures)
+	if !lockedUntil.IsZero() {
+		logger.Info("source-locked-out", lager.Data{"source": source, "failures": failures, "lockedUntil": lockedUntil})
+		g.incrementCounter(metricAuthLockouts)
+		g.broker.emitAuditEvent(logger, AuditEvent{Type: AuditEventAuthLockout, Source: source})
+	}
+	g.next.ServeHTTP(w, r)
+}
+
+// lockedOutFor returns how long the source is still locked out, which is 0 if it is not
+func (g *authFailureGuard) lockedOutFor(source string, now time.Time) time.Duration {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	failures, ok := g.sources[source]
+	if !ok || !now.Before(failures.lockedUntil) {
+		return 0
+	}
+	return failures.lockedUntil.Sub(now)
+}
+
+func (g *authFailureGuard) succeeded(source string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if _, ok := g.sources[source]; ok {
+		delete(g.sources, source)
+		g.setLockedOutGauge(g.broker.clock.Now())
+	}
+}
+
+// failed records a failure of the source and returns its count and when the lockout which it starts ends, which is
+// zero if it starts none
+func (g *authFailureGuard) failed(source string, now time.Time) (int, time.Time) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	failures, ok := g.sources[source]
+	if ok && now.Sub(failures.lastFailure) > g.config.MaxBackoff && !now.Before(failures.lockedUntil) {
+		// The source has not failed for longer than the longest lockout, so it starts over
+		ok = false
+	}
+	if !ok {
+		g.forgetOldestSource()
+		failures = &authFailures{}
+		g.sources[source] = failures
+	}
+	failures.count++
+	failures.lastFailure = now
+	if failures.count < g.config.Threshold {
+		return failures.count, time.Time{}
+	}
+
+	backoff := g.config.MaxBackoff
+	if exponent := uint(failures.count - g.config.Threshold); exponent < 32 {
+		if doubled := g.config.Backoff << exponent; doubled > 0 && doubled < backoff {
+			backoff = doubled
+		}
+	}
+	failures.lockedUntil = now.Add(backoff)
+	g.setLockedOutGauge(now)
+	return failures.count, failures.lockedUntil
+}
+
+// forgetOldestSource makes room for a new source. The caller must hold the mutex.
+func (g *authFailureGuard) forgetOldestSource() {
+	if len(g.sources) < maxAuthFailureSources {
+		return
+	}
+	oldest := ""
+	for source, failures := range g.sources {
+		if oldest == "" || failures.lastFailure.Before(g.sources[oldest].lastFailure) {
+			oldest = source
+		}
+	}
+	delete(g.sources, oldest)
+}
+
+// setLockedOutGauge sets the number of the sources which are locked out. The caller must hold the mutex.
+func (g *authFailureGuard) setLockedOutGauge(now time.Time) {
+	lockedOut := 0
+	for _, failures := range g.sources {
+		if now.Before(failures.lockedUntil) {
+			lockedOut++
+		}
+	}
+	if g.broker.metrics != nil {
+		g.broker.metrics.SetGauge(metricAuthLockedOutSources, nil, float64(lockedOut))
+	}
+}
+
+func (g *authFailureGuard) incrementCounter(name string) {
+	if g.broker.metrics != nil {
+		g.broker.metrics.IncrementCounter(name, nil)
+	}
+}
+
+// authFailureSource returns the address which the request comes from. It is the address of the connection, unless the
+// connection comes from a trusted proxy. Then it is the last address of X-Forwarded-For which is not a trusted proxy,
+// because every proxy appends the address of its client, while the addresses before them are sent by the client and
+// may be forged.
+func authFailureSource(r *http.Request, trustedProxies []*net.IPNet) string {
+	source, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		source = r.RemoteAddr
+	}
+	if !isTrustedProxy(source, trustedProxies) {
+		return source
+	}
+	addresses := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(addresses) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(addresses[i])
+		if address == "" {
+			break
+		}
+		source = address
+		if !isTrustedProxy(address, trustedProxies) {
+			break
+		}
+	}
+	return source
+}
+
+func isTrustedProxy(address string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
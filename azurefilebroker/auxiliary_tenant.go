@@ -0,0 +1,67 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// ParseAuxiliaryTenantIDs parses a comma separated list of the tenants which have granted access to the service
+// principal of the broker
+func ParseAuxiliaryTenantIDs(tenantIDs string) []string {
+	auxiliaryTenantIDs := []string{}
+	for _, tenantID := range strings.Split(tenantIDs, ",") {
+		if tenantID = strings.TrimSpace(tenantID); tenantID != "" {
+			auxiliaryTenantIDs = append(auxiliaryTenantIDs, tenantID)
+		}
+	}
+	return auxiliaryTenantIDs
+}
+
+// validateAuxiliaryTenantIDs checks that the auxiliary tenants are other tenants than the tenant of the broker
+func (config *AzureConfig) validateAuxiliaryTenantIDs() error {
+	for _, tenantID := range config.AuxiliaryTenantIDs {
+		if strings.EqualFold(tenantID, config.TenanID) {
+			return fmt.Errorf("The auxiliary tenant %q must not be the tenant of the broker", tenantID)
+		}
+		if strings.ContainsAny(tenantID, "/?#") {
+			return fmt.Errorf("Invalid auxiliary tenant %q: expected a tenant ID or a domain name", tenantID)
+		}
+	}
+	return nil
+}
+
+// isAuxiliaryTenant returns true if the tenant has granted access to the service principal of the broker
+func (config *AzureConfig) isAuxiliaryTenant(tenantID string) bool {
+	for _, auxiliaryTenantID := range config.AuxiliaryTenantIDs {
+		if strings.EqualFold(auxiliaryTenantID, tenantID) {
+			return true
+		}
+	}
+	return false
+}
+
+// auxiliaryAuthorizer authorizes the requests of the SDK with the token of the tenant of the storage account and sends
+// the token of the tenant of the broker in the x-ms-authorization-auxiliary header
+type auxiliaryAuthorizer struct {
+	autorest.Authorizer
+	token *adal.ServicePrincipalToken
+}
+
+func (a *auxiliaryAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := a.Authorizer.WithAuthorization()(p).Prepare(r)
+			if err != nil {
+				return r, err
+			}
+			if err := a.token.EnsureFresh(); err != nil {
+				return r, autorest.NewErrorWithError(err, "azurefilebroker.auxiliaryAuthorizer", "WithAuthorization", nil, "Failed to refresh the auxiliary token for the request to %s", r.URL)
+			}
+			return autorest.Prepare(r, autorest.WithHeader(auxiliaryAuthorizationHeader, "Bearer "+a.token.OAuthToken()))
+		})
+	}
+}
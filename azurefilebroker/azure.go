@@ -1,10 +1,12 @@
 package azurefilebroker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -21,23 +23,65 @@ import (
 
 const (
 	creator                     = "creator"
+	brokerInstanceIDTag         = "broker-instance-id"
+	defaultCreatorTagValue      = "azurefilebroker"
+	maxTagValueLength           = 256
 	resourceNotFound            = "StatusCode=404"
 	fileRequestTimeoutInSeconds = 60
 )
 
 const (
-	userAgent              = "azurefilebroker"
-	restAPIProviderStorage = "Microsoft.Storage"
-	restAPIStorageAccounts = "storageAccounts"
-	restAPIStorageKind     = "Storage"
-	contentTypeJSON        = "application/json"
-	contentTypeWWW         = "application/x-www-form-urlencoded"
+	defaultUserAgent            = "azurefilebroker"
+	restAPIProviderStorage      = "Microsoft.Storage"
+	restAPIStorageAccounts      = "storageAccounts"
+	restAPIStorageKind          = "Storage"
+	restAPIStorageV2Kind        = "StorageV2"
+	restAPIFileStorageKind      = "FileStorage"
+	restAPIUsageStorageAccounts = "StorageAccounts"
+	contentTypeJSON             = "application/json"
+	contentTypeWWW              = "application/x-www-form-urlencoded"
+	// auxiliaryAuthorizationHeader carries the tokens of the tenants other than the tenant of the subscription in a
+	// cross-tenant request to Azure Resource Manager
+	// Reference: https://docs.microsoft.com/en-us/azure/azure-resource-manager/management/authenticate-multi-tenant
+	auxiliaryAuthorizationHeader = "x-ms-authorization-auxiliary"
 )
 
 var (
 	restRetryCodes = []int{408, 429, 500, 502, 503, 504}
 )
 
+// The zone-redundant SKUs which the SDK of the broker does not define
+const (
+	skuStandardGZRS   storage.SkuName = "Standard_GZRS"
+	skuStandardRAGZRS storage.SkuName = "Standard_RAGZRS"
+	skuPremiumZRS     storage.SkuName = "Premium_ZRS"
+)
+
+// supportedSkuNames are the SKUs of the storage accounts created by the broker
+var supportedSkuNames = []storage.SkuName{storage.StandardGRS, storage.StandardLRS, storage.StandardRAGRS, storage.StandardZRS, skuStandardGZRS, skuStandardRAGZRS, skuPremiumZRS}
+
+// isZoneRedundantSku returns true if the SKU spreads the storage account over the availability zones of its region.
+// These SKUs are only available in some regions and need a newer kind of storage account.
+func isZoneRedundantSku(skuName storage.SkuName) bool {
+	switch skuName {
+	case storage.StandardZRS, skuStandardGZRS, skuStandardRAGZRS, skuPremiumZRS:
+		return true
+	}
+	return false
+}
+
+// storageAccountKind returns the kind of the storage account of the SKU. The premium file shares need a FileStorage
+// account and the other zone-redundant SKUs a general-purpose v2 account.
+func storageAccountKind(skuName storage.SkuName) string {
+	switch {
+	case skuName == skuPremiumZRS:
+		return restAPIFileStorageKind
+	case isZoneRedundantSku(skuName):
+		return restAPIStorageV2Kind
+	}
+	return restAPIStorageKind
+}
+
 const (
 	AzureCloud        = "AzureCloud"
 	AzureChinaCloud   = "AzureChinaCloud"
@@ -50,6 +94,80 @@ type APIVersions struct {
 	StorageForREST  string
 	StorageForSDK   string
 	ActiveDirectory string
+	ResourceManager string
+	Authorization   string
+	FileShares      string
+	// ShareAccessPolicies is the version of the API of the access policies and the SAS tokens of file shares. They are
+	// not supported when it is empty.
+	ShareAccessPolicies string
+	// ZoneRedundantStorage is the version of the API which creates the storage accounts of zone-redundant SKUs and lists
+	// the regions of the SKUs. They are not supported when it is empty.
+	ZoneRedundantStorage string
+	// RecoveryServices is the version of the API of Azure Backup which protects the file shares. The backup is not
+	// supported when it is empty.
+	RecoveryServices string
+	// MetricAlerts is the version of the API of the metric alerts of Azure Monitor on the created storage accounts. They
+	// are not supported when it is empty.
+	MetricAlerts string
+	// DiagnosticSettings is the version of the API of the diagnostic settings of Azure Monitor which send the access logs
+	// of the file shares. They are not supported when it is empty.
+	DiagnosticSettings string
+}
+
+// The names of the API versions which can be overridden by the configuration
+var apiVersionNames = []string{"StorageForREST", "StorageForSDK", "ActiveDirectory", "ResourceManager", "Authorization", "FileShares", "ShareAccessPolicies", "ZoneRedundantStorage", "RecoveryServices", "MetricAlerts", "DiagnosticSettings"}
+
+// set replaces the version of the API by its name and returns false if the name is unknown
+func (versions *APIVersions) set(name, version string) bool {
+	switch name {
+	case "StorageForREST":
+		versions.StorageForREST = version
+	case "StorageForSDK":
+		versions.StorageForSDK = version
+	case "ActiveDirectory":
+		versions.ActiveDirectory = version
+	case "ResourceManager":
+		versions.ResourceManager = version
+	case "Authorization":
+		versions.Authorization = version
+	case "FileShares":
+		versions.FileShares = version
+	case "ShareAccessPolicies":
+		versions.ShareAccessPolicies = version
+	case "ZoneRedundantStorage":
+		versions.ZoneRedundantStorage = version
+	case "RecoveryServices":
+		versions.RecoveryServices = version
+	case "MetricAlerts":
+		versions.MetricAlerts = version
+	case "DiagnosticSettings":
+		versions.DiagnosticSettings = version
+	default:
+		return false
+	}
+	return true
+}
+
+// BrokerVersion is the version of the broker in the User-Agent header. It is set at build time, e.g. with
+// -ldflags "-X code.cloudfoundry.org/azurefilebroker/azurefilebroker.BrokerVersion=1.2.0"
+var BrokerVersion = "dev"
+
+// GetAPIVersions returns the API versions of the environment with the overrides of the configuration
+func (config *AzureConfig) GetAPIVersions() APIVersions {
+	versions := Environments[config.Environment].APIVersions
+	for name, version := range config.APIVersionOverrides {
+		versions.set(name, version)
+	}
+	return versions
+}
+
+// GetUserAgent returns the User-Agent header of the requests to Azure, e.g. azurefilebroker/1.2.0 (broker-1)
+func (config *AzureConfig) GetUserAgent() string {
+	userAgent := fmt.Sprintf("%s/%s", config.UserAgent, BrokerVersion)
+	if config.BrokerInstanceID != "" {
+		userAgent = fmt.Sprintf("%s (%s)", userAgent, config.BrokerInstanceID)
+	}
+	return userAgent
 }
 
 type Environment struct {
@@ -63,43 +181,83 @@ var Environments = map[string]Environment{
 		ResourceManagerEndpointURL: "https://management.azure.com/",
 		ActiveDirectoryEndpointURL: "https://login.microsoftonline.com",
 		APIVersions: APIVersions{
-			StorageForREST:  "2016-12-01",
-			StorageForSDK:   "2016-05-31",
-			ActiveDirectory: "2015-06-15",
+			StorageForREST:       "2016-12-01",
+			StorageForSDK:        "2016-05-31",
+			ActiveDirectory:      "2015-06-15",
+			ResourceManager:      "2016-02-01",
+			Authorization:        "2015-07-01",
+			FileShares:           "2019-06-01",
+			ShareAccessPolicies:  "2021-04-01",
+			ZoneRedundantStorage: "2019-06-01",
+			RecoveryServices:     "2019-05-13",
+			MetricAlerts:         "2018-03-01",
+			DiagnosticSettings:   "2021-05-01-preview",
 		},
 	},
 	AzureChinaCloud: Environment{
 		ResourceManagerEndpointURL: "https://management.chinacloudapi.cn/",
 		ActiveDirectoryEndpointURL: "https://login.chinacloudapi.cn",
 		APIVersions: APIVersions{
-			StorageForREST:  "2016-12-01",
-			StorageForSDK:   "2016-05-31",
-			ActiveDirectory: "2015-06-15",
+			StorageForREST:       "2016-12-01",
+			StorageForSDK:        "2016-05-31",
+			ActiveDirectory:      "2015-06-15",
+			ResourceManager:      "2016-02-01",
+			Authorization:        "2015-07-01",
+			FileShares:           "2019-06-01",
+			ShareAccessPolicies:  "2021-04-01",
+			ZoneRedundantStorage: "2019-06-01",
+			RecoveryServices:     "2019-05-13",
+			MetricAlerts:         "2018-03-01",
+			DiagnosticSettings:   "2021-05-01-preview",
 		},
 	},
 	AzureUSGovernment: Environment{
 		ResourceManagerEndpointURL: "https://management.usgovcloudapi.net/",
 		ActiveDirectoryEndpointURL: "https://login.microsoftonline.com",
 		APIVersions: APIVersions{
-			StorageForREST:  "2016-12-01",
-			StorageForSDK:   "2016-05-31",
-			ActiveDirectory: "2015-06-15",
+			StorageForREST:       "2016-12-01",
+			StorageForSDK:        "2016-05-31",
+			ActiveDirectory:      "2015-06-15",
+			ResourceManager:      "2016-02-01",
+			Authorization:        "2015-07-01",
+			FileShares:           "2019-06-01",
+			ShareAccessPolicies:  "2021-04-01",
+			ZoneRedundantStorage: "2019-06-01",
+			RecoveryServices:     "2019-05-13",
+			MetricAlerts:         "2018-03-01",
+			DiagnosticSettings:   "2021-05-01-preview",
 		},
 	},
 	AzureGermanCloud: Environment{
 		ResourceManagerEndpointURL: "https://management.microsoftazure.de/",
 		ActiveDirectoryEndpointURL: "https://login.microsoftonline.de",
 		APIVersions: APIVersions{
-			StorageForREST:  "2016-12-01",
-			StorageForSDK:   "2016-05-31",
-			ActiveDirectory: "2015-06-15",
+			StorageForREST:       "2016-12-01",
+			StorageForSDK:        "2016-05-31",
+			ActiveDirectory:      "2015-06-15",
+			ResourceManager:      "2016-02-01",
+			Authorization:        "2015-07-01",
+			FileShares:           "2019-06-01",
+			ShareAccessPolicies:  "2021-04-01",
+			ZoneRedundantStorage: "2019-06-01",
+			RecoveryServices:     "2019-05-13",
+			MetricAlerts:         "2018-03-01",
+			DiagnosticSettings:   "2021-05-01-preview",
 		},
 	},
 	AzureStack: Environment{
 		APIVersions: APIVersions{
-			StorageForREST:  "2016-12-01",
-			StorageForSDK:   "2016-05-31",
-			ActiveDirectory: "2015-06-15",
+			StorageForREST:       "2016-12-01",
+			StorageForSDK:        "2016-05-31",
+			ActiveDirectory:      "2015-06-15",
+			ResourceManager:      "2016-02-01",
+			Authorization:        "2015-07-01",
+			FileShares:           "2019-06-01",
+			ShareAccessPolicies:  "",
+			ZoneRedundantStorage: "",
+			RecoveryServices:     "",
+			MetricAlerts:         "",
+			DiagnosticSettings:   "",
 		},
 	},
 }
@@ -109,16 +267,51 @@ type AzureStorageAccountSDKClient interface {
 	Exists() (bool, error)
 	GetAccessKey() (string, error)
 	DeleteStorageAccount() error
+	SetStorageAccountTags(tags map[string]string) error
 	HasFileShare(fileShareName string) (bool, error)
+	ListFileShares() (map[string]string, error)
 	CreateFileShare(fileShareName string) error
 	DeleteFileShare(fileShareName string) error
+	SetFileShareMetadata(fileShareName, key, value string) error
+	CreateDirectories(fileShareName string, paths []string) error
 	GetShareURL(fileShareName string) (string, error)
+	VerifyShareSAS(fileShareName, sasToken string) error
+	ListFilesAndDirectories(fileShareName string) ([]string, []string, error)
+	ListDirectory(fileShareName, directoryPath string, maxResults int) ([]DirectoryEntry, bool, error)
+	CopyFile(fileShareName, filePath, sourceURL string) error
+	GetFileCopyStatus(fileShareName, filePath, sasToken string) (string, error)
 }
 
 //go:generate counterfeiter -o ../azurefilebrokerfakes/fake_azure_storage_account_rest_client.go . AzureStorageAccountRESTClient
 type AzureStorageAccountRESTClient interface {
 	CreateStorageAccount() (string, error)
+	DeleteStorageAccount() (string, error)
+	UpdateStorageAccount(skuName storage.SkuName, useHTTPS bool) error
 	CheckCompletion(asyncURL string) (bool, error)
+	SubscriptionExists() (bool, error)
+	GetStorageAccountUsage() (int, int, error)
+	IsSkuAvailable(skuName storage.SkuName, location string) (bool, error)
+	ListPermissions() ([]Permission, error)
+	ResourceGroupExists() (bool, error)
+	GetFileShareStats(fileShareName string) (ShareStats, error)
+	GetFileShareAccessPolicies(fileShareName string) ([]ShareAccessPolicy, error)
+	SetFileShareAccessPolicies(fileShareName string, policies []ShareAccessPolicy) error
+	GetFileShareSAS(fileShareName, policyID string) (string, error)
+	GetFileShareReadSAS(fileShareName string, expiry time.Time) (string, error)
+	RefreshBackupContainers(vaultID string) (string, error)
+	RegisterBackupContainer(vaultID string) (string, error)
+	InquireBackupItems(vaultID string) (string, error)
+	ProtectFileShare(vaultID, policyName, fileShareName string) (string, error)
+	CreateMetricAlert(alertName string, alert StorageAccountAlert, actionGroupID string) error
+	DeleteMetricAlert(alertName string) error
+	CreateDiagnosticSetting(settingName string, destination AccessLogDestination) error
+	DeleteDiagnosticSetting(settingName string) error
+}
+
+// Permission is a set of actions which the service principal of the broker is allowed to perform
+type Permission struct {
+	Actions    []string `json:"actions"`
+	NotActions []string `json:"notActions"`
 }
 
 type StorageAccount struct {
@@ -133,7 +326,24 @@ type StorageAccount struct {
 	AccessKey               string
 	BaseURL                 string
 	OperationURL            string
+	ServicePrincipal        *ServicePrincipal // The service principal of the broker is used when it is nil
 	SDKClient               AzureStorageAccountSDKClient
+	// Context stops the calls of the clients of the storage account when it is done. It is never done when nil.
+	Context context.Context
+	// Metrics counts the failed requests of the clients of the storage account. Nothing is counted when nil.
+	Metrics Metrics
+	// Throttle slows down the requests to Azure Resource Manager of a background storage account when its subscription
+	// has few requests left. Nothing is slowed down when nil.
+	Throttle   *AzureThrottle
+	Background bool
+	// Health records the outcomes of the requests to Azure Resource Manager. Nothing is recorded when nil.
+	Health *AzureHealth
+	// Retrier tries the requests of the REST client again when they fail with a network error or a transient status
+	// code. They are tried once when nil.
+	Retrier *Retrier
+	// SubnetIDs are the only subnets which are allowed to access a created storage account. All networks are allowed
+	// when empty.
+	SubnetIDs []string
 }
 
 func NewStorageAccount(logger lager.Logger, configuration Configuration) (*StorageAccount, error) {
@@ -167,8 +377,14 @@ func NewStorageAccount(logger lager.Logger, configuration Configuration) (*Stora
 	}
 	if configuration.SkuName != "" {
 		storageAccount.SkuName = storage.SkuName(configuration.SkuName)
-		if storageAccount.SkuName != storage.StandardGRS && storageAccount.SkuName != storage.StandardLRS && storageAccount.SkuName != storage.StandardRAGRS {
-			err := fmt.Errorf("The SkuName %q to create the storage account is invalid. It must be Standard_GRS, Standard_LRS or Standard_RAGRS", configuration.SkuName)
+		supported := false
+		names := []string{}
+		for _, skuName := range supportedSkuNames {
+			supported = supported || skuName == storageAccount.SkuName
+			names = append(names, string(skuName))
+		}
+		if !supported {
+			err := fmt.Errorf("The SkuName %q to create the storage account is invalid. It must be one of %s", configuration.SkuName, strings.Join(names, ", "))
 			logger.Error("check-sku-name", err)
 			return nil, err
 		}
@@ -176,6 +392,12 @@ func NewStorageAccount(logger lager.Logger, configuration Configuration) (*Stora
 	if configuration.Location != "" {
 		storageAccount.Location = configuration.Location
 	}
+	servicePrincipal, err := configuration.servicePrincipal()
+	if err != nil {
+		logger.Error("check-service-principal", err)
+		return nil, err
+	}
+	storageAccount.ServicePrincipal = servicePrincipal
 
 	return &storageAccount, nil
 }
@@ -190,6 +412,13 @@ type AzureStorageSDKClient struct {
 
 func NewAzureStorageAccountSDKClient(logger lager.Logger, cloudConfig *CloudConfig, storageAccount *StorageAccount) (AzureStorageAccountSDKClient, error) {
 	logger = logger.Session("storage-sdk-client").WithData(lager.Data{"ResourceGroupName": storageAccount.ResourceGroupName, "StorageAccountName": storageAccount.StorageAccountName})
+	cloudConfig, err := cloudConfig.withServicePrincipal(storageAccount.ServicePrincipal)
+	if err != nil {
+		return nil, err
+	}
+	if cloudConfig.backend != nil {
+		return cloudConfig.backend.NewSDKClient(logger, cloudConfig, storageAccount)
+	}
 	connection := AzureStorageSDKClient{
 		logger:                   logger,
 		cloudConfig:              cloudConfig,
@@ -237,6 +466,28 @@ func (c *AzureStorageSDKClient) initManagementClient() error {
 	client := storage.NewAccountsClientWithBaseURI(resourceManagerEndpointURL, c.StorageAccount.SubscriptionID)
 	c.storageManagementClient = &client
 	c.storageManagementClient.Authorizer = autorest.NewBearerAuthorizer(spt)
+	if auxiliaryTenantID := c.cloudConfig.Azure.auxiliaryTokenTenantID; auxiliaryTenantID != "" {
+		auxiliaryOAuthConfig, err := adal.NewOAuthConfig(Environments[environment].ActiveDirectoryEndpointURL, auxiliaryTenantID)
+		if err != nil {
+			logger.Error("new-auxiliary-o-auth-config", err, lager.Data{"AuxiliaryTenantID": auxiliaryTenantID})
+			return fmt.Errorf("Error in initManagementClient: %v", err)
+		}
+		auxiliarySPT, err := adal.NewServicePrincipalToken(*auxiliaryOAuthConfig, clientID, clientSecret, resourceManagerEndpointURL)
+		if err != nil {
+			logger.Error("new-auxiliary-service-principal-token", err, lager.Data{"AuxiliaryTenantID": auxiliaryTenantID, "ClientID": clientID})
+			return fmt.Errorf("Error in initManagementClient: %v", err)
+		}
+		c.storageManagementClient.Authorizer = &auxiliaryAuthorizer{Authorizer: c.storageManagementClient.Authorizer, token: auxiliarySPT}
+	}
+	c.storageManagementClient.AddToUserAgent(c.cloudConfig.Azure.GetUserAgent())
+	if c.StorageAccount.Throttle != nil {
+		c.storageManagementClient.RequestInspector, c.storageManagementClient.ResponseInspector = c.StorageAccount.Throttle.inspectors(c.logger, c.StorageAccount)
+	}
+	if retrier := c.StorageAccount.Retrier; retrier != nil {
+		// The SDK retries the status codes 5xx itself with a fixed delay on the real clock
+		c.storageManagementClient.RetryAttempts = retrier.policy.MaxAttempts
+		c.storageManagementClient.RetryDuration = retrier.policy.BaseDelay
+	}
 	return nil
 }
 
@@ -245,6 +496,10 @@ func (c *AzureStorageSDKClient) Exists() (bool, error) {
 	logger.Info("start")
 	defer logger.Info("end")
 
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return false, err
+	}
+
 	if _, err := c.getStorageAccountProperties(); err != nil {
 		if strings.Contains(err.Error(), resourceNotFound) {
 			err = nil
@@ -270,6 +525,10 @@ func (c *AzureStorageSDKClient) getBaseURL() error {
 		logger.Error("get-storage-account-properties", err)
 		return err
 	}
+	if c.cloudConfig.Azure.FileEndpointSuffix != "" {
+		c.StorageAccount.BaseURL = c.cloudConfig.Azure.FileEndpointSuffix
+		return nil
+	}
 	c.StorageAccount.BaseURL, err = parseBaseURL(*(properties.PrimaryEndpoints).File)
 	if err != nil {
 		logger.Error("parse-base-url", err)
@@ -285,7 +544,10 @@ func (c *AzureStorageSDKClient) getStorageAccountProperties() (storage.Account,
 	defer logger.Info("end")
 
 	result, err := c.storageManagementClient.GetProperties(c.StorageAccount.ResourceGroupName, c.StorageAccount.StorageAccountName)
-	return result, err
+	if err != nil && !strings.Contains(err.Error(), resourceNotFound) {
+		recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "get-storage-account-properties")
+	}
+	return result, azureSDKError(err)
 }
 
 func parseBaseURL(fileEndpoint string) (string, error) {
@@ -302,10 +564,16 @@ func (c *AzureStorageSDKClient) GetAccessKey() (string, error) {
 	logger.Info("start")
 	defer logger.Info("end")
 
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return "", err
+	}
+
 	if c.StorageAccount.AccessKey == "" {
 		result, err := c.storageManagementClient.ListKeys(c.StorageAccount.ResourceGroupName, c.StorageAccount.StorageAccountName)
 		if err != nil {
+			err = azureSDKError(err)
 			logger.Error("list-keys", err)
+			recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "list-keys")
 			return "", fmt.Errorf("Failed to list keys: %v", err)
 		}
 		c.StorageAccount.AccessKey = *(*result.Keys)[0].Value
@@ -318,10 +586,54 @@ func (c *AzureStorageSDKClient) DeleteStorageAccount() error {
 	logger.Info("start")
 	defer logger.Info("end")
 
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return err
+	}
+
 	_, err := c.storageManagementClient.Delete(c.StorageAccount.ResourceGroupName, c.StorageAccount.StorageAccountName)
 	if err != nil {
+		err = azureSDKError(err)
 		logger.Error("delete", err)
-		return fmt.Errorf("Failed to list keys: %v", err)
+		recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "delete")
+		return fmt.Errorf("Failed to delete the storage account: %v", err)
+	}
+	return nil
+}
+
+// SetStorageAccountTags sets the given tags of the storage account and keeps its other tags. An empty value removes
+// the tag.
+func (c *AzureStorageSDKClient) SetStorageAccountTags(tags map[string]string) error {
+	logger := c.logger.Session("set-storage-account-tags").WithData(lager.Data{"tags": tags})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return err
+	}
+
+	properties, err := c.getStorageAccountProperties()
+	if err != nil {
+		logger.Error("get-storage-account-properties", err)
+		return err
+	}
+	accountTags := map[string]*string{}
+	if properties.Tags != nil {
+		accountTags = *properties.Tags
+	}
+	for key, value := range tags {
+		if value == "" {
+			delete(accountTags, key)
+		} else {
+			value := value
+			accountTags[key] = &value
+		}
+	}
+
+	if _, err := c.storageManagementClient.Update(c.StorageAccount.ResourceGroupName, c.StorageAccount.StorageAccountName, storage.AccountUpdateParameters{Tags: &accountTags}); err != nil {
+		err = azureSDKError(err)
+		logger.Error("update", err)
+		recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "update")
+		return err
 	}
 	return nil
 }
@@ -347,8 +659,7 @@ func (c *AzureStorageSDKClient) initFileServiceClient() error {
 		}
 	}
 
-	environment := c.cloudConfig.Azure.Environment
-	apiVersion := Environments[environment].APIVersions.StorageForSDK
+	apiVersion := c.cloudConfig.Azure.GetAPIVersions().StorageForSDK
 	client, err := file.NewClient(c.StorageAccount.StorageAccountName, c.StorageAccount.AccessKey, c.StorageAccount.BaseURL, apiVersion, c.StorageAccount.UseHTTPS)
 	if err != nil {
 		logger.Error("new-file-client", err, lager.Data{
@@ -359,7 +670,7 @@ func (c *AzureStorageSDKClient) initFileServiceClient() error {
 		return err
 	}
 	c.storageFileServiceClient = &client
-	c.storageFileServiceClient.AddToUserAgent(userAgent)
+	c.storageFileServiceClient.AddToUserAgent(c.cloudConfig.Azure.GetUserAgent())
 	return nil
 }
 
@@ -368,6 +679,10 @@ func (c *AzureStorageSDKClient) HasFileShare(fileShareName string) (bool, error)
 	logger.Info("start")
 	defer logger.Info("end")
 
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return false, err
+	}
+
 	if err := c.initFileServiceClient(); err != nil {
 		return false, err
 	}
@@ -376,33 +691,147 @@ func (c *AzureStorageSDKClient) HasFileShare(fileShareName string) (bool, error)
 	exists, err := share.Exists()
 	if err != nil {
 		logger.Error("check-file-share-exists", err)
+		recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "check-file-share-exists")
 	}
 	return exists, err
 }
 
+// ListFileShares returns the creator in the metadata of every file share in the storage account by the share name.
+// The creator is empty for the shares which were not created by the broker.
+func (c *AzureStorageSDKClient) ListFileShares() (map[string]string, error) {
+	logger := c.logger.Session("list-file-shares")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return nil, err
+	}
+
+	if err := c.initFileServiceClient(); err != nil {
+		return nil, err
+	}
+	fileService := c.storageFileServiceClient.GetFileService()
+	shares := map[string]string{}
+	params := file.ListSharesParameters{Include: "metadata", Timeout: fileRequestTimeoutInSeconds}
+	for {
+		response, err := fileService.ListShares(params)
+		if err != nil {
+			logger.Error("list-file-shares", err)
+			recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "list-file-shares")
+			return nil, err
+		}
+		for _, share := range response.Shares {
+			shares[share.Name] = share.Metadata[creator]
+		}
+		if response.NextMarker == "" {
+			return shares, nil
+		}
+		params.Marker = response.NextMarker
+	}
+}
+
 func (c *AzureStorageSDKClient) CreateFileShare(fileShareName string) error {
 	logger := c.logger.Session("create-file-share").WithData(lager.Data{"FileShareName": fileShareName})
 	logger.Info("start")
 	defer logger.Info("end")
 
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return err
+	}
+
 	if err := c.initFileServiceClient(); err != nil {
 		return err
 	}
 	fileService := c.storageFileServiceClient.GetFileService()
 	share := fileService.GetShareReference(fileShareName)
+	// The creator is used to tell the shares created by the broker from the shares created out-of-band
+	share.Metadata = map[string]string{creator: c.cloudConfig.Azure.CreatorTagValue}
 	options := file.FileRequestOptions{Timeout: fileRequestTimeoutInSeconds}
 	err := share.Create(&options)
 	if err != nil {
 		logger.Error("create-file-share", err)
+		recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "create-file-share")
 	}
 	return err
 }
 
+// SetFileShareMetadata sets a metadata of the file share and keeps its other metadata. An empty value removes it.
+func (c *AzureStorageSDKClient) SetFileShareMetadata(fileShareName, key, value string) error {
+	logger := c.logger.Session("set-file-share-metadata").WithData(lager.Data{"FileShareName": fileShareName, "key": key, "value": value})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return err
+	}
+
+	if err := c.initFileServiceClient(); err != nil {
+		return err
+	}
+	fileService := c.storageFileServiceClient.GetFileService()
+	share := fileService.GetShareReference(fileShareName)
+	options := file.FileRequestOptions{Timeout: fileRequestTimeoutInSeconds}
+	if err := share.FetchAttributes(&options); err != nil {
+		logger.Error("fetch-attributes", err)
+		recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "fetch-attributes")
+		return err
+	}
+	if share.Metadata == nil {
+		share.Metadata = map[string]string{}
+	}
+	if value == "" {
+		delete(share.Metadata, key)
+	} else {
+		share.Metadata[key] = value
+	}
+	if err := share.SetMetadata(&options); err != nil {
+		logger.Error("set-metadata", err)
+		recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "set-metadata")
+		return err
+	}
+	return nil
+}
+
+// CreateDirectories creates the directories in the file share unless they exist. The parents must be before their
+// children in the paths.
+func (c *AzureStorageSDKClient) CreateDirectories(fileShareName string, paths []string) error {
+	logger := c.logger.Session("create-directories").WithData(lager.Data{"FileShareName": fileShareName, "paths": paths})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return err
+	}
+
+	if err := c.initFileServiceClient(); err != nil {
+		return err
+	}
+	fileService := c.storageFileServiceClient.GetFileService()
+	share := fileService.GetShareReference(fileShareName)
+	options := file.FileRequestOptions{Timeout: fileRequestTimeoutInSeconds}
+	for _, path := range paths {
+		directory := share.GetRootDirectoryReference()
+		for _, name := range strings.Split(path, "/") {
+			directory = directory.GetDirectoryReference(name)
+		}
+		if _, err := directory.CreateIfNotExists(&options); err != nil {
+			logger.Error("create-directory", err, lager.Data{"path": path})
+			recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "create-directory")
+			return fmt.Errorf("Failed to create the directory %q: %v", path, err)
+		}
+	}
+	return nil
+}
+
 func (c *AzureStorageSDKClient) DeleteFileShare(fileShareName string) error {
 	logger := c.logger.Session("delete-file-share").WithData(lager.Data{"FileShareName": fileShareName})
 	logger.Info("start")
 	defer logger.Info("end")
 
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return err
+	}
+
 	if err := c.initFileServiceClient(); err != nil {
 		return err
 	}
@@ -413,6 +842,7 @@ func (c *AzureStorageSDKClient) DeleteFileShare(fileShareName string) error {
 	if err != nil {
 		// TBD: return nil when the share does not exist
 		logger.Error("delete-file-share", err)
+		recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "delete-file-share")
 	}
 	return err
 }
@@ -422,13 +852,232 @@ func (c *AzureStorageSDKClient) GetShareURL(fileShareName string) (string, error
 	logger.Info("start")
 	defer logger.Info("end")
 
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return "", err
+	}
+
+	if c.StorageAccount.BaseURL == "" {
+		if err := c.getBaseURL(); err != nil {
+			return "", err
+		}
+	}
+
+	return c.cloudConfig.Azure.GetShareURL(c.StorageAccount, fileShareName), nil
+}
+
+// VerifyShareSAS checks that the SAS token grants access to the file share by listing its root directory with it
+// Reference: https://docs.microsoft.com/en-us/rest/api/storageservices/list-directories-and-files
+func (c *AzureStorageSDKClient) VerifyShareSAS(fileShareName, sasToken string) error {
+	logger := c.logger.Session("verify-share-sas").WithData(lager.Data{"FileShareName": fileShareName})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return err
+	}
+
+	if c.StorageAccount.BaseURL == "" {
+		if err := c.getBaseURL(); err != nil {
+			return err
+		}
+	}
+
+	shareURL := fmt.Sprintf("https://%s.file.%s/%s?restype=directory&comp=list&%s", c.StorageAccount.StorageAccountName, c.StorageAccount.BaseURL, fileShareName, strings.TrimPrefix(sasToken, "?"))
+	resp, err := resty.R().
+		SetHeader("x-ms-version", c.cloudConfig.Azure.GetAPIVersions().StorageForSDK).
+		Get(shareURL)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		err := WithAzureRequestIDs(fmt.Errorf("Error Code: %d", resp.StatusCode()), resp.Header())
+		logger.Error("list-directories-and-files", err)
+		recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "list-directories-and-files")
+		return err
+	}
+	return nil
+}
+
+// fileReference returns the file of a path in the file share
+func (c *AzureStorageSDKClient) fileReference(fileShareName, filePath string) *file.File {
+	fileService := c.storageFileServiceClient.GetFileService()
+	directory := fileService.GetShareReference(fileShareName).GetRootDirectoryReference()
+	names := strings.Split(filePath, "/")
+	for _, name := range names[:len(names)-1] {
+		directory = directory.GetDirectoryReference(name)
+	}
+	return directory.GetFileReference(names[len(names)-1])
+}
+
+// ListFilesAndDirectories returns the paths of the directories and of the files in the file share. A directory is
+// before its children.
+func (c *AzureStorageSDKClient) ListFilesAndDirectories(fileShareName string) ([]string, []string, error) {
+	logger := c.logger.Session("list-files-and-directories").WithData(lager.Data{"FileShareName": fileShareName})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return nil, nil, err
+	}
+
+	if err := c.initFileServiceClient(); err != nil {
+		return nil, nil, err
+	}
+	fileService := c.storageFileServiceClient.GetFileService()
+	share := fileService.GetShareReference(fileShareName)
+	directories, files := []string{}, []string{}
+	pending := []string{""}
+	for len(pending) > 0 {
+		parent := pending[0]
+		pending = pending[1:]
+		directory := share.GetRootDirectoryReference()
+		if parent != "" {
+			for _, name := range strings.Split(parent, "/") {
+				directory = directory.GetDirectoryReference(name)
+			}
+		}
+		params := file.ListDirsAndFilesParameters{Timeout: fileRequestTimeoutInSeconds}
+		for {
+			response, err := directory.ListDirsAndFiles(params)
+			if err != nil {
+				logger.Error("list-directories-and-files", err, lager.Data{"path": parent})
+				recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "list-directories-and-files")
+				return nil, nil, err
+			}
+			for _, child := range response.Directories {
+				childPath := path.Join(parent, child.Name)
+				directories = append(directories, childPath)
+				pending = append(pending, childPath)
+			}
+			for _, child := range response.Files {
+				files = append(files, path.Join(parent, child.Name))
+			}
+			if response.NextMarker == "" {
+				break
+			}
+			params.Marker = response.NextMarker
+		}
+	}
+	return directories, files, nil
+}
+
+// ListDirectory returns the directories and the files in a directory of the file share, up to maxResults entries, and
+// true if the directory has more. The root directory is "".
+// Reference: https://docs.microsoft.com/en-us/rest/api/storageservices/list-directories-and-files
+func (c *AzureStorageSDKClient) ListDirectory(fileShareName, directoryPath string, maxResults int) ([]DirectoryEntry, bool, error) {
+	logger := c.logger.Session("list-directory").WithData(lager.Data{"FileShareName": fileShareName, "path": directoryPath})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return nil, false, err
+	}
+
+	if err := c.initFileServiceClient(); err != nil {
+		return nil, false, err
+	}
+	fileService := c.storageFileServiceClient.GetFileService()
+	directory := fileService.GetShareReference(fileShareName).GetRootDirectoryReference()
+	if directoryPath != "" {
+		for _, name := range strings.Split(directoryPath, "/") {
+			directory = directory.GetDirectoryReference(name)
+		}
+	}
+	entries := []DirectoryEntry{}
+	params := file.ListDirsAndFilesParameters{Timeout: fileRequestTimeoutInSeconds}
+	for {
+		// One more entry than wanted tells whether the directory has more
+		params.MaxResults = uint(maxResults + 1 - len(entries))
+		response, err := directory.ListDirsAndFiles(params)
+		if err != nil {
+			logger.Error("list-directories-and-files", err)
+			recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "list-directories-and-files")
+			return nil, false, err
+		}
+		for _, child := range response.Directories {
+			entries = append(entries, DirectoryEntry{Name: child.Name, IsDirectory: true})
+		}
+		for _, child := range response.Files {
+			entries = append(entries, DirectoryEntry{Name: child.Name, SizeBytes: int64(child.Properties.Length)})
+		}
+		if len(entries) > maxResults {
+			return entries[:maxResults], true, nil
+		}
+		if response.NextMarker == "" {
+			return entries, false, nil
+		}
+		params.Marker = response.NextMarker
+	}
+}
+
+// CopyFile starts the server-side copy of the source URL to the file. The source must be readable with the SAS token
+// in its URL. The copy may finish after the call, which GetFileCopyStatus tells.
+// Reference: https://docs.microsoft.com/en-us/rest/api/storageservices/copy-file
+func (c *AzureStorageSDKClient) CopyFile(fileShareName, filePath, sourceURL string) error {
+	logger := c.logger.Session("copy-file").WithData(lager.Data{"FileShareName": fileShareName, "path": filePath})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return err
+	}
+
+	if err := c.initFileServiceClient(); err != nil {
+		return err
+	}
+	options := file.FileRequestOptions{Timeout: fileRequestTimeoutInSeconds}
+	if err := c.fileReference(fileShareName, filePath).CopyFile(sourceURL, &options); err != nil {
+		logger.Error("copy-file", err)
+		recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "copy-file")
+		return err
+	}
+	return nil
+}
+
+// GetFileCopyStatus returns the status of the copy to the file: pending, success, aborted or failed. It returns "" if
+// the file does not exist. The properties are read with the SAS token because the SDK does not return the copy status.
+// Reference: https://docs.microsoft.com/en-us/rest/api/storageservices/get-file-properties
+func (c *AzureStorageSDKClient) GetFileCopyStatus(fileShareName, filePath, sasToken string) (string, error) {
+	if err := contextError(c.StorageAccount.Context); err != nil {
+		return "", err
+	}
+
 	if c.StorageAccount.BaseURL == "" {
 		if err := c.getBaseURL(); err != nil {
 			return "", err
 		}
 	}
 
-	return fmt.Sprintf("//%s.file.%s/%s", c.StorageAccount.StorageAccountName, c.StorageAccount.BaseURL, fileShareName), nil
+	fileURL := fmt.Sprintf("https://%s.file.%s/%s/%s?%s", c.StorageAccount.StorageAccountName, c.StorageAccount.BaseURL, fileShareName, escapeFilePath(filePath), strings.TrimPrefix(sasToken, "?"))
+	resp, err := resty.R().
+		SetHeader("x-ms-version", c.cloudConfig.Azure.GetAPIVersions().StorageForSDK).
+		Head(fileURL)
+	if err != nil {
+		return "", err
+	}
+	switch resp.StatusCode() {
+	case http.StatusOK:
+		// A file which was not copied has no copy status
+		if status := resp.Header().Get("x-ms-copy-status"); status != "" {
+			return status, nil
+		}
+		return "success", nil
+	case http.StatusNotFound:
+		return "", nil
+	}
+	err = WithAzureRequestIDs(fmt.Errorf("Error Code: %d", resp.StatusCode()), resp.Header())
+	c.logger.Error("get-file-properties", err, lager.Data{"FileShareName": fileShareName, "path": filePath})
+	recordAzureRequestFailure(c.StorageAccount.Metrics, "sdk", "get-file-properties")
+	return "", err
+}
+
+// escapeFilePath escapes the names of a path of a file share for a URL
+func escapeFilePath(filePath string) string {
+	names := strings.Split(filePath, "/")
+	for i, name := range names {
+		names[i] = url.PathEscape(name)
+	}
+	return strings.Join(names, "/")
 }
 
 type AzureToken struct {
@@ -441,10 +1090,19 @@ type AzureRESTClient struct {
 	cloudConfig    *CloudConfig
 	storageAccount *StorageAccount
 	token          AzureToken
+	// auxiliaryToken is sent in the x-ms-authorization-auxiliary header when the storage account is in another tenant
+	auxiliaryToken AzureToken
 }
 
 func NewAzureStorageAccountRESTClient(logger lager.Logger, cloudConfig *CloudConfig, storageAccount *StorageAccount) (AzureStorageAccountRESTClient, error) {
 	logger = logger.Session("storage-account-rest-client").WithData(lager.Data{"StorageAccountName": storageAccount.StorageAccountName})
+	cloudConfig, err := cloudConfig.withServicePrincipal(storageAccount.ServicePrincipal)
+	if err != nil {
+		return nil, err
+	}
+	if cloudConfig.backend != nil {
+		return cloudConfig.backend.NewRESTClient(logger, cloudConfig, storageAccount)
+	}
 	client := AzureRESTClient{
 		logger:         logger,
 		cloudConfig:    cloudConfig,
@@ -454,13 +1112,24 @@ func NewAzureStorageAccountRESTClient(logger lager.Logger, cloudConfig *CloudCon
 }
 
 func (c *AzureRESTClient) refreshToken(force bool) error {
-	if c.token.AccessToken == "" || time.Until(c.token.ExpiresOn) <= 0 || force {
+	if err := c.refreshTenantToken(&c.token, c.cloudConfig.Azure.TenanID, force); err != nil {
+		return err
+	}
+	if tenantID := c.cloudConfig.Azure.auxiliaryTokenTenantID; tenantID != "" {
+		return c.refreshTenantToken(&c.auxiliaryToken, tenantID, force)
+	}
+	return nil
+}
+
+// refreshTenantToken refreshes the token of the service principal in the tenant when it is missing or expired
+func (c *AzureRESTClient) refreshTenantToken(token *AzureToken, tenantID string, force bool) error {
+	if token.AccessToken == "" || time.Until(token.ExpiresOn) <= 0 || force {
 		headers := map[string]string{
 			"Content-Type": contentTypeWWW,
-			"User-Agent":   userAgent,
+			"User-Agent":   c.cloudConfig.Azure.GetUserAgent(),
 		}
 
-		hostURL := fmt.Sprintf("%s/%s/oauth2/token", Environments[c.cloudConfig.Azure.Environment].ActiveDirectoryEndpointURL, c.cloudConfig.Azure.TenanID)
+		hostURL := fmt.Sprintf("%s/%s/oauth2/token", Environments[c.cloudConfig.Azure.Environment].ActiveDirectoryEndpointURL, tenantID)
 		body := url.Values{
 			"grant_type":    {"client_credentials"},
 			"client_id":     {c.cloudConfig.Azure.ClientID},
@@ -469,12 +1138,13 @@ func (c *AzureRESTClient) refreshToken(force bool) error {
 			"scope":         {"user_impersonation"},
 		}
 
-		resty.DefaultClient.SetRetryCount(3).SetRetryWaitTime(10)
-		resp, err := resty.R().
-			SetHeaders(headers).
-			SetQueryParam("api-version", Environments[c.cloudConfig.Azure.Environment].APIVersions.ActiveDirectory).
-			SetBody(body.Encode()).
-			Post(hostURL)
+		resp, err := c.retry("refresh-token", func() (*resty.Response, error) {
+			return resty.R().
+				SetHeaders(headers).
+				SetQueryParam("api-version", c.cloudConfig.Azure.GetAPIVersions().ActiveDirectory).
+				SetBody(body.Encode()).
+				Post(hostURL)
+		})
 		if err != nil {
 			return err
 		}
@@ -492,37 +1162,30 @@ func (c *AzureRESTClient) refreshToken(force bool) error {
 			if err != nil {
 				return err
 			}
-			c.token.ExpiresOn = time.Unix(expiresOn, 0)
-			c.token.AccessToken = responseBody.AccessToken
+			token.ExpiresOn = time.Unix(expiresOn, 0)
+			token.AccessToken = responseBody.AccessToken
 		} else {
-			return fmt.Errorf("HTTP CODE: %#v", resp.StatusCode())
+			return c.responseError("refresh-token", resp, fmt.Errorf("HTTP CODE: %#v", resp.StatusCode()))
 		}
 	}
 	return nil
 }
 
 func (c *AzureRESTClient) initialize() (map[string]string, map[string]string, error) {
-	resty.DefaultClient.SetRetryCount(3).SetRetryWaitTime(10)
-	check := resty.RetryConditionFunc(func(r *resty.Response) (bool, error) {
-		for _, v := range restRetryCodes {
-			if r.StatusCode() == v {
-				return true, nil
-			}
-		}
-		return false, nil
-	})
-	resty.DefaultClient.AddRetryCondition(check)
 	headers := map[string]string{
 		"Content-Type": contentTypeJSON,
-		"User-Agent":   userAgent,
+		"User-Agent":   c.cloudConfig.Azure.GetUserAgent(),
 	}
 	queries := map[string]string{
-		"api-version": Environments[c.cloudConfig.Azure.Environment].APIVersions.StorageForREST,
+		"api-version": c.cloudConfig.Azure.GetAPIVersions().StorageForREST,
 	}
 	err := c.refreshToken(false)
 	if err != nil {
 		return nil, nil, err
 	}
+	if c.auxiliaryToken.AccessToken != "" {
+		headers[auxiliaryAuthorizationHeader] = "Bearer " + c.auxiliaryToken.AccessToken
+	}
 
 	return headers, queries, nil
 }
@@ -532,6 +1195,10 @@ func (c *AzureRESTClient) initialize() (map[string]string, map[string]string, er
 // Return "operation-url", nil when the storage account is still in creating.
 // Reference: https://docs.microsoft.com/en-us/rest/api/storagerp/storageaccounts#StorageAccounts_Create
 func (c *AzureRESTClient) CreateStorageAccount() (string, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return "", err
+	}
+
 	headers, queries, err := c.initialize()
 	if err != nil {
 		return "", err
@@ -545,7 +1212,29 @@ func (c *AzureRESTClient) CreateStorageAccount() (string, error) {
 		c.storageAccount.StorageAccountName)
 
 	tags := map[string]string{}
-	tags["User-Agent"] = userAgent
+	tags["User-Agent"] = defaultUserAgent
+	tags[creator] = c.cloudConfig.Azure.CreatorTagValue
+	if c.cloudConfig.Azure.BrokerInstanceID != "" {
+		tags[brokerInstanceIDTag] = c.cloudConfig.Azure.BrokerInstanceID
+	}
+
+	kind := storageAccountKind(c.storageAccount.SkuName)
+	encryptedServices := map[string]interface{}{
+		"file": map[string]interface{}{
+			"enabled": c.storageAccount.EnableEncryption,
+		},
+	}
+	// A FileStorage account only has the file service
+	if kind != restAPIFileStorageKind {
+		encryptedServices["blob"] = map[string]interface{}{
+			"enabled": c.storageAccount.EnableEncryption,
+		}
+	}
+	if isZoneRedundantSku(c.storageAccount.SkuName) {
+		if queries["api-version"], err = c.zoneRedundantStorageAPIVersion(); err != nil {
+			return "", err
+		}
+	}
 
 	storageAccount := map[string]interface{}{
 		"location": c.storageAccount.Location,
@@ -554,33 +1243,37 @@ func (c *AzureRESTClient) CreateStorageAccount() (string, error) {
 		"properties": map[string]interface{}{
 			"supportsHttpsTrafficOnly": c.storageAccount.UseHTTPS,
 			"encryption": map[string]interface{}{
-				"services": map[string]interface{}{
-					"blob": map[string]interface{}{
-						"enabled": c.storageAccount.EnableEncryption,
-					},
-					"file": map[string]interface{}{
-						"enabled": c.storageAccount.EnableEncryption,
-					},
-				},
+				"services":  encryptedServices,
 				"keySource": restAPIProviderStorage,
 			},
 		},
 		"sku": map[string]interface{}{
 			"name": string(c.storageAccount.SkuName),
 		},
-		"kind": restAPIStorageKind,
+		"kind": kind,
+	}
+	if len(c.storageAccount.SubnetIDs) > 0 {
+		virtualNetworkRules := []map[string]interface{}{}
+		for _, subnetID := range c.storageAccount.SubnetIDs {
+			virtualNetworkRules = append(virtualNetworkRules, map[string]interface{}{"id": subnetID, "action": "Allow"})
+		}
+		storageAccount["properties"].(map[string]interface{})["networkAcls"] = map[string]interface{}{
+			"defaultAction":       "Deny",
+			"bypass":              "AzureServices",
+			"virtualNetworkRules": virtualNetworkRules,
+		}
 	}
 	body, err := json.Marshal(storageAccount)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := resty.R().
+	request := resty.R().
 		SetHeaders(headers).
 		SetQueryParams(queries).
 		SetAuthToken(c.token.AccessToken).
-		SetBody(body).
-		Put(hostURL)
+		SetBody(body)
+	resp, err := c.send(request, http.MethodPut, hostURL)
 	if err != nil {
 		return "", err
 	}
@@ -588,33 +1281,1055 @@ func (c *AzureRESTClient) CreateStorageAccount() (string, error) {
 	if statusCode == http.StatusOK {
 		return "", nil
 	} else if statusCode == http.StatusAccepted {
+		c.logger.Info("create-storage-account-accepted", azureRequestIDs(resp.Header()))
 		return resp.Header().Get("Location"), nil
 	}
-	return "", fmt.Errorf("Error Code: %d, %v", statusCode, resp)
+	return "", c.responseError("create-storage-account", resp, fmt.Errorf("Error Code: %d, %v", statusCode, resp))
+}
+
+// DeleteStorageAccount Delete a storage account. You need to call CheckCompletion to check whether the deletion is finished.
+// Return "", nil when the storage account has been deleted or does not exist.
+// Return "operation-url", nil when the storage account is still in deleting.
+// Reference: https://docs.microsoft.com/en-us/rest/api/storagerp/storageaccounts#StorageAccounts_Delete
+func (c *AzureRESTClient) DeleteStorageAccount() (string, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return "", err
+	}
+
+	headers, queries, err := c.initialize()
+	if err != nil {
+		return "", err
+	}
+	hostURL := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/%s/%s/%s",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		c.storageAccount.SubscriptionID,
+		c.storageAccount.ResourceGroupName,
+		restAPIProviderStorage,
+		restAPIStorageAccounts,
+		c.storageAccount.StorageAccountName)
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken)
+	resp, err := c.send(request, http.MethodDelete, hostURL)
+	if err != nil {
+		return "", err
+	}
+	switch statusCode := resp.StatusCode(); statusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return "", nil
+	case http.StatusAccepted:
+		c.logger.Info("delete-storage-account-accepted", azureRequestIDs(resp.Header()))
+		return getAsyncOperationURL(resp), nil
+	default:
+		return "", c.responseError("delete-storage-account", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+}
+
+// UpdateStorageAccount Change the SKU of a storage account and whether it only accepts secure transfers. Azure only
+// changes the SKU between the SKUs of the same kind of storage account. The update is synchronous.
+// Reference: https://docs.microsoft.com/en-us/rest/api/storagerp/storageaccounts#StorageAccounts_Update
+func (c *AzureRESTClient) UpdateStorageAccount(skuName storage.SkuName, useHTTPS bool) error {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return err
+	}
+
+	headers, queries, err := c.initialize()
+	if err != nil {
+		return err
+	}
+	hostURL := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/%s/%s/%s",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		c.storageAccount.SubscriptionID,
+		c.storageAccount.ResourceGroupName,
+		restAPIProviderStorage,
+		restAPIStorageAccounts,
+		c.storageAccount.StorageAccountName)
+	if isZoneRedundantSku(skuName) {
+		if queries["api-version"], err = c.zoneRedundantStorageAPIVersion(); err != nil {
+			return err
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"properties": map[string]interface{}{
+			"supportsHttpsTrafficOnly": useHTTPS,
+		},
+		"sku": map[string]interface{}{
+			"name": string(skuName),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken).
+		SetBody(body)
+	resp, err := c.send(request, http.MethodPatch, hostURL)
+	if err != nil {
+		return err
+	}
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK {
+		return c.responseError("update-storage-account", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+	return nil
 }
 
 // CheckCompletion Check whether an asynchronous operation finishes or not
+// Both the Location and the Azure-AsyncOperation URLs are supported.
+// Reference: https://docs.microsoft.com/en-us/azure/azure-resource-manager/resource-manager-async-operations
 func (c *AzureRESTClient) CheckCompletion(asyncURL string) (bool, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return false, err
+	}
+
 	headers, queries, err := c.initialize()
 	if err != nil {
 		return false, err
 	}
 	headers["x-ms-version"] = queries["api-version"]
+	// The URLs of the operations of other providers, e.g. Azure Backup, have the version of their own API
+	if operationURL, err := url.Parse(asyncURL); err == nil && operationURL.Query().Get("api-version") != "" {
+		delete(queries, "api-version")
+	}
 
-	resp, err := resty.R().
+	request := resty.R().
 		SetHeaders(headers).
 		SetQueryParams(queries).
-		SetAuthToken(c.token.AccessToken).
-		Get(asyncURL)
+		SetAuthToken(c.token.AccessToken)
+	resp, err := c.send(request, http.MethodGet, asyncURL)
+	if err != nil {
+		return false, err
+	}
 	statusCode := resp.StatusCode()
 	if statusCode == http.StatusAccepted {
 		return false, nil
-	} else if statusCode == http.StatusOK {
+	} else if statusCode == http.StatusOK || statusCode == http.StatusNoContent {
+		operation := struct {
+			Status string `json:"status"`
+		}{}
+		if err := json.Unmarshal(resp.Body(), &operation); err != nil || operation.Status == "" {
+			// The Location URL returns the resource when the operation succeeds
+			return true, nil
+		}
+		switch operation.Status {
+		case "Succeeded":
+			return true, nil
+		case "Failed", "Canceled":
+			return false, c.responseError("check-completion", resp, fmt.Errorf("The operation is %s: %s", strings.ToLower(operation.Status), parseAPIError(resp.Body())))
+		}
+		return false, nil
+	}
+	return false, c.responseError("check-completion", resp, fmt.Errorf("StatusCode: %d - %s", statusCode, parseAPIError(resp.Body())))
+}
+
+// SubscriptionExists Check whether the subscription is accessible with the credentials of the broker
+// Reference: https://docs.microsoft.com/en-us/rest/api/resources/subscriptions#Subscriptions_Get
+func (c *AzureRESTClient) SubscriptionExists() (bool, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return false, err
+	}
+
+	hostURL := fmt.Sprintf("%s/subscriptions/%s",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		c.storageAccount.SubscriptionID)
+	return c.resourceExists(hostURL)
+}
+
+// ResourceGroupExists Check whether the resource group exists in the subscription
+// Reference: https://docs.microsoft.com/en-us/rest/api/resources/resourcegroups#ResourceGroups_Get
+func (c *AzureRESTClient) ResourceGroupExists() (bool, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return false, err
+	}
+
+	hostURL := fmt.Sprintf("%s/subscriptions/%s/resourcegroups/%s",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		c.storageAccount.SubscriptionID,
+		c.storageAccount.ResourceGroupName)
+	return c.resourceExists(hostURL)
+}
+
+// GetStorageAccountUsage Get the number of storage accounts in the subscription and its limit
+// Return 0, 0, nil when Azure does not report the usage of storage accounts.
+// Reference: https://docs.microsoft.com/en-us/rest/api/storagerp/usage#Usage_List
+func (c *AzureRESTClient) GetStorageAccountUsage() (int, int, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return 0, 0, err
+	}
+
+	headers, queries, err := c.initialize()
+	if err != nil {
+		return 0, 0, err
+	}
+	hostURL := fmt.Sprintf("%s/subscriptions/%s/providers/%s/usages",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		c.storageAccount.SubscriptionID,
+		restAPIProviderStorage)
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken)
+	resp, err := c.send(request, http.MethodGet, hostURL)
+	if err != nil {
+		return 0, 0, err
+	}
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK {
+		return 0, 0, c.responseError("get-storage-account-usage", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+
+	usages := struct {
+		Value []struct {
+			Name struct {
+				Value string `json:"value"`
+			} `json:"name"`
+			CurrentValue int `json:"currentValue"`
+			Limit        int `json:"limit"`
+		} `json:"value"`
+	}{}
+	if err := json.Unmarshal(resp.Body(), &usages); err != nil {
+		return 0, 0, err
+	}
+	for _, usage := range usages.Value {
+		if usage.Name.Value == restAPIUsageStorageAccounts {
+			return usage.CurrentValue, usage.Limit, nil
+		}
+	}
+	return 0, 0, nil
+}
+
+func (c *AzureRESTClient) zoneRedundantStorageAPIVersion() (string, error) {
+	apiVersion := c.cloudConfig.Azure.GetAPIVersions().ZoneRedundantStorage
+	if apiVersion == "" {
+		return "", newBrokerError(ErrCodeInvalidParameters, "The zone-redundant SKUs are not supported in the environment %q", c.cloudConfig.Azure.Environment)
+	}
+	return apiVersion, nil
+}
+
+// IsSkuAvailable Check whether storage accounts of the SKU can be created in the location by the subscription
+// Reference: https://docs.microsoft.com/en-us/rest/api/storagerp/skus/list
+func (c *AzureRESTClient) IsSkuAvailable(skuName storage.SkuName, location string) (bool, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return false, err
+	}
+
+	apiVersion, err := c.zoneRedundantStorageAPIVersion()
+	if err != nil {
+		return false, err
+	}
+	headers, queries, err := c.initialize()
+	if err != nil {
+		return false, err
+	}
+	queries["api-version"] = apiVersion
+	hostURL := fmt.Sprintf("%s/subscriptions/%s/providers/%s/skus",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		c.storageAccount.SubscriptionID,
+		restAPIProviderStorage)
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken)
+	resp, err := c.send(request, http.MethodGet, hostURL)
+	if err != nil {
+		return false, err
+	}
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK {
+		return false, c.responseError("list-skus", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+
+	skus := struct {
+		Value []struct {
+			ResourceType string   `json:"resourceType"`
+			Name         string   `json:"name"`
+			Kind         string   `json:"kind"`
+			Locations    []string `json:"locations"`
+			Restrictions []struct {
+				Type   string   `json:"type"`
+				Values []string `json:"values"`
+			} `json:"restrictions"`
+		} `json:"value"`
+	}{}
+	if err := json.Unmarshal(resp.Body(), &skus); err != nil {
+		return false, err
+	}
+	kind := storageAccountKind(skuName)
+	for _, sku := range skus.Value {
+		if !strings.EqualFold(sku.ResourceType, restAPIStorageAccounts) || sku.Name != string(skuName) || sku.Kind != kind || !containsLocation(sku.Locations, location) {
+			continue
+		}
+		for _, restriction := range sku.Restrictions {
+			if restriction.Type == "Location" && containsLocation(restriction.Values, location) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// containsLocation compares the names of the locations like Azure, e.g. "West Europe" is westeurope
+func containsLocation(locations []string, location string) bool {
+	normalize := func(location string) string {
+		return strings.ToLower(strings.Replace(location, " ", "", -1))
+	}
+	for _, l := range locations {
+		if normalize(l) == normalize(location) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFileShareStats Get the usage and the quota of a file share
+// Reference: https://docs.microsoft.com/en-us/rest/api/storagerp/fileshares/get
+func (c *AzureRESTClient) GetFileShareStats(fileShareName string) (ShareStats, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return ShareStats{}, err
+	}
+
+	headers, queries, err := c.initialize()
+	if err != nil {
+		return ShareStats{}, err
+	}
+	queries["api-version"] = c.cloudConfig.Azure.GetAPIVersions().FileShares
+	queries["$expand"] = "stats"
+	hostURL := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/%s/%s/%s/fileServices/default/shares/%s",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		c.storageAccount.SubscriptionID,
+		c.storageAccount.ResourceGroupName,
+		restAPIProviderStorage,
+		restAPIStorageAccounts,
+		c.storageAccount.StorageAccountName,
+		fileShareName)
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken)
+	resp, err := c.send(request, http.MethodGet, hostURL)
+	if err != nil {
+		return ShareStats{}, err
+	}
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK {
+		return ShareStats{}, c.responseError("get-file-share-stats", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+
+	share := struct {
+		Properties struct {
+			ShareUsageBytes int64 `json:"shareUsageBytes"`
+			ShareQuota      int   `json:"shareQuota"`
+		} `json:"properties"`
+	}{}
+	if err := json.Unmarshal(resp.Body(), &share); err != nil {
+		return ShareStats{}, err
+	}
+	return ShareStats{UsageBytes: share.Properties.ShareUsageBytes, QuotaGiB: share.Properties.ShareQuota}, nil
+}
+
+// shareAccessPolicyAPI is the signed identifier of a file share in Azure Resource Manager
+type shareAccessPolicyAPI struct {
+	ID           string `json:"id"`
+	AccessPolicy struct {
+		ExpiryTime time.Time `json:"expiryTime"`
+		Permission string    `json:"permission"`
+	} `json:"accessPolicy"`
+}
+
+func (c *AzureRESTClient) shareAccessPoliciesAPIVersion() (string, error) {
+	apiVersion := c.cloudConfig.Azure.GetAPIVersions().ShareAccessPolicies
+	if apiVersion == "" {
+		return "", fmt.Errorf("The access policies of file shares are not supported in the environment %q", c.cloudConfig.Azure.Environment)
+	}
+	return apiVersion, nil
+}
+
+// shareURLAndQueries returns the URL of the file share in Azure Resource Manager and the queries of the API of the
+// access policies
+func (c *AzureRESTClient) shareURLAndQueries(fileShareName string) (map[string]string, map[string]string, string, error) {
+	apiVersion, err := c.shareAccessPoliciesAPIVersion()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	headers, queries, err := c.initialize()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	queries["api-version"] = apiVersion
+	hostURL := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/%s/%s/%s/fileServices/default/shares/%s",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		c.storageAccount.SubscriptionID,
+		c.storageAccount.ResourceGroupName,
+		restAPIProviderStorage,
+		restAPIStorageAccounts,
+		c.storageAccount.StorageAccountName,
+		fileShareName)
+	return headers, queries, hostURL, nil
+}
+
+// GetFileShareAccessPolicies Get the stored access policies of a file share
+// Reference: https://docs.microsoft.com/en-us/rest/api/storagerp/file-shares/get
+func (c *AzureRESTClient) GetFileShareAccessPolicies(fileShareName string) ([]ShareAccessPolicy, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return nil, err
+	}
+
+	headers, queries, hostURL, err := c.shareURLAndQueries(fileShareName)
+	if err != nil {
+		return nil, err
+	}
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken)
+	resp, err := c.send(request, http.MethodGet, hostURL)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK {
+		return nil, c.responseError("get-file-share-access-policies", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+
+	share := struct {
+		Properties struct {
+			SignedIdentifiers []shareAccessPolicyAPI `json:"signedIdentifiers"`
+		} `json:"properties"`
+	}{}
+	if err := json.Unmarshal(resp.Body(), &share); err != nil {
+		return nil, err
+	}
+	policies := []ShareAccessPolicy{}
+	for _, identifier := range share.Properties.SignedIdentifiers {
+		policies = append(policies, ShareAccessPolicy{
+			ID:          identifier.ID,
+			Permissions: identifier.AccessPolicy.Permission,
+			Expiry:      identifier.AccessPolicy.ExpiryTime.UTC(),
+		})
+	}
+	return policies, nil
+}
+
+// SetFileShareAccessPolicies Replace the stored access policies of a file share
+// Reference: https://docs.microsoft.com/en-us/rest/api/storagerp/file-shares/update
+func (c *AzureRESTClient) SetFileShareAccessPolicies(fileShareName string, policies []ShareAccessPolicy) error {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return err
+	}
+
+	headers, queries, hostURL, err := c.shareURLAndQueries(fileShareName)
+	if err != nil {
+		return err
+	}
+
+	identifiers := []shareAccessPolicyAPI{}
+	for _, policy := range policies {
+		identifier := shareAccessPolicyAPI{ID: policy.ID}
+		identifier.AccessPolicy.ExpiryTime = policy.Expiry
+		identifier.AccessPolicy.Permission = policy.Permissions
+		identifiers = append(identifiers, identifier)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"properties": map[string]interface{}{
+			"signedIdentifiers": identifiers,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken).
+		SetBody(body)
+	resp, err := c.send(request, http.MethodPatch, hostURL)
+	if err != nil {
+		return err
+	}
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK {
+		return c.responseError("set-file-share-access-policies", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+	return nil
+}
+
+// GetFileShareSAS Get a SAS token of a file share which references a stored access policy of the share, so its
+// permissions and its expiry are those of the policy
+// Reference: https://docs.microsoft.com/en-us/rest/api/storagerp/storage-accounts/list-service-sas
+func (c *AzureRESTClient) GetFileShareSAS(fileShareName, policyID string) (string, error) {
+	return c.listServiceSAS("get-file-share-sas", map[string]string{
+		"canonicalizedResource": fmt.Sprintf("/file/%s/%s", c.storageAccount.StorageAccountName, fileShareName),
+		"signedResource":        "s",
+		"signedIdentifier":      policyID,
+		"signedProtocol":        "https",
+	})
+}
+
+// GetFileShareReadSAS Get a SAS token which grants the read of the files of a file share until the expiry, e.g. to
+// copy them to another storage account
+// Reference: https://docs.microsoft.com/en-us/rest/api/storagerp/storage-accounts/list-service-sas
+func (c *AzureRESTClient) GetFileShareReadSAS(fileShareName string, expiry time.Time) (string, error) {
+	return c.listServiceSAS("get-file-share-read-sas", map[string]string{
+		"canonicalizedResource": fmt.Sprintf("/file/%s/%s", c.storageAccount.StorageAccountName, fileShareName),
+		"signedResource":        "s",
+		"signedPermission":      "r",
+		"signedExpiry":          expiry.UTC().Format(time.RFC3339),
+		"signedProtocol":        "https",
+	})
+}
+
+func (c *AzureRESTClient) listServiceSAS(action string, parameters map[string]string) (string, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return "", err
+	}
+
+	apiVersion, err := c.shareAccessPoliciesAPIVersion()
+	if err != nil {
+		return "", err
+	}
+	headers, queries, err := c.initialize()
+	if err != nil {
+		return "", err
+	}
+	queries["api-version"] = apiVersion
+	hostURL := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/%s/%s/%s/ListServiceSas",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		c.storageAccount.SubscriptionID,
+		c.storageAccount.ResourceGroupName,
+		restAPIProviderStorage,
+		restAPIStorageAccounts,
+		c.storageAccount.StorageAccountName)
+
+	body, err := json.Marshal(parameters)
+	if err != nil {
+		return "", err
+	}
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken).
+		SetBody(body)
+	resp, err := c.send(request, http.MethodPost, hostURL)
+	if err != nil {
+		return "", err
+	}
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK {
+		return "", c.responseError(action, resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+
+	sas := struct {
+		ServiceSasToken string `json:"serviceSasToken"`
+	}{}
+	if err := json.Unmarshal(resp.Body(), &sas); err != nil {
+		return "", err
+	}
+	return sas.ServiceSasToken, nil
+}
+
+func (c *AzureRESTClient) recoveryServicesAPIVersion() (string, error) {
+	apiVersion := c.cloudConfig.Azure.GetAPIVersions().RecoveryServices
+	if apiVersion == "" {
+		return "", fmt.Errorf("Azure Backup is not supported in the environment %q", c.cloudConfig.Azure.Environment)
+	}
+	return apiVersion, nil
+}
+
+// backupContainerName returns the name of the protection container of the storage account in a vault
+func (c *AzureRESTClient) backupContainerName() string {
+	return fmt.Sprintf("StorageContainer;Storage;%s;%s", c.storageAccount.ResourceGroupName, c.storageAccount.StorageAccountName)
+}
+
+// storageAccountResourceID returns the ID of the storage account in Azure Resource Manager
+func (c *AzureRESTClient) storageAccountResourceID() string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s/%s",
+		c.storageAccount.SubscriptionID,
+		c.storageAccount.ResourceGroupName,
+		restAPIProviderStorage,
+		restAPIStorageAccounts,
+		c.storageAccount.StorageAccountName)
+}
+
+// sendBackupRequest sends a request of an operation of Azure Backup in the vault. The operations are asynchronous, so
+// it returns the URL of the operation, or "" when the operation has finished.
+func (c *AzureRESTClient) sendBackupRequest(action, method, vaultID, path, filter string, body interface{}) (string, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return "", err
+	}
+
+	apiVersion, err := c.recoveryServicesAPIVersion()
+	if err != nil {
+		return "", err
+	}
+	headers, queries, err := c.initialize()
+	if err != nil {
+		return "", err
+	}
+	queries["api-version"] = apiVersion
+	if filter != "" {
+		queries["$filter"] = filter
+	}
+	hostURL := fmt.Sprintf("%s%s/%s",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		vaultID,
+		path)
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken)
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return "", err
+		}
+		request.SetBody(data)
+	}
+	resp, err := c.send(request, method, hostURL)
+	if err != nil {
+		return "", err
+	}
+	switch statusCode := resp.StatusCode(); statusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return "", nil
+	case http.StatusAccepted:
+		c.logger.Info(action+"-accepted", azureRequestIDs(resp.Header()))
+		return getAsyncOperationURL(resp), nil
+	default:
+		return "", c.responseError(action, resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+}
+
+// RefreshBackupContainers Discover the storage accounts which the vault can protect, so that a new storage account can
+// be registered. You need to call CheckCompletion to check whether the discovery is finished.
+// Reference: https://docs.microsoft.com/en-us/rest/api/backup/protection-containers/refresh
+func (c *AzureRESTClient) RefreshBackupContainers(vaultID string) (string, error) {
+	return c.sendBackupRequest("refresh-backup-containers", http.MethodPost, vaultID, "backupFabrics/Azure/refreshContainers", "backupManagementType eq 'AzureStorage'", nil)
+}
+
+// RegisterBackupContainer Register the storage account in the vault. It is idempotent, so a storage account which is
+// already registered is accepted. You need to call CheckCompletion to check whether the registration is finished.
+// Reference: https://docs.microsoft.com/en-us/rest/api/backup/protection-containers/register
+func (c *AzureRESTClient) RegisterBackupContainer(vaultID string) (string, error) {
+	body := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"containerType":             "StorageContainer",
+			"sourceResourceId":          c.storageAccountResourceID(),
+			"resourceGroup":             c.storageAccount.ResourceGroupName,
+			"friendlyName":              c.storageAccount.StorageAccountName,
+			"backupManagementType":      "AzureStorage",
+			"acquireStorageAccountLock": "Acquire",
+		},
+	}
+	return c.sendBackupRequest("register-backup-container", http.MethodPut, vaultID, "backupFabrics/Azure/protectionContainers/"+c.backupContainerName(), "", body)
+}
+
+// InquireBackupItems Discover the file shares of the registered storage account, so that a new file share can be
+// protected. You need to call CheckCompletion to check whether the discovery is finished.
+// Reference: https://docs.microsoft.com/en-us/rest/api/backup/protection-containers/inquire
+func (c *AzureRESTClient) InquireBackupItems(vaultID string) (string, error) {
+	return c.sendBackupRequest("inquire-backup-items", http.MethodPost, vaultID, "backupFabrics/Azure/protectionContainers/"+c.backupContainerName()+"/inquire", "workloadType eq 'AzureFileShare'", nil)
+}
+
+// ProtectFileShare Enable the backup of the file share with the backup policy of the vault. You need to call
+// CheckCompletion to check whether the protection is enabled.
+// Reference: https://docs.microsoft.com/en-us/rest/api/backup/protected-items/create-or-update
+func (c *AzureRESTClient) ProtectFileShare(vaultID, policyName, fileShareName string) (string, error) {
+	body := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"protectedItemType": "AzureFileShareProtectedItem",
+			"sourceResourceId":  c.storageAccountResourceID(),
+			"policyId":          fmt.Sprintf("%s/backupPolicies/%s", vaultID, policyName),
+		},
+	}
+	return c.sendBackupRequest("protect-file-share", http.MethodPut, vaultID, fmt.Sprintf("backupFabrics/Azure/protectionContainers/%s/protectedItems/AzureFileShare;%s", c.backupContainerName(), fileShareName), "", body)
+}
+
+// metricAlertCriterion returns the metric, the aggregation and the condition of an alert of a storage account
+func metricAlertCriterion(alert StorageAccountAlert) map[string]interface{} {
+	criterion := map[string]interface{}{
+		"criterionType":   "StaticThresholdCriterion",
+		"name":            alert.Name,
+		"metricNamespace": restAPIProviderStorage + "/" + restAPIStorageAccounts,
+		"dimensions":      []interface{}{},
+		"threshold":       alert.Threshold,
+	}
+	switch alert.Name {
+	case AlertCapacity:
+		criterion["metricName"] = "UsedCapacity"
+		criterion["operator"] = "GreaterThan"
+		criterion["timeAggregation"] = "Average"
+		criterion["threshold"] = alert.Threshold * (1 << 30)
+	case AlertThrottling:
+		criterion["metricName"] = "Transactions"
+		criterion["operator"] = "GreaterThan"
+		criterion["timeAggregation"] = "Total"
+		criterion["dimensions"] = []interface{}{
+			map[string]interface{}{
+				"name":     "ResponseType",
+				"operator": "Include",
+				"values":   []string{"ClientThrottlingError", "ServerBusyError"},
+			},
+		}
+	case AlertAvailability:
+		criterion["metricName"] = "Availability"
+		criterion["operator"] = "LessThan"
+		criterion["timeAggregation"] = "Average"
+	}
+	return criterion
+}
+
+// metricAlertURL returns the URL of an alert rule in the resource group of the storage account
+func (c *AzureRESTClient) metricAlertURL(alertName string) (map[string]string, map[string]string, string, error) {
+	apiVersion := c.cloudConfig.Azure.GetAPIVersions().MetricAlerts
+	if apiVersion == "" {
+		return nil, nil, "", fmt.Errorf("The metric alerts are not supported in the environment %q", c.cloudConfig.Azure.Environment)
+	}
+	headers, queries, err := c.initialize()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	queries["api-version"] = apiVersion
+	hostURL := fmt.Sprintf("%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Insights/metricAlerts/%s",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		c.storageAccount.SubscriptionID,
+		c.storageAccount.ResourceGroupName,
+		alertName)
+	return headers, queries, hostURL, nil
+}
+
+// CreateMetricAlert Create or replace a metric alert on the storage account which notifies the action group
+// Reference: https://docs.microsoft.com/en-us/rest/api/monitor/metricalerts/createorupdate
+func (c *AzureRESTClient) CreateMetricAlert(alertName string, alert StorageAccountAlert, actionGroupID string) error {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return err
+	}
+
+	headers, queries, hostURL, err := c.metricAlertURL(alertName)
+	if err != nil {
+		return err
+	}
+
+	evaluationFrequency, windowSize, severity := "PT5M", "PT15M", 2
+	switch alert.Name {
+	case AlertCapacity:
+		// The used capacity is only emitted hourly
+		evaluationFrequency, windowSize = "PT1H", "PT1H"
+	case AlertAvailability:
+		severity = 1
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"location": "global",
+		"tags": map[string]string{
+			creator: c.cloudConfig.Azure.CreatorTagValue,
+		},
+		"properties": map[string]interface{}{
+			"description":         fmt.Sprintf("The %s of the storage account %s created by the service broker", alert.Name, c.storageAccount.StorageAccountName),
+			"severity":            severity,
+			"enabled":             true,
+			"scopes":              []string{c.storageAccountResourceID()},
+			"evaluationFrequency": evaluationFrequency,
+			"windowSize":          windowSize,
+			"criteria": map[string]interface{}{
+				"odata.type": "Microsoft.Azure.Monitor.SingleResourceMultipleMetricCriteria",
+				"allOf":      []interface{}{metricAlertCriterion(alert)},
+			},
+			"autoMitigate": true,
+			"actions": []interface{}{
+				map[string]interface{}{"actionGroupId": actionGroupID},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken).
+		SetBody(body)
+	resp, err := c.send(request, http.MethodPut, hostURL)
+	if err != nil {
+		return err
+	}
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK && statusCode != http.StatusCreated {
+		return c.responseError("create-metric-alert", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+	return nil
+}
+
+// DeleteMetricAlert Delete a metric alert of the storage account. An alert which does not exist is ignored.
+// Reference: https://docs.microsoft.com/en-us/rest/api/monitor/metricalerts/delete
+func (c *AzureRESTClient) DeleteMetricAlert(alertName string) error {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return err
+	}
+
+	headers, queries, hostURL, err := c.metricAlertURL(alertName)
+	if err != nil {
+		return err
+	}
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken)
+	resp, err := c.send(request, http.MethodDelete, hostURL)
+	if err != nil {
+		return err
+	}
+	switch statusCode := resp.StatusCode(); statusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return c.responseError("delete-metric-alert", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+}
+
+// ListPermissions List the permissions of the service principal of the broker on the resource group
+// Reference: https://docs.microsoft.com/en-us/rest/api/authorization/permissions#Permissions_ListForResourceGroup
+func (c *AzureRESTClient) ListPermissions() ([]Permission, error) {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return nil, err
+	}
+
+	headers, _, err := c.initialize()
+	if err != nil {
+		return nil, err
+	}
+	hostURL := fmt.Sprintf("%s/subscriptions/%s/resourcegroups/%s/providers/Microsoft.Authorization/permissions",
+		Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL,
+		c.storageAccount.SubscriptionID,
+		c.storageAccount.ResourceGroupName)
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParam("api-version", c.cloudConfig.Azure.GetAPIVersions().Authorization).
+		SetAuthToken(c.token.AccessToken)
+	resp, err := c.send(request, http.MethodGet, hostURL)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK {
+		return nil, c.responseError("list-permissions", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+
+	permissions := struct {
+		Value []Permission `json:"value"`
+	}{}
+	if err := json.Unmarshal(resp.Body(), &permissions); err != nil {
+		return nil, err
+	}
+	return permissions.Value, nil
+}
+
+// resourceExists returns false when Azure Resource Manager does not find the resource or does not allow the broker to read it
+func (c *AzureRESTClient) resourceExists(hostURL string) (bool, error) {
+	headers, _, err := c.initialize()
+	if err != nil {
+		return false, err
+	}
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParam("api-version", c.cloudConfig.Azure.GetAPIVersions().ResourceManager).
+		SetAuthToken(c.token.AccessToken)
+	resp, err := c.send(request, http.MethodGet, hostURL)
+	if err != nil {
+		return false, err
+	}
+	switch statusCode := resp.StatusCode(); statusCode {
+	case http.StatusOK:
 		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		// A 403 does not tell whether the resource exists, so it is an authorization error of the caller
+		return false, c.responseError("resource-exists", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+}
+
+// send sends the request to Azure Resource Manager and tries it again by the retrier of the storage account when it
+// fails with a network error or a status code of restRetryCodes. The response of the last attempt is returned.
+func (c *AzureRESTClient) send(request *resty.Request, method, url string) (*resty.Response, error) {
+	return c.retry(method+" "+url, func() (*resty.Response, error) {
+		return c.execute(request, method, url)
+	})
+}
+
+// retry calls execute until it returns a response whose status code is not in restRetryCodes, the retrier of the
+// storage account gives up or its context is done
+func (c *AzureRESTClient) retry(action string, execute func() (*resty.Response, error)) (*resty.Response, error) {
+	var resp *resty.Response
+	var err error
+	c.storageAccount.Retrier.Do(c.logger, action, func() (bool, error) {
+		if resp != nil || err != nil {
+			if err = contextError(c.storageAccount.Context); err != nil {
+				return false, err
+			}
+		}
+		resp, err = execute()
+		if err != nil {
+			return true, err
+		}
+		if statusCode := resp.StatusCode(); isRestRetryCode(statusCode) {
+			return true, fmt.Errorf("Error Code: %d", statusCode)
+		}
+		return false, nil
+	})
+	return resp, err
+}
+
+// isRestRetryCode returns true if a response with the status code may succeed when the request is sent again
+func isRestRetryCode(statusCode int) bool {
+	for _, code := range restRetryCodes {
+		if statusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// execute sends the request once after it is slowed down by the throttle of the storage account and records the
+// remaining requests of the subscription in the response and its outcome in the health of Azure
+func (c *AzureRESTClient) execute(request *resty.Request, method, url string) (*resty.Response, error) {
+	c.storageAccount.Throttle.waitForQuota(c.logger, c.storageAccount, method)
+	resp, err := request.Execute(method, url)
+	if err != nil {
+		c.storageAccount.Health.Record(err, 0)
+		return resp, err
+	}
+	c.storageAccount.Health.Record(nil, resp.StatusCode())
+	if c.storageAccount.Throttle != nil {
+		c.storageAccount.Throttle.Observe(c.storageAccount.SubscriptionID, resp.Header())
+	}
+	return resp, err
+}
+
+// responseError adds the request IDs of the failed response to the error, logs it and counts it in the metrics
+func (c *AzureRESTClient) responseError(action string, resp *resty.Response, err error) error {
+	err = WithAzureRequestIDs(err, resp.Header())
+	c.logger.Error(action, err, azureRequestIDs(resp.Header()))
+	recordAzureRequestFailure(c.storageAccount.Metrics, "rest", action)
+	return err
+}
+
+func getAsyncOperationURL(resp *resty.Response) string {
+	if asyncURL := resp.Header().Get("Azure-AsyncOperation"); asyncURL != "" {
+		return asyncURL
+	}
+	return resp.Header().Get("Location")
+}
+
+// parseAPIError extracts the code and message from an error response of Azure Resource Manager, e.g.
+// {"error": {"code": "StorageAccountNotFound", "message": "..."}}
+func parseAPIError(body []byte) string {
+	apiResponse := struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	if err := json.Unmarshal(body, &apiResponse); err != nil || (apiResponse.Error.Code == "" && apiResponse.Error.Message == "") {
+		return string(body)
+	}
+	return fmt.Sprintf("%s: %s", apiResponse.Error.Code, apiResponse.Error.Message)
+}
+
+// diagnosticSettingURL returns the URL of a diagnostic setting of the file service of the storage account
+func (c *AzureRESTClient) diagnosticSettingURL(settingName string) (map[string]string, map[string]string, string, error) {
+	apiVersion := c.cloudConfig.Azure.GetAPIVersions().DiagnosticSettings
+	if apiVersion == "" {
+		return nil, nil, "", fmt.Errorf("The diagnostic settings are not supported in the environment %q", c.cloudConfig.Azure.Environment)
+	}
+	headers, queries, err := c.initialize()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	queries["api-version"] = apiVersion
+	hostURL := fmt.Sprintf("%s%s/fileServices/default/providers/Microsoft.Insights/diagnosticSettings/%s",
+		strings.TrimSuffix(Environments[c.cloudConfig.Azure.Environment].ResourceManagerEndpointURL, "/"),
+		c.storageAccountResourceID(),
+		settingName)
+	return headers, queries, hostURL, nil
+}
+
+// CreateDiagnosticSetting Create or replace a diagnostic setting which sends the reads, the writes and the deletes of
+// the file shares of the storage account to the destination
+// Reference: https://docs.microsoft.com/en-us/rest/api/monitor/diagnosticsettings/createorupdate
+func (c *AzureRESTClient) CreateDiagnosticSetting(settingName string, destination AccessLogDestination) error {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return err
+	}
+
+	headers, queries, hostURL, err := c.diagnosticSettingURL(settingName)
+	if err != nil {
+		return err
 	}
-	apiResponse := map[string]interface{}{}
-	if err := json.Unmarshal(resp.Body(), &apiResponse); err != nil {
-		return false, fmt.Errorf("StatusCode: %d - %v\n\t%s", statusCode, resp, err)
+
+	logs := []interface{}{}
+	for _, category := range accessLogCategories {
+		logs = append(logs, map[string]interface{}{"category": category, "enabled": true})
+	}
+	properties := map[string]interface{}{"logs": logs}
+	if destination.WorkspaceID != "" {
+		properties["workspaceId"] = destination.WorkspaceID
+	}
+	if destination.StorageAccountID != "" {
+		properties["storageAccountId"] = destination.StorageAccountID
+	}
+	body, err := json.Marshal(map[string]interface{}{"properties": properties})
+	if err != nil {
+		return err
+	}
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken).
+		SetBody(body)
+	resp, err := c.send(request, http.MethodPut, hostURL)
+	if err != nil {
+		return err
+	}
+	if statusCode := resp.StatusCode(); statusCode != http.StatusOK && statusCode != http.StatusCreated {
+		return c.responseError("create-diagnostic-setting", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
+	}
+	return nil
+}
+
+// DeleteDiagnosticSetting Delete a diagnostic setting of the file service of the storage account. A setting which does
+// not exist is ignored.
+// Reference: https://docs.microsoft.com/en-us/rest/api/monitor/diagnosticsettings/delete
+func (c *AzureRESTClient) DeleteDiagnosticSetting(settingName string) error {
+	if err := contextError(c.storageAccount.Context); err != nil {
+		return err
+	}
+
+	headers, queries, hostURL, err := c.diagnosticSettingURL(settingName)
+	if err != nil {
+		return err
+	}
+
+	request := resty.R().
+		SetHeaders(headers).
+		SetQueryParams(queries).
+		SetAuthToken(c.token.AccessToken)
+	resp, err := c.send(request, http.MethodDelete, hostURL)
+	if err != nil {
+		return err
+	}
+	switch statusCode := resp.StatusCode(); statusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return c.responseError("delete-diagnostic-setting", resp, fmt.Errorf("Error Code: %d, %s", statusCode, parseAPIError(resp.Body())))
 	}
-	return false, fmt.Errorf("StatusCode: %d - %s", statusCode, apiResponse)
 }
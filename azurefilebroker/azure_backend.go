@@ -0,0 +1,25 @@
+package azurefilebroker
+
+import (
+	"code.cloudfoundry.org/lager"
+)
+
+// AzureBackend creates the clients of the storage accounts instead of the clients of Azure, e.g. the in-memory fake
+// of azurefilebrokerfakes for the acceptance tests and the demo environments without access to a cloud. The broker
+// uses the clients of Azure when no backend is set.
+type AzureBackend interface {
+	NewSDKClient(logger lager.Logger, cloudConfig *CloudConfig, storageAccount *StorageAccount) (AzureStorageAccountSDKClient, error)
+	NewRESTClient(logger lager.Logger, cloudConfig *CloudConfig, storageAccount *StorageAccount) (AzureStorageAccountRESTClient, error)
+}
+
+// SetAzureBackend makes the clients of the storage accounts of the config come from the backend. It must be set before
+// the broker is created with the config.
+func (config *CloudConfig) SetAzureBackend(backend AzureBackend) {
+	config.backend = backend
+}
+
+// Err returns the error of a call to a client of the storage account once its context is done, and nil before. The
+// clients of an AzureBackend return it so that a stopped operation fails like with the clients of Azure.
+func (s *StorageAccount) Err() error {
+	return contextError(s.Context)
+}
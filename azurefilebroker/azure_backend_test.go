@@ -0,0 +1,1381 @@
+package azurefilebroker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	. "code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	"code.cloudfoundry.org/azurefilebroker/azurefilebrokerfakes"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("AzureBackend", func() {
+	var (
+		logger         *lagertest.TestLogger
+		clock          *fakeclock.FakeClock
+		fakeAzure      *azurefilebrokerfakes.FakeAzure
+		cloud          *CloudConfig
+		storageAccount *StorageAccount
+		sdkClient      AzureStorageAccountSDKClient
+		restClient     AzureStorageAccountRESTClient
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("azure-backend-test")
+		clock = fakeclock.NewFakeClock(time.Now())
+		fakeAzure = azurefilebrokerfakes.NewFakeAzure(clock)
+		fakeAzure.AddResourceGroup("subscription", "group")
+		cloud = NewAzurefilebrokerCloudConfig(
+			NewAzureConfig("AzureCloud", "tenant", "client", "secret", "", "", "", "", "", "", nil),
+			NewControlConfig(true, true, true, true, false, "", false, false, false, 0, 0),
+			NewAzureStackConfig("", "", "", ""),
+			NewCredHubConfig("", "", "", ""),
+		)
+		cloud.SetAzureBackend(fakeAzure)
+		storageAccount = &StorageAccount{SubscriptionID: "subscription", ResourceGroupName: "group", StorageAccountName: "account", Location: "westus"}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		sdkClient, err = NewAzureStorageAccountSDKClient(logger, cloud, storageAccount)
+		Expect(err).NotTo(HaveOccurred())
+		restClient, err = NewAzureStorageAccountRESTClient(logger, cloud, storageAccount)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should keep the storage accounts and the file shares", func() {
+		Expect(sdkClient.Exists()).To(BeFalse())
+		Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+		Expect(sdkClient.Exists()).To(BeTrue())
+		Expect(sdkClient.GetAccessKey()).NotTo(BeEmpty())
+
+		Expect(sdkClient.CreateFileShare("share")).To(Succeed())
+		Expect(fakeAzure.HasFileShare("subscription", "group", "account", "share")).To(BeTrue())
+		Expect(sdkClient.ListFileShares()).To(Equal(map[string]string{"share": "azurefilebroker"}))
+		Expect(sdkClient.GetShareURL("share")).To(Equal("//account.file.core.windows.net/share"))
+		Expect(sdkClient.CreateDirectories("share", []string{"data", "data/logs"})).To(Succeed())
+		directories, files, err := sdkClient.ListFilesAndDirectories("share")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(directories).To(Equal([]string{"data", "data/logs"}))
+		Expect(files).To(BeEmpty())
+
+		Expect(sdkClient.DeleteFileShare("share")).To(Succeed())
+		Expect(sdkClient.HasFileShare("share")).To(BeFalse())
+		Expect(restClient.DeleteStorageAccount()).To(BeEmpty())
+		Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeFalse())
+	})
+
+	It("should keep the diagnostic settings of the storage accounts", func() {
+		destination := AccessLogDestination{WorkspaceID: "/subscriptions/s/resourceGroups/g/providers/Microsoft.OperationalInsights/workspaces/audit"}
+		Expect(restClient.CreateDiagnosticSetting("access-logs", destination)).To(MatchError(ContainSubstring("ResourceNotFound")))
+
+		Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+		Expect(restClient.CreateDiagnosticSetting("access-logs", destination)).To(Succeed())
+		setting, found := fakeAzure.DiagnosticSetting("subscription", "group", "account", "access-logs")
+		Expect(found).To(BeTrue())
+		Expect(setting).To(Equal(destination))
+
+		Expect(restClient.DeleteDiagnosticSetting("access-logs")).To(Succeed())
+		_, found = fakeAzure.DiagnosticSetting("subscription", "group", "account", "access-logs")
+		Expect(found).To(BeFalse())
+	})
+
+	It("should fail like Azure", func() {
+		fakeAzure.AddStorageAccount("subscription", "other-group", "account", "westus")
+		_, err := restClient.CreateStorageAccount()
+		Expect(err).To(MatchError(ContainSubstring("StorageAccountAlreadyTaken")))
+		Expect(AzureErrorCode(err)).To(Equal(ErrCodeAzureConflict))
+
+		storageAccount.StorageAccountName = "another"
+		Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+		Expect(sdkClient.CreateFileShare("share")).To(Succeed())
+		err = sdkClient.CreateFileShare("share")
+		Expect(AzureErrorCode(err)).To(Equal(ErrCodeAzureConflict))
+		err = sdkClient.DeleteFileShare("missing")
+		Expect(AzureErrorCode(err)).To(Equal(ErrCodeAzureResourceNotFound))
+	})
+
+	It("should not create a storage account in a missing resource group or beyond the limit", func() {
+		storageAccount.ResourceGroupName = "missing"
+		_, err := restClient.CreateStorageAccount()
+		Expect(AzureErrorCode(err)).To(Equal(ErrCodeAzureResourceNotFound))
+
+		storageAccount.ResourceGroupName = "group"
+		fakeAzure.SetStorageAccountLimit(0)
+		_, err = restClient.CreateStorageAccount()
+		Expect(AzureErrorCode(err)).To(Equal(ErrCodeAzureQuotaExceeded))
+	})
+
+	It("should complete the creation after the polls of the operation", func() {
+		fakeAzure.SetOperationPolls(2)
+		operationURL, err := restClient.CreateStorageAccount()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(operationURL).NotTo(BeEmpty())
+		Expect(sdkClient.CreateFileShare("share")).To(MatchError(ContainSubstring("still in creating")))
+
+		Expect(restClient.CheckCompletion(operationURL)).To(BeFalse())
+		Expect(restClient.CheckCompletion(operationURL)).To(BeTrue())
+		Expect(sdkClient.CreateFileShare("share")).To(Succeed())
+	})
+
+	It("should accept the SAS tokens of the policies which have not expired", func() {
+		Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+		Expect(sdkClient.CreateFileShare("share")).To(Succeed())
+		Expect(restClient.SetFileShareAccessPolicies("share", []ShareAccessPolicy{{ID: "readers", Permissions: "rl", Expiry: clock.Now().Add(time.Hour)}})).To(Succeed())
+		token, err := restClient.GetFileShareSAS("share", "readers")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sdkClient.VerifyShareSAS("share", token)).To(Succeed())
+		Expect(sdkClient.VerifyShareSAS("share", "sv=2019-02-02&sig=forged")).NotTo(Succeed())
+
+		clock.Increment(2 * time.Hour)
+		Expect(sdkClient.VerifyShareSAS("share", token)).NotTo(Succeed())
+	})
+
+	It("should inject the failures", func() {
+		fakeAzure.Fail("CreateStorageAccount", 1, errors.New("Error Code: 500"))
+		_, err := restClient.CreateStorageAccount()
+		Expect(AzureErrorCode(err)).To(Equal(ErrCodeAzureOperationFailed))
+		Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+
+		Expect(fakeAzure.InjectFailures("CreateFileShare=throttled")).To(Succeed())
+		err = sdkClient.CreateFileShare("share")
+		Expect(AzureErrorCode(err)).To(Equal(ErrCodeAzureThrottled))
+	})
+
+	It("should reject the invalid failures", func() {
+		Expect(fakeAzure.InjectFailures("CreateFileShare=throttled:0.5,CheckCompletion=internal-error")).To(Succeed())
+		Expect(fakeAzure.InjectFailures("Unknown=throttled")).To(MatchError(ContainSubstring("Invalid failure")))
+		Expect(fakeAzure.InjectFailures("CreateFileShare=broken")).To(MatchError(ContainSubstring(`unknown failure "broken"`)))
+		Expect(fakeAzure.InjectFailures("CreateFileShare=throttled:2")).To(MatchError(ContainSubstring("between 0 and 1")))
+	})
+
+	It("should publish the schema of every provision parameter in the catalog", func() {
+		broker := New(logger, "service-name", "service-id", clock, &azurefilebrokerfakes.FakeStore{}, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+			NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+			NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+			NewAccessLogConfig("", "", "")))
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/v2/catalog", nil)
+		request.SetBasicAuth("admin", "secret")
+		request.Header.Set(BrokerAPIVersionHeader, "2.14")
+		broker.CatalogHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"}, nil).ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		var response struct {
+			Services []struct {
+				Plans []struct {
+					Name    string `json:"name"`
+					Schemas struct {
+						ServiceInstance struct {
+							Create struct {
+								Parameters struct {
+									Properties map[string]json.RawMessage `json:"properties"`
+								}
+							}
+						} `json:"service_instance"`
+					} `json:"schemas"`
+				} `json:"plans"`
+			} `json:"services"`
+		}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+		plans := response.Services[0].Plans
+		Expect(plans).To(HaveLen(2))
+		Expect(plans[1].Name).To(Equal("AzureFileShare"))
+		properties := plans[1].Schemas.ServiceInstance.Create.Parameters.Properties
+		configurationType := reflect.TypeOf(Configuration{})
+		for i := 0; i < configurationType.NumField(); i++ {
+			Expect(properties).To(HaveKey(configurationType.Field(i).Tag.Get("json")))
+		}
+		Expect(properties["sku_name"]).To(ContainSubstring("Standard_RAGRS"))
+	})
+
+	It("should restrict the instances of the plans of the catalog file to their kind", func() {
+		fakeStore := &azurefilebrokerfakes.FakeStore{}
+		broker := New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+			NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+			NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+			NewAccessLogConfig("", "", "")))
+		broker.SetCatalog(NewCatalogConfig(`{"id":"service-id","name":"smbfs","description":"SMB shares","plans":[
+			{"id":"plan-existing","name":"existing","description":"Preexisting shares","kind":"preexisting"},
+			{"id":"plan-standard","name":"standard","description":"Azure file shares"}]}`))
+
+		services, err := broker.Services(context.TODO())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(services[0].Plans).To(HaveLen(2))
+		Expect(services[0].PlanUpdatable).To(BeFalse())
+
+		_, err = broker.Provision(context.TODO(), "instance-id", brokerapi.ProvisionDetails{
+			PlanID:        "plan-existing",
+			RawParameters: json.RawMessage(`{"storage_account_name":"account"}`),
+		}, false)
+		Expect(err).To(MatchError("The plan existing only supports preexisting shares: the parameter share must be given"))
+		Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(0))
+	})
+
+	Context("admin API", func() {
+		var (
+			fakeStore *azurefilebrokerfakes.FakeStore
+			handler   http.Handler
+			recorder  *httptest.ResponseRecorder
+		)
+
+		BeforeEach(func() {
+			fakeStore = &azurefilebrokerfakes.FakeStore{}
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{SubscriptionID: "subscription", ResourceGroupName: "group", TargetName: "account"}, nil)
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+				"instance-id-share-b": {InstanceID: "instance-id", FileShareName: "share-b"},
+				"instance-id-share-a": {InstanceID: "instance-id", FileShareName: "share-a"},
+				"other-id-share-c":    {InstanceID: "other-id", FileShareName: "share-c"},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+			Expect(sdkClient.CreateFileShare("share-a")).To(Succeed())
+			Expect(sdkClient.CreateDirectories("share-a", []string{"logs", "data", "data/uploads"})).To(Succeed())
+			Expect(sdkClient.CopyFile("share-a", "readme.txt", "https://source/readme.txt")).To(Succeed())
+			Expect(fakeAzure.SetFileShareUsage("subscription", "group", "account", "share-a", 42)).To(BeTrue())
+
+			mount := NewAzurefilebrokerMountConfig()
+			broker := New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(mount, cloud,
+				NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+				NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+				NewAccessLogConfig("", "", "")))
+			handler = broker.AdminHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"})
+			recorder = httptest.NewRecorder()
+		})
+
+		It("should list the root directory and the usage of the file shares of the instance", func() {
+			request := httptest.NewRequest("GET", "/admin/instances/instance-id/files", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var response struct {
+				InstanceID string         `json:"instance_id"`
+				FileShares []ShareListing `json:"file_shares"`
+			}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.FileShares).To(HaveLen(2))
+			Expect(response.FileShares[0].FileShareName).To(Equal("share-a"))
+			Expect(response.FileShares[0].UsageBytes).To(Equal(int64(42)))
+			Expect(response.FileShares[0].Entries).To(Equal([]DirectoryEntry{
+				{Name: "data", IsDirectory: true},
+				{Name: "logs", IsDirectory: true},
+				{Name: "readme.txt"},
+			}))
+			Expect(response.FileShares[0].Error).To(BeEmpty())
+			Expect(response.FileShares[1].FileShareName).To(Equal("share-b"))
+			Expect(response.FileShares[1].Error).To(ContainSubstring("ShareNotFound"))
+		})
+
+		It("should refuse a preexisting share", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{IsPreexisting: true, TargetName: "//server/share"}, nil)
+			request := httptest.NewRequest("GET", "/admin/instances/instance-id/files", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("Update of the storage account", func() {
+		var (
+			fakeStore       *azurefilebrokerfakes.FakeStore
+			broker          *Broker
+			serviceInstance ServiceInstance
+		)
+
+		BeforeEach(func() {
+			fakeStore = &azurefilebrokerfakes.FakeStore{}
+			serviceInstance = ServiceInstance{
+				SubscriptionID:          "subscription",
+				ResourceGroupName:       "group",
+				TargetName:              "account",
+				SkuName:                 "Standard_RAGRS",
+				UseHTTPS:                "false",
+				IsCreatedStorageAccount: true,
+				ProvisioningState:       "succeeded",
+			}
+		})
+
+		JustBeforeEach(func() {
+			storageAccount.SkuName = "Standard_RAGRS"
+			Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+			fakeStore.RetrieveServiceInstanceReturns(serviceInstance, nil)
+			broker = New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+				NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+				NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+				NewAccessLogConfig("", "", "")))
+		})
+
+		It("should change the SKU and the secure transfer of the storage account", func() {
+			_, err := broker.Update(context.TODO(), "instance-id", brokerapi.UpdateDetails{RawParameters: json.RawMessage(`{"use_https":true,"sku_name":"Standard_LRS"}`)}, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			skuName, useHTTPS, found := fakeAzure.StorageAccountProperties("subscription", "group", "account")
+			Expect(found).To(BeTrue())
+			Expect(string(skuName)).To(Equal("Standard_LRS"))
+			Expect(useHTTPS).To(BeTrue())
+			_, updated := fakeStore.UpdateServiceInstanceArgsForCall(0)
+			Expect(updated.SkuName).To(Equal("Standard_LRS"))
+			Expect(updated.UseHTTPS).To(Equal("true"))
+		})
+
+		It("should refuse a SKU which needs another kind of storage account", func() {
+			_, err := broker.Update(context.TODO(), "instance-id", brokerapi.UpdateDetails{RawParameters: json.RawMessage(`{"sku_name":"Standard_ZRS"}`)}, false)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Use migrate_to"))
+
+			skuName, _, _ := fakeAzure.StorageAccountProperties("subscription", "group", "account")
+			Expect(string(skuName)).To(Equal("Standard_RAGRS"))
+			Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+		})
+
+		Context("when the broker did not create the storage account", func() {
+			BeforeEach(func() {
+				serviceInstance.IsCreatedStorageAccount = false
+			})
+
+			It("should not change it", func() {
+				_, err := broker.Update(context.TODO(), "instance-id", brokerapi.UpdateDetails{RawParameters: json.RawMessage(`{"use_https":true}`)}, false)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("only be updated for the storage accounts which the broker created"))
+
+				_, useHTTPS, _ := fakeAzure.StorageAccountProperties("subscription", "group", "account")
+				Expect(useHTTPS).To(BeFalse())
+			})
+		})
+	})
+
+	Context("Deprovision of the storage account", func() {
+		var (
+			fakeStore *azurefilebrokerfakes.FakeStore
+			broker    *Broker
+		)
+
+		BeforeEach(func() {
+			fakeStore = &azurefilebrokerfakes.FakeStore{}
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				SubscriptionID:          "subscription",
+				ResourceGroupName:       "group",
+				TargetName:              "account",
+				IsCreatedStorageAccount: true,
+				ProvisioningState:       "succeeded",
+			}, nil)
+			fakeStore.RetrieveFileShareOwnerReturns(FileShareOwner{}, brokerapi.ErrInstanceDoesNotExist)
+			fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+		})
+
+		JustBeforeEach(func() {
+			Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+			Expect(sdkClient.CreateFileShare("data")).To(Succeed())
+			broker = New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+				NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+				NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+				NewAccessLogConfig("", "", "")))
+		})
+
+		deprovision := func() error {
+			_, err := broker.Deprovision(context.TODO(), "instance-id", brokerapi.DeprovisionDetails{}, false)
+			return err
+		}
+
+		It("should delete the storage account with the file shares which the broker created", func() {
+			Expect(deprovision()).To(Succeed())
+			Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeFalse())
+		})
+
+		It("should keep the storage account with a file share which the broker did not create", func() {
+			Expect(fakeAzure.AddFileShare("subscription", "group", "account", "foreign", "someone")).To(BeTrue())
+
+			err := deprovision()
+			Expect(ErrorCode(err)).To(Equal(ErrCodeForeignFileSharesExist))
+			Expect(err.Error()).To(ContainSubstring("foreign"))
+			Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeTrue())
+			Expect(fakeStore.DeleteServiceInstanceCallCount()).To(Equal(0))
+		})
+
+		It("should recognize a share without the creator metadata which the store records as created by the broker", func() {
+			Expect(fakeAzure.AddFileShare("subscription", "group", "account", "legacy", "")).To(BeTrue())
+			Expect(fakeAzure.AddFileShare("subscription", "group", "account", "shared", "")).To(BeTrue())
+			fakeStore.RetrieveFileShareStub = func(id string) (FileShare, error) {
+				if id == "instance-id-legacy" {
+					return FileShare{InstanceID: "instance-id", FileShareName: "legacy", IsCreated: true}, nil
+				}
+				return FileShare{}, brokerapi.ErrInstanceDoesNotExist
+			}
+			fakeStore.RetrieveFileShareOwnerStub = func(id string) (FileShareOwner, error) {
+				if id == "subscription-group-account-shared" {
+					return FileShareOwner{OwnerFileShareID: "other-instance-id-shared"}, nil
+				}
+				return FileShareOwner{}, brokerapi.ErrInstanceDoesNotExist
+			}
+
+			Expect(deprovision()).To(Succeed())
+			Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeFalse())
+		})
+
+		It("should not recognize a share without the creator metadata which the broker only used", func() {
+			Expect(fakeAzure.AddFileShare("subscription", "group", "account", "legacy", "")).To(BeTrue())
+			fakeStore.RetrieveFileShareReturns(FileShare{InstanceID: "instance-id", FileShareName: "legacy", IsCreated: false}, nil)
+
+			Expect(ErrorCode(deprovision())).To(Equal(ErrCodeForeignFileSharesExist))
+			Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeTrue())
+		})
+
+		It("should fail when the store cannot tell whether the broker created a share", func() {
+			Expect(fakeAzure.AddFileShare("subscription", "group", "account", "legacy", "")).To(BeTrue())
+			fakeStore.RetrieveFileShareOwnerReturns(FileShareOwner{}, errors.New("connection refused"))
+
+			Expect(deprovision()).To(MatchError(ContainSubstring("Failed to retrieve the owner of the file share \"legacy\"")))
+			Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeTrue())
+		})
+
+		Context("when the operator allows the deletion of the foreign file shares", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveFeatureFlagsReturns(map[string]FeatureFlag{FeatureFlagAllowDeleteForeignFileShares: {Enabled: true}}, nil)
+			})
+
+			It("should delete the storage account with a file share which the broker did not create", func() {
+				Expect(fakeAzure.AddFileShare("subscription", "group", "account", "foreign", "someone")).To(BeTrue())
+
+				Expect(deprovision()).To(Succeed())
+				Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeFalse())
+			})
+		})
+	})
+
+	It("should return the access key of the storage account in a service key", func() {
+		fakeStore := &azurefilebrokerfakes.FakeStore{}
+		fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+			ServiceID:               "service-id",
+			PlanID:                  "plan-id",
+			SubscriptionID:          "subscription",
+			ResourceGroupName:       "group",
+			TargetName:              "account",
+			IsCreatedStorageAccount: true,
+			ProvisioningState:       "succeeded",
+		}, nil)
+		fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, brokerapi.ErrInstanceDoesNotExist)
+		fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+		Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+		cloud.Control.AllowServiceKeys = true
+		broker := New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+			NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+			NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+			NewAccessLogConfig("", "", "")))
+
+		binding, err := broker.Bind(context.TODO(), "instance-id", "binding-id", brokerapi.BindDetails{
+			ServiceID:     "service-id",
+			PlanID:        "plan-id",
+			RawParameters: json.RawMessage(`{"share":"data"}`),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binding.VolumeMounts).To(BeEmpty())
+		accessKey, err := sdkClient.GetAccessKey()
+		Expect(err).NotTo(HaveOccurred())
+		credentials := binding.Credentials.(map[string]string)
+		Expect(credentials).To(HaveKeyWithValue("username", "account"))
+		Expect(credentials).To(HaveKeyWithValue("password", accessKey))
+		Expect(credentials).To(HaveKeyWithValue("storage_account_name", "account"))
+		Expect(credentials).To(HaveKeyWithValue("file_share_name", "data"))
+		Expect(credentials["share"]).To(HaveSuffix("/data"))
+	})
+
+	It("should return the binding which another broker creates while the bind waits for the lock of the file share", func() {
+		fakeStore := &azurefilebrokerfakes.FakeStore{}
+		fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+			ServiceID:               "service-id",
+			PlanID:                  "plan-id",
+			SubscriptionID:          "subscription",
+			ResourceGroupName:       "group",
+			TargetName:              "account",
+			IsCreatedStorageAccount: true,
+			ProvisioningState:       "succeeded",
+		}, nil)
+		fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, brokerapi.ErrInstanceDoesNotExist)
+		fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+		Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+		broker := New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+			NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+			NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+			NewAccessLogConfig("", "", "")))
+		bindDetails := brokerapi.BindDetails{
+			ServiceID:     "service-id",
+			PlanID:        "plan-id",
+			AppGUID:       "app-guid",
+			RawParameters: json.RawMessage(`{"share":"data"}`),
+		}
+		_, err := broker.Bind(context.TODO(), "instance-id", "binding-id", bindDetails)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeStore.CreateFileShareCallCount()).To(Equal(1))
+		_, stored := fakeStore.CreateBindingDetailsArgsForCall(0)
+		_, fileShare := fakeStore.CreateFileShareArgsForCall(0)
+
+		// The other broker holds the lock of the file share until its binding is stored
+		fakeStore.RetrieveFileShareReturns(fileShare, nil)
+		fakeStore.GetLockForUpdateStub = func(lockName string, timeoutInSeconds int) error {
+			if lockName == "instance-id-data" {
+				fakeStore.RetrieveBindingDetailsReturns(stored, nil)
+			}
+			return nil
+		}
+		binding, err := broker.Bind(context.TODO(), "instance-id", "binding-id", bindDetails)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binding.VolumeMounts).To(HaveLen(1))
+		Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(1))
+		Expect(fakeStore.CreateFileShareCallCount()).To(Equal(1))
+		Expect(fakeStore.UpdateFileShareCallCount()).To(Equal(0))
+	})
+
+	Context("deletion notifications", func() {
+		var (
+			fakeStore *azurefilebrokerfakes.FakeStore
+			broker    *Broker
+			notifier  *azurefilebrokerfakes.FakeDeletionNotifier
+		)
+
+		BeforeEach(func() {
+			fakeStore = &azurefilebrokerfakes.FakeStore{}
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				ServiceID:               "service-id",
+				PlanID:                  "plan-id",
+				OrganizationGUID:        "org-guid",
+				SpaceGUID:               "space-guid",
+				SubscriptionID:          "subscription",
+				ResourceGroupName:       "group",
+				TargetName:              "account",
+				IsCreatedStorageAccount: true,
+				ProvisioningState:       "succeeded",
+			}, nil)
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, brokerapi.ErrInstanceDoesNotExist)
+			fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+			fakeStore.RetrieveFileShareOwnerReturns(FileShareOwner{}, brokerapi.ErrInstanceDoesNotExist)
+			notifier = &azurefilebrokerfakes.FakeDeletionNotifier{}
+		})
+
+		JustBeforeEach(func() {
+			Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+			broker = New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+				NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+				NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+				NewAccessLogConfig("", "", "")))
+			broker.AddDeletionNotifier(notifier)
+		})
+
+		// serve runs the operation in a request of the platform with the originating identity header if it is set
+		serve := func(header string, operation func(ctx context.Context) error) error {
+			var err error
+			handler := OriginatingIdentityHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				err = operation(r.Context())
+			}))
+			request := httptest.NewRequest("DELETE", "/v2/service_instances/instance-id", nil)
+			if header != "" {
+				request.Header.Set(OriginatingIdentityHeader, header)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+			return err
+		}
+		deprovision := func(header string) error {
+			return serve(header, func(ctx context.Context) error {
+				_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+				return err
+			})
+		}
+		notification := func() DeletionNotification {
+			Eventually(notifier.NotifyCallCount).Should(Equal(1))
+			return notifier.NotifyArgsForCall(0)
+		}
+
+		It("should notify the deletion of the storage account by a deprovision", func() {
+			Expect(deprovision("cloudfoundry eyJ1c2VyX2lkIjoiYWJjIn0=")).To(Succeed())
+			Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeFalse())
+
+			deleted := notification()
+			Expect(deleted.Kind).To(Equal(DeletedResourceStorageAccount))
+			Expect(deleted.Initiator).To(Equal("deprovision"))
+			Expect(deleted.OriginatingIdentity).To(Equal("cloudfoundry user_id=abc"))
+			Expect(deleted.ServiceInstanceID).To(Equal("instance-id"))
+			Expect(deleted.OrganizationGUID).To(Equal("org-guid"))
+			Expect(deleted.SpaceGUID).To(Equal("space-guid"))
+			Expect(deleted.StorageAccountName).To(Equal("account"))
+			Expect(deleted.FileShareName).To(BeEmpty())
+			Expect(deleted.Timestamp).To(Equal(clock.Now().UTC()))
+		})
+
+		It("should notify the deletion of the file share by an unbind", func() {
+			bindDetails := brokerapi.BindDetails{ServiceID: "service-id", PlanID: "plan-id", AppGUID: "app-guid", RawParameters: json.RawMessage(`{"share":"data"}`)}
+			_, err := broker.Bind(context.TODO(), "instance-id", "binding-id", bindDetails)
+			Expect(err).NotTo(HaveOccurred())
+			_, stored := fakeStore.CreateBindingDetailsArgsForCall(0)
+			fakeStore.RetrieveBindingDetailsReturns(stored, nil)
+			_, fileShare := fakeStore.CreateFileShareArgsForCall(0)
+			fakeStore.RetrieveFileShareReturns(fileShare, nil)
+			_, owner := fakeStore.CreateFileShareOwnerArgsForCall(0)
+			fakeStore.RetrieveFileShareOwnerReturns(owner, nil)
+
+			err = serve("cloudfoundry eyJ1c2VyX2lkIjoiYWJjIn0=", func(ctx context.Context) error {
+				return broker.Unbind(ctx, "instance-id", "binding-id", brokerapi.UnbindDetails{})
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeAzure.HasFileShare("subscription", "group", "account", "data")).To(BeFalse())
+
+			deleted := notification()
+			Expect(deleted.Kind).To(Equal(DeletedResourceFileShare))
+			Expect(deleted.Initiator).To(Equal("unbind"))
+			Expect(deleted.OriginatingIdentity).To(Equal("cloudfoundry user_id=abc"))
+			Expect(deleted.ServiceInstanceID).To(Equal("instance-id"))
+			Expect(deleted.StorageAccountName).To(Equal("account"))
+			Expect(deleted.FileShareName).To(Equal("data"))
+		})
+
+		It("should not wait for a notifier which hangs", func() {
+			release := make(chan struct{})
+			defer close(release)
+			notifier.NotifyStub = func(DeletionNotification) error {
+				<-release
+				return nil
+			}
+
+			Expect(deprovision("")).To(Succeed())
+			Eventually(notifier.NotifyCallCount).Should(Equal(1))
+			Expect(fakeStore.DeleteServiceInstanceCallCount()).To(Equal(1))
+		})
+
+		It("should log the failure of a notifier", func() {
+			notifier.NotifyReturns(errors.New("connection refused"))
+
+			Expect(deprovision("")).To(Succeed())
+			Eventually(logger).Should(gbytes.Say("notify-deletion"))
+		})
+
+		Context("when the originating identity is parsed", func() {
+			It("should omit the identity of a request without the header", func() {
+				Expect(deprovision("")).To(Succeed())
+				Expect(notification().OriginatingIdentity).To(BeEmpty())
+			})
+
+			It("should return the decoded value of a platform without a user_id", func() {
+				Expect(deprovision("kubernetes eyJ1c2VybmFtZSI6ImphbmUifQ==")).To(Succeed())
+				Expect(notification().OriginatingIdentity).To(Equal(`kubernetes {"username":"jane"}`))
+			})
+
+			It("should return the header as it is when the value is not base64", func() {
+				Expect(deprovision("cloudfoundry not-base64!")).To(Succeed())
+				Expect(notification().OriginatingIdentity).To(Equal("cloudfoundry not-base64!"))
+			})
+
+			It("should return the header as it is when the value is not JSON", func() {
+				Expect(deprovision("cloudfoundry dXNlcg==")).To(Succeed())
+				Expect(notification().OriginatingIdentity).To(Equal("cloudfoundry dXNlcg=="))
+			})
+
+			It("should return the header as it is when it has no value", func() {
+				Expect(deprovision("cloudfoundry")).To(Succeed())
+				Expect(notification().OriginatingIdentity).To(Equal("cloudfoundry"))
+			})
+		})
+	})
+
+	Context("ownership proof of the existing file shares", func() {
+		var (
+			fakeStore *azurefilebrokerfakes.FakeStore
+			broker    *Broker
+		)
+
+		BeforeEach(func() {
+			fakeStore = &azurefilebrokerfakes.FakeStore{}
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				ServiceID:               "service-id",
+				PlanID:                  "plan-id",
+				SubscriptionID:          "subscription",
+				ResourceGroupName:       "group",
+				TargetName:              "account",
+				IsCreatedStorageAccount: true,
+				ProvisioningState:       "succeeded",
+			}, nil)
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, brokerapi.ErrInstanceDoesNotExist)
+			fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+			cloud.Control.RequireShareOwnershipProof = true
+		})
+
+		JustBeforeEach(func() {
+			Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+			Expect(fakeAzure.AddFileShare("subscription", "group", "account", "data", "someone")).To(BeTrue())
+			Expect(fakeAzure.AddFileShare("subscription", "group", "account", "other", "someone")).To(BeTrue())
+			broker = New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+				NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+				NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+				NewAccessLogConfig("", "", "")))
+		})
+
+		bind := func(parameters string) (brokerapi.Binding, error) {
+			return broker.Bind(context.TODO(), "instance-id", "binding-id", brokerapi.BindDetails{
+				ServiceID:     "service-id",
+				PlanID:        "plan-id",
+				AppGUID:       "app-guid",
+				RawParameters: json.RawMessage(parameters),
+			})
+		}
+
+		It("should refuse to bind to an existing file share without a SAS token", func() {
+			_, err := bind(`{"share":"data"}`)
+			Expect(ErrorCode(err)).To(Equal(ErrCodeShareOwnershipNotProven))
+			Expect(err.Error()).To(ContainSubstring(`The file share "data" exists in the storage account "account" and the administrator requires the parameter share_sas`))
+			Expect(fakeStore.CreateFileShareCallCount()).To(Equal(0))
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+		})
+
+		It("should refuse to bind with a SAS token of another file share", func() {
+			token, err := restClient.GetFileShareReadSAS("other", clock.Now().Add(time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = bind(`{"share":"data","share_sas":"` + token + `"}`)
+			Expect(ErrorCode(err)).To(Equal(ErrCodeShareOwnershipNotProven))
+			Expect(err.Error()).To(ContainSubstring(`The parameter share_sas does not grant access to the file share "data" in the storage account "account"`))
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+		})
+
+		It("should bind with a SAS token of the file share", func() {
+			token, err := restClient.GetFileShareReadSAS("data", clock.Now().Add(time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+
+			binding, err := bind(`{"share":"data","share_sas":"` + token + `"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.VolumeMounts).To(HaveLen(1))
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(1))
+			_, stored := fakeStore.CreateBindingDetailsArgsForCall(0)
+			Expect(stored.BindOptions.ShareSAS).To(BeEmpty())
+		})
+
+		It("should bind to a file share which the broker creates without a SAS token", func() {
+			binding, err := bind(`{"share":"new"}`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.VolumeMounts).To(HaveLen(1))
+		})
+	})
+
+	Context("bound apps metadata of the file shares", func() {
+		var (
+			fakeStore *azurefilebrokerfakes.FakeStore
+			broker    *Broker
+			mutex     sync.Mutex
+			bindings  map[string]BindingDetails
+		)
+
+		BeforeEach(func() {
+			fakeStore = &azurefilebrokerfakes.FakeStore{}
+			serviceInstance := ServiceInstance{
+				ServiceID:               "service-id",
+				PlanID:                  "plan-id",
+				SubscriptionID:          "subscription",
+				ResourceGroupName:       "group",
+				TargetName:              "account",
+				IsCreatedStorageAccount: true,
+				ProvisioningState:       "succeeded",
+			}
+			fakeStore.RetrieveServiceInstanceReturns(serviceInstance, nil)
+			otherGroupInstance := serviceInstance
+			otherGroupInstance.ResourceGroupName = "other-group"
+			fakeStore.RetrieveServiceInstancesByTargetNameReturns(map[string]ServiceInstance{
+				"instance-id":    serviceInstance,
+				"other-id":       serviceInstance,
+				"other-group-id": otherGroupInstance,
+				"preexisting-id": {IsPreexisting: true, TargetName: "account"},
+			}, nil)
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, brokerapi.ErrInstanceDoesNotExist)
+			fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+			bindings = map[string]BindingDetails{
+				"binding-web":         {BindDetails: brokerapi.BindDetails{AppGUID: "app-b", RawContext: json.RawMessage(`{"app_name":"web"}`)}, FileShareID: "instance-id-data"},
+				"binding-other":       {BindDetails: brokerapi.BindDetails{AppGUID: "app-a"}, FileShareID: "other-id-data"},
+				"binding-service-key": {FileShareID: "instance-id-data"},
+				"binding-logs":        {BindDetails: brokerapi.BindDetails{AppGUID: "app-d"}, FileShareID: "instance-id-logs"},
+				"binding-other-group": {BindDetails: brokerapi.BindDetails{AppGUID: "app-e"}, FileShareID: "other-group-id-data"},
+			}
+			fakeStore.CreateBindingDetailsStub = func(id string, details BindingDetails) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				bindings[id] = details
+				return nil
+			}
+			fakeStore.RetrieveAllBindingDetailsStub = func() (map[string]BindingDetails, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				copied := map[string]BindingDetails{}
+				for id, details := range bindings {
+					copied[id] = details
+				}
+				return copied, nil
+			}
+		})
+
+		JustBeforeEach(func() {
+			Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+			broker = New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+				NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+				NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+				NewAccessLogConfig("", "", "")))
+		})
+
+		bind := func() error {
+			_, err := broker.Bind(context.TODO(), "instance-id", "binding-worker", brokerapi.BindDetails{
+				ServiceID:     "service-id",
+				PlanID:        "plan-id",
+				AppGUID:       "app-c",
+				RawContext:    json.RawMessage(`{"app_name":"worker"}`),
+				RawParameters: json.RawMessage(`{"share":"data"}`),
+			})
+			return err
+		}
+		// boundApps returns the metadata of the bound apps of the file share, or "missing" if it is not set
+		boundApps := func() string {
+			value, found := fakeAzure.FileShareMetadata("subscription", "group", "account", "data", "bound_apps")
+			if !found {
+				return "missing"
+			}
+			return value
+		}
+
+		It("should list the apps bound to the file share through every instance of the storage account", func() {
+			Expect(bind()).To(Succeed())
+			Expect(boundApps()).To(Equal("app-a,app-b(web),app-c(worker)"))
+		})
+
+		It("should leave out the binding which is being deleted", func() {
+			Expect(bind()).To(Succeed())
+			fakeStore.RetrieveBindingDetailsReturns(bindings["binding-web"], nil)
+			fakeStore.RetrieveFileShareReturns(FileShare{InstanceID: "instance-id", FileShareName: "data", IsCreated: true, Count: 2}, nil)
+
+			Expect(broker.Unbind(context.TODO(), "instance-id", "binding-web", brokerapi.UnbindDetails{})).To(Succeed())
+			Expect(boundApps()).To(Equal("app-a,app-c(worker)"))
+		})
+
+		Context("when the last binding of a file share which is kept is deleted", func() {
+			BeforeEach(func() {
+				cloud.Control.AllowDeleteFileShare = false
+				bindings = map[string]BindingDetails{}
+			})
+
+			It("should remove the metadata", func() {
+				Expect(bind()).To(Succeed())
+				Expect(boundApps()).To(Equal("app-c(worker)"))
+				fakeStore.RetrieveBindingDetailsReturns(bindings["binding-worker"], nil)
+				fakeStore.RetrieveFileShareReturns(FileShare{InstanceID: "instance-id", FileShareName: "data", IsCreated: true, Count: 1}, nil)
+
+				Expect(broker.Unbind(context.TODO(), "instance-id", "binding-worker", brokerapi.UnbindDetails{})).To(Succeed())
+				Expect(fakeAzure.HasFileShare("subscription", "group", "account", "data")).To(BeTrue())
+				Expect(boundApps()).To(Equal("missing"))
+			})
+		})
+
+		Context("when the store fails to return the bindings", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveAllBindingDetailsStub = nil
+				fakeStore.RetrieveAllBindingDetailsReturns(nil, errors.New("connection refused"))
+			})
+
+			It("should bind and leave the metadata unchanged", func() {
+				Expect(bind()).To(Succeed())
+				Expect(boundApps()).To(Equal("missing"))
+				Expect(logger.LogMessages()).To(ContainElement(ContainSubstring("update-bound-apps-metadata.retrieve-bindings")))
+			})
+		})
+	})
+
+	Context("asynchronous provision and deprovision", func() {
+		var (
+			fakeStore *azurefilebrokerfakes.FakeStore
+			broker    *Broker
+			mutex     sync.Mutex
+			instances map[string]ServiceInstance
+		)
+
+		BeforeEach(func() {
+			fakeStore = &azurefilebrokerfakes.FakeStore{}
+			instances = map[string]ServiceInstance{}
+			putInstance := func(id string, instance ServiceInstance) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				instances[id] = instance
+				return nil
+			}
+			fakeStore.CreateServiceInstanceStub = putInstance
+			fakeStore.UpdateServiceInstanceStub = putInstance
+			fakeStore.RetrieveServiceInstanceStub = func(id string) (ServiceInstance, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				instance, ok := instances[id]
+				if !ok {
+					return ServiceInstance{}, brokerapi.ErrInstanceDoesNotExist
+				}
+				return instance, nil
+			}
+			fakeStore.DeleteServiceInstanceStub = func(id string) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				delete(instances, id)
+				return nil
+			}
+			fakeAzure.SetOperationPolls(2)
+		})
+
+		JustBeforeEach(func() {
+			broker = New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+				NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+				NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+				NewAccessLogConfig("", "", "")))
+		})
+
+		provision := func(asyncAllowed bool) (brokerapi.ProvisionedServiceSpec, error) {
+			return broker.Provision(context.TODO(), "instance-id", brokerapi.ProvisionDetails{
+				ServiceID:     "service-id",
+				PlanID:        "plan-id",
+				RawParameters: json.RawMessage(`{"subscription_id":"subscription","resource_group_name":"group","storage_account_name":"account","location":"westus"}`),
+			}, asyncAllowed)
+		}
+		instance := func() (ServiceInstance, bool) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			serviceInstance, ok := instances["instance-id"]
+			return serviceInstance, ok
+		}
+
+		It("should accept the provision and finish it in the last operation once the storage account is created", func() {
+			spec, err := provision(true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.IsAsync).To(BeTrue())
+			Expect(spec.OperationData).NotTo(BeEmpty())
+			serviceInstance, _ := instance()
+			Expect(serviceInstance.ProvisioningState).To(Equal("creating"))
+			Expect(serviceInstance.OperationURL).To(Equal(spec.OperationData))
+			Expect(serviceInstance.IsCreatedStorageAccount).To(BeTrue())
+
+			lastOperation, err := broker.LastOperation(context.TODO(), "instance-id", spec.OperationData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastOperation.State).To(Equal(brokerapi.InProgress))
+			serviceInstance, _ = instance()
+			Expect(serviceInstance.ProvisioningState).To(Equal("creating"))
+
+			lastOperation, err = broker.LastOperation(context.TODO(), "instance-id", spec.OperationData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastOperation.State).To(Equal(brokerapi.Succeeded))
+			serviceInstance, _ = instance()
+			Expect(serviceInstance.ProvisioningState).To(Equal("succeeded"))
+			Expect(sdkClient.CreateFileShare("data")).To(Succeed())
+		})
+
+		It("should require an asynchronous request when the creation does not fit in the synchronous budget", func() {
+			_, err := provision(false)
+			Expect(err).To(Equal(brokerapi.ErrAsyncRequired))
+			Expect(instance()).To(BeZero())
+			Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeFalse())
+		})
+
+		Context("when the synchronous budget is below the estimated creation time", func() {
+			BeforeEach(func() {
+				cloud.Control.SynchronousBudget = 10 * time.Second
+			})
+
+			It("should require an asynchronous request", func() {
+				_, err := provision(false)
+				Expect(err).To(Equal(brokerapi.ErrAsyncRequired))
+				Expect(instance()).To(BeZero())
+				Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeFalse())
+			})
+		})
+
+		Context("when the synchronous budget covers the estimated creation time", func() {
+			BeforeEach(func() {
+				cloud.Control.SynchronousBudget = time.Minute
+				fakeAzure.SetOperationPolls(0)
+			})
+
+			It("should create the storage account synchronously", func() {
+				spec, err := provision(false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.IsAsync).To(BeFalse())
+				serviceInstance, _ := instance()
+				Expect(serviceInstance.ProvisioningState).To(Equal("succeeded"))
+				Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeTrue())
+			})
+		})
+
+		It("should fail the provision when the operation of the creation fails", func() {
+			spec, err := provision(true)
+			Expect(err).NotTo(HaveOccurred())
+			fakeAzure.Fail("CheckCompletion", 1, errors.New("Error Code: 500"))
+
+			lastOperation, err := broker.LastOperation(context.TODO(), "instance-id", spec.OperationData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastOperation.State).To(Equal(brokerapi.Failed))
+			Expect(lastOperation.Description).To(ContainSubstring("account"))
+			serviceInstance, _ := instance()
+			Expect(serviceInstance.ProvisioningState).To(Equal("failed"))
+			Expect(serviceInstance.OperationError).To(Equal(lastOperation.Description))
+
+			// The stored state answers the next polls without asking Azure again
+			lastOperation, err = broker.LastOperation(context.TODO(), "instance-id", spec.OperationData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastOperation.State).To(Equal(brokerapi.Failed))
+			Expect(lastOperation.Description).To(Equal(serviceInstance.OperationError))
+		})
+
+		Context("when the subscription and the resource group are checked", func() {
+			var fakeRESTClient *azurefilebrokerfakes.FakeAzureStorageAccountRESTClient
+
+			BeforeEach(func() {
+				fakeRESTClient = &azurefilebrokerfakes.FakeAzureStorageAccountRESTClient{}
+				fakeRESTClient.SubscriptionExistsReturns(true, nil)
+				fakeRESTClient.ResourceGroupExistsReturns(true, nil)
+				cloud.SetAzureBackend(restClientBackend{FakeAzure: fakeAzure, restClient: fakeRESTClient})
+			})
+
+			It("should not check them for an existing storage account", func() {
+				fakeAzure.AddStorageAccount("subscription", "group", "account", "westus")
+
+				_, err := provision(false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeRESTClient.SubscriptionExistsCallCount()).To(Equal(0))
+				Expect(fakeRESTClient.ResourceGroupExistsCallCount()).To(Equal(0))
+			})
+
+			It("should refuse the provision when the subscription does not exist", func() {
+				fakeRESTClient.SubscriptionExistsReturns(false, nil)
+
+				_, err := provision(true)
+				Expect(ErrorCode(err)).To(Equal(ErrCodeSubscriptionNotAccessible))
+				Expect(err.Error()).To(ContainSubstring(`The subscription "subscription" does not exist`))
+				Expect(fakeRESTClient.ResourceGroupExistsCallCount()).To(Equal(0))
+				Expect(fakeRESTClient.CreateStorageAccountCallCount()).To(Equal(0))
+				Expect(instance()).To(BeZero())
+			})
+
+			It("should refuse the provision when the resource group does not exist", func() {
+				fakeRESTClient.ResourceGroupExistsReturns(false, nil)
+
+				_, err := provision(true)
+				Expect(ErrorCode(err)).To(Equal(ErrCodeResourceGroupNotFound))
+				Expect(err.Error()).To(ContainSubstring(`The resource group "group" does not exist in the subscription "subscription"`))
+				Expect(fakeRESTClient.CreateStorageAccountCallCount()).To(Equal(0))
+			})
+
+			It("should report an authorization failure when the subscription may not be read", func() {
+				fakeRESTClient.SubscriptionExistsReturns(false, errors.New("Error Code: 403, AuthorizationFailed: The client does not have authorization"))
+
+				_, err := provision(true)
+				Expect(ErrorCode(err)).To(Equal(ErrCodeAzureAuthorizationFailed))
+				Expect(err.Error()).To(ContainSubstring(`Failed to check whether the subscription "subscription" is accessible`))
+				Expect(err.Error()).To(ContainSubstring("Ask the operator to grant the service principal access"))
+				Expect(fakeRESTClient.CreateStorageAccountCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the quota of the storage accounts is checked", func() {
+			It("should refuse the creation when the subscription has reached its limit", func() {
+				fakeAzure.AddStorageAccount("subscription", "group", "other", "westus")
+				fakeAzure.SetStorageAccountLimit(1)
+
+				_, err := provision(true)
+				Expect(ErrorCode(err)).To(Equal(ErrCodeStorageAccountQuotaExceeded))
+				Expect(err.Error()).To(ContainSubstring(`The storage account "account" cannot be created because the subscription "subscription" already has 1 of 1 storage accounts`))
+				Expect(err.Error()).To(ContainSubstring("Delete unused storage accounts in the subscription or request a quota increase"))
+				Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeFalse())
+				Expect(instance()).To(BeZero())
+			})
+
+			Context("when Azure does not report the usage", func() {
+				var fakeRESTClient *azurefilebrokerfakes.FakeAzureStorageAccountRESTClient
+
+				BeforeEach(func() {
+					fakeRESTClient = &azurefilebrokerfakes.FakeAzureStorageAccountRESTClient{}
+					fakeRESTClient.SubscriptionExistsReturns(true, nil)
+					fakeRESTClient.ResourceGroupExistsReturns(true, nil)
+					fakeRESTClient.CreateStorageAccountReturns("", nil)
+					cloud.SetAzureBackend(restClientBackend{FakeAzure: fakeAzure, restClient: fakeRESTClient})
+				})
+
+				It("should create the storage account without a limit", func() {
+					fakeRESTClient.GetStorageAccountUsageReturns(0, 0, nil)
+
+					_, err := provision(true)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fakeRESTClient.GetStorageAccountUsageCallCount()).To(Equal(1))
+					Expect(fakeRESTClient.CreateStorageAccountCallCount()).To(Equal(1))
+				})
+
+				It("should create the storage account when the usage cannot be read", func() {
+					fakeRESTClient.GetStorageAccountUsageReturns(0, 0, errors.New("Error Code: 500, InternalError: try again"))
+
+					_, err := provision(true)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fakeRESTClient.CreateStorageAccountCallCount()).To(Equal(1))
+					Expect(logger).To(gbytes.Say("get-storage-account-usage"))
+				})
+			})
+
+			It("should add the hint to the quota error of Azure Resource Manager", func() {
+				fakeAzure.Fail("CreateStorageAccount", 1, errors.New("Error Code: 409, StorageAccountQuotaExceeded: The subscription has reached its limit"))
+
+				_, err := provision(true)
+				Expect(ErrorCode(err)).To(Equal(ErrCodeStorageAccountQuotaExceeded))
+				Expect(err.Error()).To(ContainSubstring("the storage account quota of the subscription is exceeded"))
+				Expect(err.Error()).To(ContainSubstring("Delete unused storage accounts in the subscription or request a quota increase"))
+				Expect(err.Error()).To(ContainSubstring("The subscription has reached its limit"))
+			})
+
+			It("should add the hint to the capacity error of Azure Resource Manager", func() {
+				fakeAzure.Fail("CreateStorageAccount", 1, errors.New("Error Code: 409, SkuNotAvailable: The SKU is not available in westus"))
+
+				_, err := provision(true)
+				Expect(ErrorCode(err)).To(Equal(ErrCodeLocationCapacityUnavailable))
+				Expect(err.Error()).To(ContainSubstring(`the location "westus" has no capacity for it. Use another location or SKU, or retry later.`))
+			})
+		})
+
+		Context("when the storage account is created", func() {
+			JustBeforeEach(func() {
+				spec, err := provision(true)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(func() (brokerapi.LastOperationState, error) {
+					lastOperation, err := broker.LastOperation(context.TODO(), "instance-id", spec.OperationData)
+					return lastOperation.State, err
+				}).Should(Equal(brokerapi.Succeeded))
+			})
+
+			It("should accept the deprovision and return gone once the storage account is deleted", func() {
+				spec, err := broker.Deprovision(context.TODO(), "instance-id", brokerapi.DeprovisionDetails{}, true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(spec.IsAsync).To(BeTrue())
+				Expect(spec.OperationData).To(Equal("deprovision"))
+				serviceInstance, _ := instance()
+				Expect(serviceInstance.ProvisioningState).To(Equal("deleting"))
+				Expect(serviceInstance.OperationURL).NotTo(BeEmpty())
+
+				lastOperation, err := broker.LastOperation(context.TODO(), "instance-id", "deprovision")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lastOperation.State).To(Equal(brokerapi.InProgress))
+				Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeTrue())
+
+				lastOperation, err = broker.LastOperation(context.TODO(), "instance-id", "deprovision")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lastOperation.State).To(Equal(brokerapi.Succeeded))
+				Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeFalse())
+				Expect(instance()).To(BeZero())
+
+				_, err = broker.LastOperation(context.TODO(), "instance-id", "deprovision")
+				Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+			})
+
+			It("should keep the instance when the operation of the deletion fails", func() {
+				_, err := broker.Deprovision(context.TODO(), "instance-id", brokerapi.DeprovisionDetails{}, true)
+				Expect(err).NotTo(HaveOccurred())
+				fakeAzure.Fail("CheckCompletion", 1, errors.New("Error Code: 500"))
+
+				lastOperation, err := broker.LastOperation(context.TODO(), "instance-id", "deprovision")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lastOperation.State).To(Equal(brokerapi.Failed))
+				serviceInstance, _ := instance()
+				Expect(serviceInstance.ProvisioningState).To(Equal("succeeded"))
+				Expect(serviceInstance.OperationURL).To(BeEmpty())
+				Expect(fakeAzure.HasStorageAccount("subscription", "group", "account")).To(BeTrue())
+			})
+		})
+
+		It("should not recognize an empty operation", func() {
+			_, err := broker.LastOperation(context.TODO(), "instance-id", "")
+			Expect(ErrorCode(err)).To(Equal(ErrCodeOperationUnrecognized))
+		})
+	})
+
+	Context("asynchronous bind and unbind", func() {
+		var (
+			fakeStore  *azurefilebrokerfakes.FakeStore
+			broker     *Broker
+			handler    http.Handler
+			mutex      sync.Mutex
+			operations []InstanceOperation
+		)
+
+		BeforeEach(func() {
+			fakeStore = &azurefilebrokerfakes.FakeStore{}
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				ServiceID:               "service-id",
+				PlanID:                  "plan-id",
+				SubscriptionID:          "subscription",
+				ResourceGroupName:       "group",
+				TargetName:              "account",
+				IsCreatedStorageAccount: true,
+				ProvisioningState:       "succeeded",
+			}, nil)
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, brokerapi.ErrInstanceDoesNotExist)
+			fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+			operations = nil
+			fakeStore.CreateInstanceOperationStub = func(id string, operation InstanceOperation) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				operations = append(operations, operation)
+				return nil
+			}
+			fakeStore.RetrieveInstanceOperationsStub = func(instanceID string) ([]InstanceOperation, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				return append([]InstanceOperation{}, operations...), nil
+			}
+		})
+
+		JustBeforeEach(func() {
+			Expect(restClient.CreateStorageAccount()).To(BeEmpty())
+			broker = New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+				NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+				NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+				NewAccessLogConfig("", "", "")))
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			})
+			handler = broker.AsyncBindingHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"}, next)
+		})
+
+		serve := func(method, path, body string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(method, path, strings.NewReader(body))
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			return recorder
+		}
+		lastOperation := func() string {
+			return serve("GET", "/v2/service_instances/instance-id/service_bindings/binding-id/last_operation", "").Body.String()
+		}
+
+		It("should bind in the background and return the binding once it succeeds", func() {
+			recorder := serve("PUT", "/v2/service_instances/instance-id/service_bindings/binding-id?accepts_incomplete=true",
+				`{"service_id":"service-id","plan_id":"plan-id","app_guid":"app-guid","parameters":{"share":"share"}}`)
+			Expect(recorder.Code).To(Equal(http.StatusAccepted))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"operation":"bind"}`))
+
+			Eventually(lastOperation).Should(MatchJSON(`{"state":"succeeded"}`))
+			Expect(fakeAzure.HasFileShare("subscription", "group", "account", "share")).To(BeTrue())
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(1))
+
+			By("fetching the binding")
+			_, bindingDetails := fakeStore.CreateBindingDetailsArgsForCall(0)
+			fakeStore.RetrieveBindingDetailsReturns(bindingDetails, nil)
+			_, fileShare := fakeStore.CreateFileShareArgsForCall(0)
+			fakeStore.RetrieveFileShareReturns(fileShare, nil)
+			binding, err := broker.GetBinding("instance-id", "binding-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(binding.VolumeMounts).To(HaveLen(1))
+			Expect(binding.VolumeMounts[0].Device.MountConfig).To(HaveKeyWithValue("source", "//account.file.core.windows.net/share"))
+			Expect(binding.VolumeMounts[0].Device.MountConfig).To(HaveKey("password"))
+			Expect(binding.Parameters.FileShareName).To(Equal("share"))
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(1))
+		})
+
+		It("should unbind in the background", func() {
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{
+				BindOptions: &BindOptions{FileShareName: "share"},
+				FileShareID: "instance-id-share",
+			}, nil)
+			fakeStore.RetrieveFileShareReturns(FileShare{InstanceID: "instance-id", FileShareName: "share", IsCreated: true, Count: 1}, nil)
+			Expect(sdkClient.CreateFileShare("share")).To(Succeed())
+
+			recorder := serve("DELETE", "/v2/service_instances/instance-id/service_bindings/binding-id?accepts_incomplete=true&service_id=service-id&plan_id=plan-id", "")
+			Expect(recorder.Code).To(Equal(http.StatusAccepted))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"operation":"unbind"}`))
+
+			Eventually(lastOperation).Should(MatchJSON(`{"state":"succeeded"}`))
+			Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(1))
+		})
+
+		It("should return the error of a bind which fails after it is accepted", func() {
+			fakeAzure.Fail("CreateFileShare", 1, azurefilebrokerfakes.FakeAzureFailures["authorization-failed"])
+
+			recorder := serve("PUT", "/v2/service_instances/instance-id/service_bindings/binding-id?accepts_incomplete=true",
+				`{"service_id":"service-id","plan_id":"plan-id","app_guid":"app-guid","parameters":{"share":"share"}}`)
+			Expect(recorder.Code).To(Equal(http.StatusAccepted))
+
+			Eventually(lastOperation).Should(ContainSubstring(`"state":"failed"`))
+			var response struct {
+				State       string `json:"state"`
+				Description string `json:"description"`
+			}
+			Expect(json.Unmarshal([]byte(lastOperation()), &response)).To(Succeed())
+			Expect(response.Description).To(ContainSubstring("AuthorizationFailed"))
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+		})
+
+		It("should keep the admission slot of the bind until it ends in the background", func() {
+			unblock := make(chan struct{})
+			fakeStore.CreateFileShareStub = func(string, FileShare) error {
+				<-unblock
+				return nil
+			}
+			handler = broker.AdmissionControl(NewAdmissionConfig(1, 0, time.Second, time.Second), handler)
+
+			bind := func(bindingID string) int {
+				return serve("PUT", "/v2/service_instances/instance-id/service_bindings/"+bindingID+"?accepts_incomplete=true",
+					`{"service_id":"service-id","plan_id":"plan-id","app_guid":"app-guid","parameters":{"share":"share"}}`).Code
+			}
+			Expect(bind("binding-id")).To(Equal(http.StatusAccepted))
+			Expect(bind("other-binding-id")).To(Equal(http.StatusServiceUnavailable))
+
+			close(unblock)
+			Eventually(lastOperation).Should(MatchJSON(`{"state":"succeeded"}`))
+			Eventually(func() int { return bind("other-binding-id") }).Should(Equal(http.StatusAccepted))
+		})
+
+		It("should fail an operation which was interrupted", func() {
+			operations = []InstanceOperation{{
+				InstanceID: "instance-id",
+				Operation:  "bind",
+				BindingID:  "binding-id",
+				Result:     "in progress",
+				StartedAt:  clock.Now().Add(-2 * time.Hour),
+			}}
+			Expect(lastOperation()).To(MatchJSON(`{"state":"failed","description":"The bind was interrupted. Try again"}`))
+		})
+
+		It("should return 404 for a binding without any operation", func() {
+			recorder := serve("GET", "/v2/service_instances/instance-id/service_bindings/binding-id/last_operation", "")
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should pass the synchronous binds and the binds of the preexisting shares to the next handler", func() {
+			Expect(serve("PUT", "/v2/service_instances/instance-id/service_bindings/binding-id", `{"app_guid":"app-guid"}`).Code).To(Equal(http.StatusTeapot))
+
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{IsPreexisting: true, TargetName: "//server/share"}, nil)
+			Expect(serve("PUT", "/v2/service_instances/instance-id/service_bindings/binding-id?accepts_incomplete=true", `{"app_guid":"app-guid"}`).Code).To(Equal(http.StatusTeapot))
+			Expect(fakeStore.CreateInstanceOperationCallCount()).To(Equal(0))
+		})
+	})
+
+	It("should stop the calls once the context of the storage account is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		storageAccount.Context = ctx
+		_, err := sdkClient.Exists()
+		Expect(ErrorCode(err)).To(Equal(ErrCodeOperationTimedOut))
+	})
+})
+
+// restClientBackend serves the storage accounts of the fake Azure with a REST client whose answers are set by the test
+type restClientBackend struct {
+	*azurefilebrokerfakes.FakeAzure
+	restClient AzureStorageAccountRESTClient
+}
+
+func (b restClientBackend) NewRESTClient(logger lager.Logger, cloudConfig *CloudConfig, storageAccount *StorageAccount) (AzureStorageAccountRESTClient, error) {
+	return b.restClient, nil
+}
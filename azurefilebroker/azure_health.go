@@ -0,0 +1,153 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+type azureRequestOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+// AzureHealth keeps the outcomes of the requests to Azure Resource Manager over a sliding window. The broker only uses
+// the existing resources while it is degraded, i.e. it neither creates nor deletes storage accounts and file shares,
+// so that an outage of Azure does not leave half-created resources behind.
+type AzureHealth struct {
+	logger               lager.Logger
+	clock                clock.Clock
+	window               time.Duration
+	failureRateThreshold float64
+	minRequests          int
+
+	mutex    sync.Mutex
+	outcomes []azureRequestOutcome
+	degraded bool
+	metrics  Metrics
+}
+
+// NewAzureHealth returns the health which is degraded when at least failureRateThreshold of minRequests or more
+// requests in the window fail. It recovers when no request in the window failed or the failure rate is below half
+// of the threshold.
+func NewAzureHealth(logger lager.Logger, clock clock.Clock, window time.Duration, failureRateThreshold float64, minRequests int) (*AzureHealth, error) {
+	if failureRateThreshold <= 0 || failureRateThreshold > 1 {
+		return nil, fmt.Errorf("Invalid failure rate threshold %g of Azure: expected a value greater than 0 and up to 1", failureRateThreshold)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("Invalid window %s of the health of Azure: expected a positive duration", window)
+	}
+	if minRequests < 1 {
+		return nil, fmt.Errorf("Invalid minimum number %d of requests of the health of Azure: expected at least 1", minRequests)
+	}
+	return &AzureHealth{
+		logger:               logger.Session("azure-health"),
+		clock:                clock,
+		window:               window,
+		failureRateThreshold: failureRateThreshold,
+		minRequests:          minRequests,
+	}, nil
+}
+
+// isAzureFailure returns true if the response shows that Azure fails rather than that the request is wrong
+func isAzureFailure(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// Record records the outcome of a request to Azure Resource Manager. A request without a response has the status
+// code 0.
+func (h *AzureHealth) Record(err error, statusCode int) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.outcomes = append(h.outcomes, azureRequestOutcome{at: h.clock.Now(), failed: err != nil || isAzureFailure(statusCode)})
+	h.evaluate()
+}
+
+// Degraded returns true while the broker only uses the existing resources
+func (h *AzureHealth) Degraded() bool {
+	if h == nil {
+		return false
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.evaluate()
+	return h.degraded
+}
+
+// evaluate forgets the outcomes before the window and switches the mode. It is called with the mutex.
+func (h *AzureHealth) evaluate() {
+	start := h.clock.Now().Add(-h.window)
+	kept := 0
+	for kept < len(h.outcomes) && h.outcomes[kept].at.Before(start) {
+		kept++
+	}
+	h.outcomes = h.outcomes[kept:]
+
+	failures := 0
+	for _, outcome := range h.outcomes {
+		if outcome.failed {
+			failures++
+		}
+	}
+	requests := len(h.outcomes)
+	failureRate := 0.0
+	if requests > 0 {
+		failureRate = float64(failures) / float64(requests)
+	}
+
+	data := lager.Data{"requests": requests, "failures": failures, "failureRate": failureRate, "window": h.window.String()}
+	switch {
+	case !h.degraded && requests >= h.minRequests && failureRate >= h.failureRateThreshold:
+		h.degraded = true
+		h.logger.Error("degraded-existing-resources-only", fmt.Errorf("%d of %d requests to Azure failed", failures, requests), data)
+		h.setGauge()
+	case h.degraded && (failures == 0 || (requests >= h.minRequests && failureRate < h.failureRateThreshold/2)):
+		h.degraded = false
+		h.logger.Info("recovered", data)
+		h.setGauge()
+	}
+}
+
+func (h *AzureHealth) setGauge() {
+	if h.metrics == nil {
+		return
+	}
+	value := 0.0
+	if h.degraded {
+		value = 1
+	}
+	h.metrics.SetGauge(metricAzureDegraded, nil, value)
+}
+
+func (h *AzureHealth) setMetrics(metrics Metrics) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.metrics = metrics
+	h.setGauge()
+}
+
+// SetAzureHealth stops the creations and the deletions of the broker while the requests to Azure fail
+func (b *Broker) SetAzureHealth(health *AzureHealth) {
+	b.health = health
+	if b.metrics != nil {
+		health.setMetrics(b.metrics)
+	}
+}
+
+// creationRefusal returns why a resource is not created when the control config does not allow it
+func (b *Broker) creationRefusal() string {
+	if b.health.Degraded() {
+		return "the broker does not create it while the requests to Azure fail"
+	}
+	return "the administrator does not allow to create it automatically"
+}
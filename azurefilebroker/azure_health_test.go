@@ -0,0 +1,82 @@
+package azurefilebroker_test
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	. "code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AzureHealth", func() {
+	var (
+		logger *lagertest.TestLogger
+		clock  *fakeclock.FakeClock
+		health *AzureHealth
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-azure-health")
+		clock = fakeclock.NewFakeClock(time.Now())
+		var err error
+		health, err = NewAzureHealth(logger, clock, 5*time.Minute, 0.5, 4)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should refuse an invalid threshold", func() {
+		_, err := NewAzureHealth(logger, clock, 5*time.Minute, 1.5, 4)
+		Expect(err).To(MatchError("Invalid failure rate threshold 1.5 of Azure: expected a value greater than 0 and up to 1"))
+	})
+
+	It("should not be degraded by the failures of fewer requests than the minimum", func() {
+		health.Record(errors.New("connection reset"), 0)
+		health.Record(nil, http.StatusServiceUnavailable)
+		health.Record(nil, http.StatusInternalServerError)
+		Expect(health.Degraded()).To(BeFalse())
+	})
+
+	It("should not count the errors of the requests as failures of Azure", func() {
+		for i := 0; i < 4; i++ {
+			health.Record(nil, http.StatusNotFound)
+		}
+		Expect(health.Degraded()).To(BeFalse())
+	})
+
+	It("should be degraded when the failure rate reaches the threshold", func() {
+		health.Record(nil, http.StatusOK)
+		health.Record(nil, http.StatusOK)
+		health.Record(nil, http.StatusTooManyRequests)
+		Expect(health.Degraded()).To(BeFalse())
+		health.Record(errors.New("connection reset"), 0)
+		Expect(health.Degraded()).To(BeTrue())
+	})
+
+	Context("when it is degraded", func() {
+		BeforeEach(func() {
+			for i := 0; i < 4; i++ {
+				health.Record(nil, http.StatusBadGateway)
+			}
+			Expect(health.Degraded()).To(BeTrue())
+		})
+
+		It("should recover when the failure rate is below half of the threshold", func() {
+			for i := 0; i < 8; i++ {
+				health.Record(nil, http.StatusOK)
+			}
+			Expect(health.Degraded()).To(BeTrue())
+			for i := 0; i < 5; i++ {
+				health.Record(nil, http.StatusOK)
+			}
+			Expect(health.Degraded()).To(BeFalse())
+		})
+
+		It("should recover when the failures are out of the window", func() {
+			clock.Increment(6 * time.Minute)
+			Expect(health.Degraded()).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,62 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// The headers of the responses of Azure Resource Manager and the file service which Azure support asks for
+const (
+	headerRequestID            = "x-ms-request-id"
+	headerCorrelationRequestID = "x-ms-correlation-request-id"
+)
+
+// azureRequestIDs returns the request IDs in the headers of an Azure response to log them
+func azureRequestIDs(header http.Header) lager.Data {
+	data := lager.Data{}
+	if requestID := header.Get(headerRequestID); requestID != "" {
+		data["requestID"] = requestID
+	}
+	if correlationRequestID := header.Get(headerCorrelationRequestID); correlationRequestID != "" {
+		data["correlationRequestID"] = correlationRequestID
+	}
+	return data
+}
+
+// WithAzureRequestIDs appends the request IDs in the headers of the Azure response to the message of the error so that
+// a support ticket can be filed for the failed request. The error is returned as is if the response has no request IDs.
+func WithAzureRequestIDs(err error, header http.Header) error {
+	if err == nil {
+		return nil
+	}
+	ids := []string{}
+	for _, name := range []string{headerRequestID, headerCorrelationRequestID} {
+		if value := header.Get(name); value != "" {
+			ids = append(ids, fmt.Sprintf("%s: %s", name, value))
+		}
+	}
+	if len(ids) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s (%s)", err.Error(), strings.Join(ids, ", "))
+}
+
+// azureSDKError appends the request IDs of the failed response to an error of the Azure management SDK. The errors of
+// the file service already have the request ID in their messages.
+func azureSDKError(err error) error {
+	var response *http.Response
+	switch e := err.(type) {
+	case autorest.DetailedError:
+		response = e.Response
+	case *autorest.DetailedError:
+		response = e.Response
+	}
+	if response == nil {
+		return err
+	}
+	return WithAzureRequestIDs(err, response.Header)
+}
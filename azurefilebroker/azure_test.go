@@ -0,0 +1,46 @@
+package azurefilebroker_test
+
+import (
+	. "code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewStorageAccount", func() {
+	var (
+		logger        *lagertest.TestLogger
+		configuration Configuration
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test-storage-account")
+		configuration = Configuration{
+			SubscriptionID:     "subscription",
+			ResourceGroupName:  "resource-group",
+			StorageAccountName: "account",
+			Location:           "westeurope",
+		}
+	})
+
+	It("should default to Standard_RAGRS", func() {
+		storageAccount, err := NewStorageAccount(logger, configuration)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(storageAccount.SkuName)).To(Equal("Standard_RAGRS"))
+	})
+
+	It("should accept the zone-redundant SKUs", func() {
+		for _, skuName := range []string{"Standard_ZRS", "Standard_GZRS", "Standard_RAGZRS", "Premium_ZRS"} {
+			configuration.SkuName = skuName
+			storageAccount, err := NewStorageAccount(logger, configuration)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(storageAccount.SkuName)).To(Equal(skuName))
+		}
+	})
+
+	It("should refuse an unknown SKU", func() {
+		configuration.SkuName = "Premium_GRS"
+		_, err := NewStorageAccount(logger, configuration)
+		Expect(err).To(MatchError(`The SkuName "Premium_GRS" to create the storage account is invalid. It must be one of Standard_GRS, Standard_LRS, Standard_RAGRS, Standard_ZRS, Standard_GZRS, Standard_RAGZRS, Premium_ZRS`))
+	})
+})
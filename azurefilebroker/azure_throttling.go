@@ -0,0 +1,131 @@
+package azurefilebroker
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// The headers of Azure Resource Manager with the requests which the subscription has left before it is throttled
+// Reference: https://docs.microsoft.com/en-us/azure/azure-resource-manager/resource-manager-request-limits
+const (
+	headerRemainingSubscriptionReads   = "x-ms-ratelimit-remaining-subscription-reads"
+	headerRemainingSubscriptionWrites  = "x-ms-ratelimit-remaining-subscription-writes"
+	headerRemainingSubscriptionDeletes = "x-ms-ratelimit-remaining-subscription-deletes"
+)
+
+const (
+	// The background requests are slowed down when a subscription has fewer requests left than the reserve, which keeps
+	// about a twelfth of the hourly limits of 12000 reads and 1200 writes or deletes for the provisions and the binds
+	throttlingReserveReads  = 1000
+	throttlingReserveWrites = 100
+	// A background request waits up to throttlingMaxDelay when nothing is left
+	throttlingMaxDelay = 30 * time.Second
+	// Azure refills the quota over time, so an old count is not trusted
+	throttlingObservationTTL = 5 * time.Minute
+)
+
+type remainingRequests struct {
+	remaining  int
+	observedAt time.Time
+}
+
+// AzureThrottle keeps the remaining requests of the subscriptions in the responses of Azure Resource Manager. The
+// requests of the background jobs and the admin API are slowed down when few are left so that the provisions and the
+// binds of the platform are not throttled.
+type AzureThrottle struct {
+	clock clock.Clock
+	mutex sync.Mutex
+	// remaining is keyed by the subscription ID and the header of the kind of the requests
+	remaining map[string]map[string]remainingRequests
+}
+
+func NewAzureThrottle(clock clock.Clock) *AzureThrottle {
+	return &AzureThrottle{
+		clock:     clock,
+		remaining: map[string]map[string]remainingRequests{},
+	}
+}
+
+// Observe records the remaining requests in the headers of a response of Azure Resource Manager
+func (t *AzureThrottle) Observe(subscriptionID string, header http.Header) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, name := range []string{headerRemainingSubscriptionReads, headerRemainingSubscriptionWrites, headerRemainingSubscriptionDeletes} {
+		remaining, err := strconv.Atoi(header.Get(name))
+		if err != nil {
+			continue
+		}
+		if t.remaining[subscriptionID] == nil {
+			t.remaining[subscriptionID] = map[string]remainingRequests{}
+		}
+		t.remaining[subscriptionID][name] = remainingRequests{remaining: remaining, observedAt: t.clock.Now()}
+	}
+}
+
+// Delay returns how long a background request with the method waits before it is sent to the subscription. It grows
+// from 0 when the remaining requests reach the reserve to throttlingMaxDelay when none are left.
+func (t *AzureThrottle) Delay(subscriptionID, method string) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	name, reserve := headerRemainingSubscriptionWrites, throttlingReserveWrites
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		name, reserve = headerRemainingSubscriptionReads, throttlingReserveReads
+	case http.MethodDelete:
+		name = headerRemainingSubscriptionDeletes
+	}
+
+	observed, ok := t.remaining[subscriptionID][name]
+	if !ok || t.clock.Since(observed.observedAt) > throttlingObservationTTL || observed.remaining >= reserve {
+		return 0
+	}
+	if observed.remaining < 0 {
+		observed.remaining = 0
+	}
+	return throttlingMaxDelay * time.Duration(reserve-observed.remaining) / time.Duration(reserve)
+}
+
+// waitForQuota slows down a background request of the storage account when its subscription has few requests left
+func (t *AzureThrottle) waitForQuota(logger lager.Logger, storageAccount *StorageAccount, method string) {
+	if t == nil || !storageAccount.Background {
+		return
+	}
+	delay := t.Delay(storageAccount.SubscriptionID, method)
+	if delay == 0 {
+		return
+	}
+	logger.Info("slow-down-background-request", lager.Data{"SubscriptionID": storageAccount.SubscriptionID, "method": method, "delay": delay.String()})
+	if storageAccount.Metrics != nil {
+		storageAccount.Metrics.IncrementCounter(metricAzureThrottledRequests, map[string]string{"method": method})
+	}
+	t.clock.Sleep(delay)
+}
+
+// inspectors return the decorators which shape the requests of the management SDK client of the storage account and
+// record their outcomes like those of the REST client
+func (t *AzureThrottle) inspectors(logger lager.Logger, storageAccount *StorageAccount) (autorest.PrepareDecorator, autorest.RespondDecorator) {
+	requestInspector := func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			t.waitForQuota(logger, storageAccount, r.Method)
+			return p.Prepare(r)
+		})
+	}
+	responseInspector := func(r autorest.Responder) autorest.Responder {
+		return autorest.ResponderFunc(func(resp *http.Response) error {
+			if resp != nil {
+				t.Observe(storageAccount.SubscriptionID, resp.Header)
+				storageAccount.Health.Record(nil, resp.StatusCode)
+			}
+			return r.Respond(resp)
+		})
+	}
+	return requestInspector, responseInspector
+}
@@ -0,0 +1,62 @@
+package azurefilebroker_test
+
+import (
+	"net/http"
+	"time"
+
+	. "code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	"code.cloudfoundry.org/clock/fakeclock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AzureThrottle", func() {
+	var (
+		clock    *fakeclock.FakeClock
+		throttle *AzureThrottle
+		header   http.Header
+	)
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+		throttle = NewAzureThrottle(clock)
+		header = http.Header{}
+	})
+
+	It("should not delay the requests of an unknown subscription", func() {
+		Expect(throttle.Delay("subscription", http.MethodGet)).To(BeZero())
+	})
+
+	It("should not delay the requests while enough are left", func() {
+		header.Set("x-ms-ratelimit-remaining-subscription-reads", "11999")
+		header.Set("x-ms-ratelimit-remaining-subscription-writes", "100")
+		throttle.Observe("subscription", header)
+		Expect(throttle.Delay("subscription", http.MethodGet)).To(BeZero())
+		Expect(throttle.Delay("subscription", http.MethodPut)).To(BeZero())
+	})
+
+	It("should delay the requests more when fewer are left", func() {
+		header.Set("x-ms-ratelimit-remaining-subscription-reads", "500")
+		header.Set("x-ms-ratelimit-remaining-subscription-writes", "0")
+		header.Set("x-ms-ratelimit-remaining-subscription-deletes", "75")
+		throttle.Observe("subscription", header)
+		Expect(throttle.Delay("subscription", http.MethodGet)).To(Equal(15 * time.Second))
+		Expect(throttle.Delay("subscription", http.MethodPatch)).To(Equal(30 * time.Second))
+		Expect(throttle.Delay("subscription", http.MethodDelete)).To(Equal(7500 * time.Millisecond))
+		Expect(throttle.Delay("another-subscription", http.MethodGet)).To(BeZero())
+	})
+
+	It("should keep the kinds of requests which are missing in a response", func() {
+		header.Set("x-ms-ratelimit-remaining-subscription-writes", "0")
+		throttle.Observe("subscription", header)
+		throttle.Observe("subscription", http.Header{"X-Ms-Ratelimit-Remaining-Subscription-Reads": []string{"11000"}})
+		Expect(throttle.Delay("subscription", http.MethodPost)).To(Equal(30 * time.Second))
+	})
+
+	It("should forget the remaining requests after a while", func() {
+		header.Set("x-ms-ratelimit-remaining-subscription-reads", "0")
+		throttle.Observe("subscription", header)
+		clock.Increment(6 * time.Minute)
+		Expect(throttle.Delay("subscription", http.MethodGet)).To(BeZero())
+	})
+})
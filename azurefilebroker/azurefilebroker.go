@@ -4,14 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"path"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 
 	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/lager"
@@ -21,16 +26,40 @@ import (
 const (
 	permissionVolumeMount = brokerapi.RequiredPermission("volume_mount")
 	defaultContainerPath  = "/var/vcap/data"
+
+	planIDExisting       = "06948cb0-cad7-4buh-leba-9ed8b5c345a1"
+	planIDAzureFileShare = "06948cb0-cad7-4buh-leba-9ed8b5c345a2"
 )
 
 const (
 	driverName       string = "smbdriver"
 	deviceTypeShared string = "shared"
 	databaseVersion  string = "1.0"
+
+	operationDeprovision string = "deprovision"
+
+	paramsHashSaltLength = 16
+
+	volumeIDVersionSHA256 string = "sha256"
+)
+
+// volumeIDExcludedKeys are the mount entries which do not identify the volume and are not part of the volume ID
+var volumeIDExcludedKeys = map[string]bool{"password": true}
+
+const (
+	// estimatedStorageAccountCreationDuration is how long the creation of a storage account usually takes
+	estimatedStorageAccountCreationDuration = 30 * time.Second
+	synchronousPollInterval                 = 5 * time.Second
 )
 
+var errSynchronousBudgetExceeded = newBrokerError(ErrCodeSynchronousBudgetExceeded, "The operation did not finish within the synchronous budget. It will be cleaned up by the deprovision of the service instance")
+
 const (
-	lockTimeoutInSeconds int = 30
+	provisioningStatePending   string = "pending"  // The instance is stored and nothing has been changed in Azure
+	provisioningStateCreating  string = "creating" // The storage account may have been requested to be created
+	provisioningStateSucceeded string = "succeeded"
+	provisioningStateFailed    string = "failed"
+	provisioningStateDeleting  string = "deleting" // The storage account is being deleted asynchronously
 )
 
 /*
@@ -38,6 +67,8 @@ This broker supports both AzureFileShare and preexisting shares.
 	AzureFileShare:
 		Provision with parameters: subscription_id, resource_group_name, storage_account_name, location, use_https, sku_name, enable_encryption, custom_domain_name, use_sub_domain
 			Create or use a storage account
+		Update with parameters: use_https, sku_name
+			Change the storage account which the broker created
 		Bind with parameters which are defined in BindOptions: uid, gid, file_mode, dir_mode, readonly, mount, vers, share
 			Create or use a file share; Return credentials
 		Unbind
@@ -67,6 +98,14 @@ type Configuration struct {
 	UseSubDomain       string `json:"use_sub_domain"`    // bool
 	EnableEncryption   string `json:"enable_encryption"` // bool
 	Share              string `json:"share"`             // Required for preexisting shares
+	TenantID           string `json:"tenant_id"`         // Optional service principal for AzureFileShare, or alone an auxiliary tenant of the broker
+	ClientID           string `json:"client_id"`
+	ClientSecret       string `json:"client_secret"`
+	CredHubRef         string `json:"credhub_ref"`     // Optional reference to a service principal in CredHub for AzureFileShare
+	BackupVaultID      string `json:"backup_vault_id"` // Optional Recovery Services vault which protects the created file shares
+	BackupPolicy       string `json:"backup_policy"`
+	TTLHours           int    `json:"ttl_hours"`   // Optional number of hours after which the instance is deprovisioned when it is not bound
+	AccessLogs         string `json:"access_logs"` // Optional bool which overrides whether the plan sends the access logs of the file shares
 }
 
 func (config *Configuration) ValidateForAzureFileShare() error {
@@ -82,7 +121,7 @@ func (config *Configuration) ValidateForAzureFileShare() error {
 	}
 
 	if len(missingKeys) > 0 {
-		return fmt.Errorf("Missing required parameters: %s", strings.Join(missingKeys, ", "))
+		return newBrokerError(ErrCodeInvalidParameters, "Missing required parameters: %s", strings.Join(missingKeys, ", "))
 	}
 	return nil
 }
@@ -94,15 +133,22 @@ type BindOptions struct {
 	DirMode       string `json:"dir_mode"`
 	Readonly      bool   `json:"readonly"`
 	Mount         string `json:"mount"`
-	Vers          string `json:"vers"`     // Required for AzureFileShare
-	FileShareName string `json:"share"`    // Required for AzureFileShare
-	Domain        string `json:"domain"`   // Optional for preexisting shares
-	Username      string `json:"username"` // Required for preexisting shares
-	Password      string `json:"password"` // Optional for preexisting shares
-	Sec           string `json:"sec"`      // Optional for preexisting shares
+	Vers          string `json:"vers"`      // Required for AzureFileShare
+	FileShareName string `json:"share"`     // Required for AzureFileShare
+	Domain        string `json:"domain"`    // Optional for preexisting shares
+	Username      string `json:"username"`  // Required for preexisting shares
+	Password      string `json:"password"`  // Optional for preexisting shares
+	Sec           string `json:"sec"`       // Optional for preexisting shares
+	ShareSAS      string `json:"share_sas"` // Proves the ownership of an existing AzureFileShare share
+	// AccessPolicy is defined on the AzureFileShare share and a SAS token which references it is returned in the
+	// credentials. It may only have the ID of a policy defined by an update.
+	AccessPolicy *ShareAccessPolicy `json:"access_policy"`
+	// Directories are created in the AzureFileShare share when the broker creates it, e.g. ["logs", "data/uploads"].
+	// Their modes are given by dir_mode because Azure Files does not keep the modes of the SMB shares.
+	Directories []string `json:"directories"`
 }
 
-// ToMap Omit Mount, FileShareName, Domain, Username and Password
+// ToMap Omit Mount, FileShareName, Domain, Username, Password, ShareSAS, AccessPolicy and Directories
 func (options BindOptions) ToMap() map[string]string {
 	ret := make(map[string]string)
 	if options.UID != "" {
@@ -129,6 +175,13 @@ func (options BindOptions) ToMap() map[string]string {
 	return ret
 }
 
+// redacted returns a copy without the secrets which must not be stored: the password and the SAS token of the share
+func (options BindOptions) redacted() BindOptions {
+	options.Password = ""
+	options.ShareSAS = ""
+	return options
+}
+
 func (options BindOptions) Validate(isPreexisting bool) error {
 	missingKeys := []string{}
 	if !isPreexisting {
@@ -138,7 +191,24 @@ func (options BindOptions) Validate(isPreexisting bool) error {
 	}
 
 	if len(missingKeys) > 0 {
-		return fmt.Errorf("Missing required parameters: %s", strings.Join(missingKeys, ", "))
+		return newBrokerError(ErrCodeInvalidParameters, "Missing required parameters: %s", strings.Join(missingKeys, ", "))
+	}
+
+	if options.AccessPolicy != nil {
+		if isPreexisting {
+			return newBrokerError(ErrCodeInvalidParameters, "The parameter access_policy is only supported by AzureFileShare instances")
+		}
+		if _, err := options.AccessPolicy.normalized(); err != nil {
+			return err
+		}
+	}
+	if len(options.Directories) > 0 {
+		if isPreexisting {
+			return newBrokerError(ErrCodeInvalidParameters, "The parameter directories is only supported by AzureFileShare instances")
+		}
+		if _, err := normalizedShareDirectories(options.Directories); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -149,31 +219,134 @@ type staticState struct {
 }
 
 type FileShare struct {
-	InstanceID      string `json:"instance_id"`
-	FileShareName   string `json:"file_share_name"`
-	IsCreated       bool   `json:"is_created"` // true if it is created by the broker.
-	Count           int    `json:"count"`
-	URL             string `json:"url"`
-	DatabaseVersion string `json:"database_version"`
+	InstanceID         string      `json:"instance_id"`
+	FileShareName      string      `json:"file_share_name"`
+	IsCreated          bool        `json:"is_created"` // true if it is created by the broker.
+	Count              int         `json:"count"`
+	URL                string      `json:"url"`
+	Stats              *ShareStats `json:"stats,omitempty"`        // The last collected usage of the file share
+	BackupState        string      `json:"backup_state,omitempty"` // The step of the protection with Azure Backup. Empty if the share is not protected.
+	BackupOperationURL string      `json:"backup_operation_url,omitempty"`
+	BackupError        string      `json:"backup_error,omitempty"` // The error of the last failed step, which is retried
+	DatabaseVersion    string      `json:"database_version"`
+}
+
+// StorageAccountOwner records the org and space which first used a storage account
+type StorageAccountOwner struct {
+	OrganizationGUID string `json:"organization_guid"`
+	SpaceGUID        string `json:"space_guid"`
+	DatabaseVersion  string `json:"database_version"`
+}
+
+// FileShareOwner records which service instances use a file share of a storage account. Several service instances may
+// point at the same storage account, so the share is deleted only when the last of them stops using it.
+type FileShareOwner struct {
+	OwnerFileShareID string   `json:"owner_file_share_id"` // the file share of the instance which created the share. Empty if it is not created by the broker.
+	FileShareIDs     []string `json:"file_share_ids"`      // the file shares of the instances which use the share.
+	DatabaseVersion  string   `json:"database_version"`
+}
+
+// ScheduledDeletion is a storage account or a file share which is deleted by the background worker once the
+// retention period is over
+type ScheduledDeletion struct {
+	Kind            string          `json:"kind"` // scheduledDeletionStorageAccount or scheduledDeletionFileShare
+	ServiceInstance ServiceInstance `json:"service_instance"`
+	FileShareName   string          `json:"file_share_name,omitempty"`
+	DeleteAfter     time.Time       `json:"delete_after"`
+	DatabaseVersion string          `json:"database_version"`
+}
+
+func getStorageAccountOwnerID(subscriptionID, resourceGroupName, storageAccountName string) string {
+	return fmt.Sprintf("%s-%s-%s", subscriptionID, resourceGroupName, storageAccountName)
 }
 
 func getFileShareID(instanceID, fileShareName string) string {
 	return fmt.Sprintf("%s-%s", instanceID, fileShareName)
 }
 
+// BindingDetails is persisted for every binding. InstanceID is the service instance of the binding. BindOptions and
+// FileShareID are the values which were validated in bind, so unbind does not need to parse RawParameters again.
+// RawParameters is not stored, only a salted hash of it in ParamsHash. VolumeIDVersion is the algorithm of the volume
+// ID returned for the binding. MinBrokerVersion is the oldest broker which understands the binding. These fields are
+// empty for bindings which were created by older versions of the broker.
+type BindingDetails struct {
+	brokerapi.BindDetails
+	InstanceID       string       `json:"instance_id,omitempty"`
+	BindOptions      *BindOptions `json:"bind_options,omitempty"`
+	FileShareID      string       `json:"file_share_id,omitempty"`
+	ParamsHash       string       `json:"params_hash,omitempty"`
+	VolumeIDVersion  string       `json:"volume_id_version,omitempty"`
+	MinBrokerVersion int          `json:"min_broker_version,omitempty"`
+	DatabaseVersion  string       `json:"database_version,omitempty"`
+}
+
+// isSameRequest checks whether a bind request matches the stored binding.
+// Bindings created by older versions of the broker may not have the bind options,
+// in which case only the raw parameters that are still stored can be compared.
+func (details BindingDetails) isSameRequest(request brokerapi.BindDetails, bindOptions BindOptions) bool {
+	if details.AppGUID != request.AppGUID || details.PlanID != request.PlanID || details.ServiceID != request.ServiceID {
+		return false
+	}
+
+	if details.ParamsHash != "" {
+		return matchParamsHash(details.ParamsHash, request.RawParameters)
+	}
+
+	if details.BindOptions != nil {
+		return reflect.DeepEqual(details.BindOptions.redacted(), bindOptions.redacted())
+	}
+
+	if len(details.RawParameters) > 0 {
+		var existingBindOptions BindOptions
+		if err := json.Unmarshal(details.RawParameters, &existingBindOptions); err != nil {
+			return false
+		}
+		return reflect.DeepEqual(existingBindOptions.redacted(), bindOptions.redacted())
+	}
+
+	return true
+}
+
 type ServiceInstance struct {
-	ServiceID               string `json:"service_id"`
-	PlanID                  string `json:"plan_id"`
-	OrganizationGUID        string `json:"organization_guid"`
-	SpaceGUID               string `json:"space_guid"`
-	TargetName              string `json:"target_name"`    // AzureFileShare: StorageAccountName; Preexisting shares: Share URL
-	IsPreexisting           bool   `json:"is_preexisting"` // True when preexisting shares are used; False when AzureFileShare is used.
-	SubscriptionID          string `json:"subscription_id"`
-	ResourceGroupName       string `json:"resource_group_name"`
-	UseHTTPS                string `json:"use_https"`
-	IsCreatedStorageAccount bool   `json:"is_created_storage_account"`
-	OperationURL            string `json:"operation_url"`
-	DatabaseVersion         string `json:"database_version"`
+	ServiceID               string                   `json:"service_id"`
+	PlanID                  string                   `json:"plan_id"`
+	OrganizationGUID        string                   `json:"organization_guid"`
+	SpaceGUID               string                   `json:"space_guid"`
+	TargetName              string                   `json:"target_name"`    // AzureFileShare: StorageAccountName; Preexisting shares: Share URL
+	IsPreexisting           bool                     `json:"is_preexisting"` // True when preexisting shares are used; False when AzureFileShare is used.
+	SubscriptionID          string                   `json:"subscription_id"`
+	ResourceGroupName       string                   `json:"resource_group_name"`
+	UseHTTPS                string                   `json:"use_https"`
+	Location                string                   `json:"location"`
+	SkuName                 string                   `json:"sku_name"`
+	EnableEncryption        string                   `json:"enable_encryption"`
+	IsCreatedStorageAccount bool                     `json:"is_created_storage_account"`
+	OperationURL            string                   `json:"operation_url"`
+	OperationError          string                   `json:"operation_error,omitempty"`
+	Metadata                InstanceMetadata         `json:"metadata"`
+	ServicePrincipal        *ServicePrincipal        `json:"service_principal,omitempty"`      // Set when the instance does not use the service principal of the broker
+	ProvisioningState       string                   `json:"provisioning_state"`               // Empty for instances which were created by older versions of the broker
+	ProvisionParameters     json.RawMessage          `json:"provision_parameters,omitempty"`   // The parameters of the provision without the secrets
+	Backup                  *BackupTarget            `json:"backup,omitempty"`                 // Set when the created file shares are protected with Azure Backup
+	MetricAlerts            []string                 `json:"metric_alerts,omitempty"`          // The alert rules which the broker created on the storage account
+	Migration               *StorageAccountMigration `json:"migration,omitempty"`              // Set while the file shares are migrated to a new storage account
+	ExpiresAt               *time.Time               `json:"expires_at,omitempty"`             // Set when the instance is deprovisioned after its TTL
+	Readonly                bool                     `json:"readonly,omitempty"`               // The bindings are mounted read-only and their SAS tokens only read
+	AccessLogs              bool                     `json:"access_logs,omitempty"`            // The access logs of the file shares are sent once the storage account is created
+	AccessLogDestination    *AccessLogDestination    `json:"access_log_destination,omitempty"` // Set when the broker has created the diagnostic setting of the access logs
+	MinBrokerVersion        int                      `json:"min_broker_version,omitempty"`     // The oldest broker which understands the record. Empty for older records.
+	DatabaseVersion         string                   `json:"database_version"`
+}
+
+// isProvisioningInterrupted returns true when no request is able to finish the provision of the instance any more.
+func (instance ServiceInstance) isProvisioningInterrupted() bool {
+	switch instance.ProvisioningState {
+	case provisioningStatePending:
+		return true
+	case provisioningStateCreating:
+		return instance.OperationURL == ""
+	}
+	return false
 }
 
 type lock interface {
@@ -188,6 +361,38 @@ type Broker struct {
 	static staticState
 	store  Store
 	config Config
+
+	// replica is the store of a read replica for the reads which tolerate the replication lag. It is nil without one.
+	replica     Store
+	auditEvents AuditEventEmitter
+	validation  ValidationWebhook
+	lifecycle   LifecycleWebhook
+	catalog     *catalogCache
+	requests    *requestDeduplicator
+	// deletionNotifiers are notified of the storage accounts and the file shares which the broker deletes
+	deletionNotifiers []DeletionNotifier
+	// metrics is nil without a metrics backend. operationStats is always kept for the admin API.
+	metrics        Metrics
+	operationStats *operationStats
+	// throttle is shared by the requests of all copies of the broker because the quota of Azure is per subscription
+	throttle *AzureThrottle
+	// health is nil unless the creations and the deletions stop while the requests to Azure fail
+	health *AzureHealth
+	// azureRetrier tries the failed requests to Azure again. The store is wrapped in a retryingStore when it is retried.
+	azureRetrier *Retrier
+	storeRetrier *Retrier
+	// staleBindings is nil unless the bindings whose apps no longer exist are cleaned up
+	staleBindings *staleBindings
+	// maintenanceWindows is nil unless the background jobs only start in their maintenance windows
+	maintenanceWindows *MaintenanceWindowConfig
+	// lockBreaker is nil unless the lock provider can end the sessions of the locks of other broker processes
+	lockBreaker LockBreaker
+	// storeReEncrypter is the SQL store whose records ReEncryptStore rewrites with the current key
+	storeReEncrypter *SqlStore
+	// ctx is the context of the request which a copy of the broker serves. It is nil for the background jobs.
+	ctx context.Context
+	// timedOut counts the operations which timed out and have not ended yet
+	timedOut *timedOutOperations
 }
 
 func New(
@@ -199,14 +404,20 @@ func New(
 ) *Broker {
 	theBroker := Broker{
 		logger: logger,
-		mutex:  &sync.Mutex{},
+		mutex:  newContextMutex(),
 		clock:  clock,
 		static: staticState{
 			ServiceName: serviceName,
 			ServiceID:   serviceID,
 		},
-		store:  store,
-		config: *config,
+		store:          newCompatibilityStore(store),
+		config:         *config,
+		catalog:        &catalogCache{},
+		requests:       newRequestDeduplicator(clock, config.timeouts.Deduplication),
+		operationStats: newOperationStats(clock.Now().UTC()),
+		throttle:       NewAzureThrottle(clock),
+		timedOut:       &timedOutOperations{},
+		azureRetrier:   NewRetrier(clock, NewRetryPolicy(DefaultAzureRetryMaxAttempts, DefaultRetryBaseDelay, DefaultRetryMaxDelay)),
 	}
 
 	return &theBroker
@@ -222,49 +433,90 @@ func (b *Broker) Services(_ context.Context) ([]brokerapi.Service, error) {
 	defer logger.Info("end")
 
 	var plans []brokerapi.ServicePlan
-	if b.isSupportAzureFileShare() {
+	var metadata *brokerapi.ServiceMetadata
+	description, tags := "SMB volumes (see: https://github.com/cloudfoundry/smb-volume-release/)", []string{"azurefile", "smb"}
+	if catalog := b.config.catalog; catalog.IsEnabled() {
+		plans = catalog.plans(b.isSupportAzureFileShare())
+		if b.isSupportAzureFileShare() {
+			plans = append(plans, b.config.segments.plans()...)
+		}
+		description, metadata = catalog.Service.Description, catalog.Service.Metadata
+		if len(catalog.Service.Tags) > 0 {
+			tags = catalog.Service.Tags
+		}
+	} else if b.isSupportAzureFileShare() {
 		plans = []brokerapi.ServicePlan{
 			{
 				Name:        "Existing",
-				ID:          "06948cb0-cad7-4buh-leba-9ed8b5c345a1",
+				ID:          planIDExisting,
 				Description: "A preexisting filesystem",
 			},
 			{
 				Name:        "AzureFileShare",
-				ID:          "06948cb0-cad7-4buh-leba-9ed8b5c345a2",
+				ID:          planIDAzureFileShare,
 				Description: "An Azure File Share filesystem",
 			},
 		}
+		plans = append(plans, b.config.segments.plans()...)
 	} else {
 		plans = []brokerapi.ServicePlan{
 			{
 				Name:        "Existing",
-				ID:          "06948cb0-cad7-4buh-leba-9ed8b5c345a1",
+				ID:          planIDExisting,
 				Description: "A preexisting filesystem",
 			},
 		}
 	}
 
+	// The instances only move between the AzureFileShare plans
+	azureFileSharePlans := 0
+	for _, plan := range plans {
+		if plan.ID != planIDExisting && b.planKind(plan.ID) != planKindPreexisting {
+			azureFileSharePlans++
+		}
+	}
+
 	return []brokerapi.Service{{
 		ID:            b.static.ServiceID,
 		Name:          b.static.ServiceName,
-		Description:   "SMB volumes (see: https://github.com/cloudfoundry/smb-volume-release/)",
+		Description:   description,
 		Bindable:      true,
-		PlanUpdatable: false,
-		Tags:          []string{"azurefile", "smb"},
+		PlanUpdatable: azureFileSharePlans > 1 && b.isSupportAzureFileShare(),
+		Tags:          tags,
 		Requires:      []brokerapi.RequiredPermission{permissionVolumeMount},
+		Metadata:      metadata,
 		Plans:         plans,
 	}}, nil
 }
 
 // Provision Create a service instance which is mapped to a storage account or preexisting shares
 // For AzureFileShare: UseHTTPS must be set to false. Otherwise, the mount in Linux will fail. https://docs.microsoft.com/en-us/azure/storage/storage-security-guide
-func (b *Broker) Provision(context context.Context, instanceID string, details brokerapi.ProvisionDetails, asyncAllowed bool) (_ brokerapi.ProvisionedServiceSpec, e error) {
+func (b *Broker) provision(context context.Context, instanceID string, details brokerapi.ProvisionDetails, asyncAllowed bool) (_ brokerapi.ProvisionedServiceSpec, e error) {
 	logger := b.logger.Session("provision").WithData(lager.Data{"instanceID": instanceID, "details": details, "asyncAllowed": asyncAllowed})
 	logger.Info("start")
 	defer logger.Info("end")
+	defer func() {
+		e = toFailureResponse(e)
+	}()
+
+	rawParameters, err := b.validate(logger, ValidationRequest{
+		Operation:        ValidationOperationProvision,
+		InstanceID:       instanceID,
+		ServiceID:        details.ServiceID,
+		PlanID:           details.PlanID,
+		OrganizationGUID: details.OrganizationGUID,
+		SpaceGUID:        details.SpaceGUID,
+		Parameters:       details.RawParameters,
+	})
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	details.RawParameters = rawParameters
 
-	b.mutex.Lock()
+	if err := b.lockMutex(); err != nil {
+		logger.Error("lock-broker", err)
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
 	defer b.mutex.Unlock()
 
 	var configuration Configuration
@@ -275,242 +527,848 @@ func (b *Broker) Provision(context context.Context, instanceID string, details b
 	}
 
 	if !b.isSupportAzureFileShare() && configuration.Share == "" {
-		return brokerapi.ProvisionedServiceSpec{}, fmt.Errorf("Missing required parameters: share")
+		return brokerapi.ProvisionedServiceSpec{}, newBrokerError(ErrCodeInvalidParameters, "Missing required parameters: share")
+	}
+	expiresAt, err := b.instanceExpiry(configuration)
+	if err != nil {
+		logger.Error("instance-expiry", err)
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+
+	switch b.planKind(details.PlanID) {
+	case planKindAzureFileShare:
+		if configuration.Share != "" {
+			return brokerapi.ProvisionedServiceSpec{}, newBrokerError(ErrCodeInvalidParameters, "The plan %s only supports AzureFileShare: the parameter share cannot be given", b.planName(details.PlanID))
+		}
+	case planKindPreexisting:
+		if configuration.Share == "" {
+			return brokerapi.ProvisionedServiceSpec{}, newBrokerError(ErrCodeInvalidParameters, "The plan %s only supports preexisting shares: the parameter share must be given", b.planName(details.PlanID))
+		}
 	}
 
 	if configuration.Share != "" {
 		// Provisiong preexisting shares
+		if configuration.BackupVaultID != "" || configuration.BackupPolicy != "" {
+			return brokerapi.ProvisionedServiceSpec{}, newBrokerError(ErrCodeInvalidParameters, "Preexisting shares are not protected by the broker: the parameters backup_vault_id and backup_policy cannot be given")
+		}
+		backend := NewPreexistingSMBBackend(b.config.preexisting.AllowedShares)
+		if ok, err := backend.HasFileShare(configuration.Share); err != nil {
+			return brokerapi.ProvisionedServiceSpec{}, err
+		} else if !ok {
+			return brokerapi.ProvisionedServiceSpec{}, newBrokerError(ErrCodeShareNotRegistered, "The share %q is not registered by the administrator", configuration.Share)
+		}
+
 		serviceInstance := ServiceInstance{
-			ServiceID:        details.ServiceID,
-			PlanID:           details.PlanID,
-			OrganizationGUID: details.OrganizationGUID,
-			SpaceGUID:        details.SpaceGUID,
-			TargetName:       configuration.Share,
-			IsPreexisting:    true,
+			ServiceID:           details.ServiceID,
+			PlanID:              details.PlanID,
+			OrganizationGUID:    details.OrganizationGUID,
+			SpaceGUID:           details.SpaceGUID,
+			TargetName:          configuration.Share,
+			IsPreexisting:       true,
+			ProvisioningState:   provisioningStateSucceeded,
+			ProvisionParameters: b.provisionParameters(logger, details.RawParameters),
+			ExpiresAt:           expiresAt,
+			MinBrokerVersion:    minBrokerCompatibilityVersion,
 		}
 
 		if err := b.store.CreateServiceInstance(instanceID, serviceInstance); err != nil {
 			logger.Error("create-service-instance", err, lager.Data{"serviceInstance": serviceInstance})
-			return brokerapi.ProvisionedServiceSpec{}, fmt.Errorf("Failed to store instance details %q: %s", instanceID, err)
+			return brokerapi.ProvisionedServiceSpec{}, newStoreError(err, "Failed to store instance details %q", instanceID)
 		}
 
 		logger.Debug("service-instance-created", lager.Data{"serviceInstance": serviceInstance})
 
+		b.emitAuditEvent(logger, AuditEvent{
+			Type:              AuditEventServiceInstanceCreate,
+			ServiceInstanceID: instanceID,
+			OrganizationGUID:  details.OrganizationGUID,
+			SpaceGUID:         details.SpaceGUID,
+		})
 		return brokerapi.ProvisionedServiceSpec{IsAsync: false}, nil
 	}
 
-	// Provisioning an Azure file share
+	// Provisioning an Azure file share. The provision parameters override the target of the plan.
+	planTarget := b.planTarget(details.PlanID)
 	if configuration.SubscriptionID == "" {
-		configuration.SubscriptionID = b.config.cloud.Azure.DefaultSubscriptionID
+		configuration.SubscriptionID = planTarget.SubscriptionID
 	}
 	if configuration.ResourceGroupName == "" {
-		configuration.ResourceGroupName = b.config.cloud.Azure.DefaultResourceGroupName
+		configuration.ResourceGroupName = planTarget.ResourceGroupName
 	}
 	// Do not check location in this function because location is only used when the storage account does not exist
 	if configuration.Location == "" {
-		configuration.Location = b.config.cloud.Azure.DefaultLocation
+		configuration.Location = b.placementLocation(logger, details)
+	}
+
+	// A provision which does not name its storage account gets one from the pool if any is ready
+	pooledAccount, err := b.claimPooledStorageAccount(logger, details.PlanID, &configuration)
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
 	}
+	defer func() {
+		if e != nil && pooledAccount != nil {
+			b.returnPooledStorageAccount(logger, *pooledAccount)
+		}
+	}()
 
 	if err := configuration.ValidateForAzureFileShare(); err != nil {
 		logger.Error("validate-configuration", err)
 		return brokerapi.ProvisionedServiceSpec{}, err
 	}
+	backupTarget, err := b.backupTarget(configuration)
+	if err != nil {
+		logger.Error("backup-target", err)
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	accessLogs, err := b.accessLogsEnabled(configuration, b.planName(details.PlanID))
+	if err != nil {
+		logger.Error("access-logs-enabled", err)
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	if pooledAccount == nil {
+		if err := b.config.naming.StorageAccount.check("storage account", configuration.StorageAccountName, details.RawContext); err != nil {
+			logger.Error("check-storage-account-naming-policy", err)
+			return brokerapi.ProvisionedServiceSpec{}, err
+		}
+	}
+
+	storageAccount, err := NewStorageAccount(logger, configuration)
+	if err != nil {
+		logger.Error("new-storage-account", err)
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	storageAccount.Context = b.ctx
+	storageAccount.Metrics = b.metrics
+	b.setThrottle(storageAccount)
+	// Fail early when a service principal in CredHub cannot be resolved
+	if _, err := b.config.cloud.withServicePrincipal(storageAccount.ServicePrincipal); err != nil {
+		logger.Error("resolve-service-principal", err)
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+
+	// Consider multiple users may send provision requests with a same storage account name
+	// Multiple broker instances may check whether the storage account exists or not at the same time
+	// They will send same creation requests to Azure if all of above checks return false
+	// All of them will consider they are the owner of the new created storage account
+	// We use a global lock as a solution for above race
+	err = b.getLockForUpdate(storageAccount.StorageAccountName)
+	if err != nil {
+		logger.Error("get-lock-for-check-storage-account", err)
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	defer b.store.ReleaseLockForUpdate(storageAccount.StorageAccountName)
+
+	ownerID := getStorageAccountOwnerID(storageAccount.SubscriptionID, storageAccount.ResourceGroupName, storageAccount.StorageAccountName)
+	isNewOwner, err := b.claimStorageAccount(logger, ownerID, storageAccount.StorageAccountName, details.OrganizationGUID, details.SpaceGUID)
+	if err != nil {
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+	defer func() {
+		if e != nil && e != errSynchronousBudgetExceeded && isNewOwner {
+			if err := b.store.DeleteStorageAccountOwner(ownerID); err != nil {
+				logger.Error("rollback-storage-account-owner", err)
+			}
+		}
+	}()
 
-	storageAccount, err := b.getStorageAccount(logger, configuration)
+	// The credentials of the service principal are kept out of the record of the instance when there is a secret store
+	servicePrincipal, err := b.storeServicePrincipalSecret(logger, instanceID, storageAccount.ServicePrincipal)
 	if err != nil {
-		logger.Error("get-storage-account", err)
 		return brokerapi.ProvisionedServiceSpec{}, err
 	}
+	defer func() {
+		if e != nil && e != errSynchronousBudgetExceeded {
+			b.deleteUnusedSecret(logger, servicePrincipal)
+		}
+	}()
 
+	// Store the instance before touching Azure so that an interrupted provision can be found and resumed at startup
 	serviceInstance := ServiceInstance{
-		ServiceID:               details.ServiceID,
-		PlanID:                  details.PlanID,
-		OrganizationGUID:        details.OrganizationGUID,
-		SpaceGUID:               details.SpaceGUID,
-		TargetName:              storageAccount.StorageAccountName,
-		IsPreexisting:           false,
-		SubscriptionID:          storageAccount.SubscriptionID,
-		ResourceGroupName:       storageAccount.ResourceGroupName,
-		UseHTTPS:                strconv.FormatBool(storageAccount.UseHTTPS),
-		IsCreatedStorageAccount: storageAccount.IsCreatedStorageAccount,
-		OperationURL:            storageAccount.OperationURL,
-		DatabaseVersion:         databaseVersion,
+		ServiceID:         details.ServiceID,
+		PlanID:            details.PlanID,
+		OrganizationGUID:  details.OrganizationGUID,
+		SpaceGUID:         details.SpaceGUID,
+		TargetName:        storageAccount.StorageAccountName,
+		IsPreexisting:     false,
+		SubscriptionID:    storageAccount.SubscriptionID,
+		ResourceGroupName: storageAccount.ResourceGroupName,
+		UseHTTPS:          strconv.FormatBool(storageAccount.UseHTTPS),
+		Location:          storageAccount.Location,
+		SkuName:           string(storageAccount.SkuName),
+		EnableEncryption:  strconv.FormatBool(storageAccount.EnableEncryption),
+		ServicePrincipal:  servicePrincipal,
+		Backup:            backupTarget,
+		ExpiresAt:         expiresAt,
+		AccessLogs:        accessLogs,
+		ProvisioningState: provisioningStatePending,
+		MinBrokerVersion:  minBrokerCompatibilityVersion,
+		DatabaseVersion:   databaseVersion,
+		// The parameters are kept as they were given, without the defaults of the broker
+		ProvisionParameters: b.provisionParameters(logger, details.RawParameters),
+		// The pooled storage accounts are created by the broker, so they are deleted with the instance
+		IsCreatedStorageAccount: pooledAccount != nil,
 	}
 
 	err = b.store.CreateServiceInstance(instanceID, serviceInstance)
 	if err != nil {
 		logger.Error("create-service-instance", err, lager.Data{"serviceInstance": serviceInstance})
-		return brokerapi.ProvisionedServiceSpec{}, fmt.Errorf("Failed to store instance details %q: %s", instanceID, err)
+		return brokerapi.ProvisionedServiceSpec{}, newStoreError(err, "Failed to store instance details %q", instanceID)
 	}
 
 	logger.Debug("service-instance-created", lager.Data{"serviceInstance": serviceInstance})
 
-	isAsync := storageAccount.IsCreatedStorageAccount && storageAccount.OperationURL != ""
-	return brokerapi.ProvisionedServiceSpec{IsAsync: isAsync, OperationData: storageAccount.OperationURL}, nil
+	if err := b.provisionStorageAccount(logger, instanceID, &serviceInstance, storageAccount, asyncAllowed); err != nil {
+		logger.Error("provision-storage-account", err)
+		// The storage account is still being created, so the instance is kept for the deprovision of the orphan mitigation
+		if err != errSynchronousBudgetExceeded {
+			if err := b.store.DeleteServiceInstance(instanceID); err != nil {
+				logger.Error("rollback-service-instance", err)
+			}
+		}
+		return brokerapi.ProvisionedServiceSpec{}, err
+	}
+
+	b.emitAuditEvent(logger, AuditEvent{
+		Type:              AuditEventServiceInstanceCreate,
+		ServiceInstanceID: instanceID,
+		OrganizationGUID:  details.OrganizationGUID,
+		SpaceGUID:         details.SpaceGUID,
+		ResourceIDs:       auditResourceIDs(&serviceInstance, ""),
+	})
+
+	isAsync := serviceInstance.ProvisioningState == provisioningStateCreating
+	return brokerapi.ProvisionedServiceSpec{IsAsync: isAsync, OperationData: serviceInstance.OperationURL}, nil
 }
 
-func (b *Broker) getStorageAccount(logger lager.Logger, configuration Configuration) (*StorageAccount, error) {
-	logger = logger.Session("get-storage-account")
-	logger.Info("start")
-	defer logger.Info("end")
+// claimStorageAccount checks that the storage account is owned by the given org and space when the ownership is enforced.
+// The first org and space which use the storage account become its owner. It returns true if the owner is newly recorded.
+func (b *Broker) claimStorageAccount(logger lager.Logger, ownerID, storageAccountName, organizationGUID, spaceGUID string) (bool, error) {
+	if !b.config.cloud.Control.EnforceStorageAccountOwnership {
+		return false, nil
+	}
 
-	storageAccount, err := NewStorageAccount(logger, configuration)
-	if err != nil {
-		return nil, err
+	owner, err := b.store.RetrieveStorageAccountOwner(ownerID)
+	if err == nil {
+		if owner.OrganizationGUID != organizationGUID || owner.SpaceGUID != spaceGUID {
+			err := newBrokerError(ErrCodeStorageAccountOwnedByAnother, "The storage account %q is owned by another org or space", storageAccountName)
+			logger.Error("check-storage-account-owner", err, lager.Data{"owner": owner})
+			return false, err
+		}
+		return false, nil
+	} else if err != brokerapi.ErrInstanceDoesNotExist {
+		logger.Error("retrieve-storage-account-owner", err)
+		return false, err
 	}
-	storageAccount.SDKClient, err = NewAzureStorageAccountSDKClient(
-		logger,
-		&b.config.cloud,
-		storageAccount,
-	)
-	if err != nil {
-		return nil, err
+
+	owner = StorageAccountOwner{
+		OrganizationGUID: organizationGUID,
+		SpaceGUID:        spaceGUID,
+		DatabaseVersion:  databaseVersion,
 	}
+	if err := b.store.CreateStorageAccountOwner(ownerID, owner); err != nil {
+		logger.Error("create-storage-account-owner", err)
+		return false, err
+	}
+	logger.Info("storage-account-owner-created", lager.Data{"owner": owner})
+	return true, nil
+}
 
-	// Consider multiple users may send provision requests with a same storage account name
-	// Multiple broker instances may check whether the storage account exists or not at the same time
-	// They will send same creation requests to Azure if all of above checks return false
-	// All of them will consider they are the owner of the new created storage account
-	// We use a global lock as a solution for above race
-	err = b.store.GetLockForUpdate(storageAccount.StorageAccountName, lockTimeoutInSeconds)
+// checkResourceGroup verifies that the subscription is accessible and the resource group exists, so that a wrong
+// parameter is reported explicitly instead of as a 404 of the storage account. A service principal which may not read
+// them gets an AzureAuthorizationFailed error instead of a missing subscription or resource group.
+func (b *Broker) checkResourceGroup(logger lager.Logger, storageAccount *StorageAccount) error {
+	restClient, err := NewAzureStorageAccountRESTClient(logger, &b.config.cloud, storageAccount)
 	if err != nil {
-		logger.Error("get-lock-for-check-storage-account", err)
-		return nil, err
+		return err
 	}
-	defer b.store.ReleaseLockForUpdate(storageAccount.StorageAccountName)
 
-	if exist, err := storageAccount.SDKClient.Exists(); err != nil {
-		return nil, fmt.Errorf("Failed to check whether storage account exists: %v", err)
-	} else if exist {
-		logger.Debug("check-storage-account-exist", lager.Data{
-			"message": fmt.Sprintf("The storage account %q exists.", storageAccount.StorageAccountName),
-		})
-		return storageAccount, nil
-	} else if !b.config.cloud.Control.AllowCreateStorageAccount {
-		return nil, fmt.Errorf("The storage account %q does not exist under the resource group %q in the subscription %q and the administrator does not allow to create it automatically", storageAccount.StorageAccountName, storageAccount.ResourceGroupName, storageAccount.SubscriptionID)
+	if exist, err := restClient.SubscriptionExists(); err != nil {
+		return newAzureError(err, "Failed to check whether the subscription %q is accessible", storageAccount.SubscriptionID)
+	} else if !exist {
+		return newBrokerError(ErrCodeSubscriptionNotAccessible, "The subscription %q does not exist or is not accessible with the credentials of the broker", storageAccount.SubscriptionID)
 	}
 
-	restClient, err := NewAzureStorageAccountRESTClient(
-		logger,
-		&b.config.cloud,
-		storageAccount,
-	)
+	if exist, err := restClient.ResourceGroupExists(); err != nil {
+		return newAzureError(err, "Failed to check whether the resource group %q exists", storageAccount.ResourceGroupName)
+	} else if !exist {
+		return newBrokerError(ErrCodeResourceGroupNotFound, "The resource group %q does not exist in the subscription %q", storageAccount.ResourceGroupName, storageAccount.SubscriptionID)
+	}
+	return nil
+}
+
+// checkStorageAccountQuota refuses to create a storage account when the subscription has no storage account left in
+// its quota. The quota is only a hint, so a failure to read it does not block the creation.
+func (b *Broker) checkStorageAccountQuota(logger lager.Logger, storageAccount *StorageAccount) error {
+	restClient, err := NewAzureStorageAccountRESTClient(logger, &b.config.cloud, storageAccount)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	storageAccount.OperationURL, err = restClient.CreateStorageAccount()
+	current, limit, err := restClient.GetStorageAccountUsage()
 	if err != nil {
-		return nil, fmt.Errorf("Failed to create the storage account %q under the resource group %q in the subscription %q: %v", storageAccount.StorageAccountName, storageAccount.ResourceGroupName, storageAccount.SubscriptionID, err)
+		logger.Error("get-storage-account-usage", err)
+		return nil
 	}
+	logger.Info("storage-account-usage", lager.Data{"current": current, "limit": limit})
+	if limit > 0 && current >= limit {
+		return newBrokerError(ErrCodeStorageAccountQuotaExceeded, "The storage account %q cannot be created because the subscription %q already has %d of %d storage accounts. %s", storageAccount.StorageAccountName, storageAccount.SubscriptionID, current, limit, quotaExceededHint)
+	}
+	return nil
+}
 
-	storageAccount.IsCreatedStorageAccount = true
+// checkSkuAvailability refuses a zone-redundant SKU which the location does not offer to the subscription before the
+// storage account is created. The other SKUs are available in every location.
+func (b *Broker) checkSkuAvailability(logger lager.Logger, storageAccount *StorageAccount) error {
+	if !isZoneRedundantSku(storageAccount.SkuName) {
+		return nil
+	}
+	restClient, err := NewAzureStorageAccountRESTClient(logger, &b.config.cloud, storageAccount)
+	if err != nil {
+		return err
+	}
 
-	return storageAccount, nil
+	available, err := restClient.IsSkuAvailable(storageAccount.SkuName, storageAccount.Location)
+	if ErrorCode(err) == ErrCodeInvalidParameters {
+		return err
+	} else if err != nil {
+		// Azure refuses the creation anyway if the SKU is not available
+		logger.Error("is-sku-available", err)
+		return nil
+	}
+	if !available {
+		return newBrokerError(ErrCodeInvalidParameters, "The SKU %q is not available in the location %q for the subscription %q. Choose another location or SKU", storageAccount.SkuName, storageAccount.Location, storageAccount.SubscriptionID)
+	}
+	return nil
 }
 
-func (b *Broker) Deprovision(context context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (_ brokerapi.DeprovisionServiceSpec, e error) {
-	logger := b.logger.Session("deprovision").WithData(lager.Data{"instanceID": instanceID, "details": details, "asyncAllowed": asyncAllowed})
+// provisionStorageAccount drives the service instance through its provisioning states until it succeeds or waits for
+// an asynchronous creation. Every state is stored before the Azure operation that depends on it is started.
+// When asyncAllowed is false, the creation is refused up front unless it is expected to finish within the synchronous
+// budget, and then it is waited for until the budget is spent.
+// The caller must hold the lock of the storage account.
+func (b *Broker) provisionStorageAccount(logger lager.Logger, instanceID string, serviceInstance *ServiceInstance, storageAccount *StorageAccount, asyncAllowed bool) error {
+	logger = logger.Session("provision-storage-account").WithData(lager.Data{"StorageAccountName": storageAccount.StorageAccountName})
 	logger.Info("start")
 	defer logger.Info("end")
 
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
-
-	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
-	if err != nil {
-		logger.Error("retrieve-service-instance", err)
-		return brokerapi.DeprovisionServiceSpec{}, brokerapi.ErrInstanceDoesNotExist
-	}
+	for {
+		logger.Debug("provisioning-state", lager.Data{"state": serviceInstance.ProvisioningState})
+		switch serviceInstance.ProvisioningState {
+		case provisioningStatePending:
+			if storageAccount.SDKClient == nil {
+				sdkClient, err := NewAzureStorageAccountSDKClient(logger, &b.config.cloud, storageAccount)
+				if err != nil {
+					return err
+				}
+				storageAccount.SDKClient = sdkClient
+			}
 
-	if !serviceInstance.IsPreexisting {
-		if serviceInstance.IsCreatedStorageAccount && b.config.cloud.Control.AllowDeleteStorageAccount {
-			storageAccount, err := NewStorageAccount(
-				logger,
-				Configuration{
-					SubscriptionID:     serviceInstance.SubscriptionID,
-					ResourceGroupName:  serviceInstance.ResourceGroupName,
-					StorageAccountName: serviceInstance.TargetName,
-					UseHTTPS:           serviceInstance.UseHTTPS,
+			// An existing storage account may be used by a service principal which cannot read its subscription, so
+			// the subscription and the resource group are only checked when the storage account cannot be found
+			if exist, err := storageAccount.SDKClient.Exists(); err != nil {
+				if err := b.checkResourceGroup(logger, storageAccount); err != nil {
+					return err
+				}
+				return newAzureError(err, "Failed to check whether storage account exists")
+			} else if exist {
+				logger.Debug("check-storage-account-exist", lager.Data{
+					"message": fmt.Sprintf("The storage account %q exists.", storageAccount.StorageAccountName),
 				})
-			if err != nil {
-				return brokerapi.DeprovisionServiceSpec{}, err
+				if network := b.config.segments.network(serviceInstance.PlanID); network != nil {
+					logger.Info("network-rules-not-applied", lager.Data{"message": fmt.Sprintf("The network rules of the isolation segment %q are only set on the storage accounts which the broker creates", network.Name)})
+				}
+				if cancelled, err := b.cancelStorageAccountDeletion(logger, storageAccount); err != nil {
+					return err
+				} else if cancelled {
+					serviceInstance.IsCreatedStorageAccount = true
+				}
+				serviceInstance.ProvisioningState = provisioningStateSucceeded
+			} else if err := b.checkResourceGroup(logger, storageAccount); err != nil {
+				return err
+			} else if !b.controlConfig(logger).AllowCreateStorageAccount {
+				return newBrokerError(ErrCodeStorageAccountNotFound, "The storage account %q does not exist under the resource group %q in the subscription %q and %s", storageAccount.StorageAccountName, storageAccount.ResourceGroupName, storageAccount.SubscriptionID, b.creationRefusal())
+			} else if !asyncAllowed && b.config.cloud.Control.SynchronousBudget < estimatedStorageAccountCreationDuration {
+				logger.Info("async-required", lager.Data{"synchronousBudget": b.config.cloud.Control.SynchronousBudget.String()})
+				return brokerapi.ErrAsyncRequired
+			} else {
+				if err := b.checkStorageAccountQuota(logger, storageAccount); err != nil {
+					return err
+				}
+				if err := b.checkSkuAvailability(logger, storageAccount); err != nil {
+					return err
+				}
+				serviceInstance.ProvisioningState = provisioningStateCreating
+				serviceInstance.IsCreatedStorageAccount = true
 			}
-			storageAccount.SDKClient, err = NewAzureStorageAccountSDKClient(
+		case provisioningStateCreating:
+			if serviceInstance.OperationURL != "" {
+				// LastOperation will finish the provision
+				return nil
+			}
+
+			restClient, err := NewAzureStorageAccountRESTClient(
 				logger,
 				&b.config.cloud,
 				storageAccount,
 			)
 			if err != nil {
-				return brokerapi.DeprovisionServiceSpec{}, err
+				return err
 			}
-			if ok, err := storageAccount.SDKClient.Exists(); err != nil {
-				return brokerapi.DeprovisionServiceSpec{}, fmt.Errorf("Failed to delete the storage account %q under the resource group %q in the subscription %q: %v", serviceInstance.TargetName, serviceInstance.ResourceGroupName, serviceInstance.SubscriptionID, err)
-			} else if ok {
-				if err := storageAccount.SDKClient.DeleteStorageAccount(); err != nil {
-					return brokerapi.DeprovisionServiceSpec{}, fmt.Errorf("Failed to delete the storage account %q under the resource group %q in the subscription %q: %v", serviceInstance.TargetName, serviceInstance.ResourceGroupName, serviceInstance.SubscriptionID, err)
+
+			if network := b.config.segments.network(serviceInstance.PlanID); network != nil {
+				storageAccount.SubnetIDs = network.SubnetIDs
+			}
+			// Creating a storage account is idempotent, so it is safe to send the request again when resuming
+			operationURL, err := restClient.CreateStorageAccount()
+			if err != nil {
+				return newStorageAccountCreationError(err, storageAccount.StorageAccountName, storageAccount.Location)
+			}
+			storageAccount.OperationURL = operationURL
+			storageAccount.IsCreatedStorageAccount = true
+			serviceInstance.OperationURL = operationURL
+			if operationURL == "" {
+				serviceInstance.ProvisioningState = provisioningStateSucceeded
+			} else if !asyncAllowed {
+				if err := b.store.UpdateServiceInstance(instanceID, *serviceInstance); err != nil {
+					logger.Error("update-service-instance", err, lager.Data{"serviceInstance": serviceInstance})
+					return newStoreError(err, "Failed to update instance details %q", instanceID)
 				}
+				if err := b.waitForCompletion(logger, restClient, operationURL); err != nil {
+					if err != errSynchronousBudgetExceeded {
+						err = newStorageAccountCreationError(err, storageAccount.StorageAccountName, storageAccount.Location)
+						serviceInstance.ProvisioningState = provisioningStateFailed
+						serviceInstance.OperationError = err.Error()
+						if err := b.store.UpdateServiceInstance(instanceID, *serviceInstance); err != nil {
+							logger.Error("update-service-instance", err, lager.Data{"serviceInstance": serviceInstance})
+						}
+					}
+					return err
+				}
+				serviceInstance.ProvisioningState = provisioningStateSucceeded
 			}
+		default:
+			return nil
 		}
-	}
 
-	err = b.store.DeleteServiceInstance(instanceID)
-	if err != nil {
-		return brokerapi.DeprovisionServiceSpec{}, err
+		if serviceInstance.ProvisioningState == provisioningStateSucceeded && serviceInstance.IsCreatedStorageAccount {
+			b.createStorageAccountAlerts(logger, serviceInstance)
+			b.createStorageAccountAccessLogs(logger, serviceInstance)
+		}
+		if err := b.store.UpdateServiceInstance(instanceID, *serviceInstance); err != nil {
+			logger.Error("update-service-instance", err, lager.Data{"serviceInstance": serviceInstance})
+			return newStoreError(err, "Failed to update instance details %q", instanceID)
+		}
 	}
+}
 
-	logger.Debug("service-instance-deleted", lager.Data{"serviceInstance": serviceInstance})
-
-	return brokerapi.DeprovisionServiceSpec{IsAsync: false, OperationData: "deprovision"}, nil
+// waitForCompletion polls the asynchronous operation until it finishes or the synchronous budget is spent
+func (b *Broker) waitForCompletion(logger lager.Logger, restClient AzureStorageAccountRESTClient, operationURL string) error {
+	start := b.clock.Now()
+	for {
+		done, err := restClient.CheckCompletion(operationURL)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if b.clock.Since(start)+synchronousPollInterval > b.config.cloud.Control.SynchronousBudget {
+			logger.Info("synchronous-budget-exceeded", lager.Data{"operationURL": operationURL})
+			return errSynchronousBudgetExceeded
+		}
+		b.clock.Sleep(synchronousPollInterval)
+	}
 }
 
-func (b *Broker) Bind(context context.Context, instanceID string, bindingID string, details brokerapi.BindDetails) (_ brokerapi.Binding, e error) {
-	logger := b.logger.Session("bind").WithData(lager.Data{"instanceID": instanceID, "bindingID": bindingID, "details": details})
+// ResumeProvisioning finishes or rolls back the provisions which were interrupted, e.g. because the broker stopped.
+// Instances which are still pending have not changed anything in Azure, so they are removed.
+// Instances which are creating a storage account send the creation request again.
+func (b *Broker) ResumeProvisioning() error {
+	logger := b.logger.Session("resume-provisioning")
 	logger.Info("start")
 	defer logger.Info("end")
 
+	if !b.isSupportAzureFileShare() {
+		return nil
+	}
+
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
+	serviceInstances, err := b.store.RetrieveServiceInstances()
 	if err != nil {
-		err := brokerapi.ErrInstanceDoesNotExist
-		logger.Error("retrieve-service-instance", err)
-		return brokerapi.Binding{}, err
+		logger.Error("retrieve-service-instances", err)
+		return err
 	}
 
-	if details.AppGUID == "" {
-		err := brokerapi.ErrAppGuidNotProvided
-		logger.Error("missing-app-guid-parameter", err)
-		return brokerapi.Binding{}, err
+	for instanceID, serviceInstance := range serviceInstances {
+		if !serviceInstance.isProvisioningInterrupted() {
+			continue
+		}
+		if err := b.resumeProvisioning(logger, instanceID, serviceInstance.TargetName); err != nil {
+			logger.Error("resume-provisioning", err, lager.Data{"instanceID": instanceID})
+		}
 	}
+	return nil
+}
 
-	var bindOptions BindOptions
-	var decoder = json.NewDecoder(bytes.NewBuffer(details.RawParameters))
-	if err := decoder.Decode(&bindOptions); err != nil {
-		logger.Error("decode-bind-raw-parameters", err, lager.Data{
-			"RawParameters:": details.RawParameters,
-		})
-		return brokerapi.Binding{}, brokerapi.ErrRawParamsInvalid
-	}
-	if err := bindOptions.Validate(serviceInstance.IsPreexisting); err != nil {
-		logger.Error("validate-bind-parameters", err)
-		return brokerapi.Binding{}, err
+func (b *Broker) resumeProvisioning(logger lager.Logger, instanceID, storageAccountName string) error {
+	logger = logger.Session("resume-instance").WithData(lager.Data{"instanceID": instanceID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	// Another broker may still be working on this instance. Wait for it and check the state again.
+	if err := b.getLockForUpdate(storageAccountName); err != nil {
+		return err
 	}
+	defer b.store.ReleaseLockForUpdate(storageAccountName)
 
-	globalMountConfig := b.config.mount.Copy()
-	if err := globalMountConfig.SetEntries(bindOptions.ToMap()); err != nil {
-		logger.Error("set-mount-entries", err, lager.Data{
-			"bindOptions": bindOptions,
-			"mount":       globalMountConfig.MakeConfig(),
-		})
-		return brokerapi.Binding{}, err
+	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	if !serviceInstance.isProvisioningInterrupted() {
+		return nil
 	}
 
-	mountConfig := globalMountConfig.MakeConfig()
-	var source, username, password string
+	if serviceInstance.ProvisioningState == provisioningStatePending {
+		logger.Info("rollback-service-instance", lager.Data{"serviceInstance": serviceInstance})
+		return b.store.DeleteServiceInstance(instanceID)
+	}
 
-	if serviceInstance.IsPreexisting {
+	storageAccount, err := NewStorageAccount(
+		logger,
+		Configuration{
+			SubscriptionID:     serviceInstance.SubscriptionID,
+			ResourceGroupName:  serviceInstance.ResourceGroupName,
+			StorageAccountName: serviceInstance.TargetName,
+			UseHTTPS:           serviceInstance.UseHTTPS,
+			Location:           serviceInstance.Location,
+			SkuName:            serviceInstance.SkuName,
+			EnableEncryption:   serviceInstance.EnableEncryption,
+		})
+	if err != nil {
+		return err
+	}
+	storageAccount.ServicePrincipal = serviceInstance.ServicePrincipal
+	storageAccount.Metrics = b.metrics
+	b.setThrottle(storageAccount)
+
+	logger.Info("resume-service-instance", lager.Data{"serviceInstance": serviceInstance})
+	return b.provisionStorageAccount(logger, instanceID, &serviceInstance, storageAccount, true)
+}
+
+func (b *Broker) deprovision(context context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (_ brokerapi.DeprovisionServiceSpec, e error) {
+	logger := b.logger.Session("deprovision").WithData(lager.Data{"instanceID": instanceID, "details": details, "asyncAllowed": asyncAllowed})
+	logger.Info("start")
+	defer logger.Info("end")
+	defer func() {
+		e = toFailureResponse(e)
+	}()
+
+	if err := b.lockMutex(); err != nil {
+		logger.Error("lock-broker", err)
+		return brokerapi.DeprovisionServiceSpec{}, err
+	}
+	defer b.mutex.Unlock()
+
+	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
+	if err != nil {
+		logger.Error("retrieve-service-instance", err)
+		return brokerapi.DeprovisionServiceSpec{}, goneOrStoreError(err, brokerapi.ErrInstanceDoesNotExist, "Failed to retrieve the service instance %q", instanceID)
+	}
+
+	if serviceInstance.ProvisioningState == provisioningStateDeleting {
+		logger.Info("storage-account-deletion-in-progress")
+		return brokerapi.DeprovisionServiceSpec{IsAsync: true, OperationData: operationDeprovision}, nil
+	}
+	if serviceInstance.isMigrating() {
+		return brokerapi.DeprovisionServiceSpec{}, newBrokerError(ErrCodeOperationInProgress, "The service instance cannot be deleted while its file shares are migrated to the storage account %q", serviceInstance.Migration.TargetName)
+	}
+
+	storageAccountDeleted := false
+	if !serviceInstance.IsPreexisting {
+		if serviceInstance.IsCreatedStorageAccount && b.controlConfig(logger).AllowDeleteStorageAccount {
+			storageAccount, err := b.newStorageAccountWithSDKClient(logger, &serviceInstance)
+			if err != nil {
+				return brokerapi.DeprovisionServiceSpec{}, err
+			}
+			ok, err := storageAccount.SDKClient.Exists()
+			if err != nil {
+				return brokerapi.DeprovisionServiceSpec{}, newAzureError(err, "Failed to delete the storage account %q under the resource group %q in the subscription %q", serviceInstance.TargetName, serviceInstance.ResourceGroupName, serviceInstance.SubscriptionID)
+			}
+			if ok {
+				if err := b.checkForeignFileShares(logger, instanceID, &serviceInstance, storageAccount); err != nil {
+					return brokerapi.DeprovisionServiceSpec{}, err
+				}
+			}
+			if ok && b.config.cloud.Control.DeletionRetentionPeriod > 0 {
+				if err := b.scheduleStorageAccountDeletion(logger, storageAccount, serviceInstance); err != nil {
+					return brokerapi.DeprovisionServiceSpec{}, err
+				}
+				if err := b.removeServiceInstance(logger, instanceID, serviceInstance, false); err != nil {
+					return brokerapi.DeprovisionServiceSpec{}, err
+				}
+				b.emitDeprovisionAuditEvent(logger, instanceID, &serviceInstance)
+				return brokerapi.DeprovisionServiceSpec{IsAsync: false, OperationData: operationDeprovision}, nil
+			} else if ok && asyncAllowed {
+				restClient, err := NewAzureStorageAccountRESTClient(logger, &b.config.cloud, storageAccount)
+				if err != nil {
+					return brokerapi.DeprovisionServiceSpec{}, err
+				}
+				operationURL, err := restClient.DeleteStorageAccount()
+				if err != nil {
+					return brokerapi.DeprovisionServiceSpec{}, newAzureError(err, "Failed to delete the storage account %q under the resource group %q in the subscription %q", serviceInstance.TargetName, serviceInstance.ResourceGroupName, serviceInstance.SubscriptionID)
+				}
+				b.notifyDeletion(logger, deletionInitiatorDeprovision, instanceID, &serviceInstance, "")
+				if operationURL != "" {
+					serviceInstance.ProvisioningState = provisioningStateDeleting
+					serviceInstance.OperationURL = operationURL
+					if err := b.store.UpdateServiceInstance(instanceID, serviceInstance); err != nil {
+						logger.Error("update-service-instance", err, lager.Data{"serviceInstance": serviceInstance})
+						return brokerapi.DeprovisionServiceSpec{}, err
+					}
+					return brokerapi.DeprovisionServiceSpec{IsAsync: true, OperationData: operationDeprovision}, nil
+				}
+			} else if ok {
+				if err := storageAccount.SDKClient.DeleteStorageAccount(); err != nil {
+					return brokerapi.DeprovisionServiceSpec{}, newAzureError(err, "Failed to delete the storage account %q under the resource group %q in the subscription %q", serviceInstance.TargetName, serviceInstance.ResourceGroupName, serviceInstance.SubscriptionID)
+				}
+				b.notifyDeletion(logger, deletionInitiatorDeprovision, instanceID, &serviceInstance, "")
+			}
+			storageAccountDeleted = true
+		}
+	}
+
+	if err := b.removeServiceInstance(logger, instanceID, serviceInstance, storageAccountDeleted); err != nil {
+		return brokerapi.DeprovisionServiceSpec{}, err
+	}
+	b.emitDeprovisionAuditEvent(logger, instanceID, &serviceInstance)
+
+	return brokerapi.DeprovisionServiceSpec{IsAsync: false, OperationData: operationDeprovision}, nil
+}
+
+// checkForeignFileShares refuses to delete a storage account which has file shares not created by the broker, e.g.
+// shares created out-of-band by a user who reused the storage account. A share is created by the broker if it has the
+// creator metadata of the broker, or if the store records it as created by the broker, which is how the shares of older
+// versions of the broker without the metadata are recognized. The operator may still delete the storage account with
+// AllowDeleteForeignFileShares. instanceID is empty when the service instance is already deleted.
+func (b *Broker) checkForeignFileShares(logger lager.Logger, instanceID string, serviceInstance *ServiceInstance, storageAccount *StorageAccount) error {
+	shares, err := storageAccount.SDKClient.ListFileShares()
+	if err != nil {
+		return newAzureError(err, "Failed to list the file shares in the storage account %q", storageAccount.StorageAccountName)
+	}
+
+	foreignShares := []string{}
+	for name, creator := range shares {
+		if creator == b.config.cloud.Azure.CreatorTagValue {
+			continue
+		}
+		created, err := b.isFileShareCreatedInStore(instanceID, serviceInstance, name)
+		if err != nil {
+			return err
+		}
+		if !created {
+			foreignShares = append(foreignShares, name)
+		}
+	}
+	if len(foreignShares) > 0 {
+		sort.Strings(foreignShares)
+		if b.controlConfig(logger).AllowDeleteForeignFileShares {
+			logger.Info("deleting-foreign-file-shares", lager.Data{"fileShares": foreignShares})
+			return nil
+		}
+		logger.Info("foreign-file-shares-exist", lager.Data{"fileShares": foreignShares})
+		return newBrokerError(ErrCodeForeignFileSharesExist, "The storage account %q is not deleted because it has file shares which are not created by the broker: %s", storageAccount.StorageAccountName, strings.Join(foreignShares, ", "))
+	}
+	return nil
+}
+
+// isFileShareCreatedInStore returns true if the owner of the share in the store, or else the file share of the
+// instance, records that the broker created the share
+func (b *Broker) isFileShareCreatedInStore(instanceID string, serviceInstance *ServiceInstance, fileShareName string) (bool, error) {
+	owner, err := b.store.RetrieveFileShareOwner(getFileShareOwnerID(serviceInstance, fileShareName))
+	if err == nil {
+		if owner.OwnerFileShareID != "" {
+			return true, nil
+		}
+	} else if err != brokerapi.ErrInstanceDoesNotExist {
+		return false, newStoreError(err, "Failed to retrieve the owner of the file share %q", fileShareName)
+	}
+
+	if instanceID == "" {
+		return false, nil
+	}
+	share, err := b.store.RetrieveFileShare(getFileShareID(instanceID, fileShareName))
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		return false, nil
+	} else if err != nil {
+		return false, newStoreError(err, "Failed to retrieve the file share %q", fileShareName)
+	}
+	return share.IsCreated, nil
+}
+
+// emitDeprovisionAuditEvent records the deletion of the service instance in the audit log
+func (b *Broker) emitDeprovisionAuditEvent(logger lager.Logger, instanceID string, serviceInstance *ServiceInstance) {
+	b.emitAuditEvent(logger, AuditEvent{
+		Type:              AuditEventServiceInstanceDelete,
+		ServiceInstanceID: instanceID,
+		OrganizationGUID:  serviceInstance.OrganizationGUID,
+		SpaceGUID:         serviceInstance.SpaceGUID,
+		ResourceIDs:       auditResourceIDs(serviceInstance, ""),
+	})
+}
+
+// removeServiceInstance deletes the service instance from the store, and the owner of its storage account if the
+// storage account has been deleted
+func (b *Broker) removeServiceInstance(logger lager.Logger, instanceID string, serviceInstance ServiceInstance, storageAccountDeleted bool) error {
+	if storageAccountDeleted {
+		ownerID := getStorageAccountOwnerID(serviceInstance.SubscriptionID, serviceInstance.ResourceGroupName, serviceInstance.TargetName)
+		if err := b.store.DeleteStorageAccountOwner(ownerID); err != nil {
+			logger.Error("delete-storage-account-owner", err)
+		}
+		b.deleteStorageAccountAlerts(logger, &serviceInstance)
+		b.deleteStorageAccountAccessLogs(logger, &serviceInstance)
+	}
+
+	if err := b.store.DeleteServiceInstance(instanceID); err != nil {
+		return err
+	}
+
+	logger.Debug("service-instance-deleted", lager.Data{"serviceInstance": serviceInstance})
+	b.deleteUnusedSecret(logger, serviceInstance.ServicePrincipal)
+	return nil
+}
+
+func (b *Broker) bind(context context.Context, instanceID string, bindingID string, details brokerapi.BindDetails) (_ brokerapi.Binding, e error) {
+	logger := b.logger.Session("bind").WithData(lager.Data{"instanceID": instanceID, "bindingID": bindingID, "details": details})
+	logger.Info("start")
+	defer logger.Info("end")
+	defer func() {
+		e = toFailureResponse(e)
+	}()
+
+	if err := b.lockMutex(); err != nil {
+		logger.Error("lock-broker", err)
+		return brokerapi.Binding{}, err
+	}
+	defer b.mutex.Unlock()
+
+	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
+	if err != nil {
+		err := brokerapi.ErrInstanceDoesNotExist
+		logger.Error("retrieve-service-instance", err)
+		return brokerapi.Binding{}, err
+	}
+
+	if isServiceKey(details.AppGUID) && !b.config.cloud.Control.AllowServiceKeys {
+		err := brokerapi.ErrAppGuidNotProvided
+		logger.Error("missing-app-guid-parameter", err)
+		return brokerapi.Binding{}, err
+	}
+	if serviceInstance.isMigrating() {
+		return brokerapi.Binding{}, newBrokerError(ErrCodeOperationInProgress, "The service instance cannot be bound while its file shares are migrated to the storage account %q", serviceInstance.Migration.TargetName)
+	}
+
+	// A rebuilt binding has the stored bind options, which the validation webhook has already accepted
+	isRebuild := isBindingRebuild(context)
+	if !isRebuild {
+		rawParameters, err := b.validate(logger, ValidationRequest{
+			Operation:        ValidationOperationBind,
+			InstanceID:       instanceID,
+			BindingID:        bindingID,
+			ServiceID:        details.ServiceID,
+			PlanID:           details.PlanID,
+			OrganizationGUID: serviceInstance.OrganizationGUID,
+			SpaceGUID:        serviceInstance.SpaceGUID,
+			AppGUID:          details.AppGUID,
+			Parameters:       details.RawParameters,
+		})
+		if err != nil {
+			return brokerapi.Binding{}, err
+		}
+		details.RawParameters = rawParameters
+	}
+
+	var bindOptions BindOptions
+	var decoder = json.NewDecoder(bytes.NewBuffer(details.RawParameters))
+	if err := decoder.Decode(&bindOptions); err != nil {
+		logger.Error("decode-bind-raw-parameters", err, lager.Data{
+			"RawParameters:": details.RawParameters,
+		})
+		return brokerapi.Binding{}, brokerapi.ErrRawParamsInvalid
+	}
+	if err := bindOptions.Validate(serviceInstance.IsPreexisting); err != nil {
+		logger.Error("validate-bind-parameters", err)
+		return brokerapi.Binding{}, err
+	}
+	if bindOptions.AccessPolicy != nil {
+		accessPolicy, _ := bindOptions.AccessPolicy.normalized()
+		if !accessPolicy.isReference() && !accessPolicy.Expiry.After(b.clock.Now()) {
+			return brokerapi.Binding{}, newBrokerError(ErrCodeInvalidParameters, "The access policy %q has expired", accessPolicy.ID)
+		}
+		bindOptions.AccessPolicy = &accessPolicy
+	}
+	credentialType := b.bindCredentialType(&serviceInstance)
+	if !serviceInstance.IsPreexisting && credentialType == CredentialTypeSAS && bindOptions.AccessPolicy == nil {
+		return brokerapi.Binding{}, newBrokerError(ErrCodeInvalidParameters, "The plan %s only returns SAS tokens: the parameter access_policy must be given", b.instancePlanName(&serviceInstance))
+	}
+
+	// The binding is looked up under the lock of its file share, so that a bind of the same binding on another broker
+	// either finds it or waits until it is created, and the file share counts it once
+	if !serviceInstance.IsPreexisting {
+		fileShareID := getFileShareID(instanceID, bindOptions.FileShareName)
+		if err := b.getLockForUpdate(fileShareID); err != nil {
+			logger.Error("get-lock-for-update", err)
+			return brokerapi.Binding{}, err
+		}
+		defer b.store.ReleaseLockForUpdate(fileShareID)
+	}
+
+	isDuplicate := false
+	existingBindingDetails, err := b.store.RetrieveBindingDetails(bindingID)
+	if err == nil {
+		if !isRebuild && !existingBindingDetails.isSameRequest(details, bindOptions) {
+			err := brokerapi.ErrBindingAlreadyExists
+			logger.Error("binding-already-exists-with-different-parameters", err)
+			return brokerapi.Binding{}, err
+		}
+		logger.Info("binding-already-exists")
+		isDuplicate = true
+	} else if isRebuild {
+		// The binding was deleted since it was read, so it must not be created again
+		return brokerapi.Binding{}, brokerapi.ErrBindingDoesNotExist
+	}
+
+	globalMountConfig := b.config.mount.forPlan(b.instancePlanName(&serviceInstance))
+	if err := globalMountConfig.SetEntries(bindOptions.ToMap()); err != nil {
+		logger.Error("set-mount-entries", err, lager.Data{
+			"bindOptions": bindOptions,
+			"mount":       globalMountConfig.MakeConfig(),
+		})
+		return brokerapi.Binding{}, err
+	}
+
+	mountConfig := globalMountConfig.MakeConfig()
+	// A read-only instance is mounted read-only whatever the bind parameters and the forced mount options
+	if serviceInstance.Readonly {
+		mountConfig["readonly"] = strconv.FormatBool(true)
+		bindOptions.Readonly = true
+	}
+	var source, username, password, shareSAS string
+	bindingDetails := BindingDetails{
+		BindDetails:      details,
+		InstanceID:       instanceID,
+		VolumeIDVersion:  volumeIDVersionSHA256,
+		MinBrokerVersion: minBrokerCompatibilityVersion,
+		DatabaseVersion:  databaseVersion,
+	}
+	if isDuplicate {
+		// Keep the volume ID of the existing binding, which is the legacy one if the binding was created by an older broker
+		bindingDetails.VolumeIDVersion = existingBindingDetails.VolumeIDVersion
+	}
+
+	if serviceInstance.IsPreexisting {
 		// Bind for preexisting shares
 		if bindOptions.Domain != "" {
 			mountConfig["domain"] = bindOptions.Domain
@@ -518,18 +1376,47 @@ func (b *Broker) Bind(context context.Context, instanceID string, bindingID stri
 		source = serviceInstance.TargetName
 		username = bindOptions.Username
 		password = bindOptions.Password
-	} else {
-		// Bind for AzureFileShare
-		fileShareName := bindOptions.FileShareName
 
-		fileShareID := getFileShareID(instanceID, fileShareName)
-		err = b.store.GetLockForUpdate(fileShareID, lockTimeoutInSeconds)
+		redactedBindOptions := bindOptions.redacted()
+		bindingDetails.BindOptions = &redactedBindOptions
+	} else if isDuplicate {
+		// Rebuild the response of the existing binding without touching the share count
+		fileShare, err := b.store.RetrieveFileShare(getFileShareID(instanceID, bindOptions.FileShareName))
 		if err != nil {
-			logger.Error("get-lock-for-update", err)
+			logger.Error("retrieve-file-share", err)
+			return brokerapi.Binding{}, err
+		}
+		storageAccount, err := b.newStorageAccountWithSDKClient(logger, &serviceInstance)
+		if err != nil {
+			return brokerapi.Binding{}, err
+		}
+
+		source = fileShare.URL
+		username = serviceInstance.TargetName
+		if credentialType == CredentialTypeKey {
+			password, err = storageAccount.SDKClient.GetAccessKey()
+			if err != nil {
+				return brokerapi.Binding{}, err
+			}
+		}
+		if bindOptions.AccessPolicy != nil {
+			if shareSAS, err = b.issueShareSAS(logger, &serviceInstance, bindOptions.FileShareName, *bindOptions.AccessPolicy); err != nil {
+				return brokerapi.Binding{}, err
+			}
+		}
+	} else {
+		// Bind for AzureFileShare
+		if err := b.config.naming.Share.check("file share", bindOptions.FileShareName, details.RawContext); err != nil {
+			logger.Error("check-share-naming-policy", err)
+			return brokerapi.Binding{}, err
+		}
+		ownerID := getStorageAccountOwnerID(serviceInstance.SubscriptionID, serviceInstance.ResourceGroupName, serviceInstance.TargetName)
+		if _, err := b.claimStorageAccount(logger, ownerID, serviceInstance.TargetName, serviceInstance.OrganizationGUID, serviceInstance.SpaceGUID); err != nil {
 			return brokerapi.Binding{}, err
 		}
-		defer b.store.ReleaseLockForUpdate(fileShareID)
 
+		fileShareName := bindOptions.FileShareName
+		fileShareID := getFileShareID(instanceID, fileShareName)
 		fileShare, err := b.store.RetrieveFileShare(fileShareID)
 		if err != nil {
 			if err != brokerapi.ErrInstanceDoesNotExist {
@@ -548,15 +1435,21 @@ func (b *Broker) Bind(context context.Context, instanceID string, bindingID stri
 			}
 			err = nil
 		}
-		storageAccount, err := b.handleBindShare(logger, &serviceInstance, &fileShare)
+		directories, _ := normalizedShareDirectories(bindOptions.Directories)
+		storageAccount, err := b.handleBindShare(logger, &serviceInstance, &fileShare, bindOptions.ShareSAS, directories)
 		if err != nil {
 			return brokerapi.Binding{}, err
 		}
+		if bindOptions.AccessPolicy != nil {
+			if shareSAS, err = b.issueShareSAS(logger, &serviceInstance, fileShareName, *bindOptions.AccessPolicy); err != nil {
+				return brokerapi.Binding{}, err
+			}
+		}
 
 		if fileShare.Count == 1 {
 			logger.Info("inserting-file-share-into-store", lager.Data{"fileShare": fileShare})
 			if err := b.store.CreateFileShare(fileShareID, fileShare); err != nil {
-				err = fmt.Errorf("Faied to insert file share into the store for %q: %v", fileShareID, err)
+				err = newStoreError(err, "Faied to insert file share into the store for %q", fileShareID)
 				logger.Error("insert-file-share-into-store", err)
 				return brokerapi.Binding{}, err
 			}
@@ -564,7 +1457,7 @@ func (b *Broker) Bind(context context.Context, instanceID string, bindingID stri
 		} else {
 			logger.Info("updating-file-share-in-store", lager.Data{"fileShare": fileShare})
 			if err := b.store.UpdateFileShare(fileShareID, fileShare); err != nil {
-				err = fmt.Errorf("Faied to update file share in the store for %q: %v", fileShareID, err)
+				err = newStoreError(err, "Faied to update file share in the store for %q", fileShareID)
 				logger.Error("update-file-share-in-store", err)
 				return brokerapi.Binding{}, err
 			}
@@ -573,25 +1466,44 @@ func (b *Broker) Bind(context context.Context, instanceID string, bindingID stri
 
 		source = fileShare.URL
 		username = serviceInstance.TargetName
-		password, err = storageAccount.SDKClient.GetAccessKey()
+		if credentialType == CredentialTypeKey {
+			password, err = storageAccount.SDKClient.GetAccessKey()
+			if err != nil {
+				return brokerapi.Binding{}, err
+			}
+		}
+
+		redactedBindOptions := bindOptions.redacted()
+		bindingDetails.BindOptions = &redactedBindOptions
+		bindingDetails.FileShareID = fileShareID
+	}
+
+	if !isDuplicate {
+		bindingDetails.ParamsHash, err = hashParams(details.RawParameters)
 		if err != nil {
+			logger.Error("hash-bind-raw-parameters", err)
 			return brokerapi.Binding{}, err
 		}
-	}
+		bindingDetails.RawParameters = nil
 
-	err = b.store.CreateBindingDetails(bindingID, details, serviceInstance.IsPreexisting)
-	if err != nil {
-		logger.Error("create-binding-details", err)
-		return brokerapi.Binding{}, err
-	}
+		err = b.store.CreateBindingDetails(bindingID, bindingDetails)
+		if err != nil {
+			logger.Error("create-binding-details", err)
+			return brokerapi.Binding{}, err
+		}
+
+		logger.Info("binding-details-created")
 
-	logger.Info("binding-details-created")
+		if !serviceInstance.IsPreexisting {
+			b.refreshBoundAppsMetadata(logger, &serviceInstance, bindOptions.FileShareName, "")
+		}
+	}
 
 	mountConfig["source"] = source
 	mountConfig["username"] = username
 	logger.Debug("volume-service-binding", lager.Data{"driver": "smbdriver", "mountConfig": mountConfig, "source": source})
 
-	s, err := b.hash(mountConfig)
+	s, err := volumeIDHash(bindingDetails.VolumeIDVersion, mountConfig)
 	if err != nil {
 		logger.Error("error-calculating-volume-id", err, lager.Data{"config": mountConfig})
 		return brokerapi.Binding{}, err
@@ -602,8 +1514,18 @@ func (b *Broker) Bind(context context.Context, instanceID string, bindingID stri
 	}
 	volumeID := fmt.Sprintf("%s-%s", instanceID, s)
 
+	var credentials interface{} = struct{}{} // if nil, cloud controller chokes on response
+	if shareSAS != "" {
+		credentials = map[string]string{
+			"storage_account_name": serviceInstance.TargetName,
+			"file_share_name":      bindOptions.FileShareName,
+			"access_policy_id":     bindOptions.AccessPolicy.ID,
+			"share_sas":            shareSAS,
+		}
+	}
+
 	ret := brokerapi.Binding{
-		Credentials: struct{}{}, // if nil, cloud controller chokes on response
+		Credentials: credentials,
 		VolumeMounts: []brokerapi.VolumeMount{{
 			ContainerDir: evaluateContainerPath(bindOptions, instanceID),
 			Mode:         readOnlyToMode(bindOptions.Readonly),
@@ -615,41 +1537,80 @@ func (b *Broker) Bind(context context.Context, instanceID string, bindingID stri
 			},
 		}},
 	}
+	if isServiceKey(details.AppGUID) {
+		// A service key has no app to mount the share, so it returns the credentials of the mount instead
+		ret = brokerapi.Binding{Credentials: serviceKeyCredentials(&serviceInstance, bindOptions, mountConfig, shareSAS)}
+	}
 
+	if !isDuplicate {
+		b.emitAuditEvent(logger, AuditEvent{
+			Type:              AuditEventServiceBindingCreate,
+			ServiceInstanceID: instanceID,
+			BindingID:         bindingID,
+			OrganizationGUID:  serviceInstance.OrganizationGUID,
+			SpaceGUID:         serviceInstance.SpaceGUID,
+			AppGUID:           details.AppGUID,
+			ResourceIDs:       auditResourceIDs(&serviceInstance, bindOptions.FileShareName),
+		})
+		b.sendLifecycleEvent(logger, LifecycleEventBindingCreate, &serviceInstance, LifecycleEvent{
+			ServiceInstanceID: instanceID,
+			BindingID:         bindingID,
+			AppGUID:           details.AppGUID,
+			FileShareName:     bindOptions.FileShareName,
+		})
+	}
 	return ret, nil
 }
 
-func (b *Broker) handleBindShare(logger lager.Logger, serviceInstance *ServiceInstance, share *FileShare) (*StorageAccount, error) {
+// verifyShareOwnership checks that the SAS token of the bind parameters grants access to an existing file share so that
+// an instance cannot bind to a share whose name is merely known
+func verifyShareOwnership(logger lager.Logger, storageAccount *StorageAccount, fileShareName, shareSAS string) error {
+	if shareSAS == "" {
+		return newBrokerError(ErrCodeShareOwnershipNotProven, "The file share %q exists in the storage account %q and the administrator requires the parameter share_sas with a SAS token which can list it to bind to it", fileShareName, storageAccount.StorageAccountName)
+	}
+	if err := storageAccount.SDKClient.VerifyShareSAS(fileShareName, shareSAS); err != nil {
+		logger.Error("verify-share-sas", err)
+		return newBrokerError(ErrCodeShareOwnershipNotProven, "The parameter share_sas does not grant access to the file share %q in the storage account %q: %v", fileShareName, storageAccount.StorageAccountName, err)
+	}
+	logger.Info("share-ownership-verified")
+	return nil
+}
+
+func (b *Broker) handleBindShare(logger lager.Logger, serviceInstance *ServiceInstance, share *FileShare, shareSAS string, directories []string) (*StorageAccount, error) {
 	logger = logger.Session("handle-bind-share").WithData(lager.Data{"FileShareName": share.FileShareName})
 	logger.Info("start")
 	defer logger.Info("end")
 
-	storageAccount, err := NewStorageAccount(
-		logger,
-		Configuration{
-			SubscriptionID:     serviceInstance.SubscriptionID,
-			ResourceGroupName:  serviceInstance.ResourceGroupName,
-			StorageAccountName: serviceInstance.TargetName,
-			UseHTTPS:           serviceInstance.UseHTTPS,
-		})
-	if err != nil {
+	// Other instances may use the same storage account, so the check and the creation of the share are done under the lock
+	// of the share in the storage account
+	ownerID := getFileShareOwnerID(serviceInstance, share.FileShareName)
+	if err := b.getLockForUpdate(ownerID); err != nil {
+		logger.Error("get-lock-for-update", err)
 		return nil, err
 	}
-	storageAccount.SDKClient, err = NewAzureStorageAccountSDKClient(
-		logger,
-		&b.config.cloud,
-		storageAccount,
-	)
+	defer b.store.ReleaseLockForUpdate(ownerID)
+
+	storageAccount, err := b.newStorageAccountWithSDKClient(logger, serviceInstance)
 	if err != nil {
 		return nil, err
 	}
 
 	exist, err := storageAccount.SDKClient.HasFileShare(share.FileShareName)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to check whether the file share %q exists: %v", share.FileShareName, err)
+		return nil, newAzureError(err, "Failed to check whether the file share %q exists", share.FileShareName)
 	}
 
 	if exist {
+		cancelled, err := b.cancelFileShareDeletion(logger, storageAccount, serviceInstance, share.FileShareName)
+		if err != nil {
+			return nil, err
+		} else if cancelled {
+			share.IsCreated = true
+		} else if share.Count == 0 && b.config.cloud.Control.RequireShareOwnershipProof {
+			if err := verifyShareOwnership(logger, storageAccount, share.FileShareName, shareSAS); err != nil {
+				return nil, err
+			}
+		}
 		share.Count++
 		if share.URL == "" {
 			shareURL, err := storageAccount.SDKClient.GetShareURL(share.FileShareName)
@@ -660,26 +1621,146 @@ func (b *Broker) handleBindShare(logger lager.Logger, serviceInstance *ServiceIn
 		}
 		logger.Debug("file-share-get", lager.Data{"share": share})
 	} else {
-		if !b.config.cloud.Control.AllowCreateFileShare {
-			return nil, fmt.Errorf("The file share %q does not exist in the storage account %q and the administrator does not allow to create it automatically", share.FileShareName, storageAccount.StorageAccountName)
+		if !b.controlConfig(logger).AllowCreateFileShare {
+			return nil, newBrokerError(ErrCodeShareCreationForbidden, "The file share %q does not exist in the storage account %q and %s", share.FileShareName, storageAccount.StorageAccountName, b.creationRefusal())
 		}
 		if err := storageAccount.SDKClient.CreateFileShare(share.FileShareName); err != nil {
-			return nil, fmt.Errorf("Failed to create file share %q in the storage account %q: %v", share.FileShareName, storageAccount.StorageAccountName, err)
+			return nil, newAzureError(err, "Failed to create file share %q in the storage account %q", share.FileShareName, storageAccount.StorageAccountName)
+		}
+		if err := b.createShareDirectories(logger, storageAccount, share.FileShareName, directories); err != nil {
+			return nil, err
 		}
 		share.IsCreated = true
 		share.Count = 1
+		// The background protector registers the share with the vault, which takes minutes
+		if serviceInstance.Backup != nil {
+			share.BackupState = backupStateRefreshContainers
+		}
 		shareURL, err := storageAccount.SDKClient.GetShareURL(share.FileShareName)
 		if err != nil {
 			return nil, err
 		}
 		share.URL = shareURL
 		logger.Debug("file-share-created", lager.Data{"share": share})
+		b.sendLifecycleEvent(logger, LifecycleEventShareCreate, serviceInstance, LifecycleEvent{ServiceInstanceID: share.InstanceID, FileShareName: share.FileShareName})
+	}
+
+	if share.Count == 1 {
+		if err := b.registerFileShareUser(logger, ownerID, share); err != nil {
+			return nil, err
+		}
+	}
+
+	return storageAccount, nil
+}
+
+// setThrottle shapes the requests of the storage account. Only the operations of the platform have a context, so the
+// requests of the background jobs and the admin API are slowed down first when the subscription has few requests left.
+// Their outcomes are recorded in the health of Azure, and the failed ones are tried again by the retrier of Azure.
+func (b *Broker) setThrottle(storageAccount *StorageAccount) {
+	storageAccount.Throttle = b.throttle
+	storageAccount.Background = b.ctx == nil
+	storageAccount.Health = b.health
+	storageAccount.Retrier = b.azureRetrier
+}
+
+// newStorageAccountOfInstance returns the storage account of an AzureFileShare instance without clients
+func (b *Broker) newStorageAccountOfInstance(logger lager.Logger, serviceInstance *ServiceInstance) (*StorageAccount, error) {
+	storageAccount, err := NewStorageAccount(
+		logger,
+		Configuration{
+			SubscriptionID:     serviceInstance.SubscriptionID,
+			ResourceGroupName:  serviceInstance.ResourceGroupName,
+			StorageAccountName: serviceInstance.TargetName,
+			UseHTTPS:           serviceInstance.UseHTTPS,
+		})
+	if err != nil {
+		return nil, err
 	}
+	storageAccount.ServicePrincipal = serviceInstance.ServicePrincipal
+	storageAccount.Context = b.ctx
+	storageAccount.Metrics = b.metrics
+	b.setThrottle(storageAccount)
+	return storageAccount, nil
+}
 
+func (b *Broker) newStorageAccountWithSDKClient(logger lager.Logger, serviceInstance *ServiceInstance) (*StorageAccount, error) {
+	storageAccount, err := b.newStorageAccountOfInstance(logger, serviceInstance)
+	if err != nil {
+		return nil, err
+	}
+	storageAccount.SDKClient, err = NewAzureStorageAccountSDKClient(
+		logger,
+		&b.config.cloud,
+		storageAccount,
+	)
+	if err != nil {
+		return nil, err
+	}
 	return storageAccount, nil
 }
 
-func (b *Broker) hash(mountConfig map[string]interface{}) (string, error) {
+// hashParams returns a salted SHA-256 hash of the raw parameters in the format "<salt>:<hash>"
+func hashParams(rawParameters []byte) (string, error) {
+	salt := make([]byte, paramsHashSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return formatParamsHash(salt, rawParameters), nil
+}
+
+func formatParamsHash(salt, rawParameters []byte) string {
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write(rawParameters)
+	return fmt.Sprintf("%x:%x", salt, hash.Sum(nil))
+}
+
+func matchParamsHash(paramsHash string, rawParameters []byte) bool {
+	parts := strings.SplitN(paramsHash, ":", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(formatParamsHash(salt, rawParameters)), []byte(paramsHash)) == 1
+}
+
+// volumeIDHash returns the hash in the volume ID of a binding. Bindings created by older versions of the broker
+// have no version and keep the legacy MD5 hash so that their volume ID does not change.
+func volumeIDHash(version string, mountConfig map[string]interface{}) (string, error) {
+	if version == "" {
+		return legacyVolumeIDHash(mountConfig)
+	}
+	bytes, err := canonicalVolumeIdentity(mountConfig)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(bytes)), nil
+}
+
+// canonicalVolumeIdentity serializes the mount entries which identify the volume as a JSON list of key/value pairs
+// sorted by key. Values are formatted as strings so that the serialization does not depend on their types.
+func canonicalVolumeIdentity(mountConfig map[string]interface{}) ([]byte, error) {
+	keys := make([]string, 0, len(mountConfig))
+	for key := range mountConfig {
+		if volumeIDExcludedKeys[key] {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([][2]string, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, [2]string{key, fmt.Sprint(mountConfig[key])})
+	}
+	return json.Marshal(entries)
+}
+
+func legacyVolumeIDHash(mountConfig map[string]interface{}) (string, error) {
 	var (
 		bytes []byte
 		err   error
@@ -690,36 +1771,48 @@ func (b *Broker) hash(mountConfig map[string]interface{}) (string, error) {
 	return fmt.Sprintf("%x", md5.Sum(bytes)), nil
 }
 
-func (b *Broker) Unbind(context context.Context, instanceID string, bindingID string, details brokerapi.UnbindDetails) (e error) {
+func (b *Broker) unbind(context context.Context, instanceID string, bindingID string, details brokerapi.UnbindDetails) (e error) {
 	logger := b.logger.Session("unbind").WithData(lager.Data{"instanceID": instanceID, "bindingID": bindingID, "details": details})
 	logger.Info("start")
 	defer logger.Info("end")
+	defer func() {
+		e = toFailureResponse(e)
+	}()
 
-	b.mutex.Lock()
+	if err := b.lockMutex(); err != nil {
+		logger.Error("lock-broker", err)
+		return err
+	}
 	defer b.mutex.Unlock()
 
 	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
 	if err != nil {
 		logger.Error("retrieve-service-instance", err)
-		return brokerapi.ErrInstanceDoesNotExist
+		return goneOrStoreError(err, brokerapi.ErrInstanceDoesNotExist, "Failed to retrieve the service instance %q", instanceID)
 	}
-	bindDetails, err := b.store.RetrieveBindingDetails(bindingID)
-	if err != nil {
+	bindingDetails, err := b.store.RetrieveBindingDetails(bindingID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		return b.unbindMissingBinding(logger, instanceID, bindingID, serviceInstance)
+	} else if err != nil {
 		logger.Error("retrieve-binding-details", err)
-		return brokerapi.ErrBindingDoesNotExist
+		return goneOrStoreError(err, brokerapi.ErrBindingDoesNotExist, "Failed to retrieve the binding %q", bindingID)
 	}
 
+	fileShareName := ""
 	if !serviceInstance.IsPreexisting {
-		var bindOptions BindOptions
-		var decoder = json.NewDecoder(bytes.NewBuffer(bindDetails.RawParameters))
-		if err := decoder.Decode(&bindOptions); err != nil {
-			logger.Error("decode-bind-raw-parameters", err)
-			return brokerapi.ErrRawParamsInvalid
+		fileShareID := bindingDetails.FileShareID
+		if fileShareID == "" {
+			// Bindings created by older versions of the broker only have the raw parameters
+			var bindOptions BindOptions
+			var decoder = json.NewDecoder(bytes.NewBuffer(bindingDetails.RawParameters))
+			if err := decoder.Decode(&bindOptions); err != nil {
+				logger.Error("decode-bind-raw-parameters", err)
+				return brokerapi.ErrRawParamsInvalid
+			}
+			fileShareID = getFileShareID(instanceID, bindOptions.FileShareName)
 		}
-		fileShareName := bindOptions.FileShareName
 
-		fileShareID := getFileShareID(instanceID, fileShareName)
-		err = b.store.GetLockForUpdate(fileShareID, lockTimeoutInSeconds)
+		err = b.getLockForUpdate(fileShareID)
 		if err != nil {
 			logger.Error("get-lock-for-update", err)
 			return err
@@ -727,19 +1820,31 @@ func (b *Broker) Unbind(context context.Context, instanceID string, bindingID st
 		defer b.store.ReleaseLockForUpdate(fileShareID)
 
 		fileShare, err := b.store.RetrieveFileShare(fileShareID)
-		if err != nil {
+		if err == brokerapi.ErrInstanceDoesNotExist {
+			// The file share was already removed from the store, e.g. by the repair of the share counts, so only the
+			// binding is left to delete. Returning gone here would leave the binding in the store.
+			logger.Info("file-share-not-in-store", lager.Data{"fileShareID": fileShareID})
+			return b.deleteBinding(logger, instanceID, bindingID, serviceInstance, bindingDetails, "")
+		} else if err != nil {
 			logger.Error("retrieve-file-share", err)
 			return err
 		}
+		fileShareName = fileShare.FileShareName
 
-		if err := b.handleUnbindShare(logger, &serviceInstance, &fileShare); err != nil {
-			return err
+		if err := b.handleUnbindShare(logger, &serviceInstance, &fileShare, bindingID); err != nil {
+			if b.config.cloud.Control.ShareDeletionFailurePolicy != ShareDeletionFailurePolicyRetry {
+				return err
+			}
+			logger.Error("delete-file-share-queued-for-retry", err)
+			if err := b.queueShareDeletion(logger, fileShareID, fileShare, serviceInstance, err); err != nil {
+				return err
+			}
 		}
 
 		if fileShare.Count > 0 {
 			logger.Debug("updating-file-share-in-store", lager.Data{"fileShare": fileShare})
 			if err := b.store.UpdateFileShare(fileShareID, fileShare); err != nil {
-				err = fmt.Errorf("Faied to update file share in the store for %q: %v", fileShareID, err)
+				err = newStoreError(err, "Faied to update file share in the store for %q", fileShareID)
 				logger.Error("update-file-share-in-store", err)
 				return err
 			}
@@ -747,7 +1852,7 @@ func (b *Broker) Unbind(context context.Context, instanceID string, bindingID st
 		} else {
 			logger.Debug("deleting-file-share-from-store", lager.Data{"fileShare": fileShare})
 			if err := b.store.DeleteFileShare(fileShareID); err != nil {
-				err = fmt.Errorf("Faied to delete file share from the store for %q: %v", fileShareID, err)
+				err = newStoreError(err, "Faied to delete file share from the store for %q", fileShareID)
 				logger.Error("delete-file-share-from-store", err)
 				return err
 			}
@@ -755,87 +1860,285 @@ func (b *Broker) Unbind(context context.Context, instanceID string, bindingID st
 		}
 	}
 
+	return b.deleteBinding(logger, instanceID, bindingID, serviceInstance, bindingDetails, fileShareName)
+}
+
+func (b *Broker) deleteBinding(logger lager.Logger, instanceID, bindingID string, serviceInstance ServiceInstance, bindingDetails BindingDetails, fileShareName string) error {
 	if err := b.store.DeleteBindingDetails(bindingID); err != nil {
 		return err
 	}
 
+	b.emitAuditEvent(logger, AuditEvent{
+		Type:              AuditEventServiceBindingDelete,
+		ServiceInstanceID: instanceID,
+		BindingID:         bindingID,
+		OrganizationGUID:  serviceInstance.OrganizationGUID,
+		SpaceGUID:         serviceInstance.SpaceGUID,
+		AppGUID:           bindingDetails.AppGUID,
+		ResourceIDs:       auditResourceIDs(&serviceInstance, fileShareName),
+	})
+	b.sendLifecycleEvent(logger, LifecycleEventBindingDelete, &serviceInstance, LifecycleEvent{
+		ServiceInstanceID: instanceID,
+		BindingID:         bindingID,
+		AppGUID:           bindingDetails.AppGUID,
+		FileShareName:     fileShareName,
+	})
 	return nil
 }
 
-func (b *Broker) handleUnbindShare(logger lager.Logger, serviceInstance *ServiceInstance, share *FileShare) error {
+// unbindMissingBinding handles the unbind of a binding which is not in the store, e.g. after a partial failure. The
+// file share of the binding is unknown, so the counts of the file shares of the instance are recomputed from the
+// bindings which are left. Gone is returned so that the Cloud Controller stops retrying the unbind.
+func (b *Broker) unbindMissingBinding(logger lager.Logger, instanceID, bindingID string, serviceInstance ServiceInstance) error {
+	logger = logger.Session("unbind-missing-binding")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if !serviceInstance.IsPreexisting {
+		if err := b.repairInstanceShareCounts(logger, instanceID); err != nil {
+			// The share count repairer fixes the counts later
+			logger.Error("repair-instance-share-counts", err)
+		}
+	}
+
+	b.emitAuditEvent(logger, AuditEvent{
+		Type:              AuditEventServiceBindingDelete,
+		ServiceInstanceID: instanceID,
+		BindingID:         bindingID,
+		OrganizationGUID:  serviceInstance.OrganizationGUID,
+		SpaceGUID:         serviceInstance.SpaceGUID,
+		ResourceIDs:       auditResourceIDs(&serviceInstance, ""),
+		Inconsistency:     "The binding was not in the store, so the counts of the file shares of the instance were recomputed",
+	})
+	return brokerapi.ErrBindingDoesNotExist
+}
+
+// handleUnbindShare releases the file share of the binding which is being deleted. The share is deleted or scheduled
+// for deletion when no binding uses it, otherwise the binding is removed from the metadata of its apps.
+func (b *Broker) handleUnbindShare(logger lager.Logger, serviceInstance *ServiceInstance, share *FileShare, bindingID string) error {
 	logger = logger.Session("handle-unbind-share").WithData(lager.Data{"FileShareName": share.FileShareName})
 	logger.Info("start")
 	defer logger.Info("end")
 
 	share.Count--
 	if share.Count > 0 {
+		b.refreshBoundAppsMetadata(logger, serviceInstance, share.FileShareName, bindingID)
 		return nil
 	}
 
-	if share.IsCreated && b.config.cloud.Control.AllowDeleteFileShare {
-		storageAccount, err := NewStorageAccount(
-			logger,
-			Configuration{
-				SubscriptionID:     serviceInstance.SubscriptionID,
-				ResourceGroupName:  serviceInstance.ResourceGroupName,
-				StorageAccountName: serviceInstance.TargetName,
-				UseHTTPS:           serviceInstance.UseHTTPS,
-			})
-		if err != nil {
-			return err
+	ownerID := getFileShareOwnerID(serviceInstance, share.FileShareName)
+	if err := b.getLockForUpdate(ownerID); err != nil {
+		logger.Error("get-lock-for-update", err)
+		return err
+	}
+	defer b.store.ReleaseLockForUpdate(ownerID)
+
+	createdByBroker, err := b.unregisterFileShareUser(logger, ownerID, share)
+	if err != nil {
+		return err
+	}
+
+	if createdByBroker && b.controlConfig(logger).AllowDeleteFileShare && b.config.cloud.Control.DeletionRetentionPeriod == 0 {
+		return b.deleteFileShare(logger, deletionInitiatorUnbind, share.InstanceID, serviceInstance, share.FileShareName)
+	}
+
+	// The share is kept, so it may still be bound through another instance of the storage account
+	b.updateBoundAppsMetadata(logger, serviceInstance, share.FileShareName, bindingID)
+	if createdByBroker && b.controlConfig(logger).AllowDeleteFileShare {
+		return b.scheduleFileShareDeletion(logger, serviceInstance, share.FileShareName)
+	}
+	return nil
+}
+
+// deleteFileShare deletes the file share of the instance. The instance is not known when the deletion was scheduled,
+// because the share may be used by several instances of the storage account.
+func (b *Broker) deleteFileShare(logger lager.Logger, initiator, instanceID string, serviceInstance *ServiceInstance, fileShareName string) error {
+	backend, err := b.newBackend(logger, serviceInstance)
+	if err != nil {
+		return err
+	}
+
+	if err := backend.DeleteFileShare(fileShareName); err != nil {
+		return newAzureError(err, "Faied to delete the file share %q in the storage account %q", fileShareName, serviceInstance.TargetName)
+	}
+	b.sendLifecycleEvent(logger, LifecycleEventShareDelete, serviceInstance, LifecycleEvent{ServiceInstanceID: instanceID, FileShareName: fileShareName})
+	b.notifyDeletion(logger, initiator, instanceID, serviceInstance, fileShareName)
+	return nil
+}
+
+// updateOutcome is the result of an update with the warning of a plan change, which is recorded in the history
+type updateOutcome struct {
+	spec    brokerapi.UpdateServiceSpec
+	warning string
+}
+
+// update changes the user-visible metadata of a service instance. The metadata is applied as tags on the storage
+// account when the broker created it. The storage resources are not changed except the access policies of the file
+// shares of the instance, and the SKU and the secure transfer of the storage account which the broker created for it
+// with sku_name and use_https. An AzureFileShare instance may move to another AzureFileShare plan, and the bindings which
+// keep the mount options of the previous plan are recorded as a warning in the history of the operations.
+func (b *Broker) update(_ context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (_ updateOutcome, e error) {
+	warning := ""
+	logger := b.logger.Session("update").WithData(lager.Data{"instanceID": instanceID, "details": details})
+	logger.Info("start")
+	defer logger.Info("end")
+	defer func() {
+		e = toFailureResponse(e)
+	}()
+
+	if err := b.lockMutex(); err != nil {
+		logger.Error("lock-broker", err)
+		return updateOutcome{}, err
+	}
+	defer b.mutex.Unlock()
+
+	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
+	if err != nil {
+		logger.Error("retrieve-service-instance", err)
+		return updateOutcome{}, brokerapi.ErrInstanceDoesNotExist
+	}
+
+	switch serviceInstance.ProvisioningState {
+	case provisioningStatePending, provisioningStateCreating, provisioningStateDeleting:
+		return updateOutcome{}, newBrokerError(ErrCodeOperationInProgress, "The service instance cannot be updated while its storage account is %s", serviceInstance.ProvisioningState)
+	}
+	if serviceInstance.isMigrating() {
+		return updateOutcome{}, newBrokerError(ErrCodeOperationInProgress, "The service instance cannot be updated while its file shares are migrated to the storage account %q", serviceInstance.Migration.TargetName)
+	}
+
+	parameters, err := parseUpdateParameters(details.RawParameters)
+	if err != nil {
+		logger.Error("parse-update-parameters", err)
+		if _, ok := err.(*BrokerError); ok {
+			return updateOutcome{}, err
 		}
-		storageAccount.SDKClient, err = NewAzureStorageAccountSDKClient(
-			logger,
-			&b.config.cloud,
-			storageAccount,
-		)
-		if err != nil {
-			return err
+		return updateOutcome{}, brokerapi.ErrRawParamsInvalid
+	}
+	metadata, err := parameters.apply(serviceInstance.Metadata)
+	if err != nil {
+		logger.Error("apply-update-parameters", err)
+		return updateOutcome{}, err
+	}
+	planChanged := details.PlanID != "" && details.PlanID != serviceInstance.PlanID
+	if planChanged {
+		if err := b.checkPlanChange(&serviceInstance, details.PlanID, parameters.MigrateTo != nil); err != nil {
+			logger.Error("check-plan-change", err)
+			return updateOutcome{}, err
 		}
+	}
+	// The bindings and the SAS tokens which were issued before keep their access until they are created again
+	if parameters.Readonly != nil && *parameters.Readonly != serviceInstance.Readonly {
+		serviceInstance.Readonly = *parameters.Readonly
+		logger.Info("readonly-changed", lager.Data{"readonly": serviceInstance.Readonly})
+	}
+	if parameters.ShareAccessPolicies != nil {
+		if err := b.setShareAccessPolicies(logger, instanceID, &serviceInstance, parameters.ShareAccessPolicies); err != nil {
+			logger.Error("set-share-access-policies", err)
+			return updateOutcome{}, err
+		}
+	}
+	if parameters.updatesStorageAccount() {
+		if err := b.updateStorageAccount(logger, &serviceInstance, parameters); err != nil {
+			logger.Error("update-storage-account", err)
+			return updateOutcome{}, err
+		}
+	}
+	if parameters.MigrateTo != nil {
+		if err := b.startMigration(logger, instanceID, &serviceInstance, parameters.MigrateTo, asyncAllowed); err != nil {
+			logger.Error("start-migration", err)
+			return updateOutcome{}, err
+		}
+		// The instance moves to the plan with its new storage account, which is created in the network of the plan
+		if planChanged {
+			serviceInstance.Migration.PlanID = details.PlanID
+		}
+	} else if planChanged {
+		if warning, err = b.changePlan(logger, instanceID, &serviceInstance, details.PlanID); err != nil {
+			logger.Error("change-plan", err)
+			return updateOutcome{warning: warning}, err
+		}
+	}
 
-		if err := storageAccount.SDKClient.DeleteFileShare(share.FileShareName); err != nil {
-			return fmt.Errorf("Faied to delete the file share %q in the storage account %q: %v", share.FileShareName, serviceInstance.TargetName, err)
+	// Storage accounts which are not created by the broker may be shared by other instances, so they are not tagged
+	if !serviceInstance.IsPreexisting && serviceInstance.IsCreatedStorageAccount {
+		storageAccount, err := b.newStorageAccountWithSDKClient(logger, &serviceInstance)
+		if err != nil {
+			return updateOutcome{}, err
+		}
+		if err := storageAccount.SDKClient.SetStorageAccountTags(metadata.tagChanges(serviceInstance.Metadata)); err != nil {
+			return updateOutcome{}, newAzureError(err, "Failed to tag the storage account %q", serviceInstance.TargetName)
 		}
 	}
 
-	return nil
-}
+	serviceInstance.Metadata = metadata
+	if err := b.store.UpdateServiceInstance(instanceID, serviceInstance); err != nil {
+		logger.Error("update-service-instance", err, lager.Data{"serviceInstance": serviceInstance})
+		return updateOutcome{}, newStoreError(err, "Failed to update instance details %q", instanceID)
+	}
+	logger.Info("service-instance-metadata-updated", lager.Data{"metadata": metadata})
+	b.requests.forget(instanceID)
 
-func (b *Broker) Update(context context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (brokerapi.UpdateServiceSpec, error) {
-	panic("not implemented")
+	if serviceInstance.isMigrating() {
+		return updateOutcome{spec: brokerapi.UpdateServiceSpec{IsAsync: true, OperationData: operationMigration}, warning: warning}, nil
+	}
+	return updateOutcome{spec: brokerapi.UpdateServiceSpec{IsAsync: false}, warning: warning}, nil
 }
 
-func (b *Broker) LastOperation(_ context.Context, instanceID string, operationData string) (brokerapi.LastOperation, error) {
+func (b *Broker) lastOperation(_ context.Context, instanceID string, operationData string) (_ brokerapi.LastOperation, e error) {
 	logger := b.logger.Session("last-operation").WithData(lager.Data{"instanceID": instanceID})
 	logger.Info("start")
 	defer logger.Info("end")
+	defer func() {
+		e = toFailureResponse(e)
+	}()
 
-	b.mutex.Lock()
+	if err := b.lockMutex(); err != nil {
+		logger.Error("lock-broker", err)
+		return brokerapi.LastOperation{}, err
+	}
 	defer b.mutex.Unlock()
 
 	if operationData == "" {
-		return brokerapi.LastOperation{}, errors.New("unrecognized operationData")
+		return brokerapi.LastOperation{}, newBrokerError(ErrCodeOperationUnrecognized, "unrecognized operationData")
 	}
 
 	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
 	if err != nil {
-		err := brokerapi.ErrInstanceDoesNotExist
 		logger.Error("retrieve-service-instance", err)
-		return brokerapi.LastOperation{}, err
+		// Gone tells the platform that a deprovision has finished
+		return brokerapi.LastOperation{}, goneOrStoreError(err, brokerapi.ErrInstanceDoesNotExist, "Failed to retrieve the service instance %q", instanceID)
 	}
 
 	if serviceInstance.IsPreexisting {
-		return brokerapi.LastOperation{}, errors.New("LastOperation cannot be called for preexisting shares")
+		return brokerapi.LastOperation{}, newBrokerError(ErrCodeOperationNotSupportedForShare, "LastOperation cannot be called for preexisting shares")
+	}
+	if operationData == operationMigration {
+		return migrationLastOperation(serviceInstance), nil
 	}
 
-	storageAccount, err := NewStorageAccount(
-		logger,
-		Configuration{
-			SubscriptionID:     serviceInstance.SubscriptionID,
-			ResourceGroupName:  serviceInstance.ResourceGroupName,
-			StorageAccountName: serviceInstance.TargetName,
-			UseHTTPS:           serviceInstance.UseHTTPS,
-		})
+	switch serviceInstance.ProvisioningState {
+	case provisioningStateSucceeded:
+		lastOperation := brokerapi.LastOperation{State: brokerapi.Succeeded}
+		if stats, err := b.ShareStatsOfInstance(instanceID); err != nil {
+			logger.Error("share-stats-of-instance", err)
+		} else {
+			lastOperation.Description = shareStatsSummary(stats)
+		}
+		return lastOperation, nil
+	case provisioningStateFailed:
+		return brokerapi.LastOperation{State: brokerapi.Failed, Description: serviceInstance.OperationError}, nil
+	}
+
+	// Instances stored by older versions of the broker only have the operation URL in the operation data
+	operationURL := serviceInstance.OperationURL
+	if operationURL == "" && operationData != operationDeprovision {
+		operationURL = operationData
+	}
+	if operationURL == "" {
+		return brokerapi.LastOperation{}, newBrokerError(ErrCodeOperationUnrecognized, "unrecognized operationData")
+	}
+
+	storageAccount, err := b.newStorageAccountOfInstance(logger, &serviceInstance)
 	if err != nil {
 		return brokerapi.LastOperation{}, err
 	}
@@ -847,7 +2150,7 @@ func (b *Broker) LastOperation(_ context.Context, instanceID string, operationDa
 	if err != nil {
 		return brokerapi.LastOperation{}, err
 	}
-	ret, err := restClient.CheckCompletion(operationData)
+	ret, err := restClient.CheckCompletion(operationURL)
 	state := brokerapi.InProgress
 	description := ""
 	if err != nil {
@@ -856,6 +2159,41 @@ func (b *Broker) LastOperation(_ context.Context, instanceID string, operationDa
 	} else if ret {
 		state = brokerapi.Succeeded
 	}
+	logger.Info("check-completion", lager.Data{"state": state, "description": description})
+
+	if state == brokerapi.InProgress {
+		return brokerapi.LastOperation{State: state}, nil
+	}
+
+	switch serviceInstance.ProvisioningState {
+	case provisioningStateCreating:
+		serviceInstance.ProvisioningState = provisioningStateSucceeded
+		if state == brokerapi.Failed {
+			serviceInstance.ProvisioningState = provisioningStateFailed
+			description = newStorageAccountCreationError(err, serviceInstance.TargetName, serviceInstance.Location).Error()
+			serviceInstance.OperationError = description
+		} else {
+			b.createStorageAccountAlerts(logger, &serviceInstance)
+			b.createStorageAccountAccessLogs(logger, &serviceInstance)
+		}
+	case provisioningStateDeleting:
+		if state == brokerapi.Succeeded {
+			if err := b.removeServiceInstance(logger, instanceID, serviceInstance, true); err != nil {
+				return brokerapi.LastOperation{}, err
+			}
+			return brokerapi.LastOperation{State: state}, nil
+		}
+		// The storage account still exists so the instance is usable and the deprovision can be retried
+		serviceInstance.ProvisioningState = provisioningStateSucceeded
+		serviceInstance.OperationURL = ""
+	default:
+		return brokerapi.LastOperation{State: state, Description: description}, nil
+	}
+
+	if err := b.store.UpdateServiceInstance(instanceID, serviceInstance); err != nil {
+		logger.Error("update-service-instance", err, lager.Data{"serviceInstance": serviceInstance})
+		return brokerapi.LastOperation{}, err
+	}
 
 	return brokerapi.LastOperation{State: state, Description: description}, nil
 }
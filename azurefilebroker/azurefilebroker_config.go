@@ -2,16 +2,31 @@ package azurefilebroker
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 const preexisting = "Preexisting"
 
+var supportedEnvironments = []string{preexisting, AzureCloud, AzureChinaCloud, AzureUSGovernment, AzureGermanCloud, AzureStack}
+
+// The versions of the Azure APIs are dates with an optional suffix, e.g. 2017-04-17 or 2016-12-01-preview
+var apiVersionPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(-[a-z]+)?$`)
+
 type MountConfig struct {
 	Allowed []string
 
 	Forced  map[string]string
 	Options map[string]string
+
+	// PlanOptions are the defaults of the bindings of the instances of a plan by the name of the plan. They replace the
+	// defaults of the broker, and a default which is not allowed is forced.
+	PlanOptions map[string]map[string]string
+
+	invalidPlanOptions []string
 }
 
 type AzureConfig struct {
@@ -22,9 +37,31 @@ type AzureConfig struct {
 	DefaultSubscriptionID    string
 	DefaultResourceGroupName string
 	DefaultLocation          string
+	// BrokerInstanceID and CreatorTagValue are stamped on the created resources so that broker deployments sharing a
+	// subscription can tell their resources apart
+	BrokerInstanceID string
+	CreatorTagValue  string
+	// UserAgent is the product in the User-Agent header of the requests to Azure. The version of the broker is appended
+	// to it so that Azure support can trace the requests of a deployment.
+	UserAgent string
+	// APIVersionOverrides replace the API versions of the environment by their names in APIVersions, e.g. for AzureStack
+	// builds which lag behind the public clouds
+	APIVersionOverrides map[string]string
+	// AuxiliaryTenantIDs are the tenants which have granted access to the service principal of the broker, so that it
+	// manages the storage accounts of the instances which are provisioned with one of them in tenant_id
+	AuxiliaryTenantIDs []string
+	// FileEndpointSuffix replaces the suffix of the file endpoints which Azure Resource Manager reports for the storage
+	// accounts, e.g. on AzureStack where the reported endpoints are not reachable. MountFileEndpointSuffix replaces it
+	// in the share URLs which the Diego cells mount when they reach the file endpoints by another domain than the
+	// broker. They are used as reported when empty.
+	FileEndpointSuffix      string
+	MountFileEndpointSuffix string
+	// auxiliaryTokenTenantID is the tenant whose token is sent in the x-ms-authorization-auxiliary header. It is only
+	// set in the config of a storage account in an auxiliary tenant.
+	auxiliaryTokenTenantID string
 }
 
-func NewAzureConfig(environment, tenanID, clientID, clientSecret, defaultSubscriptionID, defaultResourceGroupName, defaultLocation string) *AzureConfig {
+func NewAzureConfig(environment, tenanID, clientID, clientSecret, defaultSubscriptionID, defaultResourceGroupName, defaultLocation, brokerInstanceID, creatorTagValue, userAgent string, apiVersionOverrides map[string]string) *AzureConfig {
 	myConf := new(AzureConfig)
 
 	myConf.Environment = environment
@@ -34,6 +71,19 @@ func NewAzureConfig(environment, tenanID, clientID, clientSecret, defaultSubscri
 	myConf.DefaultSubscriptionID = defaultSubscriptionID
 	myConf.DefaultResourceGroupName = defaultResourceGroupName
 	myConf.DefaultLocation = defaultLocation
+	myConf.BrokerInstanceID = brokerInstanceID
+	myConf.CreatorTagValue = creatorTagValue
+	if myConf.CreatorTagValue == "" {
+		myConf.CreatorTagValue = defaultCreatorTagValue
+	}
+	myConf.UserAgent = userAgent
+	if myConf.UserAgent == "" {
+		myConf.UserAgent = defaultUserAgent
+	}
+	myConf.APIVersionOverrides = make(map[string]string, len(apiVersionOverrides))
+	for name, version := range apiVersionOverrides {
+		myConf.APIVersionOverrides[name] = version
+	}
 
 	return myConf
 }
@@ -64,27 +114,127 @@ func (config *AzureConfig) Validate() error {
 	if len(missingKeys) > 0 {
 		return errors.New("Missing required parameters: " + strings.Join(missingKeys, ", "))
 	}
+
+	if _, ok := Environments[config.Environment]; !ok {
+		return fmt.Errorf("Unknown environment %q: expected one of %s", config.Environment, strings.Join(supportedEnvironments, ", "))
+	}
+
+	if len(config.CreatorTagValue) > maxTagValueLength {
+		return fmt.Errorf("The creator tag value must not be longer than %d characters", maxTagValueLength)
+	}
+	if len(config.BrokerInstanceID) > maxTagValueLength {
+		return fmt.Errorf("The broker instance ID must not be longer than %d characters", maxTagValueLength)
+	}
+	if strings.ContainsAny(config.UserAgent, "\r\n") {
+		return errors.New("The user agent must not contain line breaks")
+	}
+	if err := config.validateAuxiliaryTenantIDs(); err != nil {
+		return err
+	}
+	if err := config.validateFileEndpointSuffixes(); err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range config.APIVersionOverrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		versions := APIVersions{}
+		if !versions.set(name, config.APIVersionOverrides[name]) {
+			return fmt.Errorf("Unknown API %q in apiVersions: expected one of %s", name, strings.Join(apiVersionNames, ", "))
+		}
+		if !apiVersionPattern.MatchString(config.APIVersionOverrides[name]) {
+			return fmt.Errorf("Invalid version %q of the API %q in apiVersions: expected a date such as 2019-06-01", config.APIVersionOverrides[name], name)
+		}
+	}
 	return nil
 }
 
+// ParseAPIVersionOverrides parses a comma separated list of API versions by their names in APIVersions, e.g.
+// StorageForREST=2016-01-01,FileShares=2017-10-01
+func ParseAPIVersionOverrides(apiVersions string) (map[string]string, error) {
+	overrides := map[string]string{}
+	for _, entry := range strings.Split(apiVersions, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		pair := strings.SplitN(entry, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return nil, fmt.Errorf("Invalid API version %q in apiVersions: expected <api>=<version>", entry)
+		}
+		overrides[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+	return overrides, nil
+}
+
+const (
+	// ShareDeletionFailurePolicyFail fails the unbind when the file share cannot be deleted
+	ShareDeletionFailurePolicyFail = "fail"
+	// ShareDeletionFailurePolicyRetry succeeds the unbind and retries the deletion in the background
+	ShareDeletionFailurePolicyRetry = "retry"
+)
+
 type ControlConfig struct {
-	AllowCreateStorageAccount bool
-	AllowCreateFileShare      bool
-	AllowDeleteStorageAccount bool
-	AllowDeleteFileShare      bool
+	AllowCreateStorageAccount  bool
+	AllowCreateFileShare       bool
+	AllowDeleteStorageAccount  bool
+	AllowDeleteFileShare       bool
+	ShareDeletionFailurePolicy string
+	// AllowDeleteForeignFileShares deletes a storage account created by the broker even when it has file shares which
+	// the broker did not create, e.g. once the operator checked that the data of the shares is not needed
+	AllowDeleteForeignFileShares bool
+	// EnforceStorageAccountOwnership allows a storage account to be used only by the org and space which first used it
+	EnforceStorageAccountOwnership bool
+	// RequireShareOwnershipProof requires a SAS token of an existing file share when an instance binds to it first
+	RequireShareOwnershipProof bool
+	// AllowServiceKeys answers the binds without an app, e.g. the service keys, with the SMB credentials of the share
+	// instead of a volume mount
+	AllowServiceKeys bool
+	// SynchronousBudget is how long an operation may take when the platform does not allow asynchronous operations
+	SynchronousBudget time.Duration
+	// DeletionRetentionPeriod is how long deprovisioned storage accounts and unbound file shares are kept before they are
+	// deleted. 0 deletes them immediately.
+	DeletionRetentionPeriod time.Duration
 }
 
-func NewControlConfig(allowCreateStorageAccount, allowCreateFileShare, allowDeleteStorageAccount, allowDeleteFileShare bool) *ControlConfig {
+func NewControlConfig(allowCreateStorageAccount, allowCreateFileShare, allowDeleteStorageAccount, allowDeleteFileShare, allowDeleteForeignFileShares bool, shareDeletionFailurePolicy string, enforceStorageAccountOwnership, requireShareOwnershipProof, allowServiceKeys bool, synchronousBudget, deletionRetentionPeriod time.Duration) *ControlConfig {
 	myConf := new(ControlConfig)
 
 	myConf.AllowCreateStorageAccount = allowCreateStorageAccount
 	myConf.AllowCreateFileShare = allowCreateFileShare
 	myConf.AllowDeleteStorageAccount = allowDeleteStorageAccount
 	myConf.AllowDeleteFileShare = allowDeleteFileShare
+	myConf.AllowDeleteForeignFileShares = allowDeleteForeignFileShares
+	myConf.ShareDeletionFailurePolicy = shareDeletionFailurePolicy
+	if myConf.ShareDeletionFailurePolicy == "" {
+		myConf.ShareDeletionFailurePolicy = ShareDeletionFailurePolicyFail
+	}
+	myConf.EnforceStorageAccountOwnership = enforceStorageAccountOwnership
+	myConf.RequireShareOwnershipProof = requireShareOwnershipProof
+	myConf.AllowServiceKeys = allowServiceKeys
+	myConf.SynchronousBudget = synchronousBudget
+	myConf.DeletionRetentionPeriod = deletionRetentionPeriod
 
 	return myConf
 }
 
+func (config *ControlConfig) Validate() error {
+	if config.SynchronousBudget < 0 {
+		return fmt.Errorf("Invalid synchronousBudget %s: it must not be negative", config.SynchronousBudget)
+	}
+	if config.DeletionRetentionPeriod < 0 {
+		return fmt.Errorf("Invalid deletionRetentionPeriod %s: it must not be negative", config.DeletionRetentionPeriod)
+	}
+
+	switch config.ShareDeletionFailurePolicy {
+	case ShareDeletionFailurePolicyFail, ShareDeletionFailurePolicyRetry:
+		return nil
+	}
+	return fmt.Errorf("Invalid shareDeletionFailurePolicy %q: expected %q or %q", config.ShareDeletionFailurePolicy, ShareDeletionFailurePolicyFail, ShareDeletionFailurePolicyRetry)
+}
+
 type AzureStackConfig struct {
 	AzureStackDomain         string
 	AzureStackAuthentication string
@@ -128,11 +278,119 @@ type CloudConfig struct {
 	Azure      AzureConfig
 	Control    ControlConfig
 	AzureStack AzureStackConfig
+	CredHub    CredHubConfig
+
+	// secrets keeps the credentials of the service principals given in the provision parameters when it is set
+	secrets SecretStore
+	// backend creates the clients of the storage accounts instead of Azure when it is set
+	backend AzureBackend
+}
+
+// PreexistingConfig is the configuration of the preexisting SMB shares
+type PreexistingConfig struct {
+	// AllowedShares are the UNC paths registered by the operator. Any share is allowed when it is empty.
+	AllowedShares []string
+}
+
+// NewPreexistingConfig parses a comma separated list of UNC paths
+func NewPreexistingConfig(allowedShares string) *PreexistingConfig {
+	myConf := new(PreexistingConfig)
+
+	myConf.AllowedShares = make([]string, 0)
+	for _, share := range strings.Split(allowedShares, ",") {
+		if share = strings.TrimSpace(share); share != "" {
+			myConf.AllowedShares = append(myConf.AllowedShares, share)
+		}
+	}
+
+	return myConf
+}
+
+func (config *PreexistingConfig) Validate() error {
+	for _, share := range config.AllowedShares {
+		if !isUNCPath(share) {
+			return fmt.Errorf("Invalid share %q in allowedShares: expected a UNC path such as //server/share", share)
+		}
+	}
+	return nil
+}
+
+// DefaultOperationTimeout leaves a margin below the default timeout of the Cloud Controller for broker requests, which
+// is 60 seconds, so that the broker answers before the Cloud Controller gives up
+const DefaultOperationTimeout = 50 * time.Second
+
+// DefaultLockTimeout is how long an operation waits for a lock which another operation holds
+const DefaultLockTimeout = 30 * time.Second
+
+// TimeoutConfig is how long each broker operation may take before an error is returned. 0 disables the timeout.
+type TimeoutConfig struct {
+	Provision     time.Duration
+	Bind          time.Duration
+	Unbind        time.Duration
+	Deprovision   time.Duration
+	Update        time.Duration
+	LastOperation time.Duration
+	// Lock is the wait for a lock. The default is used when it is 0.
+	Lock time.Duration
+	// Deduplication is how long the result of a provision, a deprovision, a bind or an unbind is returned to its
+	// retries. 0 runs every retry again.
+	Deduplication time.Duration
+}
+
+func NewTimeoutConfig(provision, bind, unbind, deprovision, update, lastOperation, lock, deduplication time.Duration) *TimeoutConfig {
+	myConf := new(TimeoutConfig)
+
+	myConf.Provision = provision
+	myConf.Bind = bind
+	myConf.Unbind = unbind
+	myConf.Deprovision = deprovision
+	myConf.Update = update
+	myConf.LastOperation = lastOperation
+	myConf.Lock = lock
+	myConf.Deduplication = deduplication
+
+	return myConf
+}
+
+func (config *TimeoutConfig) Validate() error {
+	timeouts := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{"provisionTimeout", config.Provision},
+		{"bindTimeout", config.Bind},
+		{"unbindTimeout", config.Unbind},
+		{"deprovisionTimeout", config.Deprovision},
+		{"updateTimeout", config.Update},
+		{"lastOperationTimeout", config.LastOperation},
+		{"lockTimeout", config.Lock},
+		{"requestDeduplicationWindow", config.Deduplication},
+	}
+	for _, t := range timeouts {
+		if t.timeout < 0 {
+			return fmt.Errorf("Invalid %s %s: it must not be negative", t.name, t.timeout)
+		}
+	}
+	if config.Lock%time.Second != 0 {
+		return fmt.Errorf("Invalid lockTimeout %s: the locks are taken with a timeout in whole seconds", config.Lock)
+	}
+	return nil
 }
 
 type Config struct {
-	mount MountConfig
-	cloud CloudConfig
+	mount       MountConfig
+	cloud       CloudConfig
+	preexisting PreexistingConfig
+	timeouts    TimeoutConfig
+	placement   PlacementConfig
+	naming      NamingConfig
+	segments    IsolationSegmentConfig
+	credentials CredentialConfig
+	pool        StoragePoolConfig
+	backup      BackupConfig
+	alerts      AlertConfig
+	accessLogs  AccessLogConfig
+	catalog     CatalogConfig
 }
 
 func inArray(list []string, key string) bool {
@@ -145,11 +403,21 @@ func inArray(list []string, key string) bool {
 	return false
 }
 
-func NewAzurefilebrokerConfig(mountConfig *MountConfig, cloudConfig *CloudConfig) *Config {
+func NewAzurefilebrokerConfig(mountConfig *MountConfig, cloudConfig *CloudConfig, preexistingConfig *PreexistingConfig, timeoutConfig *TimeoutConfig, placementConfig *PlacementConfig, namingConfig *NamingConfig, segmentConfig *IsolationSegmentConfig, credentialConfig *CredentialConfig, poolConfig *StoragePoolConfig, backupConfig *BackupConfig, alertConfig *AlertConfig, accessLogConfig *AccessLogConfig) *Config {
 	myConf := new(Config)
 
 	myConf.mount = *mountConfig
 	myConf.cloud = *cloudConfig
+	myConf.preexisting = *preexistingConfig
+	myConf.timeouts = *timeoutConfig
+	myConf.placement = *placementConfig
+	myConf.naming = *namingConfig
+	myConf.segments = *segmentConfig
+	myConf.credentials = *credentialConfig
+	myConf.pool = *poolConfig
+	myConf.backup = *backupConfig
+	myConf.alerts = *alertConfig
+	myConf.accessLogs = *accessLogConfig
 
 	return myConf
 }
@@ -160,16 +428,18 @@ func NewAzurefilebrokerMountConfig() *MountConfig {
 	myConf.Allowed = make([]string, 0)
 	myConf.Options = make(map[string]string, 0)
 	myConf.Forced = make(map[string]string, 0)
+	myConf.PlanOptions = make(map[string]map[string]string, 0)
 
 	return myConf
 }
 
-func NewAzurefilebrokerCloudConfig(azure *AzureConfig, control *ControlConfig, azureStack *AzureStackConfig) *CloudConfig {
+func NewAzurefilebrokerCloudConfig(azure *AzureConfig, control *ControlConfig, azureStack *AzureStackConfig, credHub *CredHubConfig) *CloudConfig {
 	myConf := new(CloudConfig)
 
 	myConf.Azure = *azure
 	myConf.Control = *control
 	myConf.AzureStack = *azureStack
+	myConf.CredHub = *credHub
 
 	return myConf
 }
@@ -179,12 +449,20 @@ func (config *CloudConfig) Validate() error {
 		return err
 	}
 
+	if err := config.Control.Validate(); err != nil {
+		return err
+	}
+
 	if config.Azure.Environment == AzureStack {
 		if err := config.AzureStack.Validate(); err != nil {
 			return err
 		}
 	}
 
+	if err := config.CredHub.Validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -192,6 +470,7 @@ func (config *MountConfig) Copy() *MountConfig {
 	myConf := new(MountConfig)
 
 	myConf.Allowed = config.Allowed
+	myConf.PlanOptions = config.PlanOptions
 
 	myConf.Forced = make(map[string]string, 0)
 	myConf.Options = make(map[string]string, 0)
@@ -1,9 +1,19 @@
 package azurefilebroker_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
 	. "code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
 )
 
 var _ = Describe("AzureConfig", func() {
@@ -13,7 +23,7 @@ var _ = Describe("AzureConfig", func() {
 
 	Context("Given all required params", func() {
 		BeforeEach(func() {
-			azureconfig = NewAzureConfig("environment", "tenanID", "clientID", "clientSecret", "", "", "")
+			azureconfig = NewAzureConfig("AzureCloud", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", nil)
 		})
 
 		It("should not raise an error", func() {
@@ -22,9 +32,146 @@ var _ = Describe("AzureConfig", func() {
 		})
 	})
 
+	Context("Default creator tag value", func() {
+		BeforeEach(func() {
+			azureconfig = NewAzureConfig("AzureCloud", "tenanID", "clientID", "clientSecret", "", "", "", "broker-1", "", "", nil)
+		})
+
+		It("should use the name of the broker", func() {
+			Expect(azureconfig.CreatorTagValue).To(Equal("azurefilebroker"))
+			Expect(azureconfig.BrokerInstanceID).To(Equal("broker-1"))
+		})
+	})
+
+	Context("Too long creator tag value", func() {
+		BeforeEach(func() {
+			azureconfig = NewAzureConfig("AzureCloud", "tenanID", "clientID", "clientSecret", "", "", "", "", strings.Repeat("a", 257), "", nil)
+		})
+
+		It("should raise an error", func() {
+			err := azureconfig.Validate()
+			Expect(err).To(MatchError("The creator tag value must not be longer than 256 characters"))
+		})
+	})
+
+	Context("User agent", func() {
+		It("should append the version of the broker and the broker instance ID", func() {
+			azureconfig = NewAzureConfig("AzureCloud", "tenanID", "clientID", "clientSecret", "", "", "", "broker-1", "", "", nil)
+			Expect(azureconfig.GetUserAgent()).To(Equal(fmt.Sprintf("azurefilebroker/%s (broker-1)", BrokerVersion)))
+		})
+
+		It("should use the configured product", func() {
+			azureconfig = NewAzureConfig("AzureCloud", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "my-broker", nil)
+			Expect(azureconfig.GetUserAgent()).To(Equal(fmt.Sprintf("my-broker/%s", BrokerVersion)))
+		})
+
+		It("should raise an error when it has a line break", func() {
+			azureconfig = NewAzureConfig("AzureCloud", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "my-broker\r\nX-Header: 1", nil)
+			Expect(azureconfig.Validate()).To(MatchError("The user agent must not contain line breaks"))
+		})
+	})
+
+	Context("Auxiliary tenants", func() {
+		BeforeEach(func() {
+			azureconfig = NewAzureConfig("AzureCloud", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", nil)
+		})
+
+		It("should parse a comma separated list", func() {
+			Expect(ParseAuxiliaryTenantIDs(" tenant-a, ,tenant-b ")).To(Equal([]string{"tenant-a", "tenant-b"}))
+			Expect(ParseAuxiliaryTenantIDs("")).To(BeEmpty())
+		})
+
+		It("should accept other tenants", func() {
+			azureconfig.AuxiliaryTenantIDs = []string{"tenant-a", "contoso.onmicrosoft.com"}
+			Expect(azureconfig.Validate()).To(Succeed())
+		})
+
+		It("should raise an error when the tenant of the broker is given", func() {
+			azureconfig.AuxiliaryTenantIDs = []string{"TENANID"}
+			Expect(azureconfig.Validate()).To(MatchError(`The auxiliary tenant "TENANID" must not be the tenant of the broker`))
+		})
+
+		It("should raise an error when a tenant is not a tenant ID", func() {
+			azureconfig.AuxiliaryTenantIDs = []string{"tenant-a/oauth2"}
+			Expect(azureconfig.Validate()).To(HaveOccurred())
+		})
+	})
+
+	Context("File endpoint suffixes", func() {
+		var storageAccount *StorageAccount
+
+		BeforeEach(func() {
+			azureconfig = NewAzureConfig("AzureStack", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", nil)
+			storageAccount = &StorageAccount{StorageAccountName: "account", BaseURL: "core.windows.net"}
+		})
+
+		It("should use the reported suffix by default", func() {
+			Expect(azureconfig.GetFileEndpointSuffix("core.windows.net")).To(Equal("core.windows.net"))
+			Expect(azureconfig.GetShareURL(storageAccount, "share")).To(Equal("//account.file.core.windows.net/share"))
+		})
+
+		It("should replace the suffixes of the broker and of the Diego cells", func() {
+			azureconfig.FileEndpointSuffix = "local.azurestack.external"
+			azureconfig.MountFileEndpointSuffix = "cells.azurestack.internal"
+			Expect(azureconfig.Validate()).To(Succeed())
+			Expect(azureconfig.GetFileEndpointSuffix("core.windows.net")).To(Equal("local.azurestack.external"))
+			Expect(azureconfig.GetShareURL(storageAccount, "share")).To(Equal("//account.file.cells.azurestack.internal/share"))
+		})
+
+		It("should raise an error when a suffix is not a domain", func() {
+			azureconfig.FileEndpointSuffix = "https://local.azurestack.external"
+			Expect(azureconfig.Validate()).To(MatchError(ContainSubstring("Invalid fileEndpointSuffix")))
+			azureconfig.FileEndpointSuffix = ""
+			azureconfig.MountFileEndpointSuffix = ".azurestack.external"
+			Expect(azureconfig.Validate()).To(MatchError(ContainSubstring("Invalid mountFileEndpointSuffix")))
+		})
+	})
+
+	Context("API version overrides", func() {
+		It("should override the versions of the environment", func() {
+			azureconfig = NewAzureConfig("AzureStack", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", map[string]string{"FileShares": "2017-10-01", "ShareAccessPolicies": "2019-06-01"})
+			Expect(azureconfig.Validate()).To(Succeed())
+			versions := azureconfig.GetAPIVersions()
+			Expect(versions.FileShares).To(Equal("2017-10-01"))
+			Expect(versions.ShareAccessPolicies).To(Equal("2019-06-01"))
+			Expect(versions.StorageForREST).To(Equal(Environments[AzureStack].APIVersions.StorageForREST))
+			Expect(Environments[AzureStack].APIVersions.FileShares).To(Equal("2019-06-01"))
+		})
+
+		It("should raise an error for an unknown API", func() {
+			azureconfig = NewAzureConfig("AzureCloud", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", map[string]string{"Blobs": "2017-10-01"})
+			Expect(azureconfig.Validate()).To(MatchError(ContainSubstring(`Unknown API "Blobs" in apiVersions`)))
+		})
+
+		It("should raise an error for an invalid version", func() {
+			azureconfig = NewAzureConfig("AzureCloud", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", map[string]string{"FileShares": "latest"})
+			Expect(azureconfig.Validate()).To(MatchError(`Invalid version "latest" of the API "FileShares" in apiVersions: expected a date such as 2019-06-01`))
+		})
+
+		It("should parse the versions of the flag", func() {
+			overrides, err := ParseAPIVersionOverrides(" StorageForREST=2016-01-01, FileShares=2017-10-01-preview,")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(overrides).To(Equal(map[string]string{"StorageForREST": "2016-01-01", "FileShares": "2017-10-01-preview"}))
+
+			_, err = ParseAPIVersionOverrides("FileShares")
+			Expect(err).To(MatchError(`Invalid API version "FileShares" in apiVersions: expected <api>=<version>`))
+		})
+	})
+
+	Context("Unknown environment", func() {
+		BeforeEach(func() {
+			azureconfig = NewAzureConfig("Azure", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", nil)
+		})
+
+		It("should raise an error with the supported environments", func() {
+			err := azureconfig.Validate()
+			Expect(err).To(MatchError(`Unknown environment "Azure": expected one of Preexisting, AzureCloud, AzureChinaCloud, AzureUSGovernment, AzureGermanCloud, AzureStack`))
+		})
+	})
+
 	Context("Missing environment", func() {
 		BeforeEach(func() {
-			azureconfig = NewAzureConfig("", "tenanID", "clientID", "clientSecret", "", "", "")
+			azureconfig = NewAzureConfig("", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", nil)
 		})
 
 		It("should raise an error", func() {
@@ -35,7 +182,7 @@ var _ = Describe("AzureConfig", func() {
 
 	Context("Missing tenanID", func() {
 		BeforeEach(func() {
-			azureconfig = NewAzureConfig("environment", "", "clientID", "clientSecret", "", "", "")
+			azureconfig = NewAzureConfig("AzureCloud", "", "clientID", "clientSecret", "", "", "", "", "", "", nil)
 		})
 
 		It("should raise an error", func() {
@@ -46,7 +193,7 @@ var _ = Describe("AzureConfig", func() {
 
 	Context("Missing clientID", func() {
 		BeforeEach(func() {
-			azureconfig = NewAzureConfig("environment", "tenanID", "", "clientSecret", "", "", "")
+			azureconfig = NewAzureConfig("AzureCloud", "tenanID", "", "clientSecret", "", "", "", "", "", "", nil)
 		})
 
 		It("should raise an error", func() {
@@ -57,7 +204,7 @@ var _ = Describe("AzureConfig", func() {
 
 	Context("Missing clientSecret", func() {
 		BeforeEach(func() {
-			azureconfig = NewAzureConfig("environment", "tenanID", "clientID", "", "", "", "")
+			azureconfig = NewAzureConfig("AzureCloud", "tenanID", "clientID", "", "", "", "", "", "", "", nil)
 		})
 
 		It("should raise an error", func() {
@@ -68,7 +215,7 @@ var _ = Describe("AzureConfig", func() {
 
 	Context("Missing all required params", func() {
 		BeforeEach(func() {
-			azureconfig = NewAzureConfig("", "", "", "", "", "", "")
+			azureconfig = NewAzureConfig("", "", "", "", "", "", "", "", "", "", nil)
 		})
 
 		It("should raise an error", func() {
@@ -156,17 +303,22 @@ var _ = Describe("AzurefilebrokerCloudConfig", func() {
 		azure       *AzureConfig
 		control     *ControlConfig
 		azureStack  *AzureStackConfig
+		policy      string
 	)
 
+	BeforeEach(func() {
+		policy = ""
+	})
+
 	JustBeforeEach(func() {
-		control = NewControlConfig(false, false, false, true)
-		cloudConfig = NewAzurefilebrokerCloudConfig(azure, control, azureStack)
+		control = NewControlConfig(false, false, false, true, false, policy, false, false, false, 0, 0)
+		cloudConfig = NewAzurefilebrokerCloudConfig(azure, control, azureStack, NewCredHubConfig("", "", "", ""))
 	})
 
 	Context("Given all required params", func() {
 		Context("When environment is not AzureStack", func() {
 			BeforeEach(func() {
-				azure = NewAzureConfig("Azure", "tenanID", "clientID", "clientSecret", "", "", "")
+				azure = NewAzureConfig("AzureCloud", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", nil)
 				azureStack = NewAzureStackConfig("", "", "", "")
 			})
 
@@ -178,7 +330,7 @@ var _ = Describe("AzurefilebrokerCloudConfig", func() {
 
 		Context("When environment is AzureStack", func() {
 			BeforeEach(func() {
-				azure = NewAzureConfig("AzureStack", "tenanID", "clientID", "clientSecret", "", "", "")
+				azure = NewAzureConfig("AzureStack", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", nil)
 				azureStack = NewAzureStackConfig("azureStackDomain", "azureStackAuthentication", "azureStackResource", "azureStackEndpointPrefix")
 			})
 
@@ -191,7 +343,7 @@ var _ = Describe("AzurefilebrokerCloudConfig", func() {
 
 	Context("Missing params for AzureStack", func() {
 		BeforeEach(func() {
-			azure = NewAzureConfig("AzureStack", "tenanID", "clientID", "clientSecret", "", "", "")
+			azure = NewAzureConfig("AzureStack", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", nil)
 			azureStack = NewAzureStackConfig("", "", "", "")
 		})
 
@@ -200,6 +352,50 @@ var _ = Describe("AzurefilebrokerCloudConfig", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+
+	Context("Share deletion failure policy", func() {
+		BeforeEach(func() {
+			azure = NewAzureConfig("AzureCloud", "tenanID", "clientID", "clientSecret", "", "", "", "", "", "", nil)
+			azureStack = NewAzureStackConfig("", "", "", "")
+		})
+
+		It("should default to fail", func() {
+			Expect(control.ShareDeletionFailurePolicy).To(Equal(ShareDeletionFailurePolicyFail))
+		})
+
+		Context("When the policy is retry", func() {
+			BeforeEach(func() {
+				policy = "retry"
+			})
+
+			It("should not raise an error", func() {
+				err := cloudConfig.Validate()
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("When the synchronous budget is negative", func() {
+			JustBeforeEach(func() {
+				cloudConfig.Control.SynchronousBudget = -time.Second
+			})
+
+			It("should raise an error", func() {
+				err := cloudConfig.Validate()
+				Expect(err).To(MatchError("Invalid synchronousBudget -1s: it must not be negative"))
+			})
+		})
+
+		Context("When the policy is unknown", func() {
+			BeforeEach(func() {
+				policy = "ignore"
+			})
+
+			It("should raise an error", func() {
+				err := cloudConfig.Validate()
+				Expect(err).To(MatchError(`Invalid shareDeletionFailurePolicy "ignore": expected "fail" or "retry"`))
+			})
+		})
+	})
 })
 
 var _ = Describe("MountConfig", func() {
@@ -427,4 +623,684 @@ var _ = Describe("MountConfig", func() {
 			})
 		})
 	})
+
+	Context("ReadPlanConf", func() {
+		var segments *IsolationSegmentConfig
+
+		BeforeEach(func() {
+			segments = NewIsolationSegmentConfig("segment1=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells")
+		})
+
+		It("should parse the defaults of the plans", func() {
+			config.ReadPlanConf("AzureFileShare-segment1=vers:3.0;file_mode:0644, Existing=sec:ntlmssp")
+			Expect(config.Validate(segments, NewCatalogConfig(""))).To(Succeed())
+			Expect(config.PlanOptions).To(Equal(map[string]map[string]string{
+				"AzureFileShare-segment1": {"vers": "3.0", "file_mode": "0644"},
+				"Existing":                {"sec": "ntlmssp"},
+			}))
+		})
+
+		It("should raise an error for an unknown plan or an invalid entry", func() {
+			config.ReadPlanConf("AzureFileShare-segment2=vers:3.0")
+			Expect(config.Validate(segments, NewCatalogConfig(""))).To(MatchError(`Unknown plan "AzureFileShare-segment2" in planMountOptions: expected one of Existing, AzureFileShare, AzureFileShare-segment1`))
+			config.ReadPlanConf("AzureFileShare=vers")
+			Expect(config.Validate(segments, NewCatalogConfig(""))).To(MatchError(ContainSubstring("Invalid entries in planMountOptions: AzureFileShare=vers")))
+		})
+	})
+})
+
+var _ = Describe("PreexistingConfig", func() {
+	It("should parse the comma separated shares", func() {
+		config := NewPreexistingConfig(" //server/share, \\\\server\\other ,")
+		Expect(config.AllowedShares).To(Equal([]string{"//server/share", `\\server\other`}))
+		Expect(config.Validate()).To(Succeed())
+	})
+
+	It("should raise an error when a share is not a UNC path", func() {
+		config := NewPreexistingConfig("//server/share,server")
+		Expect(config.Validate()).To(MatchError(ContainSubstring(`"server"`)))
+	})
+})
+
+var _ = Describe("PlacementConfig", func() {
+	var config *PlacementConfig
+
+	BeforeEach(func() {
+		config = NewPlacementConfig("org:finance=westeurope, annotation:example.com/region:us=eastus ,org:org-guid=northeurope")
+	})
+
+	It("should parse the comma separated rules", func() {
+		Expect(config.Validate(NewIsolationSegmentConfig(""), NewCatalogConfig(""))).To(Succeed())
+		Expect(config.Rules).To(Equal([]PlacementRule{
+			{Org: "finance", Location: "westeurope"},
+			{AnnotationKey: "example.com/region", AnnotationValue: "us", Location: "eastus"},
+			{Org: "org-guid", Location: "northeurope"},
+		}))
+	})
+
+	It("should raise an error when a rule is malformed", func() {
+		config = NewPlacementConfig("org:finance=westeurope,space:dev=eastus,org:=eastus")
+		Expect(config.Validate(NewIsolationSegmentConfig(""), NewCatalogConfig(""))).To(MatchError(ContainSubstring("space:dev=eastus, org:=eastus")))
+	})
+
+	It("should parse the subscriptions and the resource groups of the plans", func() {
+		config.ReadPlanTargets("AzureFileShare=prod:shares, AzureFileShare-segment1=:segment-shares")
+		Expect(config.Validate(NewIsolationSegmentConfig("segment1=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells"), NewCatalogConfig(""))).To(Succeed())
+		Expect(config.PlanTargets).To(Equal(map[string]PlanTarget{
+			"AzureFileShare":          {SubscriptionID: "prod", ResourceGroupName: "shares"},
+			"AzureFileShare-segment1": {ResourceGroupName: "segment-shares"},
+		}))
+	})
+
+	It("should raise an error when a plan target is malformed or of an unknown plan", func() {
+		config.ReadPlanTargets("AzureFileShare=prod,AzureFileShare=:,=prod:shares")
+		Expect(config.Validate(NewIsolationSegmentConfig(""), NewCatalogConfig(""))).To(MatchError("Invalid entries in planResourceGroups: AzureFileShare=prod, AzureFileShare=:, =prod:shares. Expected <plan name>=<subscription id>:<resource group>"))
+
+		config.ReadPlanTargets("Existing=prod:shares")
+		Expect(config.Validate(NewIsolationSegmentConfig(""), NewCatalogConfig(""))).To(MatchError(`Unknown plan "Existing" in planResourceGroups: expected one of AzureFileShare`))
+	})
+
+	It("should pick the location of the first rule which matches the org", func() {
+		Expect(config.Location(brokerapi.ProvisionDetails{
+			OrganizationGUID: "org-guid",
+			RawContext:       json.RawMessage(`{"organization_name":"finance"}`),
+		})).To(Equal("westeurope"))
+		Expect(config.Location(brokerapi.ProvisionDetails{OrganizationGUID: "org-guid"})).To(Equal("northeurope"))
+	})
+
+	It("should match the annotations of the org in the context", func() {
+		Expect(config.Location(brokerapi.ProvisionDetails{
+			OrganizationGUID: "another-org-guid",
+			RawContext:       json.RawMessage(`{"organization_annotations":{"example.com/region":"us"}}`),
+		})).To(Equal("eastus"))
+		Expect(config.Location(brokerapi.ProvisionDetails{
+			OrganizationGUID: "another-org-guid",
+			RawContext:       json.RawMessage(`{"organization_annotations":{"example.com/region":"eu"}}`),
+		})).To(BeEmpty())
+	})
+})
+
+var _ = Describe("CredHubConfig", func() {
+	It("should be disabled without a URL", func() {
+		config := NewCredHubConfig("", "", "", "")
+		Expect(config.IsEnabled()).To(BeFalse())
+		Expect(config.Validate()).To(Succeed())
+	})
+
+	It("should raise an error when the UAA client is missing", func() {
+		config := NewCredHubConfig("https://credhub.example.com/", "", "", "")
+		Expect(config.IsEnabled()).To(BeTrue())
+		Expect(config.URL).To(Equal("https://credhub.example.com"))
+		Expect(config.Validate()).To(MatchError("Missing required parameters when 'credhubURL' is set: credhubUAAURL, credhubClientID, CREDHUB_CLIENT_SECRET"))
+	})
+})
+
+var _ = Describe("TimeoutConfig", func() {
+	It("should accept disabled timeouts", func() {
+		Expect(NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0).Validate()).To(Succeed())
+	})
+
+	It("should raise an error when a timeout is negative", func() {
+		config := NewTimeoutConfig(DefaultOperationTimeout, DefaultOperationTimeout, -time.Second, 0, 0, 0, 0, 0)
+		Expect(config.Validate()).To(MatchError("Invalid unbindTimeout -1s: it must not be negative"))
+	})
+
+	It("should raise an error when the lock timeout is not in whole seconds", func() {
+		config := NewTimeoutConfig(0, 0, 0, 0, 0, 0, 1500*time.Millisecond, 0)
+		Expect(config.Validate()).To(MatchError("Invalid lockTimeout 1.5s: the locks are taken with a timeout in whole seconds"))
+	})
+
+	It("should raise an error when the deduplication window is negative", func() {
+		config := NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, -time.Minute)
+		Expect(config.Validate()).To(MatchError("Invalid requestDeduplicationWindow -1m0s: it must not be negative"))
+	})
+})
+
+var _ = Describe("NamingConfig", func() {
+	It("should parse a prefix and a regular expression", func() {
+		config := NewNamingConfig("prefix:{org}", "regex:{space}-[a-z0-9-]+")
+		Expect(config.Validate()).To(Succeed())
+		Expect(config.StorageAccount).To(Equal(NamingPolicy{Prefix: "{org}"}))
+		Expect(config.Share).To(Equal(NamingPolicy{Regex: "{space}-[a-z0-9-]+"}))
+	})
+
+	It("should not restrict the names without policies", func() {
+		config := NewNamingConfig("", " ")
+		Expect(config.Validate()).To(Succeed())
+		Expect(config.StorageAccount).To(Equal(NamingPolicy{}))
+		Expect(config.Share).To(Equal(NamingPolicy{}))
+	})
+
+	It("should raise an error for an unknown policy", func() {
+		config := NewNamingConfig("suffix:abc", "prefix:")
+		Expect(config.Validate()).To(MatchError(`Invalid naming policies: storageAccountNamingPolicy "suffix:abc", shareNamingPolicy "prefix:". Expected prefix:<prefix> or regex:<regular expression>`))
+	})
+
+	It("should raise an error for an invalid regular expression", func() {
+		config := NewNamingConfig("", "regex:{space}-[")
+		Expect(config.Validate()).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("IsolationSegmentConfig", func() {
+	const (
+		subnet1 = "/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells-1"
+		subnet2 = "/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells-2"
+	)
+
+	It("should parse the subnets of the segments", func() {
+		config := NewIsolationSegmentConfig(fmt.Sprintf("segment1=%s|%s, segment2=%s", subnet1, subnet2, subnet2))
+		Expect(config.Validate()).To(Succeed())
+		Expect(config.Networks).To(Equal([]IsolationSegmentNetwork{
+			{Name: "segment1", SubnetIDs: []string{subnet1, subnet2}},
+			{Name: "segment2", SubnetIDs: []string{subnet2}},
+		}))
+	})
+
+	It("should derive a stable plan per segment", func() {
+		config := NewIsolationSegmentConfig(fmt.Sprintf("segment1=%s,segment2=%s", subnet1, subnet2))
+		Expect(config.Networks[0].PlanName()).To(Equal("AzureFileShare-segment1"))
+		Expect(config.Networks[0].PlanID()).To(MatchRegexp("^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$"))
+		Expect(config.Networks[0].PlanID()).To(Equal(NewIsolationSegmentConfig("segment1=" + subnet2).Networks[0].PlanID()))
+		Expect(config.Networks[0].PlanID()).NotTo(Equal(config.Networks[1].PlanID()))
+	})
+
+	It("should raise an error for a subnet which is not a resource ID", func() {
+		config := NewIsolationSegmentConfig("segment1=10.0.0.0/24")
+		Expect(config.Validate()).To(MatchError("Invalid segments in isolationSegmentSubnets: segment1=10.0.0.0/24. Expected <segment>=<subnet ID>|<subnet ID> with the resource IDs of the subnets"))
+	})
+
+	It("should raise an error for a segment given twice", func() {
+		config := NewIsolationSegmentConfig(fmt.Sprintf("segment1=%s,segment1=%s", subnet1, subnet2))
+		Expect(config.Validate()).To(MatchError(`The segment "segment1" is given more than once in isolationSegmentSubnets`))
+	})
+})
+
+var _ = Describe("AuthFailureConfig", func() {
+	It("should parse the addresses and the ranges of the trusted proxies", func() {
+		config := NewAuthFailureConfig(10, time.Second, time.Minute, "10.0.0.0/8, 192.0.2.1,2001:db8::1")
+		Expect(config.Validate()).To(Succeed())
+		Expect(config.TrustedProxies).To(HaveLen(3))
+		Expect(config.TrustedProxies[0].String()).To(Equal("10.0.0.0/8"))
+		Expect(config.TrustedProxies[1].String()).To(Equal("192.0.2.1/32"))
+		Expect(config.TrustedProxies[2].String()).To(Equal("2001:db8::1/128"))
+	})
+
+	It("should raise an error for a trusted proxy which is not an address or a range", func() {
+		config := NewAuthFailureConfig(10, time.Second, time.Minute, "router.example.com,10.0.0.0/33")
+		Expect(config.Validate()).To(MatchError("Invalid addresses in authTrustedProxies: router.example.com, 10.0.0.0/33. Expected IP addresses or CIDR ranges"))
+	})
+})
+
+var _ = Describe("CatalogConfig", func() {
+	var segments *IsolationSegmentConfig
+
+	BeforeEach(func() {
+		segments = NewIsolationSegmentConfig("segment1=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells")
+	})
+
+	It("should be disabled without a catalog", func() {
+		config := NewCatalogConfig("")
+		Expect(config.Validate(segments)).To(Succeed())
+		Expect(config.IsEnabled()).To(BeFalse())
+	})
+
+	It("should parse a YAML catalog", func() {
+		config := NewCatalogConfig(`
+id: service-id
+name: smbfs
+description: SMB shares
+tags: [smb]
+metadata:
+  displayName: SMB
+  imageUrl: https://example.com/icon.png
+plans:
+- id: plan-1
+  name: standard
+  description: Azure file shares
+  kind: azurefileshare
+  metadata:
+    displayName: Standard
+`)
+		Expect(config.Validate(segments)).To(Succeed())
+		Expect(config.IsEnabled()).To(BeTrue())
+		Expect(config.Service.Name).To(Equal("smbfs"))
+		Expect(config.Service.Tags).To(Equal([]string{"smb"}))
+		Expect(config.Service.Metadata.DisplayName).To(Equal("SMB"))
+		Expect(config.Service.Metadata.ImageUrl).To(Equal("https://example.com/icon.png"))
+		Expect(config.Service.Plans).To(HaveLen(1))
+		Expect(config.Service.Plans[0].Kind).To(Equal("azurefileshare"))
+		Expect(config.Service.Plans[0].Metadata.DisplayName).To(Equal("Standard"))
+	})
+
+	It("should parse a JSON catalog", func() {
+		config := NewCatalogConfig(`{"id":"service-id","name":"smbfs","description":"SMB shares","plans":[{"id":"plan-1","name":"existing","description":"Preexisting shares","kind":"preexisting"}]}`)
+		Expect(config.Validate(segments)).To(Succeed())
+		Expect(config.Service.Plans[0].Name).To(Equal("existing"))
+	})
+
+	It("should raise an error for an unknown key", func() {
+		config := NewCatalogConfig(`{"id":"service-id","name":"smbfs","description":"SMB shares","plan":[]}`)
+		Expect(config.Validate(segments)).To(MatchError(ContainSubstring(`Invalid catalog in catalogPath: json: unknown field "plan"`)))
+	})
+
+	It("should raise an error for a catalog without plans", func() {
+		config := NewCatalogConfig(`{"id":"service-id","name":"smbfs","description":"SMB shares"}`)
+		Expect(config.Validate(segments)).To(MatchError(`The service "smbfs" of catalogPath has no plans`))
+	})
+
+	It("should raise an error for a plan which takes the name of the plan of a segment", func() {
+		config := NewCatalogConfig(`{"id":"service-id","name":"smbfs","description":"SMB shares","plans":[{"id":"plan-1","name":"AzureFileShare-segment1","description":"Azure file shares"}]}`)
+		Expect(config.Validate(segments)).To(MatchError(`The plan "AzureFileShare-segment1" of catalogPath is given more than once or takes the ID or the name of the plan of an isolation segment`))
+	})
+
+	It("should raise an error for an unknown kind", func() {
+		config := NewCatalogConfig(`{"id":"service-id","name":"smbfs","description":"SMB shares","plans":[{"id":"plan-1","name":"standard","description":"Azure file shares","kind":"nfs"}]}`)
+		Expect(config.Validate(segments)).To(MatchError(`Invalid kind "nfs" of the plan "standard" in catalogPath. Expected preexisting or azurefileshare`))
+	})
+
+	It("should let the options of the plans name the plans of the catalog", func() {
+		catalog := NewCatalogConfig(`{"id":"service-id","name":"smbfs","description":"SMB shares","plans":[{"id":"plan-1","name":"standard","description":"Azure file shares"},{"id":"plan-2","name":"existing","description":"Preexisting shares","kind":"preexisting"}]}`)
+		Expect(NewCredentialConfig("standard=sas").Validate(segments, catalog)).To(Succeed())
+		Expect(NewCredentialConfig("existing=sas").Validate(segments, catalog)).To(MatchError(`Unknown plan "existing" in planCredentialTypes: expected one of AzureFileShare, standard, AzureFileShare-segment1`))
+		mount := NewAzurefilebrokerMountConfig()
+		mount.ReadPlanConf("existing=vers:3.0")
+		Expect(mount.Validate(segments, catalog)).To(Succeed())
+	})
+})
+
+var _ = Describe("CredentialConfig", func() {
+	var segments *IsolationSegmentConfig
+
+	BeforeEach(func() {
+		segments = NewIsolationSegmentConfig("segment1=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells")
+	})
+
+	It("should parse the credential types of the plans", func() {
+		config := NewCredentialConfig("AzureFileShare=sas, AzureFileShare-segment1=none")
+		Expect(config.Validate(segments, NewCatalogConfig(""))).To(Succeed())
+		Expect(config.PlanCredentialTypes).To(Equal(map[string]string{"AzureFileShare": "sas", "AzureFileShare-segment1": "none"}))
+	})
+
+	It("should raise an error for a plan which is not an AzureFileShare plan", func() {
+		config := NewCredentialConfig("Existing=none")
+		Expect(config.Validate(segments, NewCatalogConfig(""))).To(MatchError(`Unknown plan "Existing" in planCredentialTypes: expected one of AzureFileShare, AzureFileShare-segment1`))
+	})
+
+	It("should raise an error for an unknown type", func() {
+		config := NewCredentialConfig("AzureFileShare=password")
+		Expect(config.Validate(segments, NewCatalogConfig(""))).To(MatchError(`Invalid credential type "password" of the plan "AzureFileShare" in planCredentialTypes: expected key, sas or none`))
+	})
+
+	It("should raise an error for an entry without a type", func() {
+		config := NewCredentialConfig("AzureFileShare")
+		Expect(config.Validate(segments, NewCatalogConfig(""))).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("StoragePoolConfig", func() {
+	var (
+		segments *IsolationSegmentConfig
+		azure    *AzureConfig
+	)
+
+	BeforeEach(func() {
+		segments = NewIsolationSegmentConfig("segment1=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells")
+		azure = NewAzureConfig("AzureCloud", "tenant", "client", "secret", "subscription", "group", "westeurope", "", "", "", nil)
+	})
+
+	It("should parse the sizes of the pools of the plans and locations", func() {
+		config := NewStoragePoolConfig("AzureFileShare:westeurope=3, AzureFileShare-segment1:eastus=2")
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(Succeed())
+		Expect(config.Entries).To(Equal([]StoragePoolEntry{
+			{PlanName: "AzureFileShare", Location: "westeurope", Size: 3},
+			{PlanName: "AzureFileShare-segment1", Location: "eastus", Size: 2},
+		}))
+	})
+
+	It("should accept an empty pool without the defaults", func() {
+		config := NewStoragePoolConfig("")
+		Expect(config.Validate(segments, NewCatalogConfig(""), NewAzureConfig("Preexisting", "", "", "", "", "", "", "", "", "", nil))).To(Succeed())
+		Expect(config.Entries).To(BeEmpty())
+	})
+
+	It("should raise an error for an entry without a positive size", func() {
+		config := NewStoragePoolConfig("AzureFileShare:westeurope=0,AzureFileShare=1")
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError("Invalid entries in storageAccountPool: AzureFileShare:westeurope=0, AzureFileShare=1. Expected <plan name>:<location>=<size> with a positive size"))
+	})
+
+	It("should raise an error for a plan which is not an AzureFileShare plan", func() {
+		config := NewStoragePoolConfig("Existing:westeurope=1")
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(`Unknown plan "Existing" in storageAccountPool: expected one of AzureFileShare, AzureFileShare-segment1`))
+	})
+
+	It("should raise an error for a plan and a location given twice", func() {
+		config := NewStoragePoolConfig("AzureFileShare:westeurope=1,AzureFileShare:westeurope=2")
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(`The plan "AzureFileShare" and the location "westeurope" are given more than once in storageAccountPool`))
+	})
+
+	It("should raise an error without the default resource group", func() {
+		azure.DefaultResourceGroupName = ""
+		config := NewStoragePoolConfig("AzureFileShare:westeurope=1")
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError("storageAccountPool requires AzureFileShare, defaultSubscriptionID and defaultResourceGroupName"))
+	})
+})
+
+var _ = Describe("BackupConfig", func() {
+	var azure *AzureConfig
+	const vaultID = "/subscriptions/s/resourceGroups/g/providers/Microsoft.RecoveryServices/vaults/vault"
+
+	BeforeEach(func() {
+		azure = NewAzureConfig("AzureCloud", "tenant", "client", "secret", "subscription", "group", "westeurope", "", "", "", nil)
+	})
+
+	It("should accept a vault and its policy", func() {
+		config := NewBackupConfig(" "+vaultID+" ", " daily ")
+		Expect(config.Validate(azure)).To(Succeed())
+		Expect(config.VaultID).To(Equal(vaultID))
+		Expect(config.PolicyName).To(Equal("daily"))
+	})
+
+	It("should accept no vault without AzureFileShare", func() {
+		config := NewBackupConfig("", "")
+		Expect(config.Validate(NewAzureConfig("Preexisting", "", "", "", "", "", "", "", "", "", nil))).To(Succeed())
+	})
+
+	It("should raise an error for a vault without a policy", func() {
+		config := NewBackupConfig(vaultID, "")
+		Expect(config.Validate(azure)).To(MatchError("backupVaultID and backupPolicy must be given together"))
+	})
+
+	It("should raise an error for an ID which is not the ID of a vault", func() {
+		config := NewBackupConfig("/subscriptions/s/resourceGroups/g/providers/Microsoft.Storage/storageAccounts/a", "daily")
+		Expect(config.Validate(azure)).To(MatchError(ContainSubstring("Invalid backupVaultID")))
+	})
+
+	It("should raise an error in an environment without Azure Backup", func() {
+		azure.Environment = "AzureStack"
+		config := NewBackupConfig(vaultID, "daily")
+		Expect(config.Validate(azure)).To(MatchError(`backupVaultID is not supported in the environment "AzureStack"`))
+	})
+})
+
+var _ = Describe("AlertConfig", func() {
+	var (
+		segments *IsolationSegmentConfig
+		azure    *AzureConfig
+	)
+	const actionGroupID = "/subscriptions/s/resourceGroups/g/providers/microsoft.insights/actionGroups/ops"
+
+	BeforeEach(func() {
+		segments = NewIsolationSegmentConfig("segment1=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells")
+		azure = NewAzureConfig("AzureCloud", "tenant", "client", "secret", "subscription", "group", "westeurope", "", "", "", nil)
+	})
+
+	It("should parse the alerts of the plans", func() {
+		config := NewAlertConfig("AzureFileShare:capacity=4096, AzureFileShare-segment1:availability=99.5", actionGroupID)
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(Succeed())
+		Expect(config.Alerts).To(Equal([]StorageAccountAlert{
+			{PlanName: "AzureFileShare", Name: "capacity", Threshold: 4096},
+			{PlanName: "AzureFileShare-segment1", Name: "availability", Threshold: 99.5},
+		}))
+	})
+
+	It("should accept no alert without an action group", func() {
+		config := NewAlertConfig("", "")
+		Expect(config.Validate(segments, NewCatalogConfig(""), NewAzureConfig("Preexisting", "", "", "", "", "", "", "", "", "", nil))).To(Succeed())
+		Expect(config.Alerts).To(BeEmpty())
+	})
+
+	It("should raise an error for an entry without a positive threshold", func() {
+		config := NewAlertConfig("AzureFileShare:capacity=0,AzureFileShare=1", actionGroupID)
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError("Invalid entries in storageAccountAlerts: AzureFileShare:capacity=0, AzureFileShare=1. Expected <plan name>:<alert>=<threshold> with a positive threshold"))
+	})
+
+	It("should raise an error without an action group", func() {
+		config := NewAlertConfig("AzureFileShare:throttling=100", "")
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(ContainSubstring("Invalid alertActionGroupID")))
+	})
+
+	It("should raise an error for an unknown alert", func() {
+		config := NewAlertConfig("AzureFileShare:latency=100", actionGroupID)
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(`Unknown alert "latency" of the plan "AzureFileShare" in storageAccountAlerts: expected capacity, throttling or availability`))
+	})
+
+	It("should raise an error for an availability above 100 percent", func() {
+		config := NewAlertConfig("AzureFileShare:availability=150", actionGroupID)
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(ContainSubstring("expected a percentage")))
+	})
+
+	It("should raise an error for a plan which is not an AzureFileShare plan", func() {
+		config := NewAlertConfig("Existing:capacity=1", actionGroupID)
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(`Unknown plan "Existing" in storageAccountAlerts: expected one of AzureFileShare, AzureFileShare-segment1`))
+	})
+
+	It("should raise an error for an alert of a plan given twice", func() {
+		config := NewAlertConfig("AzureFileShare:capacity=1,AzureFileShare:capacity=2", actionGroupID)
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(`The alert "capacity" of the plan "AzureFileShare" is given more than once in storageAccountAlerts`))
+	})
+
+	It("should raise an error in an environment without metric alerts", func() {
+		azure.Environment = "AzureStack"
+		config := NewAlertConfig("AzureFileShare:capacity=1", actionGroupID)
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(`storageAccountAlerts is not supported in the environment "AzureStack"`))
+	})
+})
+
+var _ = Describe("BasePathConfig", func() {
+	var (
+		paths   []string
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		paths = nil
+	})
+
+	serve := func(config *BasePathConfig, path string) *httptest.ResponseRecorder {
+		handler = BasePathHandler(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path+"?"+r.URL.RawQuery)
+		}))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	It("should remove the trailing slashes", func() {
+		config := NewBasePathConfig(" /azurefilebroker/ ")
+		Expect(config.Validate()).To(Succeed())
+		Expect(config.Path).To(Equal("/azurefilebroker"))
+	})
+
+	It("should raise an error for a path which is not absolute or has invalid segments", func() {
+		Expect(NewBasePathConfig("azurefilebroker").Validate()).To(MatchError(ContainSubstring(`Invalid basePath "azurefilebroker"`)))
+		Expect(NewBasePathConfig("/azure//files").Validate()).To(MatchError(ContainSubstring("Invalid basePath")))
+		Expect(NewBasePathConfig("/azure?files").Validate()).To(MatchError(ContainSubstring("Invalid basePath")))
+	})
+
+	It("should serve the paths as they are without a base path", func() {
+		Expect(serve(NewBasePathConfig(""), "/v2/catalog").Code).To(Equal(http.StatusOK))
+		Expect(paths).To(Equal([]string{"/v2/catalog?"}))
+	})
+
+	It("should serve the paths under the base path without it", func() {
+		config := NewBasePathConfig("/gateway/azurefilebroker")
+		Expect(serve(config, "/gateway/azurefilebroker/v2/service_instances/id?accepts_incomplete=true").Code).To(Equal(http.StatusOK))
+		Expect(serve(config, "/gateway/azurefilebroker").Code).To(Equal(http.StatusOK))
+		Expect(paths).To(Equal([]string{"/v2/service_instances/id?accepts_incomplete=true", "/?"}))
+	})
+
+	It("should not find the paths outside the base path", func() {
+		config := NewBasePathConfig("/azurefilebroker")
+		for _, path := range []string{"/v2/catalog", "/azurefilebroker2/v2/catalog", "/"} {
+			recorder := serve(config, path)
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			Expect(recorder.Body.String()).To(ContainSubstring(`the broker is served under \"/azurefilebroker\"`))
+		}
+		Expect(paths).To(BeEmpty())
+	})
+})
+
+var _ = Describe("MaintenanceWindowConfig", func() {
+	It("should parse the windows of the jobs", func() {
+		config := NewMaintenanceWindowConfig("storage-account-migrator=01:00-05:00, *=22:30-02:00;12:00-24:00", "Europe/Berlin")
+		Expect(config.Validate()).To(Succeed())
+		Expect(config.IsEnabled()).To(BeTrue())
+		Expect(config.Location.String()).To(Equal("Europe/Berlin"))
+		Expect(config.Windows).To(Equal(map[string][]MaintenanceWindow{
+			"storage-account-migrator": {{Start: 60, End: 300}},
+			"*":                        {{Start: 22*60 + 30, End: 120}, {Start: 12 * 60, End: 0}},
+		}))
+		Expect(config.Windows["*"][0].String()).To(Equal("22:30-02:00"))
+	})
+
+	It("should use the local time zone without windows", func() {
+		config := NewMaintenanceWindowConfig("", "")
+		Expect(config.Validate()).To(Succeed())
+		Expect(config.IsEnabled()).To(BeFalse())
+		Expect(config.Location).To(Equal(time.Local))
+	})
+
+	It("should raise an error for an invalid time range", func() {
+		config := NewMaintenanceWindowConfig("share-stats-collector=1:00-05:00,instance-expirer=03:00-03:00,backup-protector=25:00-02:00,*", "")
+		Expect(config.Validate()).To(MatchError("Invalid entries in maintenanceWindows: share-stats-collector=1:00-05:00, instance-expirer=03:00-03:00, backup-protector=25:00-02:00, *. Expected <job>=<HH:MM>-<HH:MM>;<HH:MM>-<HH:MM> with a start different from the end"))
+	})
+
+	It("should raise an error for an unknown time zone", func() {
+		config := NewMaintenanceWindowConfig("*=01:00-05:00", "Mars/Olympus")
+		Expect(config.Validate()).To(MatchError(ContainSubstring("Invalid maintenanceWindowTimeZone")))
+	})
+
+	It("should raise an error for an unknown job", func() {
+		config := NewMaintenanceWindowConfig("key-rotator=01:00-05:00", "UTC")
+		Expect(config.Validate()).To(MatchError(ContainSubstring(`Unknown background job "key-rotator" in maintenanceWindows`)))
+	})
+})
+
+var _ = Describe("AccessLogConfig", func() {
+	var (
+		segments *IsolationSegmentConfig
+		azure    *AzureConfig
+	)
+	const (
+		workspaceID      = "/subscriptions/s/resourceGroups/g/providers/Microsoft.OperationalInsights/workspaces/audit"
+		storageAccountID = "/subscriptions/s/resourceGroups/g/providers/Microsoft.Storage/storageAccounts/auditlogs"
+	)
+
+	BeforeEach(func() {
+		segments = NewIsolationSegmentConfig("segment1=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells")
+		azure = NewAzureConfig("AzureCloud", "tenant", "client", "secret", "subscription", "group", "westeurope", "", "", "", nil)
+	})
+
+	It("should parse the plans and the destinations", func() {
+		config := NewAccessLogConfig("AzureFileShare, AzureFileShare-segment1", workspaceID, storageAccountID)
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(Succeed())
+		Expect(config.IsEnabled()).To(BeTrue())
+		Expect(config.Plans).To(Equal([]string{"AzureFileShare", "AzureFileShare-segment1"}))
+		Expect(config.AccessLogDestination).To(Equal(AccessLogDestination{WorkspaceID: workspaceID, StorageAccountID: storageAccountID}))
+	})
+
+	It("should accept a destination without plans", func() {
+		config := NewAccessLogConfig("", "", storageAccountID)
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(Succeed())
+		Expect(config.Plans).To(BeEmpty())
+	})
+
+	It("should accept no destination without plans", func() {
+		config := NewAccessLogConfig("", "", "")
+		Expect(config.Validate(segments, NewCatalogConfig(""), NewAzureConfig("Preexisting", "", "", "", "", "", "", "", "", "", nil))).To(Succeed())
+		Expect(config.IsEnabled()).To(BeFalse())
+	})
+
+	It("should raise an error for plans without a destination", func() {
+		config := NewAccessLogConfig("AzureFileShare", "", "")
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError("accessLogPlans requires accessLogWorkspaceID or accessLogStorageAccountID"))
+	})
+
+	It("should raise an error for a destination which is not a resource ID of its kind", func() {
+		config := NewAccessLogConfig("", storageAccountID, "")
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(ContainSubstring("Invalid accessLogWorkspaceID")))
+		config = NewAccessLogConfig("", "", workspaceID)
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(ContainSubstring("Invalid accessLogStorageAccountID")))
+	})
+
+	It("should raise an error for a plan which is not an AzureFileShare plan", func() {
+		config := NewAccessLogConfig("Existing", workspaceID, "")
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(`Unknown plan "Existing" in accessLogPlans: expected one of AzureFileShare, AzureFileShare-segment1`))
+	})
+
+	It("should raise an error in an environment without diagnostic settings", func() {
+		azure.Environment = "AzureStack"
+		config := NewAccessLogConfig("AzureFileShare", workspaceID, "")
+		Expect(config.Validate(segments, NewCatalogConfig(""), azure)).To(MatchError(`accessLogWorkspaceID and accessLogStorageAccountID are not supported in the environment "AzureStack"`))
+	})
+})
+
+var _ = Describe("RedactionConfig", func() {
+	var testSink *lagertest.TestSink
+
+	log := func(config *RedactionConfig, data lager.Data) lager.Data {
+		testSink = lagertest.NewTestSink()
+		sink, err := config.NewRedactingSink(testSink)
+		Expect(err).NotTo(HaveOccurred())
+		logger := lager.NewLogger("redaction-test")
+		logger.RegisterSink(sink)
+		logger.Info("logged", data)
+		return testSink.Logs()[0].Data
+	}
+
+	It("should redact the passwords, the SAS signatures and the keys of connection strings by default", func() {
+		config := NewRedactionConfig("", "")
+		Expect(config.Validate()).To(Succeed())
+		data := log(config, lager.Data{
+			"password":         "secret",
+			"shareURL":         "https://account.file.core.windows.net/share?sv=2019-02-02&sig=c2lnbmF0dXJl&sp=rl",
+			"connectionString": "DefaultEndpointsProtocol=https;AccountName=account;AccountKey=a2V5;EndpointSuffix=core.windows.net",
+			"share":            "share",
+		})
+		Expect(data["password"]).To(Equal("*REDACTED*"))
+		Expect(data["shareURL"]).To(Equal("*REDACTED*"))
+		Expect(data["connectionString"]).To(Equal("*REDACTED*"))
+		Expect(data["share"]).To(Equal("share"))
+	})
+
+	It("should redact the configured keys and values", func() {
+		config := NewRedactionConfig("[Ss]ecret, [Tt]oken", `ghp_[A-Za-z0-9]+,x\x2cy`)
+		Expect(config.Validate()).To(Succeed())
+		Expect(config.KeyPatterns).To(Equal([]string{"[Ss]ecret", "[Tt]oken"}))
+		data := log(config, lager.Data{
+			"clientSecret": "secret",
+			"message":      "pushed with ghp_abc123",
+			"pair":         "x,y",
+			"share":        "share",
+		})
+		Expect(data["clientSecret"]).To(Equal("*REDACTED*"))
+		Expect(data["message"]).To(Equal("*REDACTED*"))
+		Expect(data["pair"]).To(Equal("*REDACTED*"))
+		Expect(data["share"]).To(Equal("share"))
+	})
+
+	It("should raise an error for an invalid regular expression", func() {
+		config := NewRedactionConfig("", "(unclosed")
+		Expect(config.Validate()).To(MatchError(ContainSubstring("Invalid regular expression in logRedactionValues")))
+	})
+})
+
+var _ = Describe("SMTPConfig", func() {
+	It("should be disabled without recipients", func() {
+		config := NewSMTPConfig("", 587, "", "", "", "")
+		Expect(config.IsEnabled()).To(BeFalse())
+		Expect(config.Validate()).To(Succeed())
+	})
+
+	It("should parse the recipients", func() {
+		config := NewSMTPConfig("smtp.example.com", 587, "user", "secret", "broker@example.com", "ops@example.com, ,storage@example.com")
+		Expect(config.To).To(Equal([]string{"ops@example.com", "storage@example.com"}))
+		Expect(config.Validate()).To(Succeed())
+	})
+
+	It("should raise an error when the server or the sender is missing", func() {
+		config := NewSMTPConfig("", 587, "", "", "", "ops@example.com")
+		Expect(config.Validate()).To(MatchError("Missing required parameters when 'deletionNotificationEmails' is set: smtpHost, smtpFrom"))
+	})
 })
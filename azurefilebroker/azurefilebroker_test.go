@@ -1,9 +1,25 @@
 package azurefilebroker_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
 	. "code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	"code.cloudfoundry.org/azurefilebroker/azurefilebrokerfakes"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/tedsuo/ifrit"
 )
 
 var _ = Describe("Configuration", func() {
@@ -86,6 +102,52 @@ var _ = Describe("Configuration", func() {
 			Expect(err).To(MatchError("Missing required parameters: subscription_id, resource_group_name, storage_account_name"))
 		})
 	})
+
+	Context("Service principal", func() {
+		var logger *lagertest.TestLogger
+
+		BeforeEach(func() {
+			logger = lagertest.NewTestLogger("configuration-test")
+		})
+
+		It("should use the service principal of the broker when none is given", func() {
+			storageAccount, err := NewStorageAccount(logger, config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(storageAccount.ServicePrincipal).To(BeNil())
+		})
+
+		It("should use the given service principal", func() {
+			config.TenantID, config.ClientID, config.ClientSecret = "tenant", "client", "secret"
+			storageAccount, err := NewStorageAccount(logger, config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*storageAccount.ServicePrincipal).To(Equal(ServicePrincipal{TenantID: "tenant", ClientID: "client", ClientSecret: "secret"}))
+		})
+
+		It("should use the service principal of the broker in the tenant when only the tenant is given", func() {
+			config.TenantID = "tenant"
+			storageAccount, err := NewStorageAccount(logger, config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*storageAccount.ServicePrincipal).To(Equal(ServicePrincipal{TenantID: "tenant"}))
+		})
+
+		It("should raise an error when both a tenant and a CredHub reference are given", func() {
+			config.TenantID, config.CredHubRef = "tenant", "/team/sp"
+			_, err := NewStorageAccount(logger, config)
+			Expect(err).To(MatchError("Either credhub_ref or tenant_id, client_id and client_secret can be given"))
+		})
+
+		It("should raise an error when the credentials are incomplete", func() {
+			config.TenantID, config.ClientID = "tenant", "client"
+			_, err := NewStorageAccount(logger, config)
+			Expect(err).To(MatchError("tenant_id, client_id and client_secret must be given together"))
+		})
+
+		It("should raise an error when both credentials and a CredHub reference are given", func() {
+			config.TenantID, config.ClientID, config.ClientSecret, config.CredHubRef = "tenant", "client", "secret", "/team/sp"
+			_, err := NewStorageAccount(logger, config)
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
 
 var _ = Describe("BindOptions", func() {
@@ -102,6 +164,7 @@ var _ = Describe("BindOptions", func() {
 			Readonly:      true,
 			Vers:          "b",
 			Mount:         "c",
+			ShareSAS:      "sv=2019-02-02&sig=secret",
 		}
 	})
 
@@ -118,4 +181,3219 @@ var _ = Describe("BindOptions", func() {
 			}))
 		})
 	})
+
+	Context("Validate an access policy", func() {
+		It("should accept a policy or the ID of a defined policy for AzureFileShare", func() {
+			options.AccessPolicy = &ShareAccessPolicy{ID: "readers", Permissions: "lr", Expiry: time.Now().Add(time.Hour)}
+			Expect(options.Validate(false)).To(Succeed())
+			options.AccessPolicy = &ShareAccessPolicy{ID: "readers"}
+			Expect(options.Validate(false)).To(Succeed())
+		})
+
+		It("should refuse a policy for a preexisting share", func() {
+			options.AccessPolicy = &ShareAccessPolicy{ID: "readers"}
+			Expect(ErrorCode(options.Validate(true))).To(Equal(ErrCodeInvalidParameters))
+		})
+
+		It("should refuse a policy without an ID, expiry or valid permissions", func() {
+			options.AccessPolicy = &ShareAccessPolicy{Permissions: "r", Expiry: time.Now().Add(time.Hour)}
+			Expect(ErrorCode(options.Validate(false))).To(Equal(ErrCodeInvalidParameters))
+			options.AccessPolicy = &ShareAccessPolicy{ID: "readers", Permissions: "r"}
+			Expect(options.Validate(false)).To(MatchError(`Missing expiry of the access policy "readers"`))
+			options.AccessPolicy = &ShareAccessPolicy{ID: "readers", Permissions: "rr", Expiry: time.Now().Add(time.Hour)}
+			Expect(ErrorCode(options.Validate(false))).To(Equal(ErrCodeInvalidParameters))
+		})
+	})
+
+	Context("Validate the directories", func() {
+		It("should accept relative and absolute paths for AzureFileShare", func() {
+			options.Directories = []string{"logs", "/data/uploads/"}
+			Expect(options.Validate(false)).To(Succeed())
+		})
+
+		It("should reject the directories for preexisting shares", func() {
+			options.Directories = []string{"logs"}
+			Expect(options.Validate(true)).To(MatchError("The parameter directories is only supported by AzureFileShare instances"))
+		})
+
+		It("should reject the paths which leave the share or have invalid names", func() {
+			options.Directories = []string{"data/../../etc"}
+			Expect(options.Validate(false)).To(MatchError(`Invalid directory "data/../../etc": the name ".." is not allowed in Azure Files`))
+			options.Directories = []string{"logs:today"}
+			Expect(ErrorCode(options.Validate(false))).To(Equal(ErrCodeInvalidParameters))
+			options.Directories = []string{"/"}
+			Expect(ErrorCode(options.Validate(false))).To(Equal(ErrCodeInvalidParameters))
+		})
+	})
+})
+
+var _ = Describe("Broker", func() {
+	var (
+		broker      *Broker
+		fakeStore   *azurefilebrokerfakes.FakeStore
+		fakeClock   *fakeclock.FakeClock
+		control     *ControlConfig
+		preexisting *PreexistingConfig
+		timeouts    *TimeoutConfig
+		placement   *PlacementConfig
+		naming      *NamingConfig
+		segments    *IsolationSegmentConfig
+		credentials *CredentialConfig
+		pool        *StoragePoolConfig
+		backup      *BackupConfig
+		alerts      *AlertConfig
+		accessLogs  *AccessLogConfig
+		ctx         context.Context
+
+		planMountOptions string
+	)
+
+	BeforeEach(func() {
+		fakeStore = &azurefilebrokerfakes.FakeStore{}
+		ctx = context.TODO()
+		control = NewControlConfig(false, false, false, false, false, "", false, false, false, 0, 0)
+		preexisting = NewPreexistingConfig("")
+		timeouts = NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0)
+		placement = NewPlacementConfig("")
+		naming = NewNamingConfig("", "")
+		segments = NewIsolationSegmentConfig("")
+		credentials = NewCredentialConfig("")
+		pool = NewStoragePoolConfig("")
+		backup = NewBackupConfig("", "")
+		alerts = NewAlertConfig("", "")
+		accessLogs = NewAccessLogConfig("", "", "")
+		planMountOptions = ""
+	})
+
+	JustBeforeEach(func() {
+		logger := lagertest.NewTestLogger("broker-test")
+		mount := NewAzurefilebrokerMountConfig()
+		mount.ReadConf("share,uid,gid,file_mode,dir_mode,readonly,vers,mount,domain,username,password,sec", "")
+		mount.ReadPlanConf(planMountOptions)
+		cloud := NewAzurefilebrokerCloudConfig(
+			NewAzureConfig("Preexisting", "", "", "", "", "", "", "", "", "", nil),
+			control,
+			NewAzureStackConfig("", "", "", ""),
+			NewCredHubConfig("", "", "", ""),
+		)
+
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		broker = New(logger, "service-name", "service-id", fakeClock, fakeStore, NewAzurefilebrokerConfig(mount, cloud, preexisting, timeouts, placement, naming, segments, credentials, pool, backup, alerts, accessLogs))
+	})
+
+	Context("Bind", func() {
+		var (
+			bindDetails brokerapi.BindDetails
+			err         error
+		)
+
+		BeforeEach(func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				ServiceID:     "service-id",
+				PlanID:        "plan-id",
+				IsPreexisting: true,
+				TargetName:    "//server/share",
+			}, nil)
+			bindDetails = brokerapi.BindDetails{
+				AppGUID:       "app-guid",
+				PlanID:        "plan-id",
+				ServiceID:     "service-id",
+				RawParameters: json.RawMessage(`{"username":"user","password":"secret","uid":"1000"}`),
+			}
+		})
+
+		Context("when the binding does not exist", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, errors.New("not found"))
+			})
+
+			It("should create the binding details without the password", func() {
+				_, err = broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(1))
+				id, details := fakeStore.CreateBindingDetailsArgsForCall(0)
+				Expect(id).To(Equal("binding-id"))
+				Expect(details.InstanceID).To(Equal("instance-id"))
+				Expect(details.RawParameters).To(BeNil())
+				Expect(details.ParamsHash).To(MatchRegexp("^[0-9a-f]{32}:[0-9a-f]{64}$"))
+				Expect(details.ParamsHash).NotTo(ContainSubstring("secret"))
+				Expect(details.BindOptions.Username).To(Equal("user"))
+				Expect(details.BindOptions.Password).To(BeEmpty())
+			})
+
+			It("should not store the SAS token of the share", func() {
+				bindDetails.RawParameters = json.RawMessage(`{"username":"user","password":"secret","share_sas":"sv=2019-02-02&sig=secret"}`)
+				_, err = broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+				Expect(err).NotTo(HaveOccurred())
+				_, details := fakeStore.CreateBindingDetailsArgsForCall(0)
+				Expect(details.BindOptions.ShareSAS).To(BeEmpty())
+			})
+
+			It("should mount the share read-only when the instance is read-only", func() {
+				fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+					ServiceID:     "service-id",
+					PlanID:        "plan-id",
+					IsPreexisting: true,
+					TargetName:    "//server/share",
+					Readonly:      true,
+				}, nil)
+				binding, err := broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.VolumeMounts[0].Mode).To(Equal("r"))
+				Expect(binding.VolumeMounts[0].Device.MountConfig).To(HaveKeyWithValue("readonly", "true"))
+			})
+
+			It("should refuse a bind without an app", func() {
+				bindDetails.AppGUID = ""
+				_, err = broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+				Expect(err).To(Equal(brokerapi.ErrAppGuidNotProvided))
+				Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+			})
+
+			Context("when the service keys are allowed", func() {
+				BeforeEach(func() {
+					control.AllowServiceKeys = true
+					bindDetails.AppGUID = ""
+				})
+
+				It("should return the SMB credentials of the share instead of a volume mount", func() {
+					binding, err := broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(binding.VolumeMounts).To(BeEmpty())
+					Expect(binding.Credentials).To(Equal(map[string]string{
+						"share":    "//server/share",
+						"username": "user",
+						"password": "secret",
+					}))
+					Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(1))
+					_, details := fakeStore.CreateBindingDetailsArgsForCall(0)
+					Expect(details.BindOptions.Password).To(BeEmpty())
+				})
+			})
+
+			Context("when the plan of the instance has defaults", func() {
+				BeforeEach(func() {
+					planMountOptions = "Existing=vers:3.0;noperm:true"
+				})
+
+				It("should mount the share with them", func() {
+					binding, err := broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(binding.VolumeMounts[0].Device.MountConfig).To(HaveKeyWithValue("vers", "3.0"))
+					Expect(binding.VolumeMounts[0].Device.MountConfig).To(HaveKeyWithValue("noperm", "true"))
+				})
+
+				It("should let the bind parameters override the allowed defaults", func() {
+					bindDetails.RawParameters = json.RawMessage(`{"username":"user","password":"secret","vers":"2.1"}`)
+					binding, err := broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(binding.VolumeMounts[0].Device.MountConfig).To(HaveKeyWithValue("vers", "2.1"))
+					Expect(binding.VolumeMounts[0].Device.MountConfig).To(HaveKeyWithValue("noperm", "true"))
+				})
+			})
+
+			It("should return a SHA-256 volume ID which does not depend on the order of the parameters", func() {
+				binding, err := broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+				Expect(err).NotTo(HaveOccurred())
+				volumeID := binding.VolumeMounts[0].Device.VolumeId
+				Expect(volumeID).To(MatchRegexp("^instance-id-[0-9a-f]{64}$"))
+				_, details := fakeStore.CreateBindingDetailsArgsForCall(0)
+				Expect(details.VolumeIDVersion).To(Equal("sha256"))
+
+				bindDetails.RawParameters = json.RawMessage(`{"uid":"1000","password":"another-secret","username":"user"}`)
+				binding, err = broker.Bind(ctx, "instance-id", "another-binding-id", bindDetails)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.VolumeMounts[0].Device.VolumeId).To(Equal(volumeID))
+			})
+		})
+
+		Context("when the binding already exists", func() {
+			var existing BindingDetails
+
+			BeforeEach(func() {
+				existing = BindingDetails{
+					BindDetails: brokerapi.BindDetails{
+						AppGUID:   "app-guid",
+						PlanID:    "plan-id",
+						ServiceID: "service-id",
+					},
+					BindOptions: &BindOptions{Username: "user", UID: "1000"},
+				}
+			})
+
+			JustBeforeEach(func() {
+				fakeStore.RetrieveBindingDetailsReturns(existing, nil)
+			})
+
+			It("should return the binding without storing it again when the parameters match", func() {
+				binding, err := broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.VolumeMounts).To(HaveLen(1))
+				Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+			})
+
+			It("should keep the legacy MD5 volume ID of a binding created by an older broker", func() {
+				binding, err := broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(binding.VolumeMounts[0].Device.VolumeId).To(MatchRegexp("^instance-id-[0-9a-f]{32}$"))
+			})
+
+			Context("when the parameters differ", func() {
+				BeforeEach(func() {
+					existing.BindOptions = &BindOptions{Username: "user", UID: "2000"}
+				})
+
+				It("should return a conflict", func() {
+					_, err = broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+					Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the binding has a hash of the parameters", func() {
+				JustBeforeEach(func() {
+					fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, errors.New("not found"))
+					_, err := broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).NotTo(HaveOccurred())
+					_, stored := fakeStore.CreateBindingDetailsArgsForCall(0)
+					fakeStore.RetrieveBindingDetailsReturns(stored, nil)
+				})
+
+				It("should return the binding when the parameters match", func() {
+					_, err = broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(1))
+				})
+
+				It("should return a conflict when only the password differs", func() {
+					bindDetails.RawParameters = json.RawMessage(`{"username":"user","password":"another-secret","uid":"1000"}`)
+					_, err = broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+				})
+			})
+
+			Context("when the app differs", func() {
+				BeforeEach(func() {
+					existing.AppGUID = "another-app-guid"
+				})
+
+				It("should return a conflict", func() {
+					_, err = broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+				})
+			})
+		})
+
+		Context("when the storage account ownership is enforced", func() {
+			BeforeEach(func() {
+				control.EnforceStorageAccountOwnership = true
+				fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+					ServiceID:         "service-id",
+					PlanID:            "plan-id",
+					OrganizationGUID:  "org-guid",
+					SpaceGUID:         "space-guid",
+					TargetName:        "account",
+					SubscriptionID:    "subscription",
+					ResourceGroupName: "resourcegroup",
+				}, nil)
+				fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, errors.New("not found"))
+				bindDetails.RawParameters = json.RawMessage(`{"share":"share"}`)
+			})
+
+			Context("when the storage account is owned by another space", func() {
+				BeforeEach(func() {
+					fakeStore.RetrieveStorageAccountOwnerReturns(StorageAccountOwner{OrganizationGUID: "org-guid", SpaceGUID: "another-space-guid"}, nil)
+				})
+
+				It("should refuse to bind", func() {
+					_, err = broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).To(MatchError(`The storage account "account" is owned by another org or space`))
+					Expect(ErrorCode(err)).To(Equal(ErrCodeStorageAccountOwnedByAnother))
+					Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusForbidden))
+					Expect(fakeStore.RetrieveStorageAccountOwnerArgsForCall(0)).To(Equal("subscription-resourcegroup-account"))
+					Expect(fakeStore.CreateStorageAccountOwnerCallCount()).To(Equal(0))
+					Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the plan only returns SAS tokens", func() {
+				BeforeEach(func() {
+					credentials = NewCredentialConfig("AzureFileShare=sas")
+				})
+
+				It("should require an access policy", func() {
+					_, err = broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).To(MatchError("The plan AzureFileShare only returns SAS tokens: the parameter access_policy must be given"))
+					Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+					Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the share names must comply with a naming policy", func() {
+				BeforeEach(func() {
+					naming = NewNamingConfig("", "prefix:{space}-")
+				})
+
+				It("should refuse a share which does not start with the name of the space", func() {
+					bindDetails.RawContext = json.RawMessage(`{"organization_name":"Finance","space_name":"Payroll"}`)
+					_, err = broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).To(MatchError(`The name of the file share "share" does not comply with the naming policy: it must start with "payroll-"`))
+					Expect(ErrorCode(err)).To(Equal(ErrCodeNamingPolicyViolation))
+					Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadRequest))
+					Expect(fakeStore.RetrieveStorageAccountOwnerCallCount()).To(Equal(0))
+				})
+
+				It("should refuse a share when the platform does not send the name of the space", func() {
+					_, err = broker.Bind(ctx, "instance-id", "binding-id", bindDetails)
+					Expect(err).To(MatchError(`The name of the file share "share" cannot be checked against the naming policy because the platform did not send the name of the space`))
+				})
+			})
+		})
+	})
+
+	Context("RepairShareCounts", func() {
+		var err error
+
+		BeforeEach(func() {
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+				"instance-share": {InstanceID: "instance", FileShareName: "share", Count: 3},
+			}, nil)
+			fakeStore.RetrieveFileShareReturns(FileShare{InstanceID: "instance", FileShareName: "share", Count: 3}, nil)
+		})
+
+		JustBeforeEach(func() {
+			err = broker.RepairShareCounts(lagertest.NewTestLogger("repair"))
+		})
+
+		Context("when the count is wrong", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveAllBindingDetailsReturns(map[string]BindingDetails{
+					"binding-1": {FileShareID: "instance-share"},
+					"binding-2": {FileShareID: "instance-share"},
+					"binding-3": {FileShareID: "instance-another-share"},
+				}, nil)
+			})
+
+			It("should update the count under the lock of the file share", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeStore.GetLockForUpdateCallCount()).To(Equal(1))
+				lockName, _ := fakeStore.GetLockForUpdateArgsForCall(0)
+				Expect(lockName).To(Equal("instance-share"))
+				Expect(fakeStore.UpdateFileShareCallCount()).To(Equal(1))
+				id, share := fakeStore.UpdateFileShareArgsForCall(0)
+				Expect(id).To(Equal("instance-share"))
+				Expect(share.Count).To(Equal(2))
+				Expect(fakeStore.ReleaseLockForUpdateCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when no binding refers to the file share", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveAllBindingDetailsReturns(map[string]BindingDetails{}, nil)
+			})
+
+			It("should remove the file share from the store", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeStore.DeleteFileShareCallCount()).To(Equal(1))
+				Expect(fakeStore.DeleteFileShareArgsForCall(0)).To(Equal("instance-share"))
+				Expect(fakeStore.UpdateFileShareCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when a binding was created by an older version", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveAllBindingDetailsReturns(map[string]BindingDetails{
+					"binding-1": {BindDetails: brokerapi.BindDetails{RawParameters: json.RawMessage(`{"share":"share"}`)}},
+				}, nil)
+			})
+
+			It("should not repair anything", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeStore.RetrieveFileSharesCallCount()).To(Equal(0))
+				Expect(fakeStore.UpdateFileShareCallCount()).To(Equal(0))
+				Expect(fakeStore.DeleteFileShareCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("CleanupStore", func() {
+		var (
+			dryRun   bool
+			bindings map[string]BindingDetails
+			report   StoreCleanupReport
+			err      error
+		)
+
+		BeforeEach(func() {
+			dryRun = true
+			fakeStore.RetrieveServiceInstancesReturns(map[string]ServiceInstance{
+				"instance": {TargetName: "account"},
+			}, nil)
+			bindings = map[string]BindingDetails{
+				"binding-1":      {FileShareID: "instance-share"},
+				"binding-2":      {FileShareID: "gone-instance-share"},
+				"binding-3":      {FileShareID: "instance-unstored-share"},
+				"legacy-binding": {BindDetails: brokerapi.BindDetails{RawParameters: json.RawMessage(`{"share":"share"}`)}},
+			}
+			fakeStore.RetrieveAllBindingDetailsReturns(bindings, nil)
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+				"instance-share":       {InstanceID: "instance", FileShareName: "share", Count: 1},
+				"instance-empty-share": {InstanceID: "instance", FileShareName: "empty-share", Count: 0},
+				"gone-instance-share":  {InstanceID: "gone-instance", FileShareName: "share", Count: 1},
+				"gone-instance-other":  {InstanceID: "gone-instance", FileShareName: "other", Count: 2},
+			}, nil)
+			fakeStore.RetrieveScheduledDeletionsReturns(map[string]ScheduledDeletion{
+				"file-share-expired":     {Kind: "file-share", DeleteAfter: time.Now().Add(-time.Hour)},
+				"file-share-not-expired": {Kind: "file-share", DeleteAfter: time.Now().Add(time.Hour)},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			report, err = broker.CleanupStore(lagertest.NewTestLogger("cleanup"), dryRun)
+		})
+
+		It("should only report the rows in a dry run", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.DryRun).To(BeTrue())
+			Expect(report.OrphanBindings).To(Equal([]string{"binding-2"}))
+			Expect(report.UnusedFileShares).To(Equal([]string{"gone-instance-other", "gone-instance-share", "instance-empty-share"}))
+			Expect(report.ExpiredScheduledDeletions).To(Equal([]string{"file-share-expired"}))
+			Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(0))
+			Expect(fakeStore.DeleteFileShareCallCount()).To(Equal(0))
+			Expect(fakeStore.GetLockForUpdateCallCount()).To(Equal(0))
+		})
+
+		Context("when the rows are removed", func() {
+			BeforeEach(func() {
+				dryRun = false
+				fakeStore.RetrieveScheduledDeletionsReturns(map[string]ScheduledDeletion{}, nil)
+				// The file shares are checked again after the orphan bindings are removed
+				fakeStore.RetrieveAllBindingDetailsReturnsOnCall(0, bindings, nil)
+				fakeStore.RetrieveAllBindingDetailsReturns(map[string]BindingDetails{
+					"binding-1": {FileShareID: "instance-share"},
+				}, nil)
+			})
+
+			It("should remove the orphan bindings and the unused file shares under their locks", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Errors).To(BeEmpty())
+				Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(1))
+				Expect(fakeStore.DeleteBindingDetailsArgsForCall(0)).To(Equal("binding-2"))
+				Expect(fakeStore.DeleteFileShareCallCount()).To(Equal(3))
+				Expect(fakeStore.DeleteFileShareArgsForCall(0)).To(Equal("gone-instance-other"))
+				Expect(fakeStore.DeleteFileShareArgsForCall(1)).To(Equal("gone-instance-share"))
+				Expect(fakeStore.DeleteFileShareArgsForCall(2)).To(Equal("instance-empty-share"))
+				Expect(fakeStore.GetLockForUpdateCallCount()).To(Equal(3))
+				Expect(fakeStore.ReleaseLockForUpdateCallCount()).To(Equal(3))
+			})
+		})
+
+		Context("when a file share cannot be removed", func() {
+			BeforeEach(func() {
+				dryRun = false
+				fakeStore.RetrieveScheduledDeletionsReturns(map[string]ScheduledDeletion{}, nil)
+				fakeStore.DeleteFileShareReturns(errors.New("database is down"))
+				fakeStore.DeleteBindingDetailsReturns(errors.New("database is down"))
+			})
+
+			It("should report the errors and go on", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(report.Errors).To(HaveLen(3))
+				Expect(report.Errors[0]).To(HavePrefix(`binding "binding-2"`))
+				Expect(report.Errors[0]).To(ContainSubstring("database is down"))
+			})
+		})
+	})
+
+	Context("LastOperation", func() {
+		It("should return the stored failure of the provision", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{ProvisioningState: "failed", OperationError: "StorageAccountAlreadyTaken: taken"}, nil)
+			lastOperation, err := broker.LastOperation(ctx, "instance-id", "operation-url")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastOperation.State).To(Equal(brokerapi.Failed))
+			Expect(lastOperation.Description).To(Equal("StorageAccountAlreadyTaken: taken"))
+		})
+
+		It("should return succeeded for a provisioned instance without polling", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{ProvisioningState: "succeeded", OperationURL: "operation-url"}, nil)
+			lastOperation, err := broker.LastOperation(ctx, "instance-id", "operation-url")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastOperation.State).To(Equal(brokerapi.Succeeded))
+			Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("Deprovision", func() {
+		It("should report the deletion in progress without deleting the instance", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{ProvisioningState: "deleting", OperationURL: "operation-url"}, nil)
+			spec, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.IsAsync).To(BeTrue())
+			Expect(spec.OperationData).To(Equal("deprovision"))
+			Expect(fakeStore.DeleteServiceInstanceCallCount()).To(Equal(0))
+		})
+
+		It("should delete a preexisting instance", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{IsPreexisting: true, ProvisioningState: "succeeded"}, nil)
+			spec, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.IsAsync).To(BeFalse())
+			Expect(fakeStore.DeleteServiceInstanceArgsForCall(0)).To(Equal("instance-id"))
+			Expect(fakeStore.DeleteStorageAccountOwnerCallCount()).To(Equal(0))
+		})
+
+		It("should refuse an instance which requires a newer broker", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{IsPreexisting: true, ProvisioningState: "succeeded", MinBrokerVersion: 99}, nil)
+			_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(ErrorCode(err)).To(Equal(ErrCodeBrokerVersionTooOld))
+			Expect(fakeStore.DeleteServiceInstanceCallCount()).To(Equal(0))
+		})
+	})
+	Context("Update", func() {
+		var (
+			updateDetails brokerapi.UpdateDetails
+			asyncAllowed  bool
+			spec          brokerapi.UpdateServiceSpec
+			err           error
+		)
+
+		BeforeEach(func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				ServiceID:         "service-id",
+				PlanID:            "plan-id",
+				IsPreexisting:     true,
+				TargetName:        "//server/share",
+				ProvisioningState: "succeeded",
+				Metadata:          InstanceMetadata{Labels: map[string]string{"team": "storage", "env": "dev"}},
+			}, nil)
+			updateDetails = brokerapi.UpdateDetails{
+				ServiceID:     "service-id",
+				PlanID:        "plan-id",
+				RawParameters: json.RawMessage(`{"labels":{"env":"prod","team":""},"cost_center":"cc-1"}`),
+			}
+			asyncAllowed = false
+		})
+
+		JustBeforeEach(func() {
+			spec, err = broker.Update(ctx, "instance-id", updateDetails, asyncAllowed)
+		})
+
+		It("should store the updated metadata", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(spec.IsAsync).To(BeFalse())
+			Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(1))
+			id, instance := fakeStore.UpdateServiceInstanceArgsForCall(0)
+			Expect(id).To(Equal("instance-id"))
+			Expect(instance.Metadata).To(Equal(InstanceMetadata{Labels: map[string]string{"env": "prod"}, CostCenter: "cc-1"}))
+			Expect(instance.TargetName).To(Equal("//server/share"))
+		})
+
+		Context("when an unsupported parameter is given", func() {
+			BeforeEach(func() {
+				updateDetails.RawParameters = json.RawMessage(`{"share":"//another/share"}`)
+			})
+
+			It("should refuse to update", func() {
+				Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+				Expect(err).To(MatchError("Unsupported parameters: share. Only labels, description, cost_center, share_access_policies, migrate_to, readonly, use_https, sku_name can be updated"))
+				Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the read-only mode is switched on", func() {
+			BeforeEach(func() {
+				updateDetails.RawParameters = json.RawMessage(`{"readonly":true}`)
+			})
+
+			It("should store it without changing the metadata", func() {
+				Expect(err).NotTo(HaveOccurred())
+				_, instance := fakeStore.UpdateServiceInstanceArgsForCall(0)
+				Expect(instance.Readonly).To(BeTrue())
+				Expect(instance.Metadata.Labels).To(Equal(map[string]string{"env": "dev", "team": "storage"}))
+			})
+		})
+
+		Context("when a label is reserved", func() {
+			BeforeEach(func() {
+				updateDetails.RawParameters = json.RawMessage(`{"labels":{"creator":"me"}}`)
+			})
+
+			It("should refuse to update", func() {
+				Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+				Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the plan changes", func() {
+			BeforeEach(func() {
+				updateDetails.PlanID = "another-plan-id"
+			})
+
+			It("should refuse to update", func() {
+				Expect(err).To(Equal(brokerapi.ErrPlanChangeNotSupported))
+			})
+
+			Context("for an AzureFileShare instance", func() {
+				BeforeEach(func() {
+					segments = NewIsolationSegmentConfig(
+						"segment1=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells," +
+							"segment2=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells," +
+							"segment3=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/other")
+					planMountOptions = "AzureFileShare-segment2=vers:3.0"
+					fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+						ServiceID:         "service-id",
+						PlanID:            segments.Networks[0].PlanID(),
+						TargetName:        "account",
+						ProvisioningState: "succeeded",
+					}, nil)
+					fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+						"instance-id-data":  {InstanceID: "instance-id", FileShareName: "data"},
+						"other-id-data":     {InstanceID: "other-id", FileShareName: "data"},
+						"instance-id-empty": {InstanceID: "instance-id", FileShareName: "empty"},
+					}, nil)
+					fakeStore.RetrieveAllBindingDetailsReturns(map[string]BindingDetails{
+						"binding-b": {FileShareID: "instance-id-data"},
+						"binding-a": {FileShareID: "instance-id-data"},
+						"binding-c": {FileShareID: "other-id-data"},
+					}, nil)
+					updateDetails.PlanID = segments.Networks[1].PlanID()
+				})
+
+				It("should move it to the plan and warn of the bindings which keep the mount options of the previous plan", func() {
+					Expect(err).NotTo(HaveOccurred())
+					_, instance := fakeStore.UpdateServiceInstanceArgsForCall(0)
+					Expect(instance.PlanID).To(Equal(segments.Networks[1].PlanID()))
+					Expect(fakeStore.CreateInstanceOperationCallCount()).To(Equal(1))
+					_, operation := fakeStore.CreateInstanceOperationArgsForCall(0)
+					Expect(operation.Warning).To(Equal(`The bindings binding-a, binding-b keep the mount options of the plan "AzureFileShare-segment1" until they are created again`))
+				})
+
+				Context("when both plans have the same defaults", func() {
+					BeforeEach(func() {
+						planMountOptions = ""
+					})
+
+					It("should not warn", func() {
+						Expect(err).NotTo(HaveOccurred())
+						_, operation := fakeStore.CreateInstanceOperationArgsForCall(0)
+						Expect(operation.Warning).To(BeEmpty())
+					})
+				})
+
+				Context("when the storage accounts of the plan allow other networks", func() {
+					BeforeEach(func() {
+						updateDetails.PlanID = segments.Networks[2].PlanID()
+					})
+
+					It("should require a migration", func() {
+						Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+						Expect(err).To(MatchError(ContainSubstring("give migrate_to")))
+						Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+					})
+				})
+			})
+		})
+
+		Context("when the access policies of the file shares are given", func() {
+			BeforeEach(func() {
+				updateDetails.RawParameters = json.RawMessage(`{"share_access_policies":{"data":[{"id":"readers","permissions":"lr","expiry":"2999-01-01T00:00:00Z"}]}}`)
+			})
+
+			It("should refuse them for a preexisting share", func() {
+				Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+				Expect(err).To(MatchError(ContainSubstring("only supported by AzureFileShare instances")))
+				Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+			})
+
+			Context("for an AzureFileShare instance", func() {
+				BeforeEach(func() {
+					fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+						ServiceID:         "service-id",
+						PlanID:            "plan-id",
+						TargetName:        "account",
+						ProvisioningState: "succeeded",
+					}, nil)
+					fakeStore.RetrieveFileShareReturns(FileShare{InstanceID: "instance-id", FileShareName: "data"}, nil)
+				})
+
+				Context("when the file share is not bound to the instance", func() {
+					BeforeEach(func() {
+						fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+					})
+
+					It("should refuse to update", func() {
+						Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+						Expect(err).To(MatchError(`The file share "data" is not bound to the service instance`))
+						Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+					})
+				})
+
+				Context("when a permission is unknown", func() {
+					BeforeEach(func() {
+						updateDetails.RawParameters = json.RawMessage(`{"share_access_policies":{"data":[{"id":"readers","permissions":"rx","expiry":"2999-01-01T00:00:00Z"}]}}`)
+					})
+
+					It("should refuse to update", func() {
+						Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+						Expect(err).To(MatchError(ContainSubstring(`Invalid permissions "rx" of the access policy "readers"`)))
+					})
+				})
+
+				Context("when a policy writes to the share of a read-only instance", func() {
+					BeforeEach(func() {
+						updateDetails.RawParameters = json.RawMessage(`{"readonly":true,"share_access_policies":{"data":[{"id":"writers","permissions":"rwl","expiry":"2999-01-01T00:00:00Z"}]}}`)
+					})
+
+					It("should refuse to update", func() {
+						Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+						Expect(err).To(MatchError(`The service instance is read-only: the access policy "writers" must only have the permissions rl`))
+						Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+					})
+				})
+
+				Context("when a policy has expired", func() {
+					BeforeEach(func() {
+						updateDetails.RawParameters = json.RawMessage(`{"share_access_policies":{"data":[{"id":"readers","permissions":"rl","expiry":"2000-01-01T00:00:00Z"}]}}`)
+					})
+
+					It("should refuse to update", func() {
+						Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+						Expect(err).To(MatchError(`The access policy "readers" has expired`))
+					})
+				})
+
+				Context("when a policy only has an ID", func() {
+					BeforeEach(func() {
+						updateDetails.RawParameters = json.RawMessage(`{"share_access_policies":{"data":[{"id":"readers"}]}}`)
+					})
+
+					It("should refuse to update", func() {
+						Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+						Expect(err).To(MatchError(`Missing permissions and expiry of the access policy "readers"`))
+					})
+				})
+
+				Context("when a share has too many policies", func() {
+					BeforeEach(func() {
+						policies := []string{}
+						for i := 0; i < 6; i++ {
+							policies = append(policies, fmt.Sprintf(`{"id":"policy-%d","permissions":"r","expiry":"2999-01-01T00:00:00Z"}`, i))
+						}
+						updateDetails.RawParameters = json.RawMessage(fmt.Sprintf(`{"share_access_policies":{"data":[%s]}}`, strings.Join(policies, ",")))
+					})
+
+					It("should refuse to update", func() {
+						Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+						Expect(err).To(MatchError(`The file share "data" can have at most 5 access policies`))
+					})
+				})
+			})
+		})
+	})
+
+	Context("storage account migration", func() {
+		var instance ServiceInstance
+
+		BeforeEach(func() {
+			instance = ServiceInstance{
+				ServiceID:               "service-id",
+				PlanID:                  "plan-id",
+				TargetName:              "account",
+				SkuName:                 "Standard_LRS",
+				Location:                "westus",
+				IsCreatedStorageAccount: true,
+				ProvisioningState:       "succeeded",
+			}
+		})
+
+		JustBeforeEach(func() {
+			fakeStore.RetrieveServiceInstanceReturns(instance, nil)
+		})
+
+		Context("when the update starts a migration", func() {
+			var (
+				rawParameters string
+				asyncAllowed  bool
+				spec          brokerapi.UpdateServiceSpec
+				err           error
+			)
+
+			BeforeEach(func() {
+				rawParameters = `{"migrate_to":{"storage_account_name":"newaccount","sku_name":"Premium_LRS"}}`
+				asyncAllowed = true
+			})
+
+			JustBeforeEach(func() {
+				spec, err = broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{ServiceID: "service-id", PlanID: "plan-id", RawParameters: json.RawMessage(rawParameters)}, asyncAllowed)
+			})
+
+			Context("for a preexisting share", func() {
+				BeforeEach(func() {
+					instance = ServiceInstance{ServiceID: "service-id", PlanID: "plan-id", IsPreexisting: true, TargetName: "//server/share", ProvisioningState: "succeeded"}
+				})
+
+				It("should refuse to migrate", func() {
+					Expect(ErrorCode(err)).To(Equal(ErrCodeOperationNotSupportedForShare))
+					Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the storage account was not created by the broker", func() {
+				BeforeEach(func() {
+					instance.IsCreatedStorageAccount = false
+				})
+
+				It("should refuse to migrate", func() {
+					Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+					Expect(err).To(MatchError(ContainSubstring(`the storage account "account" may be used by other instances`)))
+					Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the platform does not allow asynchronous operations", func() {
+				BeforeEach(func() {
+					asyncAllowed = false
+				})
+
+				It("should require them", func() {
+					Expect(err).To(Equal(brokerapi.ErrAsyncRequired))
+					Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the target is the storage account of the instance", func() {
+				BeforeEach(func() {
+					rawParameters = `{"migrate_to":{"storage_account_name":"account"}}`
+				})
+
+				It("should refuse to migrate", func() {
+					Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+					Expect(err).To(MatchError(`The file shares are already in the storage account "account"`))
+				})
+			})
+
+			Context("when the file shares of the instance are bound", func() {
+				BeforeEach(func() {
+					fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+						"share-id-1": {InstanceID: "instance-id", FileShareName: "logs"},
+						"share-id-2": {InstanceID: "instance-id", FileShareName: "data"},
+						"share-id-3": {InstanceID: "another-instance-id", FileShareName: "other"},
+					}, nil)
+				})
+
+				It("should ask to unbind the apps", func() {
+					Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+					Expect(err).To(MatchError("The file shares data, logs are bound: unbind the apps before the migration"))
+					Expect(fakeStore.UpdateServiceInstanceCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when a migration is in progress", func() {
+				BeforeEach(func() {
+					instance.Migration = &StorageAccountMigration{TargetName: "newaccount", State: "copying"}
+				})
+
+				It("should refuse to update", func() {
+					Expect(ErrorCode(err)).To(Equal(ErrCodeOperationInProgress))
+					Expect(spec.IsAsync).To(BeFalse())
+				})
+			})
+		})
+
+		Context("when a migration is in progress", func() {
+			BeforeEach(func() {
+				instance.Migration = &StorageAccountMigration{TargetName: "newaccount", State: "copying", CopiedFiles: 3, PendingFiles: 2}
+			})
+
+			It("should refuse to deprovision", func() {
+				_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+				Expect(ErrorCode(err)).To(Equal(ErrCodeOperationInProgress))
+				Expect(fakeStore.DeleteServiceInstanceCallCount()).To(Equal(0))
+			})
+
+			It("should refuse to bind", func() {
+				_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "guid"})
+				Expect(ErrorCode(err)).To(Equal(ErrCodeOperationInProgress))
+				Expect(fakeStore.CreateFileShareCallCount()).To(Equal(0))
+			})
+
+			It("should report the progress of the copy", func() {
+				lastOperation, err := broker.LastOperation(ctx, "instance-id", "migration")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lastOperation.State).To(Equal(brokerapi.InProgress))
+				Expect(lastOperation.Description).To(ContainSubstring("3 file(s) copied, 2 pending"))
+			})
+		})
+
+		Context("when the migration has failed", func() {
+			BeforeEach(func() {
+				instance.Migration = &StorageAccountMigration{TargetName: "newaccount", State: "failed", Error: "copy failed"}
+			})
+
+			It("should report the failure", func() {
+				lastOperation, err := broker.LastOperation(ctx, "instance-id", "migration")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lastOperation.State).To(Equal(brokerapi.Failed))
+				Expect(lastOperation.Description).To(Equal("copy failed"))
+			})
+
+			It("should allow to deprovision", func() {
+				instance.IsCreatedStorageAccount = false
+				fakeStore.RetrieveServiceInstanceReturns(instance, nil)
+				_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeStore.DeleteServiceInstanceCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when the migration has finished", func() {
+			It("should report the storage account of the instance", func() {
+				lastOperation, err := broker.LastOperation(ctx, "instance-id", "migration")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lastOperation.State).To(Equal(brokerapi.Succeeded))
+				Expect(lastOperation.Description).To(ContainSubstring(`"account"`))
+			})
+		})
+	})
+
+	Context("request deduplication", func() {
+		var provisionDetails brokerapi.ProvisionDetails
+
+		BeforeEach(func() {
+			preexisting = NewPreexistingConfig("//server/share, //server/another")
+			timeouts = NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, time.Minute)
+			provisionDetails = brokerapi.ProvisionDetails{RawParameters: json.RawMessage(`{"share":"//server/share"}`)}
+		})
+
+		It("should return the result of a request to its retries", func() {
+			_, err := broker.Provision(ctx, "instance-id", provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = broker.Provision(ctx, "instance-id", provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(1))
+		})
+
+		It("should run a request with other parameters", func() {
+			_, err := broker.Provision(ctx, "instance-id", provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			provisionDetails.RawParameters = json.RawMessage(`{"share":"//server/another"}`)
+			_, err = broker.Provision(ctx, "instance-id", provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(2))
+		})
+
+		It("should run the retries of a failed request", func() {
+			fakeStore.CreateServiceInstanceReturnsOnCall(0, errors.New("store-error"))
+			_, err := broker.Provision(ctx, "instance-id", provisionDetails, false)
+			Expect(err).To(HaveOccurred())
+			_, err = broker.Provision(ctx, "instance-id", provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(2))
+		})
+
+		It("should run a request again after another request of the instance", func() {
+			_, err := broker.Provision(ctx, "instance-id", provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{IsPreexisting: true, TargetName: "//server/share", ProvisioningState: "succeeded"}, nil)
+			_, err = broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = broker.Provision(ctx, "instance-id", provisionDetails, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(2))
+		})
+
+		Context("when the deduplication is disabled", func() {
+			BeforeEach(func() {
+				timeouts = NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0)
+			})
+
+			It("should run every retry", func() {
+				for i := 0; i < 2; i++ {
+					_, err := broker.Provision(ctx, "instance-id", provisionDetails, false)
+					Expect(err).NotTo(HaveOccurred())
+				}
+				Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(2))
+			})
+		})
+	})
+
+	Context("instance TTL", func() {
+		Context("when a preexisting share is provisioned with ttl_hours", func() {
+			var err error
+
+			BeforeEach(func() {
+				preexisting = NewPreexistingConfig("//server/share")
+			})
+
+			provision := func(ttlHours int) {
+				_, err = broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+					RawParameters: json.RawMessage(fmt.Sprintf(`{"share":"//server/share","ttl_hours":%d}`, ttlHours)),
+				}, false)
+			}
+
+			It("should store when the instance expires", func() {
+				provision(2)
+				Expect(err).NotTo(HaveOccurred())
+				_, serviceInstance := fakeStore.CreateServiceInstanceArgsForCall(0)
+				Expect(serviceInstance.ExpiresAt).NotTo(BeNil())
+				Expect(*serviceInstance.ExpiresAt).To(BeTemporally("~", time.Now().Add(2*time.Hour), time.Minute))
+			})
+
+			It("should refuse a negative TTL", func() {
+				provision(-1)
+				Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+				Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(0))
+			})
+
+			It("should refuse a TTL longer than a year", func() {
+				provision(24*365 + 1)
+				Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+				Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("DeprovisionExpiredInstances", func() {
+			var (
+				expired time.Time
+				err     error
+			)
+
+			BeforeEach(func() {
+				expired = time.Now().Add(-time.Hour)
+				later := time.Now().Add(time.Hour)
+				instances := map[string]ServiceInstance{
+					"expired":     {ServiceID: "service-id", PlanID: "plan-id", IsPreexisting: true, TargetName: "//server/share", ProvisioningState: "succeeded", ExpiresAt: &expired},
+					"bound":       {ServiceID: "service-id", PlanID: "plan-id", IsPreexisting: true, TargetName: "//server/share", ProvisioningState: "succeeded", ExpiresAt: &expired},
+					"not-expired": {ServiceID: "service-id", PlanID: "plan-id", IsPreexisting: true, TargetName: "//server/share", ProvisioningState: "succeeded", ExpiresAt: &later},
+					"without-ttl": {ServiceID: "service-id", PlanID: "plan-id", IsPreexisting: true, TargetName: "//server/share", ProvisioningState: "succeeded"},
+				}
+				fakeStore.RetrieveServiceInstancesReturns(instances, nil)
+				fakeStore.RetrieveServiceInstanceStub = func(id string) (ServiceInstance, error) {
+					return instances[id], nil
+				}
+				fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+					"share-id": {InstanceID: "bound", FileShareName: "data"},
+				}, nil)
+			})
+
+			JustBeforeEach(func() {
+				err = broker.DeprovisionExpiredInstances(lagertest.NewTestLogger("expire"))
+			})
+
+			It("should only deprovision the expired instances which are not bound", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeStore.DeleteServiceInstanceCallCount()).To(Equal(1))
+				Expect(fakeStore.DeleteServiceInstanceArgsForCall(0)).To(Equal("expired"))
+			})
+
+			Context("when the file shares cannot be retrieved", func() {
+				BeforeEach(func() {
+					fakeStore.RetrieveFileSharesReturns(nil, errors.New("store-error"))
+				})
+
+				It("should not deprovision any instance", func() {
+					Expect(err).To(MatchError("store-error"))
+					Expect(fakeStore.DeleteServiceInstanceCallCount()).To(Equal(0))
+				})
+			})
+		})
+	})
+
+	Context("MaintenanceWindows", func() {
+		var process ifrit.Process
+
+		// window returns the window which opens in about 90 minutes and lasts an hour
+		window := func() string {
+			opening := fakeClock.Now().UTC().Add(90 * time.Minute)
+			return fmt.Sprintf("%s-%s", opening.Format("15:04"), opening.Add(time.Hour).Format("15:04"))
+		}
+
+		AfterEach(func() {
+			if process != nil {
+				process.Signal(os.Interrupt)
+				Eventually(process.Wait()).Should(Receive())
+				process = nil
+			}
+		})
+
+		It("should defer a job which is due outside its windows to the opening of the next one", func() {
+			broker.SetMaintenanceWindows(NewMaintenanceWindowConfig("instance-expirer="+window(), "UTC"))
+			process = ifrit.Invoke(broker.InstanceExpirer(time.Hour))
+
+			fakeClock.WaitForWatcherAndIncrement(time.Hour)
+			Consistently(fakeStore.RetrieveServiceInstancesCallCount).Should(Equal(0))
+
+			fakeClock.WaitForNWatchersAndIncrement(30*time.Minute, 2)
+			Eventually(fakeStore.RetrieveServiceInstancesCallCount).Should(Equal(1))
+		})
+
+		It("should run the jobs without windows at every interval", func() {
+			broker.SetMaintenanceWindows(NewMaintenanceWindowConfig("storage-account-migrator="+window(), "UTC"))
+			process = ifrit.Invoke(broker.InstanceExpirer(time.Hour))
+
+			fakeClock.WaitForWatcherAndIncrement(time.Hour)
+			Eventually(fakeStore.RetrieveServiceInstancesCallCount).Should(Equal(1))
+		})
+	})
+
+	Context("CleanUpStaleBindings", func() {
+		var (
+			fakeAppChecker *azurefilebrokerfakes.FakeAppChecker
+			gracePeriod    time.Duration
+			err            error
+		)
+
+		BeforeEach(func() {
+			fakeAppChecker = &azurefilebrokerfakes.FakeAppChecker{}
+			fakeAppChecker.AppExistsStub = func(appGUID string) (bool, error) {
+				return appGUID != "deleted-app", nil
+			}
+			gracePeriod = time.Hour
+			bindings := map[string]BindingDetails{
+				"stale-binding": {BindDetails: brokerapi.BindDetails{AppGUID: "deleted-app", ServiceID: "service-id", PlanID: "plan-id"}, FileShareID: "instance-share"},
+				"live-binding":  {BindDetails: brokerapi.BindDetails{AppGUID: "live-app", ServiceID: "service-id", PlanID: "plan-id"}, FileShareID: "instance-share"},
+				"preexisting":   {BindDetails: brokerapi.BindDetails{AppGUID: "deleted-app"}},
+			}
+			fakeStore.RetrieveAllBindingDetailsReturns(bindings, nil)
+			fakeStore.RetrieveBindingDetailsStub = func(id string) (BindingDetails, error) {
+				return bindings[id], nil
+			}
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+				"instance-share": {InstanceID: "instance", FileShareName: "share", Count: 2},
+			}, nil)
+			fakeStore.RetrieveFileShareReturns(FileShare{InstanceID: "instance", FileShareName: "share", Count: 2}, nil)
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{ServiceID: "service-id", PlanID: "plan-id", ProvisioningState: "succeeded"}, nil)
+		})
+
+		JustBeforeEach(func() {
+			broker.SetStaleBindingPolicy(fakeAppChecker, gracePeriod)
+			err = broker.CleanUpStaleBindings(lagertest.NewTestLogger("stale-bindings"))
+		})
+
+		It("should flag the bindings whose apps are missing until the grace period is over", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(broker.StaleBindings()).To(HaveLen(1))
+			Expect(broker.StaleBindings()).To(HaveKey("stale-binding"))
+			Expect(fakeAppChecker.AppExistsCallCount()).To(Equal(2))
+			Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(0))
+		})
+
+		It("should unflag a binding whose app is back", func() {
+			fakeAppChecker.AppExistsReturns(true, nil)
+			fakeAppChecker.AppExistsStub = nil
+			Expect(broker.CleanUpStaleBindings(lagertest.NewTestLogger("stale-bindings"))).To(Succeed())
+			Expect(broker.StaleBindings()).To(BeEmpty())
+		})
+
+		Context("when the grace period is over", func() {
+			BeforeEach(func() {
+				gracePeriod = 0
+			})
+
+			It("should unbind the binding and decrease the count of its file share", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(1))
+				Expect(fakeStore.DeleteBindingDetailsArgsForCall(0)).To(Equal("stale-binding"))
+				Expect(fakeStore.UpdateFileShareCallCount()).To(Equal(1))
+				_, share := fakeStore.UpdateFileShareArgsForCall(0)
+				Expect(share.Count).To(Equal(1))
+				Expect(broker.StaleBindings()).To(BeEmpty())
+			})
+		})
+
+		Context("when an app cannot be checked", func() {
+			BeforeEach(func() {
+				gracePeriod = 0
+				fakeAppChecker.AppExistsStub = nil
+				fakeAppChecker.AppExistsReturns(false, errors.New("cc-error"))
+			})
+
+			It("should not unbind anything", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(0))
+				Expect(broker.StaleBindings()).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("PurgeScheduledDeletions", func() {
+		var err error
+
+		JustBeforeEach(func() {
+			err = broker.PurgeScheduledDeletions(lagertest.NewTestLogger("purge"))
+		})
+
+		Context("when the retention period is not over", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveScheduledDeletionsReturns(map[string]ScheduledDeletion{
+					"storage-account-subscription-resourcegroup-account": {
+						Kind:            "storage-account",
+						ServiceInstance: ServiceInstance{TargetName: "account"},
+						DeleteAfter:     time.Now().Add(time.Hour),
+					},
+				}, nil)
+			})
+
+			It("should keep the storage account", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeStore.GetLockForUpdateCallCount()).To(Equal(0))
+				Expect(fakeStore.DeleteScheduledDeletionCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the deletion was cancelled after it was listed", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveScheduledDeletionsReturns(map[string]ScheduledDeletion{
+					"file-share-subscription-resourcegroup-account-share": {
+						Kind:            "file-share",
+						ServiceInstance: ServiceInstance{SubscriptionID: "subscription", ResourceGroupName: "resourcegroup", TargetName: "account"},
+						FileShareName:   "share",
+						DeleteAfter:     time.Now().Add(-time.Hour),
+					},
+				}, nil)
+				fakeStore.RetrieveScheduledDeletionReturns(ScheduledDeletion{}, brokerapi.ErrInstanceDoesNotExist)
+			})
+
+			It("should check it under the lock of the file share", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeStore.GetLockForUpdateCallCount()).To(Equal(1))
+				lockName, _ := fakeStore.GetLockForUpdateArgsForCall(0)
+				Expect(lockName).To(Equal("subscription-resourcegroup-account-share"))
+				Expect(fakeStore.RetrieveScheduledDeletionArgsForCall(0)).To(Equal("file-share-subscription-resourcegroup-account-share"))
+				Expect(fakeStore.DeleteScheduledDeletionCallCount()).To(Equal(0))
+				Expect(fakeStore.ReleaseLockForUpdateCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Context("secret store", func() {
+		var (
+			fakeSecrets      *azurefilebrokerfakes.FakeSecretStore
+			servicePrincipal *ServicePrincipal
+			err              error
+		)
+
+		BeforeEach(func() {
+			fakeSecrets = &azurefilebrokerfakes.FakeSecretStore{}
+			servicePrincipal = &ServicePrincipal{TenantID: "tenant", ClientID: "client", SecretName: "service-principals/instance-id"}
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				ServiceID:         "service-id",
+				PlanID:            "plan-id",
+				IsPreexisting:     true,
+				TargetName:        "//server/share",
+				ProvisioningState: "succeeded",
+				ServicePrincipal:  servicePrincipal,
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			broker.SetSecretStore(fakeSecrets)
+			_, err = broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{ServiceID: "service-id", PlanID: "plan-id"}, false)
+		})
+
+		It("should delete the secret of the service principal with the instance", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeSecrets.DeleteSecretCallCount()).To(Equal(1))
+			Expect(fakeSecrets.DeleteSecretArgsForCall(0)).To(Equal("service-principals/instance-id"))
+		})
+
+		Context("when a scheduled deletion still references the secret", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveScheduledDeletionsReturns(map[string]ScheduledDeletion{
+					"storage-account-subscription-resourcegroup-account": {
+						Kind:            "storage-account",
+						ServiceInstance: ServiceInstance{TargetName: "account", ServicePrincipal: servicePrincipal},
+						DeleteAfter:     time.Now().Add(time.Hour),
+					},
+				}, nil)
+			})
+
+			It("should keep the secret until the resources are purged", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeSecrets.DeleteSecretCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("Provision a preexisting share", func() {
+		var err error
+
+		BeforeEach(func() {
+			preexisting = NewPreexistingConfig(`//server/share, \\fileserver\Projects`)
+		})
+
+		provision := func(share string) {
+			_, err = broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+				RawParameters: json.RawMessage(fmt.Sprintf(`{"share":%q}`, share)),
+			}, false)
+		}
+
+		It("should accept a registered share and its subdirectories", func() {
+			provision("//server/share")
+			Expect(err).NotTo(HaveOccurred())
+			provision("//FileServer/projects/team-a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(2))
+			_, serviceInstance := fakeStore.CreateServiceInstanceArgsForCall(1)
+			Expect(serviceInstance.TargetName).To(Equal("//FileServer/projects/team-a"))
+		})
+
+		It("should refuse a share which is not registered", func() {
+			provision("//server/share-2")
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusForbidden))
+			Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(0))
+		})
+
+		It("should refuse a share which is not a UNC path", func() {
+			provision("server/share")
+			Expect(err).To(HaveOccurred())
+			Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(0))
+		})
+
+		It("should record the operations in the history of the instance", func() {
+			provision("//server/share")
+			Expect(err).NotTo(HaveOccurred())
+			provision("//server/share-2")
+			Expect(err).To(HaveOccurred())
+
+			Expect(fakeStore.CreateInstanceOperationCallCount()).To(Equal(2))
+			id, operation := fakeStore.CreateInstanceOperationArgsForCall(0)
+			Expect(id).To(HavePrefix("instance-id-"))
+			Expect(operation.InstanceID).To(Equal("instance-id"))
+			Expect(operation.Operation).To(Equal("provision"))
+			Expect(operation.Result).To(Equal("succeeded"))
+			_, operation = fakeStore.CreateInstanceOperationArgsForCall(1)
+			Expect(operation.Result).To(Equal("failed"))
+			Expect(operation.ErrorCode).To(Equal("ShareNotRegistered"))
+			Expect(operation.Error).To(ContainSubstring(`The share "//server/share-2" is not registered by the administrator`))
+		})
+
+		It("should return the history of the instance in the admin API", func() {
+			now := time.Now()
+			fakeStore.RetrieveInstanceOperationsReturns([]InstanceOperation{
+				{InstanceID: "instance-id", Operation: "bind", Result: "succeeded", StartedAt: now},
+				{InstanceID: "instance-id", Operation: "provision", Result: "succeeded", StartedAt: now.Add(-time.Hour)},
+			}, nil)
+			handler := broker.AdminHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("GET", "/admin/instances/instance-id/operations", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(fakeStore.RetrieveInstanceOperationsArgsForCall(0)).To(Equal("instance-id"))
+
+			var response struct {
+				Count      int                 `json:"count"`
+				Operations []InstanceOperation `json:"operations"`
+			}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Count).To(Equal(2))
+			Expect(response.Operations[0].Operation).To(Equal("provision"))
+			Expect(response.Operations[1].Operation).To(Equal("bind"))
+		})
+
+		It("should keep the provision parameters without the secrets", func() {
+			_, err = broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+				RawParameters: json.RawMessage(`{"share":"//server/share","password":"secret","mount":{"uid":"1000","sas_token":"sv=1&sig=abc"}}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			_, serviceInstance := fakeStore.CreateServiceInstanceArgsForCall(0)
+			Expect(serviceInstance.ProvisionParameters).To(MatchJSON(`{"share":"//server/share","mount":{"uid":"1000"}}`))
+		})
+
+		It("should return the provision parameters in the admin API", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{ProvisionParameters: json.RawMessage(`{"share":"//server/share"}`)}, nil)
+			handler := broker.AdminHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("GET", "/admin/instances/instance-id/parameters", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"instance_id":"instance-id","parameters":{"share":"//server/share"}}`))
+
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{}, brokerapi.ErrInstanceDoesNotExist)
+			recorder = httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should serve the admin API under the base path and report the paths with it", func() {
+			handler := BasePathHandler(NewBasePathConfig("/azurefilebroker"), broker.AdminHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"}))
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{ProvisionParameters: json.RawMessage(`{"share":"//server/share"}`)}, nil)
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("GET", "/azurefilebroker/admin/instances/instance-id/parameters", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			recorder = httptest.NewRecorder()
+			request = httptest.NewRequest("GET", "/azurefilebroker/admin/instances/instance-id", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			Expect(recorder.Body.String()).To(ContainSubstring(`Unknown path \"/azurefilebroker/admin/instances/instance-id\"`))
+		})
+
+		Context("when the admin inspects the locks", func() {
+			var (
+				handler         http.Handler
+				fakeLockBreaker *azurefilebrokerfakes.FakeLockBreaker
+			)
+
+			JustBeforeEach(func() {
+				fakeLockBreaker = &azurefilebrokerfakes.FakeLockBreaker{}
+				handler = broker.AdminHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"})
+				fakeStore.RetrieveLockHoldersReturns(map[string]LockHolder{
+					"instance-2": {Owner: "broker-2", AcquiredAt: fakeClock.Now().Add(-time.Minute)},
+					"instance-1": {Owner: "broker-1", AcquiredAt: fakeClock.Now().Add(-time.Hour)},
+				}, nil)
+				fakeStore.RetrieveLockHolderReturns(LockHolder{Owner: "broker-1", AcquiredAt: fakeClock.Now().Add(-time.Hour)}, nil)
+			})
+
+			serve := func(method, path string) *httptest.ResponseRecorder {
+				recorder := httptest.NewRecorder()
+				request := httptest.NewRequest(method, path, nil)
+				request.SetBasicAuth("admin", "secret")
+				handler.ServeHTTP(recorder, request)
+				return recorder
+			}
+
+			It("should list the lock holders and the sessions which hold the locks", func() {
+				broker.SetLockBreaker(fakeLockBreaker)
+				fakeLockBreaker.LockSessionStub = func(lockName string) (string, error) {
+					if lockName == "instance-1" {
+						return "53", nil
+					}
+					return "", nil
+				}
+				recorder := serve("GET", "/admin/locks")
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+
+				var response struct {
+					Count int          `json:"count"`
+					Locks []LockStatus `json:"locks"`
+				}
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+				Expect(response.Count).To(Equal(2))
+				Expect(response.Locks[0].Name).To(Equal("instance-1"))
+				Expect(response.Locks[0].AgeSeconds).To(Equal(int64(3600)))
+				Expect(*response.Locks[0].Held).To(BeTrue())
+				Expect(response.Locks[0].Session).To(Equal("53"))
+				Expect(*response.Locks[1].Held).To(BeFalse())
+			})
+
+			It("should list the lock holders without a lock breaker", func() {
+				recorder := serve("GET", "/admin/locks")
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(recorder.Body.String()).NotTo(ContainSubstring(`"held"`))
+			})
+
+			It("should end the session which holds the lock", func() {
+				broker.SetLockBreaker(fakeLockBreaker)
+				fakeLockBreaker.BreakLockReturns("53", nil)
+				recorder := serve("DELETE", "/admin/locks/instance-1")
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				Expect(fakeLockBreaker.BreakLockArgsForCall(0)).To(Equal("instance-1"))
+
+				var lock LockStatus
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &lock)).To(Succeed())
+				Expect(lock.Owner).To(Equal("broker-1"))
+				Expect(lock.Session).To(Equal("53"))
+			})
+
+			It("should return 404 when the lock is not held", func() {
+				broker.SetLockBreaker(fakeLockBreaker)
+				fakeLockBreaker.BreakLockReturns("", nil)
+				recorder := serve("DELETE", "/admin/locks/instance-1")
+				Expect(recorder.Code).To(Equal(http.StatusNotFound))
+				Expect(recorder.Body.String()).To(ContainSubstring(`The lock \"instance-1\" is not held`))
+			})
+
+			It("should return 501 when the lock provider cannot release the locks", func() {
+				recorder := serve("DELETE", "/admin/locks/instance-1")
+				Expect(recorder.Code).To(Equal(http.StatusNotImplemented))
+				Expect(recorder.Body.String()).To(ContainSubstring(ErrCodeLockBreakNotSupported))
+			})
+		})
+
+		Context("in a plan of the catalog file", func() {
+			JustBeforeEach(func() {
+				broker.SetCatalog(NewCatalogConfig(`{"id":"service-id","name":"smbfs","description":"SMB shares","plans":[
+					{"id":"plan-existing","name":"existing","description":"Preexisting shares","kind":"preexisting"},
+					{"id":"plan-azure","name":"azure","description":"Azure file shares","kind":"azurefileshare"}]}`))
+			})
+
+			It("should refuse a share in a plan of AzureFileShare", func() {
+				_, err = broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+					PlanID:        "plan-azure",
+					RawParameters: json.RawMessage(`{"share":"//server/share"}`),
+				}, false)
+				Expect(err).To(MatchError("The plan azure only supports AzureFileShare: the parameter share cannot be given"))
+				Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("in the plan of an isolation segment", func() {
+			BeforeEach(func() {
+				segments = NewIsolationSegmentConfig("segment1=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells")
+			})
+
+			It("should refuse a share", func() {
+				_, err = broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+					PlanID:        segments.Networks[0].PlanID(),
+					RawParameters: json.RawMessage(`{"share":"//server/share"}`),
+				}, false)
+				Expect(err).To(MatchError("The plan AzureFileShare-segment1 only supports AzureFileShare: the parameter share cannot be given"))
+				Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(0))
+			})
+		})
+	})
+
+	Context("AdmissionControl", func() {
+		var (
+			handler     http.Handler
+			release     chan struct{}
+			fakeMetrics *azurefilebrokerfakes.FakeMetrics
+			maxQueued   int
+		)
+
+		BeforeEach(func() {
+			maxQueued = 0
+		})
+
+		JustBeforeEach(func() {
+			release = make(chan struct{})
+			fakeMetrics = &azurefilebrokerfakes.FakeMetrics{}
+			broker.SetMetrics(fakeMetrics)
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPut {
+					<-release
+				}
+				w.WriteHeader(http.StatusCreated)
+			})
+			handler = broker.AdmissionControl(NewAdmissionConfig(1, maxQueued, time.Minute, 5*time.Second), next)
+		})
+
+		inFlight := func() float64 {
+			value := 0.0
+			for i := 0; i < fakeMetrics.SetGaugeCallCount(); i++ {
+				if name, _, v := fakeMetrics.SetGaugeArgsForCall(i); name == "azurefilebroker_admission_in_flight" {
+					value = v
+				}
+			}
+			return value
+		}
+
+		serve := func(method, path string) chan *httptest.ResponseRecorder {
+			done := make(chan *httptest.ResponseRecorder, 1)
+			go func() {
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+				done <- recorder
+			}()
+			return done
+		}
+
+		It("should reject the provisions and the binds above the limit at once without a queue", func() {
+			provision := serve("PUT", "/v2/service_instances/instance-1")
+			Eventually(inFlight).Should(Equal(1.0))
+
+			var recorder *httptest.ResponseRecorder
+			Eventually(serve("PUT", "/v2/service_instances/instance-1/service_bindings/binding-1")).Should(Receive(&recorder))
+			Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(recorder.Header().Get("Retry-After")).To(Equal("5"))
+			name, labels := fakeMetrics.IncrementCounterArgsForCall(0)
+			Expect(name).To(Equal("azurefilebroker_admission_rejected_total"))
+			Expect(labels).To(Equal(map[string]string{"operation": "bind", "reason": "queue-full"}))
+
+			Eventually(serve("GET", "/v2/service_instances/instance-1/last_operation")).Should(Receive(&recorder))
+			Expect(recorder.Code).To(Equal(http.StatusCreated))
+
+			close(release)
+			Eventually(provision).Should(Receive(&recorder))
+			Expect(recorder.Code).To(Equal(http.StatusCreated))
+		})
+
+		Context("with a queue", func() {
+			BeforeEach(func() {
+				maxQueued = 1
+			})
+
+			It("should serve a queued request when a slot is free", func() {
+				first := serve("PUT", "/v2/service_instances/instance-1")
+				Eventually(inFlight).Should(Equal(1.0))
+				second := serve("PUT", "/v2/service_instances/instance-2")
+				Eventually(fakeClock.WatcherCount).Should(Equal(1))
+				Consistently(second).ShouldNot(Receive())
+
+				close(release)
+				var recorder *httptest.ResponseRecorder
+				Eventually(first).Should(Receive(&recorder))
+				Eventually(second).Should(Receive(&recorder))
+				Expect(recorder.Code).To(Equal(http.StatusCreated))
+			})
+
+			It("should reject a queued request after the queue timeout", func() {
+				first := serve("PUT", "/v2/service_instances/instance-1")
+				Eventually(inFlight).Should(Equal(1.0))
+				second := serve("PUT", "/v2/service_instances/instance-2")
+				Eventually(fakeClock.WatcherCount).Should(Equal(1))
+
+				fakeClock.Increment(time.Minute)
+				var recorder *httptest.ResponseRecorder
+				Eventually(second).Should(Receive(&recorder))
+				Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+
+				close(release)
+				Eventually(first).Should(Receive())
+			})
+		})
+	})
+
+	Context("AuthFailureGuard", func() {
+		var (
+			handler        http.Handler
+			nextCalls      int
+			fakeMetrics    *azurefilebrokerfakes.FakeMetrics
+			trustedProxies string
+		)
+
+		BeforeEach(func() {
+			trustedProxies = "192.0.2.0/24"
+		})
+
+		JustBeforeEach(func() {
+			nextCalls = 0
+			fakeMetrics = &azurefilebrokerfakes.FakeMetrics{}
+			broker.SetMetrics(fakeMetrics)
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalls++
+				w.WriteHeader(http.StatusUnauthorized)
+			})
+			handler = broker.AuthFailureGuard(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"}, NewAuthFailureConfig(2, time.Minute, time.Hour, trustedProxies), next)
+		})
+
+		// serve sends the request through the proxy 192.0.2.1, which is the address of the connection of httptest
+		serve := func(source, password string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("GET", "/v2/catalog", nil)
+			request.Header.Set("X-Forwarded-For", "10.0.0.1, "+source)
+			request.SetBasicAuth("admin", password)
+			handler.ServeHTTP(recorder, request)
+			return recorder
+		}
+
+		It("should lock out a source after the threshold of consecutive failures", func() {
+			Expect(serve("198.51.100.1", "wrong").Code).To(Equal(http.StatusUnauthorized))
+			Expect(serve("198.51.100.1", "wrong").Code).To(Equal(http.StatusUnauthorized))
+			Expect(fakeMetrics.IncrementCounterCallCount()).To(Equal(3))
+			name, _ := fakeMetrics.IncrementCounterArgsForCall(2)
+			Expect(name).To(Equal("azurefilebroker_auth_lockouts_total"))
+
+			recorder := serve("198.51.100.1", "secret")
+			Expect(recorder.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(recorder.Header().Get("Retry-After")).To(Equal("60"))
+			Expect(nextCalls).To(Equal(2))
+
+			Expect(serve("198.51.100.2", "secret").Code).To(Equal(http.StatusUnauthorized))
+			Expect(nextCalls).To(Equal(3))
+		})
+
+		It("should start over after a successful authentication", func() {
+			serve("198.51.100.1", "wrong")
+			serve("198.51.100.1", "secret")
+			serve("198.51.100.1", "wrong")
+			Expect(serve("198.51.100.1", "secret").Code).To(Equal(http.StatusUnauthorized))
+			Expect(nextCalls).To(Equal(4))
+		})
+
+		It("should use the last address of X-Forwarded-For which is not a trusted proxy", func() {
+			serve("198.51.100.1, 192.0.2.7", "wrong")
+			Expect(serve("198.51.100.1", "wrong").Code).To(Equal(http.StatusUnauthorized))
+			Expect(serve("198.51.100.1", "secret").Code).To(Equal(http.StatusTooManyRequests))
+		})
+
+		Context("when the request does not come from a trusted proxy", func() {
+			BeforeEach(func() {
+				trustedProxies = "203.0.113.1"
+			})
+
+			It("should ignore a forged X-Forwarded-For and lock out the address of the connection", func() {
+				serve("198.51.100.1", "wrong")
+				serve("198.51.100.2", "wrong")
+				Expect(serve("198.51.100.3", "secret").Code).To(Equal(http.StatusTooManyRequests))
+				Expect(nextCalls).To(Equal(2))
+			})
+		})
+	})
+
+	Context("operation timeouts", func() {
+		var unblock chan struct{}
+
+		BeforeEach(func() {
+			timeouts.Bind = 10 * time.Millisecond
+			timeouts.Deprovision = 10 * time.Millisecond
+			timeouts.Update = 10 * time.Millisecond
+			timeouts.Provision = 10 * time.Millisecond
+			unblock = make(chan struct{})
+			fakeStore.RetrieveServiceInstanceStub = func(string) (ServiceInstance, error) {
+				<-unblock
+				return ServiceInstance{}, errors.New("not found")
+			}
+		})
+
+		AfterEach(func() {
+			close(unblock)
+		})
+
+		It("should return an error when the operation does not finish in time", func() {
+			_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusGatewayTimeout))
+			Expect(ErrorCode(err)).To(Equal(ErrCodeOperationTimedOut))
+		})
+
+		It("should return an error when the context is done", func() {
+			timeouts.Bind = 0
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err := broker.Bind(cancelled, "instance-id", "binding-id", brokerapi.BindDetails{AppGUID: "app-guid"})
+			Expect(ErrorCode(err)).To(Equal(ErrCodeOperationTimedOut))
+		})
+
+		It("should bound the update with its timeout", func() {
+			_, err := broker.Update(ctx, "instance-id", brokerapi.UpdateDetails{}, false)
+			Expect(ErrorCode(err)).To(Equal(ErrCodeOperationTimedOut))
+		})
+
+		It("should still write to the store after the operation timed out", func() {
+			fakeStore.RetrieveServiceInstanceStub = func(string) (ServiceInstance, error) {
+				<-unblock
+				return ServiceInstance{IsPreexisting: true, TargetName: "//server/share"}, nil
+			}
+			_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, false)
+			Expect(ErrorCode(err)).To(Equal(ErrCodeOperationTimedOut))
+			Expect(fakeStore.DeleteServiceInstanceCallCount()).To(Equal(0))
+
+			unblock <- struct{}{}
+			Eventually(fakeStore.DeleteServiceInstanceCallCount).Should(Equal(1))
+		})
+
+		It("should not queue the operations behind an operation which is stuck", func() {
+			for i := 0; i < 2; i++ {
+				_, err := broker.Bind(ctx, "instance-id", fmt.Sprintf("binding-%d", i), brokerapi.BindDetails{AppGUID: "app-guid"})
+				Expect(err).To(MatchError(ContainSubstring("did not finish")))
+			}
+
+			unblock <- struct{}{}
+			Consistently(fakeStore.RetrieveServiceInstanceCallCount, 50*time.Millisecond).Should(Equal(1))
+		})
+
+		It("should refuse the operations while too many operations which timed out still run", func() {
+			fakeWebhook := &azurefilebrokerfakes.FakeValidationWebhook{}
+			fakeWebhook.ValidateStub = func(ValidationRequest) (ValidationResponse, error) {
+				<-unblock
+				return ValidationResponse{Allowed: true}, nil
+			}
+			broker.SetValidationWebhook(fakeWebhook)
+
+			for i := 0; i < 16; i++ {
+				_, err := broker.Provision(ctx, fmt.Sprintf("instance-%d", i), brokerapi.ProvisionDetails{}, false)
+				Expect(err).To(MatchError(ContainSubstring("did not finish")))
+			}
+			_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{}, false)
+			Expect(ErrorCode(err)).To(Equal(ErrCodeOperationTimedOut))
+			Expect(err).To(MatchError(ContainSubstring("16 operations which timed out are still running")))
+		})
+	})
+
+	Context("context propagation", func() {
+		BeforeEach(func() {
+			timeouts.Bind = 10 * time.Millisecond
+		})
+
+		It("should still record the binding of an operation which timed out so that the retry of the platform finds it", func() {
+			unblock := make(chan struct{})
+			fakeStore.RetrieveServiceInstanceStub = func(string) (ServiceInstance, error) {
+				<-unblock
+				return ServiceInstance{IsPreexisting: true, TargetName: "//server/share"}, nil
+			}
+
+			_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{
+				AppGUID:       "app-guid",
+				RawParameters: json.RawMessage(`{"username":"user","password":"secret"}`),
+			})
+			Expect(ErrorCode(err)).To(Equal(ErrCodeOperationTimedOut))
+
+			close(unblock)
+			Eventually(fakeStore.CreateBindingDetailsCallCount).Should(Equal(1))
+			id, _ := fakeStore.CreateBindingDetailsArgsForCall(0)
+			Expect(id).To(Equal("binding-id"))
+		})
+
+		It("should not call the store with a cancelled context", func() {
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err := broker.Provision(cancelled, "instance-id", brokerapi.ProvisionDetails{
+				RawParameters: json.RawMessage(`{"share":"//server/share"}`),
+			}, false)
+			Expect(ErrorCode(err)).To(Equal(ErrCodeOperationTimedOut))
+			Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("backup protection", func() {
+		It("should refuse a backup vault for a preexisting share", func() {
+			_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+				RawParameters: json.RawMessage(`{"share":"//server/share","backup_policy":"daily"}`),
+			}, false)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("the parameters backup_vault_id and backup_policy cannot be given"))
+			Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(0))
+		})
+
+		It("should skip the file shares which are not protected or already protected", func() {
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+				"instance-id-share-a": {InstanceID: "instance-id", FileShareName: "share-a", Count: 1},
+				"instance-id-share-b": {InstanceID: "instance-id", FileShareName: "share-b", Count: 1, BackupState: "protected"},
+			}, nil)
+			Expect(broker.ProtectFileShares(lagertest.NewTestLogger("protect-file-shares"))).To(Succeed())
+			Expect(fakeStore.GetLockForUpdateCallCount()).To(Equal(0))
+			Expect(fakeStore.UpdateFileShareCallCount()).To(Equal(0))
+		})
+
+		It("should leave a file share whose instance has no backup target unchanged", func() {
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+				"instance-id-share-a": {InstanceID: "instance-id", FileShareName: "share-a", Count: 1, BackupState: "refresh-containers"},
+			}, nil)
+			fakeStore.RetrieveFileShareReturns(FileShare{InstanceID: "instance-id", FileShareName: "share-a", Count: 1, BackupState: "refresh-containers"}, nil)
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{TargetName: "account"}, nil)
+			Expect(broker.ProtectFileShares(lagertest.NewTestLogger("protect-file-shares"))).To(Succeed())
+			lockName, _ := fakeStore.GetLockForUpdateArgsForCall(0)
+			Expect(lockName).To(Equal("instance-id-share-a"))
+			Expect(fakeStore.ReleaseLockForUpdateCallCount()).To(Equal(1))
+			Expect(fakeStore.UpdateFileShareCallCount()).To(Equal(0))
+		})
+
+		It("should not protect a file share which was unbound", func() {
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+				"instance-id-share-a": {InstanceID: "instance-id", FileShareName: "share-a", Count: 1, BackupState: "register-container"},
+			}, nil)
+			fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+			Expect(broker.ProtectFileShares(lagertest.NewTestLogger("protect-file-shares"))).To(Succeed())
+			Expect(fakeStore.RetrieveServiceInstanceCallCount()).To(Equal(0))
+			Expect(fakeStore.UpdateFileShareCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("share stats", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+				"instance-id-share-b": {InstanceID: "instance-id", FileShareName: "share-b", Count: 1},
+				"instance-id-share-a": {InstanceID: "instance-id", FileShareName: "share-a", Count: 2, Stats: &ShareStats{UsageBytes: 3 << 30, QuotaGiB: 5120}},
+				"other-id-share-a":    {InstanceID: "other-id", FileShareName: "share-a", Count: 1},
+			}, nil)
+		})
+
+		It("should store the stats of a file share under its lock", func() {
+			fakeStore.RetrieveFileShareReturns(FileShare{InstanceID: "instance-id", FileShareName: "share-a", Count: 2}, nil)
+			err := broker.RecordShareStats("instance-id-share-a", ShareStats{UsageBytes: 42, QuotaGiB: 100})
+			Expect(err).NotTo(HaveOccurred())
+			lockName, _ := fakeStore.GetLockForUpdateArgsForCall(0)
+			Expect(lockName).To(Equal("instance-id-share-a"))
+			id, share := fakeStore.UpdateFileShareArgsForCall(0)
+			Expect(id).To(Equal("instance-id-share-a"))
+			Expect(share.Count).To(Equal(2))
+			Expect(*share.Stats).To(Equal(ShareStats{UsageBytes: 42, QuotaGiB: 100}))
+			Expect(fakeStore.ReleaseLockForUpdateCallCount()).To(Equal(1))
+		})
+
+		It("should not store the stats of a file share which was unbound", func() {
+			fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+			Expect(broker.RecordShareStats("instance-id-share-a", ShareStats{})).To(Succeed())
+			Expect(fakeStore.UpdateFileShareCallCount()).To(Equal(0))
+		})
+
+		It("should summarize the usage in the last operation", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{ProvisioningState: "succeeded"}, nil)
+			lastOperation, err := broker.LastOperation(ctx, "instance-id", "operation-url")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lastOperation.Description).To(Equal("2 file share(s) in use, 3.00 GiB used by 1 of them"))
+		})
+
+		Context("admin API", func() {
+			var (
+				handler  http.Handler
+				recorder *httptest.ResponseRecorder
+			)
+
+			JustBeforeEach(func() {
+				handler = broker.AdminHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"})
+				recorder = httptest.NewRecorder()
+			})
+
+			It("should return the stats of the file shares of the instance", func() {
+				request := httptest.NewRequest("GET", "/admin/instances/instance-id/share-stats", nil)
+				request.SetBasicAuth("admin", "secret")
+				handler.ServeHTTP(recorder, request)
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+
+				var response struct {
+					InstanceID string           `json:"instance_id"`
+					FileShares []FileShareStats `json:"file_shares"`
+				}
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+				Expect(response.InstanceID).To(Equal("instance-id"))
+				Expect(response.FileShares).To(HaveLen(2))
+				Expect(response.FileShares[0].FileShareName).To(Equal("share-a"))
+				Expect(response.FileShares[0].Stats.UsageBytes).To(Equal(int64(3 << 30)))
+				Expect(response.FileShares[1].Stats).To(BeNil())
+			})
+
+			It("should refuse a request without the credentials", func() {
+				request := httptest.NewRequest("GET", "/admin/instances/instance-id/share-stats", nil)
+				request.SetBasicAuth("admin", "wrong")
+				handler.ServeHTTP(recorder, request)
+				Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			})
+
+			It("should return not found for an unknown path", func() {
+				request := httptest.NewRequest("GET", "/admin/instances/instance-id/unknown", nil)
+				request.SetBasicAuth("admin", "secret")
+				handler.ServeHTTP(recorder, request)
+				Expect(recorder.Code).To(Equal(http.StatusNotFound))
+				Expect(recorder.Body.String()).To(ContainSubstring(`"error":"ResourceNotFound"`))
+			})
+		})
+	})
+
+	Context("audit events", func() {
+		var fakeEmitter *azurefilebrokerfakes.FakeAuditEventEmitter
+
+		BeforeEach(func() {
+			fakeEmitter = &azurefilebrokerfakes.FakeAuditEventEmitter{}
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				ServiceID:         "service-id",
+				PlanID:            "plan-id",
+				OrganizationGUID:  "org-guid",
+				SpaceGUID:         "space-guid",
+				IsPreexisting:     true,
+				TargetName:        "//server/share",
+				ProvisioningState: "succeeded",
+			}, nil)
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, errors.New("not found"))
+		})
+
+		JustBeforeEach(func() {
+			broker.SetAuditEventEmitter(fakeEmitter)
+		})
+
+		It("should emit an event when a binding is created", func() {
+			_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{
+				AppGUID:       "app-guid",
+				PlanID:        "plan-id",
+				ServiceID:     "service-id",
+				RawParameters: json.RawMessage(`{"username":"user","password":"secret"}`),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeEmitter.EmitCallCount()).To(Equal(1))
+			event := fakeEmitter.EmitArgsForCall(0)
+			Expect(event.Type).To(Equal(AuditEventServiceBindingCreate))
+			Expect(event.ServiceInstanceID).To(Equal("instance-id"))
+			Expect(event.BindingID).To(Equal("binding-id"))
+			Expect(event.OrganizationGUID).To(Equal("org-guid"))
+			Expect(event.SpaceGUID).To(Equal("space-guid"))
+			Expect(event.AppGUID).To(Equal("app-guid"))
+			Expect(event.ResourceIDs).To(BeEmpty())
+			Expect(event.Timestamp.IsZero()).To(BeFalse())
+		})
+
+		It("should emit an event when an instance is deprovisioned", func() {
+			_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeEmitter.EmitCallCount()).To(Equal(1))
+			event := fakeEmitter.EmitArgsForCall(0)
+			Expect(event.Type).To(Equal(AuditEventServiceInstanceDelete))
+			Expect(event.OrganizationGUID).To(Equal("org-guid"))
+		})
+
+		It("should not emit an event when the operation fails", func() {
+			fakeStore.DeleteServiceInstanceReturns(errors.New("badness"))
+			_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(err).To(HaveOccurred())
+			Expect(fakeEmitter.EmitCallCount()).To(Equal(0))
+		})
+
+		It("should not fail the operation when the event cannot be emitted", func() {
+			fakeEmitter.EmitReturns(errors.New("unreachable"))
+			_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+				OrganizationGUID: "org-guid",
+				SpaceGUID:        "space-guid",
+				RawParameters:    json.RawMessage(`{"share":"//server/share"}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeEmitter.EmitArgsForCall(0).Type).To(Equal(AuditEventServiceInstanceCreate))
+		})
+	})
+
+	Context("lifecycle webhooks", func() {
+		var fakeWebhook *azurefilebrokerfakes.FakeLifecycleWebhook
+
+		BeforeEach(func() {
+			fakeWebhook = &azurefilebrokerfakes.FakeLifecycleWebhook{}
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				OrganizationGUID:  "org-guid",
+				SpaceGUID:         "space-guid",
+				IsPreexisting:     true,
+				TargetName:        "//server/share",
+				ProvisioningState: "succeeded",
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			broker.SetLifecycleWebhook(fakeWebhook)
+		})
+
+		It("should send an event when a binding is created", func() {
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, errors.New("not found"))
+			_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{
+				AppGUID:       "app-guid",
+				RawParameters: json.RawMessage(`{"username":"user","password":"secret"}`),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeWebhook.SendCallCount()).To(Equal(1))
+			event := fakeWebhook.SendArgsForCall(0)
+			Expect(event.Type).To(Equal(LifecycleEventBindingCreate))
+			Expect(event.ID).NotTo(BeEmpty())
+			Expect(event.ServiceInstanceID).To(Equal("instance-id"))
+			Expect(event.BindingID).To(Equal("binding-id"))
+			Expect(event.AppGUID).To(Equal("app-guid"))
+			Expect(event.SpaceGUID).To(Equal("space-guid"))
+			Expect(event.StorageAccountName).To(BeEmpty())
+		})
+
+		It("should send an event when a binding is deleted and not fail when the webhook fails", func() {
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{BindDetails: brokerapi.BindDetails{AppGUID: "app-guid"}}, nil)
+			fakeWebhook.SendReturns(errors.New("unreachable"))
+			err := broker.Unbind(ctx, "instance-id", "binding-id", brokerapi.UnbindDetails{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeWebhook.SendCallCount()).To(Equal(1))
+			Expect(fakeWebhook.SendArgsForCall(0).Type).To(Equal(LifecycleEventBindingDelete))
+			Expect(fakeWebhook.SendArgsForCall(0).AppGUID).To(Equal("app-guid"))
+		})
+
+		It("should sign the events with the HMAC-SHA256 of the secret", func() {
+			Expect(SignLifecycleEvent("key", []byte("The quick brown fox jumps over the lazy dog"))).To(Equal("sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"))
+			Expect(ParseLifecycleWebhookURLs(" https://a.example.com, ,https://b.example.com")).To(Equal([]string{"https://a.example.com", "https://b.example.com"}))
+		})
+	})
+
+	Context("RunSmokeTest", func() {
+		var (
+			smokeTest *SmokeTest
+			report    SmokeTestReport
+		)
+
+		BeforeEach(func() {
+			smokeTest = &SmokeTest{
+				InstanceID:          "smoke-test-instance",
+				BindingID:           "smoke-test-binding",
+				PlanID:              "plan-id",
+				ProvisionParameters: json.RawMessage(`{"share":"//server/share"}`),
+				BindParameters:      json.RawMessage(`{"username":"user","password":"secret"}`),
+			}
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				ServiceID:         "service-id",
+				PlanID:            "plan-id",
+				IsPreexisting:     true,
+				TargetName:        "//server/share",
+				ProvisioningState: "succeeded",
+			}, nil)
+			fakeStore.RetrieveBindingDetailsReturnsOnCall(0, BindingDetails{}, errors.New("not found"))
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, nil)
+		})
+
+		JustBeforeEach(func() {
+			report = broker.RunSmokeTest(smokeTest)
+		})
+
+		It("should pass every step of the lifecycle", func() {
+			Expect(report.Passed).To(BeTrue())
+			Expect(report.Steps).To(HaveLen(4))
+			for i, name := range []string{"provision", "bind", "unbind", "deprovision"} {
+				Expect(report.Steps[i].Name).To(Equal(name))
+				Expect(report.Steps[i].Passed).To(BeTrue())
+			}
+			Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(1))
+			Expect(fakeStore.DeleteBindingDetailsArgsForCall(0)).To(Equal("smoke-test-binding"))
+			Expect(fakeStore.DeleteServiceInstanceArgsForCall(0)).To(Equal("smoke-test-instance"))
+		})
+
+		Context("when the bind fails", func() {
+			BeforeEach(func() {
+				fakeStore.CreateBindingDetailsReturns(errors.New("badness"))
+			})
+
+			It("should fail and still deprovision the instance", func() {
+				Expect(report.Passed).To(BeFalse())
+				Expect(report.Steps).To(HaveLen(3))
+				Expect(report.Steps[1].Name).To(Equal("bind"))
+				Expect(report.Steps[1].Passed).To(BeFalse())
+				Expect(report.Steps[1].Error).NotTo(BeEmpty())
+				Expect(report.Steps[2].Name).To(Equal("deprovision"))
+				Expect(report.Steps[2].Passed).To(BeTrue())
+				Expect(fakeStore.DeleteServiceInstanceCallCount()).To(Equal(1))
+			})
+		})
+	})
+
+	Context("AuditPermissions", func() {
+		var (
+			fakeRESTClient *azurefilebrokerfakes.FakeAzureStorageAccountRESTClient
+			missing        []string
+			err            error
+		)
+
+		BeforeEach(func() {
+			fakeRESTClient = &azurefilebrokerfakes.FakeAzureStorageAccountRESTClient{}
+			control.AllowCreateStorageAccount = true
+		})
+
+		JustBeforeEach(func() {
+			missing, err = broker.AuditPermissions(fakeRESTClient)
+		})
+
+		Context("when the service principal is a contributor", func() {
+			BeforeEach(func() {
+				fakeRESTClient.ListPermissionsReturns([]Permission{{Actions: []string{"*"}, NotActions: []string{"Microsoft.Authorization/*/Write"}}}, nil)
+			})
+
+			It("should report no missing permission", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(missing).To(BeEmpty())
+			})
+		})
+
+		Context("when the service principal can only read", func() {
+			BeforeEach(func() {
+				fakeRESTClient.ListPermissionsReturns([]Permission{{Actions: []string{"*/read"}}}, nil)
+			})
+
+			It("should report the missing actions", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(missing).To(Equal([]string{
+					"Microsoft.Storage/storageAccounts/listkeys/action",
+					"Microsoft.Storage/storageAccounts/write",
+				}))
+			})
+		})
+
+		Context("when an action is excluded", func() {
+			BeforeEach(func() {
+				fakeRESTClient.ListPermissionsReturns([]Permission{{Actions: []string{"Microsoft.Storage/*"}, NotActions: []string{"microsoft.storage/storageaccounts/listkeys/action"}}}, nil)
+			})
+
+			It("should report it as missing", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(missing).To(Equal([]string{"Microsoft.Storage/storageAccounts/listkeys/action"}))
+			})
+		})
+
+		Context("when the permissions cannot be listed", func() {
+			BeforeEach(func() {
+				fakeRESTClient.ListPermissionsReturns(nil, errors.New("forbidden"))
+			})
+
+			It("should return the error", func() {
+				Expect(err).To(MatchError("forbidden"))
+			})
+		})
+	})
+
+	Context("read replica", func() {
+		var replica *azurefilebrokerfakes.FakeStore
+
+		BeforeEach(func() {
+			replica = &azurefilebrokerfakes.FakeStore{}
+			replica.RetrieveFeatureFlagsReturns(map[string]FeatureFlag{FeatureFlagAllowCreateFileShare: {Enabled: true}}, nil)
+		})
+
+		JustBeforeEach(func() {
+			broker.SetReadReplica(replica)
+		})
+
+		It("should read the feature flags from the replica", func() {
+			flags, err := broker.FeatureFlags()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(flags[0].Overridden).To(BeTrue())
+			Expect(replica.RetrieveFeatureFlagsCallCount()).To(Equal(1))
+			Expect(fakeStore.RetrieveFeatureFlagsCallCount()).To(Equal(0))
+		})
+
+		It("should read from the primary before a write", func() {
+			Expect(broker.SetFeatureFlag(FeatureFlagAllowCreateFileShare, false)).To(Succeed())
+			Expect(fakeStore.RetrieveFeatureFlagsCallCount()).To(Equal(1))
+			Expect(fakeStore.UpdateFeatureFlagCallCount() + fakeStore.CreateFeatureFlagCallCount()).To(Equal(1))
+			Expect(replica.RetrieveFeatureFlagsCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("feature flags", func() {
+		var (
+			handler  http.Handler
+			recorder *httptest.ResponseRecorder
+		)
+
+		BeforeEach(func() {
+			control.AllowCreateFileShare = true
+			fakeStore.RetrieveFeatureFlagsReturns(map[string]FeatureFlag{
+				FeatureFlagAllowCreateStorageAccount: {Enabled: true},
+				FeatureFlagAllowCreateFileShare:      {Enabled: false},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			handler = broker.AdminHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"})
+			recorder = httptest.NewRecorder()
+		})
+
+		It("should override the control config with the flags in the store", func() {
+			flags, err := broker.FeatureFlags()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(flags).To(HaveLen(5))
+			Expect(flags[0].Name).To(Equal(FeatureFlagAllowCreateFileShare))
+			Expect(flags[0].Enabled).To(BeFalse())
+			Expect(flags[0].Default).To(BeTrue())
+			Expect(flags[0].Overridden).To(BeTrue())
+			Expect(flags[1].Name).To(Equal(FeatureFlagAllowCreateStorageAccount))
+			Expect(flags[1].Enabled).To(BeTrue())
+			Expect(flags[2].Name).To(Equal(FeatureFlagAllowDeleteFileShare))
+			Expect(flags[2].Overridden).To(BeFalse())
+		})
+
+		It("should list the feature flags", func() {
+			request := httptest.NewRequest("GET", "/admin/feature-flags", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var response struct {
+				FeatureFlags []FeatureFlagStatus `json:"feature_flags"`
+			}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.FeatureFlags).To(HaveLen(5))
+		})
+
+		It("should update a flag which is set", func() {
+			request := httptest.NewRequest("PUT", "/admin/feature-flags/allow_create_file_share", strings.NewReader(`{"enabled": true}`))
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(fakeStore.GetLockForUpdateCallCount()).To(Equal(1))
+			Expect(fakeStore.CreateFeatureFlagCallCount()).To(Equal(0))
+			Expect(fakeStore.UpdateFeatureFlagCallCount()).To(Equal(1))
+			name, flag := fakeStore.UpdateFeatureFlagArgsForCall(0)
+			Expect(name).To(Equal(FeatureFlagAllowCreateFileShare))
+			Expect(flag.Enabled).To(BeTrue())
+		})
+
+		It("should create a flag which is not set", func() {
+			request := httptest.NewRequest("PUT", "/admin/feature-flags/allow_delete_file_share", strings.NewReader(`{"enabled": false}`))
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(fakeStore.CreateFeatureFlagCallCount()).To(Equal(1))
+			name, flag := fakeStore.CreateFeatureFlagArgsForCall(0)
+			Expect(name).To(Equal(FeatureFlagAllowDeleteFileShare))
+			Expect(flag.Enabled).To(BeFalse())
+		})
+
+		It("should refuse a body without enabled", func() {
+			request := httptest.NewRequest("PUT", "/admin/feature-flags/allow_delete_file_share", strings.NewReader(`{}`))
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			Expect(fakeStore.CreateFeatureFlagCallCount()).To(Equal(0))
+		})
+
+		It("should return not found for an unknown flag", func() {
+			request := httptest.NewRequest("PUT", "/admin/feature-flags/unknown", strings.NewReader(`{"enabled": true}`))
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should reset a flag", func() {
+			request := httptest.NewRequest("DELETE", "/admin/feature-flags/allow_create_file_share", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(fakeStore.DeleteFeatureFlagCallCount()).To(Equal(1))
+			Expect(fakeStore.DeleteFeatureFlagArgsForCall(0)).To(Equal(FeatureFlagAllowCreateFileShare))
+		})
+	})
+
+	Context("lock contention", func() {
+		var (
+			handler  http.Handler
+			recorder *httptest.ResponseRecorder
+			request  *http.Request
+		)
+
+		JustBeforeEach(func() {
+			handler = broker.AdminHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"})
+			recorder = httptest.NewRecorder()
+			request = httptest.NewRequest("PUT", "/admin/feature-flags/allow_create_file_share", strings.NewReader(`{"enabled": true}`))
+			request.SetBasicAuth("admin", "secret")
+		})
+
+		It("should wait for the lock up to the default timeout and record the holder", func() {
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(fakeStore.GetLockForUpdateCallCount()).To(Equal(1))
+			lockName, timeout := fakeStore.GetLockForUpdateArgsForCall(0)
+			Expect(lockName).To(Equal("feature-flags"))
+			Expect(timeout).To(Equal(30))
+			Expect(fakeStore.UpdateLockHolderCallCount()).To(Equal(1))
+			lockName, holder := fakeStore.UpdateLockHolderArgsForCall(0)
+			Expect(lockName).To(Equal("feature-flags"))
+			Expect(holder.Owner).NotTo(BeEmpty())
+		})
+
+		It("should create the holder of a lock which was never taken", func() {
+			fakeStore.UpdateLockHolderReturns(errors.New("not found"))
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(fakeStore.CreateLockHolderCallCount()).To(Equal(1))
+		})
+
+		Context("when the lock timeout is configured", func() {
+			BeforeEach(func() {
+				timeouts = NewTimeoutConfig(0, 0, 0, 0, 0, 0, 5*time.Second, 0)
+			})
+
+			It("should wait for the lock up to the timeout", func() {
+				handler.ServeHTTP(recorder, request)
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				_, timeout := fakeStore.GetLockForUpdateArgsForCall(0)
+				Expect(timeout).To(Equal(5))
+			})
+		})
+
+		Context("when the lock is held by another broker", func() {
+			BeforeEach(func() {
+				fakeStore.GetLockForUpdateReturns(errors.New("Failed to get the lock"))
+				fakeStore.RetrieveLockHolderReturns(LockHolder{Owner: "other-broker", AcquiredAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}, nil)
+			})
+
+			It("should return the holder of the lock in the error", func() {
+				handler.ServeHTTP(recorder, request)
+				Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+				Expect(recorder.Body.String()).To(ContainSubstring("The lock was taken by other-broker at 2026-01-02T03:04:05Z"))
+				Expect(fakeStore.UpdateFeatureFlagCallCount()).To(Equal(0))
+			})
+
+			It("should count the lock timeouts in the metrics", func() {
+				fakeMetrics := &azurefilebrokerfakes.FakeMetrics{}
+				broker.SetMetrics(fakeMetrics)
+				handler.ServeHTTP(recorder, request)
+				Expect(fakeMetrics.IncrementCounterCallCount()).To(BeNumerically(">", 0))
+				found := false
+				for i := 0; i < fakeMetrics.IncrementCounterCallCount(); i++ {
+					name, labels := fakeMetrics.IncrementCounterArgsForCall(i)
+					if name == "azurefilebroker_lock_timeouts_total" {
+						Expect(labels).To(Equal(map[string]string{"lock": "feature-flags"}))
+						found = true
+					}
+				}
+				Expect(found).To(BeTrue())
+			})
+		})
+	})
+
+	Context("catalog cache", func() {
+		var (
+			handler  http.Handler
+			nextHits int
+		)
+
+		JustBeforeEach(func() {
+			nextHits = 0
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextHits++
+				w.WriteHeader(http.StatusTeapot)
+			})
+			handler = broker.CatalogHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"}, next)
+		})
+
+		serve := func(path, ifNoneMatch, password string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("GET", path, nil)
+			request.SetBasicAuth("admin", password)
+			request.Header.Set(BrokerAPIVersionHeader, "2.14")
+			if ifNoneMatch != "" {
+				request.Header.Set("If-None-Match", ifNoneMatch)
+			}
+			handler.ServeHTTP(recorder, request)
+			return recorder
+		}
+
+		It("should serve the catalog with an ETag", func() {
+			recorder := serve("/v2/catalog", "", "secret")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("ETag")).NotTo(BeEmpty())
+
+			var response struct {
+				Services []brokerapi.Service `json:"services"`
+			}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Services).To(HaveLen(1))
+			Expect(response.Services[0].ID).To(Equal("service-id"))
+		})
+
+		It("should serve the service and the plans of the catalog file", func() {
+			broker.SetCatalog(NewCatalogConfig(`
+id: catalog-service-id
+name: smbfs
+description: SMB shares
+tags: [smb]
+metadata:
+  displayName: SMB
+plans:
+- id: plan-1
+  name: existing
+  description: Preexisting shares
+  kind: preexisting
+`))
+			var response struct {
+				Services []brokerapi.Service `json:"services"`
+			}
+			Expect(json.Unmarshal(serve("/v2/catalog", "", "secret").Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Services).To(HaveLen(1))
+			service := response.Services[0]
+			Expect(service.ID).To(Equal("catalog-service-id"))
+			Expect(service.Name).To(Equal("smbfs"))
+			Expect(service.Description).To(Equal("SMB shares"))
+			Expect(service.Tags).To(Equal([]string{"smb"}))
+			Expect(service.Metadata.DisplayName).To(Equal("SMB"))
+			Expect(service.Plans).To(HaveLen(1))
+			Expect(service.Plans[0].ID).To(Equal("plan-1"))
+			Expect(service.Plans[0].Name).To(Equal("existing"))
+		})
+
+		It("should not advertise the plans of the catalog file which provision AzureFileShare", func() {
+			broker.SetCatalog(NewCatalogConfig(`
+id: catalog-service-id
+name: smbfs
+description: SMB shares
+plans:
+- id: plan-1
+  name: existing
+  description: Preexisting shares
+  kind: preexisting
+- id: plan-2
+  name: azure
+  description: Azure file shares
+  kind: azurefileshare
+- id: plan-3
+  name: any
+  description: Preexisting shares in this broker
+`))
+			var response struct {
+				Services []brokerapi.Service `json:"services"`
+			}
+			Expect(json.Unmarshal(serve("/v2/catalog", "", "secret").Body.Bytes(), &response)).To(Succeed())
+			planIDs := []string{}
+			for _, plan := range response.Services[0].Plans {
+				planIDs = append(planIDs, plan.ID)
+			}
+			Expect(planIDs).To(Equal([]string{"plan-1", "plan-3"}))
+			Expect(response.Services[0].PlanUpdatable).To(BeFalse())
+		})
+
+		It("should tell that the instances and the bindings can be fetched", func() {
+			recorder := serve("/v2/catalog", "", "secret")
+			Expect(recorder.Body.String()).To(ContainSubstring(`"instances_retrievable":true`))
+			Expect(recorder.Body.String()).To(ContainSubstring(`"bindings_retrievable":true`))
+		})
+
+		It("should publish the schemas of the provision and the bind parameters of the plans", func() {
+			type parametersSchema struct {
+				Schema     string                     `json:"$schema"`
+				Properties map[string]json.RawMessage `json:"properties"`
+				Required   []string                   `json:"required"`
+			}
+			var response struct {
+				Services []struct {
+					Plans []struct {
+						Schemas struct {
+							ServiceInstance struct {
+								Create struct{ Parameters parametersSchema }
+							} `json:"service_instance"`
+							ServiceBinding struct {
+								Create struct{ Parameters parametersSchema }
+							} `json:"service_binding"`
+						} `json:"schemas"`
+					} `json:"plans"`
+				} `json:"services"`
+			}
+			Expect(json.Unmarshal(serve("/v2/catalog", "", "secret").Body.Bytes(), &response)).To(Succeed())
+			jsonNames := func(value interface{}) []string {
+				names := []string{}
+				structType := reflect.TypeOf(value)
+				for i := 0; i < structType.NumField(); i++ {
+					names = append(names, strings.Split(structType.Field(i).Tag.Get("json"), ",")[0])
+				}
+				return names
+			}
+
+			// The broker of the specs only provisions preexisting shares
+			plans := response.Services[0].Plans
+			Expect(plans).To(HaveLen(1))
+			provision := plans[0].Schemas.ServiceInstance.Create.Parameters
+			Expect(provision.Schema).To(Equal("http://json-schema.org/draft-04/schema#"))
+			Expect(provision.Properties).To(HaveKey("share"))
+			Expect(provision.Properties).NotTo(HaveKey("storage_account_name"))
+			Expect(provision.Required).To(Equal([]string{"share"}))
+			bind := plans[0].Schemas.ServiceBinding.Create.Parameters
+			Expect(bind.Schema).To(Equal("http://json-schema.org/draft-04/schema#"))
+			for _, name := range jsonNames(BindOptions{}) {
+				Expect(bind.Properties).To(HaveKey(name))
+			}
+		})
+
+		It("should return not modified when the ETag matches", func() {
+			etag := serve("/v2/catalog", "", "secret").Header().Get("ETag")
+			recorder := serve("/v2/catalog", etag, "secret")
+			Expect(recorder.Code).To(Equal(http.StatusNotModified))
+			Expect(recorder.Body.Len()).To(Equal(0))
+
+			Expect(serve("/v2/catalog", `"other"`, "secret").Code).To(Equal(http.StatusOK))
+		})
+
+		It("should keep the ETag until the catalog is invalidated", func() {
+			etag := serve("/v2/catalog", "", "secret").Header().Get("ETag")
+			Expect(serve("/v2/catalog", "", "secret").Header().Get("ETag")).To(Equal(etag))
+			broker.InvalidateCatalog()
+			Expect(serve("/v2/catalog", etag, "secret").Code).To(Equal(http.StatusNotModified))
+		})
+
+		It("should pass other requests and requests without the credentials to the next handler", func() {
+			Expect(serve("/v2/catalog", "", "wrong").Code).To(Equal(http.StatusTeapot))
+			Expect(serve("/v2/service_instances/instance-id", "", "secret").Code).To(Equal(http.StatusTeapot))
+			Expect(nextHits).To(Equal(2))
+		})
+
+		It("should pass the requests without a 2.x version of the service broker API to the next handler", func() {
+			etag := serve("/v2/catalog", "", "secret").Header().Get("ETag")
+			Expect(etag).NotTo(BeEmpty())
+
+			for _, version := range []string{"", "1.0", "3.0", "2", "two"} {
+				recorder := httptest.NewRecorder()
+				request := httptest.NewRequest("GET", "/v2/catalog", nil)
+				request.SetBasicAuth("admin", "secret")
+				request.Header.Set("If-None-Match", etag)
+				if version != "" {
+					request.Header.Set(BrokerAPIVersionHeader, version)
+				}
+				handler.ServeHTTP(recorder, request)
+				Expect(recorder.Code).To(Equal(http.StatusTeapot), "version %q", version)
+			}
+			Expect(nextHits).To(Equal(5))
+		})
+	})
+
+	Context("fetch of the instances and the bindings", func() {
+		var (
+			handler  http.Handler
+			nextHits int
+		)
+
+		JustBeforeEach(func() {
+			nextHits = 0
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextHits++
+				w.WriteHeader(http.StatusTeapot)
+			})
+			handler = broker.FetchHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"}, next)
+		})
+
+		serve := func(method, path, password string) *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(method, path, nil)
+			request.SetBasicAuth("admin", password)
+			handler.ServeHTTP(recorder, request)
+			return recorder
+		}
+
+		It("should return the provision parameters of the instance", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				ServiceID:           "service-id",
+				PlanID:              "plan-id",
+				ProvisioningState:   "succeeded",
+				ProvisionParameters: json.RawMessage(`{"storage_account_name":"account"}`),
+			}, nil)
+			recorder := serve("GET", "/v2/service_instances/instance-id", "secret")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"service_id":"service-id","plan_id":"plan-id","parameters":{"storage_account_name":"account"}}`))
+			Expect(fakeStore.RetrieveServiceInstanceArgsForCall(0)).To(Equal("instance-id"))
+		})
+
+		It("should rebuild the parameters of the instances of older brokers", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				PlanID:            "plan-id",
+				SubscriptionID:    "subscription",
+				ResourceGroupName: "group",
+				TargetName:        "account",
+				UseHTTPS:          "false",
+			}, nil)
+			recorder := serve("GET", "/v2/service_instances/instance-id", "secret")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"service_id":"","plan_id":"plan-id","parameters":{"subscription_id":"subscription","resource_group_name":"group","storage_account_name":"account","use_https":"false"}}`))
+		})
+
+		It("should return 404 for an instance which does not exist or is being provisioned", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{}, brokerapi.ErrInstanceDoesNotExist)
+			Expect(serve("GET", "/v2/service_instances/instance-id", "secret").Code).To(Equal(http.StatusNotFound))
+
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{ProvisioningState: "creating"}, nil)
+			Expect(serve("GET", "/v2/service_instances/instance-id", "secret").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should return the bind options of the binding of a preexisting share without the secrets", func() {
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{
+				BindOptions: &BindOptions{FileShareName: "share", UID: "1000", Password: "secret"},
+			}, nil)
+			recorder := serve("GET", "/v2/service_instances/instance-id/service_bindings/binding-id", "secret")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(fakeStore.RetrieveBindingDetailsArgsForCall(0)).To(Equal("binding-id"))
+
+			var response BindingResponse
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Parameters.FileShareName).To(Equal("share"))
+			Expect(response.Parameters.UID).To(Equal("1000"))
+			Expect(response.Parameters.Password).To(BeEmpty())
+			Expect(response.VolumeMounts).To(BeEmpty())
+		})
+
+		It("should return 404 for a binding of another instance", func() {
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{
+				BindOptions: &BindOptions{FileShareName: "share"},
+				FileShareID: "other-id-share",
+			}, nil)
+			Expect(serve("GET", "/v2/service_instances/instance-id/service_bindings/binding-id", "secret").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should return 404 for a binding of a preexisting share of another instance", func() {
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{
+				InstanceID:  "other-id",
+				BindOptions: &BindOptions{FileShareName: "share"},
+			}, nil)
+			Expect(serve("GET", "/v2/service_instances/instance-id/service_bindings/binding-id", "secret").Code).To(Equal(http.StatusNotFound))
+
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{
+				InstanceID:  "instance-id",
+				BindOptions: &BindOptions{FileShareName: "share"},
+			}, nil)
+			Expect(serve("GET", "/v2/service_instances/instance-id/service_bindings/binding-id", "secret").Code).To(Equal(http.StatusOK))
+		})
+
+		It("should return 404 for a binding of an older broker when the instance does not exist", func() {
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{
+				BindOptions: &BindOptions{FileShareName: "share"},
+			}, nil)
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{}, brokerapi.ErrInstanceDoesNotExist)
+			Expect(serve("GET", "/v2/service_instances/instance-id/service_bindings/binding-id", "secret").Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should pass the last operations, the other methods and the requests without the credentials to the next handler", func() {
+			Expect(serve("GET", "/v2/service_instances/instance-id/last_operation", "secret").Code).To(Equal(http.StatusTeapot))
+			Expect(serve("PUT", "/v2/service_instances/instance-id", "secret").Code).To(Equal(http.StatusTeapot))
+			Expect(serve("GET", "/v2/service_instances/instance-id", "wrong").Code).To(Equal(http.StatusTeapot))
+			Expect(nextHits).To(Equal(3))
+		})
+	})
+
+	Context("validation webhook", func() {
+		var fakeWebhook *azurefilebrokerfakes.FakeValidationWebhook
+
+		BeforeEach(func() {
+			fakeWebhook = &azurefilebrokerfakes.FakeValidationWebhook{}
+			fakeWebhook.ValidateReturns(ValidationResponse{Allowed: true}, nil)
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{
+				ServiceID:         "service-id",
+				PlanID:            "plan-id",
+				OrganizationGUID:  "org-guid",
+				SpaceGUID:         "space-guid",
+				IsPreexisting:     true,
+				TargetName:        "//server/share",
+				ProvisioningState: "succeeded",
+			}, nil)
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, errors.New("not found"))
+		})
+
+		JustBeforeEach(func() {
+			broker.SetValidationWebhook(fakeWebhook)
+		})
+
+		bind := func() error {
+			_, err := broker.Bind(ctx, "instance-id", "binding-id", brokerapi.BindDetails{
+				AppGUID:       "app-guid",
+				PlanID:        "plan-id",
+				ServiceID:     "service-id",
+				RawParameters: json.RawMessage(`{"username":"user","password":"secret"}`),
+			})
+			return err
+		}
+
+		It("should send the provision request to the webhook", func() {
+			_, err := broker.Provision(ctx, "instance-id", brokerapi.ProvisionDetails{
+				ServiceID:        "service-id",
+				PlanID:           "plan-id",
+				OrganizationGUID: "org-guid",
+				SpaceGUID:        "space-guid",
+				RawParameters:    json.RawMessage(`{"share":"//server/share"}`),
+			}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeWebhook.ValidateCallCount()).To(Equal(1))
+			request := fakeWebhook.ValidateArgsForCall(0)
+			Expect(request.Operation).To(Equal(ValidationOperationProvision))
+			Expect(request.InstanceID).To(Equal("instance-id"))
+			Expect(request.OrganizationGUID).To(Equal("org-guid"))
+			Expect(string(request.Parameters)).To(Equal(`{"share":"//server/share"}`))
+			Expect(fakeStore.CreateServiceInstanceCallCount()).To(Equal(1))
+		})
+
+		It("should send the bind request with the org and space of the instance to the webhook", func() {
+			Expect(bind()).To(Succeed())
+			request := fakeWebhook.ValidateArgsForCall(0)
+			Expect(request.Operation).To(Equal(ValidationOperationBind))
+			Expect(request.BindingID).To(Equal("binding-id"))
+			Expect(request.SpaceGUID).To(Equal("space-guid"))
+			Expect(request.AppGUID).To(Equal("app-guid"))
+		})
+
+		It("should refuse a denied request", func() {
+			fakeWebhook.ValidateReturns(ValidationResponse{Allowed: false, Reason: "naming convention"}, nil)
+			err := bind()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusForbidden))
+			Expect(err.Error()).To(ContainSubstring("naming convention"))
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+		})
+
+		It("should use the parameters which are returned by the webhook", func() {
+			fakeWebhook.ValidateReturns(ValidationResponse{Allowed: true, Parameters: json.RawMessage(`{"username":"policy-user","password":"secret"}`)}, nil)
+			Expect(bind()).To(Succeed())
+			_, details := fakeStore.CreateBindingDetailsArgsForCall(0)
+			Expect(details.BindOptions.Username).To(Equal("policy-user"))
+		})
+
+		It("should refuse the request when the webhook fails", func() {
+			fakeWebhook.ValidateReturns(ValidationResponse{}, errors.New("connection refused"))
+			err := bind()
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusBadGateway))
+			Expect(fakeStore.CreateBindingDetailsCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("RetryShareDeletions", func() {
+		BeforeEach(func() {
+			fakeStore.RetrievePendingShareDeletionsReturns(map[string]PendingShareDeletion{
+				"instance-id-due": {
+					FileShareID:   "instance-id-due",
+					FileShareName: "due",
+					InstanceID:    "instance-id",
+					Attempts:      1,
+					NextAttemptAt: time.Now().Add(-time.Minute),
+				},
+				"instance-id-later": {
+					FileShareID:   "instance-id-later",
+					FileShareName: "later",
+					InstanceID:    "instance-id",
+					Attempts:      3,
+					NextAttemptAt: time.Now().Add(time.Hour),
+				},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			broker.RetryShareDeletions(lagertest.NewTestLogger("retry-share-deletions"))
+		})
+
+		Context("when the file share is bound again", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveFileShareReturns(FileShare{}, nil)
+			})
+
+			It("should only retry the deletion which is due and drop it", func() {
+				Expect(fakeStore.RetrieveFileShareCallCount()).To(Equal(1))
+				Expect(fakeStore.RetrieveFileShareArgsForCall(0)).To(Equal("instance-id-due"))
+				Expect(fakeStore.DeletePendingShareDeletionCallCount()).To(Equal(1))
+				Expect(fakeStore.DeletePendingShareDeletionArgsForCall(0)).To(Equal("instance-id-due"))
+			})
+		})
+
+		Context("when another instance uses the file share", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+				fakeStore.RetrieveFileShareOwnerReturns(FileShareOwner{}, nil)
+			})
+
+			It("should drop the deletion", func() {
+				Expect(fakeStore.GetLockForUpdateCallCount()).To(Equal(2))
+				Expect(fakeStore.DeletePendingShareDeletionCallCount()).To(Equal(1))
+			})
+		})
+
+		Context("when the file share cannot be checked", func() {
+			BeforeEach(func() {
+				fakeStore.RetrieveFileShareReturns(FileShare{}, errors.New("connection lost"))
+			})
+
+			It("should keep the deletion", func() {
+				Expect(fakeStore.DeletePendingShareDeletionCallCount()).To(Equal(0))
+			})
+		})
+
+		It("should list the pending deletions in the admin API", func() {
+			handler := broker.AdminHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("GET", "/admin/share-deletions", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var response struct {
+				Count          int                          `json:"count"`
+				ShareDeletions []PendingShareDeletionStatus `json:"share_deletions"`
+			}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Count).To(Equal(2))
+			Expect(response.ShareDeletions[1].FileShareName).To(Equal("later"))
+			Expect(response.ShareDeletions[1].Attempts).To(Equal(3))
+			Expect(recorder.Body.String()).NotTo(ContainSubstring("service_instance"))
+		})
+	})
+
+	Context("ReplenishStorageAccountPool", func() {
+		BeforeEach(func() {
+			segments = NewIsolationSegmentConfig("segment1=/subscriptions/s/resourceGroups/g/providers/Microsoft.Network/virtualNetworks/v/subnets/cells")
+			pool = NewStoragePoolConfig("AzureFileShare-segment1:westeurope=1")
+			fakeStore.RetrievePooledStorageAccountsReturns(map[string]PooledStorageAccount{
+				"afbpool0123456789abcdef": {
+					StorageAccountName: "afbpool0123456789abcdef",
+					Location:           "westeurope",
+					PlanID:             segments.Networks[0].PlanID(),
+					State:              "ready",
+				},
+			}, nil)
+		})
+
+		JustBeforeEach(func() {
+			broker.ReplenishStorageAccountPool(lagertest.NewTestLogger("replenish-storage-account-pool"))
+		})
+
+		It("should not touch the pool when the creation of storage accounts is not allowed", func() {
+			Expect(fakeStore.GetLockForUpdateCallCount()).To(Equal(0))
+			Expect(fakeStore.RetrievePooledStorageAccountsCallCount()).To(Equal(0))
+		})
+
+		Context("when the creation of storage accounts is allowed", func() {
+			BeforeEach(func() {
+				control.AllowCreateStorageAccount = true
+			})
+
+			It("should not create an account when the pool is full", func() {
+				Expect(fakeStore.GetLockForUpdateCallCount()).To(Equal(1))
+				lockName, _ := fakeStore.GetLockForUpdateArgsForCall(0)
+				Expect(lockName).To(Equal("storage-account-pool"))
+				Expect(fakeStore.ReleaseLockForUpdateCallCount()).To(Equal(1))
+				Expect(fakeStore.CreatePooledStorageAccountCallCount()).To(Equal(0))
+				Expect(fakeStore.UpdatePooledStorageAccountCallCount()).To(Equal(0))
+			})
+
+			Context("when the plan has its own subscription and resource group", func() {
+				BeforeEach(func() {
+					pool = NewStoragePoolConfig("AzureFileShare-segment1:westeurope=2")
+					placement.ReadPlanTargets("AzureFileShare-segment1=sandbox:dev-shares")
+				})
+
+				It("should create the account in them", func() {
+					Expect(fakeStore.CreatePooledStorageAccountCallCount()).To(Equal(1))
+					_, account := fakeStore.CreatePooledStorageAccountArgsForCall(0)
+					Expect(account.SubscriptionID).To(Equal("sandbox"))
+					Expect(account.ResourceGroupName).To(Equal("dev-shares"))
+					Expect(account.PlanID).To(Equal(segments.Networks[0].PlanID()))
+				})
+			})
+		})
+	})
+
+	Context("deletion of missing resources", func() {
+		It("should return gone when the instance to deprovision does not exist", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{}, brokerapi.ErrInstanceDoesNotExist)
+			_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+			Expect(err.(*brokerapi.FailureResponse).ValidatedStatusCode(nil)).To(Equal(http.StatusGone))
+		})
+
+		It("should not return gone when the store fails", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{}, errors.New("database unavailable"))
+			_, err := broker.Deprovision(ctx, "instance-id", brokerapi.DeprovisionDetails{}, true)
+			Expect(ErrorCode(err)).To(Equal(ErrCodeStoreOperationFailed))
+
+			err = broker.Unbind(ctx, "instance-id", "binding-id", brokerapi.UnbindDetails{})
+			Expect(ErrorCode(err)).To(Equal(ErrCodeStoreOperationFailed))
+
+			_, err = broker.LastOperation(ctx, "instance-id", "operation-url")
+			Expect(ErrorCode(err)).To(Equal(ErrCodeStoreOperationFailed))
+		})
+
+		It("should return gone when the binding to unbind does not exist", func() {
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, brokerapi.ErrInstanceDoesNotExist)
+			err := broker.Unbind(ctx, "instance-id", "binding-id", brokerapi.UnbindDetails{})
+			Expect(err).To(Equal(brokerapi.ErrBindingDoesNotExist))
+
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, errors.New("database unavailable"))
+			err = broker.Unbind(ctx, "instance-id", "binding-id", brokerapi.UnbindDetails{})
+			Expect(ErrorCode(err)).To(Equal(ErrCodeStoreOperationFailed))
+		})
+
+		It("should recompute the counts of the file shares of the instance when the binding does not exist", func() {
+			fakeEmitter := &azurefilebrokerfakes.FakeAuditEventEmitter{}
+			broker.SetAuditEventEmitter(fakeEmitter)
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{TargetName: "account", SpaceGUID: "space-guid"}, nil)
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, brokerapi.ErrInstanceDoesNotExist)
+			fakeStore.RetrieveAllBindingDetailsReturns(map[string]BindingDetails{
+				"binding-2": {FileShareID: "instance-id-share"},
+			}, nil)
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+				"instance-id-share":      {InstanceID: "instance-id", FileShareName: "share", Count: 2},
+				"another-instance-share": {InstanceID: "another-instance", FileShareName: "share", Count: 5},
+			}, nil)
+			fakeStore.RetrieveFileShareReturns(FileShare{InstanceID: "instance-id", FileShareName: "share", Count: 2}, nil)
+
+			err := broker.Unbind(ctx, "instance-id", "binding-id", brokerapi.UnbindDetails{})
+			Expect(err).To(Equal(brokerapi.ErrBindingDoesNotExist))
+			Expect(fakeStore.RetrieveFileShareCallCount()).To(Equal(1))
+			Expect(fakeStore.RetrieveFileShareArgsForCall(0)).To(Equal("instance-id-share"))
+			Expect(fakeStore.UpdateFileShareCallCount()).To(Equal(1))
+			_, share := fakeStore.UpdateFileShareArgsForCall(0)
+			Expect(share.Count).To(Equal(1))
+			Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(0))
+
+			Expect(fakeEmitter.EmitCallCount()).To(Equal(1))
+			event := fakeEmitter.EmitArgsForCall(0)
+			Expect(event.Type).To(Equal(AuditEventServiceBindingDelete))
+			Expect(event.BindingID).To(Equal("binding-id"))
+			Expect(event.SpaceGUID).To(Equal("space-guid"))
+			Expect(event.Inconsistency).NotTo(BeEmpty())
+		})
+
+		It("should return gone when the counts cannot be recomputed", func() {
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{}, brokerapi.ErrInstanceDoesNotExist)
+			fakeStore.RetrieveAllBindingDetailsReturns(nil, errors.New("database unavailable"))
+
+			err := broker.Unbind(ctx, "instance-id", "binding-id", brokerapi.UnbindDetails{})
+			Expect(err).To(Equal(brokerapi.ErrBindingDoesNotExist))
+			Expect(fakeStore.UpdateFileShareCallCount() + fakeStore.DeleteFileShareCallCount()).To(Equal(0))
+		})
+
+		It("should delete a binding whose file share is not in the store", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{TargetName: "account"}, nil)
+			fakeStore.RetrieveBindingDetailsReturns(BindingDetails{FileShareID: "file-share-id"}, nil)
+			fakeStore.RetrieveFileShareReturns(FileShare{}, brokerapi.ErrInstanceDoesNotExist)
+
+			err := broker.Unbind(ctx, "instance-id", "binding-id", brokerapi.UnbindDetails{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.DeleteBindingDetailsCallCount()).To(Equal(1))
+			Expect(fakeStore.UpdateFileShareCallCount() + fakeStore.DeleteFileShareCallCount()).To(Equal(0))
+			Expect(fakeStore.ReleaseLockForUpdateCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("storage account references", func() {
+		BeforeEach(func() {
+			fakeStore.RetrieveServiceInstancesByTargetNameReturns(map[string]ServiceInstance{
+				"instance-2": {TargetName: "account", OrganizationGUID: "org-2", IsCreatedStorageAccount: true},
+				"instance-1": {TargetName: "account", OrganizationGUID: "org-1"},
+			}, nil)
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+				"share-1": {InstanceID: "instance-1", FileShareName: "data"},
+				"share-3": {InstanceID: "instance-3", FileShareName: "other"},
+			}, nil)
+			fakeStore.RetrieveAllBindingDetailsReturns(map[string]BindingDetails{
+				"binding-b": {BindDetails: brokerapi.BindDetails{AppGUID: "app-b"}, FileShareID: "share-1"},
+				"binding-a": {BindDetails: brokerapi.BindDetails{AppGUID: "app-a"}, FileShareID: "share-1"},
+				"binding-c": {BindDetails: brokerapi.BindDetails{AppGUID: "app-c"}, FileShareID: "share-3"},
+				"legacy":    {BindDetails: brokerapi.BindDetails{AppGUID: "app-d", RawParameters: json.RawMessage(`{"share":"data"}`)}},
+			}, nil)
+		})
+
+		It("should list the instances and the bindings of the storage account", func() {
+			references, err := broker.StorageAccountReferences("account")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeStore.RetrieveServiceInstancesByTargetNameArgsForCall(0)).To(Equal("account"))
+			Expect(references.Instances).To(HaveLen(2))
+			Expect(references.Instances[0].InstanceID).To(Equal("instance-1"))
+			Expect(references.Instances[0].Bindings).To(Equal([]StorageAccountBinding{
+				{BindingID: "binding-a", AppGUID: "app-a", FileShareName: "data"},
+				{BindingID: "binding-b", AppGUID: "app-b", FileShareName: "data"},
+			}))
+			Expect(references.Instances[1].IsCreatedStorageAccount).To(BeTrue())
+			Expect(references.Instances[1].Bindings).To(BeEmpty())
+			Expect(references.UnattributedBindings).To(Equal(1))
+		})
+
+		It("should serve the references in the admin API", func() {
+			handler := broker.AdminHandler(brokerapi.BrokerCredentials{Username: "admin", Password: "secret"})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("GET", "/admin/storage-accounts/account/instances", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var references StorageAccountReferences
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &references)).To(Succeed())
+			Expect(references.StorageAccountName).To(Equal("account"))
+			Expect(references.Instances).To(HaveLen(2))
+		})
+
+		It("should return no instances for an unknown storage account", func() {
+			fakeStore.RetrieveServiceInstancesByTargetNameReturns(map[string]ServiceInstance{}, nil)
+			references, err := broker.StorageAccountReferences("unknown")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(references.Instances).To(BeEmpty())
+			Expect(fakeStore.RetrieveAllBindingDetailsCallCount()).To(Equal(0))
+		})
+	})
+
+	Context("metrics", func() {
+		var credentials brokerapi.BrokerCredentials
+
+		BeforeEach(func() {
+			credentials = brokerapi.BrokerCredentials{Username: "admin", Password: "secret"}
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{ProvisioningState: "succeeded"}, nil)
+		})
+
+		It("should serve the counts of the resources and the results of the operations in the admin API", func() {
+			_, err := broker.LastOperation(ctx, "instance-id", "operation-url")
+			Expect(err).NotTo(HaveOccurred())
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{}, brokerapi.ErrInstanceDoesNotExist)
+			_, err = broker.LastOperation(ctx, "missing-instance-id", "operation-url")
+			Expect(err).To(HaveOccurred())
+
+			fakeStore.RetrieveServiceInstancesReturns(map[string]ServiceInstance{"instance-1": {}, "instance-2": {}}, nil)
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{"share-1": {}}, nil)
+			fakeStore.RetrieveAllBindingDetailsReturns(map[string]BindingDetails{"binding-1": {}, "binding-2": {}, "binding-3": {}}, nil)
+			fakeStore.RetrievePendingShareDeletionsReturns(map[string]PendingShareDeletion{"share-2": {}}, nil)
+
+			handler := broker.AdminHandler(credentials)
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest("GET", "/admin/stats", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var stats BrokerStats
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &stats)).To(Succeed())
+			Expect(stats.ServiceInstances).To(Equal(2))
+			Expect(stats.FileShares).To(Equal(1))
+			Expect(stats.Bindings).To(Equal(3))
+			Expect(stats.PendingShareDeletions).To(Equal(1))
+			Expect(stats.Operations).To(Equal(map[string]OperationStats{
+				"last-operation": {Count: 2, Failures: 1, ErrorRate: 0.5, ErrorCodes: map[string]int64{"instance-missing": 1}},
+			}))
+		})
+
+		It("should count the resources which the broker created or only referenced in every space", func() {
+			fakeStore.RetrieveServiceInstancesReturns(map[string]ServiceInstance{
+				"created-1":    {OrganizationGUID: "org", SpaceGUID: "space-a", SubscriptionID: "sub", ResourceGroupName: "rg", TargetName: "created", IsCreatedStorageAccount: true},
+				"referenced-1": {OrganizationGUID: "org", SpaceGUID: "space-a", SubscriptionID: "sub", ResourceGroupName: "rg", TargetName: "existing"},
+				"referenced-2": {OrganizationGUID: "org", SpaceGUID: "space-a", SubscriptionID: "sub", ResourceGroupName: "rg", TargetName: "Existing"},
+				"preexisting":  {OrganizationGUID: "org", SpaceGUID: "space-b", IsPreexisting: true, TargetName: "//server/share"},
+			}, nil)
+			fakeStore.RetrieveFileSharesReturns(map[string]FileShare{
+				"share-1": {InstanceID: "created-1", FileShareName: "data", IsCreated: true},
+				"share-2": {InstanceID: "referenced-1", FileShareName: "logs"},
+				"share-3": {InstanceID: "referenced-2", FileShareName: "logs"},
+				"share-4": {InstanceID: "deleted-instance", FileShareName: "tmp", IsCreated: true},
+			}, nil)
+
+			origins, err := broker.ResourceOrigins()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(origins).To(Equal([]ResourceOrigins{
+				{OrganizationGUID: "org", SpaceGUID: "space-a", CreatedStorageAccounts: 1, ReferencedStorageAccounts: 1, CreatedFileShares: 1, ReferencedFileShares: 1},
+				{OrganizationGUID: "org", SpaceGUID: "space-b", ReferencedFileShares: 1},
+			}))
+
+			stats, err := broker.Stats()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.ResourceOrigins).To(Equal(origins))
+		})
+
+		It("should report the degraded health of Azure in the gauge and the admin API", func() {
+			health, err := NewAzureHealth(lagertest.NewTestLogger("azure-health"), fakeclock.NewFakeClock(time.Now()), time.Minute, 0.5, 1)
+			Expect(err).NotTo(HaveOccurred())
+			fakeMetrics := &azurefilebrokerfakes.FakeMetrics{}
+			broker.SetAzureHealth(health)
+			broker.SetMetrics(fakeMetrics)
+			health.Record(nil, http.StatusServiceUnavailable)
+
+			Expect(fakeMetrics.SetGaugeCallCount()).To(Equal(2))
+			name, _, value := fakeMetrics.SetGaugeArgsForCall(0)
+			Expect(name).To(Equal("azurefilebroker_azure_degraded"))
+			Expect(value).To(Equal(0.0))
+			_, _, value = fakeMetrics.SetGaugeArgsForCall(1)
+			Expect(value).To(Equal(1.0))
+
+			stats, err := broker.Stats()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats.AzureDegraded).To(BeTrue())
+		})
+
+		It("should record the operations and the calls to the store in the metrics", func() {
+			fakeMetrics := &azurefilebrokerfakes.FakeMetrics{}
+			broker.SetMetrics(fakeMetrics)
+			_, err := broker.LastOperation(ctx, "instance-id", "operation-url")
+			Expect(err).NotTo(HaveOccurred())
+
+			counters := map[string][]map[string]string{}
+			for i := 0; i < fakeMetrics.IncrementCounterCallCount(); i++ {
+				name, labels := fakeMetrics.IncrementCounterArgsForCall(i)
+				counters[name] = append(counters[name], labels)
+			}
+			Expect(counters["azurefilebroker_operations_total"]).To(ConsistOf(map[string]string{"operation": "last-operation", "result": "success"}))
+			Expect(counters["azurefilebroker_store_calls_total"]).To(ContainElement(map[string]string{"database": "primary", "method": "RetrieveServiceInstance", "result": "success"}))
+			Expect(fakeMetrics.ObserveDurationCallCount()).To(BeNumerically(">", 0))
+		})
+
+		It("should serve the Prometheus metrics with the credentials of the broker", func() {
+			broker.SetMetrics(NewPrometheusMetrics())
+			_, err := broker.LastOperation(ctx, "instance-id", "operation-url")
+			Expect(err).NotTo(HaveOccurred())
+
+			handler, ok := broker.MetricsHandler(credentials)
+			Expect(ok).To(BeTrue())
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+
+			recorder = httptest.NewRecorder()
+			request := httptest.NewRequest("GET", "/metrics", nil)
+			request.SetBasicAuth("admin", "secret")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(ContainSubstring(`azurefilebroker_operations_total{operation="last-operation",result="success"} 1`))
+		})
+
+		It("should not serve the metrics of a backend which does not serve them", func() {
+			broker.SetMetrics(NewNoopMetrics())
+			_, ok := broker.MetricsHandler(credentials)
+			Expect(ok).To(BeFalse())
+		})
+	})
 })
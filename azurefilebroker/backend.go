@@ -0,0 +1,93 @@
+package azurefilebroker
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+//go:generate counterfeiter -o ../azurefilebrokerfakes/fake_backend.go . Backend
+
+// Backend is the source of the SMB shares of a service instance
+type Backend interface {
+	HasFileShare(fileShareName string) (bool, error)
+	CreateFileShare(fileShareName string) error
+	DeleteFileShare(fileShareName string) error
+	GetShareURL(fileShareName string) (string, error)
+}
+
+// The file shares of an Azure storage account are served by its SDK client
+var _ Backend = AzureStorageAccountSDKClient(nil)
+
+// newBackend returns the backend of the service instance
+func (b *Broker) newBackend(logger lager.Logger, serviceInstance *ServiceInstance) (Backend, error) {
+	if serviceInstance.IsPreexisting {
+		return NewPreexistingSMBBackend(b.config.preexisting.AllowedShares), nil
+	}
+	storageAccount, err := b.newStorageAccountWithSDKClient(logger, serviceInstance)
+	if err != nil {
+		return nil, err
+	}
+	return storageAccount.SDKClient, nil
+}
+
+type preexistingSMBBackend struct {
+	allowedShares []string
+}
+
+// NewPreexistingSMBBackend returns the backend of the SMB shares which exist outside of Azure, e.g. on-premises file
+// servers. The shares are UNC paths such as //server/share. Only the shares in allowedShares and their subdirectories
+// are served unless allowedShares is empty.
+func NewPreexistingSMBBackend(allowedShares []string) Backend {
+	normalized := make([]string, 0, len(allowedShares))
+	for _, share := range allowedShares {
+		normalized = append(normalized, normalizeUNCPath(share))
+	}
+	return &preexistingSMBBackend{allowedShares: normalized}
+}
+
+func (p *preexistingSMBBackend) HasFileShare(share string) (bool, error) {
+	if !isUNCPath(share) {
+		return false, newBrokerError(ErrCodeInvalidParameters, "Invalid share %q: expected a UNC path such as //server/share", share)
+	}
+	if len(p.allowedShares) == 0 {
+		return true, nil
+	}
+	share = normalizeUNCPath(share)
+	for _, allowed := range p.allowedShares {
+		if share == allowed || strings.HasPrefix(share, allowed+"/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p *preexistingSMBBackend) CreateFileShare(share string) error {
+	return newBrokerError(ErrCodeOperationNotSupportedForShare, "The preexisting share %q cannot be created by the broker", share)
+}
+
+func (p *preexistingSMBBackend) DeleteFileShare(share string) error {
+	return newBrokerError(ErrCodeOperationNotSupportedForShare, "The preexisting share %q cannot be deleted by the broker", share)
+}
+
+// GetShareURL returns the share as given because it is the source of the existing bindings
+func (p *preexistingSMBBackend) GetShareURL(share string) (string, error) {
+	return share, nil
+}
+
+// isUNCPath returns true for //server/share or \\server\share with an optional path
+func isUNCPath(path string) bool {
+	path = strings.Replace(path, `\`, "/", -1)
+	if !strings.HasPrefix(path, "//") {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "//"), "/", 3)
+	return len(parts) >= 2 && parts[0] != "" && parts[1] != ""
+}
+
+// normalizeUNCPath returns the path with forward slashes, without the trailing slash and in lower case because SMB
+// server and share names are case insensitive
+func normalizeUNCPath(path string) string {
+	path = strings.Replace(path, `\`, "/", -1)
+	return strings.ToLower(strings.TrimRight(path, "/"))
+}
@@ -0,0 +1,242 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/tedsuo/ifrit"
+)
+
+// The steps which protect a created file share with Azure Backup, in their order. The backup state of a file share is
+// the next step to do, or the step whose asynchronous operation is in progress.
+const (
+	backupStateRefreshContainers = "refresh-containers"
+	backupStateRegisterContainer = "register-container"
+	backupStateInquireItems      = "inquire-items"
+	backupStateProtectFileShare  = "protect-file-share"
+	backupStateProtected         = "protected"
+)
+
+// The error of a backup step is cut so that the file share fits in the value column of the store
+const maxBackupErrorLength = 1024
+
+var backupVaultIDPattern = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.RecoveryServices/vaults/[^/]+$`)
+
+// BackupTarget is the Recovery Services vault and its backup policy which protect the file shares created for a
+// service instance
+type BackupTarget struct {
+	VaultID    string `json:"vault_id"`
+	PolicyName string `json:"policy_name"`
+}
+
+// BackupConfig is the default backup target of the AzureFileShare instances. The file shares are not protected when it
+// has no vault and the provision parameters give none.
+type BackupConfig struct {
+	BackupTarget
+}
+
+// NewBackupConfig returns the backup config of the full ID of a Recovery Services vault and the name of a backup policy
+// of the vault
+func NewBackupConfig(vaultID, policyName string) *BackupConfig {
+	myConf := new(BackupConfig)
+
+	myConf.VaultID = strings.TrimSpace(vaultID)
+	myConf.PolicyName = strings.TrimSpace(policyName)
+
+	return myConf
+}
+
+func (config *BackupConfig) Validate(azure *AzureConfig) error {
+	if config.VaultID == "" && config.PolicyName == "" {
+		return nil
+	}
+	if config.VaultID == "" || config.PolicyName == "" {
+		return fmt.Errorf("backupVaultID and backupPolicy must be given together")
+	}
+	if !backupVaultIDPattern.MatchString(config.VaultID) {
+		return fmt.Errorf("Invalid backupVaultID %q: expected /subscriptions/<id>/resourceGroups/<name>/providers/Microsoft.RecoveryServices/vaults/<name>", config.VaultID)
+	}
+	if !azure.IsSupportAzureFileShare() {
+		return fmt.Errorf("backupVaultID requires AzureFileShare")
+	}
+	if azure.GetAPIVersions().RecoveryServices == "" {
+		return fmt.Errorf("backupVaultID is not supported in the environment %q", azure.Environment)
+	}
+	return nil
+}
+
+// backupTarget returns the backup target of a new AzureFileShare instance. The provision parameters override the
+// config, and a policy alone applies to the vault of the config. It returns nil when the file shares are not protected.
+func (b *Broker) backupTarget(configuration Configuration) (*BackupTarget, error) {
+	target := BackupTarget{
+		VaultID:    b.config.backup.VaultID,
+		PolicyName: b.config.backup.PolicyName,
+	}
+	if configuration.BackupVaultID != "" {
+		target.VaultID = configuration.BackupVaultID
+		target.PolicyName = configuration.BackupPolicy
+	} else if configuration.BackupPolicy != "" {
+		target.PolicyName = configuration.BackupPolicy
+	}
+
+	if target.VaultID == "" {
+		if configuration.BackupPolicy != "" {
+			return nil, newBrokerError(ErrCodeInvalidParameters, "The parameter backup_policy requires backup_vault_id because no backup vault is configured")
+		}
+		return nil, nil
+	}
+	if b.config.cloud.Azure.GetAPIVersions().RecoveryServices == "" {
+		return nil, newBrokerError(ErrCodeInvalidParameters, "Azure Backup is not supported in the environment %q", b.config.cloud.Azure.Environment)
+	}
+	if !backupVaultIDPattern.MatchString(target.VaultID) {
+		return nil, newBrokerError(ErrCodeInvalidParameters, "Invalid backup_vault_id %q: expected /subscriptions/<id>/resourceGroups/<name>/providers/Microsoft.RecoveryServices/vaults/<name>", target.VaultID)
+	}
+	if target.PolicyName == "" {
+		return nil, newBrokerError(ErrCodeInvalidParameters, "Missing required parameters: backup_policy")
+	}
+	return &target, nil
+}
+
+// BackupProtector returns a runner which periodically protects the created file shares with Azure Backup
+func (b *Broker) BackupProtector(interval time.Duration) ifrit.Runner {
+	return b.newPeriodicRunner("backup-protector", interval, func(logger lager.Logger) {
+		if err := b.ProtectFileShares(logger); err != nil {
+			logger.Error("protect-file-shares", err)
+		}
+	})
+}
+
+// ProtectFileShares advances the protection of every created file share which is not protected yet. A failed step is
+// retried in the next run.
+func (b *Broker) ProtectFileShares(logger lager.Logger) error {
+	logger = logger.Session("protect-file-shares")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	shares, err := b.store.RetrieveFileShares()
+	if err != nil {
+		return err
+	}
+
+	pending, protected := 0, 0
+	for fileShareID, share := range shares {
+		if share.BackupState == "" || share.BackupState == backupStateProtected {
+			continue
+		}
+		state, err := b.protectFileShare(logger, fileShareID)
+		if err != nil {
+			logger.Error("protect-file-share", err, lager.Data{"fileShareID": fileShareID})
+		}
+		if state == backupStateProtected {
+			protected++
+		} else {
+			pending++
+		}
+	}
+	logger.Info("file-shares-protected", lager.Data{"protected": protected, "pending": pending})
+	return nil
+}
+
+// protectFileShare does the steps of the protection of the file share until one of them is in progress or fails, and
+// returns the backup state. The file share is read again under its lock because bind and unbind update it concurrently.
+func (b *Broker) protectFileShare(logger lager.Logger, fileShareID string) (string, error) {
+	logger = logger.Session("protect-file-share").WithData(lager.Data{"fileShareID": fileShareID})
+
+	if err := b.getLockForUpdate(fileShareID); err != nil {
+		return "", err
+	}
+	defer b.store.ReleaseLockForUpdate(fileShareID)
+
+	share, err := b.store.RetrieveFileShare(fileShareID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		// The file share was unbound in the meantime
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	serviceInstance, err := b.store.RetrieveServiceInstance(share.InstanceID)
+	if err != nil {
+		return share.BackupState, err
+	}
+	if serviceInstance.Backup == nil {
+		return share.BackupState, fmt.Errorf("The service instance %q has no backup target", share.InstanceID)
+	}
+	restClient, err := b.newRESTClientOfServiceInstance(logger, &serviceInstance)
+	if err != nil {
+		return share.BackupState, err
+	}
+
+	err = b.advanceBackup(logger, restClient, serviceInstance.Backup, &share)
+	share.BackupError = ""
+	if err != nil {
+		share.BackupError = err.Error()
+		if len(share.BackupError) > maxBackupErrorLength {
+			share.BackupError = share.BackupError[:maxBackupErrorLength]
+		}
+	}
+	if updateErr := b.store.UpdateFileShare(fileShareID, share); updateErr != nil {
+		return share.BackupState, newStoreError(updateErr, "Failed to update the file share %q in the store", fileShareID)
+	}
+	return share.BackupState, err
+}
+
+// advanceBackup waits for the operation of the current step and starts the next steps. A failed operation is started
+// again in the next run.
+func (b *Broker) advanceBackup(logger lager.Logger, restClient AzureStorageAccountRESTClient, target *BackupTarget, share *FileShare) error {
+	for share.BackupState != backupStateProtected {
+		if share.BackupOperationURL != "" {
+			done, err := restClient.CheckCompletion(share.BackupOperationURL)
+			if err != nil {
+				share.BackupOperationURL = ""
+				return err
+			} else if !done {
+				return nil
+			}
+			share.BackupOperationURL = ""
+			share.BackupState = nextBackupState(share.BackupState)
+			continue
+		}
+
+		var operationURL string
+		var err error
+		switch share.BackupState {
+		case backupStateRefreshContainers:
+			operationURL, err = restClient.RefreshBackupContainers(target.VaultID)
+		case backupStateRegisterContainer:
+			operationURL, err = restClient.RegisterBackupContainer(target.VaultID)
+		case backupStateInquireItems:
+			operationURL, err = restClient.InquireBackupItems(target.VaultID)
+		case backupStateProtectFileShare:
+			operationURL, err = restClient.ProtectFileShare(target.VaultID, target.PolicyName, share.FileShareName)
+		default:
+			return fmt.Errorf("Unknown backup state %q", share.BackupState)
+		}
+		if err != nil {
+			return err
+		}
+		logger.Info("backup-step-started", lager.Data{"step": share.BackupState, "async": operationURL != ""})
+		if operationURL != "" {
+			share.BackupOperationURL = operationURL
+			continue
+		}
+		share.BackupState = nextBackupState(share.BackupState)
+	}
+	logger.Info("file-share-protected", lager.Data{"vaultID": target.VaultID, "policyName": target.PolicyName})
+	return nil
+}
+
+func nextBackupState(state string) string {
+	switch state {
+	case backupStateRefreshContainers:
+		return backupStateRegisterContainer
+	case backupStateRegisterContainer:
+		return backupStateInquireItems
+	case backupStateInquireItems:
+		return backupStateProtectFileShare
+	}
+	return backupStateProtected
+}
@@ -0,0 +1,71 @@
+package azurefilebroker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var basePathPattern = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)+$`)
+
+type basePathKey struct{}
+
+// BasePathConfig is the path prefix under which the broker serves all its endpoints, e.g. /azurefilebroker for
+// /azurefilebroker/v2/catalog, so that it can share the route of a gateway which does not rewrite the paths
+type BasePathConfig struct {
+	Path string
+}
+
+// NewBasePathConfig returns the config of a base path, with the trailing slashes removed
+func NewBasePathConfig(basePath string) *BasePathConfig {
+	myConf := new(BasePathConfig)
+
+	myConf.Path = strings.TrimRight(strings.TrimSpace(basePath), "/")
+
+	return myConf
+}
+
+// IsEnabled returns true if the endpoints are served under a base path
+func (config *BasePathConfig) IsEnabled() bool {
+	return config.Path != ""
+}
+
+func (config *BasePathConfig) Validate() error {
+	if !config.IsEnabled() {
+		return nil
+	}
+	if !basePathPattern.MatchString(config.Path) {
+		return fmt.Errorf("Invalid basePath %q: expected a path such as /azurefilebroker, made of segments of letters, digits, '.', '_', '~' and '-'", config.Path)
+	}
+	return nil
+}
+
+// BasePathHandler serves next under the base path of the config. The requests of other paths get 404. next sees the
+// paths without the base path, while the logs and the errors of the broker report the paths of the requests.
+func BasePathHandler(config *BasePathConfig, next http.Handler) http.Handler {
+	if !config.IsEnabled() {
+		return next
+	}
+	basePath := config.Path
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != basePath && !strings.HasPrefix(r.URL.Path, basePath+"/") {
+			writeAdminError(w, newBrokerError(ErrCodeResourceNotFound, "Unknown path %q: the broker is served under %q", r.URL.Path, basePath))
+			return
+		}
+		r2 := r.WithContext(context.WithValue(r.Context(), basePathKey{}, basePath))
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, basePath), "/")
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// requestPath returns the path of the request as it was sent, with the base path which BasePathHandler removed
+func requestPath(r *http.Request) string {
+	basePath, _ := r.Context().Value(basePathKey{}).(string)
+	return basePath + r.URL.Path
+}
@@ -0,0 +1,103 @@
+package azurefilebroker
+
+import (
+	"sync"
+	"time"
+)
+
+// BrokerStats is a snapshot of the resources in the store and of the results of the operations since the broker
+// started, which the admin API serves whatever the metrics backend is
+type BrokerStats struct {
+	ServiceInstances      int                       `json:"service_instances"`
+	FileShares            int                       `json:"file_shares"`
+	Bindings              int                       `json:"bindings"`
+	PendingShareDeletions int                       `json:"pending_share_deletions"`
+	Operations            map[string]OperationStats `json:"operations"`
+	Since                 time.Time                 `json:"since"`
+	// AzureDegraded is true while the broker neither creates nor deletes resources because the requests to Azure fail
+	AzureDegraded bool `json:"azure_degraded"`
+	// ResourceOrigins counts the resources which the broker created or only referenced in every space
+	ResourceOrigins []ResourceOrigins `json:"resource_origins"`
+}
+
+// OperationStats are the results of an operation, e.g. provision
+type OperationStats struct {
+	Count     int64   `json:"count"`
+	Failures  int64   `json:"failures"`
+	ErrorRate float64 `json:"error_rate"`
+	// ErrorCodes is the number of failures of every error code
+	ErrorCodes map[string]int64 `json:"error_codes"`
+}
+
+// operationStats counts the results of the operations in memory. It is shared by the copies of the broker.
+type operationStats struct {
+	mutex      sync.Mutex
+	since      time.Time
+	operations map[string]*OperationStats
+}
+
+func newOperationStats(since time.Time) *operationStats {
+	return &operationStats{since: since, operations: map[string]*OperationStats{}}
+}
+
+func (s *operationStats) record(operation, errorCode string, failed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats, ok := s.operations[operation]
+	if !ok {
+		stats = &OperationStats{ErrorCodes: map[string]int64{}}
+		s.operations[operation] = stats
+	}
+	stats.Count++
+	if failed {
+		stats.Failures++
+		stats.ErrorCodes[errorCode]++
+	}
+	stats.ErrorRate = float64(stats.Failures) / float64(stats.Count)
+}
+
+func (s *operationStats) snapshot() map[string]OperationStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshot := map[string]OperationStats{}
+	for operation, stats := range s.operations {
+		errorCodes := map[string]int64{}
+		for code, count := range stats.ErrorCodes {
+			errorCodes[code] = count
+		}
+		snapshot[operation] = OperationStats{Count: stats.Count, Failures: stats.Failures, ErrorRate: stats.ErrorRate, ErrorCodes: errorCodes}
+	}
+	return snapshot
+}
+
+// Stats returns the snapshot of the broker. The counts of the resources may lag behind by the replication lag of the
+// read replica.
+func (b *Broker) Stats() (BrokerStats, error) {
+	stats := BrokerStats{Operations: b.operationStats.snapshot(), Since: b.operationStats.since, AzureDegraded: b.health.Degraded()}
+
+	instances, err := b.readStore().RetrieveServiceInstances()
+	if err != nil {
+		return stats, newStoreError(err, "Failed to retrieve the service instances")
+	}
+	shares, err := b.readStore().RetrieveFileShares()
+	if err != nil {
+		return stats, newStoreError(err, "Failed to retrieve the file shares")
+	}
+	bindings, err := b.readStore().RetrieveAllBindingDetails()
+	if err != nil {
+		return stats, newStoreError(err, "Failed to retrieve the bindings")
+	}
+	deletions, err := b.readStore().RetrievePendingShareDeletions()
+	if err != nil {
+		return stats, newStoreError(err, "Failed to retrieve the pending share deletions")
+	}
+
+	stats.ServiceInstances = len(instances)
+	stats.FileShares = len(shares)
+	stats.Bindings = len(bindings)
+	stats.PendingShareDeletions = len(deletions)
+	stats.ResourceOrigins = countResourceOrigins(instances, shares)
+	return stats, nil
+}
@@ -0,0 +1,145 @@
+package azurefilebroker
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// CatalogPath is the path of the catalog in the service broker API
+const CatalogPath = "/v2/catalog"
+
+// BrokerAPIVersionHeader is the header of the version of the service broker API which the platform uses
+const BrokerAPIVersionHeader = "X-Broker-API-Version"
+
+type catalogResponse struct {
+	Services []catalogService `json:"services"`
+}
+
+// catalogService is a service of the catalog which FetchHandler can fetch the instances and the bindings of. Its plans
+// replace the plans of the service, which the vendored service broker API has no schemas for.
+type catalogService struct {
+	brokerapi.Service
+	Plans                []catalogPlan `json:"plans"`
+	InstancesRetrievable bool          `json:"instances_retrievable"`
+	BindingsRetrievable  bool          `json:"bindings_retrievable"`
+}
+
+// catalogPlan is a plan of the catalog with the schemas of its parameters
+type catalogPlan struct {
+	brokerapi.ServicePlan
+	Schemas *PlanSchemas `json:"schemas,omitempty"`
+}
+
+// catalogCache keeps the serialized catalog and its ETag until it is invalidated
+type catalogCache struct {
+	mutex sync.Mutex
+	body  []byte
+	etag  string
+}
+
+// InvalidateCatalog drops the cached catalog so that the next request builds it again. The catalog is only built from
+// the config which the broker is started with and from the catalog file, so SetCatalog is the only caller. The feature
+// flags do not change the catalog: they are checked when an instance is provisioned.
+func (b *Broker) InvalidateCatalog() {
+	b.catalog.mutex.Lock()
+	defer b.catalog.mutex.Unlock()
+	b.catalog.body = nil
+	b.catalog.etag = ""
+}
+
+// cachedCatalog returns the serialized catalog and its ETag. The catalog is built when it is not cached.
+func (b *Broker) cachedCatalog(logger lager.Logger) ([]byte, string, error) {
+	b.catalog.mutex.Lock()
+	defer b.catalog.mutex.Unlock()
+
+	if b.catalog.body != nil {
+		return b.catalog.body, b.catalog.etag, nil
+	}
+
+	services, err := b.Services(context.Background())
+	if err != nil {
+		return nil, "", err
+	}
+	response := catalogResponse{}
+	for _, service := range services {
+		catalogService := catalogService{Service: service, InstancesRetrievable: true, BindingsRetrievable: true}
+		for _, plan := range service.Plans {
+			catalogService.Plans = append(catalogService.Plans, catalogPlan{ServicePlan: plan, Schemas: b.planSchemas(plan.ID)})
+		}
+		response.Services = append(response.Services, catalogService)
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		return nil, "", err
+	}
+	b.catalog.body = body
+	b.catalog.etag = fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+	logger.Info("catalog-cached", lager.Data{"etag": b.catalog.etag})
+	return b.catalog.body, b.catalog.etag, nil
+}
+
+// CatalogHandler serves the catalog from the cache with an ETag and answers 304 Not Modified when the If-None-Match
+// header of the request has the ETag. Any other request, and a catalog request without the credentials or a 2.x
+// version of the service broker API, is passed to the next handler so that the service broker API answers it as usual.
+func (b *Broker) CatalogHandler(credentials brokerapi.BrokerCredentials, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != CatalogPath || r.Method != http.MethodGet || !isBrokerAPIVersion2(r.Header.Get(BrokerAPIVersionHeader)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(credentials.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(credentials.Password)) != 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger := b.logger.Session("catalog")
+		body, etag, err := b.cachedCatalog(logger)
+		if err != nil {
+			logger.Error("cached-catalog", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	})
+}
+
+// isBrokerAPIVersion2 returns true if the version of the service broker API is 2.x, like the service broker API checks
+// it before it serves a request
+func isBrokerAPIVersion2(version string) bool {
+	var major, minor int
+	if n, err := fmt.Sscanf(version, "%d.%d", &major, &minor); err != nil || n != 2 {
+		return false
+	}
+	return major == 2
+}
+
+// etagMatches returns true if the value of an If-None-Match header has the ETag or is *
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package azurefilebroker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// planKindPreexisting plans only provision preexisting shares
+	planKindPreexisting = "preexisting"
+	// planKindAzureFileShare plans only provision AzureFileShare
+	planKindAzureFileShare = "azurefileshare"
+)
+
+// CatalogConfig is the service and the plans of the catalog defined in a file. Without it, the broker has the built-in
+// service of the flags serviceName and serviceID with the plans Existing and AzureFileShare. The plans of the isolation
+// segments are added to the plans of both.
+type CatalogConfig struct {
+	Service CatalogService
+
+	defined        bool
+	invalidCatalog error
+}
+
+// CatalogService is the service of the catalog file
+type CatalogService struct {
+	ID          string                     `json:"id"`
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Metadata    *brokerapi.ServiceMetadata `json:"metadata,omitempty"`
+	Plans       []CatalogPlan              `json:"plans"`
+}
+
+// CatalogPlan is a plan of the catalog file. Its name selects the options of the plan in the other configs, e.g. the
+// mount options of planMountOptions and the targets of planResourceGroups.
+type CatalogPlan struct {
+	ID          string                         `json:"id"`
+	Name        string                         `json:"name"`
+	Description string                         `json:"description"`
+	Free        *bool                          `json:"free,omitempty"`
+	Metadata    *brokerapi.ServicePlanMetadata `json:"metadata,omitempty"`
+	// Kind is preexisting or azurefileshare to restrict the instances of the plan to preexisting shares or to
+	// AzureFileShare. Without it, the parameter share of the provision chooses between them.
+	Kind string `json:"kind,omitempty"`
+}
+
+// NewCatalogConfig parses the catalog file, which is JSON or YAML, or returns an empty config without a catalog
+func NewCatalogConfig(catalog string) *CatalogConfig {
+	myConf := new(CatalogConfig)
+
+	if strings.TrimSpace(catalog) == "" {
+		return myConf
+	}
+	myConf.defined = true
+	// YAML is read as JSON so that both formats have the same keys and refuse the same unknown keys
+	var definition interface{}
+	if err := yaml.Unmarshal([]byte(catalog), &definition); err != nil {
+		myConf.invalidCatalog = err
+		return myConf
+	}
+	content, err := json.Marshal(jsonValue(definition))
+	if err != nil {
+		myConf.invalidCatalog = err
+		return myConf
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&myConf.Service); err != nil {
+		myConf.invalidCatalog = err
+		myConf.Service = CatalogService{}
+	}
+
+	return myConf
+}
+
+// jsonValue converts the maps of YAML, whose keys may be any value, to the maps of JSON
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			object[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return object
+	case []interface{}:
+		for i, item := range value {
+			value[i] = jsonValue(item)
+		}
+	}
+	return value
+}
+
+// IsEnabled returns true if the catalog is defined by a file
+func (config *CatalogConfig) IsEnabled() bool {
+	return config.defined && config.invalidCatalog == nil
+}
+
+// Validate checks the catalog file and that its plans do not take the IDs or the names of the plans of the segments
+func (config *CatalogConfig) Validate(segments *IsolationSegmentConfig) error {
+	if config.invalidCatalog != nil {
+		return fmt.Errorf("Invalid catalog in catalogPath: %v", config.invalidCatalog)
+	}
+	if !config.IsEnabled() {
+		return nil
+	}
+	service := config.Service
+	if service.ID == "" || service.Name == "" || service.Description == "" {
+		return fmt.Errorf("The service of catalogPath must have an id, a name and a description")
+	}
+	if len(service.Plans) == 0 {
+		return fmt.Errorf("The service %q of catalogPath has no plans", service.Name)
+	}
+	ids, names := map[string]bool{}, map[string]bool{}
+	for _, network := range segments.Networks {
+		ids[network.PlanID()], names[network.PlanName()] = true, true
+	}
+	for _, plan := range service.Plans {
+		if plan.ID == "" || plan.Name == "" || plan.Description == "" {
+			return fmt.Errorf("The plans of catalogPath must have an id, a name and a description")
+		}
+		if ids[plan.ID] || names[plan.Name] {
+			return fmt.Errorf("The plan %q of catalogPath is given more than once or takes the ID or the name of the plan of an isolation segment", plan.Name)
+		}
+		ids[plan.ID], names[plan.Name] = true, true
+		switch plan.Kind {
+		case "", planKindPreexisting, planKindAzureFileShare:
+		default:
+			return fmt.Errorf("Invalid kind %q of the plan %q in catalogPath. Expected %s or %s", plan.Kind, plan.Name, planKindPreexisting, planKindAzureFileShare)
+		}
+	}
+	return nil
+}
+
+// plan returns the plan of the catalog file with the ID or nil
+func (config *CatalogConfig) plan(planID string) *CatalogPlan {
+	for i := range config.Service.Plans {
+		if config.Service.Plans[i].ID == planID {
+			return &config.Service.Plans[i]
+		}
+	}
+	return nil
+}
+
+// plans returns the plans of the catalog file. The plans of the kind azurefileshare are left out unless azureFileShare
+// is true, like the built-in plan AzureFileShare, because the broker cannot provision their instances.
+func (config *CatalogConfig) plans(azureFileShare bool) []brokerapi.ServicePlan {
+	plans := []brokerapi.ServicePlan{}
+	for _, plan := range config.Service.Plans {
+		if plan.Kind == planKindAzureFileShare && !azureFileShare {
+			continue
+		}
+		plans = append(plans, brokerapi.ServicePlan{
+			ID:          plan.ID,
+			Name:        plan.Name,
+			Description: plan.Description,
+			Free:        plan.Free,
+			Metadata:    plan.Metadata,
+		})
+	}
+	return plans
+}
+
+// optionPlanNames returns the names of the plans which the options of the plans may name: the built-in plans, the
+// plans of the catalog file and the plans of the segments. Only the plans of AzureFileShare are returned unless
+// preexisting is true.
+func optionPlanNames(segments *IsolationSegmentConfig, catalog *CatalogConfig, preexisting bool) []string {
+	planNames := []string{"AzureFileShare"}
+	if preexisting {
+		planNames = []string{"Existing", "AzureFileShare"}
+	}
+	for _, plan := range catalog.Service.Plans {
+		if preexisting || plan.Kind != planKindPreexisting {
+			planNames = append(planNames, plan.Name)
+		}
+	}
+	for _, network := range segments.Networks {
+		planNames = append(planNames, network.PlanName())
+	}
+	return planNames
+}
+
+// SetCatalog replaces the built-in service and plans by the catalog file. The instances of the built-in plans keep the
+// options of these plans.
+func (b *Broker) SetCatalog(catalog *CatalogConfig) {
+	b.config.catalog = *catalog
+	if catalog.IsEnabled() {
+		b.static.ServiceID = catalog.Service.ID
+		b.static.ServiceName = catalog.Service.Name
+	}
+	b.InvalidateCatalog()
+}
+
+// planKind returns the kind of the instances of the plan, or "" if the plan provisions both
+func (b *Broker) planKind(planID string) string {
+	if plan := b.config.catalog.plan(planID); plan != nil {
+		return plan.Kind
+	}
+	if b.config.segments.network(planID) != nil {
+		return planKindAzureFileShare
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+package azurefilebroker
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// The compatibility of the brokers which run against the same database, e.g. the blue and the green broker of an
+// upgrade. brokerCompatibilityVersion increases when the broker adds a table or writes a record which older brokers do
+// not understand. A new version must keep the schema and the records usable by the previous version, which is
+// minBrokerCompatibilityVersion, so that both serve requests until the old one is stopped.
+const (
+	brokerCompatibilityVersion    = 2
+	minBrokerCompatibilityVersion = 1
+
+	schemaVersionID = "schema"
+)
+
+// SchemaVersion is the version of the schema of the store and the oldest broker which may still use it. It is recorded
+// by the newest broker which has run against the database.
+type SchemaVersion struct {
+	Version          int    `json:"version"`
+	MinBrokerVersion int    `json:"min_broker_version"`
+	BrokerVersion    string `json:"broker_version"` // The build of the broker which recorded it
+}
+
+// EnsureSchemaVersion checks that this broker may use the schema of the store and records its version unless a newer
+// broker has recorded one. It must be called after EnsureSchema. The broker refuses to start after the upgrade of the
+// database to a version which no longer supports it, e.g. after a rollback over more than one version.
+func (s *SqlStore) EnsureSchemaVersion(logger lager.Logger) error {
+	logger = logger.Session("ensure-schema-version").WithData(lager.Data{"brokerCompatibilityVersion": brokerCompatibilityVersion})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	current, found, err := s.retrieveSchemaVersion()
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve the version of the schema of the store: %v", err)
+	}
+	if err := current.checkBroker(); err != nil {
+		return err
+	}
+	if current.Version >= brokerCompatibilityVersion {
+		logger.Info("schema-version-kept", lager.Data{"schemaVersion": current})
+		return nil
+	}
+
+	schemaVersion := SchemaVersion{
+		Version:          brokerCompatibilityVersion,
+		MinBrokerVersion: minBrokerCompatibilityVersion,
+		BrokerVersion:    BrokerVersion,
+	}
+	jsonData, err := marshalStoreValue(tableSchemaVersions, schemaVersionID, schemaVersion)
+	if err != nil {
+		return err
+	}
+	if found {
+		query := fmt.Sprintf("UPDATE %s set value = ? WHERE id = ?", s.Database.GetTableName(tableSchemaVersions))
+		_, err = s.Database.Exec(query, jsonData, schemaVersionID)
+	} else {
+		query := fmt.Sprintf("INSERT INTO %s (id, value) VALUES (?, ?)", s.Database.GetTableName(tableSchemaVersions))
+		_, err = s.Database.Exec(query, schemaVersionID, jsonData)
+	}
+	if err != nil {
+		// Another broker may have recorded the version at the same time
+		if recorded, _, retrieveErr := s.retrieveSchemaVersion(); retrieveErr == nil && recorded.Version >= brokerCompatibilityVersion {
+			return recorded.checkBroker()
+		}
+		return fmt.Errorf("Failed to record the version of the schema of the store: %v", err)
+	}
+	logger.Info("schema-version-recorded", lager.Data{"previousSchemaVersion": current, "schemaVersion": schemaVersion})
+	return nil
+}
+
+// retrieveSchemaVersion returns the recorded version, which is zero when the database was used by older brokers only
+func (s *SqlStore) retrieveSchemaVersion() (SchemaVersion, bool, error) {
+	var value []byte
+	schemaVersion := SchemaVersion{}
+
+	query := fmt.Sprintf("SELECT value FROM %s WHERE id = ?", s.Database.GetTableName(tableSchemaVersions))
+	err := s.Database.QueryRow(query, schemaVersionID).Scan(&value)
+	if err == sql.ErrNoRows {
+		return schemaVersion, false, nil
+	} else if err != nil {
+		return schemaVersion, false, err
+	}
+	if err := json.Unmarshal(value, &schemaVersion); err != nil {
+		return schemaVersion, false, err
+	}
+	return schemaVersion, true, nil
+}
+
+// checkBroker returns an error if this broker is older than the brokers which may use the schema
+func (v SchemaVersion) checkBroker() error {
+	if v.MinBrokerVersion > brokerCompatibilityVersion {
+		return fmt.Errorf("The schema of the store was upgraded to version %d by the broker %s, which requires brokers of compatibility version %d or later. This broker is %d: deploy a newer broker", v.Version, v.BrokerVersion, v.MinBrokerVersion, brokerCompatibilityVersion)
+	}
+	return nil
+}
+
+// compatibilityStore refuses the records which were written by a newer broker for brokers newer than this one, so
+// that an old broker neither serves nor overwrites what it does not understand. The lists are returned as they are,
+// so that the counts of the background jobs include every record.
+type compatibilityStore struct {
+	Store
+}
+
+func newCompatibilityStore(store Store) Store {
+	return &compatibilityStore{Store: store}
+}
+
+// errRecordTooNew is retryable, so that the platform sends the request again, which may go to the newer broker
+func errRecordTooNew(kind, id string, minBrokerVersion int) error {
+	return newBrokerError(ErrCodeBrokerVersionTooOld, "The %s %q requires a broker of compatibility version %d or later, but this broker is %d: retry the request once the broker is upgraded", kind, id, minBrokerVersion, brokerCompatibilityVersion)
+}
+
+func (s *compatibilityStore) RetrieveServiceInstance(id string) (ServiceInstance, error) {
+	instance, err := s.Store.RetrieveServiceInstance(id)
+	if err == nil && instance.MinBrokerVersion > brokerCompatibilityVersion {
+		return ServiceInstance{}, errRecordTooNew("service instance", id, instance.MinBrokerVersion)
+	}
+	return instance, err
+}
+
+func (s *compatibilityStore) RetrieveBindingDetails(id string) (BindingDetails, error) {
+	details, err := s.Store.RetrieveBindingDetails(id)
+	if err == nil && details.MinBrokerVersion > brokerCompatibilityVersion {
+		return BindingDetails{}, errRecordTooNew("binding", id, details.MinBrokerVersion)
+	}
+	return details, err
+}
+
+// UpdateServiceInstance refuses the instances which the background jobs retrieved in a list
+func (s *compatibilityStore) UpdateServiceInstance(id string, instance ServiceInstance) error {
+	if instance.MinBrokerVersion > brokerCompatibilityVersion {
+		return errRecordTooNew("service instance", id, instance.MinBrokerVersion)
+	}
+	return s.Store.UpdateServiceInstance(id, instance)
+}
@@ -0,0 +1,114 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// CredentialTypeKey returns the key of the storage account in the mount config
+	CredentialTypeKey = "key"
+	// CredentialTypeSAS only returns the SAS token of the access policy of the bind parameters in the credentials
+	CredentialTypeSAS = "sas"
+	// CredentialTypeNone returns no credential, so the shares are mounted with an identity-based authentication, e.g.
+	// sec=krb5 in the mount options
+	CredentialTypeNone = "none"
+)
+
+// CredentialConfig is the type of the credential which the bindings of AzureFileShare plans return, keyed by the names
+// of the plans. The plans which are not in it return the key of the storage account.
+type CredentialConfig struct {
+	PlanCredentialTypes map[string]string
+
+	invalidEntries []string
+}
+
+// NewCredentialConfig parses a comma separated list of plans and the types of their credentials:
+//
+//	<plan name>=key|sas|none
+func NewCredentialConfig(planCredentialTypes string) *CredentialConfig {
+	myConf := new(CredentialConfig)
+
+	myConf.PlanCredentialTypes = map[string]string{}
+	for _, entry := range strings.Split(planCredentialTypes, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		pair := strings.SplitN(entry, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			myConf.invalidEntries = append(myConf.invalidEntries, entry)
+			continue
+		}
+		myConf.PlanCredentialTypes[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+
+	return myConf
+}
+
+// Validate checks the types and that the plans are AzureFileShare plans of the catalog
+func (config *CredentialConfig) Validate(segments *IsolationSegmentConfig, catalog *CatalogConfig) error {
+	if len(config.invalidEntries) > 0 {
+		return fmt.Errorf("Invalid entries in planCredentialTypes: %s. Expected <plan name>=<type>", strings.Join(config.invalidEntries, ", "))
+	}
+	planNames := optionPlanNames(segments, catalog, false)
+
+	names := []string{}
+	for name := range config.PlanCredentialTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !inArray(planNames, name) {
+			return fmt.Errorf("Unknown plan %q in planCredentialTypes: expected one of %s", name, strings.Join(planNames, ", "))
+		}
+		switch config.PlanCredentialTypes[name] {
+		case CredentialTypeKey, CredentialTypeSAS, CredentialTypeNone:
+		default:
+			return fmt.Errorf("Invalid credential type %q of the plan %q in planCredentialTypes: expected %s, %s or %s", config.PlanCredentialTypes[name], name, CredentialTypeKey, CredentialTypeSAS, CredentialTypeNone)
+		}
+	}
+	return nil
+}
+
+// credentialType returns the type of the credential of the plan
+func (config *CredentialConfig) credentialType(planName string) string {
+	if credentialType, ok := config.PlanCredentialTypes[planName]; ok {
+		return credentialType
+	}
+	return CredentialTypeKey
+}
+
+// planName returns the name of the plan in the catalog
+func (b *Broker) planName(planID string) string {
+	if plan := b.config.catalog.plan(planID); plan != nil {
+		return plan.Name
+	}
+	switch planID {
+	case planIDExisting:
+		return "Existing"
+	case planIDAzureFileShare:
+		return "AzureFileShare"
+	}
+	if network := b.config.segments.network(planID); network != nil {
+		return network.PlanName()
+	}
+	return ""
+}
+
+// instancePlanName returns the name of the plan of an instance. The AzureFileShare instances of older versions of the
+// broker may have no plan, so they are in the default plan.
+func (b *Broker) instancePlanName(serviceInstance *ServiceInstance) string {
+	if serviceInstance.IsPreexisting {
+		return "Existing"
+	}
+	if planName := b.planName(serviceInstance.PlanID); planName != "" {
+		return planName
+	}
+	return "AzureFileShare"
+}
+
+// bindCredentialType returns the type of the credential of a binding of an AzureFileShare instance
+func (b *Broker) bindCredentialType(serviceInstance *ServiceInstance) string {
+	return b.config.credentials.credentialType(b.instancePlanName(serviceInstance))
+}
@@ -0,0 +1,335 @@
+package azurefilebroker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	resty "gopkg.in/resty.v0"
+)
+
+// The kinds of the resources whose deletions are notified
+const (
+	DeletedResourceStorageAccount = "storage-account"
+	DeletedResourceFileShare      = "file-share"
+)
+
+// The initiators of the deletions besides the requests of the platform
+const (
+	deletionInitiatorDeprovision        = "deprovision"
+	deletionInitiatorUnbind             = "unbind"
+	deletionInitiatorPurger             = "scheduled-deletion-purger"
+	deletionInitiatorShareDeletionRetry = "share-deletion-retrier"
+	deletionInitiatorMigrator           = "storage-account-migrator"
+)
+
+// deletionNotificationTimeout bounds the request to the webhook and the conversation with the mail server, so that a
+// notifier which hangs does not keep its notifications in the background forever
+const deletionNotificationTimeout = 30 * time.Second
+
+// OriginatingIdentityHeader is the header of the Open Service Broker API with the platform and the user who sent a
+// request
+const OriginatingIdentityHeader = "X-Broker-API-Originating-Identity"
+
+type originatingIdentityKey struct{}
+
+// DeletionNotification tells the operators that the broker deleted a storage account or a file share. Initiator is the
+// operation or the background job which deleted it, and OriginatingIdentity the user of the platform who sent the
+// request, if the platform told it.
+type DeletionNotification struct {
+	Kind                string    `json:"kind"`
+	Timestamp           time.Time `json:"timestamp"`
+	BrokerInstanceID    string    `json:"broker_instance_id,omitempty"`
+	Initiator           string    `json:"initiator"`
+	OriginatingIdentity string    `json:"originating_identity,omitempty"`
+	ServiceInstanceID   string    `json:"service_instance_id,omitempty"`
+	OrganizationGUID    string    `json:"organization_guid,omitempty"`
+	SpaceGUID           string    `json:"space_guid,omitempty"`
+	SubscriptionID      string    `json:"subscription_id,omitempty"`
+	ResourceGroupName   string    `json:"resource_group_name,omitempty"`
+	StorageAccountName  string    `json:"storage_account_name,omitempty"`
+	FileShareName       string    `json:"file_share_name,omitempty"`
+}
+
+// Summary returns the notification in one line, e.g. for the subject of an email
+func (n DeletionNotification) Summary() string {
+	if n.Kind == DeletedResourceFileShare {
+		return fmt.Sprintf("azurefilebroker deleted the file share %q in %q", n.FileShareName, n.StorageAccountName)
+	}
+	return fmt.Sprintf("azurefilebroker deleted the storage account %q", n.StorageAccountName)
+}
+
+//go:generate counterfeiter -o ../azurefilebrokerfakes/fake_deletion_notifier.go . DeletionNotifier
+type DeletionNotifier interface {
+	Notify(notification DeletionNotification) error
+}
+
+type webhookDeletionNotifier struct {
+	url   string
+	token string
+}
+
+// NewWebhookDeletionNotifier returns a notifier which POSTs every notification as JSON to the url. The token is sent
+// as a bearer token if it is not empty.
+func NewWebhookDeletionNotifier(url, token string) DeletionNotifier {
+	return &webhookDeletionNotifier{url: url, token: token}
+}
+
+func (n *webhookDeletionNotifier) Notify(notification DeletionNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	request := resty.New().SetTimeout(deletionNotificationTimeout).R().
+		SetHeader("Content-Type", contentTypeJSON).
+		SetBody(body)
+	if n.token != "" {
+		request.SetAuthToken(n.token)
+	}
+	resp, err := request.Post(n.url)
+	if err != nil {
+		return err
+	}
+	if statusCode := resp.StatusCode(); statusCode < http.StatusOK || statusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("Error Code: %d, %v", statusCode, resp)
+	}
+	return nil
+}
+
+// SMTPConfig is the mail server which sends the deletion notifications to the operators
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// NewSMTPConfig parses the comma separated list of the recipients
+func NewSMTPConfig(host string, port int, username, password, from, to string) *SMTPConfig {
+	myConf := new(SMTPConfig)
+
+	myConf.Host = host
+	myConf.Port = port
+	myConf.Username = username
+	myConf.Password = password
+	myConf.From = from
+	myConf.To = []string{}
+	for _, address := range strings.Split(to, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			myConf.To = append(myConf.To, address)
+		}
+	}
+
+	return myConf
+}
+
+// IsEnabled returns true if the deletions are notified by email
+func (config *SMTPConfig) IsEnabled() bool {
+	return len(config.To) > 0
+}
+
+func (config *SMTPConfig) Validate() error {
+	if !config.IsEnabled() {
+		return nil
+	}
+	missingKeys := []string{}
+	if config.Host == "" {
+		missingKeys = append(missingKeys, "smtpHost")
+	}
+	if config.From == "" {
+		missingKeys = append(missingKeys, "smtpFrom")
+	}
+	if len(missingKeys) > 0 {
+		return errors.New("Missing required parameters when 'deletionNotificationEmails' is set: " + strings.Join(missingKeys, ", "))
+	}
+	if config.Port <= 0 || config.Port > 65535 {
+		return fmt.Errorf("Invalid smtpPort %d", config.Port)
+	}
+	return nil
+}
+
+type smtpDeletionNotifier struct {
+	config SMTPConfig
+}
+
+// NewSMTPDeletionNotifier returns a notifier which mails every notification to the recipients of the config. The
+// server must support STARTTLS when a username is set, so that the password is not sent in clear text.
+func NewSMTPDeletionNotifier(config *SMTPConfig) DeletionNotifier {
+	return &smtpDeletionNotifier{config: *config}
+}
+
+func (n *smtpDeletionNotifier) Notify(notification DeletionNotification) error {
+	details, err := json.MarshalIndent(notification, "", "  ")
+	if err != nil {
+		return err
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", notification.Summary())
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(&message, "%s by %s", notification.Summary(), notification.Initiator)
+	if notification.OriginatingIdentity != "" {
+		fmt.Fprintf(&message, " for %s", notification.OriginatingIdentity)
+	}
+	fmt.Fprintf(&message, ".\r\n\r\n%s\r\n", strings.Replace(string(details), "\n", "\r\n", -1))
+
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+	}
+	return n.sendMail(auth, message.Bytes())
+}
+
+// sendMail sends the message like smtp.SendMail, which has no timeout, within deletionNotificationTimeout
+func (n *smtpDeletionNotifier) sendMail(auth smtp.Auth, message []byte) error {
+	address := net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port))
+	conn, err := net.DialTimeout("tcp", address, deletionNotificationTimeout)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(deletionNotificationTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: n.config.Host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(n.config.From); err != nil {
+		return err
+	}
+	for _, to := range n.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// AddDeletionNotifier notifies the notifier of every storage account and file share which the broker deletes. The
+// resources which an operation deletes again because it failed to set them up are not notified.
+func (b *Broker) AddDeletionNotifier(notifier DeletionNotifier) {
+	b.deletionNotifiers = append(b.deletionNotifiers, notifier)
+}
+
+// notifyDeletion notifies the deletion of the storage account of the instance, or of its file share if it is given. The
+// notifiers are called in the background because the operation may hold the mutex of the broker and the locks of the
+// resources, which a slow webhook or mail server must not keep. A failure is only logged because the resource is
+// already deleted.
+func (b *Broker) notifyDeletion(logger lager.Logger, initiator, instanceID string, serviceInstance *ServiceInstance, fileShareName string) {
+	if len(b.deletionNotifiers) == 0 {
+		return
+	}
+	notification := DeletionNotification{
+		Kind:                DeletedResourceStorageAccount,
+		Timestamp:           b.clock.Now().UTC(),
+		BrokerInstanceID:    b.config.cloud.Azure.BrokerInstanceID,
+		Initiator:           initiator,
+		OriginatingIdentity: originatingIdentity(b.ctx),
+		ServiceInstanceID:   instanceID,
+		OrganizationGUID:    serviceInstance.OrganizationGUID,
+		SpaceGUID:           serviceInstance.SpaceGUID,
+		SubscriptionID:      serviceInstance.SubscriptionID,
+		ResourceGroupName:   serviceInstance.ResourceGroupName,
+		StorageAccountName:  serviceInstance.TargetName,
+		FileShareName:       fileShareName,
+	}
+	if fileShareName != "" {
+		notification.Kind = DeletedResourceFileShare
+	}
+	go b.sendDeletionNotification(logger, notification)
+}
+
+func (b *Broker) sendDeletionNotification(logger lager.Logger, notification DeletionNotification) {
+	notified := 0
+	for _, notifier := range b.deletionNotifiers {
+		if err := notifier.Notify(notification); err != nil {
+			logger.Error("notify-deletion", err, lager.Data{"kind": notification.Kind})
+			continue
+		}
+		notified++
+	}
+	logger.Info("deletion-notified", lager.Data{"kind": notification.Kind, "initiator": notification.Initiator, "notified": notified})
+}
+
+// OriginatingIdentityHandler passes the originating identity of the requests of the platform to the operations of the
+// broker, so that the deletions tell which user requested them
+func OriginatingIdentityHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if identity := parseOriginatingIdentity(r.Header.Get(OriginatingIdentityHeader)); identity != "" {
+			r = r.WithContext(withOriginatingIdentity(r.Context(), identity))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseOriginatingIdentity returns the platform and the user of the header, e.g. "cloudfoundry user_id=abc" for
+// "cloudfoundry eyJ1c2VyX2lkIjoiYWJjIn0=". The value is returned as it is when it cannot be decoded.
+func parseOriginatingIdentity(header string) string {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	if len(parts) != 2 {
+		return header
+	}
+	decoded, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return header
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal(decoded, &value); err != nil {
+		return header
+	}
+	if userID, ok := value["user_id"].(string); ok {
+		return fmt.Sprintf("%s user_id=%s", parts[0], userID)
+	}
+	return fmt.Sprintf("%s %s", parts[0], decoded)
+}
+
+func withOriginatingIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, originatingIdentityKey{}, identity)
+}
+
+// originatingIdentity returns the originating identity of the request of the context, or "" if it has none
+func originatingIdentity(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	identity, _ := ctx.Value(originatingIdentityKey{}).(string)
+	return identity
+}
@@ -0,0 +1,265 @@
+package azurefilebroker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Machine-readable codes of the errors returned by the broker. They are returned in the "error" field of the
+// OSB error response.
+const (
+	ErrCodeInvalidParameters             = "InvalidParameters"
+	ErrCodeStorageAccountNotFound        = "StorageAccountNotFound"
+	ErrCodeSubscriptionNotAccessible     = "SubscriptionNotAccessible"
+	ErrCodeResourceGroupNotFound         = "ResourceGroupNotFound"
+	ErrCodeStorageAccountOwnedByAnother  = "StorageAccountOwnedByAnotherSpace"
+	ErrCodeShareCreationForbidden        = "ShareCreationForbidden"
+	ErrCodeShareNotRegistered            = "ShareNotRegistered"
+	ErrCodeShareOwnershipNotProven       = "ShareOwnershipNotProven"
+	ErrCodeStorageAccountQuotaExceeded   = "StorageAccountQuotaExceeded"
+	ErrCodeLocationCapacityUnavailable   = "LocationCapacityUnavailable"
+	ErrCodeForeignFileSharesExist        = "ForeignFileSharesExist"
+	ErrCodeAzureThrottled                = "AzureThrottled"
+	ErrCodeAzureOperationFailed          = "AzureOperationFailed"
+	ErrCodeStoreOperationFailed          = "StoreOperationFailed"
+	ErrCodeOperationUnrecognized         = "OperationUnrecognized"
+	ErrCodeOperationInProgress           = "ConcurrencyError"
+	ErrCodeSynchronousBudgetExceeded     = "SynchronousBudgetExceeded"
+	ErrCodeOperationNotSupportedForShare = "OperationNotSupportedForPreexistingShare"
+	ErrCodeResourceNotFound              = "ResourceNotFound"
+	ErrCodeOperationTimedOut             = "OperationTimedOut"
+	ErrCodeRequestDenied                 = "RequestDenied"
+	ErrCodeValidationUnavailable         = "ValidationUnavailable"
+	ErrCodeNamingPolicyViolation         = "NamingPolicyViolation"
+	ErrCodeAzureAuthorizationFailed      = "AzureAuthorizationFailed"
+	ErrCodeAzureResourceNotFound         = "AzureResourceNotFound"
+	ErrCodeAzureConflict                 = "AzureConflict"
+	ErrCodeAzureQuotaExceeded            = "AzureQuotaExceeded"
+	ErrCodeBrokerVersionTooOld           = "BrokerVersionTooOld"
+	ErrCodeLockBreakNotSupported         = "LockBreakNotSupported"
+)
+
+var errorStatusCodes = map[string]int{
+	ErrCodeInvalidParameters:             http.StatusBadRequest,
+	ErrCodeStorageAccountNotFound:        http.StatusBadRequest,
+	ErrCodeSubscriptionNotAccessible:     http.StatusBadRequest,
+	ErrCodeResourceGroupNotFound:         http.StatusBadRequest,
+	ErrCodeStorageAccountOwnedByAnother:  http.StatusForbidden,
+	ErrCodeShareCreationForbidden:        http.StatusForbidden,
+	ErrCodeShareNotRegistered:            http.StatusForbidden,
+	ErrCodeShareOwnershipNotProven:       http.StatusForbidden,
+	ErrCodeStorageAccountQuotaExceeded:   http.StatusUnprocessableEntity,
+	ErrCodeLocationCapacityUnavailable:   http.StatusUnprocessableEntity,
+	ErrCodeForeignFileSharesExist:        http.StatusUnprocessableEntity,
+	ErrCodeAzureThrottled:                http.StatusTooManyRequests,
+	ErrCodeAzureOperationFailed:          http.StatusBadGateway,
+	ErrCodeStoreOperationFailed:          http.StatusInternalServerError,
+	ErrCodeOperationUnrecognized:         http.StatusBadRequest,
+	ErrCodeOperationInProgress:           http.StatusUnprocessableEntity,
+	ErrCodeSynchronousBudgetExceeded:     http.StatusInternalServerError,
+	ErrCodeOperationNotSupportedForShare: http.StatusBadRequest,
+	ErrCodeResourceNotFound:              http.StatusNotFound,
+	ErrCodeOperationTimedOut:             http.StatusGatewayTimeout,
+	ErrCodeRequestDenied:                 http.StatusForbidden,
+	ErrCodeValidationUnavailable:         http.StatusBadGateway,
+	ErrCodeNamingPolicyViolation:         http.StatusBadRequest,
+	ErrCodeAzureAuthorizationFailed:      http.StatusForbidden,
+	ErrCodeAzureResourceNotFound:         http.StatusBadRequest,
+	ErrCodeAzureConflict:                 http.StatusUnprocessableEntity,
+	ErrCodeAzureQuotaExceeded:            http.StatusUnprocessableEntity,
+	ErrCodeBrokerVersionTooOld:           http.StatusServiceUnavailable,
+	ErrCodeLockBreakNotSupported:         http.StatusNotImplemented,
+}
+
+const brokerErrorLoggerAction = "broker-error"
+
+const (
+	// Azure returns 429 when the requests are throttled
+	resourceThrottled     = "StatusCode=429"
+	restResourceThrottled = "StatusCode: 429"
+	restErrorThrottled    = "Error Code: 429"
+)
+
+var (
+	// Error codes of Azure Resource Manager when the subscription has reached the limit of storage accounts
+	quotaExceededMarkers = []string{"StorageAccountQuotaExceeded", "QuotaExceeded"}
+	// Error codes of Azure Resource Manager when the location cannot host the storage account
+	capacityUnavailableMarkers = []string{"SkuNotAvailable", "LocationNotAvailableForResourceType", "AllocationFailed"}
+	// Error codes of Azure Resource Manager and of the file service when the identity of the broker is not allowed
+	authorizationFailedMarkers = []string{"AuthorizationFailed", "AuthenticationFailed", "InvalidAuthenticationToken", "AuthorizationPermissionMismatch", "InsufficientAccountPermissions"}
+	// Error codes of Azure Resource Manager and of the file service when a resource does not exist
+	resourceNotFoundMarkers = []string{"ResourceNotFound", "ResourceGroupNotFound", "SubscriptionNotFound", "ShareNotFound", "ParentNotFound"}
+	// Error codes of Azure Resource Manager and of the file service when the state of a resource conflicts with the request
+	conflictMarkers = []string{"Conflict", "AlreadyExists", "AnotherOperationInProgress", "ShareBeingDeleted", "LeaseIdMissing"}
+	// Error codes of the file service when a quota of the storage account is reached
+	fileServiceQuotaMarkers = []string{"ShareQuotaExceeded", "ShareSnapshotCountExceeded", "ShareLimitExceeded"}
+
+	// The HTTP status of Azure in the errors of the REST client and of the SDKs
+	azureStatusCodePattern = regexp.MustCompile(`(?:Error Code: |StatusCode[=:] ?|HTTP CODE: )(\d{3})`)
+)
+
+const (
+	quotaExceededHint       = "Delete unused storage accounts in the subscription or request a quota increase from Azure support."
+	capacityUnavailableHint = "Use another location or SKU, or retry later."
+	authorizationFailedHint = "Ask the operator to grant the service principal access to the subscription, the resource group and the storage account."
+	resourceNotFoundHint    = "Check the subscription, the resource group and the storage account in the parameters."
+	conflictHint            = "Retry when the other operation on the resource has finished."
+	azureQuotaHint          = "Delete unused resources or ask the operator for a higher quota."
+	badRequestHint          = "Check the parameters of the request."
+	retryHint               = "The request can be retried."
+)
+
+// BrokerError is an error with a machine-readable code which is mapped to an OSB HTTP status
+type BrokerError struct {
+	Code    string
+	Message string
+}
+
+func (e *BrokerError) Error() string {
+	return e.Message
+}
+
+// StatusCode returns the HTTP status of the error in OSB responses
+func (e *BrokerError) StatusCode() int {
+	if statusCode, ok := errorStatusCodes[e.Code]; ok {
+		return statusCode
+	}
+	return http.StatusInternalServerError
+}
+
+// Retryable returns true when the same request may succeed later. The platform gets a 5xx or a 429 for these errors,
+// and a 4xx for the errors which need another request or an operator.
+func (e *BrokerError) Retryable() bool {
+	statusCode := e.StatusCode()
+	return statusCode >= http.StatusInternalServerError || statusCode == http.StatusTooManyRequests
+}
+
+func newBrokerError(code string, format string, a ...interface{}) *BrokerError {
+	return &BrokerError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// newAzureError wraps an error of Azure. The throttled requests and the failures of Azure can be retried later. The
+// errors which the same request gets again, like a missing permission or resource, are terminal and have a hint.
+func newAzureError(err error, format string, a ...interface{}) *BrokerError {
+	message := fmt.Sprintf("%s: %v", fmt.Sprintf(format, a...), err)
+	code := AzureErrorCode(err)
+	switch code {
+	case ErrCodeAzureAuthorizationFailed:
+		message = fmt.Sprintf("%s. %s", message, authorizationFailedHint)
+	case ErrCodeAzureResourceNotFound:
+		message = fmt.Sprintf("%s. %s", message, resourceNotFoundHint)
+	case ErrCodeAzureConflict:
+		message = fmt.Sprintf("%s. %s", message, conflictHint)
+	case ErrCodeAzureQuotaExceeded:
+		message = fmt.Sprintf("%s. %s", message, azureQuotaHint)
+	case ErrCodeInvalidParameters:
+		message = fmt.Sprintf("%s. %s", message, badRequestHint)
+	case ErrCodeAzureThrottled, ErrCodeAzureOperationFailed:
+		message = fmt.Sprintf("%s. %s", message, retryHint)
+	}
+	return &BrokerError{Code: code, Message: message}
+}
+
+// AzureErrorCode returns the broker error code of an error of Azure from the Azure error code, or else from its HTTP
+// status. The errors without either, like network errors, are failures which can be retried.
+func AzureErrorCode(err error) string {
+	text := err.Error()
+	switch {
+	case containsAny(text, []string{resourceThrottled, restResourceThrottled, restErrorThrottled}):
+		return ErrCodeAzureThrottled
+	case containsAny(text, quotaExceededMarkers), containsAny(text, fileServiceQuotaMarkers):
+		return ErrCodeAzureQuotaExceeded
+	case containsAny(text, authorizationFailedMarkers):
+		return ErrCodeAzureAuthorizationFailed
+	case containsAny(text, resourceNotFoundMarkers):
+		return ErrCodeAzureResourceNotFound
+	}
+
+	match := azureStatusCodePattern.FindStringSubmatch(text)
+	if match == nil {
+		return ErrCodeAzureOperationFailed
+	}
+	switch statusCode, _ := strconv.Atoi(match[1]); statusCode {
+	case http.StatusBadRequest:
+		return ErrCodeInvalidParameters
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrCodeAzureAuthorizationFailed
+	case http.StatusNotFound:
+		return ErrCodeAzureResourceNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return ErrCodeAzureConflict
+	}
+	if containsAny(text, conflictMarkers) {
+		return ErrCodeAzureConflict
+	}
+	return ErrCodeAzureOperationFailed
+}
+
+// newStorageAccountCreationError translates the quota and capacity errors of a storage account creation into messages
+// with remediation hints
+func newStorageAccountCreationError(err error, storageAccountName, location string) *BrokerError {
+	if containsAny(err.Error(), quotaExceededMarkers) {
+		return newBrokerError(ErrCodeStorageAccountQuotaExceeded, "The storage account %q cannot be created because the storage account quota of the subscription is exceeded. %s Error: %v", storageAccountName, quotaExceededHint, err)
+	}
+	if containsAny(err.Error(), capacityUnavailableMarkers) {
+		return newBrokerError(ErrCodeLocationCapacityUnavailable, "The storage account %q cannot be created because the location %q has no capacity for it. %s Error: %v", storageAccountName, location, capacityUnavailableHint, err)
+	}
+	return newAzureError(err, "Failed to create the storage account %q", storageAccountName)
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func newStoreError(err error, format string, a ...interface{}) *BrokerError {
+	return &BrokerError{Code: ErrCodeStoreOperationFailed, Message: fmt.Sprintf("%s: %v", fmt.Sprintf(format, a...), err)}
+}
+
+// goneOrStoreError returns gone when the store does not have the resource, so that the platform stops retrying its
+// deletion. The other errors of the store must not be reported as gone because the platform would forget a resource
+// which still exists, so they are returned as store errors which the platform retries.
+func goneOrStoreError(err error, gone error, format string, a ...interface{}) error {
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		return gone
+	}
+	if brokerError, ok := err.(*BrokerError); ok {
+		return brokerError
+	}
+	return newStoreError(err, format, a...)
+}
+
+// ErrorCode returns the machine-readable code of an error returned by the broker, or "" if it has none
+func ErrorCode(err error) string {
+	if brokerError, ok := err.(*BrokerError); ok {
+		return brokerError.Code
+	}
+	if failureResponse, ok := err.(*brokerapi.FailureResponse); ok {
+		if errorResponse, ok := failureResponse.ErrorResponse().(brokerapi.ErrorResponse); ok {
+			return errorResponse.Error
+		}
+	}
+	return ""
+}
+
+// toFailureResponse converts a BrokerError into the failure response of brokerapi so that the status and the code
+// are returned to the platform. Other errors are returned as they are.
+func toFailureResponse(err error) error {
+	brokerError, ok := err.(*BrokerError)
+	if !ok {
+		return err
+	}
+	return brokerapi.NewFailureResponseBuilder(
+		errors.New(brokerError.Message), brokerError.StatusCode(), brokerErrorLoggerAction,
+	).WithErrorKey(brokerError.Code).Build()
+}
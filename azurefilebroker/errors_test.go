@@ -0,0 +1,104 @@
+package azurefilebroker_test
+
+import (
+	"errors"
+	"net/http"
+
+	. "code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("BrokerError", func() {
+	It("should map the code to the HTTP status", func() {
+		Expect((&BrokerError{Code: ErrCodeShareCreationForbidden}).StatusCode()).To(Equal(http.StatusForbidden))
+		Expect((&BrokerError{Code: ErrCodeResourceGroupNotFound}).StatusCode()).To(Equal(http.StatusBadRequest))
+		Expect((&BrokerError{Code: ErrCodeAzureThrottled}).StatusCode()).To(Equal(http.StatusTooManyRequests))
+		Expect((&BrokerError{Code: "Unknown"}).StatusCode()).To(Equal(http.StatusInternalServerError))
+	})
+
+	Context("ErrorCode", func() {
+		It("should return the code of a broker error", func() {
+			Expect(ErrorCode(&BrokerError{Code: ErrCodeInvalidParameters})).To(Equal(ErrCodeInvalidParameters))
+		})
+
+		It("should return the error key of a failure response", func() {
+			Expect(ErrorCode(brokerapi.ErrAsyncRequired)).To(Equal("AsyncRequired"))
+		})
+
+		It("should return empty for other errors", func() {
+			Expect(ErrorCode(errors.New("error"))).To(BeEmpty())
+		})
+	})
+
+	Context("StatusCode of quota errors", func() {
+		It("should be unprocessable", func() {
+			Expect((&BrokerError{Code: ErrCodeStorageAccountQuotaExceeded}).StatusCode()).To(Equal(http.StatusUnprocessableEntity))
+			Expect((&BrokerError{Code: ErrCodeLocationCapacityUnavailable}).StatusCode()).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
+})
+
+var _ = Describe("AzureErrorCode", func() {
+	It("should classify the throttled requests as retryable", func() {
+		Expect(AzureErrorCode(errors.New("Error Code: 429, TooManyRequests: slow down"))).To(Equal(ErrCodeAzureThrottled))
+		Expect((&BrokerError{Code: ErrCodeAzureThrottled}).Retryable()).To(BeTrue())
+	})
+
+	It("should classify the failures of Azure as retryable", func() {
+		Expect(AzureErrorCode(errors.New("Error Code: 503, ServerBusy: try later"))).To(Equal(ErrCodeAzureOperationFailed))
+		Expect(AzureErrorCode(errors.New("dial tcp: i/o timeout"))).To(Equal(ErrCodeAzureOperationFailed))
+		Expect((&BrokerError{Code: ErrCodeAzureOperationFailed}).Retryable()).To(BeTrue())
+	})
+
+	It("should classify the authorization errors as terminal", func() {
+		Expect(AzureErrorCode(errors.New("Error Code: 403, AuthorizationFailed: no access"))).To(Equal(ErrCodeAzureAuthorizationFailed))
+		Expect(AzureErrorCode(errors.New("storage: service returned error: StatusCode=403, ErrorCode=AuthenticationFailed, ErrorMessage=bad key"))).To(Equal(ErrCodeAzureAuthorizationFailed))
+		Expect(AzureErrorCode(errors.New("HTTP CODE: 401"))).To(Equal(ErrCodeAzureAuthorizationFailed))
+		Expect((&BrokerError{Code: ErrCodeAzureAuthorizationFailed}).Retryable()).To(BeFalse())
+	})
+
+	It("should classify the missing resources as terminal", func() {
+		Expect(AzureErrorCode(errors.New("Error Code: 404, ResourceGroupNotFound: missing"))).To(Equal(ErrCodeAzureResourceNotFound))
+		Expect(AzureErrorCode(errors.New("StatusCode: 404 - not found"))).To(Equal(ErrCodeAzureResourceNotFound))
+		Expect((&BrokerError{Code: ErrCodeAzureResourceNotFound}).StatusCode()).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should classify the conflicts and the quotas as terminal", func() {
+		Expect(AzureErrorCode(errors.New("Error Code: 409, AnotherOperationInProgress: busy"))).To(Equal(ErrCodeAzureConflict))
+		Expect(AzureErrorCode(errors.New("StatusCode=409, ErrorCode=ShareBeingDeleted"))).To(Equal(ErrCodeAzureConflict))
+		Expect(AzureErrorCode(errors.New("Error Code: 409, QuotaExceeded: too many"))).To(Equal(ErrCodeAzureQuotaExceeded))
+		Expect((&BrokerError{Code: ErrCodeAzureConflict}).Retryable()).To(BeFalse())
+		Expect((&BrokerError{Code: ErrCodeAzureQuotaExceeded}).StatusCode()).To(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("should classify the requests which Azure refuses as invalid parameters", func() {
+		Expect(AzureErrorCode(errors.New("Error Code: 400, InvalidParameter: bad sku"))).To(Equal(ErrCodeInvalidParameters))
+	})
+})
+
+var _ = Describe("WithAzureRequestIDs", func() {
+	It("should append the request IDs of the response to the error", func() {
+		header := http.Header{}
+		header.Set("x-ms-request-id", "request-id")
+		header.Set("x-ms-correlation-request-id", "correlation-request-id")
+		err := WithAzureRequestIDs(errors.New("Error Code: 500, InternalError: failed"), header)
+		Expect(err).To(MatchError("Error Code: 500, InternalError: failed (x-ms-request-id: request-id, x-ms-correlation-request-id: correlation-request-id)"))
+	})
+
+	It("should append the request ID of a file service response", func() {
+		header := http.Header{}
+		header.Set("x-ms-request-id", "request-id")
+		Expect(WithAzureRequestIDs(errors.New("Error Code: 403"), header)).To(MatchError("Error Code: 403 (x-ms-request-id: request-id)"))
+	})
+
+	It("should return the error as is without request IDs", func() {
+		err := errors.New("Error Code: 500")
+		Expect(WithAzureRequestIDs(err, http.Header{})).To(Equal(err))
+	})
+
+	It("should return nil without an error", func() {
+		Expect(WithAzureRequestIDs(nil, http.Header{})).To(BeNil())
+	})
+})
@@ -0,0 +1,146 @@
+package azurefilebroker
+
+import (
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// The feature flags which override the control config at runtime, e.g. to freeze the creation of resources during an
+// incident without redeploying the broker
+const (
+	FeatureFlagAllowCreateStorageAccount    = "allow_create_storage_account"
+	FeatureFlagAllowCreateFileShare         = "allow_create_file_share"
+	FeatureFlagAllowDeleteStorageAccount    = "allow_delete_storage_account"
+	FeatureFlagAllowDeleteFileShare         = "allow_delete_file_share"
+	FeatureFlagAllowDeleteForeignFileShares = "allow_delete_foreign_file_shares"
+)
+
+const featureFlagsLockName = "feature-flags"
+
+// FeatureFlag is a feature flag which is set at runtime and stored in the store
+type FeatureFlag struct {
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FeatureFlagStatus is the effective value of a feature flag
+type FeatureFlagStatus struct {
+	Name       string     `json:"name"`
+	Enabled    bool       `json:"enabled"`
+	Default    bool       `json:"default"`    // the value in the control config when the broker started
+	Overridden bool       `json:"overridden"` // true if the value is set at runtime
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// featureFlagFields returns the fields of the control config which can be overridden by the feature flags
+func featureFlagFields(control *ControlConfig) map[string]*bool {
+	return map[string]*bool{
+		FeatureFlagAllowCreateStorageAccount:    &control.AllowCreateStorageAccount,
+		FeatureFlagAllowCreateFileShare:         &control.AllowCreateFileShare,
+		FeatureFlagAllowDeleteStorageAccount:    &control.AllowDeleteStorageAccount,
+		FeatureFlagAllowDeleteFileShare:         &control.AllowDeleteFileShare,
+		FeatureFlagAllowDeleteForeignFileShares: &control.AllowDeleteForeignFileShares,
+	}
+}
+
+// controlConfig returns the control config with the feature flags which are set at runtime. The control config of the
+// broker is used as is when the feature flags cannot be retrieved so that an unavailable store does not change what
+// the broker does. Nothing is created or deleted while the health of Azure is degraded.
+func (b *Broker) controlConfig(logger lager.Logger) ControlConfig {
+	control := b.config.cloud.Control
+	fields := featureFlagFields(&control)
+	if flags, err := b.readStore().RetrieveFeatureFlags(); err != nil {
+		logger.Error("retrieve-feature-flags", err)
+	} else {
+		for name, flag := range flags {
+			if field, ok := fields[name]; ok {
+				*field = flag.Enabled
+			}
+		}
+	}
+	if b.health.Degraded() {
+		logger.Info("azure-degraded-existing-resources-only")
+		for _, field := range fields {
+			*field = false
+		}
+	}
+	return control
+}
+
+// FeatureFlags returns the effective value of every feature flag sorted by name
+func (b *Broker) FeatureFlags() ([]FeatureFlagStatus, error) {
+	flags, err := b.readStore().RetrieveFeatureFlags()
+	if err != nil {
+		return nil, newStoreError(err, "Failed to retrieve the feature flags")
+	}
+
+	statuses := []FeatureFlagStatus{}
+	for name, field := range featureFlagFields(&b.config.cloud.Control) {
+		status := FeatureFlagStatus{Name: name, Enabled: *field, Default: *field}
+		if flag, ok := flags[name]; ok {
+			updatedAt := flag.UpdatedAt
+			status.Enabled = flag.Enabled
+			status.Overridden = true
+			status.UpdatedAt = &updatedAt
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses, nil
+}
+
+// SetFeatureFlag overrides the control config with the value until the feature flag is reset
+func (b *Broker) SetFeatureFlag(name string, enabled bool) error {
+	logger := b.logger.Session("set-feature-flag").WithData(lager.Data{"name": name, "enabled": enabled})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := checkFeatureFlagName(name); err != nil {
+		return err
+	}
+
+	if err := b.getLockForUpdate(featureFlagsLockName); err != nil {
+		logger.Error("get-lock-for-update", err)
+		return err
+	}
+	defer b.store.ReleaseLockForUpdate(featureFlagsLockName)
+
+	flags, err := b.store.RetrieveFeatureFlags()
+	if err != nil {
+		return newStoreError(err, "Failed to retrieve the feature flags")
+	}
+	flag := FeatureFlag{Enabled: enabled, UpdatedAt: b.clock.Now().UTC()}
+	if _, ok := flags[name]; ok {
+		err = b.store.UpdateFeatureFlag(name, flag)
+	} else {
+		err = b.store.CreateFeatureFlag(name, flag)
+	}
+	if err != nil {
+		return newStoreError(err, "Failed to save the feature flag %q in the store", name)
+	}
+	return nil
+}
+
+// ResetFeatureFlag restores the value of the feature flag in the control config of the broker
+func (b *Broker) ResetFeatureFlag(name string) error {
+	logger := b.logger.Session("reset-feature-flag").WithData(lager.Data{"name": name})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := checkFeatureFlagName(name); err != nil {
+		return err
+	}
+	if err := b.store.DeleteFeatureFlag(name); err != nil {
+		return newStoreError(err, "Failed to delete the feature flag %q from the store", name)
+	}
+	return nil
+}
+
+func checkFeatureFlagName(name string) error {
+	if _, ok := featureFlagFields(&ControlConfig{})[name]; !ok {
+		return newBrokerError(ErrCodeResourceNotFound, "Unknown feature flag %q", name)
+	}
+	return nil
+}
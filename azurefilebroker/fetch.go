@@ -0,0 +1,186 @@
+package azurefilebroker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// InstancesPath is the prefix of the paths of the service instances in the service broker API
+const InstancesPath = "/v2/service_instances/"
+
+// InstanceResponse is the body of the fetch of a service instance in the service broker API 2.14
+type InstanceResponse struct {
+	ServiceID  string          `json:"service_id"`
+	PlanID     string          `json:"plan_id"`
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+// BindingResponse is the body of the fetch of a service binding in the service broker API 2.14. The credentials and
+// the volume mounts are rebuilt for the file shares of the broker, which the platform fetches after an asynchronous
+// bind. They are not returned for the preexisting shares, whose passwords are not stored.
+type BindingResponse struct {
+	Credentials  interface{}             `json:"credentials,omitempty"`
+	VolumeMounts []brokerapi.VolumeMount `json:"volume_mounts,omitempty"`
+	Parameters   *BindOptions            `json:"parameters,omitempty"`
+}
+
+// FetchHandler serves the fetch of the service instances and the service bindings of the service broker API 2.14,
+// which the vendored service broker API does not route. Any other request, and a fetch without the credentials, is
+// passed to the next handler so that the service broker API answers it as usual.
+func (b *Broker) FetchHandler(credentials brokerapi.BrokerCredentials, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, InstancesPath) || r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The last operations of the instances and the bindings are answered by the service broker API
+		segments := strings.Split(strings.TrimPrefix(r.URL.Path, InstancesPath), "/")
+		if (len(segments) != 1 && (len(segments) != 3 || segments[1] != "service_bindings")) || containsString(segments, "") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(credentials.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(credentials.Password)) != 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var response interface{}
+		var err error
+		if len(segments) == 1 {
+			response, err = b.GetInstance(segments[0])
+		} else {
+			response, err = b.GetBinding(segments[0], segments[2])
+		}
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, response)
+	})
+}
+
+// GetInstance returns the plan and the provision parameters of the service instance without the secrets. The
+// parameters of the instances which were created by older versions of the broker are rebuilt from the instance.
+func (b *Broker) GetInstance(instanceID string) (InstanceResponse, error) {
+	logger := b.logger.Session("get-instance").WithData(lager.Data{"instanceID": instanceID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	serviceInstance, err := b.readStore().RetrieveServiceInstance(instanceID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		return InstanceResponse{}, newBrokerError(ErrCodeResourceNotFound, "The service instance %q does not exist", instanceID)
+	} else if err != nil {
+		logger.Error("retrieve-service-instance", err)
+		return InstanceResponse{}, newStoreError(err, "Failed to retrieve the service instance %q", instanceID)
+	}
+	// An instance which is being provisioned cannot be fetched yet
+	switch serviceInstance.ProvisioningState {
+	case provisioningStatePending, provisioningStateCreating, provisioningStateFailed:
+		return InstanceResponse{}, newBrokerError(ErrCodeResourceNotFound, "The service instance %q is not provisioned", instanceID)
+	}
+
+	parameters := serviceInstance.ProvisionParameters
+	if len(parameters) == 0 {
+		if parameters, err = json.Marshal(instanceConfiguration(serviceInstance)); err != nil {
+			return InstanceResponse{}, err
+		}
+	}
+	return InstanceResponse{ServiceID: serviceInstance.ServiceID, PlanID: serviceInstance.PlanID, Parameters: parameters}, nil
+}
+
+// GetBinding returns the bind options of the binding without the secrets, and the credentials and the volume mounts of
+// the bindings of the file shares of the broker
+func (b *Broker) GetBinding(instanceID, bindingID string) (BindingResponse, error) {
+	logger := b.logger.Session("get-binding").WithData(lager.Data{"instanceID": instanceID, "bindingID": bindingID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	bindingDetails, err := b.readStore().RetrieveBindingDetails(bindingID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		return BindingResponse{}, newBrokerError(ErrCodeResourceNotFound, "The service binding %q does not exist", bindingID)
+	} else if err != nil {
+		logger.Error("retrieve-binding-details", err)
+		return BindingResponse{}, newStoreError(err, "Failed to retrieve the service binding %q", bindingID)
+	}
+	options := bindingDetails.BindOptions
+	if options == nil {
+		// Bindings created by older versions of the broker only have the raw parameters
+		options = &BindOptions{}
+		if err := json.Unmarshal(bindingDetails.RawParameters, options); err != nil && len(bindingDetails.RawParameters) > 0 {
+			logger.Error("decode-bind-raw-parameters", err)
+			return BindingResponse{}, brokerapi.ErrRawParamsInvalid
+		}
+	}
+	belongs, err := b.bindingBelongsTo(bindingDetails, instanceID, options.FileShareName)
+	if err != nil {
+		logger.Error("check-binding-instance", err)
+		return BindingResponse{}, err
+	}
+	if !belongs {
+		return BindingResponse{}, newBrokerError(ErrCodeResourceNotFound, "The service binding %q does not belong to the service instance %q", bindingID, instanceID)
+	}
+	redacted := options.redacted()
+	response := BindingResponse{Parameters: &redacted}
+	if bindingDetails.FileShareID != "" && bindingDetails.BindOptions != nil {
+		binding, err := b.rebuildBinding(instanceID, bindingID, bindingDetails)
+		if err == brokerapi.ErrBindingDoesNotExist {
+			return BindingResponse{}, newBrokerError(ErrCodeResourceNotFound, "The service binding %q does not exist", bindingID)
+		} else if err != nil {
+			logger.Error("rebuild-binding", err)
+			return BindingResponse{}, err
+		}
+		response.Credentials = binding.Credentials
+		response.VolumeMounts = binding.VolumeMounts
+	}
+	return response, nil
+}
+
+// bindingBelongsTo returns true if the binding belongs to the service instance. The bindings of older versions of the
+// broker do not have their instance, so their file share is compared instead. The ones of preexisting shares have
+// neither, so the instance must at least exist.
+func (b *Broker) bindingBelongsTo(bindingDetails BindingDetails, instanceID, fileShareName string) (bool, error) {
+	switch {
+	case bindingDetails.InstanceID != "":
+		return bindingDetails.InstanceID == instanceID, nil
+	case bindingDetails.FileShareID != "":
+		return bindingDetails.FileShareID == getFileShareID(instanceID, fileShareName), nil
+	}
+	_, err := b.readStore().RetrieveServiceInstance(instanceID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		return false, nil
+	} else if err != nil {
+		return false, newStoreError(err, "Failed to retrieve the service instance %q", instanceID)
+	}
+	return true, nil
+}
+
+// instanceConfiguration returns the provision parameters of the configuration which the service instance uses, without
+// the secrets and the empty values
+func instanceConfiguration(serviceInstance ServiceInstance) map[string]string {
+	if serviceInstance.IsPreexisting {
+		return map[string]string{"share": serviceInstance.TargetName}
+	}
+	configuration := map[string]string{}
+	for key, value := range map[string]string{
+		"subscription_id":      serviceInstance.SubscriptionID,
+		"resource_group_name":  serviceInstance.ResourceGroupName,
+		"storage_account_name": serviceInstance.TargetName,
+		"location":             serviceInstance.Location,
+		"use_https":            serviceInstance.UseHTTPS,
+		"sku_name":             serviceInstance.SkuName,
+		"enable_encryption":    serviceInstance.EnableEncryption,
+	} {
+		if value != "" {
+			configuration[key] = value
+		}
+	}
+	return configuration
+}
@@ -0,0 +1,107 @@
+package azurefilebroker
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+func getFileShareOwnerID(serviceInstance *ServiceInstance, fileShareName string) string {
+	storageAccountOwnerID := getStorageAccountOwnerID(serviceInstance.SubscriptionID, serviceInstance.ResourceGroupName, serviceInstance.TargetName)
+	return fmt.Sprintf("%s-%s", storageAccountOwnerID, fileShareName)
+}
+
+// registerFileShareUser records that the instance of the file share uses the share in the storage account. If another
+// instance already created the share, the file share is marked as not created so that only one of them deletes it.
+// The caller must hold the lock of ownerID.
+func (b *Broker) registerFileShareUser(logger lager.Logger, ownerID string, share *FileShare) error {
+	logger = logger.Session("register-file-share-user").WithData(lager.Data{"ownerID": ownerID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	fileShareID := getFileShareID(share.InstanceID, share.FileShareName)
+	owner, err := b.store.RetrieveFileShareOwner(ownerID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		owner = FileShareOwner{
+			FileShareIDs:    []string{fileShareID},
+			DatabaseVersion: databaseVersion,
+		}
+		if share.IsCreated {
+			owner.OwnerFileShareID = fileShareID
+		}
+		if err := b.store.CreateFileShareOwner(ownerID, owner); err != nil {
+			return newStoreError(err, "Failed to insert the owner of the file share %q into the store", share.FileShareName)
+		}
+		return nil
+	} else if err != nil {
+		return newStoreError(err, "Failed to retrieve the owner of the file share %q", share.FileShareName)
+	}
+
+	if share.IsCreated {
+		if owner.OwnerFileShareID == "" {
+			owner.OwnerFileShareID = fileShareID
+		} else if owner.OwnerFileShareID != fileShareID {
+			logger.Info("file-share-created-by-another-instance", lager.Data{"owner": owner.OwnerFileShareID})
+			share.IsCreated = false
+		}
+	}
+	if !containsString(owner.FileShareIDs, fileShareID) {
+		owner.FileShareIDs = append(owner.FileShareIDs, fileShareID)
+	}
+	if err := b.store.UpdateFileShareOwner(ownerID, owner); err != nil {
+		return newStoreError(err, "Failed to update the owner of the file share %q in the store", share.FileShareName)
+	}
+	return nil
+}
+
+// unregisterFileShareUser removes the instance of the file share from the users of the share in the storage account.
+// It returns true if the share was created by the broker and no instance uses it any more.
+// The caller must hold the lock of ownerID.
+func (b *Broker) unregisterFileShareUser(logger lager.Logger, ownerID string, share *FileShare) (bool, error) {
+	logger = logger.Session("unregister-file-share-user").WithData(lager.Data{"ownerID": ownerID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	fileShareID := getFileShareID(share.InstanceID, share.FileShareName)
+	owner, err := b.store.RetrieveFileShareOwner(ownerID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		// The share is only used by file shares created by older versions of the broker
+		return share.IsCreated, nil
+	} else if err != nil {
+		return false, newStoreError(err, "Failed to retrieve the owner of the file share %q", share.FileShareName)
+	}
+
+	fileShareIDs := []string{}
+	for _, id := range owner.FileShareIDs {
+		if id != fileShareID {
+			fileShareIDs = append(fileShareIDs, id)
+		}
+	}
+	if share.IsCreated && owner.OwnerFileShareID == "" {
+		owner.OwnerFileShareID = fileShareID
+	}
+
+	if len(fileShareIDs) > 0 {
+		logger.Info("file-share-used-by-other-instances", lager.Data{"fileShareIDs": fileShareIDs})
+		owner.FileShareIDs = fileShareIDs
+		if err := b.store.UpdateFileShareOwner(ownerID, owner); err != nil {
+			return false, newStoreError(err, "Failed to update the owner of the file share %q in the store", share.FileShareName)
+		}
+		return false, nil
+	}
+
+	if err := b.store.DeleteFileShareOwner(ownerID); err != nil {
+		return false, newStoreError(err, "Failed to delete the owner of the file share %q from the store", share.FileShareName)
+	}
+	return owner.OwnerFileShareID != "", nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package azurefilebroker
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+const (
+	descriptionTag = "description"
+	costCenterTag  = "cost-center"
+
+	maxTagNameLength = 512
+)
+
+// Tags which are set by the broker itself and cannot be used as labels
+var reservedTags = []string{defaultUserAgent, creator, brokerInstanceIDTag, pendingDeletionMark, descriptionTag, costCenterTag}
+
+// InstanceMetadata is the user-visible metadata of a service instance. It is applied as tags on the storage accounts
+// created by the broker and does not change the storage resources.
+type InstanceMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Description string            `json:"description,omitempty"`
+	CostCenter  string            `json:"cost_center,omitempty"`
+}
+
+// UpdateParameters are the parameters accepted by Update. Fields which are not given are not changed, and a label with
+// an empty value is removed.
+type UpdateParameters struct {
+	Labels      map[string]string `json:"labels"`
+	Description *string           `json:"description"`
+	CostCenter  *string           `json:"cost_center"`
+	// ShareAccessPolicies replace the access policies of the file shares of the instance by their names
+	ShareAccessPolicies map[string][]ShareAccessPolicy `json:"share_access_policies"`
+	// MigrateTo copies the file shares of the instance to a new storage account and switches the instance to it
+	MigrateTo *MigrationParameters `json:"migrate_to"`
+	// Readonly switches the instance to or from the read-only mode
+	Readonly *bool `json:"readonly"`
+	// UseHTTPS and SkuName change the storage account which the broker created for the instance
+	UseHTTPS *bool   `json:"use_https"`
+	SkuName  *string `json:"sku_name"`
+}
+
+var updateParameterKeys = []string{"labels", "description", "cost_center", "share_access_policies", "migrate_to", "readonly", "use_https", "sku_name"}
+
+func parseUpdateParameters(rawParameters []byte) (UpdateParameters, error) {
+	parameters := UpdateParameters{}
+	if len(rawParameters) == 0 {
+		return parameters, nil
+	}
+
+	keys := map[string]json.RawMessage{}
+	if err := json.Unmarshal(rawParameters, &keys); err != nil {
+		return parameters, err
+	}
+	unknownKeys := []string{}
+	for key := range keys {
+		if !containsString(updateParameterKeys, key) {
+			unknownKeys = append(unknownKeys, key)
+		}
+	}
+	if len(unknownKeys) > 0 {
+		sort.Strings(unknownKeys)
+		return parameters, newBrokerError(ErrCodeInvalidParameters, "Unsupported parameters: %s. Only %s can be updated", strings.Join(unknownKeys, ", "), strings.Join(updateParameterKeys, ", "))
+	}
+
+	if err := json.Unmarshal(rawParameters, &parameters); err != nil {
+		return parameters, err
+	}
+	return parameters, nil
+}
+
+// apply returns the metadata updated with the parameters
+func (parameters UpdateParameters) apply(metadata InstanceMetadata) (InstanceMetadata, error) {
+	updated := InstanceMetadata{
+		Labels:      map[string]string{},
+		Description: metadata.Description,
+		CostCenter:  metadata.CostCenter,
+	}
+	for key, value := range metadata.Labels {
+		updated.Labels[key] = value
+	}
+
+	for key, value := range parameters.Labels {
+		if key == "" || len(key) > maxTagNameLength || strings.ContainsAny(key, `<>%&\?/`) {
+			return metadata, newBrokerError(ErrCodeInvalidParameters, "Invalid label %q: it must have at most %d characters and none of <>%%&\\?/", key, maxTagNameLength)
+		}
+		for _, reserved := range reservedTags {
+			if strings.EqualFold(key, reserved) {
+				return metadata, newBrokerError(ErrCodeInvalidParameters, "Invalid label %q: it is reserved by the broker", key)
+			}
+		}
+		if len(value) > maxTagValueLength {
+			return metadata, newBrokerError(ErrCodeInvalidParameters, "Invalid label %q: the value must not be longer than %d characters", key, maxTagValueLength)
+		}
+		if value == "" {
+			delete(updated.Labels, key)
+		} else {
+			updated.Labels[key] = value
+		}
+	}
+	if parameters.Description != nil {
+		updated.Description = *parameters.Description
+	}
+	if parameters.CostCenter != nil {
+		updated.CostCenter = *parameters.CostCenter
+	}
+	if len(updated.Description) > maxTagValueLength || len(updated.CostCenter) > maxTagValueLength {
+		return metadata, newBrokerError(ErrCodeInvalidParameters, "The description and the cost center must not be longer than %d characters", maxTagValueLength)
+	}
+	return updated, nil
+}
+
+// tagChanges returns the tags to set on the storage account to go from the previous metadata to this one. Removed
+// labels have an empty value.
+func (metadata InstanceMetadata) tagChanges(previous InstanceMetadata) map[string]string {
+	tags := map[string]string{
+		descriptionTag: metadata.Description,
+		costCenterTag:  metadata.CostCenter,
+	}
+	for key := range previous.Labels {
+		tags[key] = ""
+	}
+	for key, value := range metadata.Labels {
+		tags[key] = value
+	}
+	return tags
+}
@@ -0,0 +1,93 @@
+package azurefilebroker
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/tedsuo/ifrit"
+)
+
+// The longest TTL of an instance. Instances which should live longer do not need one.
+const maxInstanceTTLHours = 24 * 365
+
+// instanceExpiry returns when an instance provisioned now with the parameter ttl_hours expires, or nil when it does not
+func (b *Broker) instanceExpiry(configuration Configuration) (*time.Time, error) {
+	if configuration.TTLHours == 0 {
+		return nil, nil
+	}
+	if configuration.TTLHours < 0 || configuration.TTLHours > maxInstanceTTLHours {
+		return nil, newBrokerError(ErrCodeInvalidParameters, "Invalid ttl_hours %d: expected a number of hours between 1 and %d", configuration.TTLHours, maxInstanceTTLHours)
+	}
+	expiresAt := b.clock.Now().Add(time.Duration(configuration.TTLHours) * time.Hour).UTC()
+	return &expiresAt, nil
+}
+
+// isExpired returns true when the TTL of the instance is over
+func (instance ServiceInstance) isExpired(now time.Time) bool {
+	return instance.ExpiresAt != nil && !now.Before(*instance.ExpiresAt)
+}
+
+// InstanceExpirer returns a runner which periodically deprovisions the instances whose TTL is over
+func (b *Broker) InstanceExpirer(interval time.Duration) ifrit.Runner {
+	return b.newPeriodicRunner("instance-expirer", interval, func(logger lager.Logger) {
+		if err := b.DeprovisionExpiredInstances(logger); err != nil {
+			logger.Error("deprovision-expired-instances", err)
+		}
+	})
+}
+
+// DeprovisionExpiredInstances deprovisions the instances whose TTL is over. An instance which is still bound or has an
+// operation in progress is kept until a later run, so the apps never lose their file shares.
+func (b *Broker) DeprovisionExpiredInstances(logger lager.Logger) error {
+	logger = logger.Session("deprovision-expired-instances")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	serviceInstances, err := b.store.RetrieveServiceInstances()
+	if err != nil {
+		return err
+	}
+	shares, err := b.store.RetrieveFileShares()
+	if err != nil {
+		return err
+	}
+	boundInstances := map[string]bool{}
+	for _, share := range shares {
+		boundInstances[share.InstanceID] = true
+	}
+
+	now := b.clock.Now()
+	deprovisioned, kept := 0, 0
+	for instanceID, serviceInstance := range serviceInstances {
+		if !serviceInstance.isExpired(now) {
+			continue
+		}
+		if boundInstances[instanceID] {
+			logger.Info("expired-instance-bound", lager.Data{"instanceID": instanceID, "expiresAt": serviceInstance.ExpiresAt})
+			kept++
+			continue
+		}
+		if serviceInstance.ProvisioningState != provisioningStateSucceeded || serviceInstance.isMigrating() {
+			logger.Info("expired-instance-busy", lager.Data{"instanceID": instanceID, "provisioningState": serviceInstance.ProvisioningState})
+			kept++
+			continue
+		}
+
+		// The deletion is synchronous because no platform polls the last operation of the instance
+		_, err := b.Deprovision(withOriginatingIdentity(context.Background(), "azurefilebroker instance-expirer"), instanceID, brokerapi.DeprovisionDetails{
+			ServiceID: serviceInstance.ServiceID,
+			PlanID:    serviceInstance.PlanID,
+		}, false)
+		if err != nil {
+			logger.Error("deprovision-expired-instance", err, lager.Data{"instanceID": instanceID})
+			kept++
+			continue
+		}
+		logger.Info("expired-instance-deprovisioned", lager.Data{"instanceID": instanceID, "expiresAt": serviceInstance.ExpiresAt})
+		deprovisioned++
+	}
+	logger.Info("expired-instances", lager.Data{"deprovisioned": deprovisioned, "kept": kept})
+	return nil
+}
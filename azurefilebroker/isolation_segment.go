@@ -0,0 +1,118 @@
+package azurefilebroker
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var (
+	isolationSegmentNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	subnetIDPattern             = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Network/virtualNetworks/[^/]+/subnets/[^/]+$`)
+)
+
+// IsolationSegmentNetwork is the subnets of the Diego cells of an isolation segment. The storage accounts created for
+// the plan of the segment only allow these subnets.
+type IsolationSegmentNetwork struct {
+	Name      string
+	SubnetIDs []string
+}
+
+// PlanID returns the ID of the plan of the segment, which does not change while the name of the segment is kept
+func (network IsolationSegmentNetwork) PlanID() string {
+	hash := sha256.Sum256([]byte("azurefilebroker-isolation-segment-" + network.Name))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", hash[0:4], hash[4:6], hash[6:8], hash[8:10], hash[10:16])
+}
+
+// PlanName returns the name of the plan of the segment
+func (network IsolationSegmentNetwork) PlanName() string {
+	return "AzureFileShare-" + network.Name
+}
+
+// IsolationSegmentConfig is the networks of the isolation segments. Each segment has an AzureFileShare plan, which the
+// administrator makes available to the orgs of the segment.
+type IsolationSegmentConfig struct {
+	Networks []IsolationSegmentNetwork
+
+	invalidNetworks []string
+}
+
+// NewIsolationSegmentConfig parses a comma separated list of segments and the resource IDs of their subnets:
+//
+//	<segment>=<subnet ID>|<subnet ID>
+func NewIsolationSegmentConfig(segments string) *IsolationSegmentConfig {
+	myConf := new(IsolationSegmentConfig)
+
+	myConf.Networks = make([]IsolationSegmentNetwork, 0)
+	for _, segment := range strings.Split(segments, ",") {
+		if segment = strings.TrimSpace(segment); segment == "" {
+			continue
+		}
+		if network, ok := parseIsolationSegmentNetwork(segment); ok {
+			myConf.Networks = append(myConf.Networks, network)
+		} else {
+			myConf.invalidNetworks = append(myConf.invalidNetworks, segment)
+		}
+	}
+
+	return myConf
+}
+
+func parseIsolationSegmentNetwork(segment string) (IsolationSegmentNetwork, bool) {
+	parts := strings.SplitN(segment, "=", 2)
+	if len(parts) != 2 {
+		return IsolationSegmentNetwork{}, false
+	}
+	network := IsolationSegmentNetwork{Name: strings.TrimSpace(parts[0])}
+	if !isolationSegmentNamePattern.MatchString(network.Name) {
+		return IsolationSegmentNetwork{}, false
+	}
+	for _, subnetID := range strings.Split(parts[1], "|") {
+		subnetID = strings.TrimSpace(subnetID)
+		if !subnetIDPattern.MatchString(subnetID) {
+			return IsolationSegmentNetwork{}, false
+		}
+		network.SubnetIDs = append(network.SubnetIDs, subnetID)
+	}
+	return network, true
+}
+
+func (config *IsolationSegmentConfig) Validate() error {
+	if len(config.invalidNetworks) > 0 {
+		return fmt.Errorf("Invalid segments in isolationSegmentSubnets: %s. Expected <segment>=<subnet ID>|<subnet ID> with the resource IDs of the subnets", strings.Join(config.invalidNetworks, ", "))
+	}
+	names := map[string]bool{}
+	for _, network := range config.Networks {
+		if names[network.Name] {
+			return fmt.Errorf("The segment %q is given more than once in isolationSegmentSubnets", network.Name)
+		}
+		names[network.Name] = true
+	}
+	return nil
+}
+
+// network returns the network of the segment of the plan or nil if the plan is not the plan of a segment
+func (config *IsolationSegmentConfig) network(planID string) *IsolationSegmentNetwork {
+	for i := range config.Networks {
+		if config.Networks[i].PlanID() == planID {
+			return &config.Networks[i]
+		}
+	}
+	return nil
+}
+
+// plans returns the AzureFileShare plans of the segments
+func (config *IsolationSegmentConfig) plans() []brokerapi.ServicePlan {
+	plans := []brokerapi.ServicePlan{}
+	for _, network := range config.Networks {
+		plans = append(plans, brokerapi.ServicePlan{
+			Name:        network.PlanName(),
+			ID:          network.PlanID(),
+			Description: fmt.Sprintf("An Azure File Share filesystem which is only reachable from the isolation segment %s", network.Name),
+		})
+	}
+	return plans
+}
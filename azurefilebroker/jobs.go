@@ -0,0 +1,51 @@
+package azurefilebroker
+
+import (
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+// newPeriodicRunner returns a runner which calls the job at every interval until it is signaled. A call which is due
+// outside the maintenance windows of the job is deferred to the opening of the next window.
+func (b *Broker) newPeriodicRunner(session string, interval time.Duration, job func(lager.Logger)) ifrit.Runner {
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		logger := b.logger.Session(session)
+		ticker := b.clock.NewTicker(interval)
+		defer ticker.Stop()
+		// deferred is the timer of the opening of the next window while a call is deferred
+		var deferred clock.Timer
+		var opened <-chan time.Time
+		defer func() {
+			if deferred != nil {
+				deferred.Stop()
+			}
+		}()
+
+		close(ready)
+		for {
+			select {
+			case <-ticker.C():
+				if b.maintenanceWindows != nil {
+					if wait := b.maintenanceWindows.untilOpen(session, b.clock.Now()); wait > 0 {
+						if opened == nil {
+							logger.Info("deferred-to-maintenance-window", lager.Data{"wait": wait.String()})
+							deferred = b.clock.NewTimer(wait)
+							opened = deferred.C()
+						}
+						continue
+					}
+				}
+				job(logger)
+			case <-opened:
+				deferred, opened = nil, nil
+				job(logger)
+			case <-signals:
+				return nil
+			}
+		}
+	})
+}
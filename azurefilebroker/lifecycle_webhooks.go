@@ -0,0 +1,142 @@
+package azurefilebroker
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	resty "gopkg.in/resty.v0"
+)
+
+// The types of the lifecycle events of the storage which the broker manages
+const (
+	LifecycleEventShareCreate   = "share.create"
+	LifecycleEventShareDelete   = "share.delete"
+	LifecycleEventBindingCreate = "binding.create"
+	LifecycleEventBindingDelete = "binding.delete"
+)
+
+// LifecycleSignatureHeader is the header of the signature of a lifecycle event, which is sha256= followed by the hex
+// HMAC-SHA256 of the body with the secret of the webhooks
+const LifecycleSignatureHeader = "X-Azurefilebroker-Signature"
+
+// LifecycleEvent is sent to the lifecycle webhooks when the broker creates or deletes a file share or a binding, so
+// that external systems, e.g. backup schedulers, can react to it. ID is unique, so that a receiver can drop the events
+// which it already got.
+type LifecycleEvent struct {
+	ID                 string    `json:"id"`
+	Type               string    `json:"type"`
+	Timestamp          time.Time `json:"timestamp"`
+	BrokerInstanceID   string    `json:"broker_instance_id,omitempty"`
+	ServiceInstanceID  string    `json:"service_instance_id,omitempty"`
+	BindingID          string    `json:"service_binding_id,omitempty"`
+	OrganizationGUID   string    `json:"organization_guid,omitempty"`
+	SpaceGUID          string    `json:"space_guid,omitempty"`
+	AppGUID            string    `json:"app_guid,omitempty"`
+	SubscriptionID     string    `json:"subscription_id,omitempty"`
+	ResourceGroupName  string    `json:"resource_group_name,omitempty"`
+	StorageAccountName string    `json:"storage_account_name,omitempty"`
+	FileShareName      string    `json:"file_share_name,omitempty"`
+}
+
+//go:generate counterfeiter -o ../azurefilebrokerfakes/fake_lifecycle_webhook.go . LifecycleWebhook
+type LifecycleWebhook interface {
+	Send(event LifecycleEvent) error
+}
+
+type httpLifecycleWebhook struct {
+	urls   []string
+	secret string
+}
+
+// NewHTTPLifecycleWebhook returns a webhook which POSTs every event as JSON to each of the urls. The body is signed
+// with the secret in the header LifecycleSignatureHeader.
+func NewHTTPLifecycleWebhook(urls []string, secret string) LifecycleWebhook {
+	return &httpLifecycleWebhook{urls: urls, secret: secret}
+}
+
+// ParseLifecycleWebhookURLs parses a comma separated list of URLs
+func ParseLifecycleWebhookURLs(urls string) []string {
+	result := []string{}
+	for _, url := range strings.Split(urls, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			result = append(result, url)
+		}
+	}
+	return result
+}
+
+// SignLifecycleEvent returns the value of the header LifecycleSignatureHeader of the body of an event
+func SignLifecycleEvent(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send POSTs the event to every url, even when a url fails, and returns the failures
+func (w *httpLifecycleWebhook) Send(event LifecycleEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	signature := SignLifecycleEvent(w.secret, body)
+
+	failures := []string{}
+	for _, url := range w.urls {
+		resp, err := resty.R().
+			SetHeader("Content-Type", contentTypeJSON).
+			SetHeader(LifecycleSignatureHeader, signature).
+			SetBody(body).
+			Post(url)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", url, err))
+		} else if statusCode := resp.StatusCode(); statusCode < http.StatusOK || statusCode >= http.StatusMultipleChoices {
+			failures = append(failures, fmt.Sprintf("%s: Error Code: %d, %v", url, statusCode, resp))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Failed to send the lifecycle event to %d of %d webhooks: %s", len(failures), len(w.urls), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// SetLifecycleWebhook enables the lifecycle events of the file shares and the bindings
+func (b *Broker) SetLifecycleWebhook(webhook LifecycleWebhook) {
+	b.lifecycle = webhook
+}
+
+// sendLifecycleEvent sends the event of a file share or a binding of the instance. A failure is only logged so that the
+// operation itself is not failed by a receiver.
+func (b *Broker) sendLifecycleEvent(logger lager.Logger, eventType string, serviceInstance *ServiceInstance, event LifecycleEvent) {
+	if b.lifecycle == nil {
+		return
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		logger.Error("read-random", err)
+		return
+	}
+	event.ID = hex.EncodeToString(id)
+	event.Type = eventType
+	event.Timestamp = b.clock.Now().UTC()
+	event.BrokerInstanceID = b.config.cloud.Azure.BrokerInstanceID
+	event.OrganizationGUID = serviceInstance.OrganizationGUID
+	event.SpaceGUID = serviceInstance.SpaceGUID
+	if !serviceInstance.IsPreexisting {
+		event.SubscriptionID = serviceInstance.SubscriptionID
+		event.ResourceGroupName = serviceInstance.ResourceGroupName
+		event.StorageAccountName = serviceInstance.TargetName
+	}
+	if err := b.lifecycle.Send(event); err != nil {
+		logger.Error("send-lifecycle-event", err, lager.Data{"type": eventType})
+		return
+	}
+	logger.Info("lifecycle-event-sent", lager.Data{"type": eventType, "id": event.ID})
+}
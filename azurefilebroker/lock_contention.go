@@ -0,0 +1,84 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// LockHolder is the broker process which took a lock last. While the lock is held, it is the current holder.
+type LockHolder struct {
+	Owner           string    `json:"owner"`
+	AcquiredAt      time.Time `json:"acquired_at"`
+	DatabaseVersion string    `json:"database_version"`
+}
+
+// lockTimeoutInSeconds returns the wait for a lock of the timeout config
+func (b *Broker) lockTimeoutInSeconds() int {
+	if b.config.timeouts.Lock <= 0 {
+		return int(DefaultLockTimeout / time.Second)
+	}
+	return int(b.config.timeouts.Lock / time.Second)
+}
+
+// lockOwner identifies the broker process in the lock holders, e.g. the container of an app instance
+func (b *Broker) lockOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	if b.config.cloud.Azure.BrokerInstanceID != "" {
+		return fmt.Sprintf("%s/%s", b.config.cloud.Azure.BrokerInstanceID, hostname)
+	}
+	return hostname
+}
+
+// getLockForUpdate waits for the lock up to the lock timeout and records the wait in the metrics. The broker process
+// which holds the lock is added to the error when it is known from the store, which helps to debug bind storms.
+func (b *Broker) getLockForUpdate(lockName string) error {
+	logger := b.logger.Session("get-lock-for-update").WithData(lager.Data{"lockName": lockName})
+
+	start := b.clock.Now()
+	err := b.store.GetLockForUpdate(lockName, b.lockTimeoutInSeconds())
+	wait := b.clock.Since(start)
+	if b.metrics != nil {
+		result := metricResultSuccess
+		if err != nil {
+			result = metricResultFailure
+			b.metrics.IncrementCounter(metricLockTimeouts, map[string]string{"lock": lockName})
+		}
+		b.metrics.ObserveDuration(metricLockWaitDuration, map[string]string{"result": result}, wait)
+	}
+
+	if err != nil {
+		// The errors of a stopped operation are returned as is
+		if ErrorCode(err) == "" {
+			if holder, holderErr := b.store.RetrieveLockHolder(lockName); holderErr == nil {
+				err = fmt.Errorf("%v. The lock was taken by %s at %s", err, holder.Owner, holder.AcquiredAt.Format(time.RFC3339))
+			}
+		}
+		logger.Error("lock-wait-failed", err, lager.Data{"wait": wait.String()})
+		return err
+	}
+	b.recordLockHolder(logger, lockName)
+	return nil
+}
+
+// recordLockHolder records this process as the holder of a lock which it has just taken. A failure is only logged
+// because the holder is only informational.
+func (b *Broker) recordLockHolder(logger lager.Logger, lockName string) {
+	holder := LockHolder{
+		Owner:           b.lockOwner(),
+		AcquiredAt:      b.clock.Now().UTC(),
+		DatabaseVersion: databaseVersion,
+	}
+	// The holder of a lock is only written under the lock, so the update and the creation do not race
+	if err := b.store.UpdateLockHolder(lockName, holder); err == nil {
+		return
+	}
+	if err := b.store.CreateLockHolder(lockName, holder); err != nil {
+		logger.Error("create-lock-holder", err)
+	}
+}
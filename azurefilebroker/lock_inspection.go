@@ -0,0 +1,190 @@
+package azurefilebroker
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+//go:generate counterfeiter -o ../azurefilebrokerfakes/fake_lock_breaker.go . LockBreaker
+
+// LockBreaker finds the database sessions which hold the locks and ends them, e.g. the session of a broker process
+// which hangs with a lock. Ending a session releases all its locks. The file locks and the leases of the blobs have no
+// session which another process can end.
+type LockBreaker interface {
+	// LockSession returns the session which holds the lock, or "" if the lock is free
+	LockSession(lockName string) (string, error)
+	// BreakLock ends the session which holds the lock and returns it, or "" if the lock was free
+	BreakLock(lockName string) (string, error)
+}
+
+// LockStatus is a lock which was taken by a broker process, with the session which holds it when it is known
+type LockStatus struct {
+	Name            string    `json:"name"`
+	Owner           string    `json:"owner"`
+	AcquiredAt      time.Time `json:"acquired_at"`
+	AgeSeconds      int64     `json:"age_seconds"`
+	DatabaseVersion string    `json:"database_version"`
+	// Held is nil when the lock provider cannot tell whether the lock is held
+	Held    *bool  `json:"held,omitempty"`
+	Session string `json:"session,omitempty"`
+}
+
+// SetLockBreaker enables the force-unlock of the admin API and the sessions of the locks in the lock inspection
+func (b *Broker) SetLockBreaker(breaker LockBreaker) {
+	b.lockBreaker = breaker
+}
+
+// Locks returns the locks which the broker processes have taken, sorted by name. A lock holder is kept after the lock
+// is released, so the locks are only known to be held with a lock breaker.
+func (b *Broker) Locks(logger lager.Logger) ([]LockStatus, error) {
+	holders, err := b.store.RetrieveLockHolders()
+	if err != nil {
+		return nil, newStoreError(err, "Failed to retrieve the lock holders")
+	}
+	now := b.clock.Now()
+	locks := []LockStatus{}
+	for name, holder := range holders {
+		lock := b.lockStatus(name, holder, now)
+		if b.lockBreaker != nil {
+			session, err := b.lockBreaker.LockSession(name)
+			if err != nil {
+				logger.Error("lock-session", err, lager.Data{"lockName": name})
+			} else {
+				held := session != ""
+				lock.Held, lock.Session = &held, session
+			}
+		}
+		locks = append(locks, lock)
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Name < locks[j].Name })
+	return locks, nil
+}
+
+// ForceUnlock ends the session which holds the lock, so that the operations which wait for it go on. The session may
+// hold other locks, which are released too, and its operation fails when it uses the session again.
+func (b *Broker) ForceUnlock(logger lager.Logger, lockName string) (LockStatus, error) {
+	logger = logger.Session("force-unlock").WithData(lager.Data{"lockName": lockName})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if b.lockBreaker == nil {
+		return LockStatus{}, newBrokerError(ErrCodeLockBreakNotSupported, "The lock provider of the broker cannot release the locks of other sessions: release the lock %q in its backend", lockName)
+	}
+	lock := LockStatus{Name: lockName}
+	if holder, err := b.store.RetrieveLockHolder(lockName); err == nil {
+		lock = b.lockStatus(lockName, holder, b.clock.Now())
+	}
+	session, err := b.lockBreaker.BreakLock(lockName)
+	if err != nil {
+		logger.Error("break-lock", err)
+		return LockStatus{}, newStoreError(err, "Failed to release the lock %q", lockName)
+	}
+	if session == "" {
+		return LockStatus{}, newBrokerError(ErrCodeResourceNotFound, "The lock %q is not held", lockName)
+	}
+	held := false
+	lock.Held, lock.Session = &held, session
+	logger.Info("lock-force-released", lager.Data{"session": session, "owner": lock.Owner, "acquiredAt": lock.AcquiredAt})
+	return lock, nil
+}
+
+func (b *Broker) lockStatus(name string, holder LockHolder, now time.Time) LockStatus {
+	return LockStatus{
+		Name:            name,
+		Owner:           holder.Owner,
+		AcquiredAt:      holder.AcquiredAt,
+		AgeSeconds:      int64(now.Sub(holder.AcquiredAt) / time.Second),
+		DatabaseVersion: holder.DatabaseVersion,
+	}
+}
+
+// LockSession fails when more than one session may hold the lock, so that BreakLock does not end the wrong session
+func (p *sqlAppLockProvider) LockSession(lockName string) (string, error) {
+	rows, err := p.database.Query(p.database.GetAppLockSessionSQL(), p.appLockName(lockName))
+	if err != nil {
+		return "", fmt.Errorf("Cannot find the session which holds the lock %q. Error: %v", lockName, err)
+	}
+	defer rows.Close()
+
+	sessions := []string{}
+	for rows.Next() {
+		var session sql.NullString
+		if err := rows.Scan(&session); err != nil {
+			return "", fmt.Errorf("Cannot find the session which holds the lock %q. Error: %v", lockName, err)
+		}
+		if session.Valid && session.String != "" && !containsString(sessions, session.String) {
+			sessions = append(sessions, session.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("Cannot find the session which holds the lock %q. Error: %v", lockName, err)
+	}
+	switch len(sessions) {
+	case 0:
+		return "", nil
+	case 1:
+		return sessions[0], nil
+	}
+	return "", fmt.Errorf("Cannot find the session which holds the lock %q because the sessions %s hold locks of the same name prefix", lockName, strings.Join(sessions, ", "))
+}
+
+func (p *sqlAppLockProvider) BreakLock(lockName string) (string, error) {
+	session, err := p.LockSession(lockName)
+	if err != nil || session == "" {
+		return "", err
+	}
+	// The session is a number of the database, which is part of the statement because KILL takes no parameter
+	if _, err := strconv.ParseUint(session, 10, 64); err != nil {
+		return "", fmt.Errorf("Cannot end the session %q which holds the lock %q because it is not a number", session, lockName)
+	}
+	if _, err := p.database.Exec(p.database.GetKillSessionSQL(session)); err != nil {
+		return "", fmt.Errorf("Cannot end the session %s which holds the lock %q. Error: %v", session, lockName, err)
+	}
+	return session, nil
+}
+
+func (s *SqlStore) LockSession(lockName string) (string, error) {
+	return (&sqlAppLockProvider{database: s.Database}).LockSession(lockName)
+}
+
+func (s *SqlStore) BreakLock(lockName string) (string, error) {
+	return (&sqlAppLockProvider{database: s.Database}).BreakLock(lockName)
+}
+
+// postgresLockSessionSQL finds the backend which holds the advisory lock of pg_advisory_xact_lock(hashtext($1)). The
+// key of the lock is split into the high and the low 32 bits in pg_locks.
+const postgresLockSessionSQL = `SELECT pid FROM pg_locks WHERE locktype = 'advisory' AND granted AND objsubid = 1 ` +
+	`AND database = (SELECT oid FROM pg_database WHERE datname = current_database()) ` +
+	`AND classid = ((hashtext($1)::bigint >> 32) & 4294967295)::oid AND objid = (hashtext($1)::bigint & 4294967295)::oid`
+
+func (p *postgresLockProvider) LockSession(lockName string) (string, error) {
+	var pid sql.NullString
+	err := p.db.QueryRow(postgresLockSessionSQL, lockName).Scan(&pid)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("Cannot find the session which holds the lock %q. Error: %v", lockName, err)
+	}
+	return pid.String, nil
+}
+
+func (p *postgresLockProvider) BreakLock(lockName string) (string, error) {
+	session, err := p.LockSession(lockName)
+	if err != nil || session == "" {
+		return "", err
+	}
+	pid, err := strconv.Atoi(session)
+	if err != nil {
+		return "", fmt.Errorf("Cannot end the session %q which holds the lock %q because it is not a number", session, lockName)
+	}
+	if _, err := p.db.Exec("SELECT pg_terminate_backend($1)", pid); err != nil {
+		return "", fmt.Errorf("Cannot end the session %s which holds the lock %q. Error: %v", session, lockName, err)
+	}
+	return session, nil
+}
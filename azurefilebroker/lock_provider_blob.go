@@ -0,0 +1,170 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	resty "gopkg.in/resty.v0"
+)
+
+const (
+	// The version of the Blob service REST API of the leases
+	blobLeaseAPIVersion = "2018-03-28"
+	// A lease is acquired for blobLeaseDuration and renewed every blobLeaseRenewInterval while the lock is held, so a
+	// lock of a broker instance which stops is released after blobLeaseDuration
+	blobLeaseDuration      = 60 * time.Second
+	blobLeaseRenewInterval = 20 * time.Second
+)
+
+type blobLease struct {
+	id   string
+	stop chan struct{}
+}
+
+// blobLeaseLockProvider takes a lease on a blob per lock in a container
+// Reference: https://docs.microsoft.com/en-us/rest/api/storageservices/lease-blob
+type blobLeaseLockProvider struct {
+	logger       lager.Logger
+	clock        clock.Clock
+	containerURL *url.URL
+	mutex        sync.Mutex
+	// held is keyed by the name of the lock
+	held map[string]blobLease
+}
+
+// NewBlobLeaseLockProvider returns the provider of the leases of the blobs in a container. The URL has a SAS token
+// which allows to read, create and write the blobs.
+func NewBlobLeaseLockProvider(logger lager.Logger, clock clock.Clock, containerURL string) (LockProvider, error) {
+	u, err := url.Parse(containerURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URL of the container of the blob leases: %v", err)
+	}
+	return &blobLeaseLockProvider{
+		logger:       logger.Session("blob-lease-lock-provider"),
+		clock:        clock,
+		containerURL: u,
+		held:         map[string]blobLease{},
+	}, nil
+}
+
+// blobURL returns the URL of the blob of the lock with the query of the SAS token and the parameters
+func (p *blobLeaseLockProvider) blobURL(lockName string, parameters string) string {
+	u := *p.containerURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + lockObjectName(lockName)
+	if parameters != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += parameters
+	}
+	return u.String()
+}
+
+// createBlob creates the empty blob of the lock unless it exists
+func (p *blobLeaseLockProvider) createBlob(lockName string) error {
+	resp, err := resty.R().
+		SetHeader("x-ms-version", blobLeaseAPIVersion).
+		SetHeader("x-ms-blob-type", "BlockBlob").
+		SetHeader("If-None-Match", "*").
+		Put(p.blobURL(lockName, ""))
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode() {
+	case http.StatusCreated, http.StatusConflict, http.StatusPreconditionFailed:
+		return nil
+	}
+	return WithAzureRequestIDs(fmt.Errorf("Error Code: %d", resp.StatusCode()), resp.Header())
+}
+
+// lease runs the lease action on the blob of the lock and returns the status code and the lease ID of the response
+func (p *blobLeaseLockProvider) lease(lockName string, headers map[string]string) (int, string, error) {
+	request := resty.R().SetHeader("x-ms-version", blobLeaseAPIVersion)
+	for name, value := range headers {
+		request.SetHeader(name, value)
+	}
+	resp, err := request.Put(p.blobURL(lockName, "comp=lease"))
+	if err != nil {
+		return 0, "", err
+	}
+	return resp.StatusCode(), resp.Header().Get("x-ms-lease-id"), nil
+}
+
+func (p *blobLeaseLockProvider) GetLockForUpdate(lockName string, seconds int) error {
+	if err := p.createBlob(lockName); err != nil {
+		return fmt.Errorf("Cannot get the lock %q for update in %d seconds. Error: %v", lockName, seconds, err)
+	}
+
+	deadline := p.clock.Now().Add(time.Duration(seconds) * time.Second)
+	for {
+		statusCode, leaseID, err := p.lease(lockName, map[string]string{
+			"x-ms-lease-action":   "acquire",
+			"x-ms-lease-duration": fmt.Sprintf("%d", int(blobLeaseDuration.Seconds())),
+		})
+		if err != nil {
+			return fmt.Errorf("Cannot get the lock %q for update in %d seconds. Error: %v", lockName, seconds, err)
+		}
+		if statusCode == http.StatusCreated {
+			lease := blobLease{id: leaseID, stop: make(chan struct{})}
+			p.mutex.Lock()
+			p.held[lockName] = lease
+			p.mutex.Unlock()
+			go p.renew(lockName, lease)
+			return nil
+		}
+		if statusCode != http.StatusConflict {
+			return fmt.Errorf("Cannot get the lock %q for update in %d seconds. Error Code: %d", lockName, seconds, statusCode)
+		}
+		if !p.clock.Now().Before(deadline) {
+			return fmt.Errorf("Cannot get the lock %q for update in %d seconds because the lock has been obtained by some process", lockName, seconds)
+		}
+		p.clock.Sleep(lockPollInterval)
+	}
+}
+
+// renew renews the lease until the lock is released. A failure is only logged because the lease may still be renewed
+// before it expires.
+func (p *blobLeaseLockProvider) renew(lockName string, lease blobLease) {
+	ticker := p.clock.NewTicker(blobLeaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lease.stop:
+			return
+		case <-ticker.C():
+			statusCode, _, err := p.lease(lockName, map[string]string{"x-ms-lease-action": "renew", "x-ms-lease-id": lease.id})
+			if err == nil && statusCode != http.StatusOK {
+				err = fmt.Errorf("Error Code: %d", statusCode)
+			}
+			if err != nil {
+				p.logger.Error("renew-lease", err, lager.Data{"lockName": lockName})
+			}
+		}
+	}
+}
+
+func (p *blobLeaseLockProvider) ReleaseLockForUpdate(lockName string) error {
+	p.mutex.Lock()
+	lease, ok := p.held[lockName]
+	delete(p.held, lockName)
+	p.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Cannot release the lock %q for update because it is not held", lockName)
+	}
+	close(lease.stop)
+	statusCode, _, err := p.lease(lockName, map[string]string{"x-ms-lease-action": "release", "x-ms-lease-id": lease.id})
+	if err == nil && statusCode != http.StatusOK {
+		err = fmt.Errorf("Error Code: %d", statusCode)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot release the lock %q for update. Error: %v", lockName, err)
+	}
+	return nil
+}
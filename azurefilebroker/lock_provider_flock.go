@@ -0,0 +1,85 @@
+//go:build !windows
+// +build !windows
+
+package azurefilebroker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+)
+
+// fileLockProvider flocks a file per lock in a directory. The broker instances must share the directory, e.g. on an
+// NFS or an SMB mount which supports flock, or run on the same VM.
+type fileLockProvider struct {
+	clock     clock.Clock
+	directory string
+	mutex     sync.Mutex
+	// held is keyed by the name of the lock
+	held map[string]*os.File
+}
+
+func NewFileLockProvider(clock clock.Clock, directory string) (LockProvider, error) {
+	if directory == "" {
+		return nil, fmt.Errorf("The directory of the file locks is missing")
+	}
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("Cannot create the directory of the file locks %q. Error: %v", directory, err)
+	}
+	return &fileLockProvider{
+		clock:     clock,
+		directory: directory,
+		held:      map[string]*os.File{},
+	}, nil
+}
+
+func (p *fileLockProvider) GetLockForUpdate(lockName string, seconds int) error {
+	// The file is never removed because another broker instance may have opened it to wait for the lock
+	file, err := os.OpenFile(filepath.Join(p.directory, lockObjectName(lockName)), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("Cannot get the lock %q for update in %d seconds. Error: %v", lockName, seconds, err)
+	}
+
+	deadline := p.clock.Now().Add(time.Duration(seconds) * time.Second)
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != syscall.EWOULDBLOCK {
+			break
+		}
+		if !p.clock.Now().Before(deadline) {
+			file.Close()
+			return fmt.Errorf("Cannot get the lock %q for update in %d seconds because the lock has been obtained by some process", lockName, seconds)
+		}
+		p.clock.Sleep(lockPollInterval)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Cannot get the lock %q for update in %d seconds. Error: %v", lockName, seconds, err)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.held[lockName] = file
+	return nil
+}
+
+func (p *fileLockProvider) ReleaseLockForUpdate(lockName string) error {
+	p.mutex.Lock()
+	file, ok := p.held[lockName]
+	delete(p.held, lockName)
+	p.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Cannot release the lock %q for update because it is not held", lockName)
+	}
+	// Closing the file releases the flock
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("Cannot release the lock %q for update. Error: %v", lockName, err)
+	}
+	return nil
+}
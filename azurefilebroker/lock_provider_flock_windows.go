@@ -0,0 +1,12 @@
+package azurefilebroker
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/clock"
+)
+
+// NewFileLockProvider returns an error because flock is not available on Windows
+func NewFileLockProvider(clock clock.Clock, directory string) (LockProvider, error) {
+	return nil, fmt.Errorf("The file locks are not supported on Windows")
+}
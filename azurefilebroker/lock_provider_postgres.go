@@ -0,0 +1,67 @@
+package azurefilebroker
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"code.cloudfoundry.org/goshims/sqlshim"
+	_ "github.com/lib/pq"
+)
+
+// postgresLockProvider takes the advisory locks of Postgres. Every lock is taken in its own transaction, which keeps
+// its connection out of the pool until the lock is released, because an advisory lock belongs to a session.
+type postgresLockProvider struct {
+	db    sqlshim.SqlDB
+	mutex sync.Mutex
+	// held is keyed by the name of the lock
+	held map[string]*sql.Tx
+}
+
+func NewPostgresLockProvider(db sqlshim.SqlDB) LockProvider {
+	return &postgresLockProvider{
+		db:   db,
+		held: map[string]*sql.Tx{},
+	}
+}
+
+func (p *postgresLockProvider) GetLockForUpdate(lockName string, seconds int) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Cannot get the lock %q for update in %d seconds. Error: %v", lockName, seconds, err)
+	}
+	// lock_timeout cannot be a parameter. 0 waits forever, so a lock which is not waited for times out at once.
+	timeout := seconds * 1000
+	if timeout <= 0 {
+		timeout = 1
+	}
+	if _, err := tx.Exec(fmt.Sprintf("SET LOCAL lock_timeout = %d", timeout)); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Cannot get the lock %q for update in %d seconds. Error: %v", lockName, seconds, err)
+	}
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", lockName); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Cannot get the lock %q for update in %d seconds. Error: %v", lockName, seconds, err)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.held[lockName] = tx
+	return nil
+}
+
+func (p *postgresLockProvider) ReleaseLockForUpdate(lockName string) error {
+	// The transaction is removed before it ends so that a waiting GetLockForUpdate of the same lock can record its own
+	p.mutex.Lock()
+	tx, ok := p.held[lockName]
+	delete(p.held, lockName)
+	p.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Cannot release the lock %q for update because it is not held", lockName)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Cannot release the lock %q for update. Error: %v", lockName, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// The keys and the values which lager redacts by default. They are repeated because lager only applies them when no
+// pattern is given.
+var (
+	defaultRedactedKeyPatterns   = []string{"[Pp]wd", "[Pp]ass"}
+	defaultRedactedValuePatterns = []string{
+		`AKIA[A-Z0-9]{16}`,
+		`KEY["']?\s*(?::|=>|=)\s*["']?[A-Z0-9/\+=]{40}["']?`,
+		`\$1\$[A-Z0-9./]{1,16}\$[A-Z0-9./]{22}`,
+		`\$5\$[A-Z0-9./]{1,16}\$[A-Z0-9./]{43}`,
+		`\$6\$[A-Z0-9./]{1,16}\$[A-Z0-9./]{86}`,
+		`-----BEGIN(.*)PRIVATE KEY-----`,
+	}
+)
+
+// builtinRedactedValuePatterns are the secrets of Azure Storage which may be in the logged URLs and errors
+var builtinRedactedValuePatterns = []string{
+	// The signature of a SAS token, e.g. in a share URL
+	`(?i)(^|[?&])sig=[^&\s"]+`,
+	// The key or the SAS token in a connection string of a storage account
+	`(?i)(AccountKey|SharedAccessSignature)=[^;\s"]+`,
+}
+
+// RedactionConfig is the additional patterns of the keys and of the values of the log data which are replaced by
+// *REDACTED*. A value is redacted as a whole when a part of it matches.
+type RedactionConfig struct {
+	KeyPatterns   []string
+	ValuePatterns []string
+}
+
+// NewRedactionConfig parses comma separated lists of regular expressions. A comma in an expression is written \x2c.
+func NewRedactionConfig(keyPatterns, valuePatterns string) *RedactionConfig {
+	myConf := new(RedactionConfig)
+
+	myConf.KeyPatterns = splitRedactionPatterns(keyPatterns)
+	myConf.ValuePatterns = splitRedactionPatterns(valuePatterns)
+
+	return myConf
+}
+
+func splitRedactionPatterns(patterns string) []string {
+	result := []string{}
+	for _, pattern := range strings.Split(patterns, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			result = append(result, pattern)
+		}
+	}
+	return result
+}
+
+func (config *RedactionConfig) Validate() error {
+	for _, pattern := range config.KeyPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("Invalid regular expression in logRedactionKeys: %v", err)
+		}
+	}
+	for _, pattern := range config.ValuePatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("Invalid regular expression in logRedactionValues: %v", err)
+		}
+	}
+	return nil
+}
+
+// NewRedactingSink returns a sink which redacts the default and the built-in patterns and the patterns of the config
+func (config *RedactionConfig) NewRedactingSink(sink lager.Sink) (lager.Sink, error) {
+	keyPatterns := append(append([]string{}, defaultRedactedKeyPatterns...), config.KeyPatterns...)
+	valuePatterns := append(append(append([]string{}, defaultRedactedValuePatterns...), builtinRedactedValuePatterns...), config.ValuePatterns...)
+	return lager.NewRedactingSink(sink, keyPatterns, valuePatterns)
+}
@@ -0,0 +1,191 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// allBackgroundJobs is the job name of the maintenance windows which apply to every job without windows of its own
+const allBackgroundJobs = "*"
+
+// backgroundJobNames are the names of the runners of the background jobs, which are also the sessions of their logs
+var backgroundJobNames = []string{
+	"backup-protector",
+	"instance-expirer",
+	"resource-origin-collector",
+	"scheduled-deletion-purger",
+	"share-count-repairer",
+	"share-deletion-retrier",
+	"share-stats-collector",
+	"stale-binding-cleaner",
+	"storage-account-migrator",
+	"storage-account-pool-replenisher",
+}
+
+// MaintenanceWindow is a daily time range in minutes after midnight. End is before Start for a window over midnight.
+type MaintenanceWindow struct {
+	Start int
+	End   int
+}
+
+func (w MaintenanceWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// contains returns true if the minute of the day is in the window
+func (w MaintenanceWindow) contains(minute int) bool {
+	if w.Start < w.End {
+		return w.Start <= minute && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// MaintenanceWindowConfig restricts when the background jobs start, so that their requests to Azure do not coincide
+// with the busy hours of the platform. A job without windows, and without the windows of "*", runs at every interval.
+type MaintenanceWindowConfig struct {
+	Windows  map[string][]MaintenanceWindow
+	Location *time.Location
+
+	invalidEntries []string
+	locationError  error
+}
+
+// NewMaintenanceWindowConfig parses a comma separated list of background jobs and their daily windows in the time zone,
+// e.g. "storage-account-migrator=01:00-05:00,*=22:00-02:00;12:00-13:00" with "Europe/Berlin". The time zone is the
+// local one of the broker when it is empty.
+func NewMaintenanceWindowConfig(windows, timeZone string) *MaintenanceWindowConfig {
+	myConf := new(MaintenanceWindowConfig)
+
+	myConf.Windows = make(map[string][]MaintenanceWindow)
+	for _, entry := range strings.Split(windows, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		pair := strings.SplitN(entry, "=", 2)
+		job := strings.TrimSpace(pair[0])
+		if len(pair) != 2 || job == "" {
+			myConf.invalidEntries = append(myConf.invalidEntries, entry)
+			continue
+		}
+		jobWindows := []MaintenanceWindow{}
+		for _, timeRange := range strings.Split(pair[1], ";") {
+			window, ok := parseMaintenanceWindow(strings.TrimSpace(timeRange))
+			if !ok {
+				myConf.invalidEntries = append(myConf.invalidEntries, entry)
+				jobWindows = nil
+				break
+			}
+			jobWindows = append(jobWindows, window)
+		}
+		if jobWindows != nil {
+			myConf.Windows[job] = append(myConf.Windows[job], jobWindows...)
+		}
+	}
+
+	myConf.Location = time.Local
+	if timeZone = strings.TrimSpace(timeZone); timeZone != "" {
+		if location, err := time.LoadLocation(timeZone); err != nil {
+			myConf.locationError = err
+		} else {
+			myConf.Location = location
+		}
+	}
+
+	return myConf
+}
+
+// parseMaintenanceWindow parses <HH:MM>-<HH:MM>, where the end may be 24:00
+func parseMaintenanceWindow(timeRange string) (MaintenanceWindow, bool) {
+	parseMinute := func(value string) (int, bool) {
+		var hour, minute int
+		if n, err := fmt.Sscanf(value, "%d:%d", &hour, &minute); err != nil || n != 2 || len(value) != 5 {
+			return 0, false
+		}
+		if hour == 24 && minute == 0 {
+			return 24 * 60, true
+		}
+		return hour*60 + minute, hour >= 0 && hour < 24 && minute >= 0 && minute < 60
+	}
+	bounds := strings.SplitN(timeRange, "-", 2)
+	if len(bounds) != 2 {
+		return MaintenanceWindow{}, false
+	}
+	start, ok := parseMinute(strings.TrimSpace(bounds[0]))
+	if !ok || start == 24*60 {
+		return MaintenanceWindow{}, false
+	}
+	end, ok := parseMinute(strings.TrimSpace(bounds[1]))
+	if !ok || end == start {
+		return MaintenanceWindow{}, false
+	}
+	return MaintenanceWindow{Start: start, End: end % (24 * 60)}, true
+}
+
+// IsEnabled returns true if a background job has maintenance windows
+func (config *MaintenanceWindowConfig) IsEnabled() bool {
+	return len(config.Windows) > 0
+}
+
+// Validate checks the time ranges, the time zone and that the jobs are background jobs of the broker
+func (config *MaintenanceWindowConfig) Validate() error {
+	if len(config.invalidEntries) > 0 {
+		return fmt.Errorf("Invalid entries in maintenanceWindows: %s. Expected <job>=<HH:MM>-<HH:MM>;<HH:MM>-<HH:MM> with a start different from the end", strings.Join(config.invalidEntries, ", "))
+	}
+	if config.locationError != nil {
+		return fmt.Errorf("Invalid maintenanceWindowTimeZone: %v", config.locationError)
+	}
+	jobs := []string{}
+	for job := range config.Windows {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+	for _, job := range jobs {
+		if job != allBackgroundJobs && !inArray(backgroundJobNames, job) {
+			return fmt.Errorf("Unknown background job %q in maintenanceWindows: expected %s or one of %s", job, allBackgroundJobs, strings.Join(backgroundJobNames, ", "))
+		}
+	}
+	return nil
+}
+
+// windows returns the windows of the job, or nil if it runs at any time
+func (config *MaintenanceWindowConfig) windows(job string) []MaintenanceWindow {
+	if windows, ok := config.Windows[job]; ok {
+		return windows
+	}
+	return config.Windows[allBackgroundJobs]
+}
+
+// untilOpen returns 0 if the job may start at the time, or else how long until its next window opens
+func (config *MaintenanceWindowConfig) untilOpen(job string, now time.Time) time.Duration {
+	windows := config.windows(job)
+	if len(windows) == 0 {
+		return 0
+	}
+	local := now.In(config.Location)
+	minute := local.Hour()*60 + local.Minute()
+	var wait time.Duration
+	for _, window := range windows {
+		if window.contains(minute) {
+			return 0
+		}
+		// The dates are built in the time zone, so that a window opens at its time of day over the changes of DST
+		start := time.Date(local.Year(), local.Month(), local.Day(), window.Start/60, window.Start%60, 0, 0, config.Location)
+		if !start.After(local) {
+			start = time.Date(local.Year(), local.Month(), local.Day()+1, window.Start/60, window.Start%60, 0, 0, config.Location)
+		}
+		if until := start.Sub(local); wait == 0 || until < wait {
+			wait = until
+		}
+	}
+	return wait
+}
+
+// SetMaintenanceWindows restricts when the background jobs start. A job which is due outside its windows runs once
+// when its next window opens.
+func (b *Broker) SetMaintenanceWindows(config *MaintenanceWindowConfig) {
+	if config.IsEnabled() {
+		b.maintenanceWindows = config
+	}
+}
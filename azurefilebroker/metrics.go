@@ -0,0 +1,242 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// The metrics recorded by the broker, the store and the Azure clients
+const (
+	metricOperations             = "azurefilebroker_operations_total"
+	metricOperationDuration      = "azurefilebroker_operation_duration_seconds"
+	metricStoreCalls             = "azurefilebroker_store_calls_total"
+	metricStoreCallDuration      = "azurefilebroker_store_call_duration_seconds"
+	metricAzureRequestFailures   = "azurefilebroker_azure_request_failures_total"
+	metricAzureThrottledRequests = "azurefilebroker_azure_throttled_requests_total"
+	metricAzureDegraded          = "azurefilebroker_azure_degraded"
+	metricLockWaitDuration       = "azurefilebroker_lock_wait_duration_seconds"
+	metricLockTimeouts           = "azurefilebroker_lock_timeouts_total"
+	metricResultSuccess          = "success"
+	metricResultFailure          = "failure"
+	metricErrorCodeUnknown       = "Unknown"
+	metricsBackendNoop           = "noop"
+	metricsBackendPrometheus     = "prometheus"
+	prometheusContentType        = "text/plain; version=0.0.4"
+)
+
+//go:generate counterfeiter -o ../azurefilebrokerfakes/fake_metrics.go . Metrics
+type Metrics interface {
+	// IncrementCounter adds one to the counter of the labels
+	IncrementCounter(name string, labels map[string]string)
+	// ObserveDuration records a duration, e.g. of an operation, under the labels
+	ObserveDuration(name string, labels map[string]string, duration time.Duration)
+	// SetGauge sets the current value of the gauge of the labels, e.g. 1 while the broker is degraded
+	SetGauge(name string, labels map[string]string, value float64)
+}
+
+// NewMetrics returns the metrics of the backend, which is "noop" or "prometheus"
+func NewMetrics(backend string) (Metrics, error) {
+	switch backend {
+	case "", metricsBackendNoop:
+		return NewNoopMetrics(), nil
+	case metricsBackendPrometheus:
+		return NewPrometheusMetrics(), nil
+	}
+	return nil, fmt.Errorf("Invalid metrics backend %q. Expected %s or %s", backend, metricsBackendNoop, metricsBackendPrometheus)
+}
+
+type noopMetrics struct{}
+
+// NewNoopMetrics returns metrics which record nothing. The admin API still has the snapshot of the broker.
+func NewNoopMetrics() Metrics {
+	return noopMetrics{}
+}
+
+func (noopMetrics) IncrementCounter(name string, labels map[string]string) {}
+
+func (noopMetrics) ObserveDuration(name string, labels map[string]string, duration time.Duration) {}
+
+func (noopMetrics) SetGauge(name string, labels map[string]string, value float64) {}
+
+// PrometheusMetrics keeps the metrics in memory and serves them in the text format of Prometheus. The durations are
+// exposed as summaries with a sum and a count.
+type PrometheusMetrics struct {
+	mutex     sync.Mutex
+	counters  map[string]map[string]float64
+	gauges    map[string]map[string]float64
+	durations map[string]map[string]*durationSummary
+}
+
+type durationSummary struct {
+	sum   float64
+	count int64
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		counters:  map[string]map[string]float64{},
+		gauges:    map[string]map[string]float64{},
+		durations: map[string]map[string]*durationSummary{},
+	}
+}
+
+func (m *PrometheusMetrics) IncrementCounter(name string, labels map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.counters[name] == nil {
+		m.counters[name] = map[string]float64{}
+	}
+	m.counters[name][prometheusLabels(labels)]++
+}
+
+func (m *PrometheusMetrics) SetGauge(name string, labels map[string]string, value float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.gauges[name] == nil {
+		m.gauges[name] = map[string]float64{}
+	}
+	m.gauges[name][prometheusLabels(labels)] = value
+}
+
+func (m *PrometheusMetrics) ObserveDuration(name string, labels map[string]string, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.durations[name] == nil {
+		m.durations[name] = map[string]*durationSummary{}
+	}
+	key := prometheusLabels(labels)
+	summary, ok := m.durations[name][key]
+	if !ok {
+		summary = &durationSummary{}
+		m.durations[name][key] = summary
+	}
+	summary.sum += duration.Seconds()
+	summary.count++
+}
+
+// ServeHTTP writes the metrics sorted by their names and labels
+// Reference: https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	families := map[string][]string{}
+	for name, series := range m.counters {
+		families[name] = []string{fmt.Sprintf("# TYPE %s counter", name)}
+		for labels, value := range series {
+			families[name] = append(families[name], fmt.Sprintf("%s%s %g", name, labels, value))
+		}
+	}
+	for name, series := range m.gauges {
+		families[name] = []string{fmt.Sprintf("# TYPE %s gauge", name)}
+		for labels, value := range series {
+			families[name] = append(families[name], fmt.Sprintf("%s%s %g", name, labels, value))
+		}
+	}
+	for name, series := range m.durations {
+		families[name] = []string{fmt.Sprintf("# TYPE %s summary", name)}
+		for labels, summary := range series {
+			families[name] = append(families[name],
+				fmt.Sprintf("%s_count%s %d", name, labels, summary.count),
+				fmt.Sprintf("%s_sum%s %g", name, labels, summary.sum))
+		}
+	}
+	names := []string{}
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	w.WriteHeader(http.StatusOK)
+	for _, name := range names {
+		// The TYPE comment stays in front of the samples
+		sort.Strings(families[name][1:])
+		fmt.Fprintln(w, strings.Join(families[name], "\n"))
+	}
+}
+
+// prometheusLabels renders the labels sorted by their names, e.g. {operation="bind",result="success"}
+func prometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := []string{}
+	for name, value := range labels {
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// SetMetrics records the operations of the broker, the calls to its stores and the failed requests to Azure in the
+// metrics. Without it, the broker only keeps the snapshot of the admin API.
+func (b *Broker) SetMetrics(metrics Metrics) {
+	b.metrics = metrics
+	if b.health != nil {
+		b.health.setMetrics(metrics)
+	}
+	b.store = newMetricsStore(b.store, metrics, b.clock, "primary")
+	if b.replica != nil {
+		b.replica = newMetricsStore(b.replica, metrics, b.clock, "replica")
+	}
+}
+
+// MetricsHandler returns the handler of the metrics if their backend serves them, e.g. Prometheus. It requires the
+// same credentials as the broker API.
+func (b *Broker) MetricsHandler(credentials brokerapi.BrokerCredentials) (http.Handler, bool) {
+	handler, ok := b.metrics.(http.Handler)
+	if !ok {
+		return nil, false
+	}
+	return &adminAuthHandler{handler: handler, credentials: credentials}, true
+}
+
+// recordOperation records the result and the duration of an operation which started at start in the metrics and the
+// snapshot of the admin API. It is deferred with the error result of the operation.
+func (b *Broker) recordOperation(operation string, start time.Time, result *error) {
+	err := *result
+	duration := b.clock.Since(start)
+	errorCode := ""
+	labels := map[string]string{"operation": operation, "result": metricResultSuccess}
+	if err != nil {
+		errorCode = metricErrorCode(err)
+		labels["result"] = metricResultFailure
+		labels["error_code"] = errorCode
+	}
+	b.operationStats.record(operation, errorCode, err != nil)
+	if b.metrics == nil {
+		return
+	}
+	b.metrics.IncrementCounter(metricOperations, labels)
+	b.metrics.ObserveDuration(metricOperationDuration, map[string]string{"operation": operation}, duration)
+}
+
+// metricErrorCode returns the code of the error or the logger action of an error of brokerapi, e.g. instance-missing
+func metricErrorCode(err error) string {
+	if code := ErrorCode(err); code != "" {
+		return code
+	}
+	if failureResponse, ok := err.(*brokerapi.FailureResponse); ok && failureResponse.LoggerAction() != "" {
+		return failureResponse.LoggerAction()
+	}
+	return metricErrorCodeUnknown
+}
+
+// recordAzureRequestFailure counts a failed request of the Azure clients of the storage account
+func recordAzureRequestFailure(metrics Metrics, client, operation string) {
+	if metrics == nil {
+		return
+	}
+	metrics.IncrementCounter(metricAzureRequestFailures, map[string]string{"client": client, "operation": operation})
+}
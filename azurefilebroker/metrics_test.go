@@ -0,0 +1,60 @@
+package azurefilebroker_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	It("should reject an unknown backend", func() {
+		_, err := NewMetrics("statsd")
+		Expect(err).To(MatchError(ContainSubstring(`Invalid metrics backend "statsd"`)))
+	})
+
+	Context("PrometheusMetrics", func() {
+		It("should serve the counters and the durations sorted in the text format", func() {
+			metrics := NewPrometheusMetrics()
+			metrics.IncrementCounter("requests_total", map[string]string{"result": "success", "operation": "bind"})
+			metrics.IncrementCounter("requests_total", map[string]string{"operation": "bind", "result": "success"})
+			metrics.IncrementCounter("requests_total", map[string]string{"operation": "bind", "result": "failure"})
+			metrics.ObserveDuration("request_duration_seconds", map[string]string{"operation": "bind"}, 1500*time.Millisecond)
+			metrics.ObserveDuration("request_duration_seconds", map[string]string{"operation": "bind"}, 500*time.Millisecond)
+
+			recorder := httptest.NewRecorder()
+			metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+			Expect(recorder.Body.String()).To(Equal(`# TYPE request_duration_seconds summary
+request_duration_seconds_count{operation="bind"} 2
+request_duration_seconds_sum{operation="bind"} 2
+# TYPE requests_total counter
+requests_total{operation="bind",result="failure"} 1
+requests_total{operation="bind",result="success"} 2
+`))
+		})
+
+		It("should serve the last value of a gauge", func() {
+			metrics := NewPrometheusMetrics()
+			metrics.SetGauge("degraded", nil, 1)
+			metrics.SetGauge("degraded", nil, 0)
+
+			recorder := httptest.NewRecorder()
+			metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+			Expect(recorder.Body.String()).To(Equal("# TYPE degraded gauge\ndegraded 0\n"))
+		})
+
+		It("should escape the values of the labels", func() {
+			metrics := NewPrometheusMetrics()
+			metrics.IncrementCounter("errors_total", map[string]string{"code": "a\"b\\c\nd"})
+
+			recorder := httptest.NewRecorder()
+			metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+			Expect(recorder.Body.String()).To(ContainSubstring(`errors_total{code="a\"b\\c\nd"} 1`))
+		})
+	})
+})
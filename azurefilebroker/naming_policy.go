@@ -0,0 +1,153 @@
+package azurefilebroker
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	namingPolicyPrefix = "prefix"
+	namingPolicyRegex  = "regex"
+
+	namingPlaceholderOrg   = "{org}"
+	namingPlaceholderSpace = "{space}"
+)
+
+// NamingPolicy restricts the names which the users request. Either the prefix or the regular expression, which must
+// match the whole name, is set. Both may have the placeholders {org} and {space}, which are replaced by the lower-case
+// names of the org and the space of the request.
+type NamingPolicy struct {
+	Prefix string
+	Regex  string
+}
+
+// NamingConfig is the policies of the names of the storage accounts in the provision parameters and of the file shares
+// in the bind parameters of AzureFileShare. The names are not restricted by an empty policy.
+type NamingConfig struct {
+	StorageAccount NamingPolicy
+	Share          NamingPolicy
+
+	invalidPolicies []string
+}
+
+// NewNamingConfig parses the policies, which are one of:
+//
+//	prefix:<prefix>
+//	regex:<regular expression>
+func NewNamingConfig(storageAccountPolicy, sharePolicy string) *NamingConfig {
+	myConf := new(NamingConfig)
+
+	var ok bool
+	if myConf.StorageAccount, ok = parseNamingPolicy(storageAccountPolicy); !ok {
+		myConf.invalidPolicies = append(myConf.invalidPolicies, fmt.Sprintf("storageAccountNamingPolicy %q", storageAccountPolicy))
+	}
+	if myConf.Share, ok = parseNamingPolicy(sharePolicy); !ok {
+		myConf.invalidPolicies = append(myConf.invalidPolicies, fmt.Sprintf("shareNamingPolicy %q", sharePolicy))
+	}
+
+	return myConf
+}
+
+func parseNamingPolicy(policy string) (NamingPolicy, bool) {
+	if policy = strings.TrimSpace(policy); policy == "" {
+		return NamingPolicy{}, true
+	}
+	parts := strings.SplitN(policy, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return NamingPolicy{}, false
+	}
+	switch parts[0] {
+	case namingPolicyPrefix:
+		return NamingPolicy{Prefix: parts[1]}, true
+	case namingPolicyRegex:
+		return NamingPolicy{Regex: parts[1]}, true
+	}
+	return NamingPolicy{}, false
+}
+
+func (config *NamingConfig) Validate() error {
+	if len(config.invalidPolicies) > 0 {
+		return fmt.Errorf("Invalid naming policies: %s. Expected prefix:<prefix> or regex:<regular expression>", strings.Join(config.invalidPolicies, ", "))
+	}
+	if err := config.StorageAccount.validateRegex("storageAccountNamingPolicy"); err != nil {
+		return err
+	}
+	return config.Share.validateRegex("shareNamingPolicy")
+}
+
+func (policy NamingPolicy) validateRegex(name string) error {
+	if policy.Regex == "" {
+		return nil
+	}
+	if _, err := regexp.Compile(expandNamingPlaceholders(policy.Regex, namingContext{OrganizationName: "org", SpaceName: "space"}, regexp.QuoteMeta)); err != nil {
+		return fmt.Errorf("Invalid regular expression in %s: %v", name, err)
+	}
+	return nil
+}
+
+// namingContext is the part of the context of a request on Cloud Foundry which the placeholders of the policies use
+// Reference: https://github.com/openservicebrokerapi/servicebroker/blob/master/profile.md#cloud-foundry-context-object
+type namingContext struct {
+	OrganizationName string `json:"organization_name"`
+	SpaceName        string `json:"space_name"`
+}
+
+func newNamingContext(rawContext json.RawMessage) namingContext {
+	var context namingContext
+	if len(rawContext) > 0 {
+		// The names are only sent by recent versions of the platform, so the placeholders fail without them
+		json.Unmarshal(rawContext, &context)
+	}
+	return context
+}
+
+// expandNamingPlaceholders replaces the placeholders in the value by the names in the context, which are escaped by
+// quote
+func expandNamingPlaceholders(value string, context namingContext, quote func(string) string) string {
+	return strings.NewReplacer(
+		namingPlaceholderOrg, quote(strings.ToLower(context.OrganizationName)),
+		namingPlaceholderSpace, quote(strings.ToLower(context.SpaceName)),
+	).Replace(value)
+}
+
+// missingPlaceholders returns the placeholders of the policy whose names are not in the context
+func (policy NamingPolicy) missingPlaceholders(context namingContext) []string {
+	value := policy.Prefix + policy.Regex
+	missing := []string{}
+	if strings.Contains(value, namingPlaceholderOrg) && context.OrganizationName == "" {
+		missing = append(missing, "org")
+	}
+	if strings.Contains(value, namingPlaceholderSpace) && context.SpaceName == "" {
+		missing = append(missing, "space")
+	}
+	return missing
+}
+
+// check returns an error which tells the rule of the policy when the name of the resource does not comply with it
+func (policy NamingPolicy) check(resource, name string, rawContext json.RawMessage) error {
+	if policy.Prefix == "" && policy.Regex == "" {
+		return nil
+	}
+	context := newNamingContext(rawContext)
+	if missing := policy.missingPlaceholders(context); len(missing) > 0 {
+		return newBrokerError(ErrCodeNamingPolicyViolation, "The name of the %s %q cannot be checked against the naming policy because the platform did not send the name of the %s", resource, name, strings.Join(missing, " and "))
+	}
+	if policy.Prefix != "" {
+		prefix := expandNamingPlaceholders(policy.Prefix, context, func(s string) string { return s })
+		if !strings.HasPrefix(name, prefix) {
+			return newBrokerError(ErrCodeNamingPolicyViolation, "The name of the %s %q does not comply with the naming policy: it must start with %q", resource, name, prefix)
+		}
+		return nil
+	}
+	pattern := expandNamingPlaceholders(policy.Regex, context, regexp.QuoteMeta)
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return fmt.Errorf("Invalid regular expression %q of the naming policy: %v", pattern, err)
+	}
+	if !re.MatchString(name) {
+		return newBrokerError(ErrCodeNamingPolicyViolation, "The name of the %s %q does not comply with the naming policy: it must match %q", resource, name, pattern)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package azurefilebroker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// The error of an operation is cut so that the operation fits in the value column of the store
+const maxInstanceOperationErrorLength = 2048
+
+// InstanceOperation is an entry of the history of a service instance. The history is append-only and is kept after
+// the instance is deleted so that the timeline of an instance can be reconstructed.
+type InstanceOperation struct {
+	InstanceID string `json:"instance_id"`
+	// Operation is provision, update, deprovision, bind, unbind or last-operation
+	Operation string `json:"operation"`
+	BindingID string `json:"binding_id,omitempty"`
+	// Result is the state of the operation: succeeded, failed or in progress when the operation is asynchronous
+	Result    string `json:"result"`
+	ErrorCode string `json:"error_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Warning tells what the operation has not changed, e.g. the bindings which keep the mount options of the previous
+	// plan of the instance
+	Warning         string    `json:"warning,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationMs      int64     `json:"duration_ms"`
+	DatabaseVersion string    `json:"database_version"`
+}
+
+// recordInstanceOperation appends the operation to the history of its instance. The result is derived from the error
+// unless it is set. A failure is only logged because the history is only informational.
+func (b *Broker) recordInstanceOperation(operation InstanceOperation, start time.Time, err error) {
+	logger := b.logger.Session("record-instance-operation").WithData(lager.Data{"instanceID": operation.InstanceID, "operation": operation.Operation})
+	if err := b.createInstanceOperation(operation, start, err); err != nil {
+		logger.Error("create-instance-operation", err)
+	}
+}
+
+// createInstanceOperation appends the operation to the history of its instance and returns the failure, e.g. for the
+// asynchronous binds whose state is read from the history
+func (b *Broker) createInstanceOperation(operation InstanceOperation, start time.Time, err error) error {
+	if err != nil {
+		operation.Result = string(brokerapi.Failed)
+		operation.ErrorCode = metricErrorCode(err)
+		operation.Error = err.Error()
+	} else if operation.Result == "" {
+		operation.Result = string(brokerapi.Succeeded)
+	}
+	if len(operation.Error) > maxInstanceOperationErrorLength {
+		operation.Error = operation.Error[:maxInstanceOperationErrorLength]
+	}
+	operation.StartedAt = start.UTC()
+	operation.DurationMs = int64(b.clock.Since(start) / time.Millisecond)
+	operation.DatabaseVersion = databaseVersion
+
+	// Several brokers may record an operation of the instance at the same time, so the time is not unique
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	id := fmt.Sprintf("%s-%d-%s", operation.InstanceID, start.UnixNano(), hex.EncodeToString(suffix))
+	return b.store.CreateInstanceOperation(id, operation)
+}
+
+// asyncResult returns the result of an operation which has not failed
+func asyncResult(isAsync bool) string {
+	if isAsync {
+		return string(brokerapi.InProgress)
+	}
+	return string(brokerapi.Succeeded)
+}
+
+// InstanceOperations returns the history of the service instance sorted by the start of the operations
+func (b *Broker) InstanceOperations(instanceID string) ([]InstanceOperation, error) {
+	operations, err := b.readStore().RetrieveInstanceOperations(instanceID)
+	if err != nil {
+		return nil, newStoreError(err, "Failed to retrieve the operations of the instance %q", instanceID)
+	}
+	sort.SliceStable(operations, func(i, j int) bool { return operations[i].StartedAt.Before(operations[j].StartedAt) })
+	return operations, nil
+}
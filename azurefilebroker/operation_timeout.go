@@ -0,0 +1,177 @@
+package azurefilebroker
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Provision runs provision within the provision timeout of the config and records its result. The retries of a request
+// get its result instead of running it again. The other operations are bounded, deduplicated and recorded in the same
+// way.
+func (b *Broker) Provision(ctx context.Context, instanceID string, details brokerapi.ProvisionDetails, asyncAllowed bool) (_ brokerapi.ProvisionedServiceSpec, e error) {
+	start := b.clock.Now()
+	defer b.recordOperation("provision", start, &e)
+	var spec brokerapi.ProvisionedServiceSpec
+	defer func() {
+		b.recordInstanceOperation(InstanceOperation{InstanceID: instanceID, Operation: "provision", Result: asyncResult(spec.IsAsync)}, start, e)
+	}()
+	result, err := b.runWithTimeout(ctx, "provision", b.config.timeouts.Provision, func(ctx context.Context) (interface{}, error) {
+		return b.deduplicate("provision", instanceID, "", details, asyncAllowed, func() (interface{}, error) {
+			return b.withContext(ctx).provision(ctx, instanceID, details, asyncAllowed)
+		})
+	})
+	spec, _ = result.(brokerapi.ProvisionedServiceSpec)
+	return spec, err
+}
+
+func (b *Broker) Deprovision(ctx context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (_ brokerapi.DeprovisionServiceSpec, e error) {
+	start := b.clock.Now()
+	defer b.recordOperation("deprovision", start, &e)
+	var spec brokerapi.DeprovisionServiceSpec
+	defer func() {
+		b.recordInstanceOperation(InstanceOperation{InstanceID: instanceID, Operation: "deprovision", Result: asyncResult(spec.IsAsync)}, start, e)
+	}()
+	result, err := b.runWithTimeout(ctx, "deprovision", b.config.timeouts.Deprovision, func(ctx context.Context) (interface{}, error) {
+		return b.deduplicate("deprovision", instanceID, "", details, asyncAllowed, func() (interface{}, error) {
+			return b.withContext(ctx).deprovision(ctx, instanceID, details, asyncAllowed)
+		})
+	})
+	spec, _ = result.(brokerapi.DeprovisionServiceSpec)
+	return spec, err
+}
+
+func (b *Broker) Bind(ctx context.Context, instanceID string, bindingID string, details brokerapi.BindDetails) (_ brokerapi.Binding, e error) {
+	start := b.clock.Now()
+	defer b.recordOperation("bind", start, &e)
+	defer func() {
+		b.recordInstanceOperation(InstanceOperation{InstanceID: instanceID, Operation: "bind", BindingID: bindingID}, start, e)
+	}()
+	result, err := b.runWithTimeout(ctx, "bind", b.config.timeouts.Bind, func(ctx context.Context) (interface{}, error) {
+		return b.deduplicate("bind", instanceID, bindingID, details, false, func() (interface{}, error) {
+			return b.withContext(ctx).bind(ctx, instanceID, bindingID, details)
+		})
+	})
+	binding, _ := result.(brokerapi.Binding)
+	return binding, err
+}
+
+func (b *Broker) Unbind(ctx context.Context, instanceID string, bindingID string, details brokerapi.UnbindDetails) (e error) {
+	start := b.clock.Now()
+	defer b.recordOperation("unbind", start, &e)
+	defer func() {
+		b.recordInstanceOperation(InstanceOperation{InstanceID: instanceID, Operation: "unbind", BindingID: bindingID}, start, e)
+	}()
+	_, err := b.runWithTimeout(ctx, "unbind", b.config.timeouts.Unbind, func(ctx context.Context) (interface{}, error) {
+		return b.deduplicate("unbind", instanceID, bindingID, details, false, func() (interface{}, error) {
+			return nil, b.withContext(ctx).unbind(ctx, instanceID, bindingID, details)
+		})
+	})
+	return err
+}
+
+// Update runs update within the update timeout of the config and records its result with the warning of a plan change
+func (b *Broker) Update(ctx context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (_ brokerapi.UpdateServiceSpec, e error) {
+	start := b.clock.Now()
+	defer b.recordOperation("update", start, &e)
+	var outcome updateOutcome
+	defer func() {
+		b.recordInstanceOperation(InstanceOperation{InstanceID: instanceID, Operation: "update", Result: asyncResult(outcome.spec.IsAsync), Warning: outcome.warning}, start, e)
+	}()
+	result, err := b.runWithTimeout(ctx, "update", b.config.timeouts.Update, func(ctx context.Context) (interface{}, error) {
+		return b.withContext(ctx).update(ctx, instanceID, details, asyncAllowed)
+	})
+	outcome, _ = result.(updateOutcome)
+	return outcome.spec, err
+}
+
+func (b *Broker) LastOperation(ctx context.Context, instanceID string, operationData string) (_ brokerapi.LastOperation, e error) {
+	start := b.clock.Now()
+	defer b.recordOperation("last-operation", start, &e)
+	var lastOperation brokerapi.LastOperation
+	// The platform polls the asynchronous operations, so only their outcome is recorded
+	defer func() {
+		if e == nil && lastOperation.State == brokerapi.InProgress {
+			return
+		}
+		operation := InstanceOperation{InstanceID: instanceID, Operation: "last-operation", Result: string(lastOperation.State)}
+		if lastOperation.State == brokerapi.Failed {
+			operation.Error = lastOperation.Description
+			// The platform may send the failed request again, which must run again
+			b.requests.forget(instanceID)
+		}
+		b.recordInstanceOperation(operation, start, e)
+	}()
+	result, err := b.runWithTimeout(ctx, "last-operation", b.config.timeouts.LastOperation, func(ctx context.Context) (interface{}, error) {
+		return b.withContext(ctx).lastOperation(ctx, instanceID, operationData)
+	})
+	lastOperation, _ = result.(brokerapi.LastOperation)
+	return lastOperation, err
+}
+
+// maxTimedOutOperations is how many operations which timed out may still run until their next call to the store or
+// to Azure. The operations which start beyond it are refused so that the operations stuck in a call do not pile up.
+const maxTimedOutOperations = 16
+
+// operationResult is the result of an operation which runWithTimeout sends back from the goroutine of the operation
+type operationResult struct {
+	value interface{}
+	err   error
+}
+
+// timedOutOperations counts the operations which timed out and still run. It is shared by the copies of the broker.
+type timedOutOperations struct {
+	count int32
+}
+
+func (o *timedOutOperations) running() int32 {
+	return atomic.LoadInt32(&o.count)
+}
+
+func (o *timedOutOperations) add(delta int32) {
+	atomic.AddInt32(&o.count, delta)
+}
+
+// runWithTimeout runs the operation and returns its result, or an error if it does not finish before the timeout or
+// the context is done. The operation is given the context with the timeout and stops at its next read from the store,
+// lock or call to Azure. Its writes to the store still land so that what it already changed in Azure is recorded or
+// rolled back. The result of an operation which timed out is dropped when it ends.
+func (b *Broker) runWithTimeout(ctx context.Context, operation string, timeout time.Duration, run func(context.Context) (interface{}, error)) (interface{}, error) {
+	logger := b.logger.Session(operation)
+	if running := b.timedOut.running(); running >= maxTimedOutOperations {
+		err := newBrokerError(ErrCodeOperationTimedOut, "The %s operation is refused because %d operations which timed out are still running", operation, running)
+		logger.Error("too-many-timed-out-operations", err)
+		return nil, toFailureResponse(err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// The channel is buffered so that the operation which timed out does not block when it ends
+	results := make(chan operationResult, 1)
+	go func() {
+		value, err := run(ctx)
+		results <- operationResult{value: value, err: err}
+	}()
+
+	select {
+	case result := <-results:
+		return result.value, result.err
+	case <-ctx.Done():
+		err := newBrokerError(ErrCodeOperationTimedOut, "The %s operation did not finish in %s: %v", operation, timeout, ctx.Err())
+		logger.Error("operation-timed-out", err, lager.Data{"timeout": timeout.String()})
+		b.timedOut.add(1)
+		go func() {
+			result := <-results
+			b.timedOut.add(-1)
+			logger.Info("timed-out-operation-ended", lager.Data{"error": fmt.Sprint(result.err)})
+		}()
+		return nil, toFailureResponse(err)
+	}
+}
@@ -0,0 +1,130 @@
+package azurefilebroker
+
+// The version of JSON Schema of the parameter schemas, which the service broker API requires in every schema
+const jsonSchemaVersion = "http://json-schema.org/draft-04/schema#"
+
+// PlanSchemas are the schemas of the provision and the bind parameters of a plan in the catalog of the service broker
+// API 2.13. The platform validates the parameters with them and shows them in the marketplace.
+type PlanSchemas struct {
+	ServiceInstance ServiceInstanceSchema `json:"service_instance"`
+	ServiceBinding  ServiceBindingSchema  `json:"service_binding"`
+}
+
+// ServiceInstanceSchema is the schema of the provision parameters
+type ServiceInstanceSchema struct {
+	Create InputParametersSchema `json:"create"`
+}
+
+// ServiceBindingSchema is the schema of the bind parameters
+type ServiceBindingSchema struct {
+	Create InputParametersSchema `json:"create"`
+}
+
+// InputParametersSchema is a JSON schema of the parameters of an operation
+type InputParametersSchema struct {
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// planSchemas returns the schemas of the plan, which describe Configuration and BindOptions. They only check the types
+// that the broker decodes, so that the platform does not refuse the values which the broker accepts, and they do not
+// refuse the unknown parameters, which the broker ignores.
+func (b *Broker) planSchemas(planID string) *PlanSchemas {
+	return &PlanSchemas{
+		ServiceInstance: ServiceInstanceSchema{Create: InputParametersSchema{Parameters: b.provisionSchema(planID)}},
+		ServiceBinding:  ServiceBindingSchema{Create: InputParametersSchema{Parameters: bindSchema()}},
+	}
+}
+
+// provisionSchema returns the schema of Configuration for the plan. A broker without AzureFileShare and the plans of
+// the kind preexisting only provision preexisting shares, and the plans of the kind azurefileshare, e.g. the plans of
+// the isolation segments, only provision AzureFileShare.
+func (b *Broker) provisionSchema(planID string) map[string]interface{} {
+	skuNames := []string{}
+	for _, skuName := range supportedSkuNames {
+		skuNames = append(skuNames, string(skuName))
+	}
+	properties := map[string]interface{}{
+		"share": describe(map[string]interface{}{"type": "string"},
+			"A preexisting share registered by the administrator, e.g. //server/share. An Azure file share is used without it"),
+		"ttl_hours": describe(map[string]interface{}{"type": "integer", "minimum": 0, "maximum": maxInstanceTTLHours},
+			"The number of hours after which the instance is deprovisioned when it is not bound"),
+	}
+	schema := map[string]interface{}{
+		"$schema":    jsonSchemaVersion,
+		"type":       "object",
+		"properties": properties,
+	}
+	kind := b.planKind(planID)
+	if !b.isSupportAzureFileShare() || kind == planKindPreexisting {
+		schema["required"] = []string{"share"}
+		return schema
+	}
+	if kind == planKindAzureFileShare {
+		delete(properties, "share")
+	}
+
+	for name, property := range map[string]map[string]interface{}{
+		"subscription_id":      describe(map[string]interface{}{"type": "string"}, "The subscription of the storage account. The default is the subscription of the plan or the broker"),
+		"resource_group_name":  describe(map[string]interface{}{"type": "string"}, "The resource group of the storage account. The default is the resource group of the plan or the broker"),
+		"storage_account_name": describe(map[string]interface{}{"type": "string"}, "The storage account of the file shares, which is created when it does not exist and the broker may create it"),
+		"location":             describe(map[string]interface{}{"type": "string"}, "The location of the created storage account"),
+		"use_https":            describe(map[string]interface{}{"type": "string"}, "true if the created storage account only accepts secure transfers, which SMB 2.1 cannot mount"),
+		"sku_name":             describe(map[string]interface{}{"type": "string", "enum": skuNames}, "The SKU of the created storage account"),
+		"custom_domain_name":   describe(map[string]interface{}{"type": "string"}, "The custom domain of the created storage account"),
+		"use_sub_domain":       describe(map[string]interface{}{"type": "string"}, "true if the custom domain is validated indirectly with the asverify subdomain"),
+		"enable_encryption":    describe(map[string]interface{}{"type": "string"}, "true if the file shares of the created storage account are encrypted"),
+		"tenant_id":            describe(map[string]interface{}{"type": "string"}, "The tenant of the service principal which manages the storage account, or alone an auxiliary tenant of the broker"),
+		"client_id":            describe(map[string]interface{}{"type": "string"}, "The client ID of the service principal which manages the storage account"),
+		"client_secret":        describe(map[string]interface{}{"type": "string"}, "The client secret of the service principal which manages the storage account"),
+		"credhub_ref":          describe(map[string]interface{}{"type": "string"}, "The CredHub reference of the service principal which manages the storage account"),
+		"backup_vault_id":      describe(map[string]interface{}{"type": "string"}, "The resource ID of the Recovery Services vault which protects the created file shares"),
+		"backup_policy":        describe(map[string]interface{}{"type": "string"}, "The backup policy of the vault which protects the created file shares"),
+		"access_logs":          describe(map[string]interface{}{"type": "string"}, "true or false to override whether the plan sends the access logs of the file shares"),
+	} {
+		properties[name] = property
+	}
+	return schema
+}
+
+// bindSchema returns the schema of BindOptions
+func bindSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"$schema": jsonSchemaVersion,
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"uid":       describe(map[string]interface{}{"type": "string"}, "The user which owns the files of the mount"),
+			"gid":       describe(map[string]interface{}{"type": "string"}, "The group which owns the files of the mount"),
+			"file_mode": describe(map[string]interface{}{"type": "string"}, "The mode of the files of the mount, e.g. 0644"),
+			"dir_mode":  describe(map[string]interface{}{"type": "string"}, "The mode of the directories of the mount and of the created directories, e.g. 0755"),
+			"readonly":  describe(map[string]interface{}{"type": "boolean"}, "Whether the share is mounted read-only"),
+			"mount":     describe(map[string]interface{}{"type": "string"}, "The path of the mount in the container. The default is under /var/vcap/data"),
+			"vers":      describe(map[string]interface{}{"type": "string"}, "The SMB version of the mount, e.g. 3.0"),
+			"share":     describe(map[string]interface{}{"type": "string"}, "The Azure file share to mount, which is created when it does not exist"),
+			"domain":    describe(map[string]interface{}{"type": "string"}, "The domain of the user of a preexisting share"),
+			"username":  describe(map[string]interface{}{"type": "string"}, "The user which mounts a preexisting share"),
+			"password":  describe(map[string]interface{}{"type": "string"}, "The password of the user which mounts a preexisting share"),
+			"sec":       describe(map[string]interface{}{"type": "string"}, "The security mode of the mount of a preexisting share, e.g. ntlmssp"),
+			"share_sas": describe(map[string]interface{}{"type": "string"}, "A SAS token which lists the existing Azure file share, which proves its ownership"),
+			"access_policy": describe(map[string]interface{}{
+				"type":     "object",
+				"required": []string{"id"},
+				"properties": map[string]interface{}{
+					"id":          map[string]interface{}{"type": "string"},
+					"permissions": describe(map[string]interface{}{"type": "string"}, "The letters r(ead), c(reate), w(rite), d(elete) and l(ist)"),
+					"expiry":      map[string]interface{}{"type": "string", "format": "date-time"},
+				},
+			}, "The stored access policy of the share which the SAS token of the credentials references. Only the id of a policy of an update may be given"),
+			"directories": describe(map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+				`The directories which are created in the Azure file share when the broker creates it, e.g. ["logs", "data/uploads"]`),
+		},
+	}
+}
+
+// describe returns a copy of the schema with the description
+func describe(schema map[string]interface{}, description string) map[string]interface{} {
+	described := map[string]interface{}{"description": description}
+	for key, value := range schema {
+		described[key] = value
+	}
+	return described
+}
@@ -0,0 +1,89 @@
+package azurefilebroker
+
+import (
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	actionReadStorageAccounts   = "Microsoft.Storage/storageAccounts/read"
+	actionListKeys              = "Microsoft.Storage/storageAccounts/listkeys/action"
+	actionWriteStorageAccounts  = "Microsoft.Storage/storageAccounts/write"
+	actionDeleteStorageAccounts = "Microsoft.Storage/storageAccounts/delete"
+
+	// The built-in role which grants all the actions needed by the broker
+	suggestedRole = "Storage Account Contributor"
+)
+
+type requiredAction struct {
+	Action  string
+	Purpose string
+}
+
+// requiredActions returns the actions which the broker needs with the given control config
+func requiredActions(control *ControlConfig) []requiredAction {
+	actions := []requiredAction{
+		{Action: actionReadStorageAccounts, Purpose: "list and read storage accounts"},
+		// File shares are managed with the access keys of the storage account
+		{Action: actionListKeys, Purpose: "list the keys of storage accounts to manage file shares"},
+	}
+	if control.AllowCreateStorageAccount {
+		actions = append(actions, requiredAction{Action: actionWriteStorageAccounts, Purpose: "create storage accounts"})
+	}
+	if control.AllowDeleteStorageAccount {
+		actions = append(actions, requiredAction{Action: actionDeleteStorageAccounts, Purpose: "delete storage accounts"})
+	}
+	return actions
+}
+
+// AuditPermissions checks, without changing anything, that the service principal has the permissions which the broker
+// needs in the resource group of the client. It logs every missing action and returns them.
+func (b *Broker) AuditPermissions(restClient AzureStorageAccountRESTClient) ([]string, error) {
+	logger := b.logger.Session("audit-permissions")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	permissions, err := restClient.ListPermissions()
+	if err != nil {
+		logger.Error("list-permissions", err)
+		return nil, err
+	}
+
+	missing := []string{}
+	for _, required := range requiredActions(&b.config.cloud.Control) {
+		if !isActionAllowed(required.Action, permissions) {
+			logger.Info("missing-permission", lager.Data{"action": required.Action, "purpose": required.Purpose})
+			missing = append(missing, required.Action)
+		}
+	}
+
+	report := lager.Data{"missing": missing}
+	if len(missing) > 0 {
+		report["suggestedRole"] = suggestedRole
+	}
+	logger.Info("permission-report", report)
+	return missing, nil
+}
+
+// isActionAllowed returns true if any permission allows the action without excluding it. Actions may have wildcards.
+func isActionAllowed(action string, permissions []Permission) bool {
+	for _, permission := range permissions {
+		if matchesAnyAction(action, permission.Actions) && !matchesAnyAction(action, permission.NotActions) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyAction(action string, patterns []string) bool {
+	for _, pattern := range patterns {
+		// Actions are case insensitive and "*" matches any characters
+		expression := "(?i)^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1) + "$"
+		if matched, err := regexp.MatchString(expression, action); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
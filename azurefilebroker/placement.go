@@ -0,0 +1,205 @@
+package azurefilebroker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const (
+	placementSelectorOrg        = "org"
+	placementSelectorAnnotation = "annotation"
+)
+
+// PlacementRule picks the location of the storage accounts created for the org which it matches
+type PlacementRule struct {
+	// Org is the name or the GUID of the org
+	Org string
+	// AnnotationKey and AnnotationValue match an annotation of the org
+	AnnotationKey   string
+	AnnotationValue string
+	Location        string
+}
+
+// PlanTarget is the subscription and the resource group of the storage accounts of a plan. An empty field keeps the
+// default of the broker.
+type PlanTarget struct {
+	SubscriptionID    string
+	ResourceGroupName string
+}
+
+// PlacementConfig is the policy which picks the location of a storage account created by the broker when the location
+// is not in the provision parameters. The platform does not send the isolation segment of an instance to brokers, so
+// the orgs of an isolation segment are mapped by their names or by an annotation of the orgs. PlanTargets replace the
+// default subscription and resource group for the instances of their plans.
+type PlacementConfig struct {
+	Rules       []PlacementRule
+	PlanTargets map[string]PlanTarget
+
+	invalidRules       []string
+	invalidPlanTargets []string
+}
+
+// NewPlacementConfig parses a comma separated list of rules, which are tried in order:
+//
+//	org:<org name or GUID>=<location>
+//	annotation:<key>:<value>=<location>
+func NewPlacementConfig(policy string) *PlacementConfig {
+	myConf := new(PlacementConfig)
+
+	myConf.Rules = make([]PlacementRule, 0)
+	myConf.PlanTargets = make(map[string]PlanTarget)
+	for _, rule := range strings.Split(policy, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		if placementRule, ok := parsePlacementRule(rule); ok {
+			myConf.Rules = append(myConf.Rules, placementRule)
+		} else {
+			myConf.invalidRules = append(myConf.invalidRules, rule)
+		}
+	}
+
+	return myConf
+}
+
+func parsePlacementRule(rule string) (PlacementRule, bool) {
+	separator := strings.LastIndex(rule, "=")
+	if separator < 0 {
+		return PlacementRule{}, false
+	}
+	selector, location := rule[:separator], strings.TrimSpace(rule[separator+1:])
+	if location == "" {
+		return PlacementRule{}, false
+	}
+
+	parts := strings.SplitN(selector, ":", 3)
+	switch {
+	case parts[0] == placementSelectorOrg && len(parts) == 2 && parts[1] != "":
+		return PlacementRule{Org: parts[1], Location: location}, true
+	case parts[0] == placementSelectorAnnotation && len(parts) == 3 && parts[1] != "":
+		return PlacementRule{AnnotationKey: parts[1], AnnotationValue: parts[2], Location: location}, true
+	}
+	return PlacementRule{}, false
+}
+
+// ReadPlanTargets parses a comma separated list of plans and their subscriptions and resource groups:
+//
+//	<plan name>=<subscription id>:<resource group>
+//
+// Either the subscription or the resource group may be empty to keep the default of the broker.
+func (config *PlacementConfig) ReadPlanTargets(planFlag string) {
+	config.PlanTargets = make(map[string]PlanTarget)
+	config.invalidPlanTargets = nil
+	for _, entry := range strings.Split(planFlag, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		pair := strings.SplitN(entry, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			config.invalidPlanTargets = append(config.invalidPlanTargets, entry)
+			continue
+		}
+		target := strings.SplitN(pair[1], ":", 2)
+		if len(target) != 2 || strings.TrimSpace(target[0]) == "" && strings.TrimSpace(target[1]) == "" {
+			config.invalidPlanTargets = append(config.invalidPlanTargets, entry)
+			continue
+		}
+		config.PlanTargets[strings.TrimSpace(pair[0])] = PlanTarget{
+			SubscriptionID:    strings.TrimSpace(target[0]),
+			ResourceGroupName: strings.TrimSpace(target[1]),
+		}
+	}
+}
+
+// Validate checks the rules and that the plans of the targets are AzureFileShare plans of the catalog
+func (config *PlacementConfig) Validate(segments *IsolationSegmentConfig, catalog *CatalogConfig) error {
+	if len(config.invalidRules) > 0 {
+		return fmt.Errorf("Invalid rules in placementPolicy: %s. Expected org:<org>=<location> or annotation:<key>:<value>=<location>", strings.Join(config.invalidRules, ", "))
+	}
+	if len(config.invalidPlanTargets) > 0 {
+		return fmt.Errorf("Invalid entries in planResourceGroups: %s. Expected <plan name>=<subscription id>:<resource group>", strings.Join(config.invalidPlanTargets, ", "))
+	}
+	planNames := optionPlanNames(segments, catalog, false)
+	names := []string{}
+	for name := range config.PlanTargets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !inArray(planNames, name) {
+			return fmt.Errorf("Unknown plan %q in planResourceGroups: expected one of %s", name, strings.Join(planNames, ", "))
+		}
+	}
+	return nil
+}
+
+// placementContext is the part of the context of a provision request on Cloud Foundry which the placement rules match
+// Reference: https://github.com/openservicebrokerapi/servicebroker/blob/master/profile.md#cloud-foundry-context-object
+type placementContext struct {
+	OrganizationGUID        string            `json:"organization_guid"`
+	OrganizationName        string            `json:"organization_name"`
+	OrganizationAnnotations map[string]string `json:"organization_annotations"`
+}
+
+func newPlacementContext(details brokerapi.ProvisionDetails) placementContext {
+	var context placementContext
+	if len(details.RawContext) > 0 {
+		// The context is optional, so a context which cannot be parsed only matches by the org GUID of the request
+		json.Unmarshal(details.RawContext, &context)
+	}
+	if context.OrganizationGUID == "" {
+		context.OrganizationGUID = details.OrganizationGUID
+	}
+	return context
+}
+
+func (rule PlacementRule) matches(context placementContext) bool {
+	if rule.Org != "" {
+		return rule.Org == context.OrganizationGUID || rule.Org == context.OrganizationName
+	}
+	value, ok := context.OrganizationAnnotations[rule.AnnotationKey]
+	return ok && value == rule.AnnotationValue
+}
+
+// Location returns the location of the first rule which matches the org of the provision request or an empty string
+func (config *PlacementConfig) Location(details brokerapi.ProvisionDetails) string {
+	context := newPlacementContext(details)
+	for _, rule := range config.Rules {
+		if rule.matches(context) {
+			return rule.Location
+		}
+	}
+	return ""
+}
+
+// placementLocation returns the location of a storage account which is created for the provision request when the
+// provision parameters do not have one
+func (b *Broker) placementLocation(logger lager.Logger, details brokerapi.ProvisionDetails) string {
+	if location := b.config.placement.Location(details); location != "" {
+		logger.Info("placement-policy-location", lager.Data{"location": location})
+		return location
+	}
+	return b.config.cloud.Azure.DefaultLocation
+}
+
+// planTarget returns the subscription and the resource group of the storage accounts of the plan, which are the
+// defaults of the broker unless the plan has its own
+func (b *Broker) planTarget(planID string) PlanTarget {
+	target := PlanTarget{
+		SubscriptionID:    b.config.cloud.Azure.DefaultSubscriptionID,
+		ResourceGroupName: b.config.cloud.Azure.DefaultResourceGroupName,
+	}
+	planTarget := b.config.placement.PlanTargets[b.planName(planID)]
+	if planTarget.SubscriptionID != "" {
+		target.SubscriptionID = planTarget.SubscriptionID
+	}
+	if planTarget.ResourceGroupName != "" {
+		target.ResourceGroupName = planTarget.ResourceGroupName
+	}
+	return target
+}
@@ -0,0 +1,143 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// ReadPlanConf parses a comma separated list of plans and their defaults:
+//
+//	<plan name>=<param>:<value>;<param>:<value>
+func (config *MountConfig) ReadPlanConf(planFlag string) {
+	config.PlanOptions = make(map[string]map[string]string)
+	config.invalidPlanOptions = nil
+	for _, entry := range strings.Split(planFlag, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		pair := strings.SplitN(entry, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			config.invalidPlanOptions = append(config.invalidPlanOptions, entry)
+			continue
+		}
+		options := map[string]string{}
+		for _, option := range strings.Split(pair[1], ";") {
+			if option = strings.TrimSpace(option); option == "" {
+				continue
+			}
+			keyValue := strings.SplitN(option, ":", 2)
+			if len(keyValue) != 2 || keyValue[0] == "" {
+				config.invalidPlanOptions = append(config.invalidPlanOptions, entry)
+				break
+			}
+			options[keyValue[0]] = keyValue[1]
+		}
+		config.PlanOptions[strings.TrimSpace(pair[0])] = options
+	}
+}
+
+// Validate checks that the plans of the defaults are plans of the catalog
+func (config *MountConfig) Validate(segments *IsolationSegmentConfig, catalog *CatalogConfig) error {
+	if len(config.invalidPlanOptions) > 0 {
+		return fmt.Errorf("Invalid entries in planMountOptions: %s. Expected <plan name>=<param>:<value>;<param>:<value>", strings.Join(config.invalidPlanOptions, ", "))
+	}
+	planNames := optionPlanNames(segments, catalog, true)
+	names := []string{}
+	for name := range config.PlanOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !inArray(planNames, name) {
+			return fmt.Errorf("Unknown plan %q in planMountOptions: expected one of %s", name, strings.Join(planNames, ", "))
+		}
+	}
+	return nil
+}
+
+// forPlan returns a copy of the config with the defaults of the plan
+func (config *MountConfig) forPlan(planName string) *MountConfig {
+	myConf := config.Copy()
+	for k, v := range config.PlanOptions[planName] {
+		if inArray(config.Allowed, k) {
+			myConf.Options[k] = v
+		} else {
+			myConf.Forced[k] = v
+		}
+	}
+	return myConf
+}
+
+// hasSameDefaults returns true if the bindings of both plans get the same defaults and forced options
+func (config *MountConfig) hasSameDefaults(planName, otherPlanName string) bool {
+	mountConfig, otherMountConfig := config.forPlan(planName), config.forPlan(otherPlanName)
+	return reflect.DeepEqual(mountConfig.Options, otherMountConfig.Options) && reflect.DeepEqual(mountConfig.Forced, otherMountConfig.Forced)
+}
+
+// hasSameSubnets returns true if the storage accounts of both plans allow the same networks
+func (config *IsolationSegmentConfig) hasSameSubnets(planID, otherPlanID string) bool {
+	subnetIDs := func(planID string) []string {
+		network := config.network(planID)
+		if network == nil {
+			return nil
+		}
+		ids := append([]string{}, network.SubnetIDs...)
+		for i := range ids {
+			ids[i] = strings.ToLower(ids[i])
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	return reflect.DeepEqual(subnetIDs(planID), subnetIDs(otherPlanID))
+}
+
+// checkPlanChange checks that an instance may move to the plan. Only AzureFileShare instances move between the
+// AzureFileShare plans, and a plan whose storage accounts allow other networks requires a migration to a new storage
+// account which is created in the network of the plan.
+func (b *Broker) checkPlanChange(serviceInstance *ServiceInstance, planID string, migrating bool) error {
+	planName := b.planName(planID)
+	if serviceInstance.IsPreexisting || planName == "" || planID == planIDExisting || b.planKind(planID) == planKindPreexisting {
+		return brokerapi.ErrPlanChangeNotSupported
+	}
+	if !migrating && !b.config.segments.hasSameSubnets(serviceInstance.PlanID, planID) {
+		return newBrokerError(ErrCodeInvalidParameters, "The storage accounts of the plans %q and %q allow different networks: give migrate_to to move the file shares to a new storage account in the network of the plan %q", b.instancePlanName(serviceInstance), planName, planName)
+	}
+	return nil
+}
+
+// changePlan moves the instance to the plan, whose defaults are used by the next bindings. It returns a warning with
+// the bindings which keep the mount options of the previous plan until they are created again, or "" if there are none.
+func (b *Broker) changePlan(logger lager.Logger, instanceID string, serviceInstance *ServiceInstance, planID string) (string, error) {
+	previousPlanName, planName := b.instancePlanName(serviceInstance), b.planName(planID)
+	serviceInstance.PlanID = planID
+	logger.Info("plan-changed", lager.Data{"previousPlan": previousPlanName, "plan": planName})
+	if b.config.mount.hasSameDefaults(previousPlanName, planName) {
+		return "", nil
+	}
+
+	shares, err := b.store.RetrieveFileShares()
+	if err != nil {
+		return "", newStoreError(err, "Failed to retrieve the file shares")
+	}
+	bindings, err := b.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return "", newStoreError(err, "Failed to retrieve the bindings")
+	}
+	bindingIDs := []string{}
+	for bindingID, bindingDetails := range bindings {
+		if share, ok := shares[bindingDetails.FileShareID]; ok && share.InstanceID == instanceID {
+			bindingIDs = append(bindingIDs, bindingID)
+		}
+	}
+	if len(bindingIDs) == 0 {
+		return "", nil
+	}
+	sort.Strings(bindingIDs)
+	logger.Info("bindings-with-previous-mount-options", lager.Data{"bindingIDs": bindingIDs})
+	return fmt.Sprintf("The bindings %s keep the mount options of the plan %q until they are created again", strings.Join(bindingIDs, ", "), previousPlanName), nil
+}
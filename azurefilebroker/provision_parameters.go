@@ -0,0 +1,80 @@
+package azurefilebroker
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// The sanitized parameters are only kept when they are short, because the instance must fit in the value column of
+// the store
+const maxProvisionParametersLength = 1024
+
+// secretParameterPattern matches the names of the provision parameters which may have a secret, e.g. client_secret
+var secretParameterPattern = regexp.MustCompile(`(?i)secret|passw|pwd|key|token|credential|signature|sas`)
+
+// sanitizeProvisionParameters returns the provision parameters without the parameters which may have a secret, at
+// any depth, and the names of the removed parameters. It returns nil when nothing is left or the parameters are not
+// a JSON object.
+func sanitizeProvisionParameters(rawParameters json.RawMessage) (json.RawMessage, []string) {
+	if len(rawParameters) == 0 {
+		return nil, nil
+	}
+	var parameters map[string]interface{}
+	if err := json.Unmarshal(rawParameters, &parameters); err != nil || len(parameters) == 0 {
+		return nil, nil
+	}
+	removed := removeSecretParameters("", parameters)
+	sort.Strings(removed)
+	if len(parameters) == 0 {
+		return nil, removed
+	}
+	sanitized, err := json.Marshal(parameters)
+	if err != nil {
+		return nil, removed
+	}
+	return sanitized, removed
+}
+
+func removeSecretParameters(prefix string, parameters map[string]interface{}) []string {
+	removed := []string{}
+	for name, value := range parameters {
+		if secretParameterPattern.MatchString(name) {
+			delete(parameters, name)
+			removed = append(removed, prefix+name)
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			removed = append(removed, removeSecretParameters(prefix+name+".", nested)...)
+		}
+	}
+	return removed
+}
+
+// provisionParameters returns the parameters of a provision which are kept with the instance
+func (b *Broker) provisionParameters(logger lager.Logger, rawParameters json.RawMessage) json.RawMessage {
+	sanitized, removed := sanitizeProvisionParameters(rawParameters)
+	if len(removed) > 0 {
+		logger.Info("secret-provision-parameters-removed", lager.Data{"parameters": removed})
+	}
+	if len(sanitized) > maxProvisionParametersLength {
+		logger.Info("provision-parameters-too-long", lager.Data{"length": len(sanitized), "maxLength": maxProvisionParametersLength})
+		return nil
+	}
+	return sanitized
+}
+
+// InstanceProvisionParameters returns the parameters which the service instance was provisioned with, without the
+// secrets. It returns nil for the instances which were created by older versions of the broker.
+func (b *Broker) InstanceProvisionParameters(instanceID string) (json.RawMessage, error) {
+	serviceInstance, err := b.readStore().RetrieveServiceInstance(instanceID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		return nil, newBrokerError(ErrCodeResourceNotFound, "The service instance %q does not exist", instanceID)
+	} else if err != nil {
+		return nil, newStoreError(err, "Failed to retrieve the service instance %q", instanceID)
+	}
+	return serviceInstance.ProvisionParameters, nil
+}
@@ -0,0 +1,21 @@
+package azurefilebroker
+
+// SetReadReplica sends the reads which tolerate the replication lag to the store of a read replica. The reads which
+// are followed by a write, e.g. under a lock, always go to the primary store so that no update is lost.
+func (b *Broker) SetReadReplica(replica Store) {
+	b.replica = newCompatibilityStore(replica)
+	if b.storeRetrier != nil {
+		b.replica = newRetryingStore(b.logger, b.replica, b.storeRetrier)
+	}
+	if b.metrics != nil {
+		b.replica = newMetricsStore(b.replica, b.metrics, b.clock, "replica")
+	}
+}
+
+// readStore returns the store for a read whose result is only reported or may be stale by the replication lag
+func (b *Broker) readStore() Store {
+	if b.replica != nil {
+		return b.replica
+	}
+	return b.store
+}
@@ -0,0 +1,204 @@
+package azurefilebroker
+
+import (
+	"context"
+)
+
+// withContext returns a copy of the broker which stops the reads from the store, the locks, the wait for the mutex of
+// the broker and the calls to Azure when the context of the request is done, e.g. when the platform cancels the request
+// or its deadline passes. The Azure SDK, the REST client and the SQL shim of the broker do not accept a context, so the
+// context is checked before every call instead of interrupting a call in flight.
+func (b *Broker) withContext(ctx context.Context) *Broker {
+	scoped := *b
+	scoped.ctx = ctx
+	scoped.store = &contextStore{Store: b.store, ctx: ctx}
+	if b.replica != nil {
+		scoped.replica = &contextStore{Store: b.replica, ctx: ctx}
+	}
+	return &scoped
+}
+
+// contextError returns an error if the context is done. A nil context is never done.
+func contextError(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return newBrokerError(ErrCodeOperationTimedOut, "The operation was stopped: %v", err)
+	}
+	return nil
+}
+
+// contextMutex is the mutex of the broker. A request stops waiting for it when its context is done, so that the requests
+// queued behind an operation which is stuck in a call to Azure fail with their timeout instead of piling up.
+type contextMutex chan struct{}
+
+func newContextMutex() contextMutex {
+	return make(contextMutex, 1)
+}
+
+func (m contextMutex) Lock() {
+	m <- struct{}{}
+}
+
+func (m contextMutex) Unlock() {
+	<-m
+}
+
+// lockContext locks the mutex, or returns an error if the context is done first. A nil context waits for the mutex.
+func (m contextMutex) lockContext(ctx context.Context) error {
+	if ctx == nil {
+		m.Lock()
+		return nil
+	}
+	select {
+	case m <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+// lockMutex locks the mutex of the broker until the context of the request is done
+func (b *Broker) lockMutex() error {
+	if m, ok := b.mutex.(contextMutex); ok {
+		return m.lockContext(b.ctx)
+	}
+	b.mutex.Lock()
+	return nil
+}
+
+// contextStore is a store whose reads and locks fail once the context is done, which stops the operation at its next
+// step. The writes are passed to the store whatever the context: a stopped operation still records what it already
+// changed in Azure, rolls back its pending records and fixes the share counts and the owners of the file shares. The
+// locks are always released so that a stopped operation does not keep them.
+type contextStore struct {
+	Store
+	ctx context.Context
+}
+
+func (s *contextStore) RetrieveServiceInstance(id string) (ServiceInstance, error) {
+	if err := contextError(s.ctx); err != nil {
+		return ServiceInstance{}, err
+	}
+	return s.Store.RetrieveServiceInstance(id)
+}
+
+func (s *contextStore) RetrieveServiceInstances() (map[string]ServiceInstance, error) {
+	if err := contextError(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.RetrieveServiceInstances()
+}
+
+func (s *contextStore) RetrieveServiceInstancesByTargetName(targetName string) (map[string]ServiceInstance, error) {
+	if err := contextError(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.RetrieveServiceInstancesByTargetName(targetName)
+}
+
+func (s *contextStore) RetrieveBindingDetails(id string) (BindingDetails, error) {
+	if err := contextError(s.ctx); err != nil {
+		return BindingDetails{}, err
+	}
+	return s.Store.RetrieveBindingDetails(id)
+}
+
+func (s *contextStore) RetrieveAllBindingDetails() (map[string]BindingDetails, error) {
+	if err := contextError(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.RetrieveAllBindingDetails()
+}
+
+func (s *contextStore) RetrieveFileShare(id string) (FileShare, error) {
+	if err := contextError(s.ctx); err != nil {
+		return FileShare{}, err
+	}
+	return s.Store.RetrieveFileShare(id)
+}
+
+func (s *contextStore) RetrieveFileShares() (map[string]FileShare, error) {
+	if err := contextError(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.RetrieveFileShares()
+}
+
+func (s *contextStore) RetrieveStorageAccountOwner(id string) (StorageAccountOwner, error) {
+	if err := contextError(s.ctx); err != nil {
+		return StorageAccountOwner{}, err
+	}
+	return s.Store.RetrieveStorageAccountOwner(id)
+}
+
+func (s *contextStore) RetrieveFileShareOwner(id string) (FileShareOwner, error) {
+	if err := contextError(s.ctx); err != nil {
+		return FileShareOwner{}, err
+	}
+	return s.Store.RetrieveFileShareOwner(id)
+}
+
+func (s *contextStore) RetrieveLockHolder(id string) (LockHolder, error) {
+	if err := contextError(s.ctx); err != nil {
+		return LockHolder{}, err
+	}
+	return s.Store.RetrieveLockHolder(id)
+}
+
+func (s *contextStore) RetrieveScheduledDeletion(id string) (ScheduledDeletion, error) {
+	if err := contextError(s.ctx); err != nil {
+		return ScheduledDeletion{}, err
+	}
+	return s.Store.RetrieveScheduledDeletion(id)
+}
+
+func (s *contextStore) RetrieveLockHolders() (map[string]LockHolder, error) {
+	if err := contextError(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.RetrieveLockHolders()
+}
+
+func (s *contextStore) RetrieveScheduledDeletions() (map[string]ScheduledDeletion, error) {
+	if err := contextError(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.RetrieveScheduledDeletions()
+}
+
+func (s *contextStore) RetrieveFeatureFlags() (map[string]FeatureFlag, error) {
+	if err := contextError(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.RetrieveFeatureFlags()
+}
+
+func (s *contextStore) RetrievePendingShareDeletions() (map[string]PendingShareDeletion, error) {
+	if err := contextError(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.RetrievePendingShareDeletions()
+}
+
+func (s *contextStore) RetrievePooledStorageAccounts() (map[string]PooledStorageAccount, error) {
+	if err := contextError(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.RetrievePooledStorageAccounts()
+}
+
+func (s *contextStore) RetrieveInstanceOperations(instanceID string) ([]InstanceOperation, error) {
+	if err := contextError(s.ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.RetrieveInstanceOperations(instanceID)
+}
+
+func (s *contextStore) GetLockForUpdate(lockName string, timeoutInSeconds int) error {
+	if err := contextError(s.ctx); err != nil {
+		return err
+	}
+	return s.Store.GetLockForUpdate(lockName, timeoutInSeconds)
+}
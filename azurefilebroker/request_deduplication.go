@@ -0,0 +1,144 @@
+package azurefilebroker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+// requestDeduplicator returns the result of a request to its retries. The platform retries a request which timed out
+// with the same parameters, so a retry waits for the request which is in progress, or gets its result when it has
+// succeeded in the window, instead of calling Azure again. The failed requests are run again by their retries. The
+// results are kept in memory, so only the retries which reach the same broker process are deduplicated.
+type requestDeduplicator struct {
+	mutex   sync.Mutex
+	clock   clock.Clock
+	window  time.Duration
+	entries map[string]*requestEntry
+}
+
+type requestEntry struct {
+	instanceID  string
+	done        chan struct{}
+	result      interface{}
+	err         error
+	completedAt time.Time
+}
+
+func newRequestDeduplicator(clock clock.Clock, window time.Duration) *requestDeduplicator {
+	return &requestDeduplicator{
+		clock:   clock,
+		window:  window,
+		entries: map[string]*requestEntry{},
+	}
+}
+
+// requestKey returns the key of a request from its operation, the IDs which it is sent to and a fingerprint of its
+// details
+func requestKey(operation, instanceID, bindingID string, details interface{}, asyncAllowed bool) (string, error) {
+	fingerprint, err := json.Marshal(struct {
+		Details      interface{} `json:"details"`
+		AsyncAllowed bool        `json:"async_allowed"`
+	}{details, asyncAllowed})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(fingerprint)
+	return fmt.Sprintf("%s/%s/%s/%s", operation, instanceID, bindingID, hex.EncodeToString(sum[:])), nil
+}
+
+// do runs the request unless the same request is in progress or has succeeded in the window. A request which runs drops
+// the results of the other requests of the instance because it may change what they return.
+func (d *requestDeduplicator) do(logger lager.Logger, key, instanceID string, run func() (interface{}, error)) (interface{}, error) {
+	if d == nil || d.window <= 0 {
+		return run()
+	}
+
+	for {
+		d.mutex.Lock()
+		d.expire()
+		entry, ok := d.entries[key]
+		if !ok {
+			entry = &requestEntry{instanceID: instanceID, done: make(chan struct{})}
+			d.entries[key] = entry
+			d.mutex.Unlock()
+			return d.run(key, entry, run)
+		}
+		d.mutex.Unlock()
+
+		<-entry.done
+		if entry.err == nil {
+			logger.Info("request-deduplicated", lager.Data{"key": key, "completedAt": entry.completedAt})
+			return entry.result, nil
+		}
+		// The failed request has been dropped, so the retry runs it again unless another retry has started first
+	}
+}
+
+func (d *requestDeduplicator) run(key string, entry *requestEntry, run func() (interface{}, error)) (interface{}, error) {
+	result, err := run()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	entry.result, entry.err, entry.completedAt = result, err, d.clock.Now()
+	close(entry.done)
+	for otherKey, other := range d.entries {
+		if other.instanceID != entry.instanceID {
+			continue
+		}
+		if otherKey != key && isClosed(other.done) || otherKey == key && err != nil {
+			delete(d.entries, otherKey)
+		}
+	}
+	return result, err
+}
+
+// forget drops the results of the requests of the instance which have finished
+func (d *requestDeduplicator) forget(instanceID string) {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for key, entry := range d.entries {
+		if entry.instanceID == instanceID && isClosed(entry.done) {
+			delete(d.entries, key)
+		}
+	}
+}
+
+// expire drops the results which are older than the window. The caller must hold the mutex.
+func (d *requestDeduplicator) expire() {
+	deadline := d.clock.Now().Add(-d.window)
+	for key, entry := range d.entries {
+		if isClosed(entry.done) && entry.completedAt.Before(deadline) {
+			delete(d.entries, key)
+		}
+	}
+}
+
+func isClosed(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// deduplicate runs a request of the platform through the deduplicator of the broker
+func (b *Broker) deduplicate(operation, instanceID, bindingID string, details interface{}, asyncAllowed bool, run func() (interface{}, error)) (interface{}, error) {
+	logger := b.logger.Session(operation).WithData(lager.Data{"instanceID": instanceID, "bindingID": bindingID})
+	key, err := requestKey(operation, instanceID, bindingID, details, asyncAllowed)
+	if err != nil {
+		logger.Error("request-key", err)
+		return run()
+	}
+	return b.requests.do(logger, key, instanceID, run)
+}
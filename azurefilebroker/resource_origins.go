@@ -0,0 +1,156 @@
+package azurefilebroker
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+// The gauges of the resources which the broker created or only referenced
+const (
+	metricStorageAccounts = "azurefilebroker_storage_accounts"
+	metricFileShares      = "azurefilebroker_file_shares"
+
+	resourceOriginCreated    = "created"
+	resourceOriginReferenced = "referenced"
+)
+
+// ResourceOrigins counts the storage accounts and the file shares of the instances of a space which the broker created,
+// and the ones which existed before and which it only references. The broker deletes only the resources which it
+// created, so the referenced ones are those which the operators keep when they disallow the creations.
+type ResourceOrigins struct {
+	OrganizationGUID          string `json:"organization_guid"`
+	SpaceGUID                 string `json:"space_guid"`
+	CreatedStorageAccounts    int    `json:"created_storage_accounts"`
+	ReferencedStorageAccounts int    `json:"referenced_storage_accounts"`
+	CreatedFileShares         int    `json:"created_file_shares"`
+	// ReferencedFileShares includes the preexisting SMB shares
+	ReferencedFileShares int `json:"referenced_file_shares"`
+}
+
+// ResourceOrigins returns the origins of the resources of every space which has instances, sorted by org and space.
+// A storage account or a file share used by several instances of a space is counted once.
+func (b *Broker) ResourceOrigins() ([]ResourceOrigins, error) {
+	instances, err := b.readStore().RetrieveServiceInstances()
+	if err != nil {
+		return nil, newStoreError(err, "Failed to retrieve the service instances")
+	}
+	shares, err := b.readStore().RetrieveFileShares()
+	if err != nil {
+		return nil, newStoreError(err, "Failed to retrieve the file shares")
+	}
+	return countResourceOrigins(instances, shares), nil
+}
+
+func countResourceOrigins(instances map[string]ServiceInstance, shares map[string]FileShare) []ResourceOrigins {
+	spaces := map[string]*ResourceOrigins{}
+	counted := map[string]bool{}
+	count := func(instance ServiceInstance, resourceID string, created bool, isStorageAccount bool) {
+		spaceKey := instance.OrganizationGUID + "/" + instance.SpaceGUID
+		key := spaceKey + "/" + strings.ToLower(resourceID)
+		if counted[key] {
+			return
+		}
+		counted[key] = true
+		origins, ok := spaces[spaceKey]
+		if !ok {
+			origins = &ResourceOrigins{OrganizationGUID: instance.OrganizationGUID, SpaceGUID: instance.SpaceGUID}
+			spaces[spaceKey] = origins
+		}
+		switch {
+		case isStorageAccount && created:
+			origins.CreatedStorageAccounts++
+		case isStorageAccount:
+			origins.ReferencedStorageAccounts++
+		case created:
+			origins.CreatedFileShares++
+		default:
+			origins.ReferencedFileShares++
+		}
+	}
+
+	for _, instance := range instances {
+		if instance.IsPreexisting {
+			count(instance, "smb:"+instance.TargetName, false, false)
+			continue
+		}
+		accountID := getStorageAccountOwnerID(instance.SubscriptionID, instance.ResourceGroupName, instance.TargetName)
+		count(instance, "account:"+accountID, instance.IsCreatedStorageAccount, true)
+	}
+	for _, share := range shares {
+		instance, ok := instances[share.InstanceID]
+		if !ok || instance.IsPreexisting {
+			continue
+		}
+		accountID := getStorageAccountOwnerID(instance.SubscriptionID, instance.ResourceGroupName, instance.TargetName)
+		count(instance, "share:"+accountID+"/"+share.FileShareName, share.IsCreated, false)
+	}
+
+	result := []ResourceOrigins{}
+	for _, origins := range spaces {
+		result = append(result, *origins)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].OrganizationGUID != result[j].OrganizationGUID {
+			return result[i].OrganizationGUID < result[j].OrganizationGUID
+		}
+		return result[i].SpaceGUID < result[j].SpaceGUID
+	})
+	return result
+}
+
+// ResourceOriginCollector returns a runner which periodically sets the gauges of the origins of the resources. The
+// gauges of a space without instances any more are set to 0.
+func (b *Broker) ResourceOriginCollector(interval time.Duration) ifrit.Runner {
+	previous := map[string]ResourceOrigins{}
+	return b.newPeriodicRunner("resource-origin-collector", interval, func(logger lager.Logger) {
+		current, err := b.collectResourceOrigins(logger, previous)
+		if err != nil {
+			logger.Error("collect-resource-origins", err)
+			return
+		}
+		previous = current
+	})
+}
+
+// collectResourceOrigins sets the gauges of the origins of the resources and returns them by space
+func (b *Broker) collectResourceOrigins(logger lager.Logger, previous map[string]ResourceOrigins) (map[string]ResourceOrigins, error) {
+	logger = logger.Session("collect-resource-origins")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	origins, err := b.ResourceOrigins()
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]ResourceOrigins{}
+	for _, space := range origins {
+		current[space.OrganizationGUID+"/"+space.SpaceGUID] = space
+	}
+	for key, space := range previous {
+		if _, ok := current[key]; !ok {
+			b.setResourceOriginGauges(ResourceOrigins{OrganizationGUID: space.OrganizationGUID, SpaceGUID: space.SpaceGUID})
+		}
+	}
+	for _, space := range current {
+		b.setResourceOriginGauges(space)
+	}
+	logger.Info("resource-origins-collected", lager.Data{"spaces": len(current)})
+	return current, nil
+}
+
+func (b *Broker) setResourceOriginGauges(space ResourceOrigins) {
+	if b.metrics == nil {
+		return
+	}
+	labels := func(origin string) map[string]string {
+		return map[string]string{"origin": origin, "organization_guid": space.OrganizationGUID, "space_guid": space.SpaceGUID}
+	}
+	b.metrics.SetGauge(metricStorageAccounts, labels(resourceOriginCreated), float64(space.CreatedStorageAccounts))
+	b.metrics.SetGauge(metricStorageAccounts, labels(resourceOriginReferenced), float64(space.ReferencedStorageAccounts))
+	b.metrics.SetGauge(metricFileShares, labels(resourceOriginCreated), float64(space.CreatedFileShares))
+	b.metrics.SetGauge(metricFileShares, labels(resourceOriginReferenced), float64(space.ReferencedFileShares))
+}
@@ -0,0 +1,237 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	scheduledDeletionStorageAccount = "storage-account"
+	scheduledDeletionFileShare      = "file-share"
+
+	// The tag of storage accounts and the metadata of file shares which tell operators when they are deleted
+	pendingDeletionMark = "pending_deletion_after"
+
+	scheduledDeletionPurgeInterval = time.Hour
+)
+
+func getScheduledDeletionID(kind, ownerID string) string {
+	return fmt.Sprintf("%s-%s", kind, ownerID)
+}
+
+// scheduleStorageAccountDeletion keeps the storage account for the retention period instead of deleting it.
+// The storage account is tagged so that operators can see when it is deleted.
+func (b *Broker) scheduleStorageAccountDeletion(logger lager.Logger, storageAccount *StorageAccount, serviceInstance ServiceInstance) error {
+	deleteAfter := b.clock.Now().Add(b.config.cloud.Control.DeletionRetentionPeriod)
+	if err := storageAccount.SDKClient.SetStorageAccountTags(map[string]string{pendingDeletionMark: deleteAfter.UTC().Format(time.RFC3339)}); err != nil {
+		return newAzureError(err, "Failed to tag the storage account %q for deletion", storageAccount.StorageAccountName)
+	}
+
+	ownerID := getStorageAccountOwnerID(serviceInstance.SubscriptionID, serviceInstance.ResourceGroupName, serviceInstance.TargetName)
+	return b.scheduleDeletion(logger, getScheduledDeletionID(scheduledDeletionStorageAccount, ownerID), ScheduledDeletion{
+		Kind:            scheduledDeletionStorageAccount,
+		ServiceInstance: serviceInstance,
+		DeleteAfter:     deleteAfter,
+		DatabaseVersion: databaseVersion,
+	})
+}
+
+// scheduleFileShareDeletion keeps the file share for the retention period instead of deleting it.
+// The caller must hold the lock of the file share in the storage account.
+func (b *Broker) scheduleFileShareDeletion(logger lager.Logger, serviceInstance *ServiceInstance, fileShareName string) error {
+	storageAccount, err := b.newStorageAccountWithSDKClient(logger, serviceInstance)
+	if err != nil {
+		return err
+	}
+	deleteAfter := b.clock.Now().Add(b.config.cloud.Control.DeletionRetentionPeriod)
+	if err := storageAccount.SDKClient.SetFileShareMetadata(fileShareName, pendingDeletionMark, deleteAfter.UTC().Format(time.RFC3339)); err != nil {
+		return newAzureError(err, "Failed to mark the file share %q for deletion", fileShareName)
+	}
+
+	return b.scheduleDeletion(logger, getScheduledDeletionID(scheduledDeletionFileShare, getFileShareOwnerID(serviceInstance, fileShareName)), ScheduledDeletion{
+		Kind:            scheduledDeletionFileShare,
+		ServiceInstance: *serviceInstance,
+		FileShareName:   fileShareName,
+		DeleteAfter:     deleteAfter,
+		DatabaseVersion: databaseVersion,
+	})
+}
+
+func (b *Broker) scheduleDeletion(logger lager.Logger, id string, deletion ScheduledDeletion) error {
+	// A resource which was scheduled, reused and released again gets a new retention period
+	if err := b.store.DeleteScheduledDeletion(id); err != nil {
+		return newStoreError(err, "Failed to delete the scheduled deletion %q from the store", id)
+	}
+	if err := b.store.CreateScheduledDeletion(id, deletion); err != nil {
+		return newStoreError(err, "Failed to insert the scheduled deletion %q into the store", id)
+	}
+	logger.Info("deletion-scheduled", lager.Data{"id": id, "deleteAfter": deletion.DeleteAfter})
+	return nil
+}
+
+// cancelStorageAccountDeletion keeps a storage account which is provisioned again during its retention period.
+// It returns true if a deletion was cancelled, i.e. the storage account was created by the broker.
+func (b *Broker) cancelStorageAccountDeletion(logger lager.Logger, storageAccount *StorageAccount) (bool, error) {
+	if b.config.cloud.Control.DeletionRetentionPeriod <= 0 {
+		return false, nil
+	}
+	ownerID := getStorageAccountOwnerID(storageAccount.SubscriptionID, storageAccount.ResourceGroupName, storageAccount.StorageAccountName)
+	cancelled, err := b.cancelDeletion(logger, getScheduledDeletionID(scheduledDeletionStorageAccount, ownerID))
+	if err != nil || !cancelled {
+		return false, err
+	}
+	if err := storageAccount.SDKClient.SetStorageAccountTags(map[string]string{pendingDeletionMark: ""}); err != nil {
+		logger.Error("remove-pending-deletion-tag", err)
+	}
+	return true, nil
+}
+
+// cancelFileShareDeletion keeps a file share which is bound again during its retention period.
+// It returns true if a deletion was cancelled, i.e. the file share was created by the broker.
+// The caller must hold the lock of the file share in the storage account.
+func (b *Broker) cancelFileShareDeletion(logger lager.Logger, storageAccount *StorageAccount, serviceInstance *ServiceInstance, fileShareName string) (bool, error) {
+	if b.config.cloud.Control.DeletionRetentionPeriod <= 0 {
+		return false, nil
+	}
+	cancelled, err := b.cancelDeletion(logger, getScheduledDeletionID(scheduledDeletionFileShare, getFileShareOwnerID(serviceInstance, fileShareName)))
+	if err != nil || !cancelled {
+		return false, err
+	}
+	if err := storageAccount.SDKClient.SetFileShareMetadata(fileShareName, pendingDeletionMark, ""); err != nil {
+		logger.Error("remove-pending-deletion-metadata", err)
+	}
+	return true, nil
+}
+
+func (b *Broker) cancelDeletion(logger lager.Logger, id string) (bool, error) {
+	if _, err := b.store.RetrieveScheduledDeletion(id); err == brokerapi.ErrInstanceDoesNotExist {
+		return false, nil
+	} else if err != nil {
+		return false, newStoreError(err, "Failed to retrieve the scheduled deletion %q", id)
+	}
+	if err := b.store.DeleteScheduledDeletion(id); err != nil {
+		return false, newStoreError(err, "Failed to delete the scheduled deletion %q from the store", id)
+	}
+	logger.Info("scheduled-deletion-cancelled", lager.Data{"id": id})
+	return true, nil
+}
+
+// ScheduledDeletionPurger returns a runner which periodically deletes the resources whose retention period is over
+func (b *Broker) ScheduledDeletionPurger() ifrit.Runner {
+	return b.newPeriodicRunner("scheduled-deletion-purger", scheduledDeletionPurgeInterval, func(logger lager.Logger) {
+		if err := b.PurgeScheduledDeletions(logger); err != nil {
+			logger.Error("purge-scheduled-deletions", err)
+		}
+	})
+}
+
+// PurgeScheduledDeletions deletes the storage accounts and file shares whose retention period is over
+func (b *Broker) PurgeScheduledDeletions(logger lager.Logger) error {
+	logger = logger.Session("purge-scheduled-deletions")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	deletions, err := b.store.RetrieveScheduledDeletions()
+	if err != nil {
+		return err
+	}
+
+	now := b.clock.Now()
+	for id, deletion := range deletions {
+		if now.Before(deletion.DeleteAfter) {
+			continue
+		}
+		if err := b.purgeScheduledDeletion(logger.WithData(lager.Data{"id": id}), id, deletion); err != nil {
+			logger.Error("purge-scheduled-deletion", err, lager.Data{"id": id})
+		}
+	}
+	return nil
+}
+
+func (b *Broker) purgeScheduledDeletion(logger lager.Logger, id string, deletion ScheduledDeletion) error {
+	// Take the lock which provision and bind hold when they cancel a deletion
+	lockName := deletion.ServiceInstance.TargetName
+	if deletion.Kind == scheduledDeletionFileShare {
+		lockName = getFileShareOwnerID(&deletion.ServiceInstance, deletion.FileShareName)
+	}
+	if err := b.getLockForUpdate(lockName); err != nil {
+		return err
+	}
+	defer b.store.ReleaseLockForUpdate(lockName)
+
+	// The deletion may have been cancelled since it was listed
+	if _, err := b.store.RetrieveScheduledDeletion(id); err == brokerapi.ErrInstanceDoesNotExist {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	switch deletion.Kind {
+	case scheduledDeletionStorageAccount:
+		if err := b.purgeStorageAccount(logger, &deletion.ServiceInstance); err != nil {
+			return err
+		}
+	case scheduledDeletionFileShare:
+		if err := b.purgeFileShare(logger, &deletion.ServiceInstance, deletion.FileShareName); err != nil {
+			return err
+		}
+	default:
+		logger.Info("unknown-scheduled-deletion", lager.Data{"kind": deletion.Kind})
+	}
+	if err := b.store.DeleteScheduledDeletion(id); err != nil {
+		return err
+	}
+	b.deleteUnusedSecret(logger, deletion.ServiceInstance.ServicePrincipal)
+	return nil
+}
+
+func (b *Broker) purgeStorageAccount(logger lager.Logger, serviceInstance *ServiceInstance) error {
+	storageAccount, err := b.newStorageAccountWithSDKClient(logger, serviceInstance)
+	if err != nil {
+		return err
+	}
+	if exist, err := storageAccount.SDKClient.Exists(); err != nil {
+		return err
+	} else if exist {
+		if err := b.checkForeignFileShares(logger, "", serviceInstance, storageAccount); err != nil {
+			// Keep the storage account for good because someone started to use it
+			logger.Error("check-foreign-file-shares", err)
+			return nil
+		}
+		if err := storageAccount.SDKClient.DeleteStorageAccount(); err != nil {
+			return err
+		}
+		logger.Info("storage-account-deleted")
+		b.notifyDeletion(logger, deletionInitiatorPurger, "", serviceInstance, "")
+	}
+	b.deleteStorageAccountAlerts(logger, serviceInstance)
+	b.deleteStorageAccountAccessLogs(logger, serviceInstance)
+
+	ownerID := getStorageAccountOwnerID(serviceInstance.SubscriptionID, serviceInstance.ResourceGroupName, serviceInstance.TargetName)
+	if err := b.store.DeleteStorageAccountOwner(ownerID); err != nil {
+		logger.Error("delete-storage-account-owner", err)
+	}
+	return nil
+}
+
+// purgeFileShare deletes the file share unless it is used again. The caller must hold the lock of the file share in
+// the storage account.
+func (b *Broker) purgeFileShare(logger lager.Logger, serviceInstance *ServiceInstance, fileShareName string) error {
+	// Another instance started to use the share during the retention period
+	if _, err := b.store.RetrieveFileShareOwner(getFileShareOwnerID(serviceInstance, fileShareName)); err == nil {
+		logger.Info("file-share-used-again")
+		return nil
+	} else if err != brokerapi.ErrInstanceDoesNotExist {
+		return err
+	}
+
+	if err := b.deleteFileShare(logger, deletionInitiatorPurger, "", serviceInstance, fileShareName); err != nil {
+		return err
+	}
+	logger.Info("file-share-deleted")
+	return nil
+}
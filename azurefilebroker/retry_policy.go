@@ -0,0 +1,107 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+)
+
+// The default retry policies. The store is not retried by default because most of its errors are not transient.
+const (
+	DefaultAzureRetryMaxAttempts = 4
+	DefaultStoreRetryMaxAttempts = 1
+	DefaultRetryBaseDelay        = 100 * time.Millisecond
+	DefaultRetryMaxDelay         = 2 * time.Second
+)
+
+// RetryPolicy is how often and how fast a failed call is tried again. The delay before the attempt n+1 is
+// BaseDelay * 2^(n-1), capped by MaxDelay. There is no jitter so that the same failures always give the same delays.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one. 1 does not retry.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func NewRetryPolicy(maxAttempts int, baseDelay, maxDelay time.Duration) *RetryPolicy {
+	myConf := new(RetryPolicy)
+
+	myConf.MaxAttempts = maxAttempts
+	myConf.BaseDelay = baseDelay
+	myConf.MaxDelay = maxDelay
+
+	return myConf
+}
+
+func (policy *RetryPolicy) Validate() error {
+	if policy.MaxAttempts < 1 {
+		return fmt.Errorf("Invalid max attempts %d: it must be at least 1", policy.MaxAttempts)
+	}
+	if policy.BaseDelay < 0 || policy.MaxDelay < 0 {
+		return fmt.Errorf("Invalid delays %s and %s: they must not be negative", policy.BaseDelay, policy.MaxDelay)
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		return fmt.Errorf("Invalid max delay %s: it must not be less than the base delay %s", policy.MaxDelay, policy.BaseDelay)
+	}
+	return nil
+}
+
+// Delay returns the delay before the attempt after the failed attempt, which starts at 1
+func (policy *RetryPolicy) Delay(attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay
+}
+
+// Retrier tries a call again by its policy and waits on its clock between the attempts, so that the tests can run
+// the retries with a fake clock or without delays
+type Retrier struct {
+	policy RetryPolicy
+	clock  clock.Clock
+}
+
+func NewRetrier(clock clock.Clock, policy *RetryPolicy) *Retrier {
+	return &Retrier{policy: *policy, clock: clock}
+}
+
+// Do calls call until it succeeds, it returns an error which must not be retried or the attempts are exhausted, and
+// returns its last error. A nil retrier calls it once.
+func (r *Retrier) Do(logger lager.Logger, action string, call func() (retry bool, err error)) error {
+	attempts := 1
+	if r != nil {
+		attempts = r.policy.MaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		retry, err := call()
+		if err == nil || !retry || attempt >= attempts {
+			return err
+		}
+		delay := r.policy.Delay(attempt)
+		logger.Info("retry", lager.Data{"action": action, "attempt": attempt, "delay": delay.String(), "error": err.Error()})
+		if delay > 0 {
+			r.clock.Sleep(delay)
+		}
+	}
+}
+
+// SetRetryPolicies replaces the default retry policy of the requests to Azure and retries the reads and the locks of
+// the store, including its read replica, by the store policy when it has more than one attempt. It must be called
+// after SetMetrics so that every attempt is measured.
+func (b *Broker) SetRetryPolicies(azure, store *RetryPolicy) {
+	b.azureRetrier = NewRetrier(b.clock, azure)
+	if store.MaxAttempts <= 1 {
+		return
+	}
+	b.storeRetrier = NewRetrier(b.clock, store)
+	b.store = newRetryingStore(b.logger, b.store, b.storeRetrier)
+	if b.replica != nil {
+		b.replica = newRetryingStore(b.logger, b.replica, b.storeRetrier)
+	}
+}
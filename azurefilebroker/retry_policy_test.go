@@ -0,0 +1,151 @@
+package azurefilebroker_test
+
+import (
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	. "code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	"code.cloudfoundry.org/azurefilebroker/azurefilebrokerfakes"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+var _ = Describe("RetryPolicy", func() {
+	var (
+		logger *lagertest.TestLogger
+		clock  *fakeclock.FakeClock
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("retry-policy-test")
+		clock = fakeclock.NewFakeClock(time.Now())
+	})
+
+	It("should double the delay up to the max delay", func() {
+		policy := NewRetryPolicy(5, 100*time.Millisecond, time.Second)
+		Expect(policy.Validate()).To(Succeed())
+		Expect(policy.Delay(1)).To(Equal(100 * time.Millisecond))
+		Expect(policy.Delay(2)).To(Equal(200 * time.Millisecond))
+		Expect(policy.Delay(4)).To(Equal(800 * time.Millisecond))
+		Expect(policy.Delay(5)).To(Equal(time.Second))
+	})
+
+	It("should reject the invalid policies", func() {
+		Expect(NewRetryPolicy(0, 0, 0).Validate()).To(MatchError(ContainSubstring("Invalid max attempts 0")))
+		Expect(NewRetryPolicy(3, -time.Second, time.Second).Validate()).To(MatchError(ContainSubstring("must not be negative")))
+		Expect(NewRetryPolicy(3, time.Second, time.Millisecond).Validate()).To(MatchError(ContainSubstring("must not be less than the base delay")))
+	})
+
+	Context("Retrier", func() {
+		var attempts int
+
+		BeforeEach(func() {
+			attempts = 0
+		})
+
+		It("should retry until the call succeeds", func() {
+			retrier := NewRetrier(clock, NewRetryPolicy(3, 0, 0))
+			err := retrier.Do(logger, "test", func() (bool, error) {
+				attempts++
+				if attempts < 3 {
+					return true, errors.New("transient")
+				}
+				return false, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(attempts).To(Equal(3))
+		})
+
+		It("should return the last error after the max attempts or an error which is not retried", func() {
+			retrier := NewRetrier(clock, NewRetryPolicy(2, 0, 0))
+			err := retrier.Do(logger, "test", func() (bool, error) {
+				attempts++
+				return true, errors.New("transient")
+			})
+			Expect(err).To(MatchError("transient"))
+			Expect(attempts).To(Equal(2))
+
+			err = retrier.Do(logger, "test", func() (bool, error) {
+				attempts++
+				return false, errors.New("permanent")
+			})
+			Expect(err).To(MatchError("permanent"))
+			Expect(attempts).To(Equal(3))
+		})
+
+		It("should call once without a retrier", func() {
+			var retrier *Retrier
+			err := retrier.Do(logger, "test", func() (bool, error) {
+				attempts++
+				return true, errors.New("transient")
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(attempts).To(Equal(1))
+		})
+
+		It("should wait on its clock between the attempts", func() {
+			retrier := NewRetrier(clock, NewRetryPolicy(2, time.Minute, time.Minute))
+			done := make(chan error)
+			go func() {
+				done <- retrier.Do(logger, "test", func() (bool, error) {
+					attempts++
+					if attempts < 2 {
+						return true, errors.New("transient")
+					}
+					return false, nil
+				})
+			}()
+			Eventually(clock.WatcherCount).Should(Equal(1))
+			Consistently(done).ShouldNot(Receive())
+			clock.Increment(time.Minute)
+			Eventually(done).Should(Receive(BeNil()))
+		})
+	})
+
+	Context("store", func() {
+		var (
+			fakeStore *azurefilebrokerfakes.FakeStore
+			broker    *Broker
+		)
+
+		BeforeEach(func() {
+			fakeStore = &azurefilebrokerfakes.FakeStore{}
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{IsPreexisting: true, TargetName: "//server/share"}, nil)
+			cloud := NewAzurefilebrokerCloudConfig(
+				NewAzureConfig("AzureCloud", "tenant", "client", "secret", "", "", "", "", "", "", nil),
+				NewControlConfig(true, true, true, true, false, "", false, false, false, 0, 0),
+				NewAzureStackConfig("", "", "", ""),
+				NewCredHubConfig("", "", "", ""),
+			)
+			broker = New(logger, "service-name", "service-id", clock, fakeStore, NewAzurefilebrokerConfig(NewAzurefilebrokerMountConfig(), cloud,
+				NewPreexistingConfig(""), NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0), NewPlacementConfig(""), NewNamingConfig("", ""),
+				NewIsolationSegmentConfig(""), NewCredentialConfig(""), NewStoragePoolConfig(""), NewBackupConfig("", ""), NewAlertConfig("", ""),
+				NewAccessLogConfig("", "", "")))
+			broker.SetRetryPolicies(NewRetryPolicy(1, 0, 0), NewRetryPolicy(3, 0, 0))
+		})
+
+		It("should retry the reads which fail with a transient error", func() {
+			fakeStore.RetrieveServiceInstanceReturnsOnCall(0, ServiceInstance{}, driver.ErrBadConn)
+			fakeStore.RetrieveServiceInstanceReturnsOnCall(1, ServiceInstance{}, errors.New("dial tcp: connection refused"))
+			_, err := broker.ListSharesOfInstance("instance-id")
+			Expect(ErrorCode(err)).To(Equal(ErrCodeInvalidParameters))
+			Expect(fakeStore.RetrieveServiceInstanceCallCount()).To(Equal(3))
+		})
+
+		It("should not retry a missing record or a permanent error", func() {
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{}, brokerapi.ErrInstanceDoesNotExist)
+			_, err := broker.ListSharesOfInstance("instance-id")
+			Expect(ErrorCode(err)).To(Equal(ErrCodeResourceNotFound))
+			Expect(fakeStore.RetrieveServiceInstanceCallCount()).To(Equal(1))
+
+			fakeStore.RetrieveServiceInstanceReturns(ServiceInstance{}, errors.New("Table 'service_instances' doesn't exist"))
+			_, err = broker.ListSharesOfInstance("instance-id")
+			Expect(err).To(HaveOccurred())
+			Expect(fakeStore.RetrieveServiceInstanceCallCount()).To(Equal(2))
+		})
+	})
+})
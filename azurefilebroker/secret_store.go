@@ -0,0 +1,189 @@
+package azurefilebroker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	resty "gopkg.in/resty.v0"
+)
+
+// The prefix of the names of the secrets of the service principals given in the provision parameters
+const servicePrincipalSecretPrefix = "service-principals/"
+
+// ErrSecretDoesNotExist is returned by a SecretStore when no secret has the name
+var ErrSecretDoesNotExist = errors.New("the secret does not exist")
+
+//go:generate counterfeiter -o ../azurefilebrokerfakes/fake_secret_store.go . SecretStore
+
+// SecretStore keeps the secrets of the records out of the StateStore, e.g. the client secrets of the service principals
+// given in the provision parameters. The records only keep the names of their secrets. Without a secret store, the
+// secrets are kept in the records.
+type SecretStore interface {
+	// PutSecret stores the value, marshalled to JSON, under the name and replaces the previous value
+	PutSecret(name string, value interface{}) error
+	// GetSecret unmarshals the value of the secret into value
+	GetSecret(name string, value interface{}) error
+	// DeleteSecret deletes the secret, which succeeds when it does not exist
+	DeleteSecret(name string) error
+}
+
+// SetSecretStore keeps the secrets of the new instances in the secret store instead of the store of the broker. The
+// instances which were provisioned before keep their secrets in their records.
+func (b *Broker) SetSecretStore(secrets SecretStore) {
+	b.config.cloud.secrets = secrets
+}
+
+// storeServicePrincipalSecret moves the credentials of the service principal of the instance to the secret store and
+// returns the service principal which references them. It is the service principal itself when there is no secret
+// store or nothing secret.
+func (b *Broker) storeServicePrincipalSecret(logger lager.Logger, instanceID string, servicePrincipal *ServicePrincipal) (*ServicePrincipal, error) {
+	secrets := b.config.cloud.secrets
+	if secrets == nil || servicePrincipal == nil || servicePrincipal.ClientSecret == "" {
+		return servicePrincipal, nil
+	}
+	name := servicePrincipalSecretPrefix + instanceID
+	if err := secrets.PutSecret(name, servicePrincipal); err != nil {
+		logger.Error("put-service-principal-secret", err, lager.Data{"name": name})
+		return nil, newStoreError(err, "Failed to store the service principal of the instance %q in the secret store", instanceID)
+	}
+	return &ServicePrincipal{
+		TenantID:   servicePrincipal.TenantID,
+		ClientID:   servicePrincipal.ClientID,
+		SecretName: name,
+	}, nil
+}
+
+// deleteUnusedSecret deletes the secret of the service principal once neither an instance nor a scheduled deletion
+// references it. The scheduled deletions keep it until the resources of the instance are purged.
+func (b *Broker) deleteUnusedSecret(logger lager.Logger, servicePrincipal *ServicePrincipal) {
+	if servicePrincipal == nil || servicePrincipal.SecretName == "" || b.config.cloud.secrets == nil {
+		return
+	}
+	name := servicePrincipal.SecretName
+	deletions, err := b.store.RetrieveScheduledDeletions()
+	if err != nil {
+		logger.Error("retrieve-scheduled-deletions", err)
+		return
+	}
+	for _, deletion := range deletions {
+		if sp := deletion.ServiceInstance.ServicePrincipal; sp != nil && sp.SecretName == name {
+			return
+		}
+	}
+	serviceInstances, err := b.store.RetrieveServiceInstances()
+	if err != nil {
+		logger.Error("retrieve-service-instances", err)
+		return
+	}
+	for _, serviceInstance := range serviceInstances {
+		if sp := serviceInstance.ServicePrincipal; sp != nil && sp.SecretName == name {
+			return
+		}
+	}
+	if err := b.config.cloud.secrets.DeleteSecret(name); err != nil {
+		logger.Error("delete-secret", err, lager.Data{"name": name})
+		return
+	}
+	logger.Info("secret-deleted", lager.Data{"name": name})
+}
+
+// credHubSecretStore keeps the secrets as JSON credentials of CredHub under a path
+type credHubSecretStore struct {
+	config *CredHubConfig
+	path   string
+}
+
+// NewCredHubSecretStore returns the secret store which keeps the secrets in CredHub under the path. The UAA client of
+// the CredHub config must be able to write the credentials of the path.
+func NewCredHubSecretStore(config *CredHubConfig, path string) SecretStore {
+	return &credHubSecretStore{config: config, path: "/" + strings.Trim(path, "/")}
+}
+
+func (s *credHubSecretStore) credentialName(name string) string {
+	return s.path + "/" + name
+}
+
+// Reference: https://credhub-api.cfapps.io/#set-credentials
+func (s *credHubSecretStore) PutSecret(name string, value interface{}) error {
+	token, err := s.config.getToken()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"name":  s.credentialName(name),
+		"type":  "json",
+		"value": value,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := resty.R().
+		SetHeader("Content-Type", "application/json").
+		SetAuthToken(token).
+		SetBody(body).
+		Put(s.config.URL + "/api/v1/data")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("Error Code: %d, %v", resp.StatusCode(), resp)
+	}
+	return nil
+}
+
+// Reference: https://credhub-api.cfapps.io/#get-by-name
+func (s *credHubSecretStore) GetSecret(name string, value interface{}) error {
+	token, err := s.config.getToken()
+	if err != nil {
+		return err
+	}
+	resp, err := resty.R().
+		SetQueryParams(map[string]string{"name": s.credentialName(name), "current": "true"}).
+		SetAuthToken(token).
+		Get(s.config.URL + "/api/v1/data")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() == http.StatusNotFound {
+		return ErrSecretDoesNotExist
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("Error Code: %d, %v", resp.StatusCode(), resp)
+	}
+
+	var result struct {
+		Data []struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return err
+	}
+	if len(result.Data) == 0 {
+		return ErrSecretDoesNotExist
+	}
+	return json.Unmarshal(result.Data[0].Value, value)
+}
+
+// Reference: https://credhub-api.cfapps.io/#delete-credentials
+func (s *credHubSecretStore) DeleteSecret(name string) error {
+	token, err := s.config.getToken()
+	if err != nil {
+		return err
+	}
+	resp, err := resty.R().
+		SetQueryParams(map[string]string{"name": s.credentialName(name)}).
+		SetAuthToken(token).
+		Delete(s.config.URL + "/api/v1/data")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusNoContent && resp.StatusCode() != http.StatusNotFound {
+		return fmt.Errorf("Error Code: %d, %v", resp.StatusCode(), resp)
+	}
+	return nil
+}
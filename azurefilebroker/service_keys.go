@@ -0,0 +1,35 @@
+package azurefilebroker
+
+import "fmt"
+
+// isServiceKey returns true if the bind has no app, e.g. the bind of cf create-service-key, whose credentials are used
+// outside of the platform instead of a volume mount
+func isServiceKey(appGUID string) bool {
+	return appGUID == ""
+}
+
+// serviceKeyCredentials returns the credentials with which an SMB client mounts the share of a service key: the share,
+// the user and its password, which is the access key of the storage account for AzureFileShare, or the SAS token of
+// the access policy when the plan does not return the access key
+func serviceKeyCredentials(serviceInstance *ServiceInstance, bindOptions BindOptions, mountConfig map[string]interface{}, shareSAS string) map[string]string {
+	credentials := map[string]string{}
+	for _, key := range []string{"source", "username", "password", "domain", "vers"} {
+		value, ok := mountConfig[key]
+		if !ok || fmt.Sprint(value) == "" {
+			continue
+		}
+		if key == "source" {
+			key = "share"
+		}
+		credentials[key] = fmt.Sprint(value)
+	}
+	if !serviceInstance.IsPreexisting {
+		credentials["storage_account_name"] = serviceInstance.TargetName
+		credentials["file_share_name"] = bindOptions.FileShareName
+	}
+	if shareSAS != "" {
+		credentials["access_policy_id"] = bindOptions.AccessPolicy.ID
+		credentials["share_sas"] = shareSAS
+	}
+	return credentials
+}
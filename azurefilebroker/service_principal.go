@@ -0,0 +1,208 @@
+package azurefilebroker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	resty "gopkg.in/resty.v0"
+)
+
+// ServicePrincipal is a service principal which is given in the provision parameters so that the broker manages the
+// storage account of the service instance in a subscription which the service principal of the broker cannot access.
+// Either the credentials or a reference to a JSON credential in CredHub with the keys tenant_id, client_id and
+// client_secret are set. The credentials are kept in the store of the broker, or in its secret store under SecretName,
+// while a reference is resolved every time the service principal is used. When only the tenant is set, the service principal of the broker authenticates in that
+// tenant, which must be one of the auxiliary tenants of the broker.
+type ServicePrincipal struct {
+	TenantID     string `json:"tenant_id,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	CredHubRef   string `json:"credhub_ref,omitempty"`
+	SecretName   string `json:"secret_name,omitempty"` // The name of the credentials in the secret store of the broker
+}
+
+// servicePrincipal returns the service principal in the provision parameters or nil if there is none
+func (config *Configuration) servicePrincipal() (*ServicePrincipal, error) {
+	servicePrincipal := ServicePrincipal{
+		TenantID:     config.TenantID,
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		CredHubRef:   config.CredHubRef,
+	}
+	hasCredentials := servicePrincipal.ClientID != "" || servicePrincipal.ClientSecret != ""
+	if !hasCredentials && servicePrincipal.TenantID == "" && servicePrincipal.CredHubRef == "" {
+		return nil, nil
+	}
+	if (hasCredentials || servicePrincipal.TenantID != "") && servicePrincipal.CredHubRef != "" {
+		return nil, newBrokerError(ErrCodeInvalidParameters, "Either credhub_ref or tenant_id, client_id and client_secret can be given")
+	}
+	if hasCredentials && (servicePrincipal.TenantID == "" || servicePrincipal.ClientID == "" || servicePrincipal.ClientSecret == "") {
+		return nil, newBrokerError(ErrCodeInvalidParameters, "tenant_id, client_id and client_secret must be given together")
+	}
+	return &servicePrincipal, nil
+}
+
+// isDelegated returns true if the service principal of the broker authenticates in the tenant of the service principal
+func (servicePrincipal *ServicePrincipal) isDelegated() bool {
+	return servicePrincipal.ClientID == "" && servicePrincipal.CredHubRef == "" && servicePrincipal.SecretName == ""
+}
+
+type CredHubConfig struct {
+	URL          string
+	UAAURL       string
+	ClientID     string
+	ClientSecret string
+}
+
+func NewCredHubConfig(url, uaaURL, clientID, clientSecret string) *CredHubConfig {
+	myConf := new(CredHubConfig)
+
+	myConf.URL = strings.TrimRight(url, "/")
+	myConf.UAAURL = strings.TrimRight(uaaURL, "/")
+	myConf.ClientID = clientID
+	myConf.ClientSecret = clientSecret
+
+	return myConf
+}
+
+// IsEnabled returns true if service principals can be referenced in CredHub
+func (config *CredHubConfig) IsEnabled() bool {
+	return config.URL != ""
+}
+
+func (config *CredHubConfig) Validate() error {
+	if !config.IsEnabled() {
+		return nil
+	}
+	missingKeys := []string{}
+	if config.UAAURL == "" {
+		missingKeys = append(missingKeys, "credhubUAAURL")
+	}
+	if config.ClientID == "" {
+		missingKeys = append(missingKeys, "credhubClientID")
+	}
+	if config.ClientSecret == "" {
+		missingKeys = append(missingKeys, "CREDHUB_CLIENT_SECRET")
+	}
+	if len(missingKeys) > 0 {
+		return errors.New("Missing required parameters when 'credhubURL' is set: " + strings.Join(missingKeys, ", "))
+	}
+	return nil
+}
+
+// withServicePrincipal returns the cloud config which authenticates with the service principal. It is the cloud config
+// itself when the service principal is nil.
+func (config *CloudConfig) withServicePrincipal(servicePrincipal *ServicePrincipal) (*CloudConfig, error) {
+	if servicePrincipal == nil {
+		return config, nil
+	}
+	if servicePrincipal.isDelegated() {
+		if !config.Azure.isAuxiliaryTenant(servicePrincipal.TenantID) {
+			return nil, newBrokerError(ErrCodeInvalidParameters, "The tenant %q has not granted access to the broker: client_id and client_secret must be given with tenant_id", servicePrincipal.TenantID)
+		}
+		// The token of the tenant of the broker is sent too so that Azure Resource Manager accepts the references to
+		// its resources, e.g. the virtual networks of the platform
+		myConf := *config
+		myConf.Azure.TenanID = servicePrincipal.TenantID
+		myConf.Azure.auxiliaryTokenTenantID = config.Azure.TenanID
+		return &myConf, nil
+	}
+	credentials := *servicePrincipal
+	if credentials.CredHubRef != "" {
+		if !config.CredHub.IsEnabled() {
+			return nil, newBrokerError(ErrCodeInvalidParameters, "credhub_ref cannot be used because CredHub is not configured in the broker")
+		}
+		var err error
+		if credentials, err = config.CredHub.getServicePrincipal(credentials.CredHubRef); err != nil {
+			return nil, fmt.Errorf("Failed to get the service principal %q from CredHub: %v", servicePrincipal.CredHubRef, err)
+		}
+	}
+	if credentials.SecretName != "" {
+		if config.secrets == nil {
+			return nil, fmt.Errorf("Failed to get the service principal %q: the broker has no secret store", servicePrincipal.SecretName)
+		}
+		if err := config.secrets.GetSecret(credentials.SecretName, &credentials); err != nil {
+			return nil, fmt.Errorf("Failed to get the service principal %q from the secret store: %v", servicePrincipal.SecretName, err)
+		}
+	}
+
+	myConf := *config
+	myConf.Azure.TenanID = credentials.TenantID
+	myConf.Azure.ClientID = credentials.ClientID
+	myConf.Azure.ClientSecret = credentials.ClientSecret
+	return &myConf, nil
+}
+
+// getServicePrincipal reads the current value of the JSON credential with the given name
+// Reference: https://credhub-api.cfapps.io/#get-by-name
+func (config *CredHubConfig) getServicePrincipal(name string) (ServicePrincipal, error) {
+	token, err := config.getToken()
+	if err != nil {
+		return ServicePrincipal{}, err
+	}
+
+	resp, err := resty.R().
+		SetQueryParams(map[string]string{"name": name, "current": "true"}).
+		SetAuthToken(token).
+		Get(config.URL + "/api/v1/data")
+	if err != nil {
+		return ServicePrincipal{}, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return ServicePrincipal{}, fmt.Errorf("Error Code: %d, %v", resp.StatusCode(), resp)
+	}
+
+	var result struct {
+		Data []struct {
+			Value ServicePrincipal `json:"value"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.Body(), &result); err != nil {
+		return ServicePrincipal{}, err
+	}
+	if len(result.Data) == 0 {
+		return ServicePrincipal{}, errors.New("the credential does not exist")
+	}
+	servicePrincipal := result.Data[0].Value
+	if servicePrincipal.TenantID == "" || servicePrincipal.ClientID == "" || servicePrincipal.ClientSecret == "" {
+		return ServicePrincipal{}, errors.New("the credential must have tenant_id, client_id and client_secret")
+	}
+	servicePrincipal.CredHubRef = ""
+	return servicePrincipal, nil
+}
+
+func (config *CredHubConfig) getToken() (string, error) {
+	return getClientCredentialsToken(config.UAAURL, config.ClientID, config.ClientSecret)
+}
+
+// getClientCredentialsToken gets an access token of the UAA client by the client credentials grant
+func getClientCredentialsToken(uaaURL, clientID, clientSecret string) (string, error) {
+	body := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"response_type": {"token"},
+	}
+	resp, err := resty.R().
+		SetHeader("Content-Type", contentTypeWWW).
+		SetBody(body.Encode()).
+		Post(uaaURL + "/oauth/token")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return "", fmt.Errorf("Error Code: %d, %v", resp.StatusCode(), resp)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(resp.Body(), &token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,252 @@
+package azurefilebroker
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const (
+	// Azure allows up to five stored access policies on a file share
+	maxShareAccessPolicies       = 5
+	maxShareAccessPolicyIDLength = 64
+	// The permissions of a file share SAS in the order in which Azure expects them
+	shareAccessPolicyPermissions = "rcwdl"
+	// The permissions of the SAS tokens of a read-only instance
+	readOnlyShareAccessPolicyPermissions = "rl"
+)
+
+// ShareAccessPolicy is a stored access policy of a file share. The SAS tokens which reference it are revoked when the
+// policy is deleted, so the keys of the storage account do not have to be rotated.
+// Reference: https://docs.microsoft.com/en-us/rest/api/storageservices/define-stored-access-policy
+type ShareAccessPolicy struct {
+	ID string `json:"id"`
+	// Permissions are the letters r(ead), c(reate), w(rite), d(elete) and l(ist)
+	Permissions string    `json:"permissions"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// isReference returns true if the policy of the bind parameters only has the ID of a policy defined on the share
+func (policy ShareAccessPolicy) isReference() bool {
+	return policy.Permissions == "" && policy.Expiry.IsZero()
+}
+
+// normalized returns the policy with the permissions in the order of Azure and the expiry in UTC
+func (policy ShareAccessPolicy) normalized() (ShareAccessPolicy, error) {
+	if policy.ID == "" || len(policy.ID) > maxShareAccessPolicyIDLength {
+		return policy, newBrokerError(ErrCodeInvalidParameters, "Invalid access policy ID %q: it must have 1 to %d characters", policy.ID, maxShareAccessPolicyIDLength)
+	}
+	if policy.isReference() {
+		return policy, nil
+	}
+	if policy.Expiry.IsZero() {
+		return policy, newBrokerError(ErrCodeInvalidParameters, "Missing expiry of the access policy %q", policy.ID)
+	}
+
+	permissions := ""
+	for _, permission := range shareAccessPolicyPermissions {
+		if count := strings.Count(policy.Permissions, string(permission)); count > 1 {
+			return policy, newBrokerError(ErrCodeInvalidParameters, "Invalid permissions %q of the access policy %q: %q is repeated", policy.Permissions, policy.ID, permission)
+		} else if count == 1 {
+			permissions += string(permission)
+		}
+	}
+	if permissions == "" || len(permissions) != len(policy.Permissions) {
+		return policy, newBrokerError(ErrCodeInvalidParameters, "Invalid permissions %q of the access policy %q: expected some of %s", policy.Permissions, policy.ID, shareAccessPolicyPermissions)
+	}
+	policy.Permissions = permissions
+	policy.Expiry = policy.Expiry.UTC()
+	return policy, nil
+}
+
+func equalShareAccessPolicies(a, b []ShareAccessPolicy) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || a[i].Permissions != b[i].Permissions || !a[i].Expiry.Equal(b[i].Expiry) {
+			return false
+		}
+	}
+	return true
+}
+
+// isReadOnly returns true if the policy only reads and lists the file share
+func (policy ShareAccessPolicy) isReadOnly() bool {
+	return strings.Trim(policy.Permissions, readOnlyShareAccessPolicyPermissions) == ""
+}
+
+// checkReadOnly refuses a policy which writes to the file share of a read-only instance
+func (policy ShareAccessPolicy) checkReadOnly(serviceInstance *ServiceInstance) error {
+	if serviceInstance.Readonly && !policy.isReadOnly() {
+		return newBrokerError(ErrCodeInvalidParameters, "The service instance is read-only: the access policy %q must only have the permissions %s", policy.ID, readOnlyShareAccessPolicyPermissions)
+	}
+	return nil
+}
+
+// normalizeShareAccessPolicies validates the policies of a file share, which must be defined and expire after now
+func normalizeShareAccessPolicies(fileShareName string, policies []ShareAccessPolicy, now time.Time) ([]ShareAccessPolicy, error) {
+	if len(policies) > maxShareAccessPolicies {
+		return nil, newBrokerError(ErrCodeInvalidParameters, "The file share %q can have at most %d access policies", fileShareName, maxShareAccessPolicies)
+	}
+	normalized := []ShareAccessPolicy{}
+	ids := map[string]bool{}
+	for _, policy := range policies {
+		policy, err := policy.normalized()
+		if err != nil {
+			return nil, err
+		}
+		if policy.isReference() {
+			return nil, newBrokerError(ErrCodeInvalidParameters, "Missing permissions and expiry of the access policy %q", policy.ID)
+		}
+		if !policy.Expiry.After(now) {
+			return nil, newBrokerError(ErrCodeInvalidParameters, "The access policy %q has expired", policy.ID)
+		}
+		if ids[policy.ID] {
+			return nil, newBrokerError(ErrCodeInvalidParameters, "The access policy %q is repeated", policy.ID)
+		}
+		ids[policy.ID] = true
+		normalized = append(normalized, policy)
+	}
+	sort.Slice(normalized, func(i, j int) bool { return normalized[i].ID < normalized[j].ID })
+	return normalized, nil
+}
+
+// issueShareSAS defines the access policy of the bind parameters on the file share, unless it only references a
+// policy defined by an update, and returns a SAS token which references the policy
+func (b *Broker) issueShareSAS(logger lager.Logger, serviceInstance *ServiceInstance, fileShareName string, policy ShareAccessPolicy) (string, error) {
+	logger = logger.Session("issue-share-sas").WithData(lager.Data{"FileShareName": fileShareName, "accessPolicyID": policy.ID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	// The policies of a share are replaced as a whole, so they are changed under the lock of the share in the storage
+	// account like its creation
+	ownerID := getFileShareOwnerID(serviceInstance, fileShareName)
+	if err := b.getLockForUpdate(ownerID); err != nil {
+		logger.Error("get-lock-for-update", err)
+		return "", err
+	}
+	defer b.store.ReleaseLockForUpdate(ownerID)
+
+	restClient, err := b.newRESTClientOfServiceInstance(logger, serviceInstance)
+	if err != nil {
+		return "", err
+	}
+	policies, err := restClient.GetFileShareAccessPolicies(fileShareName)
+	if err != nil {
+		return "", newAzureError(err, "Failed to get the access policies of the file share %q", fileShareName)
+	}
+
+	updated := []ShareAccessPolicy{}
+	found := false
+	for _, existing := range policies {
+		if existing.ID == policy.ID {
+			found = true
+			if !policy.isReference() {
+				existing = policy
+			}
+		}
+		updated = append(updated, existing)
+	}
+	if !found {
+		if policy.isReference() {
+			return "", newBrokerError(ErrCodeInvalidParameters, "The access policy %q is not defined on the file share %q", policy.ID, fileShareName)
+		}
+		updated = append(updated, policy)
+	}
+	if len(updated) > maxShareAccessPolicies {
+		return "", newBrokerError(ErrCodeInvalidParameters, "The file share %q already has %d access policies", fileShareName, maxShareAccessPolicies)
+	}
+	// A read-only instance never issues a SAS token which writes, even for a policy defined before
+	for _, defined := range updated {
+		if defined.ID == policy.ID {
+			if err := defined.checkReadOnly(serviceInstance); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if !equalShareAccessPolicies(updated, policies) {
+		if err := restClient.SetFileShareAccessPolicies(fileShareName, updated); err != nil {
+			return "", newAzureError(err, "Failed to set the access policies of the file share %q", fileShareName)
+		}
+		logger.Info("share-access-policy-set")
+	}
+
+	sasToken, err := restClient.GetFileShareSAS(fileShareName, policy.ID)
+	if err != nil {
+		return "", newAzureError(err, "Failed to get a SAS token of the access policy %q of the file share %q", policy.ID, fileShareName)
+	}
+	return sasToken, nil
+}
+
+// setShareAccessPolicies replaces the access policies of the file shares of the instance. The SAS tokens of a policy
+// which is left out are revoked.
+func (b *Broker) setShareAccessPolicies(logger lager.Logger, instanceID string, serviceInstance *ServiceInstance, sharePolicies map[string][]ShareAccessPolicy) error {
+	logger = logger.Session("set-share-access-policies")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if serviceInstance.IsPreexisting {
+		return newBrokerError(ErrCodeInvalidParameters, "The parameter share_access_policies is only supported by AzureFileShare instances")
+	}
+
+	fileShareNames := []string{}
+	normalized := map[string][]ShareAccessPolicy{}
+	for fileShareName, policies := range sharePolicies {
+		if _, err := b.store.RetrieveFileShare(getFileShareID(instanceID, fileShareName)); err == brokerapi.ErrInstanceDoesNotExist {
+			return newBrokerError(ErrCodeInvalidParameters, "The file share %q is not bound to the service instance", fileShareName)
+		} else if err != nil {
+			return newStoreError(err, "Failed to retrieve the file share %q", fileShareName)
+		}
+		var err error
+		if normalized[fileShareName], err = normalizeShareAccessPolicies(fileShareName, policies, b.clock.Now()); err != nil {
+			return err
+		}
+		for _, policy := range normalized[fileShareName] {
+			if err := policy.checkReadOnly(serviceInstance); err != nil {
+				return err
+			}
+		}
+		fileShareNames = append(fileShareNames, fileShareName)
+	}
+	sort.Strings(fileShareNames)
+
+	restClient, err := b.newRESTClientOfServiceInstance(logger, serviceInstance)
+	if err != nil {
+		return err
+	}
+	for _, fileShareName := range fileShareNames {
+		if err := b.setAccessPoliciesOfShare(logger, restClient, serviceInstance, fileShareName, normalized[fileShareName]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Broker) setAccessPoliciesOfShare(logger lager.Logger, restClient AzureStorageAccountRESTClient, serviceInstance *ServiceInstance, fileShareName string, policies []ShareAccessPolicy) error {
+	ownerID := getFileShareOwnerID(serviceInstance, fileShareName)
+	if err := b.getLockForUpdate(ownerID); err != nil {
+		logger.Error("get-lock-for-update", err)
+		return err
+	}
+	defer b.store.ReleaseLockForUpdate(ownerID)
+
+	if err := restClient.SetFileShareAccessPolicies(fileShareName, policies); err != nil {
+		return newAzureError(err, "Failed to set the access policies of the file share %q", fileShareName)
+	}
+	logger.Info("share-access-policies-set", lager.Data{"FileShareName": fileShareName, "count": len(policies)})
+	return nil
+}
+
+// newRESTClientOfServiceInstance returns the REST client of the storage account of an AzureFileShare instance
+func (b *Broker) newRESTClientOfServiceInstance(logger lager.Logger, serviceInstance *ServiceInstance) (AzureStorageAccountRESTClient, error) {
+	storageAccount, err := b.newStorageAccountOfInstance(logger, serviceInstance)
+	if err != nil {
+		return nil, err
+	}
+	return NewAzureStorageAccountRESTClient(logger, &b.config.cloud, storageAccount)
+}
@@ -0,0 +1,124 @@
+package azurefilebroker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	// boundAppsMetadata is the metadata of a file share which lists the apps bound to it, e.g.
+	// 1b8a5c2e-0000-4000-8000-000000000000(web),2c9b6d3f-0000-4000-8000-000000000000
+	boundAppsMetadata = "bound_apps"
+	// The metadata of a file share is limited to 8 KiB in total, so the list is cut to leave room for the others
+	maxBoundAppsMetadataLength = 4096
+)
+
+// appNameOfContext returns the name of the app in the context of a bind request, which Cloud Foundry only sends in
+// recent versions
+func appNameOfContext(rawContext json.RawMessage) string {
+	if len(rawContext) == 0 {
+		return ""
+	}
+	context := struct {
+		AppName string `json:"app_name"`
+	}{}
+	json.Unmarshal(rawContext, &context)
+	return context.AppName
+}
+
+// boundAppsMetadataValue renders the apps, keyed by their GUIDs, sorted by GUID. The metadata is sent in HTTP headers,
+// so the characters of the app names which are not printable ASCII, and the separators, are replaced.
+func boundAppsMetadataValue(apps map[string]string) string {
+	appGUIDs := []string{}
+	for appGUID := range apps {
+		appGUIDs = append(appGUIDs, appGUID)
+	}
+	sort.Strings(appGUIDs)
+
+	entries := []string{}
+	length := 0
+	for i, appGUID := range appGUIDs {
+		entry := appGUID
+		if name := apps[appGUID]; name != "" {
+			entry = fmt.Sprintf("%s(%s)", appGUID, strings.Map(func(r rune) rune {
+				if r < ' ' || r > '~' || strings.ContainsRune(",()", r) {
+					return '_'
+				}
+				return r
+			}, name))
+		}
+		// Room is kept for the count of the apps which are left out
+		if length+len(entry)+len(",+0000 more") > maxBoundAppsMetadataLength {
+			entries = append(entries, fmt.Sprintf("+%d more", len(appGUIDs)-i))
+			break
+		}
+		entries = append(entries, entry)
+		length += len(entry) + 1
+	}
+	return strings.Join(entries, ",")
+}
+
+// updateBoundAppsMetadata lists the apps which are bound to the file share, through any instance of its storage
+// account, in the metadata of the share. The binding which is being deleted is left out. The bindings created by
+// older versions of the broker do not record their file share, so their apps are not listed, and neither are the
+// service keys, which have no app. A failure is only logged because the metadata is only informational. The caller
+// must hold the lock of the file share in the storage account.
+func (b *Broker) updateBoundAppsMetadata(logger lager.Logger, serviceInstance *ServiceInstance, fileShareName, deletedBindingID string) {
+	logger = logger.Session("update-bound-apps-metadata").WithData(lager.Data{"FileShareName": fileShareName})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	instances, err := b.store.RetrieveServiceInstancesByTargetName(serviceInstance.TargetName)
+	if err != nil {
+		logger.Error("retrieve-service-instances", err)
+		return
+	}
+	fileShareIDs := map[string]bool{}
+	for instanceID, instance := range instances {
+		if !instance.IsPreexisting && instance.SubscriptionID == serviceInstance.SubscriptionID && instance.ResourceGroupName == serviceInstance.ResourceGroupName {
+			fileShareIDs[getFileShareID(instanceID, fileShareName)] = true
+		}
+	}
+	bindings, err := b.store.RetrieveAllBindingDetails()
+	if err != nil {
+		logger.Error("retrieve-bindings", err)
+		return
+	}
+	apps := map[string]string{}
+	for bindingID, bindingDetails := range bindings {
+		if bindingID == deletedBindingID || !fileShareIDs[bindingDetails.FileShareID] || isServiceKey(bindingDetails.AppGUID) {
+			continue
+		}
+		if name := appNameOfContext(bindingDetails.RawContext); name != "" || apps[bindingDetails.AppGUID] == "" {
+			apps[bindingDetails.AppGUID] = name
+		}
+	}
+
+	storageAccount, err := b.newStorageAccountWithSDKClient(logger, serviceInstance)
+	if err != nil {
+		logger.Error("new-storage-account", err)
+		return
+	}
+	if err := storageAccount.SDKClient.SetFileShareMetadata(fileShareName, boundAppsMetadata, boundAppsMetadataValue(apps)); err != nil {
+		logger.Error("set-file-share-metadata", err)
+		return
+	}
+	logger.Info("bound-apps-metadata-updated", lager.Data{"apps": len(apps)})
+}
+
+// refreshBoundAppsMetadata updates the metadata of the bound apps under the lock of the file share in the storage
+// account
+func (b *Broker) refreshBoundAppsMetadata(logger lager.Logger, serviceInstance *ServiceInstance, fileShareName, deletedBindingID string) {
+	ownerID := getFileShareOwnerID(serviceInstance, fileShareName)
+	if err := b.getLockForUpdate(ownerID); err != nil {
+		logger.Error("get-lock-for-update", err)
+		return
+	}
+	defer b.store.ReleaseLockForUpdate(ownerID)
+
+	b.updateBoundAppsMetadata(logger, serviceInstance, fileShareName, deletedBindingID)
+}
@@ -0,0 +1,142 @@
+package azurefilebroker
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/tedsuo/ifrit"
+)
+
+// ShareCountRepairer returns a runner which periodically repairs the reference counts of file shares
+func (b *Broker) ShareCountRepairer(interval time.Duration) ifrit.Runner {
+	return b.newPeriodicRunner("share-count-repairer", interval, func(logger lager.Logger) {
+		if err := b.RepairShareCounts(logger); err != nil {
+			logger.Error("repair-share-counts", err)
+		}
+	})
+}
+
+// RepairShareCounts recomputes the reference count of every file share from the bindings which refer to it.
+// Bindings created by older versions of the broker do not record their file share, so the counts are not
+// repaired while such bindings exist.
+func (b *Broker) RepairShareCounts(logger lager.Logger) error {
+	logger = logger.Session("repair-share-counts")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	bindings, err := b.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return err
+	}
+	if bindingID, ok := legacyBinding(bindings); ok {
+		logger.Info("skip-repair-for-legacy-binding", lager.Data{"bindingID": bindingID})
+		return nil
+	}
+
+	shares, err := b.store.RetrieveFileShares()
+	if err != nil {
+		return err
+	}
+
+	repaired := 0
+	for fileShareID := range shares {
+		ok, err := b.repairShareCount(logger, fileShareID)
+		if err != nil {
+			logger.Error("repair-share-count", err, lager.Data{"fileShareID": fileShareID})
+			continue
+		}
+		if ok {
+			repaired++
+		}
+	}
+	logger.Info("share-counts-repaired", lager.Data{"checked": len(shares), "repaired": repaired})
+	return nil
+}
+
+// repairInstanceShareCounts recomputes the reference counts of the file shares of one service instance
+func (b *Broker) repairInstanceShareCounts(logger lager.Logger, instanceID string) error {
+	logger = logger.Session("repair-instance-share-counts").WithData(lager.Data{"instanceID": instanceID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	bindings, err := b.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return err
+	}
+	if bindingID, ok := legacyBinding(bindings); ok {
+		logger.Info("skip-repair-for-legacy-binding", lager.Data{"bindingID": bindingID})
+		return nil
+	}
+
+	shares, err := b.store.RetrieveFileShares()
+	if err != nil {
+		return err
+	}
+	for fileShareID, share := range shares {
+		if share.InstanceID != instanceID {
+			continue
+		}
+		if _, err := b.repairShareCount(logger, fileShareID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyBinding returns a binding created by an older version of the broker, which does not record its file share
+func legacyBinding(bindings map[string]BindingDetails) (string, bool) {
+	for bindingID, bindingDetails := range bindings {
+		if bindingDetails.FileShareID == "" && len(bindingDetails.RawParameters) > 0 {
+			return bindingID, true
+		}
+	}
+	return "", false
+}
+
+// repairShareCount fixes the count of one file share under its lock. It returns true if the count was wrong.
+func (b *Broker) repairShareCount(logger lager.Logger, fileShareID string) (bool, error) {
+	if err := b.getLockForUpdate(fileShareID); err != nil {
+		return false, err
+	}
+	defer b.store.ReleaseLockForUpdate(fileShareID)
+
+	// Bind and unbind change the bindings of a file share only under its lock, so they must be read again here
+	share, err := b.store.RetrieveFileShare(fileShareID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	bindings, err := b.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return false, err
+	}
+
+	count := 0
+	for _, bindingDetails := range bindings {
+		if bindingDetails.FileShareID == fileShareID {
+			count++
+		}
+	}
+	if count == share.Count {
+		return false, nil
+	}
+
+	data := lager.Data{"fileShareID": fileShareID, "storedCount": share.Count, "actualCount": count}
+	if count == 0 {
+		// The file share itself is kept because it may still have data which was not deleted at unbind
+		if err := b.store.DeleteFileShare(fileShareID); err != nil {
+			return false, err
+		}
+		logger.Info("unreferenced-file-share-removed-from-store", data)
+		return true, nil
+	}
+
+	share.Count = count
+	if err := b.store.UpdateFileShare(fileShareID, share); err != nil {
+		return false, err
+	}
+	logger.Info("file-share-count-repaired", data)
+	return true, nil
+}
@@ -0,0 +1,199 @@
+package azurefilebroker
+
+import (
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	shareDeletionRetryInterval = 60 * time.Second
+	// The retries of a deletion back off exponentially from the first to the maximum delay
+	shareDeletionFirstRetryDelay = time.Minute
+	shareDeletionMaxRetryDelay   = 6 * time.Hour
+)
+
+// PendingShareDeletion is a file share whose deletion failed at unbind when the share deletion failure policy is
+// "retry". It is kept in the store until the share is deleted or used again.
+type PendingShareDeletion struct {
+	FileShareID     string          `json:"file_share_id"`
+	FileShareName   string          `json:"file_share_name"`
+	InstanceID      string          `json:"instance_id"`
+	ServiceInstance ServiceInstance `json:"service_instance"`
+	Attempts        int             `json:"attempts"`
+	LastError       string          `json:"last_error"`
+	CreatedAt       time.Time       `json:"created_at"`
+	NextAttemptAt   time.Time       `json:"next_attempt_at"`
+	DatabaseVersion string          `json:"database_version"`
+}
+
+// PendingShareDeletionStatus is a pending share deletion without the service instance, which may have the credentials
+// of a service principal
+type PendingShareDeletionStatus struct {
+	FileShareID   string    `json:"file_share_id"`
+	FileShareName string    `json:"file_share_name"`
+	InstanceID    string    `json:"instance_id"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error"`
+	CreatedAt     time.Time `json:"created_at"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// shareDeletionRetryDelay returns how long to wait after the given number of failed attempts
+func shareDeletionRetryDelay(attempts int) time.Duration {
+	delay := shareDeletionFirstRetryDelay
+	for i := 1; i < attempts && delay < shareDeletionMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > shareDeletionMaxRetryDelay {
+		delay = shareDeletionMaxRetryDelay
+	}
+	return delay
+}
+
+// queueShareDeletion records the failed deletion of the file share in the store so that it is retried in the
+// background. The caller must hold the lock of fileShareID.
+func (b *Broker) queueShareDeletion(logger lager.Logger, fileShareID string, share FileShare, serviceInstance ServiceInstance, cause error) error {
+	fileShareName := share.FileShareName
+	now := b.clock.Now().UTC()
+	deletion := PendingShareDeletion{
+		FileShareID:     fileShareID,
+		FileShareName:   fileShareName,
+		InstanceID:      share.InstanceID,
+		ServiceInstance: serviceInstance,
+		Attempts:        1,
+		LastError:       cause.Error(),
+		CreatedAt:       now,
+		NextAttemptAt:   now.Add(shareDeletionRetryDelay(1)),
+		DatabaseVersion: databaseVersion,
+	}
+	// A share which is bound and unbound again while its deletion is pending replaces the old deletion
+	if err := b.store.DeletePendingShareDeletion(fileShareID); err != nil {
+		return newStoreError(err, "Failed to delete the pending deletion of the file share %q from the store", fileShareName)
+	}
+	if err := b.store.CreatePendingShareDeletion(fileShareID, deletion); err != nil {
+		return newStoreError(err, "Failed to insert the pending deletion of the file share %q into the store", fileShareName)
+	}
+	logger.Info("share-deletion-queued", lager.Data{"fileShareID": fileShareID, "nextAttemptAt": deletion.NextAttemptAt})
+	return nil
+}
+
+// PendingShareDeletions returns the file shares waiting for a deletion retry sorted by their ID
+func (b *Broker) PendingShareDeletions() ([]PendingShareDeletionStatus, error) {
+	deletions, err := b.readStore().RetrievePendingShareDeletions()
+	if err != nil {
+		return nil, newStoreError(err, "Failed to retrieve the pending share deletions")
+	}
+
+	statuses := make([]PendingShareDeletionStatus, 0, len(deletions))
+	for _, deletion := range deletions {
+		statuses = append(statuses, PendingShareDeletionStatus{
+			FileShareID:   deletion.FileShareID,
+			FileShareName: deletion.FileShareName,
+			InstanceID:    deletion.InstanceID,
+			Attempts:      deletion.Attempts,
+			LastError:     deletion.LastError,
+			CreatedAt:     deletion.CreatedAt,
+			NextAttemptAt: deletion.NextAttemptAt,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].FileShareID < statuses[j].FileShareID })
+	return statuses, nil
+}
+
+// ShareDeletionRetrier returns a runner which periodically retries the pending file share deletions
+func (b *Broker) ShareDeletionRetrier() ifrit.Runner {
+	return b.newPeriodicRunner("share-deletion-retrier", shareDeletionRetryInterval, b.RetryShareDeletions)
+}
+
+// RetryShareDeletions tries once to delete every pending file share whose next attempt is due
+func (b *Broker) RetryShareDeletions(logger lager.Logger) {
+	logger = logger.Session("retry-share-deletions")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	deletions, err := b.store.RetrievePendingShareDeletions()
+	if err != nil {
+		logger.Error("retrieve-pending-share-deletions", err)
+		return
+	}
+
+	now := b.clock.Now()
+	pending := 0
+	var oldest time.Time
+	for _, deletion := range deletions {
+		if !now.Before(deletion.NextAttemptAt) && b.retryShareDeletion(logger, deletion) {
+			continue
+		}
+		pending++
+		if oldest.IsZero() || deletion.CreatedAt.Before(oldest) {
+			oldest = deletion.CreatedAt
+		}
+	}
+
+	data := lager.Data{"count": pending}
+	if pending > 0 {
+		data["oldestAge"] = now.Sub(oldest).String()
+	}
+	logger.Info("pending-share-deletions", data)
+}
+
+// retryShareDeletion returns true if the deletion is not pending any more
+func (b *Broker) retryShareDeletion(logger lager.Logger, deletion PendingShareDeletion) bool {
+	logger = logger.WithData(lager.Data{"fileShareID": deletion.FileShareID, "attempts": deletion.Attempts})
+
+	if err := b.getLockForUpdate(deletion.FileShareID); err != nil {
+		logger.Error("get-lock-for-update", err)
+		return false
+	}
+	defer b.store.ReleaseLockForUpdate(deletion.FileShareID)
+
+	// The share was bound again after the failed deletion so it must be kept
+	if _, err := b.store.RetrieveFileShare(deletion.FileShareID); err == nil {
+		logger.Info("file-share-bound-again")
+		return b.removePendingShareDeletion(logger, deletion)
+	} else if err != brokerapi.ErrInstanceDoesNotExist {
+		logger.Error("retrieve-file-share", err)
+		return false
+	}
+
+	ownerID := getFileShareOwnerID(&deletion.ServiceInstance, deletion.FileShareName)
+	if err := b.getLockForUpdate(ownerID); err != nil {
+		logger.Error("get-lock-for-update", err)
+		return false
+	}
+	defer b.store.ReleaseLockForUpdate(ownerID)
+
+	// Another instance started to use the share after the failed deletion
+	if _, err := b.store.RetrieveFileShareOwner(ownerID); err == nil {
+		logger.Info("file-share-used-by-another-instance")
+		return b.removePendingShareDeletion(logger, deletion)
+	} else if err != brokerapi.ErrInstanceDoesNotExist {
+		logger.Error("retrieve-file-share-owner", err)
+		return false
+	}
+
+	if err := b.deleteFileShare(logger, deletionInitiatorShareDeletionRetry, deletion.InstanceID, &deletion.ServiceInstance, deletion.FileShareName); err != nil {
+		logger.Error("delete-file-share", err)
+		deletion.Attempts++
+		deletion.LastError = err.Error()
+		deletion.NextAttemptAt = b.clock.Now().UTC().Add(shareDeletionRetryDelay(deletion.Attempts))
+		if err := b.store.UpdatePendingShareDeletion(deletion.FileShareID, deletion); err != nil {
+			logger.Error("update-pending-share-deletion", err)
+		}
+		return false
+	}
+	logger.Info("deleted-file-share")
+	return b.removePendingShareDeletion(logger, deletion)
+}
+
+func (b *Broker) removePendingShareDeletion(logger lager.Logger, deletion PendingShareDeletion) bool {
+	if err := b.store.DeletePendingShareDeletion(deletion.FileShareID); err != nil {
+		logger.Error("delete-pending-share-deletion", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package azurefilebroker
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	maxShareDirectories          = 50
+	maxShareDirectoryNameLength  = 255
+	invalidShareDirectoryNameSet = "\"\\:|<>*?"
+)
+
+// normalizedShareDirectories returns the directories in the bind parameters, and their parents, in the order in which
+// they are created, e.g. logs, data/2020 returns logs, data, data/2020. The paths are relative to the root of the share
+// and a leading slash is ignored.
+func normalizedShareDirectories(directories []string) ([]string, error) {
+	if len(directories) > maxShareDirectories {
+		return nil, newBrokerError(ErrCodeInvalidParameters, "Too many directories: at most %d directories can be created in a file share", maxShareDirectories)
+	}
+	created := map[string]bool{}
+	normalized := []string{}
+	for _, directory := range directories {
+		path := strings.Trim(directory, "/")
+		if path == "" {
+			return nil, newBrokerError(ErrCodeInvalidParameters, "Invalid directory %q: expected a path relative to the root of the share", directory)
+		}
+		names := strings.Split(path, "/")
+		for i, name := range names {
+			if name == "" || name == "." || name == ".." || len(name) > maxShareDirectoryNameLength || strings.ContainsAny(name, invalidShareDirectoryNameSet) || strings.HasSuffix(name, ".") {
+				return nil, newBrokerError(ErrCodeInvalidParameters, "Invalid directory %q: the name %q is not allowed in Azure Files", directory, name)
+			}
+			if parent := strings.Join(names[:i+1], "/"); !created[parent] {
+				created[parent] = true
+				normalized = append(normalized, parent)
+			}
+		}
+	}
+	return normalized, nil
+}
+
+// createShareDirectories creates the directories in a file share which the broker has just created. The share is
+// deleted when a directory cannot be created so that the bind creates it again when it is retried.
+func (b *Broker) createShareDirectories(logger lager.Logger, storageAccount *StorageAccount, fileShareName string, directories []string) error {
+	if len(directories) == 0 {
+		return nil
+	}
+	logger = logger.Session("create-share-directories").WithData(lager.Data{"FileShareName": fileShareName, "directories": directories})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := storageAccount.SDKClient.CreateDirectories(fileShareName, directories); err != nil {
+		logger.Error("create-directories", err)
+		if err := storageAccount.SDKClient.DeleteFileShare(fileShareName); err != nil {
+			logger.Error("rollback-file-share", err)
+		}
+		return newAzureError(err, "Failed to create the directories in the file share %q in the storage account %q", fileShareName, storageAccount.StorageAccountName)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package azurefilebroker
+
+import (
+	"sort"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// The most entries of the root directory of a file share which are listed
+const maxShareListingEntries = 1000
+
+// DirectoryEntry is a directory or a file in a directory of a file share
+type DirectoryEntry struct {
+	Name        string `json:"name"`
+	IsDirectory bool   `json:"is_directory"`
+	// SizeBytes is the size of a file. Azure Files does not return the size of a directory.
+	SizeBytes int64 `json:"size_bytes,omitempty"`
+}
+
+// ShareListing is the root directory and the current usage of a file share, read from Azure with the credentials of
+// the broker
+type ShareListing struct {
+	FileShareName string           `json:"file_share_name"`
+	UsageBytes    int64            `json:"usage_bytes"`
+	QuotaGiB      int              `json:"quota_gib"`
+	Entries       []DirectoryEntry `json:"entries"`
+	// Truncated is true when the root directory has more than maxShareListingEntries entries
+	Truncated bool `json:"truncated"`
+	// Error is the failure to read the file share from Azure, which does not fail the other file shares
+	Error string `json:"error,omitempty"`
+}
+
+// ListSharesOfInstance returns the directories and the files in the root directory and the usage of every file share
+// of an AzureFileShare instance sorted by name, so that operators can check their content without mounting them. The
+// directories are before the files.
+func (b *Broker) ListSharesOfInstance(instanceID string) ([]ShareListing, error) {
+	logger := b.logger.Session("list-shares-of-instance").WithData(lager.Data{"instanceID": instanceID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	serviceInstance, err := b.readStore().RetrieveServiceInstance(instanceID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		return nil, newBrokerError(ErrCodeResourceNotFound, "The service instance %q does not exist", instanceID)
+	} else if err != nil {
+		return nil, newStoreError(err, "Failed to retrieve the service instance %q", instanceID)
+	}
+	if serviceInstance.IsPreexisting {
+		return nil, newBrokerError(ErrCodeInvalidParameters, "The service instance %q is a preexisting share which the broker has no credentials to list", instanceID)
+	}
+	shares, err := b.readStore().RetrieveFileShares()
+	if err != nil {
+		return nil, newStoreError(err, "Failed to retrieve the file shares")
+	}
+
+	storageAccount, err := b.newStorageAccountWithSDKClient(logger, &serviceInstance)
+	if err != nil {
+		return nil, newAzureError(err, "Failed to create the SDK client of the storage account %q", serviceInstance.TargetName)
+	}
+	restClient, err := NewAzureStorageAccountRESTClient(logger, &b.config.cloud, storageAccount)
+	if err != nil {
+		return nil, newAzureError(err, "Failed to create the REST client of the storage account %q", serviceInstance.TargetName)
+	}
+
+	listings := []ShareListing{}
+	for _, share := range shares {
+		if share.InstanceID != instanceID {
+			continue
+		}
+		listing := ShareListing{FileShareName: share.FileShareName, Entries: []DirectoryEntry{}}
+		if err := listShare(storageAccount.SDKClient, restClient, &listing); err != nil {
+			logger.Error("list-share", err, lager.Data{"fileShareName": share.FileShareName})
+			listing.Error = newAzureError(err, "Failed to list the file share %q", share.FileShareName).Error()
+		}
+		listings = append(listings, listing)
+	}
+	sort.Slice(listings, func(i, j int) bool { return listings[i].FileShareName < listings[j].FileShareName })
+	return listings, nil
+}
+
+func listShare(sdkClient AzureStorageAccountSDKClient, restClient AzureStorageAccountRESTClient, listing *ShareListing) error {
+	stats, err := restClient.GetFileShareStats(listing.FileShareName)
+	if err != nil {
+		return err
+	}
+	listing.UsageBytes, listing.QuotaGiB = stats.UsageBytes, stats.QuotaGiB
+
+	entries, truncated, err := sdkClient.ListDirectory(listing.FileShareName, "", maxShareListingEntries)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDirectory != entries[j].IsDirectory {
+			return entries[i].IsDirectory
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	listing.Entries, listing.Truncated = entries, truncated
+	return nil
+}
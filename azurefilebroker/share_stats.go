@@ -0,0 +1,155 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/tedsuo/ifrit"
+)
+
+const bytesPerGiB = 1 << 30
+
+// ShareStats is the usage of a file share when it was collected
+type ShareStats struct {
+	UsageBytes  int64     `json:"usage_bytes"`
+	QuotaGiB    int       `json:"quota_gib"`
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+// FileShareStats is the last collected usage of a file share of a service instance
+type FileShareStats struct {
+	FileShareName string      `json:"file_share_name"`
+	Count         int         `json:"count"`
+	Stats         *ShareStats `json:"stats"` // nil until the usage is collected
+}
+
+// ShareStatsCollector returns a runner which periodically collects the usage of the file shares
+func (b *Broker) ShareStatsCollector(interval time.Duration) ifrit.Runner {
+	return b.newPeriodicRunner("share-stats-collector", interval, func(logger lager.Logger) {
+		if err := b.CollectShareStats(logger); err != nil {
+			logger.Error("collect-share-stats", err)
+		}
+	})
+}
+
+// CollectShareStats gets the usage of every file share of the AzureFileShare instances from Azure and stores it with
+// the file share
+func (b *Broker) CollectShareStats(logger lager.Logger) error {
+	logger = logger.Session("collect-share-stats")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	shares, err := b.store.RetrieveFileShares()
+	if err != nil {
+		return err
+	}
+
+	restClients := map[string]AzureStorageAccountRESTClient{}
+	collected := 0
+	for fileShareID, share := range shares {
+		restClient, ok := restClients[share.InstanceID]
+		if !ok {
+			restClient, err = b.newRESTClientOfInstance(logger, share.InstanceID)
+			if err != nil {
+				logger.Error("new-rest-client", err, lager.Data{"instanceID": share.InstanceID})
+			}
+			// A failed instance is not tried again for its other file shares
+			restClients[share.InstanceID] = restClient
+		}
+		if restClient == nil {
+			continue
+		}
+
+		stats, err := restClient.GetFileShareStats(share.FileShareName)
+		if err != nil {
+			logger.Error("get-file-share-stats", err, lager.Data{"fileShareID": fileShareID})
+			continue
+		}
+		stats.CollectedAt = b.clock.Now().UTC()
+		if err := b.RecordShareStats(fileShareID, stats); err != nil {
+			logger.Error("record-share-stats", err, lager.Data{"fileShareID": fileShareID})
+			continue
+		}
+		collected++
+	}
+	logger.Info("share-stats-collected", lager.Data{"shares": len(shares), "collected": collected})
+	return nil
+}
+
+// newRESTClientOfInstance returns the REST client of the storage account of an AzureFileShare instance or nil for a
+// preexisting share
+func (b *Broker) newRESTClientOfInstance(logger lager.Logger, instanceID string) (AzureStorageAccountRESTClient, error) {
+	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if serviceInstance.IsPreexisting {
+		return nil, nil
+	}
+	storageAccount, err := b.newStorageAccountOfInstance(logger, &serviceInstance)
+	if err != nil {
+		return nil, err
+	}
+	return NewAzureStorageAccountRESTClient(logger, &b.config.cloud, storageAccount)
+}
+
+// RecordShareStats stores the usage of the file share. The file share is read again under its lock because bind and
+// unbind update it concurrently.
+func (b *Broker) RecordShareStats(fileShareID string, stats ShareStats) error {
+	if err := b.getLockForUpdate(fileShareID); err != nil {
+		return err
+	}
+	defer b.store.ReleaseLockForUpdate(fileShareID)
+
+	share, err := b.store.RetrieveFileShare(fileShareID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		// The file share was unbound in the meantime
+		return nil
+	} else if err != nil {
+		return err
+	}
+	share.Stats = &stats
+	if err := b.store.UpdateFileShare(fileShareID, share); err != nil {
+		return newStoreError(err, "Failed to update the file share %q in the store", fileShareID)
+	}
+	return nil
+}
+
+// ShareStatsOfInstance returns the last collected usage of the file shares of the instance sorted by name
+func (b *Broker) ShareStatsOfInstance(instanceID string) ([]FileShareStats, error) {
+	shares, err := b.readStore().RetrieveFileShares()
+	if err != nil {
+		return nil, newStoreError(err, "Failed to retrieve the file shares")
+	}
+
+	stats := []FileShareStats{}
+	for _, share := range shares {
+		if share.InstanceID == instanceID {
+			stats = append(stats, FileShareStats{FileShareName: share.FileShareName, Count: share.Count, Stats: share.Stats})
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].FileShareName < stats[j].FileShareName })
+	return stats, nil
+}
+
+// shareStatsSummary describes the usage of the file shares in the description of the last operation
+func shareStatsSummary(stats []FileShareStats) string {
+	if len(stats) == 0 {
+		return ""
+	}
+	var usageBytes int64
+	collected := 0
+	for _, share := range stats {
+		if share.Stats != nil {
+			usageBytes += share.Stats.UsageBytes
+			collected++
+		}
+	}
+	if collected == 0 {
+		return fmt.Sprintf("%d file share(s) in use, usage not collected yet", len(stats))
+	}
+	return fmt.Sprintf("%d file share(s) in use, %.2f GiB used by %d of them", len(stats), float64(usageBytes)/bytesPerGiB, collected)
+}
@@ -0,0 +1,188 @@
+package azurefilebroker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const (
+	smokeTestOrganizationGUID = "smoke-test"
+	smokeTestSpaceGUID        = "smoke-test"
+	smokeTestAppGUID          = "smoke-test"
+
+	smokeTestPollInterval     = 10 * time.Second
+	smokeTestOperationTimeout = 30 * time.Minute
+)
+
+// SmokeTestStep is the result of one step of the smoke test
+type SmokeTestStep struct {
+	Name     string `json:"name"`
+	Passed   bool   `json:"passed"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// SmokeTestReport is the pass/fail report of the smoke test
+type SmokeTestReport struct {
+	Passed     bool            `json:"passed"`
+	InstanceID string          `json:"instance_id"`
+	BindingID  string          `json:"binding_id"`
+	Steps      []SmokeTestStep `json:"steps"`
+}
+
+// SmokeTest provisions a temporary service instance, binds and unbinds it, and deprovisions it with the same code paths
+// as the broker API. The cleanup steps are run even if an earlier step fails so that nothing is left behind.
+type SmokeTest struct {
+	InstanceID          string
+	BindingID           string
+	PlanID              string
+	ProvisionParameters json.RawMessage
+	BindParameters      json.RawMessage
+	PollInterval        time.Duration
+	OperationTimeout    time.Duration
+
+	provisioned bool
+	hasBound    bool
+}
+
+// NewSmokeTest returns a smoke test of the AzureFileShare plan which creates or reuses the given storage account and
+// file share. The subscription, resource group and location are the defaults of the broker.
+func NewSmokeTest(instanceID, bindingID, storageAccountName, fileShareName string) *SmokeTest {
+	provisionParameters, _ := json.Marshal(map[string]string{"storage_account_name": storageAccountName})
+	bindParameters, _ := json.Marshal(map[string]string{"share": fileShareName})
+	return &SmokeTest{
+		InstanceID:          instanceID,
+		BindingID:           bindingID,
+		PlanID:              planIDAzureFileShare,
+		ProvisionParameters: provisionParameters,
+		BindParameters:      bindParameters,
+		PollInterval:        smokeTestPollInterval,
+		OperationTimeout:    smokeTestOperationTimeout,
+	}
+}
+
+// RunSmokeTest runs the smoke test against the broker and returns the report
+func (b *Broker) RunSmokeTest(smokeTest *SmokeTest) SmokeTestReport {
+	logger := b.logger.Session("smoke-test").WithData(lager.Data{"instanceID": smokeTest.InstanceID, "bindingID": smokeTest.BindingID})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	report := SmokeTestReport{Passed: true, InstanceID: smokeTest.InstanceID, BindingID: smokeTest.BindingID}
+	run := func(name string, step func() error) bool {
+		start := b.clock.Now()
+		err := step()
+		result := SmokeTestStep{Name: name, Passed: err == nil, Duration: b.clock.Since(start).String()}
+		if err != nil {
+			result.Error = err.Error()
+			report.Passed = false
+			logger.Error(name, err)
+		} else {
+			logger.Info(name + "-passed")
+		}
+		report.Steps = append(report.Steps, result)
+		return err == nil
+	}
+
+	if run("provision", func() error { return b.smokeTestProvision(logger, smokeTest) }) {
+		run("bind", func() error { return b.smokeTestBind(smokeTest) })
+	}
+	if smokeTest.hasBound {
+		run("unbind", func() error { return b.smokeTestUnbind(smokeTest) })
+	}
+	if smokeTest.provisioned {
+		run("deprovision", func() error { return b.smokeTestDeprovision(logger, smokeTest) })
+	}
+	return report
+}
+
+func (b *Broker) smokeTestProvision(logger lager.Logger, smokeTest *SmokeTest) error {
+	spec, err := b.Provision(context.Background(), smokeTest.InstanceID, brokerapi.ProvisionDetails{
+		ServiceID:        b.static.ServiceID,
+		PlanID:           smokeTest.PlanID,
+		OrganizationGUID: smokeTestOrganizationGUID,
+		SpaceGUID:        smokeTestSpaceGUID,
+		RawParameters:    smokeTest.ProvisionParameters,
+	}, true)
+	if err != nil {
+		return err
+	}
+	// The instance exists from now on, even if the storage account fails to be created, and must be deprovisioned
+	smokeTest.provisioned = true
+	if !spec.IsAsync {
+		return nil
+	}
+	return b.smokeTestWaitForOperation(logger, smokeTest, spec.OperationData, false)
+}
+
+func (b *Broker) smokeTestBind(smokeTest *SmokeTest) error {
+	binding, err := b.Bind(context.Background(), smokeTest.InstanceID, smokeTest.BindingID, brokerapi.BindDetails{
+		AppGUID:       smokeTestAppGUID,
+		ServiceID:     b.static.ServiceID,
+		PlanID:        smokeTest.PlanID,
+		RawParameters: smokeTest.BindParameters,
+	})
+	if err != nil {
+		return err
+	}
+	smokeTest.hasBound = true
+	if len(binding.VolumeMounts) != 1 {
+		return fmt.Errorf("expected 1 volume mount in the binding, got %d", len(binding.VolumeMounts))
+	}
+	if binding.VolumeMounts[0].Device.VolumeId == "" {
+		return errors.New("the volume mount of the binding has no volume id")
+	}
+	return nil
+}
+
+func (b *Broker) smokeTestUnbind(smokeTest *SmokeTest) error {
+	return b.Unbind(context.Background(), smokeTest.InstanceID, smokeTest.BindingID, brokerapi.UnbindDetails{
+		ServiceID: b.static.ServiceID,
+		PlanID:    smokeTest.PlanID,
+	})
+}
+
+func (b *Broker) smokeTestDeprovision(logger lager.Logger, smokeTest *SmokeTest) error {
+	spec, err := b.Deprovision(context.Background(), smokeTest.InstanceID, brokerapi.DeprovisionDetails{
+		ServiceID: b.static.ServiceID,
+		PlanID:    smokeTest.PlanID,
+	}, true)
+	if err != nil {
+		return err
+	}
+	if !spec.IsAsync {
+		return nil
+	}
+	return b.smokeTestWaitForOperation(logger, smokeTest, spec.OperationData, true)
+}
+
+// smokeTestWaitForOperation polls the last operation of the instance until it is finished. A deprovisioned instance is
+// removed from the store so its last operation is gone when the deletion succeeds.
+func (b *Broker) smokeTestWaitForOperation(logger lager.Logger, smokeTest *SmokeTest, operationData string, deprovisioning bool) error {
+	deadline := b.clock.Now().Add(smokeTest.OperationTimeout)
+	for {
+		lastOperation, err := b.LastOperation(context.Background(), smokeTest.InstanceID, operationData)
+		if err == brokerapi.ErrInstanceDoesNotExist && deprovisioning {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch lastOperation.State {
+		case brokerapi.Succeeded:
+			return nil
+		case brokerapi.Failed:
+			return fmt.Errorf("the operation failed: %s", lastOperation.Description)
+		}
+
+		if b.clock.Now().After(deadline) {
+			return fmt.Errorf("the operation did not finish in %s", smokeTest.OperationTimeout)
+		}
+		logger.Info("wait-for-operation", lager.Data{"state": lastOperation.State})
+		b.clock.Sleep(smokeTest.PollInterval)
+	}
+}
@@ -3,25 +3,58 @@ package azurefilebroker
 import (
 	"database/sql"
 	"database/sql/driver"
+	"sync"
 	"time"
 
 	"code.cloudfoundry.org/goshims/sqlshim"
+	"code.cloudfoundry.org/lager"
 )
 
 type AppLock interface {
 	GetAppLockSQL() string
 	GetReleaseAppLockSQL() string
+	// GetAppLockSessionSQL returns the query of the session which holds an app lock. It returns no row or NULL when
+	// the lock is free, and a row per session when it cannot tell which session holds the lock.
+	GetAppLockSessionSQL() string
+	// GetKillSessionSQL returns the statement which ends a session, which releases its app locks
+	GetKillSessionSQL(session string) string
+}
+
+// AppLockName is implemented by the variants whose app lock names are shorter than the lock names of the broker
+type AppLockName interface {
+	// GetAppLockName returns the name of the app lock in the database
+	GetAppLockName(lockName string) string
+}
+
+// AppLockInitialize is implemented by the variants whose app locks need objects in the database
+type AppLockInitialize interface {
+	GetInitializeAppLockSQL() []string
+}
+
+// ProcedureProbe is implemented by the variants whose store needs procedures in the database
+type ProcedureProbe interface {
+	// GetProcedureProbeSQL returns a query per procedure which returns 1 when the procedure exists and may be executed,
+	// 0 when it may not be executed and NULL when it does not exist
+	GetProcedureProbeSQL() map[string]string
 }
 
 type DBInitialize interface {
 	GetInitializeDatabaseSQL() []string
 }
 
+type TableNames interface {
+	// GetTableName returns the name of the table in the SQL statements of the store
+	GetTableName(table string) string
+}
+
 //go:generate counterfeiter -o ../azurefilebrokerfakes/fake_sql_variant.go . SqlVariant
 type SqlVariant interface {
 	Connect() (sqlshim.SqlDB, error)
+	// SetCredentials changes the credentials of the next Connect
+	SetCredentials(username, password string)
 
 	DBInitialize
+	TableNames
 	AppLock
 }
 
@@ -31,12 +64,27 @@ type SqlConnection interface {
 	sqlshim.SqlDB
 
 	DBInitialize
+	TableNames
 	AppLock
 }
 
 type sqlConnection struct {
+	mutex sync.RWMutex // guards sqlDB, which is replaced when the credentials are rotated
 	sqlDB sqlshim.SqlDB
 	leaf  SqlVariant
+
+	// The app locks of MySQL and MSSQL belong to the sessions of the connection pool which took them, so a pool which
+	// is replaced is only closed once its app locks are released. Closing it earlier would release the locks while
+	// the operations which took them still run.
+	locksMutex sync.Mutex                 // guards appLocks, lockUsers and retired
+	appLocks   map[string][]sqlshim.SqlDB // the pools which hold the app locks, by the name of the lock
+	lockUsers  map[sqlshim.SqlDB]int      // how many app locks every pool holds or is taking
+	retired    map[sqlshim.SqlDB]bool     // the replaced pools which are closed once they hold no app lock
+
+	logger      lager.Logger
+	credentials DBCredentialsSource
+	username    string
+	password    string
 }
 
 func NewSqlConnection(variant SqlVariant) SqlConnection {
@@ -44,26 +92,196 @@ func NewSqlConnection(variant SqlVariant) SqlConnection {
 		panic("variant cannot be nil")
 	}
 	return &sqlConnection{
-		leaf: variant,
+		leaf:      variant,
+		appLocks:  map[string][]sqlshim.SqlDB{},
+		lockUsers: map[sqlshim.SqlDB]int{},
+		retired:   map[sqlshim.SqlDB]bool{},
 	}
 }
 
+// NewSqlConnectionWithCredentials returns a connection which reads the credentials from the source when it connects.
+// When the database rejects the credentials, e.g. because they were rotated, the credentials are read again and the
+// connection pool is replaced by one with the new credentials.
+func NewSqlConnectionWithCredentials(logger lager.Logger, variant SqlVariant, credentials DBCredentialsSource) SqlConnection {
+	connection := NewSqlConnection(variant).(*sqlConnection)
+	connection.logger = logger.Session("sql-connection")
+	connection.credentials = credentials
+	return connection
+}
+
 func (c *sqlConnection) Connect() error {
+	if c.credentials != nil {
+		username, password, err := c.credentials.Credentials()
+		if err != nil {
+			return err
+		}
+		c.leaf.SetCredentials(username, password)
+		c.username, c.password = username, password
+	}
+
 	sqlDB, err := c.leaf.Connect()
 	if err != nil {
 		return err
 	}
 
+	c.mutex.Lock()
 	c.sqlDB = sqlDB
+	c.mutex.Unlock()
 
 	err = c.Ping()
 	return err
 }
 
+func (c *sqlConnection) db() sqlshim.SqlDB {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.sqlDB
+}
+
+// reconnect returns true if the error is an authentication failure and the connection pool was replaced by one with
+// new credentials from the source, so that the failed call can be tried again
+func (c *sqlConnection) reconnect(err error) bool {
+	if c.credentials == nil || !isAuthenticationError(err) {
+		return false
+	}
+	logger := c.logger.Session("reconnect")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	username, password, err := c.credentials.Credentials()
+	if err != nil {
+		logger.Error("read-credentials", err)
+		return false
+	}
+	if username == c.username && password == c.password {
+		// The credentials are not rotated yet or another call has already reconnected with them
+		logger.Info("credentials-unchanged")
+		return c.sqlDB.Ping() == nil
+	}
+
+	c.leaf.SetCredentials(username, password)
+	sqlDB, err := c.leaf.Connect()
+	if err != nil {
+		logger.Error("connect", err)
+		return false
+	}
+	if err := sqlDB.Ping(); err != nil {
+		logger.Error("ping", err)
+		sqlDB.Close()
+		return false
+	}
+
+	// Close waits for the calls which still use the old connection pool
+	old := c.sqlDB
+	c.locksMutex.Lock()
+	if c.lockUsers[old] > 0 {
+		logger.Info("keeping-connection-pool-with-app-locks", lager.Data{"appLocks": c.lockUsers[old]})
+		c.retired[old] = true
+	} else {
+		go old.Close()
+	}
+	c.locksMutex.Unlock()
+	c.sqlDB = sqlDB
+	c.username, c.password = username, password
+	logger.Info("reconnected-with-rotated-credentials", lager.Data{"username": username})
+	return true
+}
+
+// getAppLock takes the app lock in a session of the connection pool and remembers the pool, so that the lock is
+// released in the same pool and the pool is not closed while it holds the lock. It returns the result of the query.
+func (c *sqlConnection) getAppLock(lockName string, seconds int) (int, error) {
+	ret, err := c.takeAppLock(lockName, seconds)
+	if c.reconnect(err) {
+		ret, err = c.takeAppLock(lockName, seconds)
+	}
+	return ret, err
+}
+
+func (c *sqlConnection) takeAppLock(lockName string, seconds int) (int, error) {
+	c.mutex.RLock()
+	db := c.sqlDB
+	c.locksMutex.Lock()
+	c.lockUsers[db]++
+	c.locksMutex.Unlock()
+	c.mutex.RUnlock()
+
+	var ret int
+	err := db.QueryRow(c.GetAppLockSQL(), lockName, seconds).Scan(&ret)
+	if err == nil && ret == 1 {
+		c.locksMutex.Lock()
+		c.appLocks[lockName] = append(c.appLocks[lockName], db)
+		c.locksMutex.Unlock()
+	} else {
+		c.releaseLockUser(db)
+	}
+	return ret, err
+}
+
+// releaseAppLock releases the app lock in the connection pool which took it, and closes the pool if it was replaced
+// and holds no other app lock
+func (c *sqlConnection) releaseAppLock(lockName string) error {
+	c.locksMutex.Lock()
+	pools := c.appLocks[lockName]
+	if len(pools) == 0 {
+		c.locksMutex.Unlock()
+		_, err := c.Exec(c.GetReleaseAppLockSQL(), lockName)
+		return err
+	}
+	db := pools[len(pools)-1]
+	if len(pools) == 1 {
+		delete(c.appLocks, lockName)
+	} else {
+		c.appLocks[lockName] = pools[:len(pools)-1]
+	}
+	c.locksMutex.Unlock()
+
+	_, err := db.Exec(c.GetReleaseAppLockSQL(), lockName)
+	c.releaseLockUser(db)
+	return err
+}
+
+// releaseLockUser forgets an app lock of the pool, and closes the pool if it was replaced and holds no app lock
+func (c *sqlConnection) releaseLockUser(db sqlshim.SqlDB) {
+	c.locksMutex.Lock()
+	defer c.locksMutex.Unlock()
+	if c.lockUsers[db]--; c.lockUsers[db] > 0 {
+		return
+	}
+	delete(c.lockUsers, db)
+	if c.retired[db] {
+		delete(c.retired, db)
+		if c.logger != nil {
+			c.logger.Info("closing-connection-pool-without-app-locks")
+		}
+		go db.Close()
+	}
+}
+
 func (c *sqlConnection) GetInitializeDatabaseSQL() []string {
 	return c.leaf.GetInitializeDatabaseSQL()
 }
 
+func (c *sqlConnection) GetTableName(table string) string {
+	return c.leaf.GetTableName(table)
+}
+
+func (c *sqlConnection) GetProcedureProbeSQL() map[string]string {
+	if probe, ok := c.leaf.(ProcedureProbe); ok {
+		return probe.GetProcedureProbeSQL()
+	}
+	return nil
+}
+
+func (c *sqlConnection) GetAppLockName(lockName string) string {
+	if name, ok := c.leaf.(AppLockName); ok {
+		return name.GetAppLockName(lockName)
+	}
+	return lockName
+}
+
 func (c *sqlConnection) GetAppLockSQL() string {
 	return c.leaf.GetAppLockSQL()
 }
@@ -72,50 +290,82 @@ func (c *sqlConnection) GetReleaseAppLockSQL() string {
 	return c.leaf.GetReleaseAppLockSQL()
 }
 
+func (c *sqlConnection) GetAppLockSessionSQL() string {
+	return c.leaf.GetAppLockSessionSQL()
+}
+
+func (c *sqlConnection) GetKillSessionSQL(session string) string {
+	return c.leaf.GetKillSessionSQL(session)
+}
+
 func (c *sqlConnection) Ping() error {
-	return c.sqlDB.Ping()
+	err := c.db().Ping()
+	if c.reconnect(err) {
+		err = c.db().Ping()
+	}
+	return err
 }
 
 func (c *sqlConnection) Close() error {
-	return c.sqlDB.Close()
+	return c.db().Close()
 }
 
 func (c *sqlConnection) SetMaxIdleConns(n int) {
-	c.sqlDB.SetMaxIdleConns(n)
+	c.db().SetMaxIdleConns(n)
 }
 
 func (c *sqlConnection) SetMaxOpenConns(n int) {
-	c.sqlDB.SetMaxOpenConns(n)
+	c.db().SetMaxOpenConns(n)
 }
 
 func (c *sqlConnection) SetConnMaxLifetime(d time.Duration) {
-	c.sqlDB.SetConnMaxLifetime(d)
+	c.db().SetConnMaxLifetime(d)
 }
 
 func (c *sqlConnection) Stats() sql.DBStats {
-	return c.sqlDB.Stats()
+	return c.db().Stats()
 }
 
 func (c *sqlConnection) Prepare(query string) (*sql.Stmt, error) {
-	return c.sqlDB.Prepare(query)
+	stmt, err := c.db().Prepare(query)
+	if c.reconnect(err) {
+		stmt, err = c.db().Prepare(query)
+	}
+	return stmt, err
 }
 
 func (c *sqlConnection) Exec(query string, args ...interface{}) (sql.Result, error) {
-	return c.sqlDB.Exec(query, args...)
+	result, err := c.db().Exec(query, args...)
+	if c.reconnect(err) {
+		result, err = c.db().Exec(query, args...)
+	}
+	return result, err
 }
 
 func (c *sqlConnection) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	return c.sqlDB.Query(query, args...)
+	rows, err := c.db().Query(query, args...)
+	if c.reconnect(err) {
+		rows, err = c.db().Query(query, args...)
+	}
+	return rows, err
 }
 
 func (c *sqlConnection) QueryRow(query string, args ...interface{}) *sql.Row {
-	return c.sqlDB.QueryRow(query, args...)
+	row := c.db().QueryRow(query, args...)
+	if row != nil && c.reconnect(row.Err()) {
+		row = c.db().QueryRow(query, args...)
+	}
+	return row
 }
 
 func (c *sqlConnection) Begin() (*sql.Tx, error) {
-	return c.sqlDB.Begin()
+	tx, err := c.db().Begin()
+	if c.reconnect(err) {
+		tx, err = c.db().Begin()
+	}
+	return tx, err
 }
 
 func (c *sqlConnection) Driver() driver.Driver {
-	return c.sqlDB.Driver()
+	return c.db().Driver()
 }
@@ -0,0 +1,112 @@
+package azurefilebroker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/go-sql-driver/mysql"
+)
+
+// The numbers of the errors which the databases return when they reject the credentials
+const (
+	mysqlErrAccessDenied = 1045
+	mssqlErrLoginFailed  = 18456
+)
+
+//go:generate counterfeiter -o ../azurefilebrokerfakes/fake_dbcredentials_source.go . DBCredentialsSource
+type DBCredentialsSource interface {
+	// Credentials returns the current username and password of the database so that the store can reconnect with
+	// new ones after they are rotated
+	Credentials() (string, string, error)
+}
+
+type fileDBCredentialsSource struct {
+	path string
+}
+
+// NewFileDBCredentialsSource returns a source which reads the credentials from a JSON file with the keys username and
+// password, e.g. a file which is rendered again by a secret store when the credentials are rotated
+func NewFileDBCredentialsSource(path string) DBCredentialsSource {
+	return &fileDBCredentialsSource{path: path}
+}
+
+func (s *fileDBCredentialsSource) Credentials() (string, string, error) {
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return "", "", err
+	}
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.Unmarshal(content, &credentials); err != nil {
+		return "", "", fmt.Errorf("Invalid DB credentials file %q: %v", s.path, err)
+	}
+	if credentials.Username == "" || credentials.Password == "" {
+		return "", "", fmt.Errorf("Missing username or password in the DB credentials file %q", s.path)
+	}
+	return credentials.Username, credentials.Password, nil
+}
+
+type vcapDBCredentialsSource struct {
+	name      string
+	lookupEnv func(string) (string, bool)
+}
+
+// NewVcapDBCredentialsSource returns a source which reads the credentials from the db service binding of the name,
+// label or tag in VCAP_SERVICES. The file of VCAP_SERVICES_FILE_PATH is read instead when the platform provides the
+// service bindings in a file, which it renders again when a binding is rotated.
+func NewVcapDBCredentialsSource(name string) DBCredentialsSource {
+	return NewVcapDBCredentialsSourceWithLookupEnv(name, os.LookupEnv)
+}
+
+func NewVcapDBCredentialsSourceWithLookupEnv(name string, lookupEnv func(string) (string, bool)) DBCredentialsSource {
+	return &vcapDBCredentialsSource{name: name, lookupEnv: lookupEnv}
+}
+
+func (s *vcapDBCredentialsSource) Credentials() (string, string, error) {
+	vcapServices, err := LookupVcapServices(s.lookupEnv)
+	if err != nil {
+		return "", "", err
+	}
+	binding, err := ParseDBServiceBinding(vcapServices, s.name)
+	if err != nil {
+		return "", "", err
+	}
+	if binding.Username == "" || binding.Password == "" {
+		return "", "", fmt.Errorf("Missing username or password in the db service binding %q", binding.Name)
+	}
+	return binding.Username, binding.Password, nil
+}
+
+// LookupVcapServices returns VCAP_SERVICES, which is read from the file of VCAP_SERVICES_FILE_PATH when the platform
+// provides the service bindings in a file
+func LookupVcapServices(lookupEnv func(string) (string, bool)) (string, error) {
+	if path, ok := lookupEnv("VCAP_SERVICES_FILE_PATH"); ok && path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("Cannot read VCAP_SERVICES from %q: %v", path, err)
+		}
+		return string(content), nil
+	}
+	vcapServices, ok := lookupEnv("VCAP_SERVICES")
+	if !ok {
+		return "", errors.New("missing VCAP_SERVICES environment")
+	}
+	return vcapServices, nil
+}
+
+// isAuthenticationError returns true if the database rejected the credentials of a new connection
+func isAuthenticationError(err error) bool {
+	switch e := err.(type) {
+	case *mysql.MySQLError:
+		return e.Number == mysqlErrAccessDenied
+	case mssql.Error:
+		return e.Number == mssqlErrLoginFailed
+	}
+	return false
+}
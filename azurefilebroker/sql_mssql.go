@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"strings"
 
 	"code.cloudfoundry.org/goshims/sqlshim"
 	"code.cloudfoundry.org/lager"
@@ -14,34 +15,34 @@ const tempSQLCertFile = "/tmp/dbCert"
 
 type mssqlVariant struct {
 	sql                   sqlshim.Sql
-	dbConnectionString    string
+	username              string
+	password              string
+	host                  string
+	port                  string
 	caCert                string
 	hostNameInCertificate string
 	dbName                string
+	schema                string
 	logger                lager.Logger
 }
 
-func NewMSSqlVariant(logger lager.Logger, username, password, host, port, dbName, caCert, hostNameInCertificate string) SqlVariant {
-	return NewMSSqlVariantWithShims(logger, username, password, host, port, dbName, caCert, hostNameInCertificate, &sqlshim.SqlShim{})
+func NewMSSqlVariant(logger lager.Logger, username, password, host, port, dbName, schema, caCert, hostNameInCertificate string) SqlVariant {
+	return NewMSSqlVariantWithShims(logger, username, password, host, port, dbName, schema, caCert, hostNameInCertificate, &sqlshim.SqlShim{})
 }
 
-func NewMSSqlVariantWithShims(logger lager.Logger, username, password, host, port, dbName, caCert, hostNameInCertificate string, sql sqlshim.Sql) SqlVariant {
-	query := url.Values{}
-	query.Add("database", dbName)
-
-	u := &url.URL{
-		Scheme:   "sqlserver",
-		User:     url.UserPassword(username, password),
-		Host:     fmt.Sprintf("%s:%s", host, port),
-		RawQuery: query.Encode(),
-	}
-
+// NewMSSqlVariantWithShims returns the MSSQL variant. The tables and the procedures of the broker are in the schema,
+// or in the default schema of the user if it is empty.
+func NewMSSqlVariantWithShims(logger lager.Logger, username, password, host, port, dbName, schema, caCert, hostNameInCertificate string, sql sqlshim.Sql) SqlVariant {
 	return &mssqlVariant{
 		sql:                   sql,
-		dbConnectionString:    u.String(),
+		username:              username,
+		password:              password,
+		host:                  host,
+		port:                  port,
 		caCert:                caCert,
 		hostNameInCertificate: hostNameInCertificate,
 		dbName:                dbName,
+		schema:                schema,
 		logger:                logger,
 	}
 }
@@ -51,6 +52,16 @@ func (c *mssqlVariant) Connect() (sqlshim.SqlDB, error) {
 	logger.Info("start")
 	defer logger.Info("end")
 
+	database := url.Values{}
+	database.Add("database", c.dbName)
+	u := &url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(c.username, c.password),
+		Host:     fmt.Sprintf("%s:%s", c.host, c.port),
+		RawQuery: database.Encode(),
+	}
+	dbConnectionString := u.String()
+
 	if c.caCert != "" {
 		logger.Debug("secure-mssql-with-certificate")
 		// TBD: Cleanup the temporary cert file when stopping
@@ -67,7 +78,7 @@ func (c *mssqlVariant) Connect() (sqlshim.SqlDB, error) {
 		query.Add("hostNameInCertificate", c.hostNameInCertificate)
 		query.Add("certificate", tempSQLCertFile)
 
-		c.dbConnectionString = fmt.Sprintf("%s&%s", c.dbConnectionString, query.Encode())
+		dbConnectionString = fmt.Sprintf("%s&%s", dbConnectionString, query.Encode())
 	} else if c.hostNameInCertificate != "" {
 		logger.Debug("secure-mssql-without-certificate")
 		query := url.Values{}
@@ -75,86 +86,150 @@ func (c *mssqlVariant) Connect() (sqlshim.SqlDB, error) {
 		query.Add("TrustServerCertificate", "false")
 		query.Add("hostNameInCertificate", c.hostNameInCertificate)
 
-		c.dbConnectionString = fmt.Sprintf("%s&%s", c.dbConnectionString, query.Encode())
+		dbConnectionString = fmt.Sprintf("%s&%s", dbConnectionString, query.Encode())
 	}
 
-	sqlDB, err := c.sql.Open("mssql", c.dbConnectionString)
+	sqlDB, err := c.sql.Open("mssql", dbConnectionString)
 	return sqlDB, err
 }
 
+func (c *mssqlVariant) SetCredentials(username, password string) {
+	c.username = username
+	c.password = password
+}
+
+// mssqlProcedure is a stored procedure which is created with the tables
+type mssqlProcedure struct {
+	name       string
+	parameters []string
+	body       []string
+}
+
+var mssqlProcedures = []mssqlProcedure{
+	{
+		name:       "GetAppLockForUpdate",
+		parameters: []string{"@LockName NVARCHAR(255)", "@Timeout INT"},
+		body: []string{
+			"SET @Timeout = @Timeout * 1000;",
+			"DECLARE @rc INT = 0;",
+			`EXEC @rc = SP_GETAPPLOCK @Resource = @LockName, @LockTimeout = @Timeout, @LockMode = "Exclusive", @LockOwner = "Session";`,
+			`SELECT "RESULT" = CASE WHEN @rc < 0 THEN 0 ELSE 1 END;`,
+		},
+	},
+	{
+		name:       "ReleaseAppLockForUpdate",
+		parameters: []string{"@LockName NVARCHAR(255)"},
+		body: []string{
+			"DECLARE @rc INT = 0;",
+			`EXEC @rc = SP_RELEASEAPPLOCK @Resource = @LockName, @LockOwner = "Session";`,
+			`SELECT "RESULT" = CASE WHEN @rc < 0 THEN 0 ELSE 1 END;`,
+		},
+	},
+}
+
 func (c *mssqlVariant) GetInitializeDatabaseSQL() []string {
-	return []string{
-		`IF NOT EXISTS (SELECT * from sys.objects WHERE name='service_instances' and type = 'U')
-		BEGIN
-			CREATE TABLE service_instances(
-				id VARCHAR(255) PRIMARY KEY,
-				service_id VARCHAR(255),
-				plan_id VARCHAR(255),
-				organization_guid VARCHAR(255),
-				space_guid VARCHAR(255),
-				target_name VARCHAR(4096),
-				hash_key VARCHAR(255) UNIQUE,
-				value VARCHAR(4096)
-			)
-		END`,
-		`IF NOT EXISTS (SELECT * from sys.objects WHERE name='service_bindings' and type = 'U')
-		BEGIN
-			CREATE TABLE service_bindings(
-				id VARCHAR(255) PRIMARY KEY,
-				value VARCHAR(4096)
-			)
-		END`,
-		`IF NOT EXISTS (SELECT * from sys.objects WHERE name = 'file_shares' and type = 'U')
+	statements := c.createSchemaSQL()
+	statements = append(statements, createTablesSQL(c)...)
+	return append(statements, c.createProceduresSQL()...)
+}
+
+// GetInitializeAppLockSQL returns the statements which create the procedures of the app locks without the tables of
+// the store, for a database which only provides the locks
+func (c *mssqlVariant) GetInitializeAppLockSQL() []string {
+	return append(c.createSchemaSQL(), c.createProceduresSQL()...)
+}
+
+func (c *mssqlVariant) GetProcedureProbeSQL() map[string]string {
+	probes := map[string]string{}
+	for _, procedure := range mssqlProcedures {
+		name := c.qualify(procedure.name)
+		probes[name] = fmt.Sprintf("SELECT HAS_PERMS_BY_NAME(N'%s', N'OBJECT', N'EXECUTE')", name)
+	}
+	return probes
+}
+
+func (c *mssqlVariant) createSchemaSQL() []string {
+	if c.schema == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf(`IF NOT EXISTS (SELECT * FROM sys.schemas WHERE name = '%s')
 		BEGIN
-			CREATE TABLE file_shares(
-				id VARCHAR(255) PRIMARY KEY,
-				instance_id VARCHAR(255),
-				FOREIGN KEY (instance_id) REFERENCES service_instances(id),
-				file_share_name VARCHAR(255),
-				value VARCHAR(4096),
-				CONSTRAINT file_share UNIQUE (instance_id, file_share_name)
-			)
-		END`,
-		`IF NOT EXISTS (SELECT * from sys.procedures WHERE name = 'GetAppLockForUpdate' and type = 'P')
+			EXECUTE sp_executesql N'CREATE SCHEMA %s'
+		END`, c.schema, c.schema)}
+}
+
+func (c *mssqlVariant) createProceduresSQL() []string {
+	statements := []string{}
+	for _, procedure := range mssqlProcedures {
+		name := c.qualify(procedure.name)
+		statements = append(statements, fmt.Sprintf(`IF OBJECT_ID(N'%s', N'P') IS NULL
 		BEGIN
-			EXECUTE sp_executesql N'CREATE PROCEDURE GetAppLockForUpdate
-				@LockName NVARCHAR(255),
-				@Timeout INT
+			EXECUTE sp_executesql N'CREATE PROCEDURE %s
+				%s
 			AS
 			BEGIN
-				SET @Timeout = @Timeout * 1000;
-				DECLARE @rc INT = 0;
-				EXEC @rc = SP_GETAPPLOCK @Resource = @LockName, @LockTimeout = @Timeout, @LockMode = "Exclusive", @LockOwner = "Session";
-				SELECT "RESULT" = CASE WHEN @rc < 0 THEN 0 ELSE 1 END;
+				%s
 			END'
-		END`,
-		`IF NOT EXISTS (SELECT * from sys.procedures WHERE name = 'ReleaseAppLockForUpdate' and type = 'P')
+		END`, name, name, strings.Join(procedure.parameters, ",\n\t\t\t\t"), strings.Join(procedure.body, "\n\t\t\t\t")))
+	}
+	return statements
+}
+
+func (c *mssqlVariant) createTableIfNotExistsSQL(table, definition string) string {
+	return fmt.Sprintf(`IF OBJECT_ID(N'%s', N'U') IS NULL
 		BEGIN
-			EXECUTE sp_executesql N'CREATE PROCEDURE ReleaseAppLockForUpdate
-				@LockName NVARCHAR(255)
-			AS
-			BEGIN
-				DECLARE @rc INT = 0;
-				EXEC @rc = SP_RELEASEAPPLOCK @Resource = @LockName, @LockOwner = "Session";
-				SELECT "RESULT" = CASE WHEN @rc < 0 THEN 0 ELSE 1 END;
-			END'
-		END`,
-		`IF NOT EXISTS (SELECT * from sys.procedures WHERE name = 'ReleaseAppLockForUpdate' and type = 'P')
+			CREATE TABLE %s%s
+		END`, table, table, definition)
+}
+
+func (c *mssqlVariant) valueColumnType() string {
+	return "NVARCHAR(MAX)"
+}
+
+// widenValueColumnSQL alters the column only when it is not yet NVARCHAR(MAX), whose length is -1
+func (c *mssqlVariant) widenValueColumnSQL(table string) string {
+	return fmt.Sprintf(`IF COL_LENGTH(N'%s', N'%s') <> -1
 		BEGIN
-			EXECUTE sp_executesql N'CREATE PROCEDURE ReleaseAppLockForUpdate
-				@LockName NVARCHAR(255)
-			AS
-			BEGIN
-				EXEC SP_RELEASEAPPLOCK @Resource = @LockName, @LockOwner = "Session";
-			END'
-		END`,
+			ALTER TABLE %s ALTER COLUMN %s %s
+		END`, table, valueColumn, table, valueColumn, c.valueColumnType())
+}
+
+func (c *mssqlVariant) GetTableName(table string) string {
+	return c.qualify(table)
+}
+
+// qualify returns the name of an object in the schema of the broker. An object without a schema is in the default
+// schema of the database user.
+func (c *mssqlVariant) qualify(name string) string {
+	if c.schema == "" {
+		return name
 	}
+	return c.schema + "." + name
 }
 
 func (c *mssqlVariant) GetAppLockSQL() string {
-	return "GetAppLockForUpdate @LockName = ?, @Timeout = ?"
+	return c.qualify("GetAppLockForUpdate") + " @LockName = ?, @Timeout = ?"
 }
 
 func (c *mssqlVariant) GetReleaseAppLockSQL() string {
-	return "ReleaseAppLockForUpdate @LockName = ?"
+	return c.qualify("ReleaseAppLockForUpdate") + " @LockName = ?"
+}
+
+// GetAppLockSessionSQL returns the query of the session which holds an app lock. The locks show only the first 32
+// characters of the name of an app lock in brackets, e.g. 0:[lock name]:(8e9f2c4a), so the query keeps the sessions
+// with a lock of the same prefix only when the exact name is held: by another session when APPLOCK_TEST cannot take
+// it, or by the session of the query when APPLOCK_MODE shows it. The names of two locks which are held by other
+// sessions at the same time may still share the prefix, so the query returns every session which may hold the lock.
+// It requires VIEW SERVER STATE.
+func (c *mssqlVariant) GetAppLockSessionSQL() string {
+	return "SELECT DISTINCT l.request_session_id FROM (SELECT CAST(? AS NVARCHAR(255)) AS name) n " +
+		"JOIN sys.dm_tran_locks l ON l.resource_type = 'APPLICATION' AND l.request_status = 'GRANT' " +
+		"AND l.resource_database_id = DB_ID() AND CHARINDEX(':[' + LEFT(n.name, 32) + ']:', l.resource_description) > 0 " +
+		"WHERE (l.request_session_id <> @@SPID AND APPLOCK_TEST('public', n.name, 'Exclusive', 'Session') = 0) " +
+		"OR (l.request_session_id = @@SPID AND APPLOCK_MODE('public', n.name, 'Session') <> 'NoLock')"
+}
+
+// GetKillSessionSQL requires ALTER ANY CONNECTION
+func (c *mssqlVariant) GetKillSessionSQL(session string) string {
+	return "KILL " + session
 }
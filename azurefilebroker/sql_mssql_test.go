@@ -10,6 +10,7 @@ import (
 	"code.cloudfoundry.org/goshims/sqlshim/sql_fake"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
 
 var _ = Describe("MssqlVariant", func() {
@@ -21,16 +22,18 @@ var _ = Describe("MssqlVariant", func() {
 
 		cert                  string
 		hostNameInCertificate string
+		schema                string
 	)
 
 	BeforeEach(func() {
 		logger = lagertest.NewTestLogger("mssql-variant-test")
 
 		fakeSql = &sql_fake.FakeSql{}
+		schema = ""
 	})
 
 	JustBeforeEach(func() {
-		database = azurefilebroker.NewMSSqlVariantWithShims(logger, "username", "password", "host", "port", "dbName", cert, hostNameInCertificate, fakeSql)
+		database = azurefilebroker.NewMSSqlVariantWithShims(logger, "username", "password", "host", "port", "dbName", schema, cert, hostNameInCertificate, fakeSql)
 	})
 
 	Describe(".Connect", func() {
@@ -103,4 +106,130 @@ var _ = Describe("MssqlVariant", func() {
 			})
 		})
 	})
+
+	Describe(".GetInitializeDatabaseSQL", func() {
+		var statements []string
+
+		JustBeforeEach(func() {
+			statements = database.GetInitializeDatabaseSQL()
+		})
+
+		It("creates every table and procedure once", func() {
+			Expect(statements).To(HaveLen(26))
+			Expect(statements[0]).To(ContainSubstring("IF OBJECT_ID(N'service_instances', N'U') IS NULL"))
+			Expect(statements[0]).To(ContainSubstring("value NVARCHAR(MAX)"))
+			Expect(statements[2]).To(ContainSubstring("FOREIGN KEY (instance_id) REFERENCES service_instances(id)"))
+			Expect(statements[2]).To(ContainSubstring("CONSTRAINT file_share UNIQUE (instance_id, file_share_name)"))
+			Expect(statements[24]).To(ContainSubstring("CREATE PROCEDURE GetAppLockForUpdate"))
+			Expect(statements[25]).To(ContainSubstring("CREATE PROCEDURE ReleaseAppLockForUpdate"))
+			Expect(statements[25]).To(ContainSubstring(`SELECT "RESULT"`))
+			Expect(database.GetAppLockSQL()).To(Equal("GetAppLockForUpdate @LockName = ?, @Timeout = ?"))
+		})
+
+		It("widens the value column of the tables of an older broker", func() {
+			Expect(statements[12]).To(ContainSubstring("IF COL_LENGTH(N'service_instances', N'value') <> -1"))
+			Expect(statements[12]).To(ContainSubstring("ALTER TABLE service_instances ALTER COLUMN value NVARCHAR(MAX)"))
+			Expect(statements[23]).To(ContainSubstring("ALTER TABLE schema_versions ALTER COLUMN value NVARCHAR(MAX)"))
+		})
+
+		Context("when the schema is specified", func() {
+			BeforeEach(func() {
+				schema = "broker"
+			})
+
+			It("creates the schema and qualifies the tables and the procedures", func() {
+				Expect(statements).To(HaveLen(27))
+				Expect(statements[0]).To(ContainSubstring("CREATE SCHEMA broker"))
+				Expect(statements[1]).To(ContainSubstring("CREATE TABLE broker.service_instances("))
+				Expect(statements[3]).To(ContainSubstring("REFERENCES broker.service_instances(id)"))
+				Expect(statements[13]).To(ContainSubstring("ALTER TABLE broker.service_instances ALTER COLUMN value NVARCHAR(MAX)"))
+				Expect(statements[26]).To(ContainSubstring("CREATE PROCEDURE broker.ReleaseAppLockForUpdate"))
+				Expect(database.GetTableName("file_shares")).To(Equal("broker.file_shares"))
+				Expect(database.GetAppLockSQL()).To(Equal("broker.GetAppLockForUpdate @LockName = ?, @Timeout = ?"))
+				Expect(database.GetReleaseAppLockSQL()).To(Equal("broker.ReleaseAppLockForUpdate @LockName = ?"))
+			})
+
+			It("creates only the schema and the procedures for a database of the locks", func() {
+				lockStatements := database.(azurefilebroker.AppLockInitialize).GetInitializeAppLockSQL()
+				Expect(lockStatements).To(HaveLen(3))
+				Expect(lockStatements[0]).To(ContainSubstring("CREATE SCHEMA broker"))
+				Expect(lockStatements[1]).To(ContainSubstring("CREATE PROCEDURE broker.GetAppLockForUpdate"))
+				Expect(lockStatements[2]).To(ContainSubstring("CREATE PROCEDURE broker.ReleaseAppLockForUpdate"))
+			})
+
+			It("probes the permission to execute the procedures", func() {
+				probes := database.(azurefilebroker.ProcedureProbe).GetProcedureProbeSQL()
+				Expect(probes).To(HaveLen(2))
+				Expect(probes["broker.GetAppLockForUpdate"]).To(Equal("SELECT HAS_PERMS_BY_NAME(N'broker.GetAppLockForUpdate', N'OBJECT', N'EXECUTE')"))
+				Expect(probes).To(HaveKey("broker.ReleaseAppLockForUpdate"))
+			})
+		})
+	})
+
+	Describe("lock sessions", func() {
+		const (
+			// The owner IDs of two shares in the same storage account share the first 32 characters, which are all
+			// that the locks show of their names
+			lockName      = "6b085460-5f21-4b28-b28e-5d0c8e9f6a3b-cf-resource-group-mysharedstorageaccount-share-a"
+			otherLockName = "6b085460-5f21-4b28-b28e-5d0c8e9f6a3b-cf-resource-group-mysharedstorageaccount-share-b"
+		)
+
+		var (
+			mock    sqlmock.Sqlmock
+			breaker azurefilebroker.LockBreaker
+		)
+
+		BeforeEach(func() {
+			db, m, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			mock = m
+			fakeSql.OpenReturns(db, nil)
+		})
+
+		JustBeforeEach(func() {
+			connection := azurefilebroker.NewSqlConnection(database)
+			Expect(connection.Connect()).To(Succeed())
+			breaker = azurefilebroker.NewSqlAppLockProvider(connection).(azurefilebroker.LockBreaker)
+			Expect(lockName[:32]).To(Equal(otherLockName[:32]))
+		})
+
+		It("tests the exact name of the lock in the sessions whose locks have its prefix", func() {
+			sessionSQL := database.GetAppLockSessionSQL()
+			Expect(sessionSQL).To(ContainSubstring("LEFT(n.name, 32)"))
+			Expect(sessionSQL).To(ContainSubstring("APPLOCK_TEST('public', n.name, 'Exclusive', 'Session') = 0"))
+			Expect(sessionSQL).To(ContainSubstring("APPLOCK_MODE('public', n.name, 'Session') <> 'NoLock'"))
+			Expect(sessionSQL).NotTo(ContainSubstring("TOP 1"))
+		})
+
+		It("ends the only session which holds the exact name", func() {
+			mock.ExpectQuery("SELECT DISTINCT l.request_session_id").WithArgs(lockName).WillReturnRows(sqlmock.NewRows([]string{"request_session_id"}).AddRow(53))
+			mock.ExpectExec("KILL 53").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			session, err := breaker.BreakLock(lockName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(session).To(Equal("53"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("refuses to end a session when two sessions hold locks with the same prefix", func() {
+			mock.ExpectQuery("SELECT DISTINCT l.request_session_id").WithArgs(lockName).WillReturnRows(sqlmock.NewRows([]string{"request_session_id"}).AddRow(53).AddRow(54))
+			mock.ExpectQuery("SELECT DISTINCT l.request_session_id").WithArgs(lockName).WillReturnRows(sqlmock.NewRows([]string{"request_session_id"}).AddRow(53).AddRow(54))
+
+			_, err := breaker.LockSession(lockName)
+			Expect(err).To(MatchError(ContainSubstring("because the sessions 53, 54 hold locks of the same name prefix")))
+			session, err := breaker.BreakLock(lockName)
+			Expect(err).To(HaveOccurred())
+			Expect(session).To(BeEmpty())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("reports the lock as free when only another lock with the same prefix is held", func() {
+			mock.ExpectQuery("SELECT DISTINCT l.request_session_id").WithArgs(lockName).WillReturnRows(sqlmock.NewRows([]string{"request_session_id"}))
+
+			session, err := breaker.LockSession(lockName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(session).To(BeEmpty())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
 })
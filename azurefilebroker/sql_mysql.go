@@ -3,8 +3,10 @@ package azurefilebroker
 import (
 	"fmt"
 
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"time"
 
 	"code.cloudfoundry.org/goshims/sqlshim"
@@ -12,9 +14,14 @@ import (
 	"github.com/go-sql-driver/mysql"
 )
 
+const maxMySqlAppLockNameLength = 64
+
 type mysqlVariant struct {
 	sql                   sqlshim.Sql
-	dbConnectionString    string
+	username              string
+	password              string
+	host                  string
+	port                  string
 	caCert                string
 	hostNameInCertificate string
 	dbName                string
@@ -28,7 +35,10 @@ func NewMySqlVariant(logger lager.Logger, username, password, host, port, dbName
 func NewMySqlVariantWithSqlObject(logger lager.Logger, username, password, host, port, dbName, caCert, hostNameInCertificate string, sql sqlshim.Sql) SqlVariant {
 	return &mysqlVariant{
 		sql:                   sql,
-		dbConnectionString:    fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", username, password, host, port, dbName),
+		username:              username,
+		password:              password,
+		host:                  host,
+		port:                  port,
 		caCert:                caCert,
 		hostNameInCertificate: hostNameInCertificate,
 		dbName:                dbName,
@@ -41,9 +51,10 @@ func (c *mysqlVariant) Connect() (sqlshim.SqlDB, error) {
 	logger.Info("start")
 	defer logger.Info("end")
 
+	dbConnectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", c.username, c.password, c.host, c.port, c.dbName)
 	if c.caCert != "" {
 		logger.Info("secure-mysql-with-certificate")
-		cfg, err := mysql.ParseDSN(c.dbConnectionString)
+		cfg, err := mysql.ParseDSN(dbConnectionString)
 		if err != nil {
 			err := fmt.Errorf("Invalid connection string for %s", c.dbName)
 			logger.Error("invalid-db-connection-string", err)
@@ -71,7 +82,7 @@ func (c *mysqlVariant) Connect() (sqlshim.SqlDB, error) {
 		cfg.ReadTimeout = 10 * time.Minute
 		cfg.WriteTimeout = 10 * time.Minute
 
-		c.dbConnectionString = cfg.FormatDSN()
+		dbConnectionString = cfg.FormatDSN()
 	} else if c.hostNameInCertificate != "" {
 		logger.Info("secure-mysql-without-certificate")
 		err := mysql.RegisterTLSConfig("custom", &tls.Config{
@@ -83,39 +94,50 @@ func (c *mysqlVariant) Connect() (sqlshim.SqlDB, error) {
 			return nil, err
 		}
 
-		c.dbConnectionString = fmt.Sprintf("%s?allowNativePasswords=true&tls=custom", c.dbConnectionString)
+		dbConnectionString = fmt.Sprintf("%s?allowNativePasswords=true&tls=custom", dbConnectionString)
 	}
 
-	sqlDB, err := c.sql.Open("mysql", c.dbConnectionString)
+	sqlDB, err := c.sql.Open("mysql", dbConnectionString)
 	return sqlDB, err
 }
 
+func (c *mysqlVariant) SetCredentials(username, password string) {
+	c.username = username
+	c.password = password
+}
+
 func (c *mysqlVariant) GetInitializeDatabaseSQL() []string {
-	return []string{
-		`CREATE TABLE IF NOT EXISTS service_instances(
-			id VARCHAR(255) PRIMARY KEY,
-			service_id VARCHAR(255),
-			plan_id VARCHAR(255),
-			organization_guid VARCHAR(255),
-			space_guid VARCHAR(255),
-			target_name VARCHAR(4096),
-			hash_key VARCHAR(255),
-			value VARCHAR(4096),
-			UNIQUE (hash_key)
-		)`,
-		`CREATE TABLE IF NOT EXISTS service_bindings(
-			id VARCHAR(255) PRIMARY KEY,
-			value VARCHAR(4096)
-		)`,
-		`CREATE TABLE IF NOT EXISTS file_shares(
-			id VARCHAR(255) PRIMARY KEY,
-			instance_id VARCHAR(255),
-			FOREIGN KEY instance_id(instance_id) REFERENCES service_instances(id),
-			file_share_name VARCHAR(255),
-			value VARCHAR(4096),
-			CONSTRAINT file_share UNIQUE (instance_id, file_share_name)
-		)`,
+	return createTablesSQL(c)
+}
+
+func (c *mysqlVariant) createTableIfNotExistsSQL(table, definition string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s", table, definition)
+}
+
+// valueColumnType returns LONGTEXT because TEXT takes 64 KB only
+func (c *mysqlVariant) valueColumnType() string {
+	return "LONGTEXT"
+}
+
+// widenValueColumnSQL modifies the column unconditionally. MySQL does not rebuild the table when the column already
+// has the type.
+func (c *mysqlVariant) widenValueColumnSQL(table string) string {
+	return fmt.Sprintf("ALTER TABLE %s MODIFY %s %s", table, valueColumn, c.valueColumnType())
+}
+
+// GetTableName returns the table as is because the schema of MySQL is the database
+func (c *mysqlVariant) GetTableName(table string) string {
+	return table
+}
+
+// GetAppLockName hashes the names which are longer than the 64 characters which GET_LOCK accepts since MySQL 5.7, such
+// as the owner IDs of the file shares. The hash is 64 hexadecimal characters.
+func (c *mysqlVariant) GetAppLockName(lockName string) string {
+	if len(lockName) <= maxMySqlAppLockNameLength {
+		return lockName
 	}
+	hash := sha256.Sum256([]byte(lockName))
+	return hex.EncodeToString(hash[:])
 }
 
 func (c *mysqlVariant) GetAppLockSQL() string {
@@ -125,3 +147,13 @@ func (c *mysqlVariant) GetAppLockSQL() string {
 func (c *mysqlVariant) GetReleaseAppLockSQL() string {
 	return "SELECT RELEASE_LOCK(?)"
 }
+
+// GetAppLockSessionSQL returns the query of the connection ID which holds a lock, which is NULL when it is free
+func (c *mysqlVariant) GetAppLockSessionSQL() string {
+	return "SELECT IS_USED_LOCK(?)"
+}
+
+// GetKillSessionSQL requires the privilege CONNECTION_ADMIN or SUPER unless the connection is of the same user
+func (c *mysqlVariant) GetKillSessionSQL(session string) string {
+	return "KILL " + session
+}
@@ -4,12 +4,15 @@ import (
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
 
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 
 	"code.cloudfoundry.org/azurefilebroker/azurefilebroker"
 	"code.cloudfoundry.org/goshims/sqlshim/sql_fake"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
 
 var _ = Describe("MysqlVariant", func() {
@@ -112,4 +115,71 @@ var _ = Describe("MysqlVariant", func() {
 			})
 		})
 	})
+
+	Describe(".GetInitializeDatabaseSQL", func() {
+		It("creates every table once", func() {
+			statements := database.GetInitializeDatabaseSQL()
+			Expect(statements).To(HaveLen(24))
+			Expect(statements[0]).To(HavePrefix("CREATE TABLE IF NOT EXISTS service_instances("))
+			Expect(statements[0]).To(ContainSubstring("UNIQUE (hash_key)"))
+			Expect(statements[0]).To(ContainSubstring("value LONGTEXT"))
+			Expect(statements[2]).To(ContainSubstring("FOREIGN KEY (instance_id) REFERENCES service_instances(id)"))
+			Expect(database.GetTableName("file_shares")).To(Equal("file_shares"))
+		})
+
+		It("widens the value column of the tables of an older broker", func() {
+			statements := database.GetInitializeDatabaseSQL()
+			Expect(statements[12]).To(Equal("ALTER TABLE service_instances MODIFY value LONGTEXT"))
+			Expect(statements[23]).To(Equal("ALTER TABLE schema_versions MODIFY value LONGTEXT"))
+		})
+	})
+
+	Describe("app locks", func() {
+		var (
+			mock     sqlmock.Sqlmock
+			provider azurefilebroker.LockProvider
+		)
+
+		BeforeEach(func() {
+			db, m, err := sqlmock.New()
+			Expect(err).NotTo(HaveOccurred())
+			mock = m
+			fakeSql.OpenReturns(db, nil)
+		})
+
+		JustBeforeEach(func() {
+			connection := azurefilebroker.NewSqlConnection(database)
+			Expect(connection.Connect()).To(Succeed())
+			provider = azurefilebroker.NewSqlAppLockProvider(connection)
+		})
+
+		It("hashes the owner IDs of the file shares which are longer than the 64 characters of GET_LOCK", func() {
+			ownerID := "6b085460-5f21-4b28-b28e-5d0c8e9f6a3b-cf-resource-group-mysharedstorageaccount-myfileshare"
+			hash := sha256.Sum256([]byte(ownerID))
+			lockName := hex.EncodeToString(hash[:])
+			Expect(lockName).To(HaveLen(64))
+
+			mock.ExpectQuery("SELECT GET_LOCK").WithArgs(lockName, 30).WillReturnRows(sqlmock.NewRows([]string{"ret"}).AddRow(1))
+			mock.ExpectQuery("SELECT IS_USED_LOCK").WithArgs(lockName).WillReturnRows(sqlmock.NewRows([]string{"session"}).AddRow("42"))
+			mock.ExpectExec("SELECT RELEASE_LOCK").WithArgs(lockName).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			Expect(provider.GetLockForUpdate(ownerID, 30)).To(Succeed())
+			session, err := provider.(azurefilebroker.LockBreaker).LockSession(ownerID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(session).To(Equal("42"))
+			Expect(provider.ReleaseLockForUpdate(ownerID)).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("keeps the names of at most 64 characters", func() {
+			lockName := "6b085460-5f21-4b28-b28e-5d0c8e9f6a3b"
+
+			mock.ExpectQuery("SELECT GET_LOCK").WithArgs(lockName, 30).WillReturnRows(sqlmock.NewRows([]string{"ret"}).AddRow(1))
+			mock.ExpectExec("SELECT RELEASE_LOCK").WithArgs(lockName).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			Expect(provider.GetLockForUpdate(lockName, 30)).To(Succeed())
+			Expect(provider.ReleaseLockForUpdate(lockName)).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
 })
@@ -0,0 +1,169 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The tables of the store
+const (
+	tableServiceInstances      = "service_instances"
+	tableServiceBindings       = "service_bindings"
+	tableFileShares            = "file_shares"
+	tableStorageAccountOwners  = "storage_account_owners"
+	tableFileShareOwners       = "file_share_owners"
+	tableScheduledDeletions    = "scheduled_deletions"
+	tableFeatureFlags          = "feature_flags"
+	tablePendingShareDeletions = "pending_share_deletions"
+	tablePooledStorageAccounts = "pooled_storage_accounts"
+	tableInstanceOperations    = "instance_operations"
+	tableLockHolders           = "lock_holders"
+	tableSchemaVersions        = "schema_versions"
+)
+
+type sqlForeignKey struct {
+	column           string
+	referencedTable  string
+	referencedColumn string
+}
+
+type sqlUniqueConstraint struct {
+	name    string // the constraint is not named if it is empty
+	columns []string
+}
+
+// sqlTable is the definition of a table which the DDL of every SQL variant is generated from
+type sqlTable struct {
+	name        string
+	columns     []string
+	foreignKeys []sqlForeignKey
+	uniques     []sqlUniqueConstraint
+}
+
+// valueColumn is the column which stores a record as JSON. Its type is the large text type of the dialect, see
+// sqlDialect.valueColumnType, because a record grows with e.g. the shares of an instance.
+const valueColumn = "value"
+
+// MaxStoreValueSize is the size in bytes of the largest record which the store writes. The column takes more, but a
+// larger record is a bug which must fail with a clear error instead of the limit of a packet of the database.
+const MaxStoreValueSize = 1024 * 1024
+
+// keyValueTable is a table which stores the value of every key as JSON
+func keyValueTable(name string) sqlTable {
+	return sqlTable{
+		name: name,
+		columns: []string{
+			"id VARCHAR(255) PRIMARY KEY",
+			valueColumn,
+		},
+	}
+}
+
+// sqlTables are the tables of the store in the order in which they must be created. Two versions of the broker run
+// against the same database during a blue/green upgrade, so a new version only adds tables and keeps the values of the
+// records readable by the previous one, see brokerCompatibilityVersion.
+var sqlTables = []sqlTable{
+	{
+		name: tableServiceInstances,
+		columns: []string{
+			"id VARCHAR(255) PRIMARY KEY",
+			"service_id VARCHAR(255)",
+			"plan_id VARCHAR(255)",
+			"organization_guid VARCHAR(255)",
+			"space_guid VARCHAR(255)",
+			"target_name VARCHAR(4096)",
+			"hash_key VARCHAR(255)",
+			valueColumn,
+		},
+		uniques: []sqlUniqueConstraint{{columns: []string{"hash_key"}}},
+	},
+	keyValueTable(tableServiceBindings),
+	{
+		name: tableFileShares,
+		columns: []string{
+			"id VARCHAR(255) PRIMARY KEY",
+			"instance_id VARCHAR(255)",
+			"file_share_name VARCHAR(255)",
+			valueColumn,
+		},
+		foreignKeys: []sqlForeignKey{{column: "instance_id", referencedTable: tableServiceInstances, referencedColumn: "id"}},
+		uniques:     []sqlUniqueConstraint{{name: "file_share", columns: []string{"instance_id", "file_share_name"}}},
+	},
+	keyValueTable(tableStorageAccountOwners),
+	keyValueTable(tableFileShareOwners),
+	keyValueTable(tableScheduledDeletions),
+	keyValueTable(tableFeatureFlags),
+	keyValueTable(tablePendingShareDeletions),
+	keyValueTable(tablePooledStorageAccounts),
+	{
+		// The history of the operations is kept after the instance is deleted, so the instance is not referenced
+		name: tableInstanceOperations,
+		columns: []string{
+			"id VARCHAR(255) PRIMARY KEY",
+			"instance_id VARCHAR(255)",
+			valueColumn,
+		},
+	},
+	keyValueTable(tableLockHolders),
+	keyValueTable(tableSchemaVersions),
+}
+
+// definitionSQL returns the columns and the constraints of the table in parentheses, which follow the name of the
+// table in a CREATE TABLE statement
+func (t sqlTable) definitionSQL(dialect sqlDialect) string {
+	definitions := []string{}
+	for _, column := range t.columns {
+		if column == valueColumn {
+			column = valueColumn + " " + dialect.valueColumnType()
+		}
+		definitions = append(definitions, column)
+	}
+	for _, foreignKey := range t.foreignKeys {
+		definitions = append(definitions, fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s(%s)", foreignKey.column, dialect.GetTableName(foreignKey.referencedTable), foreignKey.referencedColumn))
+	}
+	for _, unique := range t.uniques {
+		constraint := fmt.Sprintf("UNIQUE (%s)", strings.Join(unique.columns, ", "))
+		if unique.name != "" {
+			constraint = fmt.Sprintf("CONSTRAINT %s %s", unique.name, constraint)
+		}
+		definitions = append(definitions, constraint)
+	}
+	return fmt.Sprintf("(\n\t%s\n)", strings.Join(definitions, ",\n\t"))
+}
+
+// sqlDialect renders the parts of the DDL which differ between the SQL variants
+type sqlDialect interface {
+	// GetTableName returns the name of the table in the SQL statements
+	GetTableName(table string) string
+	// createTableIfNotExistsSQL returns a statement which creates the table unless it exists
+	createTableIfNotExistsSQL(table, definition string) string
+	// valueColumnType returns the type of the value column, which takes a record of MaxStoreValueSize
+	valueColumnType() string
+	// widenValueColumnSQL returns a statement which changes the value column of a table created with VARCHAR(4096)
+	// by an older broker to valueColumnType. It changes nothing when the column is already wide.
+	widenValueColumnSQL(table string) string
+}
+
+// createTablesSQL returns the statements which create the tables of the store in the dialect and widen the value
+// columns of the tables which already exist. A wider column is still read and written by the older brokers.
+func createTablesSQL(dialect sqlDialect) []string {
+	statements := []string{}
+	for _, table := range sqlTables {
+		statements = append(statements, dialect.createTableIfNotExistsSQL(dialect.GetTableName(table.name), table.definitionSQL(dialect)))
+	}
+	for _, table := range sqlTables {
+		statements = append(statements, dialect.widenValueColumnSQL(dialect.GetTableName(table.name)))
+	}
+	return statements
+}
+
+var sqlIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateSQLIdentifier checks a name which is put in SQL statements as is, e.g. a schema
+func validateSQLIdentifier(kind, name string) error {
+	if !sqlIdentifierPattern.MatchString(name) {
+		return fmt.Errorf("Invalid %s %q. Expected letters, digits and underscores", kind, name)
+	}
+	return nil
+}
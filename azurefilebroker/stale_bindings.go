@@ -0,0 +1,200 @@
+package azurefilebroker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/tedsuo/ifrit"
+	resty "gopkg.in/resty.v0"
+)
+
+//go:generate counterfeiter -o ../azurefilebrokerfakes/fake_app_checker.go . AppChecker
+type AppChecker interface {
+	// AppExists returns false only when the platform knows that the app does not exist
+	AppExists(appGUID string) (bool, error)
+}
+
+type cloudControllerAppChecker struct {
+	apiURL       string
+	uaaURL       string
+	clientID     string
+	clientSecret string
+}
+
+// NewCloudControllerAppChecker returns a checker which asks the Cloud Controller for the apps. The UAA client needs
+// the authority cloud_controller.admin_read_only or cloud_controller.global_auditor to see the apps of all spaces.
+func NewCloudControllerAppChecker(apiURL, uaaURL, clientID, clientSecret string) AppChecker {
+	return &cloudControllerAppChecker{apiURL: apiURL, uaaURL: uaaURL, clientID: clientID, clientSecret: clientSecret}
+}
+
+func (c *cloudControllerAppChecker) AppExists(appGUID string) (bool, error) {
+	token, err := getClientCredentialsToken(c.uaaURL, c.clientID, c.clientSecret)
+	if err != nil {
+		return false, err
+	}
+	resp, err := resty.R().
+		SetAuthToken(token).
+		Get(c.apiURL + "/v3/apps/" + appGUID)
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode() {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Error Code: %d, %v", resp.StatusCode(), resp)
+	}
+}
+
+// staleBindings are the bindings whose apps were missing, keyed by the binding ID, with the first time that the app
+// was seen missing. They are kept in memory, so a restart of the broker starts their grace periods again.
+type staleBindings struct {
+	mutex   sync.Mutex
+	checker AppChecker
+	grace   time.Duration
+	flagged map[string]time.Time
+}
+
+// SetStaleBindingPolicy enables the cleanup of the bindings whose apps no longer exist in Cloud Foundry. A binding is
+// unbound when its app is still missing after the grace period, so that a deleted app does not pin the count of its
+// file share forever.
+func (b *Broker) SetStaleBindingPolicy(checker AppChecker, gracePeriod time.Duration) {
+	b.staleBindings = &staleBindings{checker: checker, grace: gracePeriod, flagged: map[string]time.Time{}}
+}
+
+// StaleBindingCleaner returns a runner which periodically cleans up the stale bindings
+func (b *Broker) StaleBindingCleaner(interval time.Duration) ifrit.Runner {
+	return b.newPeriodicRunner("stale-binding-cleaner", interval, func(logger lager.Logger) {
+		if err := b.CleanUpStaleBindings(logger); err != nil {
+			logger.Error("clean-up-stale-bindings", err)
+		}
+	})
+}
+
+// StaleBindings returns the IDs of the bindings whose apps are missing and when they were flagged
+func (b *Broker) StaleBindings() map[string]time.Time {
+	flagged := map[string]time.Time{}
+	if b.staleBindings == nil {
+		return flagged
+	}
+	b.staleBindings.mutex.Lock()
+	defer b.staleBindings.mutex.Unlock()
+	for bindingID, since := range b.staleBindings.flagged {
+		flagged[bindingID] = since
+	}
+	return flagged
+}
+
+// CleanUpStaleBindings checks the apps of the bindings of the AzureFileShare instances. A binding is flagged when its
+// app is missing, unflagged when the app is back, and unbound when the app is still missing after the grace period.
+// An app which cannot be checked keeps the flags of its bindings as they are.
+func (b *Broker) CleanUpStaleBindings(logger lager.Logger) error {
+	logger = logger.Session("clean-up-stale-bindings")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if b.staleBindings == nil {
+		return nil
+	}
+	bindings, err := b.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return err
+	}
+	shares, err := b.store.RetrieveFileShares()
+	if err != nil {
+		return err
+	}
+
+	bindingIDs := []string{}
+	for bindingID, bindingDetails := range bindings {
+		// The bindings of the preexisting instances do not count in a file share
+		if bindingDetails.FileShareID == "" || bindingDetails.AppGUID == "" {
+			continue
+		}
+		bindingIDs = append(bindingIDs, bindingID)
+	}
+	sort.Strings(bindingIDs)
+
+	now := b.clock.Now()
+	appExists := map[string]bool{}
+	flagged, unbound, failed := 0, 0, 0
+	for _, bindingID := range bindingIDs {
+		bindingDetails := bindings[bindingID]
+		exists, checked := appExists[bindingDetails.AppGUID]
+		if !checked {
+			if exists, err = b.staleBindings.checker.AppExists(bindingDetails.AppGUID); err != nil {
+				logger.Error("check-app", err, lager.Data{"appGUID": bindingDetails.AppGUID})
+				failed++
+				continue
+			}
+			appExists[bindingDetails.AppGUID] = exists
+		}
+
+		since, stale := b.staleBindings.flag(bindingID, !exists, now)
+		if !stale {
+			continue
+		}
+		if now.Sub(since) < b.staleBindings.grace {
+			logger.Info("stale-binding-flagged", lager.Data{"bindingID": bindingID, "appGUID": bindingDetails.AppGUID, "since": since})
+			flagged++
+			continue
+		}
+
+		share, ok := shares[bindingDetails.FileShareID]
+		if !ok {
+			logger.Info("file-share-of-stale-binding-not-found", lager.Data{"bindingID": bindingID, "fileShareID": bindingDetails.FileShareID})
+			failed++
+			continue
+		}
+		err := b.Unbind(withOriginatingIdentity(context.Background(), "azurefilebroker stale-binding-cleaner"), share.InstanceID, bindingID, brokerapi.UnbindDetails{
+			ServiceID: bindingDetails.ServiceID,
+			PlanID:    bindingDetails.PlanID,
+		})
+		if err != nil && err != brokerapi.ErrBindingDoesNotExist {
+			logger.Error("unbind-stale-binding", err, lager.Data{"bindingID": bindingID, "instanceID": share.InstanceID})
+			failed++
+			continue
+		}
+		b.staleBindings.flag(bindingID, false, now)
+		logger.Info("stale-binding-unbound", lager.Data{"bindingID": bindingID, "instanceID": share.InstanceID, "appGUID": bindingDetails.AppGUID, "since": since})
+		unbound++
+	}
+	b.staleBindings.forgetExcept(bindings)
+	logger.Info("stale-bindings", lager.Data{"flagged": flagged, "unbound": unbound, "failed": failed})
+	return nil
+}
+
+// flag flags or unflags the binding and returns since when it is flagged
+func (s *staleBindings) flag(bindingID string, missing bool, now time.Time) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !missing {
+		delete(s.flagged, bindingID)
+		return time.Time{}, false
+	}
+	since, ok := s.flagged[bindingID]
+	if !ok {
+		since = now
+		s.flagged[bindingID] = since
+	}
+	return since, true
+}
+
+// forgetExcept unflags the bindings which were unbound by the platform meanwhile
+func (s *staleBindings) forgetExcept(bindings map[string]BindingDetails) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for bindingID := range s.flagged {
+		if _, ok := bindings[bindingID]; !ok {
+			delete(s.flagged, bindingID)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	// AlertCapacity fires when the used capacity of the storage account is above the threshold in GiB
+	AlertCapacity = "capacity"
+	// AlertThrottling fires when more requests than the threshold are throttled in 15 minutes
+	AlertThrottling = "throttling"
+	// AlertAvailability fires when the availability of the storage account is below the threshold in percent
+	AlertAvailability = "availability"
+)
+
+var actionGroupIDPattern = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Insights/actionGroups/[^/]+$`)
+
+// StorageAccountAlert is an Azure Monitor metric alert which is created on the storage accounts of a plan
+type StorageAccountAlert struct {
+	PlanName  string
+	Name      string
+	Threshold float64
+}
+
+// AlertConfig is the metric alerts of the storage accounts which the broker creates and the action group which they
+// notify. No alert is created when it has no entry.
+type AlertConfig struct {
+	ActionGroupID string
+	Alerts        []StorageAccountAlert
+
+	invalidEntries []string
+}
+
+// NewAlertConfig parses a comma separated list of plans, alerts and their thresholds:
+//
+//	<plan name>:capacity|throttling|availability=<threshold>
+func NewAlertConfig(alerts, actionGroupID string) *AlertConfig {
+	myConf := new(AlertConfig)
+
+	myConf.ActionGroupID = strings.TrimSpace(actionGroupID)
+	myConf.Alerts = make([]StorageAccountAlert, 0)
+	for _, entry := range strings.Split(alerts, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if alert, ok := parseStorageAccountAlert(entry); ok {
+			myConf.Alerts = append(myConf.Alerts, alert)
+		} else {
+			myConf.invalidEntries = append(myConf.invalidEntries, entry)
+		}
+	}
+
+	return myConf
+}
+
+func parseStorageAccountAlert(entry string) (StorageAccountAlert, bool) {
+	pair := strings.SplitN(entry, "=", 2)
+	if len(pair) != 2 {
+		return StorageAccountAlert{}, false
+	}
+	target := strings.SplitN(pair[0], ":", 2)
+	if len(target) != 2 {
+		return StorageAccountAlert{}, false
+	}
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(pair[1]), 64)
+	if err != nil || threshold <= 0 {
+		return StorageAccountAlert{}, false
+	}
+	alert := StorageAccountAlert{
+		PlanName:  strings.TrimSpace(target[0]),
+		Name:      strings.TrimSpace(target[1]),
+		Threshold: threshold,
+	}
+	if alert.PlanName == "" || alert.Name == "" {
+		return StorageAccountAlert{}, false
+	}
+	return alert, true
+}
+
+// Validate checks the alerts, that the plans are AzureFileShare plans of the catalog and that the action group is given
+func (config *AlertConfig) Validate(segments *IsolationSegmentConfig, catalog *CatalogConfig, azure *AzureConfig) error {
+	if len(config.invalidEntries) > 0 {
+		return fmt.Errorf("Invalid entries in storageAccountAlerts: %s. Expected <plan name>:<alert>=<threshold> with a positive threshold", strings.Join(config.invalidEntries, ", "))
+	}
+	if len(config.Alerts) == 0 {
+		return nil
+	}
+	if !azure.IsSupportAzureFileShare() {
+		return fmt.Errorf("storageAccountAlerts requires AzureFileShare")
+	}
+	if azure.GetAPIVersions().MetricAlerts == "" {
+		return fmt.Errorf("storageAccountAlerts is not supported in the environment %q", azure.Environment)
+	}
+	if !actionGroupIDPattern.MatchString(config.ActionGroupID) {
+		return fmt.Errorf("Invalid alertActionGroupID %q: expected /subscriptions/<id>/resourceGroups/<name>/providers/Microsoft.Insights/actionGroups/<name>", config.ActionGroupID)
+	}
+	planNames := optionPlanNames(segments, catalog, false)
+
+	alerts := map[string]bool{}
+	for _, alert := range config.Alerts {
+		if !inArray(planNames, alert.PlanName) {
+			return fmt.Errorf("Unknown plan %q in storageAccountAlerts: expected one of %s", alert.PlanName, strings.Join(planNames, ", "))
+		}
+		switch alert.Name {
+		case AlertCapacity, AlertThrottling:
+		case AlertAvailability:
+			if alert.Threshold > 100 {
+				return fmt.Errorf("Invalid threshold %v of the alert %q of the plan %q in storageAccountAlerts: expected a percentage", alert.Threshold, alert.Name, alert.PlanName)
+			}
+		default:
+			return fmt.Errorf("Unknown alert %q of the plan %q in storageAccountAlerts: expected %s, %s or %s", alert.Name, alert.PlanName, AlertCapacity, AlertThrottling, AlertAvailability)
+		}
+		key := alert.PlanName + ":" + alert.Name
+		if alerts[key] {
+			return fmt.Errorf("The alert %q of the plan %q is given more than once in storageAccountAlerts", alert.Name, alert.PlanName)
+		}
+		alerts[key] = true
+	}
+	return nil
+}
+
+// planAlerts returns the alerts of the plan sorted by name
+func (config *AlertConfig) planAlerts(planName string) []StorageAccountAlert {
+	alerts := []StorageAccountAlert{}
+	for _, alert := range config.Alerts {
+		if alert.PlanName == planName {
+			alerts = append(alerts, alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Name < alerts[j].Name })
+	return alerts
+}
+
+// storageAccountAlertName returns the name of the alert rule of the storage account, which is unique in the resource
+// group of the storage account
+func storageAccountAlertName(storageAccountName, alertName string) string {
+	return fmt.Sprintf("%s-%s", storageAccountName, alertName)
+}
+
+// createStorageAccountAlerts creates the alerts of the plan on a storage account which the broker has created and
+// records their names in the instance. A failure is only logged because the storage account is usable without them.
+func (b *Broker) createStorageAccountAlerts(logger lager.Logger, serviceInstance *ServiceInstance) {
+	alerts := b.config.alerts.planAlerts(b.instancePlanName(serviceInstance))
+	if len(alerts) == 0 {
+		return
+	}
+	logger = logger.Session("create-storage-account-alerts")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	restClient, err := b.newRESTClientOfServiceInstance(logger, serviceInstance)
+	if err != nil {
+		logger.Error("new-rest-client", err)
+		return
+	}
+	for _, alert := range alerts {
+		alertName := storageAccountAlertName(serviceInstance.TargetName, alert.Name)
+		if inArray(serviceInstance.MetricAlerts, alertName) {
+			continue
+		}
+		if err := restClient.CreateMetricAlert(alertName, alert, b.config.alerts.ActionGroupID); err != nil {
+			logger.Error("create-metric-alert", err, lager.Data{"alertName": alertName})
+			continue
+		}
+		serviceInstance.MetricAlerts = append(serviceInstance.MetricAlerts, alertName)
+		logger.Info("metric-alert-created", lager.Data{"alertName": alertName})
+	}
+}
+
+// deleteStorageAccountAlerts deletes the alerts of a storage account which the broker has deleted. Azure Monitor keeps
+// the alert rules of deleted resources. A failure is only logged because the storage account is gone.
+func (b *Broker) deleteStorageAccountAlerts(logger lager.Logger, serviceInstance *ServiceInstance) {
+	if len(serviceInstance.MetricAlerts) == 0 {
+		return
+	}
+	logger = logger.Session("delete-storage-account-alerts")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	restClient, err := b.newRESTClientOfServiceInstance(logger, serviceInstance)
+	if err != nil {
+		logger.Error("new-rest-client", err)
+		return
+	}
+	for _, alertName := range serviceInstance.MetricAlerts {
+		if err := restClient.DeleteMetricAlert(alertName); err != nil {
+			logger.Error("delete-metric-alert", err, lager.Data{"alertName": alertName})
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package azurefilebroker
+
+import (
+	"sort"
+)
+
+// StorageAccountReferences are the service instances and the bindings which use a storage account
+type StorageAccountReferences struct {
+	StorageAccountName string                   `json:"storage_account_name"`
+	Instances          []StorageAccountInstance `json:"instances"`
+	// UnattributedBindings is the number of bindings created by older versions of the broker, which do not record
+	// their file share, so they may also use the storage account
+	UnattributedBindings int `json:"unattributed_bindings"`
+}
+
+// StorageAccountInstance is a service instance which uses a storage account
+type StorageAccountInstance struct {
+	InstanceID              string                  `json:"instance_id"`
+	OrganizationGUID        string                  `json:"organization_guid"`
+	SpaceGUID               string                  `json:"space_guid"`
+	SubscriptionID          string                  `json:"subscription_id"`
+	ResourceGroupName       string                  `json:"resource_group_name"`
+	IsCreatedStorageAccount bool                    `json:"is_created_storage_account"`
+	Bindings                []StorageAccountBinding `json:"bindings"`
+}
+
+// StorageAccountBinding is a binding to a file share of a storage account
+type StorageAccountBinding struct {
+	BindingID     string `json:"binding_id"`
+	AppGUID       string `json:"app_guid"`
+	FileShareName string `json:"file_share_name"`
+}
+
+// StorageAccountReferences returns the AzureFileShare instances of the storage account and their bindings sorted by
+// their IDs, e.g. before the storage account is decommissioned or its keys are rotated after a leak
+func (b *Broker) StorageAccountReferences(storageAccountName string) (StorageAccountReferences, error) {
+	references := StorageAccountReferences{StorageAccountName: storageAccountName, Instances: []StorageAccountInstance{}}
+
+	instances, err := b.readStore().RetrieveServiceInstancesByTargetName(storageAccountName)
+	if err != nil {
+		return references, newStoreError(err, "Failed to retrieve the service instances of the storage account %q", storageAccountName)
+	}
+	if len(instances) == 0 {
+		return references, nil
+	}
+
+	shares, err := b.readStore().RetrieveFileShares()
+	if err != nil {
+		return references, newStoreError(err, "Failed to retrieve the file shares")
+	}
+	bindings, err := b.readStore().RetrieveAllBindingDetails()
+	if err != nil {
+		return references, newStoreError(err, "Failed to retrieve the bindings")
+	}
+
+	bindingsOfInstance := map[string][]StorageAccountBinding{}
+	for bindingID, bindingDetails := range bindings {
+		if bindingDetails.FileShareID == "" {
+			if len(bindingDetails.RawParameters) > 0 {
+				references.UnattributedBindings++
+			}
+			continue
+		}
+		share, ok := shares[bindingDetails.FileShareID]
+		if !ok {
+			continue
+		}
+		bindingsOfInstance[share.InstanceID] = append(bindingsOfInstance[share.InstanceID], StorageAccountBinding{
+			BindingID:     bindingID,
+			AppGUID:       bindingDetails.AppGUID,
+			FileShareName: share.FileShareName,
+		})
+	}
+
+	for instanceID, instance := range instances {
+		if instance.IsPreexisting {
+			continue
+		}
+		instanceBindings := bindingsOfInstance[instanceID]
+		if instanceBindings == nil {
+			instanceBindings = []StorageAccountBinding{}
+		}
+		sort.Slice(instanceBindings, func(i, j int) bool { return instanceBindings[i].BindingID < instanceBindings[j].BindingID })
+		references.Instances = append(references.Instances, StorageAccountInstance{
+			InstanceID:              instanceID,
+			OrganizationGUID:        instance.OrganizationGUID,
+			SpaceGUID:               instance.SpaceGUID,
+			SubscriptionID:          instance.SubscriptionID,
+			ResourceGroupName:       instance.ResourceGroupName,
+			IsCreatedStorageAccount: instance.IsCreatedStorageAccount,
+			Bindings:                instanceBindings,
+		})
+	}
+	sort.Slice(references.Instances, func(i, j int) bool { return references.Instances[i].InstanceID < references.Instances[j].InstanceID })
+	return references, nil
+}
@@ -0,0 +1,462 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	// operationMigration is the operation data of an update which migrates the file shares to a new storage account
+	operationMigration = "migration"
+
+	migrationStateCreatingAccount = "creating-account"
+	migrationStateCopying         = "copying"
+	migrationStateFailed          = "failed"
+
+	// The SAS tokens which read the file shares during a run of the migration
+	migrationSASLifetime = 24 * time.Hour
+	// The error of a migration is cut so that the instance fits in the value column of the store
+	maxMigrationErrorLength = 1024
+)
+
+// MigrationParameters is the migrate_to parameter of an update. The new storage account is created in the subscription
+// and the resource group of the instance, with the SKU and the location of the instance unless they are given.
+type MigrationParameters struct {
+	StorageAccountName string `json:"storage_account_name"`
+	SkuName            string `json:"sku_name"`
+	Location           string `json:"location"`
+}
+
+// StorageAccountMigration is the copy of the file shares of an instance to a new storage account. The instance is
+// switched to the new storage account once every file is copied.
+type StorageAccountMigration struct {
+	TargetName   string    `json:"target_name"`
+	SkuName      string    `json:"sku_name"`
+	Location     string    `json:"location"`
+	State        string    `json:"state"`
+	OperationURL string    `json:"operation_url,omitempty"`
+	CopiedFiles  int       `json:"copied_files"`
+	PendingFiles int       `json:"pending_files"`
+	Error        string    `json:"error,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	// PlanID is the plan which the instance moves to with the new storage account. It keeps its plan when empty.
+	PlanID string `json:"plan_id,omitempty"`
+}
+
+// isMigrating returns true while the file shares of the instance are copied to a new storage account
+func (instance ServiceInstance) isMigrating() bool {
+	return instance.Migration != nil && instance.Migration.State != migrationStateFailed
+}
+
+// startMigration checks the migration and records it in the instance. The apps must be unbound because the files
+// which they write during the copy would be lost.
+func (b *Broker) startMigration(logger lager.Logger, instanceID string, serviceInstance *ServiceInstance, parameters *MigrationParameters, asyncAllowed bool) error {
+	logger = logger.Session("start-migration").WithData(lager.Data{"parameters": parameters})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if serviceInstance.IsPreexisting {
+		return newBrokerError(ErrCodeOperationNotSupportedForShare, "Preexisting shares cannot be migrated")
+	}
+	if !serviceInstance.IsCreatedStorageAccount {
+		return newBrokerError(ErrCodeInvalidParameters, "Only the storage accounts created by the broker can be migrated: the storage account %q may be used by other instances", serviceInstance.TargetName)
+	}
+	if !asyncAllowed {
+		return brokerapi.ErrAsyncRequired
+	}
+	if parameters.StorageAccountName == "" {
+		return newBrokerError(ErrCodeInvalidParameters, "Missing required parameters: migrate_to.storage_account_name")
+	}
+	if parameters.StorageAccountName == serviceInstance.TargetName {
+		return newBrokerError(ErrCodeInvalidParameters, "The file shares are already in the storage account %q", parameters.StorageAccountName)
+	}
+	if err := b.config.naming.StorageAccount.check("storage account", parameters.StorageAccountName, nil); err != nil {
+		return err
+	}
+
+	shares, err := b.store.RetrieveFileShares()
+	if err != nil {
+		return newStoreError(err, "Failed to retrieve the file shares")
+	}
+	bound := []string{}
+	for _, share := range shares {
+		if share.InstanceID == instanceID {
+			bound = append(bound, share.FileShareName)
+		}
+	}
+	if len(bound) > 0 {
+		sort.Strings(bound)
+		return newBrokerError(ErrCodeInvalidParameters, "The file shares %s are bound: unbind the apps before the migration", strings.Join(bound, ", "))
+	}
+
+	migration := StorageAccountMigration{
+		TargetName: parameters.StorageAccountName,
+		SkuName:    parameters.SkuName,
+		Location:   parameters.Location,
+		State:      migrationStateCreatingAccount,
+		StartedAt:  b.clock.Now().UTC(),
+	}
+	if migration.SkuName == "" {
+		migration.SkuName = serviceInstance.SkuName
+	}
+	if migration.Location == "" {
+		migration.Location = serviceInstance.Location
+	}
+	target, err := b.newMigrationTargetAccount(logger, serviceInstance, &migration)
+	if err != nil {
+		return newBrokerError(ErrCodeInvalidParameters, "Invalid migrate_to: %v", err)
+	}
+	target.SDKClient, err = NewAzureStorageAccountSDKClient(logger, &b.config.cloud, target)
+	if err != nil {
+		return err
+	}
+
+	if err := b.getLockForUpdate(target.StorageAccountName); err != nil {
+		return err
+	}
+	defer b.store.ReleaseLockForUpdate(target.StorageAccountName)
+
+	if exist, err := target.SDKClient.Exists(); err != nil {
+		return newAzureError(err, "Failed to check whether the storage account %q exists", target.StorageAccountName)
+	} else if exist {
+		return newBrokerError(ErrCodeInvalidParameters, "The storage account %q exists: the file shares are only migrated to a new storage account", target.StorageAccountName)
+	}
+	ownerID := getStorageAccountOwnerID(target.SubscriptionID, target.ResourceGroupName, target.StorageAccountName)
+	if _, err := b.claimStorageAccount(logger, ownerID, target.StorageAccountName, serviceInstance.OrganizationGUID, serviceInstance.SpaceGUID); err != nil {
+		return err
+	}
+
+	serviceInstance.Migration = &migration
+	logger.Info("migration-started", lager.Data{"migration": migration})
+	return nil
+}
+
+// newMigrationTargetAccount returns the new storage account of the migration of an instance without clients
+func (b *Broker) newMigrationTargetAccount(logger lager.Logger, serviceInstance *ServiceInstance, migration *StorageAccountMigration) (*StorageAccount, error) {
+	storageAccount, err := NewStorageAccount(
+		logger,
+		Configuration{
+			SubscriptionID:     serviceInstance.SubscriptionID,
+			ResourceGroupName:  serviceInstance.ResourceGroupName,
+			StorageAccountName: migration.TargetName,
+			UseHTTPS:           serviceInstance.UseHTTPS,
+			Location:           migration.Location,
+			SkuName:            migration.SkuName,
+			EnableEncryption:   serviceInstance.EnableEncryption,
+		})
+	if err != nil {
+		return nil, err
+	}
+	storageAccount.ServicePrincipal = serviceInstance.ServicePrincipal
+	storageAccount.Context = b.ctx
+	storageAccount.Metrics = b.metrics
+	b.setThrottle(storageAccount)
+	planID := serviceInstance.PlanID
+	if migration.PlanID != "" {
+		planID = migration.PlanID
+	}
+	if network := b.config.segments.network(planID); network != nil {
+		storageAccount.SubnetIDs = network.SubnetIDs
+	}
+	return storageAccount, nil
+}
+
+// migrationLastOperation returns the state of the migration of the instance
+func migrationLastOperation(serviceInstance ServiceInstance) brokerapi.LastOperation {
+	migration := serviceInstance.Migration
+	if migration == nil {
+		return brokerapi.LastOperation{State: brokerapi.Succeeded, Description: fmt.Sprintf("The file shares are in the storage account %q", serviceInstance.TargetName)}
+	}
+	switch migration.State {
+	case migrationStateFailed:
+		return brokerapi.LastOperation{State: brokerapi.Failed, Description: migration.Error}
+	case migrationStateCopying:
+		return brokerapi.LastOperation{State: brokerapi.InProgress, Description: fmt.Sprintf("Copying the file shares to the storage account %q: %d file(s) copied, %d pending", migration.TargetName, migration.CopiedFiles, migration.PendingFiles)}
+	}
+	return brokerapi.LastOperation{State: brokerapi.InProgress, Description: fmt.Sprintf("Creating the storage account %q", migration.TargetName)}
+}
+
+// StorageAccountMigrator returns a runner which periodically advances the migrations of the instances
+func (b *Broker) StorageAccountMigrator(interval time.Duration) ifrit.Runner {
+	return b.newPeriodicRunner("storage-account-migrator", interval, func(logger lager.Logger) {
+		if err := b.MigrateStorageAccounts(logger); err != nil {
+			logger.Error("migrate-storage-accounts", err)
+		}
+	})
+}
+
+// MigrateStorageAccounts advances the migration of every instance which is migrating. An error of Azure is retried in
+// the next run, and a failed copy fails the migration.
+func (b *Broker) MigrateStorageAccounts(logger lager.Logger) error {
+	logger = logger.Session("migrate-storage-accounts")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	serviceInstances, err := b.store.RetrieveServiceInstances()
+	if err != nil {
+		return err
+	}
+	for instanceID, serviceInstance := range serviceInstances {
+		if !serviceInstance.isMigrating() {
+			continue
+		}
+		if err := b.advanceMigration(logger, instanceID, serviceInstance.Migration.TargetName); err != nil {
+			logger.Error("advance-migration", err, lager.Data{"instanceID": instanceID})
+		}
+	}
+	return nil
+}
+
+// advanceMigration does the next step of the migration of the instance under the lock of the new storage account
+func (b *Broker) advanceMigration(logger lager.Logger, instanceID, targetName string) error {
+	logger = logger.Session("advance-migration").WithData(lager.Data{"instanceID": instanceID, "targetName": targetName})
+
+	if err := b.getLockForUpdate(targetName); err != nil {
+		return err
+	}
+	defer b.store.ReleaseLockForUpdate(targetName)
+
+	serviceInstance, err := b.store.RetrieveServiceInstance(instanceID)
+	if err == brokerapi.ErrInstanceDoesNotExist {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !serviceInstance.isMigrating() || serviceInstance.Migration.TargetName != targetName {
+		return nil
+	}
+	migration := serviceInstance.Migration
+	logger.Info("migration-state", lager.Data{"state": migration.State})
+
+	target, err := b.newMigrationTargetAccount(logger, &serviceInstance, migration)
+	if err != nil {
+		return b.failMigration(logger, instanceID, serviceInstance, err)
+	}
+	switch migration.State {
+	case migrationStateCreatingAccount:
+		restClient, err := NewAzureStorageAccountRESTClient(logger, &b.config.cloud, target)
+		if err != nil {
+			return err
+		}
+		if migration.OperationURL == "" {
+			// Creating a storage account is idempotent, so it is safe to send the request again after a failed run
+			operationURL, err := restClient.CreateStorageAccount()
+			if err != nil {
+				return b.failMigration(logger, instanceID, serviceInstance, newStorageAccountCreationError(err, target.StorageAccountName, target.Location))
+			}
+			migration.OperationURL = operationURL
+		}
+		if migration.OperationURL != "" {
+			done, err := restClient.CheckCompletion(migration.OperationURL)
+			if err != nil {
+				return b.failMigration(logger, instanceID, serviceInstance, newStorageAccountCreationError(err, target.StorageAccountName, target.Location))
+			} else if !done {
+				return b.updateMigration(instanceID, serviceInstance)
+			}
+		}
+		migration.OperationURL = ""
+		migration.State = migrationStateCopying
+		logger.Info("storage-account-created")
+		return b.updateMigration(instanceID, serviceInstance)
+	case migrationStateCopying:
+		copied, pending, err := b.copyFileShares(logger, &serviceInstance, target)
+		if err == errMigrationCopyFailed {
+			return b.failMigration(logger, instanceID, serviceInstance, err)
+		} else if err != nil {
+			return err
+		}
+		migration.CopiedFiles, migration.PendingFiles = copied, pending
+		if pending > 0 {
+			return b.updateMigration(instanceID, serviceInstance)
+		}
+		return b.switchStorageAccount(logger, instanceID, serviceInstance, target)
+	}
+	return fmt.Errorf("Unknown migration state %q", migration.State)
+}
+
+var errMigrationCopyFailed = newBrokerError(ErrCodeAzureOperationFailed, "The copy of a file to the new storage account failed or was aborted")
+
+// copyFileShares starts the copy of every file of the storage account of the instance which is not in the new storage
+// account, and counts the copied files and the files whose copy is pending
+func (b *Broker) copyFileShares(logger lager.Logger, serviceInstance *ServiceInstance, target *StorageAccount) (int, int, error) {
+	source, err := b.newStorageAccountWithSDKClient(logger, serviceInstance)
+	if err != nil {
+		return 0, 0, err
+	}
+	sourceREST, err := NewAzureStorageAccountRESTClient(logger, &b.config.cloud, source)
+	if err != nil {
+		return 0, 0, err
+	}
+	if target.SDKClient, err = NewAzureStorageAccountSDKClient(logger, &b.config.cloud, target); err != nil {
+		return 0, 0, err
+	}
+	targetREST, err := NewAzureStorageAccountRESTClient(logger, &b.config.cloud, target)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	shares, err := source.SDKClient.ListFileShares()
+	if err != nil {
+		return 0, 0, newAzureError(err, "Failed to list the file shares of the storage account %q", source.StorageAccountName)
+	}
+	shareNames := []string{}
+	for shareName := range shares {
+		shareNames = append(shareNames, shareName)
+	}
+	sort.Strings(shareNames)
+
+	copied, pending := 0, 0
+	expiry := b.clock.Now().Add(migrationSASLifetime)
+	for _, shareName := range shareNames {
+		if exist, err := target.SDKClient.HasFileShare(shareName); err != nil {
+			return 0, 0, err
+		} else if !exist {
+			if err := target.SDKClient.CreateFileShare(shareName); err != nil {
+				return 0, 0, newAzureError(err, "Failed to create file share %q in the storage account %q", shareName, target.StorageAccountName)
+			}
+		}
+		sourceSAS, err := sourceREST.GetFileShareReadSAS(shareName, expiry)
+		if err != nil {
+			return 0, 0, err
+		}
+		targetSAS, err := targetREST.GetFileShareReadSAS(shareName, expiry)
+		if err != nil {
+			return 0, 0, err
+		}
+		sourceShareURL, err := source.SDKClient.GetShareURL(shareName)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		directories, files, err := source.SDKClient.ListFilesAndDirectories(shareName)
+		if err != nil {
+			return 0, 0, newAzureError(err, "Failed to list the files of the file share %q", shareName)
+		}
+		if err := target.SDKClient.CreateDirectories(shareName, directories); err != nil {
+			return 0, 0, err
+		}
+		for _, filePath := range files {
+			status, err := target.SDKClient.GetFileCopyStatus(shareName, filePath, targetSAS)
+			if err != nil {
+				return 0, 0, err
+			}
+			switch status {
+			case "":
+				sourceURL := fmt.Sprintf("https:%s/%s?%s", sourceShareURL, escapeFilePath(filePath), strings.TrimPrefix(sourceSAS, "?"))
+				if err := target.SDKClient.CopyFile(shareName, filePath, sourceURL); err != nil {
+					return 0, 0, newAzureError(err, "Failed to copy the file %q of the file share %q", filePath, shareName)
+				}
+				pending++
+			case "pending":
+				pending++
+			case "success":
+				copied++
+			default:
+				logger.Error("copy-file", errMigrationCopyFailed, lager.Data{"fileShareName": shareName, "path": filePath, "status": status})
+				return 0, 0, errMigrationCopyFailed
+			}
+		}
+	}
+	logger.Info("file-shares-copied", lager.Data{"shares": len(shareNames), "copied": copied, "pending": pending})
+	return copied, pending, nil
+}
+
+// switchStorageAccount makes the new storage account the storage account of the instance and deletes the old one
+func (b *Broker) switchStorageAccount(logger lager.Logger, instanceID string, serviceInstance ServiceInstance, target *StorageAccount) error {
+	previous := serviceInstance
+	migration := serviceInstance.Migration
+
+	if err := target.SDKClient.SetStorageAccountTags(serviceInstance.Metadata.tagChanges(InstanceMetadata{})); err != nil {
+		return newAzureError(err, "Failed to tag the storage account %q", target.StorageAccountName)
+	}
+	serviceInstance.TargetName = migration.TargetName
+	serviceInstance.SkuName = migration.SkuName
+	serviceInstance.Location = migration.Location
+	serviceInstance.IsCreatedStorageAccount = true
+	if migration.PlanID != "" {
+		serviceInstance.PlanID = migration.PlanID
+	}
+	serviceInstance.MetricAlerts = nil
+	serviceInstance.AccessLogDestination = nil
+	serviceInstance.Migration = nil
+	b.createStorageAccountAlerts(logger, &serviceInstance)
+	b.createStorageAccountAccessLogs(logger, &serviceInstance)
+	if err := b.store.UpdateServiceInstance(instanceID, serviceInstance); err != nil {
+		return newStoreError(err, "Failed to update instance details %q", instanceID)
+	}
+	logger.Info("storage-account-switched", lager.Data{"previous": previous.TargetName, "current": serviceInstance.TargetName})
+
+	// The instance uses the new storage account from now on, so a failure to delete the old one is only logged
+	b.deleteStorageAccountAlerts(logger, &previous)
+	b.deleteStorageAccountAccessLogs(logger, &previous)
+	previous.Migration = nil
+	if err := b.deletePreviousStorageAccount(logger, previous); err != nil {
+		logger.Error("delete-previous-storage-account", err)
+	}
+	return nil
+}
+
+// deletePreviousStorageAccount deletes the storage account which an instance used before its migration, or keeps it
+// for the retention period
+func (b *Broker) deletePreviousStorageAccount(logger lager.Logger, previous ServiceInstance) error {
+	if !b.controlConfig(logger).AllowDeleteStorageAccount {
+		return nil
+	}
+	storageAccount, err := b.newStorageAccountWithSDKClient(logger, &previous)
+	if err != nil {
+		return err
+	}
+	if b.config.cloud.Control.DeletionRetentionPeriod > 0 {
+		return b.scheduleStorageAccountDeletion(logger, storageAccount, previous)
+	}
+	if err := storageAccount.SDKClient.DeleteStorageAccount(); err != nil {
+		return newAzureError(err, "Failed to delete the storage account %q under the resource group %q in the subscription %q", previous.TargetName, previous.ResourceGroupName, previous.SubscriptionID)
+	}
+	b.notifyDeletion(logger, deletionInitiatorMigrator, "", &previous, "")
+	ownerID := getStorageAccountOwnerID(previous.SubscriptionID, previous.ResourceGroupName, previous.TargetName)
+	if err := b.store.DeleteStorageAccountOwner(ownerID); err != nil {
+		logger.Error("delete-storage-account-owner", err)
+	}
+	return nil
+}
+
+// failMigration records the error of the migration and deletes the new storage account. The instance keeps its
+// storage account, so it is usable and the migration can be started again.
+func (b *Broker) failMigration(logger lager.Logger, instanceID string, serviceInstance ServiceInstance, cause error) error {
+	migration := serviceInstance.Migration
+	logger.Error("migration-failed", cause)
+
+	if target, err := b.newMigrationTargetAccount(logger, &serviceInstance, migration); err == nil {
+		if target.SDKClient, err = NewAzureStorageAccountSDKClient(logger, &b.config.cloud, target); err == nil {
+			err = target.SDKClient.DeleteStorageAccount()
+		}
+		if err != nil {
+			logger.Error("delete-target-storage-account", err)
+		}
+		ownerID := getStorageAccountOwnerID(target.SubscriptionID, target.ResourceGroupName, target.StorageAccountName)
+		if err := b.store.DeleteStorageAccountOwner(ownerID); err != nil && err != brokerapi.ErrInstanceDoesNotExist {
+			logger.Error("delete-storage-account-owner", err)
+		}
+	}
+
+	migration.State = migrationStateFailed
+	migration.OperationURL = ""
+	migration.Error = fmt.Sprintf("Failed to migrate the file shares to the storage account %q: %v", migration.TargetName, cause)
+	if len(migration.Error) > maxMigrationErrorLength {
+		migration.Error = migration.Error[:maxMigrationErrorLength]
+	}
+	return b.updateMigration(instanceID, serviceInstance)
+}
+
+func (b *Broker) updateMigration(instanceID string, serviceInstance ServiceInstance) error {
+	if err := b.store.UpdateServiceInstance(instanceID, serviceInstance); err != nil {
+		return newStoreError(err, "Failed to update instance details %q", instanceID)
+	}
+	return nil
+}
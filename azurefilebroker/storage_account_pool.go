@@ -0,0 +1,403 @@
+package azurefilebroker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+)
+
+const (
+	storageAccountPoolLockID     = "storage-account-pool"
+	storageAccountPoolNamePrefix = "afbpool"
+	// A creation which neither finishes nor fails within the timeout is given up so that its slot in the pool is
+	// refilled
+	pooledStorageAccountCreationTimeout = time.Hour
+
+	pooledStorageAccountStateCreating = "creating"
+	pooledStorageAccountStateReady    = "ready"
+)
+
+// PooledStorageAccount is a storage account which the broker created in advance for a plan and a location. It is
+// handed out to the first provision of the plan in the location which does not name its storage account.
+type PooledStorageAccount struct {
+	SubscriptionID     string    `json:"subscription_id"`
+	ResourceGroupName  string    `json:"resource_group_name"`
+	StorageAccountName string    `json:"storage_account_name"`
+	Location           string    `json:"location"`
+	SkuName            string    `json:"sku_name"`
+	PlanID             string    `json:"plan_id"`
+	State              string    `json:"state"`
+	OperationURL       string    `json:"operation_url"`
+	LastError          string    `json:"last_error"`
+	CreatedAt          time.Time `json:"created_at"`
+	DatabaseVersion    string    `json:"database_version"`
+}
+
+// StoragePoolEntry is the number of storage accounts which are kept ready for a plan in a location
+type StoragePoolEntry struct {
+	PlanName string
+	Location string
+	Size     int
+}
+
+// StoragePoolConfig is the storage accounts which are created in advance in the default subscription and resource
+// group. The pool is disabled when it has no entry.
+type StoragePoolConfig struct {
+	Entries []StoragePoolEntry
+
+	invalidEntries []string
+}
+
+// NewStoragePoolConfig parses a comma separated list of plans, locations and the numbers of storage accounts:
+//
+//	<plan name>:<location>=<size>
+func NewStoragePoolConfig(pool string) *StoragePoolConfig {
+	myConf := new(StoragePoolConfig)
+
+	myConf.Entries = make([]StoragePoolEntry, 0)
+	for _, entry := range strings.Split(pool, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if poolEntry, ok := parseStoragePoolEntry(entry); ok {
+			myConf.Entries = append(myConf.Entries, poolEntry)
+		} else {
+			myConf.invalidEntries = append(myConf.invalidEntries, entry)
+		}
+	}
+
+	return myConf
+}
+
+func parseStoragePoolEntry(entry string) (StoragePoolEntry, bool) {
+	pair := strings.SplitN(entry, "=", 2)
+	if len(pair) != 2 {
+		return StoragePoolEntry{}, false
+	}
+	target := strings.SplitN(pair[0], ":", 2)
+	if len(target) != 2 {
+		return StoragePoolEntry{}, false
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(pair[1]))
+	if err != nil || size <= 0 {
+		return StoragePoolEntry{}, false
+	}
+	poolEntry := StoragePoolEntry{
+		PlanName: strings.TrimSpace(target[0]),
+		Location: strings.TrimSpace(target[1]),
+		Size:     size,
+	}
+	if poolEntry.PlanName == "" || poolEntry.Location == "" {
+		return StoragePoolEntry{}, false
+	}
+	return poolEntry, true
+}
+
+// Validate checks that the plans are AzureFileShare plans of the catalog and that the accounts can be created in the
+// default subscription and resource group
+func (config *StoragePoolConfig) Validate(segments *IsolationSegmentConfig, catalog *CatalogConfig, azure *AzureConfig) error {
+	if len(config.invalidEntries) > 0 {
+		return fmt.Errorf("Invalid entries in storageAccountPool: %s. Expected <plan name>:<location>=<size> with a positive size", strings.Join(config.invalidEntries, ", "))
+	}
+	if len(config.Entries) == 0 {
+		return nil
+	}
+	if !azure.IsSupportAzureFileShare() || azure.DefaultSubscriptionID == "" || azure.DefaultResourceGroupName == "" {
+		return fmt.Errorf("storageAccountPool requires AzureFileShare, defaultSubscriptionID and defaultResourceGroupName")
+	}
+	planNames := optionPlanNames(segments, catalog, false)
+
+	targets := map[string]bool{}
+	for _, entry := range config.Entries {
+		if !inArray(planNames, entry.PlanName) {
+			return fmt.Errorf("Unknown plan %q in storageAccountPool: expected one of %s", entry.PlanName, strings.Join(planNames, ", "))
+		}
+		target := entry.PlanName + ":" + entry.Location
+		if targets[target] {
+			return fmt.Errorf("The plan %q and the location %q are given more than once in storageAccountPool", entry.PlanName, entry.Location)
+		}
+		targets[target] = true
+	}
+	return nil
+}
+
+// planID returns the ID of the plan in the catalog
+func (b *Broker) planID(planName string) string {
+	for _, plan := range b.config.catalog.Service.Plans {
+		if plan.Name == planName {
+			return plan.ID
+		}
+	}
+	if planName == "AzureFileShare" {
+		return planIDAzureFileShare
+	}
+	for _, network := range b.config.segments.Networks {
+		if network.PlanName() == planName {
+			return network.PlanID()
+		}
+	}
+	return ""
+}
+
+// newPooledStorageAccountName returns a random name which is valid for a storage account
+func newPooledStorageAccountName() (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return storageAccountPoolNamePrefix + hex.EncodeToString(suffix), nil
+}
+
+// newPooledStorageAccount returns the storage account of a pooled storage account with the settings of the broker
+func (b *Broker) newPooledStorageAccount(logger lager.Logger, account PooledStorageAccount) (*StorageAccount, error) {
+	storageAccount, err := NewStorageAccount(logger, Configuration{
+		SubscriptionID:     account.SubscriptionID,
+		ResourceGroupName:  account.ResourceGroupName,
+		StorageAccountName: account.StorageAccountName,
+		Location:           account.Location,
+		SkuName:            account.SkuName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	storageAccount.Context = b.ctx
+	storageAccount.Metrics = b.metrics
+	b.setThrottle(storageAccount)
+	if network := b.config.segments.network(account.PlanID); network != nil {
+		storageAccount.SubnetIDs = network.SubnetIDs
+	}
+	return storageAccount, nil
+}
+
+// StorageAccountPoolReplenisher returns a runner which periodically refills the storage account pool
+func (b *Broker) StorageAccountPoolReplenisher(interval time.Duration) ifrit.Runner {
+	return b.newPeriodicRunner("storage-account-pool-replenisher", interval, b.ReplenishStorageAccountPool)
+}
+
+// ReplenishStorageAccountPool checks the creations of the pooled storage accounts and starts the creation of the
+// accounts which are missing from the pool
+func (b *Broker) ReplenishStorageAccountPool(logger lager.Logger) {
+	logger = logger.Session("replenish-storage-account-pool")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if len(b.config.pool.Entries) == 0 {
+		return
+	}
+	if !b.controlConfig(logger).AllowCreateStorageAccount {
+		logger.Info("creation-not-allowed")
+		return
+	}
+
+	if err := b.getLockForUpdate(storageAccountPoolLockID); err != nil {
+		logger.Error("get-lock-for-update", err)
+		return
+	}
+	defer b.store.ReleaseLockForUpdate(storageAccountPoolLockID)
+
+	accounts, err := b.store.RetrievePooledStorageAccounts()
+	if err != nil {
+		logger.Error("retrieve-pooled-storage-accounts", err)
+		return
+	}
+
+	names := []string{}
+	for name := range accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	counts := map[string]int{}
+	for _, name := range names {
+		account := accounts[name]
+		if account.State == pooledStorageAccountStateCreating && !b.checkPooledStorageAccount(logger, &account) {
+			continue
+		}
+		counts[account.PlanID+":"+account.Location]++
+	}
+
+	for _, entry := range b.config.pool.Entries {
+		planID := b.planID(entry.PlanName)
+		for i := counts[planID+":"+entry.Location]; i < entry.Size; i++ {
+			if err := b.createPooledStorageAccount(logger, planID, entry.Location); err != nil {
+				logger.Error("create-pooled-storage-account", err, lager.Data{"plan": entry.PlanName, "location": entry.Location})
+				break
+			}
+		}
+	}
+}
+
+// createPooledStorageAccount stores the account in the pool before its creation is requested, so that an interrupted
+// creation is sent again instead of leaking the account
+func (b *Broker) createPooledStorageAccount(logger lager.Logger, planID, location string) error {
+	name, err := newPooledStorageAccountName()
+	if err != nil {
+		return err
+	}
+	target := b.planTarget(planID)
+	account := PooledStorageAccount{
+		SubscriptionID:     target.SubscriptionID,
+		ResourceGroupName:  target.ResourceGroupName,
+		StorageAccountName: name,
+		Location:           location,
+		PlanID:             planID,
+		State:              pooledStorageAccountStateCreating,
+		CreatedAt:          b.clock.Now().UTC(),
+		DatabaseVersion:    databaseVersion,
+	}
+	storageAccount, err := b.newPooledStorageAccount(logger, account)
+	if err != nil {
+		return err
+	}
+	account.SkuName = string(storageAccount.SkuName)
+	if err := b.store.CreatePooledStorageAccount(name, account); err != nil {
+		return newStoreError(err, "Failed to insert the pooled storage account %q into the store", name)
+	}
+	logger.Info("pooled-storage-account-created", lager.Data{"StorageAccountName": name, "location": location, "planID": planID})
+	b.checkPooledStorageAccount(logger, &account)
+	return nil
+}
+
+// checkPooledStorageAccount requests the creation of the account again when it has no operation or polls its
+// operation. It updates the account in the store and returns false if the account was removed from the pool.
+func (b *Broker) checkPooledStorageAccount(logger lager.Logger, account *PooledStorageAccount) bool {
+	logger = logger.WithData(lager.Data{"StorageAccountName": account.StorageAccountName})
+
+	err := b.advancePooledStorageAccount(logger, account)
+	if err == nil {
+		if err := b.store.UpdatePooledStorageAccount(account.StorageAccountName, *account); err != nil {
+			logger.Error("update-pooled-storage-account", err)
+		}
+		return true
+	}
+	logger.Error("advance-pooled-storage-account", err)
+	if b.clock.Since(account.CreatedAt) < pooledStorageAccountCreationTimeout {
+		account.LastError = err.Error()
+		if err := b.store.UpdatePooledStorageAccount(account.StorageAccountName, *account); err != nil {
+			logger.Error("update-pooled-storage-account", err)
+		}
+		return true
+	}
+
+	logger.Info("pooled-storage-account-creation-timeout", lager.Data{"createdAt": account.CreatedAt})
+	b.deletePooledStorageAccount(logger, *account)
+	if err := b.store.DeletePooledStorageAccount(account.StorageAccountName); err != nil {
+		logger.Error("delete-pooled-storage-account", err)
+	}
+	return false
+}
+
+func (b *Broker) advancePooledStorageAccount(logger lager.Logger, account *PooledStorageAccount) error {
+	storageAccount, err := b.newPooledStorageAccount(logger, *account)
+	if err != nil {
+		return err
+	}
+	restClient, err := NewAzureStorageAccountRESTClient(logger, &b.config.cloud, storageAccount)
+	if err != nil {
+		return err
+	}
+
+	if account.OperationURL == "" {
+		// Creating a storage account is idempotent, so it is safe to send the request again
+		operationURL, err := restClient.CreateStorageAccount()
+		if err != nil {
+			return newStorageAccountCreationError(err, account.StorageAccountName, account.Location)
+		}
+		account.OperationURL = operationURL
+		if operationURL == "" {
+			account.State = pooledStorageAccountStateReady
+			return nil
+		}
+	}
+
+	done, err := restClient.CheckCompletion(account.OperationURL)
+	if err != nil {
+		return newStorageAccountCreationError(err, account.StorageAccountName, account.Location)
+	}
+	if done {
+		account.State = pooledStorageAccountStateReady
+		account.LastError = ""
+		logger.Info("pooled-storage-account-ready")
+	}
+	return nil
+}
+
+// deletePooledStorageAccount deletes an account whose creation was given up. A failure is only logged because the
+// account has not been handed out and can be deleted by the administrator.
+func (b *Broker) deletePooledStorageAccount(logger lager.Logger, account PooledStorageAccount) {
+	storageAccount, err := b.newPooledStorageAccount(logger, account)
+	if err != nil {
+		logger.Error("new-storage-account", err)
+		return
+	}
+	restClient, err := NewAzureStorageAccountRESTClient(logger, &b.config.cloud, storageAccount)
+	if err != nil {
+		logger.Error("new-rest-client", err)
+		return
+	}
+	if _, err := restClient.DeleteStorageAccount(); err != nil {
+		logger.Error("delete-storage-account", err)
+	}
+}
+
+// claimPooledStorageAccount removes a ready account of the plan from the pool and names it in the configuration when
+// the provision asks for a storage account with the settings of the pool. It returns nil when no account matches.
+func (b *Broker) claimPooledStorageAccount(logger lager.Logger, planID string, configuration *Configuration) (*PooledStorageAccount, error) {
+	if len(b.config.pool.Entries) == 0 || configuration.StorageAccountName != "" || configuration.SkuName != "" || configuration.EnableEncryption != "" ||
+		configuration.TenantID != "" || configuration.ClientID != "" || configuration.CredHubRef != "" {
+		return nil, nil
+	}
+
+	if err := b.getLockForUpdate(storageAccountPoolLockID); err != nil {
+		logger.Error("get-lock-for-update", err)
+		return nil, err
+	}
+	defer b.store.ReleaseLockForUpdate(storageAccountPoolLockID)
+
+	accounts, err := b.store.RetrievePooledStorageAccounts()
+	if err != nil {
+		logger.Error("retrieve-pooled-storage-accounts", err)
+		return nil, newStoreError(err, "Failed to retrieve the pooled storage accounts")
+	}
+
+	names := []string{}
+	for name := range accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		account := accounts[name]
+		if account.State != pooledStorageAccountStateReady || account.PlanID != planID || account.Location != configuration.Location ||
+			account.SubscriptionID != configuration.SubscriptionID || account.ResourceGroupName != configuration.ResourceGroupName {
+			continue
+		}
+		if err := b.store.DeletePooledStorageAccount(name); err != nil {
+			logger.Error("delete-pooled-storage-account", err)
+			return nil, newStoreError(err, "Failed to delete the pooled storage account %q from the store", name)
+		}
+		logger.Info("pooled-storage-account-claimed", lager.Data{"StorageAccountName": name})
+		configuration.StorageAccountName = name
+		return &account, nil
+	}
+	logger.Info("no-pooled-storage-account", lager.Data{"planID": planID, "location": configuration.Location})
+	return nil, nil
+}
+
+// returnPooledStorageAccount puts a claimed account back into the pool when the provision fails
+func (b *Broker) returnPooledStorageAccount(logger lager.Logger, account PooledStorageAccount) {
+	if err := b.getLockForUpdate(storageAccountPoolLockID); err != nil {
+		logger.Error("get-lock-for-update", err)
+		return
+	}
+	defer b.store.ReleaseLockForUpdate(storageAccountPoolLockID)
+
+	if err := b.store.CreatePooledStorageAccount(account.StorageAccountName, account); err != nil {
+		logger.Error("rollback-pooled-storage-account", err)
+	}
+}
@@ -0,0 +1,76 @@
+package azurefilebroker
+
+import (
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/Azure/azure-sdk-for-go/arm/storage"
+)
+
+// updatesStorageAccount returns true if the parameters change the properties of the storage account of the instance
+func (parameters UpdateParameters) updatesStorageAccount() bool {
+	return parameters.UseHTTPS != nil || parameters.SkuName != nil
+}
+
+// updateStorageAccount applies use_https and sku_name of the update parameters to the storage account of the instance
+// and records them in the instance. Only the storage accounts which the broker created are changed because the others
+// may be shared with other instances. Azure does not change a storage account to another kind or in or out of the
+// availability zones, which needs a migration of its file shares with migrate_to.
+func (b *Broker) updateStorageAccount(logger lager.Logger, serviceInstance *ServiceInstance, parameters UpdateParameters) error {
+	logger = logger.Session("update-storage-account").WithData(lager.Data{"storageAccountName": serviceInstance.TargetName})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if serviceInstance.IsPreexisting || !serviceInstance.IsCreatedStorageAccount {
+		return newBrokerError(ErrCodeInvalidParameters, "The parameters use_https and sku_name can only be updated for the storage accounts which the broker created")
+	}
+	if parameters.MigrateTo != nil {
+		return newBrokerError(ErrCodeInvalidParameters, "The parameters use_https and sku_name cannot be updated with migrate_to, which takes them for the new storage account")
+	}
+
+	currentSkuName := storage.SkuName(serviceInstance.SkuName)
+	skuName := currentSkuName
+	if parameters.SkuName != nil {
+		skuName = storage.SkuName(*parameters.SkuName)
+		if !isSupportedSkuName(skuName) {
+			names := []string{}
+			for _, name := range supportedSkuNames {
+				names = append(names, string(name))
+			}
+			return newBrokerError(ErrCodeInvalidParameters, "The SkuName %q is invalid. It must be one of %s", skuName, strings.Join(names, ", "))
+		}
+		if storageAccountKind(skuName) != storageAccountKind(currentSkuName) || isZoneRedundantSku(skuName) != isZoneRedundantSku(currentSkuName) {
+			return newBrokerError(ErrCodeInvalidParameters, "The SKU of the storage account %q cannot change from %s to %s in place. Use migrate_to to move its file shares to a new storage account", serviceInstance.TargetName, currentSkuName, skuName)
+		}
+	}
+	useHTTPS, _ := strconv.ParseBool(serviceInstance.UseHTTPS)
+	if parameters.UseHTTPS != nil {
+		useHTTPS = *parameters.UseHTTPS
+	}
+	if skuName == currentSkuName && strconv.FormatBool(useHTTPS) == serviceInstance.UseHTTPS {
+		return nil
+	}
+
+	restClient, err := b.newRESTClientOfServiceInstance(logger, serviceInstance)
+	if err != nil {
+		return err
+	}
+	if err := restClient.UpdateStorageAccount(skuName, useHTTPS); err != nil {
+		logger.Error("update", err)
+		return newAzureError(err, "Failed to update the storage account %q", serviceInstance.TargetName)
+	}
+	serviceInstance.SkuName = string(skuName)
+	serviceInstance.UseHTTPS = strconv.FormatBool(useHTTPS)
+	logger.Info("storage-account-updated", lager.Data{"skuName": skuName, "useHTTPS": useHTTPS})
+	return nil
+}
+
+func isSupportedSkuName(skuName storage.SkuName) bool {
+	for _, name := range supportedSkuNames {
+		if name == skuName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateFileEndpointSuffixes checks that the suffixes of the file endpoints are domains without a scheme or a path
+func (config *AzureConfig) validateFileEndpointSuffixes() error {
+	suffixes := []struct {
+		name   string
+		suffix string
+	}{
+		{"fileEndpointSuffix", config.FileEndpointSuffix},
+		{"mountFileEndpointSuffix", config.MountFileEndpointSuffix},
+	}
+	for _, s := range suffixes {
+		if s.suffix == "" {
+			continue
+		}
+		if strings.ContainsAny(s.suffix, "/:?# ") || strings.HasPrefix(s.suffix, ".") || strings.HasSuffix(s.suffix, ".") {
+			return fmt.Errorf("Invalid %s %q: expected a domain such as local.azurestack.external", s.name, s.suffix)
+		}
+	}
+	return nil
+}
+
+// GetFileEndpointSuffix returns the suffix of the file endpoints which the broker calls, e.g. core.windows.net. The
+// suffix of the config replaces the one in the endpoints which Azure Resource Manager reports for a storage account.
+func (config *AzureConfig) GetFileEndpointSuffix(reportedSuffix string) string {
+	if config.FileEndpointSuffix != "" {
+		return config.FileEndpointSuffix
+	}
+	return reportedSuffix
+}
+
+// GetShareURL returns the URL of the file share which the apps mount. On AzureStack the Diego cells may reach the
+// file endpoints by another domain than the broker, so the mount suffix of the config replaces the suffix of the
+// storage account.
+func (config *AzureConfig) GetShareURL(storageAccount *StorageAccount, fileShareName string) string {
+	suffix := storageAccount.BaseURL
+	if config.MountFileEndpointSuffix != "" {
+		suffix = config.MountFileEndpointSuffix
+	}
+	return fmt.Sprintf("//%s.file.%s/%s", storageAccount.StorageAccountName, suffix, fileShareName)
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/md5"
 	"fmt"
+	"sort"
+	"strings"
 
 	"database/sql"
 
@@ -14,82 +16,236 @@ import (
 )
 
 //go:generate counterfeiter -o ../azurefilebrokerfakes/fake_store.go . Store
+
+// Store is the state of the broker and the locks which serialize its updates. They are in the same database unless
+// NewCompositeStore takes them from separate backends. The secrets of the records are kept out of the store by a
+// SecretStore if the broker has one.
 type Store interface {
+	StateStore
+	LockProvider
+}
+
+// StateStore keeps the records of the broker: the service instances, the bindings, the file shares and their owners
+// and the bookkeeping of the background jobs
+type StateStore interface {
 	RetrieveServiceInstance(id string) (ServiceInstance, error)
-	RetrieveBindingDetails(id string) (brokerapi.BindDetails, error)
+	RetrieveServiceInstances() (map[string]ServiceInstance, error)
+	RetrieveServiceInstancesByTargetName(targetName string) (map[string]ServiceInstance, error)
+	RetrieveBindingDetails(id string) (BindingDetails, error)
+	RetrieveAllBindingDetails() (map[string]BindingDetails, error)
 	RetrieveFileShare(id string) (FileShare, error)
+	RetrieveFileShares() (map[string]FileShare, error)
+	RetrieveStorageAccountOwner(id string) (StorageAccountOwner, error)
+	RetrieveFileShareOwner(id string) (FileShareOwner, error)
+	RetrieveScheduledDeletion(id string) (ScheduledDeletion, error)
+	RetrieveScheduledDeletions() (map[string]ScheduledDeletion, error)
+	RetrieveFeatureFlags() (map[string]FeatureFlag, error)
+	RetrievePendingShareDeletions() (map[string]PendingShareDeletion, error)
+	RetrievePooledStorageAccounts() (map[string]PooledStorageAccount, error)
+	RetrieveInstanceOperations(instanceID string) ([]InstanceOperation, error)
+	RetrieveLockHolder(id string) (LockHolder, error)
+	RetrieveLockHolders() (map[string]LockHolder, error)
 
 	CreateServiceInstance(id string, instance ServiceInstance) error
-	CreateBindingDetails(id string, details brokerapi.BindDetails, redactRawParameter bool) error
+	CreateBindingDetails(id string, details BindingDetails) error
 	CreateFileShare(id string, share FileShare) error
+	CreateStorageAccountOwner(id string, owner StorageAccountOwner) error
+	CreateFileShareOwner(id string, owner FileShareOwner) error
+	CreateScheduledDeletion(id string, deletion ScheduledDeletion) error
+	CreateFeatureFlag(id string, flag FeatureFlag) error
+	CreatePendingShareDeletion(id string, deletion PendingShareDeletion) error
+	CreatePooledStorageAccount(id string, account PooledStorageAccount) error
+	CreateInstanceOperation(id string, operation InstanceOperation) error
+	CreateLockHolder(id string, holder LockHolder) error
 
+	UpdateServiceInstance(id string, instance ServiceInstance) error
 	UpdateFileShare(id string, share FileShare) error
+	UpdateFileShareOwner(id string, owner FileShareOwner) error
+	UpdateFeatureFlag(id string, flag FeatureFlag) error
+	UpdatePendingShareDeletion(id string, deletion PendingShareDeletion) error
+	UpdatePooledStorageAccount(id string, account PooledStorageAccount) error
+	UpdateLockHolder(id string, holder LockHolder) error
 
 	DeleteServiceInstance(id string) error
 	DeleteBindingDetails(id string) error
 	DeleteFileShare(id string) error
-
-	GetLockForUpdate(lockName string, timeoutInSeconds int) error
-	ReleaseLockForUpdate(lockName string) error
+	DeleteStorageAccountOwner(id string) error
+	DeleteFileShareOwner(id string) error
+	DeleteScheduledDeletion(id string) error
+	DeleteFeatureFlag(id string) error
+	DeletePendingShareDeletion(id string) error
+	DeletePooledStorageAccount(id string) error
 }
 
 type SqlStore struct {
 	StoreType string
 	Database  SqlConnection
+
+	// encryption is nil unless the records are encrypted at rest, see SetEncryption
+	encryption *storeCipher
+}
+
+// NewStore returns the SQL store of the driver. The schema of the tables is only supported by mssql. The credentials
+// are read from the source, if it is not nil, instead of dbUsername and dbPassword whenever the store connects. The
+// tables are not created here, EnsureSchema must be called before the broker serves requests.
+func NewStore(logger lager.Logger, dbDriver, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbSchema, dbCACert, hostNameInCertificate string, credentials DBCredentialsSource) *SqlStore {
+	logger = logger.Session("sql-store")
+	storeType, toDatabase := newSqlVariant(logger, dbDriver, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbSchema, dbCACert, hostNameInCertificate)
+	database := newSqlConnectionOfSource(logger, toDatabase, credentials)
+	if err := database.Connect(); err != nil {
+		logger.Fatal("sql-connect-to-database", err)
+	}
+	return &SqlStore{
+		StoreType: storeType,
+		Database:  database,
+	}
 }
 
-func NewStore(logger lager.Logger, dbDriver, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbCACert, hostNameInCertificate string) Store {
+// NewReadReplicaStore returns the SQL store of a read replica of the database of NewStore. The tables are created
+// by NewStore on the primary, so they are not initialized on the replica, which rejects the writes.
+func NewReadReplicaStore(logger lager.Logger, dbDriver, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbSchema, dbCACert, hostNameInCertificate string, credentials DBCredentialsSource) *SqlStore {
+	logger = logger.Session("sql-read-replica-store")
+	storeType, toDatabase := newSqlVariant(logger, dbDriver, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbSchema, dbCACert, hostNameInCertificate)
+	database := newSqlConnectionOfSource(logger, toDatabase, credentials)
+	if err := database.Connect(); err != nil {
+		logger.Fatal("sql-connect-to-database", err)
+	}
+	return &SqlStore{
+		StoreType: storeType,
+		Database:  database,
+	}
+}
+
+func newSqlVariant(logger lager.Logger, dbDriver, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbSchema, dbCACert, hostNameInCertificate string) (string, SqlVariant) {
 	var toDatabase SqlVariant
 	var storeType string
-	logger = logger.Session("sql-store")
 
 	switch dbDriver {
 	case "mssql":
+		if dbSchema != "" {
+			if err := validateSQLIdentifier("dbSchema", dbSchema); err != nil {
+				logger.Fatal("db-schema-invalid", err)
+			}
+		}
 		storeType = "mssql"
-		toDatabase = NewMSSqlVariant(logger, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbCACert, hostNameInCertificate)
+		toDatabase = NewMSSqlVariant(logger, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbSchema, dbCACert, hostNameInCertificate)
 	case "mysql":
+		if dbSchema != "" {
+			logger.Fatal("db-schema-unsupported", fmt.Errorf("dbSchema is not supported by mysql. The tables are in the database %q", dbName))
+		}
 		storeType = "mysql"
 		toDatabase = NewMySqlVariant(logger, dbUsername, dbPassword, dbHostname, dbPort, dbName, dbCACert, hostNameInCertificate)
 	default:
 		logger.Fatal("db-driver-unrecognized", fmt.Errorf("Unrecognized Driver: %s", dbDriver))
 	}
-	store, err := NewStoreWithVariant(logger, storeType, toDatabase)
-	if err != nil {
-		logger.Fatal("new-store-with-variant", err)
+	return storeType, toDatabase
+}
+
+func newSqlConnectionOfSource(logger lager.Logger, toDatabase SqlVariant, credentials DBCredentialsSource) SqlConnection {
+	if credentials == nil {
+		return NewSqlConnection(toDatabase)
 	}
-	return store
+	return NewSqlConnectionWithCredentials(logger, toDatabase, credentials)
 }
 
 func NewStoreWithVariant(logger lager.Logger, storeType string, toDatabase SqlVariant) (Store, error) {
-	database := NewSqlConnection(toDatabase)
-	err := initialize(logger, database)
-	if err != nil {
-		logger.Error("sql-failed-to-initialize-database", err)
+	return newStoreWithConnection(logger, storeType, NewSqlConnection(toDatabase))
+}
+
+func newStoreWithConnection(logger lager.Logger, storeType string, database SqlConnection) (Store, error) {
+	if err := database.Connect(); err != nil {
+		logger.Error("sql-connect-to-database", err)
 		return nil, err
 	}
 
-	return &SqlStore{
+	store := &SqlStore{
 		StoreType: storeType,
 		Database:  database,
-	}, nil
+	}
+	if err := store.EnsureSchema(logger, false); err != nil {
+		logger.Error("sql-failed-to-initialize-database", err)
+		return nil, err
+	}
+	return store, nil
 }
 
-func initialize(logger lager.Logger, db SqlConnection) error {
-	logger = logger.Session("initialize-database")
+// EnsureSchema creates the tables and the procedures of the store which do not exist. When skipCreation is true, e.g.
+// because the tables are created by the administrators of the database, nothing is created and the schema is only
+// probed: every table and procedure must exist and the user of the broker must be allowed to use it. The administrators
+// then also widen the value columns of the tables of an older broker, see sqlDialect.widenValueColumnSQL.
+func (s *SqlStore) EnsureSchema(logger lager.Logger, skipCreation bool) error {
+	logger = logger.Session("ensure-schema").WithData(lager.Data{"storeType": s.StoreType, "skipCreation": skipCreation})
 	logger.Info("start")
 	defer logger.Info("end")
 
-	var err error
-	err = db.Connect()
-	if err != nil {
-		logger.Error("sql-connect-to-database", err)
-		return err
+	if skipCreation {
+		return s.probeSchema(logger)
 	}
 
-	for _, query := range db.GetInitializeDatabaseSQL() {
-		if _, err := db.Exec(query); err != nil {
-			return err
+	statements := s.Database.GetInitializeDatabaseSQL()
+	for i, statement := range statements {
+		if _, err := s.Database.Exec(statement); err != nil {
+			return fmt.Errorf("Failed to execute the statement %d of %d of the schema of the store: %v", i+1, len(statements), err)
 		}
+		logger.Info("statement-executed", lager.Data{"statement": i + 1, "statements": len(statements)})
+	}
+	return nil
+}
+
+// schemaProbeStatements probe the permissions of the user of the broker on a table. They change no row, but the
+// database checks the permission of a statement anyway.
+var schemaProbeStatements = []struct {
+	permission string
+	format     string
+}{
+	{permission: "INSERT", format: "INSERT INTO %[1]s (id) SELECT id FROM %[1]s WHERE 1 = 0"},
+	{permission: "UPDATE", format: "UPDATE %s SET value = value WHERE 1 = 0"},
+	{permission: "DELETE", format: "DELETE FROM %s WHERE 1 = 0"},
+}
+
+// probeSchema checks that every table can be read and written and that every procedure can be executed. All the
+// problems are reported at once, so that they can be fixed together.
+func (s *SqlStore) probeSchema(logger lager.Logger) error {
+	problems := []string{}
+	for _, table := range sqlTables {
+		tableName := s.Database.GetTableName(table.name)
+		rows, err := s.Database.Query(fmt.Sprintf("SELECT 1 FROM %s WHERE 1 = 0", tableName))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("the table %s is missing or cannot be read: %v", tableName, err))
+			continue
+		}
+		rows.Close()
+		for _, probe := range schemaProbeStatements {
+			if _, err := s.Database.Exec(fmt.Sprintf(probe.format, tableName)); err != nil {
+				problems = append(problems, fmt.Sprintf("%s on the table %s failed: %v", probe.permission, tableName, err))
+			}
+		}
+		logger.Info("table-probed", lager.Data{"table": tableName})
+	}
+
+	if probe, ok := s.Database.(ProcedureProbe); ok {
+		queries := probe.GetProcedureProbeSQL()
+		names := []string{}
+		for name := range queries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var permitted sql.NullInt64
+			if err := s.Database.QueryRow(queries[name]).Scan(&permitted); err != nil {
+				problems = append(problems, fmt.Sprintf("the procedure %s cannot be probed: %v", name, err))
+			} else if !permitted.Valid {
+				problems = append(problems, fmt.Sprintf("the procedure %s is missing", name))
+			} else if permitted.Int64 != 1 {
+				problems = append(problems, fmt.Sprintf("EXECUTE on the procedure %s is denied", name))
+			} else {
+				logger.Info("procedure-probed", lager.Data{"procedure": name})
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("The schema of the store is not usable without its creation: %s", strings.Join(problems, "; "))
 	}
 	return nil
 }
@@ -99,10 +255,10 @@ func (s *SqlStore) RetrieveServiceInstance(id string) (ServiceInstance, error) {
 	var value []byte
 	serviceInstance := ServiceInstance{}
 
-	query := "SELECT id, value FROM service_instances WHERE id = ?"
+	query := fmt.Sprintf("SELECT id, value FROM %s WHERE id = ?", s.Database.GetTableName(tableServiceInstances))
 	err := s.Database.QueryRow(query, id).Scan(&serviceID, &value)
 	if err == nil {
-		err = json.Unmarshal(value, &serviceInstance)
+		err = s.decodeStoreValue(tableServiceInstances, id, value, &serviceInstance)
 		if err != nil {
 			return ServiceInstance{}, err
 		}
@@ -113,15 +269,50 @@ func (s *SqlStore) RetrieveServiceInstance(id string) (ServiceInstance, error) {
 	return serviceInstance, err
 }
 
-func (s *SqlStore) RetrieveBindingDetails(id string) (brokerapi.BindDetails, error) {
+func (s *SqlStore) RetrieveServiceInstances() (map[string]ServiceInstance, error) {
+	query := fmt.Sprintf("SELECT id, value FROM %s", s.Database.GetTableName(tableServiceInstances))
+	return s.queryServiceInstances(query)
+}
+
+// RetrieveServiceInstancesByTargetName returns the instances of a storage account or of a preexisting share
+func (s *SqlStore) RetrieveServiceInstancesByTargetName(targetName string) (map[string]ServiceInstance, error) {
+	query := fmt.Sprintf("SELECT id, value FROM %s WHERE target_name = ?", s.Database.GetTableName(tableServiceInstances))
+	return s.queryServiceInstances(query, targetName)
+}
+
+func (s *SqlStore) queryServiceInstances(query string, args ...interface{}) (map[string]ServiceInstance, error) {
+	serviceInstances := map[string]ServiceInstance{}
+
+	rows, err := s.Database.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		serviceInstance := ServiceInstance{}
+		if err := s.decodeStoreValue(tableServiceInstances, id, value, &serviceInstance); err != nil {
+			return nil, err
+		}
+		serviceInstances[id] = serviceInstance
+	}
+	return serviceInstances, rows.Err()
+}
+
+func (s *SqlStore) RetrieveBindingDetails(id string) (BindingDetails, error) {
 	var bindingID string
 	var value []byte
-	bindDetails := brokerapi.BindDetails{}
+	bindDetails := BindingDetails{}
 
-	query := "SELECT id, value FROM service_bindings WHERE id = ?"
+	query := fmt.Sprintf("SELECT id, value FROM %s WHERE id = ?", s.Database.GetTableName(tableServiceBindings))
 	err := s.Database.QueryRow(query, id).Scan(&bindingID, &value)
 	if err == nil {
-		err = json.Unmarshal(value, &bindDetails)
+		err = s.decodeStoreValue(tableServiceBindings, id, value, &bindDetails)
 		if err != nil {
 			return bindDetails, err
 		}
@@ -132,15 +323,40 @@ func (s *SqlStore) RetrieveBindingDetails(id string) (brokerapi.BindDetails, err
 	return bindDetails, err
 }
 
+func (s *SqlStore) RetrieveAllBindingDetails() (map[string]BindingDetails, error) {
+	bindings := map[string]BindingDetails{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s", s.Database.GetTableName(tableServiceBindings))
+	rows, err := s.Database.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		bindingDetails := BindingDetails{}
+		if err := s.decodeStoreValue(tableServiceBindings, id, value, &bindingDetails); err != nil {
+			return nil, err
+		}
+		bindings[id] = bindingDetails
+	}
+	return bindings, rows.Err()
+}
+
 func (s *SqlStore) RetrieveFileShare(id string) (FileShare, error) {
 	var serviceID string
 	var value []byte
 	share := FileShare{}
 
-	query := "SELECT id, value FROM file_shares WHERE id = ?"
+	query := fmt.Sprintf("SELECT id, value FROM %s WHERE id = ?", s.Database.GetTableName(tableFileShares))
 	err := s.Database.QueryRow(query, id).Scan(&serviceID, &value)
 	if err == nil {
-		err = json.Unmarshal(value, &share)
+		err = s.decodeStoreValue(tableFileShares, id, value, &share)
 		if err != nil {
 			return share, err
 		}
@@ -151,8 +367,313 @@ func (s *SqlStore) RetrieveFileShare(id string) (FileShare, error) {
 	return share, err
 }
 
+func (s *SqlStore) RetrieveFileShares() (map[string]FileShare, error) {
+	shares := map[string]FileShare{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s", s.Database.GetTableName(tableFileShares))
+	rows, err := s.Database.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		share := FileShare{}
+		if err := s.decodeStoreValue(tableFileShares, id, value, &share); err != nil {
+			return nil, err
+		}
+		shares[id] = share
+	}
+	return shares, rows.Err()
+}
+
+func (s *SqlStore) RetrieveStorageAccountOwner(id string) (StorageAccountOwner, error) {
+	var ownerID string
+	var value []byte
+	owner := StorageAccountOwner{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s WHERE id = ?", s.Database.GetTableName(tableStorageAccountOwners))
+	err := s.Database.QueryRow(query, id).Scan(&ownerID, &value)
+	if err == nil {
+		err = s.decodeStoreValue(tableStorageAccountOwners, id, value, &owner)
+		if err != nil {
+			return owner, err
+		}
+		return owner, nil
+	} else if err == sql.ErrNoRows {
+		return owner, brokerapi.ErrInstanceDoesNotExist
+	}
+	return owner, err
+}
+
+func (s *SqlStore) RetrieveFileShareOwner(id string) (FileShareOwner, error) {
+	var ownerID string
+	var value []byte
+	owner := FileShareOwner{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s WHERE id = ?", s.Database.GetTableName(tableFileShareOwners))
+	err := s.Database.QueryRow(query, id).Scan(&ownerID, &value)
+	if err == nil {
+		err = s.decodeStoreValue(tableFileShareOwners, id, value, &owner)
+		if err != nil {
+			return owner, err
+		}
+		return owner, nil
+	} else if err == sql.ErrNoRows {
+		return owner, brokerapi.ErrInstanceDoesNotExist
+	}
+	return owner, err
+}
+
+func (s *SqlStore) RetrieveLockHolder(id string) (LockHolder, error) {
+	var holderID string
+	var value []byte
+	holder := LockHolder{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s WHERE id = ?", s.Database.GetTableName(tableLockHolders))
+	err := s.Database.QueryRow(query, id).Scan(&holderID, &value)
+	if err == nil {
+		err = s.decodeStoreValue(tableLockHolders, id, value, &holder)
+		if err != nil {
+			return holder, err
+		}
+		return holder, nil
+	} else if err == sql.ErrNoRows {
+		return holder, brokerapi.ErrInstanceDoesNotExist
+	}
+	return holder, err
+}
+
+func (s *SqlStore) RetrieveLockHolders() (map[string]LockHolder, error) {
+	holders := map[string]LockHolder{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s", s.Database.GetTableName(tableLockHolders))
+	rows, err := s.Database.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		holder := LockHolder{}
+		if err := s.decodeStoreValue(tableLockHolders, id, value, &holder); err != nil {
+			return nil, err
+		}
+		holders[id] = holder
+	}
+	return holders, rows.Err()
+}
+
+func (s *SqlStore) RetrieveScheduledDeletion(id string) (ScheduledDeletion, error) {
+	var deletionID string
+	var value []byte
+	deletion := ScheduledDeletion{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s WHERE id = ?", s.Database.GetTableName(tableScheduledDeletions))
+	err := s.Database.QueryRow(query, id).Scan(&deletionID, &value)
+	if err == nil {
+		err = s.decodeStoreValue(tableScheduledDeletions, id, value, &deletion)
+		if err != nil {
+			return deletion, err
+		}
+		return deletion, nil
+	} else if err == sql.ErrNoRows {
+		return deletion, brokerapi.ErrInstanceDoesNotExist
+	}
+	return deletion, err
+}
+
+func (s *SqlStore) RetrieveScheduledDeletions() (map[string]ScheduledDeletion, error) {
+	deletions := map[string]ScheduledDeletion{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s", s.Database.GetTableName(tableScheduledDeletions))
+	rows, err := s.Database.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		deletion := ScheduledDeletion{}
+		if err := s.decodeStoreValue(tableScheduledDeletions, id, value, &deletion); err != nil {
+			return nil, err
+		}
+		deletions[id] = deletion
+	}
+	return deletions, rows.Err()
+}
+
+func (s *SqlStore) RetrieveFeatureFlags() (map[string]FeatureFlag, error) {
+	flags := map[string]FeatureFlag{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s", s.Database.GetTableName(tableFeatureFlags))
+	rows, err := s.Database.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		flag := FeatureFlag{}
+		if err := s.decodeStoreValue(tableFeatureFlags, id, value, &flag); err != nil {
+			return nil, err
+		}
+		flags[id] = flag
+	}
+	return flags, rows.Err()
+}
+
+func (s *SqlStore) RetrievePendingShareDeletions() (map[string]PendingShareDeletion, error) {
+	deletions := map[string]PendingShareDeletion{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s", s.Database.GetTableName(tablePendingShareDeletions))
+	rows, err := s.Database.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		deletion := PendingShareDeletion{}
+		if err := s.decodeStoreValue(tablePendingShareDeletions, id, value, &deletion); err != nil {
+			return nil, err
+		}
+		deletions[id] = deletion
+	}
+	return deletions, rows.Err()
+}
+
+func (s *SqlStore) RetrievePooledStorageAccounts() (map[string]PooledStorageAccount, error) {
+	accounts := map[string]PooledStorageAccount{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s", s.Database.GetTableName(tablePooledStorageAccounts))
+	rows, err := s.Database.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		account := PooledStorageAccount{}
+		if err := s.decodeStoreValue(tablePooledStorageAccounts, id, value, &account); err != nil {
+			return nil, err
+		}
+		accounts[id] = account
+	}
+	return accounts, rows.Err()
+}
+
+func (s *SqlStore) RetrieveInstanceOperations(instanceID string) ([]InstanceOperation, error) {
+	operations := []InstanceOperation{}
+
+	query := fmt.Sprintf("SELECT id, value FROM %s WHERE instance_id = ?", s.Database.GetTableName(tableInstanceOperations))
+	rows, err := s.Database.Query(query, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var value []byte
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, err
+		}
+		operation := InstanceOperation{}
+		if err := s.decodeStoreValue(tableInstanceOperations, id, value, &operation); err != nil {
+			return nil, err
+		}
+		operations = append(operations, operation)
+	}
+	return operations, rows.Err()
+}
+
+// marshalStoreValue returns the JSON of a record, which must fit in the value column of the table
+func marshalStoreValue(table, id string, value interface{}) ([]byte, error) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStoreValueSize(table, id, jsonData); err != nil {
+		return nil, err
+	}
+	return jsonData, nil
+}
+
+func checkStoreValueSize(table, id string, value []byte) error {
+	if len(value) > MaxStoreValueSize {
+		return fmt.Errorf("The record %q of the table %s is %d bytes, which is more than the %d bytes which the store keeps", id, table, len(value), MaxStoreValueSize)
+	}
+	return nil
+}
+
+// encodeStoreValue returns the value of a record in the value column of the table: its JSON, which is encrypted if the
+// store has keys
+func (s *SqlStore) encodeStoreValue(table, id string, value interface{}) ([]byte, error) {
+	if s.encryption == nil {
+		return marshalStoreValue(table, id, value)
+	}
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.encryption.seal(table, id, jsonData)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStoreValueSize(table, id, sealed); err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// decodeStoreValue reads the value of a record which is either JSON or encrypted by encodeStoreValue
+func (s *SqlStore) decodeStoreValue(table, id string, data []byte, value interface{}) error {
+	if isEncryptedStoreValue(data) {
+		if s.encryption == nil {
+			return fmt.Errorf("The record %q of the table %s is encrypted, but STORE_ENCRYPTION_KEYS is not set", id, table)
+		}
+		plaintext, err := s.encryption.open(table, id, data)
+		if err != nil {
+			return err
+		}
+		data = plaintext
+	}
+	return json.Unmarshal(data, value)
+}
+
 func (s *SqlStore) CreateServiceInstance(id string, instance ServiceInstance) error {
-	jsonData, err := json.Marshal(instance)
+	jsonData, err := s.encodeStoreValue(tableServiceInstances, id, instance)
 	if err != nil {
 		return err
 	}
@@ -167,7 +688,7 @@ func (s *SqlStore) CreateServiceInstance(id string, instance ServiceInstance) er
 	buffer.WriteString(instance.TargetName)
 	hashKey := fmt.Sprintf("%x", md5.Sum(buffer.Bytes()))
 
-	query := "INSERT INTO service_instances (id, service_id, plan_id, organization_guid, space_guid, target_name, hash_key, value) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	query := fmt.Sprintf("INSERT INTO %s (id, service_id, plan_id, organization_guid, space_guid, target_name, hash_key, value) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", s.Database.GetTableName(tableServiceInstances))
 	_, err = s.Database.Exec(query, id, instance.ServiceID, instance.PlanID, instance.OrganizationGUID, instance.SpaceGUID, instance.TargetName, hashKey, jsonData)
 	if err != nil {
 		return err
@@ -175,19 +696,17 @@ func (s *SqlStore) CreateServiceInstance(id string, instance ServiceInstance) er
 	return nil
 }
 
-func (s *SqlStore) CreateBindingDetails(id string, details brokerapi.BindDetails, redactRawParameter bool) error {
-	// Preexisting shares do not need to use RawParameters of BindDetails in unbind operation
-	// For security, do not store RawParameters in broker's database.
-	if redactRawParameter {
-		details.RawParameters = nil
-	}
+func (s *SqlStore) CreateBindingDetails(id string, details BindingDetails) error {
+	// RawParameters may have secrets, e.g. the password of a preexisting share.
+	// For security, do not store RawParameters in broker's database. Only ParamsHash is stored to compare requests.
+	details.RawParameters = nil
 
-	jsonData, err := json.Marshal(details)
+	jsonData, err := s.encodeStoreValue(tableServiceBindings, id, details)
 	if err != nil {
 		return err
 	}
 
-	query := "INSERT INTO service_bindings (id, value) VALUES (?, ?)"
+	query := fmt.Sprintf("INSERT INTO %s (id, value) VALUES (?, ?)", s.Database.GetTableName(tableServiceBindings))
 	_, err = s.Database.Exec(query, id, jsonData)
 	if err != nil {
 		return err
@@ -196,12 +715,12 @@ func (s *SqlStore) CreateBindingDetails(id string, details brokerapi.BindDetails
 }
 
 func (s *SqlStore) CreateFileShare(id string, share FileShare) error {
-	jsonData, err := json.Marshal(share)
+	jsonData, err := s.encodeStoreValue(tableFileShares, id, share)
 	if err != nil {
 		return err
 	}
 
-	query := "INSERT INTO file_shares (id, instance_id, file_share_name, value) VALUES (?, ?, ?, ?)"
+	query := fmt.Sprintf("INSERT INTO %s (id, instance_id, file_share_name, value) VALUES (?, ?, ?, ?)", s.Database.GetTableName(tableFileShares))
 	_, err = s.Database.Exec(query, id, share.InstanceID, share.FileShareName, jsonData)
 	if err != nil {
 		return err
@@ -209,8 +728,120 @@ func (s *SqlStore) CreateFileShare(id string, share FileShare) error {
 	return nil
 }
 
+func (s *SqlStore) CreateStorageAccountOwner(id string, owner StorageAccountOwner) error {
+	jsonData, err := s.encodeStoreValue(tableStorageAccountOwners, id, owner)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (id, value) VALUES (?, ?)", s.Database.GetTableName(tableStorageAccountOwners))
+	_, err = s.Database.Exec(query, id, jsonData)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) CreateFileShareOwner(id string, owner FileShareOwner) error {
+	jsonData, err := s.encodeStoreValue(tableFileShareOwners, id, owner)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (id, value) VALUES (?, ?)", s.Database.GetTableName(tableFileShareOwners))
+	_, err = s.Database.Exec(query, id, jsonData)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) CreateLockHolder(id string, holder LockHolder) error {
+	jsonData, err := s.encodeStoreValue(tableLockHolders, id, holder)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (id, value) VALUES (?, ?)", s.Database.GetTableName(tableLockHolders))
+	_, err = s.Database.Exec(query, id, jsonData)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) CreateScheduledDeletion(id string, deletion ScheduledDeletion) error {
+	jsonData, err := s.encodeStoreValue(tableScheduledDeletions, id, deletion)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (id, value) VALUES (?, ?)", s.Database.GetTableName(tableScheduledDeletions))
+	_, err = s.Database.Exec(query, id, jsonData)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) CreateFeatureFlag(id string, flag FeatureFlag) error {
+	jsonData, err := s.encodeStoreValue(tableFeatureFlags, id, flag)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (id, value) VALUES (?, ?)", s.Database.GetTableName(tableFeatureFlags))
+	_, err = s.Database.Exec(query, id, jsonData)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) CreatePendingShareDeletion(id string, deletion PendingShareDeletion) error {
+	jsonData, err := s.encodeStoreValue(tablePendingShareDeletions, id, deletion)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (id, value) VALUES (?, ?)", s.Database.GetTableName(tablePendingShareDeletions))
+	_, err = s.Database.Exec(query, id, jsonData)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) CreatePooledStorageAccount(id string, account PooledStorageAccount) error {
+	jsonData, err := s.encodeStoreValue(tablePooledStorageAccounts, id, account)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (id, value) VALUES (?, ?)", s.Database.GetTableName(tablePooledStorageAccounts))
+	_, err = s.Database.Exec(query, id, jsonData)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) CreateInstanceOperation(id string, operation InstanceOperation) error {
+	jsonData, err := s.encodeStoreValue(tableInstanceOperations, id, operation)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (id, instance_id, value) VALUES (?, ?, ?)", s.Database.GetTableName(tableInstanceOperations))
+	_, err = s.Database.Exec(query, id, operation.InstanceID, jsonData)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *SqlStore) DeleteServiceInstance(id string) error {
-	query := "DELETE FROM service_instances WHERE id = ?"
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.Database.GetTableName(tableServiceInstances))
 	_, err := s.Database.Exec(query, id)
 	if err != nil {
 		return err
@@ -219,7 +850,7 @@ func (s *SqlStore) DeleteServiceInstance(id string) error {
 }
 
 func (s *SqlStore) DeleteBindingDetails(id string) error {
-	query := "DELETE FROM service_bindings WHERE id = ?"
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.Database.GetTableName(tableServiceBindings))
 	_, err := s.Database.Exec(query, id)
 	if err != nil {
 		return err
@@ -228,7 +859,34 @@ func (s *SqlStore) DeleteBindingDetails(id string) error {
 }
 
 func (s *SqlStore) DeleteFileShare(id string) error {
-	query := "DELETE FROM file_shares WHERE id = ?"
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.Database.GetTableName(tableFileShares))
+	_, err := s.Database.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) DeleteStorageAccountOwner(id string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.Database.GetTableName(tableStorageAccountOwners))
+	_, err := s.Database.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) DeleteFileShareOwner(id string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.Database.GetTableName(tableFileShareOwners))
+	_, err := s.Database.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) DeleteScheduledDeletion(id string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.Database.GetTableName(tableScheduledDeletions))
 	_, err := s.Database.Exec(query, id)
 	if err != nil {
 		return err
@@ -236,12 +894,59 @@ func (s *SqlStore) DeleteFileShare(id string) error {
 	return nil
 }
 
+func (s *SqlStore) DeleteFeatureFlag(id string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.Database.GetTableName(tableFeatureFlags))
+	_, err := s.Database.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) DeletePendingShareDeletion(id string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.Database.GetTableName(tablePendingShareDeletions))
+	_, err := s.Database.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) DeletePooledStorageAccount(id string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.Database.GetTableName(tablePooledStorageAccounts))
+	_, err := s.Database.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SqlStore) UpdateServiceInstance(id string, instance ServiceInstance) error {
+	jsonData, err := s.encodeStoreValue(tableServiceInstances, id, instance)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s set value = ? WHERE id = ?", s.Database.GetTableName(tableServiceInstances))
+	result, err := s.Database.Exec(query, jsonData, id)
+	if err != nil {
+		return err
+	}
+	ret, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Cannot parse RowsAffected when updating the service instance: %v", err)
+	}
+	if ret == int64(0) {
+		return fmt.Errorf("Cannot update the service instance in the database")
+	}
+	return nil
+}
+
 func (s *SqlStore) UpdateFileShare(id string, share FileShare) error {
-	jsonData, err := json.Marshal(share)
+	jsonData, err := s.encodeStoreValue(tableFileShares, id, share)
 	if err != nil {
 		return err
 	}
-	query := "UPDATE file_shares set value = ? WHERE id = ?"
+	query := fmt.Sprintf("UPDATE %s set value = ? WHERE id = ?", s.Database.GetTableName(tableFileShares))
 	result, err := s.Database.Exec(query, jsonData, id)
 	if err != nil {
 		return err
@@ -256,25 +961,111 @@ func (s *SqlStore) UpdateFileShare(id string, share FileShare) error {
 	return nil
 }
 
-func (s *SqlStore) GetLockForUpdate(lockName string, seconds int) error {
-	query := s.Database.GetAppLockSQL()
-	var ret int
-	err := s.Database.QueryRow(query, lockName, seconds).Scan(&ret)
+func (s *SqlStore) UpdateFileShareOwner(id string, owner FileShareOwner) error {
+	jsonData, err := s.encodeStoreValue(tableFileShareOwners, id, owner)
 	if err != nil {
-		return fmt.Errorf("Cannot get the lock %q for update in %d seconds. Error: %v", lockName, seconds, err)
+		return err
 	}
-	if ret != 1 {
-		return fmt.Errorf("Cannot get the lock %q for update in %d seconds because the lock has been obtained by some process", lockName, seconds)
+	query := fmt.Sprintf("UPDATE %s set value = ? WHERE id = ?", s.Database.GetTableName(tableFileShareOwners))
+	result, err := s.Database.Exec(query, jsonData, id)
+	if err != nil {
+		return err
+	}
+	ret, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Cannot parse RowsAffected when updating the file share owner: %v", err)
+	}
+	if ret == int64(0) {
+		return fmt.Errorf("Cannot update the file share owner in the database")
 	}
 	return nil
 }
 
-func (s *SqlStore) ReleaseLockForUpdate(lockName string) error {
-	query := s.Database.GetReleaseAppLockSQL()
+func (s *SqlStore) UpdateLockHolder(id string, holder LockHolder) error {
+	jsonData, err := s.encodeStoreValue(tableLockHolders, id, holder)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s set value = ? WHERE id = ?", s.Database.GetTableName(tableLockHolders))
+	result, err := s.Database.Exec(query, jsonData, id)
+	if err != nil {
+		return err
+	}
+	ret, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Cannot parse RowsAffected when updating the lock holder: %v", err)
+	}
+	if ret == int64(0) {
+		return fmt.Errorf("Cannot update the lock holder in the database")
+	}
+	return nil
+}
 
-	_, err := s.Database.Exec(query, lockName)
+func (s *SqlStore) UpdateFeatureFlag(id string, flag FeatureFlag) error {
+	jsonData, err := s.encodeStoreValue(tableFeatureFlags, id, flag)
 	if err != nil {
-		return fmt.Errorf("Cannot release the lock %q for update. Error: %v", lockName, err)
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s set value = ? WHERE id = ?", s.Database.GetTableName(tableFeatureFlags))
+	result, err := s.Database.Exec(query, jsonData, id)
+	if err != nil {
+		return err
+	}
+	ret, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Cannot parse RowsAffected when updating the feature flag: %v", err)
+	}
+	if ret == int64(0) {
+		return fmt.Errorf("Cannot update the feature flag in the database")
 	}
 	return nil
 }
+
+func (s *SqlStore) UpdatePendingShareDeletion(id string, deletion PendingShareDeletion) error {
+	jsonData, err := s.encodeStoreValue(tablePendingShareDeletions, id, deletion)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s set value = ? WHERE id = ?", s.Database.GetTableName(tablePendingShareDeletions))
+	result, err := s.Database.Exec(query, jsonData, id)
+	if err != nil {
+		return err
+	}
+	ret, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Cannot parse RowsAffected when updating the pending share deletion: %v", err)
+	}
+	if ret == int64(0) {
+		return fmt.Errorf("Cannot update the pending share deletion in the database")
+	}
+	return nil
+}
+
+func (s *SqlStore) UpdatePooledStorageAccount(id string, account PooledStorageAccount) error {
+	jsonData, err := s.encodeStoreValue(tablePooledStorageAccounts, id, account)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s set value = ? WHERE id = ?", s.Database.GetTableName(tablePooledStorageAccounts))
+	result, err := s.Database.Exec(query, jsonData, id)
+	if err != nil {
+		return err
+	}
+	ret, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Cannot parse RowsAffected when updating the pooled storage account: %v", err)
+	}
+	if ret == int64(0) {
+		return fmt.Errorf("Cannot update the pooled storage account in the database")
+	}
+	return nil
+}
+
+// GetLockForUpdate takes the app lock of the database of the store. NewStoreWithLockProvider takes the locks elsewhere.
+func (s *SqlStore) GetLockForUpdate(lockName string, seconds int) error {
+	return NewSqlAppLockProvider(s.Database).GetLockForUpdate(lockName, seconds)
+}
+
+func (s *SqlStore) ReleaseLockForUpdate(lockName string) error {
+	return NewSqlAppLockProvider(s.Database).ReleaseLockForUpdate(lockName)
+}
@@ -0,0 +1,159 @@
+package azurefilebroker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// StoreCleanupReport lists the rows which the cleanup of the store removes. A dry run only lists them.
+type StoreCleanupReport struct {
+	DryRun bool `json:"dry_run"`
+	// OrphanBindings are the bindings whose service instance is gone
+	OrphanBindings []string `json:"orphan_bindings"`
+	// UnusedFileShares are the file shares which no binding uses and which have a count of 0 or whose service instance
+	// is gone. The shares in Azure are not deleted.
+	UnusedFileShares []string `json:"unused_file_shares"`
+	// ExpiredScheduledDeletions are the deleted storage accounts and file shares whose retention period is over. They
+	// are purged like the purger does, which is not run when the retention is disabled.
+	ExpiredScheduledDeletions []string `json:"expired_scheduled_deletions"`
+	// Errors are the rows which could not be removed
+	Errors []string `json:"errors,omitempty"`
+}
+
+// CleanupStore removes the rows of the store which nothing refers to any more. Bindings created by older versions of
+// the broker do not record their file share, so they are never removed.
+func (b *Broker) CleanupStore(logger lager.Logger, dryRun bool) (StoreCleanupReport, error) {
+	logger = logger.Session("cleanup-store").WithData(lager.Data{"dryRun": dryRun})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	report := StoreCleanupReport{
+		DryRun:                    dryRun,
+		OrphanBindings:            []string{},
+		UnusedFileShares:          []string{},
+		ExpiredScheduledDeletions: []string{},
+	}
+
+	instances, err := b.store.RetrieveServiceInstances()
+	if err != nil {
+		return report, newStoreError(err, "Failed to retrieve the service instances")
+	}
+	bindings, err := b.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return report, newStoreError(err, "Failed to retrieve the bindings")
+	}
+	shares, err := b.store.RetrieveFileShares()
+	if err != nil {
+		return report, newStoreError(err, "Failed to retrieve the file shares")
+	}
+	deletions, err := b.store.RetrieveScheduledDeletions()
+	if err != nil {
+		return report, newStoreError(err, "Failed to retrieve the scheduled deletions")
+	}
+
+	usedFileShares := map[string]bool{}
+	for bindingID, bindingDetails := range bindings {
+		if bindingDetails.FileShareID == "" {
+			continue
+		}
+		if hasInstanceOfFileShare(instances, shares, bindingDetails.FileShareID) {
+			usedFileShares[bindingDetails.FileShareID] = true
+		} else {
+			report.OrphanBindings = append(report.OrphanBindings, bindingID)
+		}
+	}
+	for fileShareID, share := range shares {
+		if usedFileShares[fileShareID] {
+			continue
+		}
+		if _, ok := instances[share.InstanceID]; share.Count == 0 || !ok {
+			report.UnusedFileShares = append(report.UnusedFileShares, fileShareID)
+		}
+	}
+	now := b.clock.Now()
+	for id, deletion := range deletions {
+		if !now.Before(deletion.DeleteAfter) {
+			report.ExpiredScheduledDeletions = append(report.ExpiredScheduledDeletions, id)
+		}
+	}
+	sort.Strings(report.OrphanBindings)
+	sort.Strings(report.UnusedFileShares)
+	sort.Strings(report.ExpiredScheduledDeletions)
+
+	logger.Info("rows-to-remove", lager.Data{
+		"orphanBindings":            len(report.OrphanBindings),
+		"unusedFileShares":          len(report.UnusedFileShares),
+		"expiredScheduledDeletions": len(report.ExpiredScheduledDeletions),
+	})
+	if dryRun {
+		return report, nil
+	}
+
+	for _, bindingID := range report.OrphanBindings {
+		if err := b.store.DeleteBindingDetails(bindingID); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("binding %q: %v", bindingID, err))
+			continue
+		}
+		logger.Info("orphan-binding-removed", lager.Data{"bindingID": bindingID})
+	}
+	for _, fileShareID := range report.UnusedFileShares {
+		if err := b.removeUnusedFileShare(logger, fileShareID); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("file share %q: %v", fileShareID, err))
+		}
+	}
+	for _, id := range report.ExpiredScheduledDeletions {
+		if err := b.purgeScheduledDeletion(logger.WithData(lager.Data{"id": id}), id, deletions[id]); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("scheduled deletion %q: %v", id, err))
+		}
+	}
+	return report, nil
+}
+
+// hasInstanceOfFileShare returns true if the service instance of the file share exists. The instance of a file share
+// which is not in the store any more is found by the prefix of the ID of the file share.
+func hasInstanceOfFileShare(instances map[string]ServiceInstance, shares map[string]FileShare, fileShareID string) bool {
+	if share, ok := shares[fileShareID]; ok {
+		_, ok := instances[share.InstanceID]
+		return ok
+	}
+	for instanceID := range instances {
+		if strings.HasPrefix(fileShareID, getFileShareID(instanceID, "")) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeUnusedFileShare removes the file share from the store under its lock unless a binding started to use it
+func (b *Broker) removeUnusedFileShare(logger lager.Logger, fileShareID string) error {
+	if err := b.getLockForUpdate(fileShareID); err != nil {
+		return err
+	}
+	defer b.store.ReleaseLockForUpdate(fileShareID)
+
+	if _, err := b.store.RetrieveFileShare(fileShareID); err == brokerapi.ErrInstanceDoesNotExist {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	bindings, err := b.store.RetrieveAllBindingDetails()
+	if err != nil {
+		return err
+	}
+	for bindingID, bindingDetails := range bindings {
+		if bindingDetails.FileShareID == fileShareID {
+			logger.Info("file-share-used-again", lager.Data{"fileShareID": fileShareID, "bindingID": bindingID})
+			return nil
+		}
+	}
+
+	if err := b.store.DeleteFileShare(fileShareID); err != nil {
+		return err
+	}
+	logger.Info("unused-file-share-removed-from-store", lager.Data{"fileShareID": fileShareID})
+	return nil
+}
@@ -0,0 +1,324 @@
+package azurefilebroker
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// storeEncryptionPrefix starts the values of the records which are encrypted, followed by the ID of the key, a colon
+// and the nonce and the ciphertext in base64. The records which were written without encryption are JSON, which starts
+// with '{', so both are read.
+const storeEncryptionPrefix = "enc:v1:"
+
+const storeEncryptionKeySize = 32
+
+var storeEncryptionKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// StoreEncryptionKey is a key of the operator which encrypts the records of the store with AES-256-GCM
+type StoreEncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// StoreEncryptionConfig is the keys of the encryption of the records of the SQL store at rest. The first key encrypts
+// the records which are written. The other keys only decrypt the records which were written before the first key was
+// added, until the re-encryption rewrites them with the first key and the old keys can be removed. The schema version
+// is not encrypted because every broker must read it.
+type StoreEncryptionConfig struct {
+	Keys []StoreEncryptionKey
+
+	// invalidEntries are the positions of the entries which cannot be parsed. The entries have the keys, which must not
+	// be in the errors.
+	invalidEntries []string
+}
+
+// NewStoreEncryptionConfig parses the keys, which are a comma separated list of <key id>:<base64 of 32 bytes>
+func NewStoreEncryptionConfig(keys string) *StoreEncryptionConfig {
+	myConf := new(StoreEncryptionConfig)
+
+	myConf.Keys = []StoreEncryptionKey{}
+	for i, entry := range strings.Split(keys, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		pair := strings.SplitN(entry, ":", 2)
+		if len(pair) != 2 {
+			myConf.invalidEntries = append(myConf.invalidEntries, strconv.Itoa(i+1))
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(pair[1]))
+		if err != nil {
+			myConf.invalidEntries = append(myConf.invalidEntries, strconv.Itoa(i+1))
+			continue
+		}
+		myConf.Keys = append(myConf.Keys, StoreEncryptionKey{ID: strings.TrimSpace(pair[0]), Key: key})
+	}
+
+	return myConf
+}
+
+// IsEnabled returns true if the records are encrypted
+func (config *StoreEncryptionConfig) IsEnabled() bool {
+	return len(config.Keys) > 0
+}
+
+// KeyIDs returns the IDs of the keys, the current one first
+func (config *StoreEncryptionConfig) KeyIDs() []string {
+	ids := []string{}
+	for _, key := range config.Keys {
+		ids = append(ids, key.ID)
+	}
+	return ids
+}
+
+func (config *StoreEncryptionConfig) Validate() error {
+	if len(config.invalidEntries) > 0 {
+		return fmt.Errorf("Invalid entries %s in STORE_ENCRYPTION_KEYS. Expected <key id>:<base64 of %d bytes>", strings.Join(config.invalidEntries, ", "), storeEncryptionKeySize)
+	}
+	ids := map[string]bool{}
+	for _, key := range config.Keys {
+		if !storeEncryptionKeyIDPattern.MatchString(key.ID) {
+			return fmt.Errorf("Invalid key ID %q in STORE_ENCRYPTION_KEYS. Expected 1 to 64 letters, digits, '_', '.' or '-'", key.ID)
+		}
+		if ids[key.ID] {
+			return fmt.Errorf("The key ID %q is used by more than one key in STORE_ENCRYPTION_KEYS", key.ID)
+		}
+		ids[key.ID] = true
+		if len(key.Key) != storeEncryptionKeySize {
+			return fmt.Errorf("The key %q in STORE_ENCRYPTION_KEYS is %d bytes. Expected %d bytes for AES-256", key.ID, len(key.Key), storeEncryptionKeySize)
+		}
+	}
+	return nil
+}
+
+// storeCipher encrypts the values of the records with the current key and decrypts them with the key of their ID. The
+// table and the ID of the record are authenticated, so that a value cannot be copied to another record.
+type storeCipher struct {
+	currentKeyID string
+	aeads        map[string]cipher.AEAD
+}
+
+func newStoreCipher(config *StoreEncryptionConfig) (*storeCipher, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if !config.IsEnabled() {
+		return nil, fmt.Errorf("No key in STORE_ENCRYPTION_KEYS")
+	}
+	c := &storeCipher{currentKeyID: config.Keys[0].ID, aeads: map[string]cipher.AEAD{}}
+	for _, key := range config.Keys {
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads[key.ID] = aead
+	}
+	return c, nil
+}
+
+func isEncryptedStoreValue(value []byte) bool {
+	return bytes.HasPrefix(value, []byte(storeEncryptionPrefix))
+}
+
+// storeValueKeyID returns the ID of the key of an encrypted value
+func storeValueKeyID(value []byte) (string, bool) {
+	if !isEncryptedStoreValue(value) {
+		return "", false
+	}
+	rest := value[len(storeEncryptionPrefix):]
+	separator := bytes.IndexByte(rest, ':')
+	if separator < 0 {
+		return "", false
+	}
+	return string(rest[:separator]), true
+}
+
+func storeValueAdditionalData(table, id string) []byte {
+	return []byte(table + "/" + id)
+}
+
+func (c *storeCipher) seal(table, id string, plaintext []byte) ([]byte, error) {
+	aead := c.aeads[c.currentKeyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("Failed to generate the nonce of the record %q of the table %s: %v", id, table, err)
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, storeValueAdditionalData(table, id))
+	return []byte(storeEncryptionPrefix + c.currentKeyID + ":" + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// open returns the JSON of an encrypted value
+func (c *storeCipher) open(table, id string, value []byte) ([]byte, error) {
+	keyID, ok := storeValueKeyID(value)
+	if !ok {
+		return nil, fmt.Errorf("The record %q of the table %s is not a valid encrypted value", id, table)
+	}
+	aead, ok := c.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("The record %q of the table %s is encrypted with the key %q, which is not in STORE_ENCRYPTION_KEYS", id, table, keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(value[len(storeEncryptionPrefix)+len(keyID)+1:]))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("The record %q of the table %s is not a valid encrypted value", id, table)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, storeValueAdditionalData(table, id))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt the record %q of the table %s with the key %q: %v", id, table, keyID, err)
+	}
+	return plaintext, nil
+}
+
+// SetEncryption encrypts the records which the store writes with the first key of the config and decrypts the records
+// which it reads with the key of their ID. The records which were written without encryption are still read. Every
+// broker which uses the database must have the keys before the first broker writes encrypted records, because the
+// brokers without the keys cannot read them.
+func (s *SqlStore) SetEncryption(config *StoreEncryptionConfig) error {
+	encryption, err := newStoreCipher(config)
+	if err != nil {
+		return err
+	}
+	s.encryption = encryption
+	return nil
+}
+
+// StoreReEncryptionReport lists the records which the re-encryption rewrites with the current key. A dry run only
+// lists them.
+type StoreReEncryptionReport struct {
+	DryRun bool   `json:"dry_run"`
+	KeyID  string `json:"key_id"`
+	// ReEncrypted are the records, as <table>/<id>, which were not encrypted or were encrypted with another key
+	ReEncrypted []string `json:"re_encrypted"`
+	// Unchanged is the number of the records which are already encrypted with the current key
+	Unchanged int `json:"unchanged"`
+	// Errors are the records which could not be re-encrypted, e.g. because their key is not configured or because a
+	// broker updated them during the re-encryption
+	Errors []string `json:"errors,omitempty"`
+}
+
+type storeRecordValue struct {
+	id    string
+	value []byte
+}
+
+// ReEncrypt rewrites every record which is not encrypted with the current key, e.g. after a key rotation or after the
+// encryption is enabled, so that the old keys can be removed. A record is only rewritten if it has not changed since it
+// was read, so the brokers may serve requests meanwhile.
+func (s *SqlStore) ReEncrypt(logger lager.Logger, dryRun bool) (StoreReEncryptionReport, error) {
+	logger = logger.Session("re-encrypt-store").WithData(lager.Data{"dryRun": dryRun})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	report := StoreReEncryptionReport{DryRun: dryRun, ReEncrypted: []string{}}
+	if s.encryption == nil {
+		return report, fmt.Errorf("The store is not encrypted: set STORE_ENCRYPTION_KEYS")
+	}
+	report.KeyID = s.encryption.currentKeyID
+
+	for _, table := range sqlTables {
+		if table.name == tableSchemaVersions {
+			continue
+		}
+		records, err := s.retrieveRecordValues(table.name)
+		if err != nil {
+			return report, fmt.Errorf("Failed to retrieve the records of the table %s: %v", table.name, err)
+		}
+		for _, record := range records {
+			name := table.name + "/" + record.id
+			if keyID, _ := storeValueKeyID(record.value); keyID == report.KeyID {
+				report.Unchanged++
+				continue
+			}
+			if err := s.reEncryptRecord(table.name, record, dryRun); err != nil {
+				logger.Error("re-encrypt-record", err, lager.Data{"record": name})
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			report.ReEncrypted = append(report.ReEncrypted, name)
+		}
+	}
+	logger.Info("store-re-encrypted", lager.Data{"keyID": report.KeyID, "reEncrypted": len(report.ReEncrypted), "unchanged": report.Unchanged, "errors": len(report.Errors)})
+	return report, nil
+}
+
+// retrieveRecordValues reads the records of the table before any of them is rewritten, so that the rows are not open
+// during the updates
+func (s *SqlStore) retrieveRecordValues(table string) ([]storeRecordValue, error) {
+	query := fmt.Sprintf("SELECT id, value FROM %s", s.Database.GetTableName(table))
+	rows, err := s.Database.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []storeRecordValue{}
+	for rows.Next() {
+		var record storeRecordValue
+		if err := rows.Scan(&record.id, &record.value); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *SqlStore) reEncryptRecord(table string, record storeRecordValue, dryRun bool) error {
+	plaintext := record.value
+	if isEncryptedStoreValue(record.value) {
+		var err error
+		if plaintext, err = s.encryption.open(table, record.id, record.value); err != nil {
+			return err
+		}
+	}
+	value, err := s.encryption.seal(table, record.id, plaintext)
+	if err != nil {
+		return err
+	}
+	if err := checkStoreValueSize(table, record.id, value); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+
+	query := fmt.Sprintf("UPDATE %s set value = ? WHERE id = ? AND value = ?", s.Database.GetTableName(table))
+	result, err := s.Database.Exec(query, value, record.id, record.value)
+	if err != nil {
+		return err
+	}
+	ret, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Cannot parse RowsAffected when re-encrypting the record: %v", err)
+	}
+	if ret == int64(0) {
+		return fmt.Errorf("The record was updated or deleted during the re-encryption: run the re-encryption again")
+	}
+	return nil
+}
+
+// SetStoreReEncrypter sets the store whose records ReEncryptStore rewrites
+func (b *Broker) SetStoreReEncrypter(store *SqlStore) {
+	b.storeReEncrypter = store
+}
+
+// ReEncryptStore rewrites the records of the store with the current key of its encryption, see SqlStore.ReEncrypt
+func (b *Broker) ReEncryptStore(logger lager.Logger, dryRun bool) (StoreReEncryptionReport, error) {
+	if b.storeReEncrypter == nil {
+		return StoreReEncryptionReport{DryRun: dryRun}, fmt.Errorf("The store of the broker is not a SQL store")
+	}
+	return b.storeReEncrypter.ReEncrypt(logger, dryRun)
+}
@@ -0,0 +1,356 @@
+package azurefilebroker
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// metricsStore is a store which records the result and the duration of every call in the metrics
+type metricsStore struct {
+	Store
+	metrics  Metrics
+	clock    clock.Clock
+	database string
+}
+
+// newMetricsStore returns the store with metrics. The database is "primary" or "replica".
+func newMetricsStore(store Store, metrics Metrics, clock clock.Clock, database string) Store {
+	return &metricsStore{Store: store, metrics: metrics, clock: clock, database: database}
+}
+
+// record counts a missing record as not found instead of a failure because most operations expect it
+func (s *metricsStore) record(method string, start time.Time, err error) {
+	result := metricResultSuccess
+	switch {
+	case err == brokerapi.ErrInstanceDoesNotExist:
+		result = "not-found"
+	case err != nil:
+		result = metricResultFailure
+	}
+	s.metrics.IncrementCounter(metricStoreCalls, map[string]string{"database": s.database, "method": method, "result": result})
+	s.metrics.ObserveDuration(metricStoreCallDuration, map[string]string{"database": s.database, "method": method}, s.clock.Since(start))
+}
+
+func (s *metricsStore) RetrieveServiceInstance(id string) (ServiceInstance, error) {
+	start := s.clock.Now()
+	instance, err := s.Store.RetrieveServiceInstance(id)
+	s.record("RetrieveServiceInstance", start, err)
+	return instance, err
+}
+
+func (s *metricsStore) RetrieveServiceInstances() (map[string]ServiceInstance, error) {
+	start := s.clock.Now()
+	instances, err := s.Store.RetrieveServiceInstances()
+	s.record("RetrieveServiceInstances", start, err)
+	return instances, err
+}
+
+func (s *metricsStore) RetrieveServiceInstancesByTargetName(targetName string) (map[string]ServiceInstance, error) {
+	start := s.clock.Now()
+	instances, err := s.Store.RetrieveServiceInstancesByTargetName(targetName)
+	s.record("RetrieveServiceInstancesByTargetName", start, err)
+	return instances, err
+}
+
+func (s *metricsStore) RetrieveBindingDetails(id string) (BindingDetails, error) {
+	start := s.clock.Now()
+	details, err := s.Store.RetrieveBindingDetails(id)
+	s.record("RetrieveBindingDetails", start, err)
+	return details, err
+}
+
+func (s *metricsStore) RetrieveAllBindingDetails() (map[string]BindingDetails, error) {
+	start := s.clock.Now()
+	details, err := s.Store.RetrieveAllBindingDetails()
+	s.record("RetrieveAllBindingDetails", start, err)
+	return details, err
+}
+
+func (s *metricsStore) RetrieveFileShare(id string) (FileShare, error) {
+	start := s.clock.Now()
+	share, err := s.Store.RetrieveFileShare(id)
+	s.record("RetrieveFileShare", start, err)
+	return share, err
+}
+
+func (s *metricsStore) RetrieveFileShares() (map[string]FileShare, error) {
+	start := s.clock.Now()
+	shares, err := s.Store.RetrieveFileShares()
+	s.record("RetrieveFileShares", start, err)
+	return shares, err
+}
+
+func (s *metricsStore) RetrieveStorageAccountOwner(id string) (StorageAccountOwner, error) {
+	start := s.clock.Now()
+	owner, err := s.Store.RetrieveStorageAccountOwner(id)
+	s.record("RetrieveStorageAccountOwner", start, err)
+	return owner, err
+}
+
+func (s *metricsStore) RetrieveFileShareOwner(id string) (FileShareOwner, error) {
+	start := s.clock.Now()
+	owner, err := s.Store.RetrieveFileShareOwner(id)
+	s.record("RetrieveFileShareOwner", start, err)
+	return owner, err
+}
+
+func (s *metricsStore) RetrieveLockHolder(id string) (LockHolder, error) {
+	start := s.clock.Now()
+	holder, err := s.Store.RetrieveLockHolder(id)
+	s.record("RetrieveLockHolder", start, err)
+	return holder, err
+}
+
+func (s *metricsStore) RetrieveScheduledDeletion(id string) (ScheduledDeletion, error) {
+	start := s.clock.Now()
+	deletion, err := s.Store.RetrieveScheduledDeletion(id)
+	s.record("RetrieveScheduledDeletion", start, err)
+	return deletion, err
+}
+
+func (s *metricsStore) RetrieveLockHolders() (map[string]LockHolder, error) {
+	start := s.clock.Now()
+	holders, err := s.Store.RetrieveLockHolders()
+	s.record("RetrieveLockHolders", start, err)
+	return holders, err
+}
+
+func (s *metricsStore) RetrieveScheduledDeletions() (map[string]ScheduledDeletion, error) {
+	start := s.clock.Now()
+	deletions, err := s.Store.RetrieveScheduledDeletions()
+	s.record("RetrieveScheduledDeletions", start, err)
+	return deletions, err
+}
+
+func (s *metricsStore) RetrieveFeatureFlags() (map[string]FeatureFlag, error) {
+	start := s.clock.Now()
+	flags, err := s.Store.RetrieveFeatureFlags()
+	s.record("RetrieveFeatureFlags", start, err)
+	return flags, err
+}
+
+func (s *metricsStore) RetrievePendingShareDeletions() (map[string]PendingShareDeletion, error) {
+	start := s.clock.Now()
+	deletions, err := s.Store.RetrievePendingShareDeletions()
+	s.record("RetrievePendingShareDeletions", start, err)
+	return deletions, err
+}
+
+func (s *metricsStore) RetrievePooledStorageAccounts() (map[string]PooledStorageAccount, error) {
+	start := s.clock.Now()
+	accounts, err := s.Store.RetrievePooledStorageAccounts()
+	s.record("RetrievePooledStorageAccounts", start, err)
+	return accounts, err
+}
+
+func (s *metricsStore) RetrieveInstanceOperations(instanceID string) ([]InstanceOperation, error) {
+	start := s.clock.Now()
+	operations, err := s.Store.RetrieveInstanceOperations(instanceID)
+	s.record("RetrieveInstanceOperations", start, err)
+	return operations, err
+}
+
+func (s *metricsStore) CreateServiceInstance(id string, instance ServiceInstance) error {
+	start := s.clock.Now()
+	err := s.Store.CreateServiceInstance(id, instance)
+	s.record("CreateServiceInstance", start, err)
+	return err
+}
+
+func (s *metricsStore) CreateBindingDetails(id string, details BindingDetails) error {
+	start := s.clock.Now()
+	err := s.Store.CreateBindingDetails(id, details)
+	s.record("CreateBindingDetails", start, err)
+	return err
+}
+
+func (s *metricsStore) CreateFileShare(id string, share FileShare) error {
+	start := s.clock.Now()
+	err := s.Store.CreateFileShare(id, share)
+	s.record("CreateFileShare", start, err)
+	return err
+}
+
+func (s *metricsStore) CreateStorageAccountOwner(id string, owner StorageAccountOwner) error {
+	start := s.clock.Now()
+	err := s.Store.CreateStorageAccountOwner(id, owner)
+	s.record("CreateStorageAccountOwner", start, err)
+	return err
+}
+
+func (s *metricsStore) CreateFileShareOwner(id string, owner FileShareOwner) error {
+	start := s.clock.Now()
+	err := s.Store.CreateFileShareOwner(id, owner)
+	s.record("CreateFileShareOwner", start, err)
+	return err
+}
+
+func (s *metricsStore) CreateLockHolder(id string, holder LockHolder) error {
+	start := s.clock.Now()
+	err := s.Store.CreateLockHolder(id, holder)
+	s.record("CreateLockHolder", start, err)
+	return err
+}
+
+func (s *metricsStore) CreateScheduledDeletion(id string, deletion ScheduledDeletion) error {
+	start := s.clock.Now()
+	err := s.Store.CreateScheduledDeletion(id, deletion)
+	s.record("CreateScheduledDeletion", start, err)
+	return err
+}
+
+func (s *metricsStore) CreateFeatureFlag(id string, flag FeatureFlag) error {
+	start := s.clock.Now()
+	err := s.Store.CreateFeatureFlag(id, flag)
+	s.record("CreateFeatureFlag", start, err)
+	return err
+}
+
+func (s *metricsStore) CreatePendingShareDeletion(id string, deletion PendingShareDeletion) error {
+	start := s.clock.Now()
+	err := s.Store.CreatePendingShareDeletion(id, deletion)
+	s.record("CreatePendingShareDeletion", start, err)
+	return err
+}
+
+func (s *metricsStore) CreatePooledStorageAccount(id string, account PooledStorageAccount) error {
+	start := s.clock.Now()
+	err := s.Store.CreatePooledStorageAccount(id, account)
+	s.record("CreatePooledStorageAccount", start, err)
+	return err
+}
+
+func (s *metricsStore) CreateInstanceOperation(id string, operation InstanceOperation) error {
+	start := s.clock.Now()
+	err := s.Store.CreateInstanceOperation(id, operation)
+	s.record("CreateInstanceOperation", start, err)
+	return err
+}
+
+func (s *metricsStore) UpdateServiceInstance(id string, instance ServiceInstance) error {
+	start := s.clock.Now()
+	err := s.Store.UpdateServiceInstance(id, instance)
+	s.record("UpdateServiceInstance", start, err)
+	return err
+}
+
+func (s *metricsStore) UpdateFileShare(id string, share FileShare) error {
+	start := s.clock.Now()
+	err := s.Store.UpdateFileShare(id, share)
+	s.record("UpdateFileShare", start, err)
+	return err
+}
+
+func (s *metricsStore) UpdateFileShareOwner(id string, owner FileShareOwner) error {
+	start := s.clock.Now()
+	err := s.Store.UpdateFileShareOwner(id, owner)
+	s.record("UpdateFileShareOwner", start, err)
+	return err
+}
+
+func (s *metricsStore) UpdateLockHolder(id string, holder LockHolder) error {
+	start := s.clock.Now()
+	err := s.Store.UpdateLockHolder(id, holder)
+	s.record("UpdateLockHolder", start, err)
+	return err
+}
+
+func (s *metricsStore) UpdateFeatureFlag(id string, flag FeatureFlag) error {
+	start := s.clock.Now()
+	err := s.Store.UpdateFeatureFlag(id, flag)
+	s.record("UpdateFeatureFlag", start, err)
+	return err
+}
+
+func (s *metricsStore) UpdatePendingShareDeletion(id string, deletion PendingShareDeletion) error {
+	start := s.clock.Now()
+	err := s.Store.UpdatePendingShareDeletion(id, deletion)
+	s.record("UpdatePendingShareDeletion", start, err)
+	return err
+}
+
+func (s *metricsStore) UpdatePooledStorageAccount(id string, account PooledStorageAccount) error {
+	start := s.clock.Now()
+	err := s.Store.UpdatePooledStorageAccount(id, account)
+	s.record("UpdatePooledStorageAccount", start, err)
+	return err
+}
+
+func (s *metricsStore) DeleteServiceInstance(id string) error {
+	start := s.clock.Now()
+	err := s.Store.DeleteServiceInstance(id)
+	s.record("DeleteServiceInstance", start, err)
+	return err
+}
+
+func (s *metricsStore) DeleteBindingDetails(id string) error {
+	start := s.clock.Now()
+	err := s.Store.DeleteBindingDetails(id)
+	s.record("DeleteBindingDetails", start, err)
+	return err
+}
+
+func (s *metricsStore) DeleteFileShare(id string) error {
+	start := s.clock.Now()
+	err := s.Store.DeleteFileShare(id)
+	s.record("DeleteFileShare", start, err)
+	return err
+}
+
+func (s *metricsStore) DeleteStorageAccountOwner(id string) error {
+	start := s.clock.Now()
+	err := s.Store.DeleteStorageAccountOwner(id)
+	s.record("DeleteStorageAccountOwner", start, err)
+	return err
+}
+
+func (s *metricsStore) DeleteFileShareOwner(id string) error {
+	start := s.clock.Now()
+	err := s.Store.DeleteFileShareOwner(id)
+	s.record("DeleteFileShareOwner", start, err)
+	return err
+}
+
+func (s *metricsStore) DeleteScheduledDeletion(id string) error {
+	start := s.clock.Now()
+	err := s.Store.DeleteScheduledDeletion(id)
+	s.record("DeleteScheduledDeletion", start, err)
+	return err
+}
+
+func (s *metricsStore) DeleteFeatureFlag(id string) error {
+	start := s.clock.Now()
+	err := s.Store.DeleteFeatureFlag(id)
+	s.record("DeleteFeatureFlag", start, err)
+	return err
+}
+
+func (s *metricsStore) DeletePendingShareDeletion(id string) error {
+	start := s.clock.Now()
+	err := s.Store.DeletePendingShareDeletion(id)
+	s.record("DeletePendingShareDeletion", start, err)
+	return err
+}
+
+func (s *metricsStore) DeletePooledStorageAccount(id string) error {
+	start := s.clock.Now()
+	err := s.Store.DeletePooledStorageAccount(id)
+	s.record("DeletePooledStorageAccount", start, err)
+	return err
+}
+
+func (s *metricsStore) GetLockForUpdate(lockName string, timeoutInSeconds int) error {
+	start := s.clock.Now()
+	err := s.Store.GetLockForUpdate(lockName, timeoutInSeconds)
+	s.record("GetLockForUpdate", start, err)
+	return err
+}
+
+func (s *metricsStore) ReleaseLockForUpdate(lockName string) error {
+	start := s.clock.Now()
+	err := s.Store.ReleaseLockForUpdate(lockName)
+	s.record("ReleaseLockForUpdate", start, err)
+	return err
+}
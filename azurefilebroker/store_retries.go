@@ -0,0 +1,216 @@
+package azurefilebroker
+
+import (
+	"database/sql/driver"
+	"net"
+	"strings"
+
+	"code.cloudfoundry.org/lager"
+)
+
+// The errors of the databases which may not happen again when the same call is tried again
+var transientStoreErrors = []string{
+	"driver: bad connection",
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"i/o timeout",
+	"deadlock",
+	"too many connections",
+}
+
+// retryingStore is a store which tries the reads and the locks again by its retrier when they fail with a transient
+// error. The writes are not tried again because a write which has failed may still have been committed.
+type retryingStore struct {
+	Store
+	logger  lager.Logger
+	retrier *Retrier
+}
+
+func newRetryingStore(logger lager.Logger, store Store, retrier *Retrier) Store {
+	return &retryingStore{Store: store, logger: logger.Session("store-retries"), retrier: retrier}
+}
+
+// isTransientStoreError returns false for a missing record so that it is never retried
+func isTransientStoreError(err error) bool {
+	if err == driver.ErrBadConn {
+		return true
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, transient := range transientStoreErrors {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *retryingStore) do(method string, call func() error) error {
+	return s.retrier.Do(s.logger, method, func() (bool, error) {
+		err := call()
+		return err != nil && isTransientStoreError(err), err
+	})
+}
+
+func (s *retryingStore) RetrieveServiceInstance(id string) (ServiceInstance, error) {
+	var instance ServiceInstance
+	err := s.do("RetrieveServiceInstance", func() (err error) {
+		instance, err = s.Store.RetrieveServiceInstance(id)
+		return err
+	})
+	return instance, err
+}
+
+func (s *retryingStore) RetrieveServiceInstances() (map[string]ServiceInstance, error) {
+	var instances map[string]ServiceInstance
+	err := s.do("RetrieveServiceInstances", func() (err error) {
+		instances, err = s.Store.RetrieveServiceInstances()
+		return err
+	})
+	return instances, err
+}
+
+func (s *retryingStore) RetrieveServiceInstancesByTargetName(targetName string) (map[string]ServiceInstance, error) {
+	var instances map[string]ServiceInstance
+	err := s.do("RetrieveServiceInstancesByTargetName", func() (err error) {
+		instances, err = s.Store.RetrieveServiceInstancesByTargetName(targetName)
+		return err
+	})
+	return instances, err
+}
+
+func (s *retryingStore) RetrieveBindingDetails(id string) (BindingDetails, error) {
+	var details BindingDetails
+	err := s.do("RetrieveBindingDetails", func() (err error) {
+		details, err = s.Store.RetrieveBindingDetails(id)
+		return err
+	})
+	return details, err
+}
+
+func (s *retryingStore) RetrieveAllBindingDetails() (map[string]BindingDetails, error) {
+	var details map[string]BindingDetails
+	err := s.do("RetrieveAllBindingDetails", func() (err error) {
+		details, err = s.Store.RetrieveAllBindingDetails()
+		return err
+	})
+	return details, err
+}
+
+func (s *retryingStore) RetrieveFileShare(id string) (FileShare, error) {
+	var share FileShare
+	err := s.do("RetrieveFileShare", func() (err error) {
+		share, err = s.Store.RetrieveFileShare(id)
+		return err
+	})
+	return share, err
+}
+
+func (s *retryingStore) RetrieveFileShares() (map[string]FileShare, error) {
+	var shares map[string]FileShare
+	err := s.do("RetrieveFileShares", func() (err error) {
+		shares, err = s.Store.RetrieveFileShares()
+		return err
+	})
+	return shares, err
+}
+
+func (s *retryingStore) RetrieveStorageAccountOwner(id string) (StorageAccountOwner, error) {
+	var owner StorageAccountOwner
+	err := s.do("RetrieveStorageAccountOwner", func() (err error) {
+		owner, err = s.Store.RetrieveStorageAccountOwner(id)
+		return err
+	})
+	return owner, err
+}
+
+func (s *retryingStore) RetrieveFileShareOwner(id string) (FileShareOwner, error) {
+	var owner FileShareOwner
+	err := s.do("RetrieveFileShareOwner", func() (err error) {
+		owner, err = s.Store.RetrieveFileShareOwner(id)
+		return err
+	})
+	return owner, err
+}
+
+func (s *retryingStore) RetrieveScheduledDeletion(id string) (ScheduledDeletion, error) {
+	var deletion ScheduledDeletion
+	err := s.do("RetrieveScheduledDeletion", func() (err error) {
+		deletion, err = s.Store.RetrieveScheduledDeletion(id)
+		return err
+	})
+	return deletion, err
+}
+
+func (s *retryingStore) RetrieveLockHolders() (map[string]LockHolder, error) {
+	var holders map[string]LockHolder
+	err := s.do("RetrieveLockHolders", func() (err error) {
+		holders, err = s.Store.RetrieveLockHolders()
+		return err
+	})
+	return holders, err
+}
+
+func (s *retryingStore) RetrieveScheduledDeletions() (map[string]ScheduledDeletion, error) {
+	var deletions map[string]ScheduledDeletion
+	err := s.do("RetrieveScheduledDeletions", func() (err error) {
+		deletions, err = s.Store.RetrieveScheduledDeletions()
+		return err
+	})
+	return deletions, err
+}
+
+func (s *retryingStore) RetrieveFeatureFlags() (map[string]FeatureFlag, error) {
+	var flags map[string]FeatureFlag
+	err := s.do("RetrieveFeatureFlags", func() (err error) {
+		flags, err = s.Store.RetrieveFeatureFlags()
+		return err
+	})
+	return flags, err
+}
+
+func (s *retryingStore) RetrievePendingShareDeletions() (map[string]PendingShareDeletion, error) {
+	var deletions map[string]PendingShareDeletion
+	err := s.do("RetrievePendingShareDeletions", func() (err error) {
+		deletions, err = s.Store.RetrievePendingShareDeletions()
+		return err
+	})
+	return deletions, err
+}
+
+func (s *retryingStore) RetrievePooledStorageAccounts() (map[string]PooledStorageAccount, error) {
+	var accounts map[string]PooledStorageAccount
+	err := s.do("RetrievePooledStorageAccounts", func() (err error) {
+		accounts, err = s.Store.RetrievePooledStorageAccounts()
+		return err
+	})
+	return accounts, err
+}
+
+func (s *retryingStore) RetrieveInstanceOperations(instanceID string) ([]InstanceOperation, error) {
+	var operations []InstanceOperation
+	err := s.do("RetrieveInstanceOperations", func() (err error) {
+		operations, err = s.Store.RetrieveInstanceOperations(instanceID)
+		return err
+	})
+	return operations, err
+}
+
+func (s *retryingStore) RetrieveLockHolder(id string) (LockHolder, error) {
+	var holder LockHolder
+	err := s.do("RetrieveLockHolder", func() (err error) {
+		holder, err = s.Store.RetrieveLockHolder(id)
+		return err
+	})
+	return holder, err
+}
+
+// GetLockForUpdate is tried again only when the database fails, not when the lock is still held after the timeout
+func (s *retryingStore) GetLockForUpdate(lockName string, seconds int) error {
+	return s.do("GetLockForUpdate", func() error {
+		return s.Store.GetLockForUpdate(lockName, seconds)
+	})
+}
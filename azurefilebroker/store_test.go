@@ -5,6 +5,7 @@ import (
 	"crypto/md5"
 	"errors"
 	"fmt"
+	"strings"
 
 	"code.cloudfoundry.org/azurefilebroker/azurefilebroker"
 	"code.cloudfoundry.org/azurefilebroker/azurefilebrokerfakes"
@@ -12,6 +13,8 @@ import (
 	"github.com/pivotal-cf/brokerapi"
 
 	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
 	"encoding/json"
 	"reflect"
 
@@ -35,7 +38,7 @@ var _ = Describe("Store", func() {
 		mock                                                      sqlmock.Sqlmock
 		bindResource                                              brokerapi.BindResource
 		rawParameters                                             json.RawMessage
-		bindDetails                                               brokerapi.BindDetails
+		bindDetails                                               azurefilebroker.BindingDetails
 		fileShare                                                 azurefilebroker.FileShare
 	)
 
@@ -120,6 +123,63 @@ var _ = Describe("Store", func() {
 
 	})
 
+	Describe("RetrieveServiceInstances", func() {
+		var serviceInstances map[string]azurefilebroker.ServiceInstance
+
+		Context("When instances exist", func() {
+			BeforeEach(func() {
+				Expect(err).NotTo(HaveOccurred())
+				columns := []string{"id", "value"}
+
+				rows := sqlmock.NewRows(columns)
+				jsonvalue1, err := json.Marshal(azurefilebroker.ServiceInstance{TargetName: "target_1", ProvisioningState: "pending"})
+				Expect(err).NotTo(HaveOccurred())
+				rows.AddRow("instance_1", jsonvalue1)
+				jsonvalue2, err := json.Marshal(azurefilebroker.ServiceInstance{TargetName: "target_2"})
+				Expect(err).NotTo(HaveOccurred())
+				rows.AddRow("instance_2", jsonvalue2)
+
+				mock.ExpectQuery("SELECT id, value FROM service_instances").WillReturnRows(rows)
+			})
+			JustBeforeEach(func() {
+				serviceInstances, err = sqlStore.RetrieveServiceInstances()
+			})
+			It("should return all instances", func() {
+				Expect(err).To(BeNil())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+				Expect(serviceInstances).To(HaveLen(2))
+				Expect(serviceInstances["instance_1"].TargetName).To(Equal("target_1"))
+				Expect(serviceInstances["instance_1"].ProvisioningState).To(Equal("pending"))
+				Expect(serviceInstances["instance_2"].TargetName).To(Equal("target_2"))
+			})
+		})
+
+		Context("When the query fails", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT id, value FROM service_instances").WillReturnError(errors.New("error"))
+			})
+			JustBeforeEach(func() {
+				serviceInstances, err = sqlStore.RetrieveServiceInstances()
+			})
+			It("should return an error", func() {
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("RetrieveServiceInstancesByTargetName", func() {
+		It("should select the instances by the target name", func() {
+			rows := sqlmock.NewRows([]string{"id", "value"}).AddRow("instance-1", []byte(`{"target_name":"account"}`))
+			mock.ExpectQuery("SELECT id, value FROM service_instances WHERE target_name = ?").WithArgs("account").WillReturnRows(rows)
+
+			instances, err := sqlStore.RetrieveServiceInstancesByTargetName("account")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(HaveKey("instance-1"))
+			Expect(instances["instance-1"].TargetName).To(Equal("account"))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
 	Describe("RetrieveBindingDetails", func() {
 		Context("When the instance exists", func() {
 			BeforeEach(func() {
@@ -132,7 +192,11 @@ var _ = Describe("Store", func() {
 
 				columns := []string{"id", "value"}
 				rows := sqlmock.NewRows(columns)
-				jsonvalue, err := json.Marshal(brokerapi.BindDetails{AppGUID: appGUID, PlanID: planID, ServiceID: serviceID, BindResource: &bindResource, RawParameters: rawParameters})
+				jsonvalue, err := json.Marshal(azurefilebroker.BindingDetails{
+					BindDetails: brokerapi.BindDetails{AppGUID: appGUID, PlanID: planID, ServiceID: serviceID, BindResource: &bindResource, RawParameters: rawParameters},
+					BindOptions: &azurefilebroker.BindOptions{FileShareName: "share_123"},
+					FileShareID: "instance_123-share_123",
+				})
 				Expect(err).NotTo(HaveOccurred())
 				rows.AddRow(bindingID, jsonvalue)
 
@@ -150,6 +214,8 @@ var _ = Describe("Store", func() {
 				Expect(bindDetails.BindResource.AppGuid).To(Equal(appGUID))
 				Expect(bindDetails.BindResource.Route).To(Equal("binding-route"))
 				Expect(bindDetails.RawParameters).To(Equal(rawParameters))
+				Expect(bindDetails.BindOptions.FileShareName).To(Equal("share_123"))
+				Expect(bindDetails.FileShareID).To(Equal("instance_123-share_123"))
 			})
 		})
 
@@ -162,7 +228,47 @@ var _ = Describe("Store", func() {
 			})
 			It("should return an error", func() {
 				Expect(err).To(HaveOccurred())
-				Expect(reflect.DeepEqual(bindDetails, brokerapi.BindDetails{})).To(BeTrue())
+				Expect(reflect.DeepEqual(bindDetails, azurefilebroker.BindingDetails{})).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("RetrieveAllBindingDetails", func() {
+		var bindings map[string]azurefilebroker.BindingDetails
+
+		Context("When bindings exist", func() {
+			BeforeEach(func() {
+				rows := sqlmock.NewRows([]string{"id", "value"})
+				jsonvalue1, err := json.Marshal(azurefilebroker.BindingDetails{FileShareID: "file_share_1"})
+				Expect(err).NotTo(HaveOccurred())
+				rows.AddRow("binding_1", jsonvalue1)
+				jsonvalue2, err := json.Marshal(azurefilebroker.BindingDetails{FileShareID: "file_share_2"})
+				Expect(err).NotTo(HaveOccurred())
+				rows.AddRow("binding_2", jsonvalue2)
+
+				mock.ExpectQuery("SELECT id, value FROM service_bindings").WillReturnRows(rows)
+			})
+			JustBeforeEach(func() {
+				bindings, err = sqlStore.RetrieveAllBindingDetails()
+			})
+			It("should return all bindings", func() {
+				Expect(err).To(BeNil())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+				Expect(bindings).To(HaveLen(2))
+				Expect(bindings["binding_1"].FileShareID).To(Equal("file_share_1"))
+				Expect(bindings["binding_2"].FileShareID).To(Equal("file_share_2"))
+			})
+		})
+
+		Context("When the query fails", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT id, value FROM service_bindings").WillReturnError(errors.New("error"))
+			})
+			JustBeforeEach(func() {
+				bindings, err = sqlStore.RetrieveAllBindingDetails()
+			})
+			It("should return an error", func() {
+				Expect(err).To(HaveOccurred())
 			})
 		})
 	})
@@ -210,6 +316,166 @@ var _ = Describe("Store", func() {
 
 	})
 
+	Describe("RetrieveFileShares", func() {
+		var shares map[string]azurefilebroker.FileShare
+
+		Context("When file shares exist", func() {
+			BeforeEach(func() {
+				rows := sqlmock.NewRows([]string{"id", "value"})
+				jsonvalue, err := json.Marshal(azurefilebroker.FileShare{InstanceID: "instance_1", FileShareName: "share_1", Count: 2})
+				Expect(err).NotTo(HaveOccurred())
+				rows.AddRow("instance_1-share_1", jsonvalue)
+
+				mock.ExpectQuery("SELECT id, value FROM file_shares").WillReturnRows(rows)
+			})
+			JustBeforeEach(func() {
+				shares, err = sqlStore.RetrieveFileShares()
+			})
+			It("should return all file shares", func() {
+				Expect(err).To(BeNil())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+				Expect(shares).To(HaveLen(1))
+				Expect(shares["instance_1-share_1"].Count).To(Equal(2))
+			})
+		})
+
+		Context("When the query fails", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT id, value FROM file_shares").WillReturnError(errors.New("error"))
+			})
+			JustBeforeEach(func() {
+				shares, err = sqlStore.RetrieveFileShares()
+			})
+			It("should return an error", func() {
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("RetrieveStorageAccountOwner", func() {
+		var (
+			ownerID string
+			owner   azurefilebroker.StorageAccountOwner
+		)
+
+		BeforeEach(func() {
+			ownerID = "subscription-resourcegroup-account"
+		})
+
+		Context("When the owner exists", func() {
+			BeforeEach(func() {
+				rows := sqlmock.NewRows([]string{"id", "value"})
+				jsonvalue, err := json.Marshal(azurefilebroker.StorageAccountOwner{OrganizationGUID: "org", SpaceGUID: "space"})
+				Expect(err).NotTo(HaveOccurred())
+				rows.AddRow(ownerID, jsonvalue)
+
+				mock.ExpectQuery("SELECT id, value FROM storage_account_owners WHERE id = ?").WithArgs(ownerID).WillReturnRows(rows)
+			})
+			JustBeforeEach(func() {
+				owner, err = sqlStore.RetrieveStorageAccountOwner(ownerID)
+			})
+			It("should return the owner", func() {
+				Expect(err).To(BeNil())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+				Expect(owner.OrganizationGUID).To(Equal("org"))
+				Expect(owner.SpaceGUID).To(Equal("space"))
+			})
+		})
+
+		Context("When the owner does not exist", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT id, value FROM storage_account_owners WHERE id = ?").WithArgs(ownerID).WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+			})
+			JustBeforeEach(func() {
+				owner, err = sqlStore.RetrieveStorageAccountOwner(ownerID)
+			})
+			It("should return ErrInstanceDoesNotExist", func() {
+				Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+				Expect(reflect.DeepEqual(owner, azurefilebroker.StorageAccountOwner{})).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("RetrieveFileShareOwner", func() {
+		var (
+			ownerID string
+			owner   azurefilebroker.FileShareOwner
+		)
+
+		BeforeEach(func() {
+			ownerID = "subscription-resourcegroup-account-share"
+		})
+
+		Context("When the owner exists", func() {
+			BeforeEach(func() {
+				rows := sqlmock.NewRows([]string{"id", "value"})
+				jsonvalue, err := json.Marshal(azurefilebroker.FileShareOwner{OwnerFileShareID: "instance-share", FileShareIDs: []string{"instance-share", "another-instance-share"}})
+				Expect(err).NotTo(HaveOccurred())
+				rows.AddRow(ownerID, jsonvalue)
+
+				mock.ExpectQuery("SELECT id, value FROM file_share_owners WHERE id = ?").WithArgs(ownerID).WillReturnRows(rows)
+			})
+			JustBeforeEach(func() {
+				owner, err = sqlStore.RetrieveFileShareOwner(ownerID)
+			})
+			It("should return the owner", func() {
+				Expect(err).To(BeNil())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+				Expect(owner.OwnerFileShareID).To(Equal("instance-share"))
+				Expect(owner.FileShareIDs).To(ConsistOf("instance-share", "another-instance-share"))
+			})
+		})
+
+		Context("When the owner does not exist", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT id, value FROM file_share_owners WHERE id = ?").WithArgs(ownerID).WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+			})
+			JustBeforeEach(func() {
+				owner, err = sqlStore.RetrieveFileShareOwner(ownerID)
+			})
+			It("should return ErrInstanceDoesNotExist", func() {
+				Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+				Expect(reflect.DeepEqual(owner, azurefilebroker.FileShareOwner{})).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("RetrieveScheduledDeletions", func() {
+		var deletions map[string]azurefilebroker.ScheduledDeletion
+
+		BeforeEach(func() {
+			rows := sqlmock.NewRows([]string{"id", "value"})
+			jsonvalue, err := json.Marshal(azurefilebroker.ScheduledDeletion{Kind: "file-share", FileShareName: "share"})
+			Expect(err).NotTo(HaveOccurred())
+			rows.AddRow("file-share-subscription-resourcegroup-account-share", jsonvalue)
+
+			mock.ExpectQuery("SELECT id, value FROM scheduled_deletions").WillReturnRows(rows)
+		})
+		JustBeforeEach(func() {
+			deletions, err = sqlStore.RetrieveScheduledDeletions()
+		})
+		It("should return the scheduled deletions", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			Expect(deletions).To(HaveLen(1))
+			Expect(deletions["file-share-subscription-resourcegroup-account-share"].FileShareName).To(Equal("share"))
+		})
+	})
+
+	Describe("RetrieveScheduledDeletion", func() {
+		Context("When the scheduled deletion does not exist", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("SELECT id, value FROM scheduled_deletions WHERE id = ?").WithArgs("id").WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+			})
+			JustBeforeEach(func() {
+				_, err = sqlStore.RetrieveScheduledDeletion("id")
+			})
+			It("should return ErrInstanceDoesNotExist", func() {
+				Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+			})
+		})
+	})
+
 	Describe("CreateServiceInstance", func() {
 		BeforeEach(func() {
 			Expect(err).NotTo(HaveOccurred())
@@ -251,18 +517,24 @@ var _ = Describe("Store", func() {
 			serviceID = "service_123"
 			bindingID = "binding_123"
 			bindResource = brokerapi.BindResource{AppGuid: appGUID, Route: "binding-route"}
-			bindDetails = brokerapi.BindDetails{AppGUID: appGUID, PlanID: planID, ServiceID: serviceID, BindResource: &bindResource, RawParameters: rawParameters}
-			jsonValue, err := json.Marshal(bindDetails)
+			bindDetails = azurefilebroker.BindingDetails{
+				BindDetails: brokerapi.BindDetails{AppGUID: appGUID, PlanID: planID, ServiceID: serviceID, BindResource: &bindResource, RawParameters: rawParameters},
+				BindOptions: &azurefilebroker.BindOptions{FileShareName: "share_123"},
+				FileShareID: "instance_123-share_123",
+			}
+			storedDetails := bindDetails
+			storedDetails.RawParameters = nil
+			jsonValue, err := json.Marshal(storedDetails)
 			Expect(err).NotTo(HaveOccurred())
 
 			result := sqlmock.NewResult(1, 1)
 			mock.ExpectExec(`INSERT INTO service_bindings \(id, value\) VALUES \([?], [?]\)`).WithArgs(bindingID, jsonValue).WillReturnResult(result)
 		})
 		JustBeforeEach(func() {
-			err = sqlStore.CreateBindingDetails(bindingID, bindDetails, false)
+			err = sqlStore.CreateBindingDetails(bindingID, bindDetails)
 		})
 
-		It("should not error and call INSERT INTO on the db", func() {
+		It("should not store the raw parameters and call INSERT INTO on the db", func() {
 			Expect(err).To(BeNil())
 			Expect(mock.ExpectationsWereMet()).Should(Succeed())
 		})
@@ -290,6 +562,307 @@ var _ = Describe("Store", func() {
 		})
 	})
 
+	Describe("CreateStorageAccountOwner", func() {
+		var (
+			ownerID string
+			owner   azurefilebroker.StorageAccountOwner
+		)
+
+		BeforeEach(func() {
+			ownerID = "subscription-resourcegroup-account"
+			owner = azurefilebroker.StorageAccountOwner{OrganizationGUID: "org", SpaceGUID: "space"}
+			jsonValue, err := json.Marshal(owner)
+			Expect(err).NotTo(HaveOccurred())
+
+			result := sqlmock.NewResult(1, 1)
+			mock.ExpectExec("INSERT INTO storage_account_owners").WithArgs(ownerID, jsonValue).WillReturnResult(result)
+		})
+		JustBeforeEach(func() {
+			err = sqlStore.CreateStorageAccountOwner(ownerID, owner)
+		})
+		It("should not error and call INSERT INTO on the db", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+	})
+
+	Describe("CreateFileShareOwner", func() {
+		var (
+			ownerID string
+			owner   azurefilebroker.FileShareOwner
+		)
+
+		BeforeEach(func() {
+			ownerID = "subscription-resourcegroup-account-share"
+			owner = azurefilebroker.FileShareOwner{OwnerFileShareID: "instance-share", FileShareIDs: []string{"instance-share"}}
+			jsonValue, err := json.Marshal(owner)
+			Expect(err).NotTo(HaveOccurred())
+
+			result := sqlmock.NewResult(1, 1)
+			mock.ExpectExec("INSERT INTO file_share_owners").WithArgs(ownerID, jsonValue).WillReturnResult(result)
+		})
+		JustBeforeEach(func() {
+			err = sqlStore.CreateFileShareOwner(ownerID, owner)
+		})
+		It("should not error and call INSERT INTO on the db", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+	})
+
+	Describe("CreateScheduledDeletion", func() {
+		var deletion azurefilebroker.ScheduledDeletion
+
+		BeforeEach(func() {
+			deletion = azurefilebroker.ScheduledDeletion{Kind: "storage-account", ServiceInstance: azurefilebroker.ServiceInstance{TargetName: "account"}}
+			jsonValue, err := json.Marshal(deletion)
+			Expect(err).NotTo(HaveOccurred())
+
+			result := sqlmock.NewResult(1, 1)
+			mock.ExpectExec("INSERT INTO scheduled_deletions").WithArgs("id", jsonValue).WillReturnResult(result)
+		})
+		JustBeforeEach(func() {
+			err = sqlStore.CreateScheduledDeletion("id", deletion)
+		})
+		It("should not error and call INSERT INTO on the db", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+	})
+
+	Describe("RetrieveFeatureFlags", func() {
+		var flags map[string]azurefilebroker.FeatureFlag
+
+		BeforeEach(func() {
+			rows := sqlmock.NewRows([]string{"id", "value"})
+			jsonvalue, err := json.Marshal(azurefilebroker.FeatureFlag{Enabled: true})
+			Expect(err).NotTo(HaveOccurred())
+			rows.AddRow(azurefilebroker.FeatureFlagAllowCreateFileShare, jsonvalue)
+
+			mock.ExpectQuery("SELECT id, value FROM feature_flags").WillReturnRows(rows)
+		})
+		JustBeforeEach(func() {
+			flags, err = sqlStore.RetrieveFeatureFlags()
+		})
+		It("should return the feature flags", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			Expect(flags).To(HaveLen(1))
+			Expect(flags[azurefilebroker.FeatureFlagAllowCreateFileShare].Enabled).To(BeTrue())
+		})
+	})
+
+	Describe("RetrieveLockHolders", func() {
+		var holders map[string]azurefilebroker.LockHolder
+
+		BeforeEach(func() {
+			rows := sqlmock.NewRows([]string{"id", "value"})
+			jsonvalue, err := json.Marshal(azurefilebroker.LockHolder{Owner: "broker-1"})
+			Expect(err).NotTo(HaveOccurred())
+			rows.AddRow("lock", jsonvalue)
+
+			mock.ExpectQuery("SELECT id, value FROM lock_holders").WillReturnRows(rows)
+		})
+		JustBeforeEach(func() {
+			holders, err = sqlStore.RetrieveLockHolders()
+		})
+		It("should return the lock holders", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			Expect(holders).To(HaveLen(1))
+			Expect(holders["lock"].Owner).To(Equal("broker-1"))
+		})
+	})
+
+	Describe("BreakLock", func() {
+		var session string
+
+		JustBeforeEach(func() {
+			session, err = sqlStore.BreakLock("lock")
+		})
+
+		Context("when the lock is held", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("fakelocksession").WithArgs("lock").WillReturnRows(sqlmock.NewRows([]string{"session"}).AddRow("53"))
+				mock.ExpectExec("fakekill 53").WillReturnResult(sqlmock.NewResult(0, 0))
+			})
+			It("should end the session which holds the lock", func() {
+				Expect(err).To(BeNil())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+				Expect(session).To(Equal("53"))
+			})
+		})
+
+		Context("when the lock is free", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("fakelocksession").WithArgs("lock").WillReturnRows(sqlmock.NewRows([]string{"session"}).AddRow(nil))
+			})
+			It("should not end any session", func() {
+				Expect(err).To(BeNil())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+				Expect(session).To(BeEmpty())
+			})
+		})
+
+		Context("when the session is not a number", func() {
+			BeforeEach(func() {
+				mock.ExpectQuery("fakelocksession").WithArgs("lock").WillReturnRows(sqlmock.NewRows([]string{"session"}).AddRow("1; DROP TABLE lock_holders"))
+			})
+			It("should not end the session", func() {
+				Expect(err).To(MatchError(`Cannot end the session "1; DROP TABLE lock_holders" which holds the lock "lock" because it is not a number`))
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			})
+		})
+	})
+
+	Describe("RetrievePendingShareDeletions", func() {
+		var deletions map[string]azurefilebroker.PendingShareDeletion
+
+		BeforeEach(func() {
+			rows := sqlmock.NewRows([]string{"id", "value"})
+			jsonvalue, err := json.Marshal(azurefilebroker.PendingShareDeletion{FileShareID: "instance-share", FileShareName: "share", Attempts: 2})
+			Expect(err).NotTo(HaveOccurred())
+			rows.AddRow("instance-share", jsonvalue)
+
+			mock.ExpectQuery("SELECT id, value FROM pending_share_deletions").WillReturnRows(rows)
+		})
+		JustBeforeEach(func() {
+			deletions, err = sqlStore.RetrievePendingShareDeletions()
+		})
+		It("should return the pending share deletions", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			Expect(deletions).To(HaveLen(1))
+			Expect(deletions["instance-share"].Attempts).To(Equal(2))
+		})
+	})
+
+	Describe("RetrievePooledStorageAccounts", func() {
+		var accounts map[string]azurefilebroker.PooledStorageAccount
+
+		BeforeEach(func() {
+			rows := sqlmock.NewRows([]string{"id", "value"})
+			jsonvalue, err := json.Marshal(azurefilebroker.PooledStorageAccount{StorageAccountName: "afbpool0123456789abcdef", Location: "westeurope", State: "ready"})
+			Expect(err).NotTo(HaveOccurred())
+			rows.AddRow("afbpool0123456789abcdef", jsonvalue)
+
+			mock.ExpectQuery("SELECT id, value FROM pooled_storage_accounts").WillReturnRows(rows)
+		})
+		JustBeforeEach(func() {
+			accounts, err = sqlStore.RetrievePooledStorageAccounts()
+		})
+		It("should return the pooled storage accounts", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			Expect(accounts).To(HaveLen(1))
+			Expect(accounts["afbpool0123456789abcdef"].Location).To(Equal("westeurope"))
+		})
+	})
+
+	Describe("RetrieveInstanceOperations", func() {
+		var operations []azurefilebroker.InstanceOperation
+
+		BeforeEach(func() {
+			rows := sqlmock.NewRows([]string{"id", "value"})
+			jsonvalue, err := json.Marshal(azurefilebroker.InstanceOperation{InstanceID: "instance_123", Operation: "provision", Result: "succeeded"})
+			Expect(err).NotTo(HaveOccurred())
+			rows.AddRow("instance_123-1-00000000", jsonvalue)
+
+			mock.ExpectQuery("SELECT id, value FROM instance_operations WHERE instance_id = ?").WithArgs("instance_123").WillReturnRows(rows)
+		})
+		JustBeforeEach(func() {
+			operations, err = sqlStore.RetrieveInstanceOperations("instance_123")
+		})
+		It("should return the operations of the instance", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			Expect(operations).To(HaveLen(1))
+			Expect(operations[0].Operation).To(Equal("provision"))
+		})
+	})
+
+	Describe("EnsureSchema", func() {
+		Context("when the creation is skipped", func() {
+			It("should probe that the tables can be read and written", func() {
+				for i := 0; i < 12; i++ {
+					mock.ExpectQuery(`SELECT 1 FROM \w+ WHERE 1 = 0`).WillReturnRows(sqlmock.NewRows([]string{"1"}))
+					mock.ExpectExec(`INSERT INTO (\w+) \(id\) SELECT id FROM \w+ WHERE 1 = 0`).WillReturnResult(sqlmock.NewResult(0, 0))
+					mock.ExpectExec(`UPDATE \w+ SET value = value WHERE 1 = 0`).WillReturnResult(sqlmock.NewResult(0, 0))
+					mock.ExpectExec(`DELETE FROM \w+ WHERE 1 = 0`).WillReturnResult(sqlmock.NewResult(0, 0))
+				}
+				err = sqlStore.EnsureSchema(lagertest.NewTestLogger("test-broker"), true)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			})
+
+			It("should report the missing tables and the denied statements", func() {
+				mock.ExpectQuery("SELECT 1 FROM service_instances WHERE 1 = 0").WillReturnError(errors.New("Invalid object name"))
+				mock.ExpectQuery("SELECT 1 FROM service_bindings WHERE 1 = 0").WillReturnRows(sqlmock.NewRows([]string{"1"}))
+				mock.ExpectExec("INSERT INTO service_bindings").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("UPDATE service_bindings").WillReturnError(errors.New("UPDATE permission denied"))
+				err = sqlStore.EnsureSchema(lagertest.NewTestLogger("test-broker"), true)
+				Expect(err).To(MatchError(ContainSubstring("the table service_instances is missing or cannot be read: Invalid object name; ")))
+				Expect(err).To(MatchError(ContainSubstring("UPDATE on the table service_bindings failed: UPDATE permission denied")))
+			})
+		})
+
+		It("should return the statement which failed", func() {
+			fakeVariant.GetInitializeDatabaseSQLReturns([]string{"CREATE TABLE service_instances(...)", "CREATE TABLE service_bindings(...)"})
+			fakeSqlDb.ExecReturnsOnCall(fakeSqlDb.ExecCallCount()+1, nil, errors.New("permission denied"))
+			_, err = azurefilebroker.NewStoreWithVariant(lagertest.NewTestLogger("test-broker"), storeType, fakeVariant)
+			Expect(err).To(MatchError("Failed to execute the statement 2 of 2 of the schema of the store: permission denied"))
+		})
+	})
+
+	Describe("EnsureSchemaVersion", func() {
+		Context("when no version is recorded", func() {
+			It("should record the version of the broker", func() {
+				mock.ExpectQuery("SELECT value FROM schema_versions WHERE id = ?").WithArgs("schema").WillReturnError(sql.ErrNoRows)
+				mock.ExpectExec("INSERT INTO schema_versions [(]id, value[)] VALUES [(][?], [?][)]").WithArgs("schema", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				err = sqlStore.EnsureSchemaVersion(lagertest.NewTestLogger("test-broker"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			})
+		})
+
+		Context("when a newer broker still supports this one", func() {
+			It("should keep the recorded version", func() {
+				rows := sqlmock.NewRows([]string{"value"}).AddRow([]byte(`{"version":3,"min_broker_version":2,"broker_version":"2.0.0"}`))
+				mock.ExpectQuery("SELECT value FROM schema_versions WHERE id = ?").WithArgs("schema").WillReturnRows(rows)
+				err = sqlStore.EnsureSchemaVersion(lagertest.NewTestLogger("test-broker"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			})
+		})
+
+		Context("when a newer broker no longer supports this one", func() {
+			It("should refuse to start", func() {
+				rows := sqlmock.NewRows([]string{"value"}).AddRow([]byte(`{"version":4,"min_broker_version":3,"broker_version":"3.0.0"}`))
+				mock.ExpectQuery("SELECT value FROM schema_versions WHERE id = ?").WithArgs("schema").WillReturnRows(rows)
+				err = sqlStore.EnsureSchemaVersion(lagertest.NewTestLogger("test-broker"))
+				Expect(err).To(MatchError(ContainSubstring("requires brokers of compatibility version 3 or later")))
+			})
+		})
+	})
+
+	Describe("UpdateFeatureFlag", func() {
+		var flag azurefilebroker.FeatureFlag
+
+		BeforeEach(func() {
+			flag = azurefilebroker.FeatureFlag{Enabled: false}
+			jsonValue, err := json.Marshal(flag)
+			Expect(err).NotTo(HaveOccurred())
+			mock.ExpectExec("UPDATE feature_flags set value = [?] WHERE id = [?]").WithArgs(jsonValue, "id").WillReturnResult(sqlmock.NewResult(0, 0))
+		})
+		JustBeforeEach(func() {
+			err = sqlStore.UpdateFeatureFlag("id", flag)
+		})
+		It("should return an error when no row is updated", func() {
+			Expect(err).To(MatchError("Cannot update the feature flag in the database"))
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+	})
+
 	Describe("DeleteServiceInstance", func() {
 		BeforeEach(func() {
 			Expect(err).NotTo(HaveOccurred())
@@ -338,6 +911,110 @@ var _ = Describe("Store", func() {
 		})
 	})
 
+	Describe("DeleteStorageAccountOwner", func() {
+		BeforeEach(func() {
+			result := sqlmock.NewResult(1, 1)
+			mock.ExpectExec("DELETE FROM storage_account_owners WHERE id = ?").WithArgs("subscription-resourcegroup-account").WillReturnResult(result)
+		})
+		JustBeforeEach(func() {
+			err = sqlStore.DeleteStorageAccountOwner("subscription-resourcegroup-account")
+		})
+		It("should not error and call DELETE FROM on the db", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+	})
+
+	Describe("DeleteFileShareOwner", func() {
+		BeforeEach(func() {
+			result := sqlmock.NewResult(1, 1)
+			mock.ExpectExec("DELETE FROM file_share_owners WHERE id = ?").WithArgs("subscription-resourcegroup-account-share").WillReturnResult(result)
+		})
+		JustBeforeEach(func() {
+			err = sqlStore.DeleteFileShareOwner("subscription-resourcegroup-account-share")
+		})
+		It("should not error and call DELETE FROM on the db", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+	})
+
+	Describe("DeleteScheduledDeletion", func() {
+		BeforeEach(func() {
+			result := sqlmock.NewResult(1, 1)
+			mock.ExpectExec("DELETE FROM scheduled_deletions WHERE id = ?").WithArgs("id").WillReturnResult(result)
+		})
+		JustBeforeEach(func() {
+			err = sqlStore.DeleteScheduledDeletion("id")
+		})
+		It("should not error and call DELETE FROM on the db", func() {
+			Expect(err).To(BeNil())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+		})
+	})
+
+	Describe("UpdateServiceInstance", func() {
+		BeforeEach(func() {
+			Expect(err).NotTo(HaveOccurred())
+			instanceID = "instance_123"
+			serviceInstance = azurefilebroker.ServiceInstance{TargetName: "target_123", ProvisioningState: "succeeded"}
+		})
+		JustBeforeEach(func() {
+			err = sqlStore.UpdateServiceInstance(instanceID, serviceInstance)
+		})
+
+		Context("when the instance exists", func() {
+			BeforeEach(func() {
+				jsonValue, err := json.Marshal(serviceInstance)
+				Expect(err).NotTo(HaveOccurred())
+
+				result := sqlmock.NewResult(0, 1)
+				mock.ExpectExec("UPDATE service_instances").WithArgs(jsonValue, instanceID).WillReturnResult(result)
+			})
+			It("should not error and call UPDATE on the db", func() {
+				Expect(err).To(BeNil())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			})
+		})
+
+		Context("when the instance does not exist", func() {
+			BeforeEach(func() {
+				jsonValue, err := json.Marshal(serviceInstance)
+				Expect(err).NotTo(HaveOccurred())
+
+				result := sqlmock.NewResult(0, 0)
+				mock.ExpectExec("UPDATE service_instances").WithArgs(jsonValue, instanceID).WillReturnResult(result)
+			})
+			It("should return an error", func() {
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("when the instance is at the size limit of the store", func() {
+			BeforeEach(func() {
+				jsonValue := fillToStoreValueSize(&serviceInstance)
+				mock.ExpectExec("UPDATE service_instances").WithArgs(jsonValue, instanceID).WillReturnResult(sqlmock.NewResult(0, 1))
+			})
+
+			It("should write the instance", func() {
+				Expect(err).To(BeNil())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			})
+		})
+
+		Context("when the instance is one byte larger than the size limit of the store", func() {
+			BeforeEach(func() {
+				fillToStoreValueSize(&serviceInstance)
+				serviceInstance.ProvisioningState += "s"
+			})
+
+			It("should return an error and not write the instance", func() {
+				Expect(err).To(MatchError(fmt.Sprintf(`The record "instance_123" of the table service_instances is %d bytes, which is more than the %d bytes which the store keeps`, azurefilebroker.MaxStoreValueSize+1, azurefilebroker.MaxStoreValueSize)))
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			})
+		})
+	})
+
 	Describe("UpdateFileShare", func() {
 		Context("when the file share exists", func() {
 			BeforeEach(func() {
@@ -447,6 +1124,221 @@ var _ = Describe("Store", func() {
 
 	})
 
+	Describe("StoreEncryptionConfig", func() {
+		It("parses the keys, the current one first", func() {
+			config := azurefilebroker.NewStoreEncryptionConfig(storeEncryptionKey("key2", 2) + ", " + storeEncryptionKey("key1", 1))
+			Expect(config.Validate()).To(Succeed())
+			Expect(config.IsEnabled()).To(BeTrue())
+			Expect(config.KeyIDs()).To(Equal([]string{"key2", "key1"}))
+		})
+
+		It("is disabled without keys", func() {
+			config := azurefilebroker.NewStoreEncryptionConfig("")
+			Expect(config.Validate()).To(Succeed())
+			Expect(config.IsEnabled()).To(BeFalse())
+		})
+
+		It("rejects an entry which is not base64 without its key in the error", func() {
+			config := azurefilebroker.NewStoreEncryptionConfig(storeEncryptionKey("key1", 1) + ",key2:not-base64!")
+			Expect(config.Validate()).To(MatchError(ContainSubstring("Invalid entries 2 in STORE_ENCRYPTION_KEYS")))
+			Expect(config.Validate().Error()).NotTo(ContainSubstring("not-base64"))
+		})
+
+		It("rejects a key which is not 32 bytes", func() {
+			config := azurefilebroker.NewStoreEncryptionConfig("key1:" + base64.StdEncoding.EncodeToString([]byte("short")))
+			Expect(config.Validate()).To(MatchError(ContainSubstring("The key \"key1\" in STORE_ENCRYPTION_KEYS is 5 bytes")))
+		})
+
+		It("rejects an ID which is used twice", func() {
+			config := azurefilebroker.NewStoreEncryptionConfig(storeEncryptionKey("key1", 1) + "," + storeEncryptionKey("key1", 2))
+			Expect(config.Validate()).To(MatchError(ContainSubstring("is used by more than one key")))
+		})
+
+		It("rejects an invalid ID", func() {
+			config := azurefilebroker.NewStoreEncryptionConfig(storeEncryptionKey("key 1", 1))
+			Expect(config.Validate()).To(MatchError(ContainSubstring("Invalid key ID")))
+		})
+	})
+
+	Describe("encryption", func() {
+		var (
+			flag          azurefilebroker.FeatureFlag
+			sealed        *storeValueArgument
+			sealedWithKey func(keys, id string) []byte
+		)
+
+		BeforeEach(func() {
+			flag = azurefilebroker.FeatureFlag{Enabled: true}
+			sealed = &storeValueArgument{}
+			sealedWithKey = func(keys, id string) []byte {
+				store := azurefilebroker.SqlStore{StoreType: storeType, Database: sqlStore.Database}
+				Expect(store.SetEncryption(azurefilebroker.NewStoreEncryptionConfig(keys))).To(Succeed())
+				value := &storeValueArgument{}
+				mock.ExpectExec("INSERT INTO feature_flags").WithArgs(id, value).WillReturnResult(sqlmock.NewResult(1, 1))
+				Expect(store.CreateFeatureFlag(id, flag)).To(Succeed())
+				return value.value
+			}
+		})
+
+		Context("when the store has keys", func() {
+			BeforeEach(func() {
+				Expect(sqlStore.SetEncryption(azurefilebroker.NewStoreEncryptionConfig(storeEncryptionKey("key2", 2) + "," + storeEncryptionKey("key1", 1)))).To(Succeed())
+			})
+
+			It("writes the records encrypted with the current key", func() {
+				mock.ExpectExec("INSERT INTO feature_flags").WithArgs("flag", sealed).WillReturnResult(sqlmock.NewResult(1, 1))
+				Expect(sqlStore.CreateFeatureFlag("flag", flag)).To(Succeed())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+				Expect(string(sealed.value)).To(HavePrefix("enc:v1:key2:"))
+				Expect(string(sealed.value)).NotTo(ContainSubstring("enabled"))
+			})
+
+			It("reads the records which are encrypted with any of its keys or not encrypted", func() {
+				plain, err := json.Marshal(flag)
+				Expect(err).NotTo(HaveOccurred())
+				rows := sqlmock.NewRows([]string{"id", "value"}).
+					AddRow("new", sealedWithKey(storeEncryptionKey("key2", 2), "new")).
+					AddRow("old", sealedWithKey(storeEncryptionKey("key1", 1), "old")).
+					AddRow("plain", plain)
+				mock.ExpectQuery("SELECT id, value FROM feature_flags").WillReturnRows(rows)
+
+				flags, err := sqlStore.RetrieveFeatureFlags()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(flags).To(Equal(map[string]azurefilebroker.FeatureFlag{"new": flag, "old": flag, "plain": flag}))
+			})
+
+			It("rejects a value which was copied from another record", func() {
+				rows := sqlmock.NewRows([]string{"id", "value"}).AddRow("copy", sealedWithKey(storeEncryptionKey("key2", 2), "original"))
+				mock.ExpectQuery("SELECT id, value FROM feature_flags").WillReturnRows(rows)
+
+				_, err := sqlStore.RetrieveFeatureFlags()
+				Expect(err).To(MatchError(ContainSubstring("Failed to decrypt the record \"copy\" of the table feature_flags with the key \"key2\"")))
+			})
+
+			It("rejects a value whose key is not configured", func() {
+				rows := sqlmock.NewRows([]string{"id", "value"}).AddRow("flag", sealedWithKey(storeEncryptionKey("key0", 9), "flag"))
+				mock.ExpectQuery("SELECT id, value FROM feature_flags").WillReturnRows(rows)
+
+				_, err := sqlStore.RetrieveFeatureFlags()
+				Expect(err).To(MatchError(ContainSubstring("is encrypted with the key \"key0\", which is not in STORE_ENCRYPTION_KEYS")))
+			})
+
+			It("checks the size of the encrypted record", func() {
+				serviceInstance = azurefilebroker.ServiceInstance{ServiceID: serviceID}
+				fillToStoreValueSize(&serviceInstance)
+				err = sqlStore.CreateServiceInstance("instance", serviceInstance)
+				Expect(err).To(MatchError(ContainSubstring("which is more than the")))
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			})
+		})
+
+		Context("when the store has no keys", func() {
+			It("fails to read the encrypted records", func() {
+				rows := sqlmock.NewRows([]string{"id", "value"}).AddRow("flag", sealedWithKey(storeEncryptionKey("key1", 1), "flag"))
+				mock.ExpectQuery("SELECT id, value FROM feature_flags").WillReturnRows(rows)
+
+				_, err := sqlStore.RetrieveFeatureFlags()
+				Expect(err).To(MatchError(ContainSubstring("is encrypted, but STORE_ENCRYPTION_KEYS is not set")))
+			})
+
+			It("does not re-encrypt", func() {
+				_, err := sqlStore.ReEncrypt(lagertest.NewTestLogger("test-broker"), false)
+				Expect(err).To(MatchError(ContainSubstring("The store is not encrypted")))
+			})
+		})
+	})
+
+	Describe("ReEncrypt", func() {
+		var (
+			report       azurefilebroker.StoreReEncryptionReport
+			dryRun       bool
+			plain, old   []byte
+			current      []byte
+			updatedValue *storeValueArgument
+			updateResult driver.Result
+		)
+
+		BeforeEach(func() {
+			dryRun = false
+			updatedValue = &storeValueArgument{}
+			updateResult = sqlmock.NewResult(0, 1)
+
+			flag := azurefilebroker.FeatureFlag{Enabled: true}
+			plain, err = json.Marshal(flag)
+			Expect(err).NotTo(HaveOccurred())
+			seal := func(keys, id string) []byte {
+				Expect(sqlStore.SetEncryption(azurefilebroker.NewStoreEncryptionConfig(keys))).To(Succeed())
+				value := &storeValueArgument{}
+				mock.ExpectExec("INSERT INTO feature_flags").WithArgs(id, value).WillReturnResult(sqlmock.NewResult(1, 1))
+				Expect(sqlStore.CreateFeatureFlag(id, flag)).To(Succeed())
+				return value.value
+			}
+			old = seal(storeEncryptionKey("key1", 1), "old")
+			current = seal(storeEncryptionKey("key2", 2), "current")
+			Expect(sqlStore.SetEncryption(azurefilebroker.NewStoreEncryptionConfig(storeEncryptionKey("key2", 2) + "," + storeEncryptionKey("key1", 1)))).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			for _, table := range []string{"service_instances", "service_bindings", "file_shares", "storage_account_owners", "file_share_owners", "scheduled_deletions"} {
+				mock.ExpectQuery("SELECT id, value FROM " + table).WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+			}
+			rows := sqlmock.NewRows([]string{"id", "value"}).AddRow("plain", plain).AddRow("old", old).AddRow("current", current)
+			mock.ExpectQuery("SELECT id, value FROM feature_flags").WillReturnRows(rows)
+			if !dryRun {
+				mock.ExpectExec("UPDATE feature_flags set value = [?] WHERE id = [?] AND value = [?]").WithArgs(updatedValue, "plain", plain).WillReturnResult(updateResult)
+				mock.ExpectExec("UPDATE feature_flags set value = [?] WHERE id = [?] AND value = [?]").WithArgs(updatedValue, "old", old).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			for _, table := range []string{"pending_share_deletions", "pooled_storage_accounts", "instance_operations", "lock_holders"} {
+				mock.ExpectQuery("SELECT id, value FROM " + table).WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+			}
+
+			report, err = sqlStore.ReEncrypt(lagertest.NewTestLogger("test-broker"), dryRun)
+		})
+
+		It("rewrites the records which are not encrypted with the current key", func() {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mock.ExpectationsWereMet()).Should(Succeed())
+			Expect(report.KeyID).To(Equal("key2"))
+			Expect(report.ReEncrypted).To(Equal([]string{"feature_flags/plain", "feature_flags/old"}))
+			Expect(report.Unchanged).To(Equal(1))
+			Expect(report.Errors).To(BeEmpty())
+			Expect(string(updatedValue.value)).To(HavePrefix("enc:v1:key2:"))
+
+			rows := sqlmock.NewRows([]string{"id", "value"}).AddRow("old", updatedValue.value)
+			mock.ExpectQuery("SELECT id, value FROM feature_flags").WillReturnRows(rows)
+			Expect(sqlStore.SetEncryption(azurefilebroker.NewStoreEncryptionConfig(storeEncryptionKey("key2", 2)))).To(Succeed())
+			flags, err := sqlStore.RetrieveFeatureFlags()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(flags["old"].Enabled).To(BeTrue())
+		})
+
+		Context("when it is a dry run", func() {
+			BeforeEach(func() {
+				dryRun = true
+			})
+
+			It("only lists the records", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+				Expect(report.DryRun).To(BeTrue())
+				Expect(report.ReEncrypted).To(Equal([]string{"feature_flags/plain", "feature_flags/old"}))
+			})
+		})
+
+		Context("when a record is updated by a broker meanwhile", func() {
+			BeforeEach(func() {
+				updateResult = sqlmock.NewResult(0, 0)
+			})
+
+			It("reports the record", func() {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mock.ExpectationsWereMet()).Should(Succeed())
+				Expect(report.ReEncrypted).To(Equal([]string{"feature_flags/old"}))
+				Expect(report.Errors).To(ConsistOf(ContainSubstring("feature_flags/plain: The record was updated or deleted during the re-encryption")))
+			})
+		})
+	})
+
 	Describe("ReleaseLockForUpdate", func() {
 		var (
 			lockName string
@@ -486,3 +1378,30 @@ var _ = Describe("Store", func() {
 
 	})
 })
+
+// storeValueArgument matches any value of a statement and keeps it
+type storeValueArgument struct {
+	value []byte
+}
+
+func (a *storeValueArgument) Match(v driver.Value) bool {
+	value, ok := v.([]byte)
+	a.value = value
+	return ok
+}
+
+func storeEncryptionKey(id string, b byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// fillToStoreValueSize pads the provisioning state of the instance so that its JSON is MaxStoreValueSize bytes
+func fillToStoreValueSize(serviceInstance *azurefilebroker.ServiceInstance) []byte {
+	serviceInstance.ProvisioningState = ""
+	jsonValue, err := json.Marshal(serviceInstance)
+	Expect(err).NotTo(HaveOccurred())
+	serviceInstance.ProvisioningState = strings.Repeat("s", azurefilebroker.MaxStoreValueSize-len(jsonValue))
+	jsonValue, err = json.Marshal(serviceInstance)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(jsonValue).To(HaveLen(azurefilebroker.MaxStoreValueSize))
+	return jsonValue
+}
@@ -0,0 +1,109 @@
+package azurefilebroker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	resty "gopkg.in/resty.v0"
+)
+
+// The operations which are validated by the validation webhook
+const (
+	ValidationOperationProvision = "provision"
+	ValidationOperationBind      = "bind"
+)
+
+// ValidationRequest is the request of a provision or bind which is sent to the validation webhook
+type ValidationRequest struct {
+	Operation        string          `json:"operation"`
+	InstanceID       string          `json:"service_instance_id"`
+	BindingID        string          `json:"service_binding_id,omitempty"`
+	ServiceID        string          `json:"service_id"`
+	PlanID           string          `json:"plan_id"`
+	OrganizationGUID string          `json:"organization_guid,omitempty"`
+	SpaceGUID        string          `json:"space_guid,omitempty"`
+	AppGUID          string          `json:"app_guid,omitempty"`
+	Parameters       json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ValidationResponse is the decision of the validation webhook. The request is denied with the reason unless it is
+// allowed. The parameters replace the parameters of the request if they are set.
+type ValidationResponse struct {
+	Allowed    bool            `json:"allowed"`
+	Reason     string          `json:"reason,omitempty"`
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+}
+
+//go:generate counterfeiter -o ../azurefilebrokerfakes/fake_validation_webhook.go . ValidationWebhook
+type ValidationWebhook interface {
+	Validate(request ValidationRequest) (ValidationResponse, error)
+}
+
+type httpValidationWebhook struct {
+	url   string
+	token string
+}
+
+// NewHTTPValidationWebhook returns a webhook which POSTs every request as JSON to the url and reads the decision from
+// the response. The token is sent as a bearer token if it is not empty.
+func NewHTTPValidationWebhook(url, token string) ValidationWebhook {
+	return &httpValidationWebhook{url: url, token: token}
+}
+
+func (v *httpValidationWebhook) Validate(validationRequest ValidationRequest) (ValidationResponse, error) {
+	body, err := json.Marshal(validationRequest)
+	if err != nil {
+		return ValidationResponse{}, err
+	}
+	request := resty.R().
+		SetHeader("Content-Type", contentTypeJSON).
+		SetBody(body)
+	if v.token != "" {
+		request.SetAuthToken(v.token)
+	}
+	resp, err := request.Post(v.url)
+	if err != nil {
+		return ValidationResponse{}, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return ValidationResponse{}, fmt.Errorf("Error Code: %d, %v", resp.StatusCode(), resp)
+	}
+
+	var response ValidationResponse
+	if err := json.Unmarshal(resp.Body(), &response); err != nil {
+		return ValidationResponse{}, err
+	}
+	return response, nil
+}
+
+// SetValidationWebhook enables the validation of the provision and bind requests
+func (b *Broker) SetValidationWebhook(webhook ValidationWebhook) {
+	b.validation = webhook
+}
+
+// validate asks the validation webhook whether the request is allowed and returns the parameters to use, which are the
+// parameters of the request unless the webhook replaces them. The request is denied when the webhook fails so that
+// the policy cannot be bypassed by making the webhook unavailable.
+func (b *Broker) validate(logger lager.Logger, request ValidationRequest) (json.RawMessage, error) {
+	if b.validation == nil {
+		return request.Parameters, nil
+	}
+	logger = logger.Session("validate")
+
+	response, err := b.validation.Validate(request)
+	if err != nil {
+		logger.Error("validation-webhook", err)
+		return nil, newBrokerError(ErrCodeValidationUnavailable, "Failed to validate the %s request: %v", request.Operation, err)
+	}
+	if !response.Allowed {
+		logger.Info("denied", lager.Data{"reason": response.Reason})
+		return nil, newBrokerError(ErrCodeRequestDenied, "The %s request is denied by the policy of the administrator: %s", request.Operation, response.Reason)
+	}
+	if len(response.Parameters) > 0 {
+		logger.Info("parameters-mutated")
+		return response.Parameters, nil
+	}
+	return request.Parameters, nil
+}
@@ -0,0 +1,1029 @@
+package azurefilebrokerfakes
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"github.com/Azure/azure-sdk-for-go/arm/storage"
+)
+
+const (
+	fakeAzureFileEndpointSuffix = "core.windows.net"
+	fakeAzureOperationURLPrefix = "https://management.fake.azure/operations/"
+	// The default limit of the storage accounts of a subscription in a region
+	fakeAzureStorageAccountLimit = 250
+	// The quota of a new file share in GiB
+	fakeAzureFileShareQuota = 5120
+	// The most stored access policies of a file share
+	fakeAzureMaxAccessPolicies = 5
+	// The metadata of the file shares with the creator, like the broker sets it
+	fakeAzureCreatorMetadata = "creator"
+)
+
+// FakeAzureFailures are the errors of Azure which can be injected by name, in the format of the errors of the clients
+// of the broker so that they are classified like the real ones
+var FakeAzureFailures = map[string]error{
+	"throttled":            errors.New("Error Code: 429, TooManyRequests: The request is being throttled"),
+	"internal-error":       errors.New("Error Code: 500, InternalError: The server encountered an internal error. Please retry the request."),
+	"server-busy":          errors.New("Error Code: 503, ServerBusy: The server is currently unable to receive requests. Please retry your request."),
+	"authorization-failed": errors.New("Error Code: 403, AuthorizationFailed: The client does not have authorization to perform the action"),
+	"quota-exceeded":       errors.New("Error Code: 409, StorageAccountQuotaExceeded: The subscription has reached the limit of storage accounts"),
+	"conflict":             errors.New("Error Code: 409, AnotherOperationInProgress: Another operation is in progress on the resource"),
+}
+
+// fakeAzureOperations are the operations which can fail, named after the methods of the clients
+var fakeAzureOperations = []string{
+	"Exists", "GetAccessKey", "DeleteStorageAccount", "SetStorageAccountTags", "HasFileShare", "ListFileShares",
+	"CreateFileShare", "DeleteFileShare", "SetFileShareMetadata", "CreateDirectories", "GetShareURL", "VerifyShareSAS",
+	"ListFilesAndDirectories", "ListDirectory", "CopyFile", "GetFileCopyStatus", "CreateStorageAccount", "CheckCompletion",
+	"UpdateStorageAccount",
+	"SubscriptionExists", "GetStorageAccountUsage", "IsSkuAvailable", "ListPermissions", "ResourceGroupExists",
+	"GetFileShareStats", "GetFileShareAccessPolicies", "SetFileShareAccessPolicies", "GetFileShareSAS",
+	"GetFileShareReadSAS", "RefreshBackupContainers", "RegisterBackupContainer", "InquireBackupItems",
+	"ProtectFileShare", "CreateMetricAlert", "DeleteMetricAlert", "CreateDiagnosticSetting", "DeleteDiagnosticSetting",
+}
+
+var fakeAzureStorageAccountName = regexp.MustCompile(`^[a-z0-9]{3,24}$`)
+
+// FakeAzure is a stateful in-memory Azure for the acceptance tests and the demo environments without access to a
+// cloud. It keeps the resource groups, the storage accounts with their keys and tags, and the file shares with their
+// metadata, directories, files and access policies, and fails like Azure, e.g. when a storage account name is taken
+// in another resource group or a share already exists. Failures can be injected into every operation.
+type FakeAzure struct {
+	mutex sync.Mutex
+	clock clock.Clock
+
+	// resourceGroups are keyed by the subscription and the resource group in lower case
+	resourceGroups map[string]bool
+	// storageAccounts are keyed by their names, which are unique in Azure
+	storageAccounts     map[string]*fakeStorageAccount
+	storageAccountLimit int
+	operationPolls      int
+	operations          map[string]*fakeOperation
+	nextOperationID     int
+	sasTokens           map[string]fakeSASToken
+	failures            map[string]*fakeFailure
+	random              *mathrand.Rand
+}
+
+type fakeStorageAccount struct {
+	subscriptionID    string
+	resourceGroupName string
+	location          string
+	skuName           storage.SkuName
+	useHTTPS          bool
+	tags              map[string]string
+	keys              []string
+	fileShares        map[string]*fakeFileShare
+	alerts            map[string]azurefilebroker.StorageAccountAlert
+	diagnostics       map[string]azurefilebroker.AccessLogDestination
+	// creating is true until the operation which creates the storage account completes
+	creating bool
+}
+
+type fakeFileShare struct {
+	metadata    map[string]string
+	directories map[string]bool
+	// files are the copy statuses of the files by path
+	files      map[string]string
+	policies   []azurefilebroker.ShareAccessPolicy
+	usageBytes int64
+	quotaGiB   int
+}
+
+// fakeOperation is an asynchronous operation which completes after its polls
+type fakeOperation struct {
+	polls    int
+	complete func()
+}
+
+type fakeSASToken struct {
+	storageAccountName string
+	fileShareName      string
+	policyID           string
+	expiry             time.Time
+}
+
+type fakeFailure struct {
+	err   error
+	count int
+	rate  float64
+}
+
+func NewFakeAzure(clock clock.Clock) *FakeAzure {
+	return &FakeAzure{
+		clock:               clock,
+		resourceGroups:      map[string]bool{},
+		storageAccounts:     map[string]*fakeStorageAccount{},
+		storageAccountLimit: fakeAzureStorageAccountLimit,
+		operations:          map[string]*fakeOperation{},
+		sasTokens:           map[string]fakeSASToken{},
+		failures:            map[string]*fakeFailure{},
+		random:              mathrand.New(mathrand.NewSource(clock.Now().UnixNano())),
+	}
+}
+
+// AddResourceGroup creates the resource group, and the subscription with it
+func (f *FakeAzure) AddResourceGroup(subscriptionID, resourceGroupName string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.resourceGroups[resourceGroupKey(subscriptionID, resourceGroupName)] = true
+}
+
+// AddStorageAccount creates a storage account which existed before the broker, with its resource group
+func (f *FakeAzure) AddStorageAccount(subscriptionID, resourceGroupName, storageAccountName, location string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.resourceGroups[resourceGroupKey(subscriptionID, resourceGroupName)] = true
+	f.storageAccounts[storageAccountName] = newFakeStorageAccount(subscriptionID, resourceGroupName, location, storage.StandardRAGRS, map[string]string{})
+}
+
+// AddFileShare creates a file share out-of-band in the storage account of the resource group, e.g. by a user who reuses
+// the storage account or by an older version of the broker. creator is the creator metadata of the share, which is not
+// set if it is empty.
+func (f *FakeAzure) AddFileShare(subscriptionID, resourceGroupName, storageAccountName, fileShareName, creator string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	account := f.lookup(subscriptionID, resourceGroupName, storageAccountName)
+	if account == nil {
+		return false
+	}
+	metadata := map[string]string{}
+	if creator != "" {
+		metadata[fakeAzureCreatorMetadata] = creator
+	}
+	account.fileShares[fileShareName] = &fakeFileShare{
+		metadata:    metadata,
+		directories: map[string]bool{},
+		files:       map[string]string{},
+		quotaGiB:    fakeAzureFileShareQuota,
+	}
+	return true
+}
+
+// HasStorageAccount returns true if the storage account exists in the resource group
+func (f *FakeAzure) HasStorageAccount(subscriptionID, resourceGroupName, storageAccountName string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.lookup(subscriptionID, resourceGroupName, storageAccountName) != nil
+}
+
+// HasFileShare returns true if the file share exists in the storage account of the resource group
+func (f *FakeAzure) HasFileShare(subscriptionID, resourceGroupName, storageAccountName, fileShareName string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	account := f.lookup(subscriptionID, resourceGroupName, storageAccountName)
+	return account != nil && account.fileShares[fileShareName] != nil
+}
+
+// FileShareMetadata returns the metadata of the file share in the storage account of the resource group. It returns
+// false if the file share or the metadata does not exist.
+func (f *FakeAzure) FileShareMetadata(subscriptionID, resourceGroupName, storageAccountName, fileShareName, key string) (string, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	account := f.lookup(subscriptionID, resourceGroupName, storageAccountName)
+	if account == nil || account.fileShares[fileShareName] == nil {
+		return "", false
+	}
+	value, ok := account.fileShares[fileShareName].metadata[key]
+	return value, ok
+}
+
+// DiagnosticSetting returns the destination of the diagnostic setting of the storage account of the resource group. It
+// returns false if the storage account or the setting does not exist.
+func (f *FakeAzure) DiagnosticSetting(subscriptionID, resourceGroupName, storageAccountName, settingName string) (azurefilebroker.AccessLogDestination, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	account := f.lookup(subscriptionID, resourceGroupName, storageAccountName)
+	if account == nil {
+		return azurefilebroker.AccessLogDestination{}, false
+	}
+	destination, ok := account.diagnostics[settingName]
+	return destination, ok
+}
+
+// StorageAccountProperties returns the SKU of the storage account and whether it only accepts secure transfers. It
+// returns false if the storage account does not exist.
+func (f *FakeAzure) StorageAccountProperties(subscriptionID, resourceGroupName, storageAccountName string) (storage.SkuName, bool, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	account := f.lookup(subscriptionID, resourceGroupName, storageAccountName)
+	if account == nil {
+		return "", false, false
+	}
+	return account.skuName, account.useHTTPS, true
+}
+
+// SetFileShareUsage sets the bytes which the files of the file share use. It returns false if the share does not exist.
+func (f *FakeAzure) SetFileShareUsage(subscriptionID, resourceGroupName, storageAccountName, fileShareName string, usageBytes int64) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	account := f.lookup(subscriptionID, resourceGroupName, storageAccountName)
+	if account == nil || account.fileShares[fileShareName] == nil {
+		return false
+	}
+	account.fileShares[fileShareName].usageBytes = usageBytes
+	return true
+}
+
+// SetStorageAccountLimit sets the most storage accounts of a subscription
+func (f *FakeAzure) SetStorageAccountLimit(limit int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.storageAccountLimit = limit
+}
+
+// SetOperationPolls makes the creations and the deletions of the storage accounts asynchronous operations which
+// complete after the polls of CheckCompletion. They complete immediately when it is 0.
+func (f *FakeAzure) SetOperationPolls(polls int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.operationPolls = polls
+}
+
+// Fail makes the next count calls of the operation fail with the error
+func (f *FakeAzure) Fail(operation string, count int, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.failures[operation] = &fakeFailure{err: err, count: count}
+}
+
+// FailRandomly makes the calls of the operation fail with the error at the rate between 0 and 1
+func (f *FakeAzure) FailRandomly(operation string, rate float64, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.failures[operation] = &fakeFailure{err: err, rate: rate}
+}
+
+// InjectFailures parses a comma separated list of failures in the format operation=failure:rate, e.g.
+// "CreateFileShare=throttled:0.2,CheckCompletion=internal-error:0.05", where the failures are the names of
+// FakeAzureFailures. The rate is 1 when it is omitted.
+func (f *FakeAzure) InjectFailures(failures string) error {
+	for _, failure := range strings.Split(failures, ",") {
+		if failure = strings.TrimSpace(failure); failure == "" {
+			continue
+		}
+		parts := strings.SplitN(failure, "=", 2)
+		if len(parts) != 2 || !isFakeAzureOperation(parts[0]) {
+			return fmt.Errorf("Invalid failure %q: expected operation=failure:rate with an operation of the clients of Azure", failure)
+		}
+		name, rate := parts[1], 1.0
+		if i := strings.LastIndex(name, ":"); i >= 0 {
+			var err error
+			if rate, err = strconv.ParseFloat(name[i+1:], 64); err != nil || rate < 0 || rate > 1 {
+				return fmt.Errorf("Invalid failure %q: the rate must be between 0 and 1", failure)
+			}
+			name = name[:i]
+		}
+		err, ok := FakeAzureFailures[name]
+		if !ok {
+			return fmt.Errorf("Invalid failure %q: unknown failure %q", failure, name)
+		}
+		f.FailRandomly(parts[0], rate, err)
+	}
+	return nil
+}
+
+func (f *FakeAzure) NewSDKClient(logger lager.Logger, cloudConfig *azurefilebroker.CloudConfig, storageAccount *azurefilebroker.StorageAccount) (azurefilebroker.AzureStorageAccountSDKClient, error) {
+	return &fakeAzureSDKClient{&fakeAzureClient{
+		azure:          f,
+		logger:         logger.Session("fake-azure-sdk-client"),
+		cloudConfig:    cloudConfig,
+		storageAccount: storageAccount,
+	}}, nil
+}
+
+func (f *FakeAzure) NewRESTClient(logger lager.Logger, cloudConfig *azurefilebroker.CloudConfig, storageAccount *azurefilebroker.StorageAccount) (azurefilebroker.AzureStorageAccountRESTClient, error) {
+	return &fakeAzureRESTClient{&fakeAzureClient{
+		azure:          f,
+		logger:         logger.Session("fake-azure-rest-client"),
+		cloudConfig:    cloudConfig,
+		storageAccount: storageAccount,
+	}}, nil
+}
+
+func newFakeStorageAccount(subscriptionID, resourceGroupName, location string, skuName storage.SkuName, tags map[string]string) *fakeStorageAccount {
+	return &fakeStorageAccount{
+		subscriptionID:    subscriptionID,
+		resourceGroupName: resourceGroupName,
+		location:          location,
+		skuName:           skuName,
+		tags:              tags,
+		keys:              []string{newFakeAzureKey(), newFakeAzureKey()},
+		fileShares:        map[string]*fakeFileShare{},
+		alerts:            map[string]azurefilebroker.StorageAccountAlert{},
+		diagnostics:       map[string]azurefilebroker.AccessLogDestination{},
+	}
+}
+
+func newFakeAzureKey() string {
+	key := make([]byte, 64)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func resourceGroupKey(subscriptionID, resourceGroupName string) string {
+	return strings.ToLower(subscriptionID + "/" + resourceGroupName)
+}
+
+func isFakeAzureOperation(operation string) bool {
+	for _, name := range fakeAzureOperations {
+		if name == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the storage account if it exists in the resource group. The caller must hold the mutex.
+func (f *FakeAzure) lookup(subscriptionID, resourceGroupName, storageAccountName string) *fakeStorageAccount {
+	account, ok := f.storageAccounts[storageAccountName]
+	if !ok || resourceGroupKey(account.subscriptionID, account.resourceGroupName) != resourceGroupKey(subscriptionID, resourceGroupName) {
+		return nil
+	}
+	return account
+}
+
+// injectedFailure returns the error injected into the operation, if the call fails. The caller must hold the mutex.
+func (f *FakeAzure) injectedFailure(operation string) error {
+	failure, ok := f.failures[operation]
+	if !ok {
+		return nil
+	}
+	if failure.count > 0 {
+		if failure.count--; failure.count == 0 {
+			delete(f.failures, operation)
+		}
+		return failure.err
+	}
+	if failure.rate > 0 && f.random.Float64() < failure.rate {
+		return failure.err
+	}
+	return nil
+}
+
+// startOperation returns the URL of an operation which completes after the polls, or completes it at once and returns
+// "" when the operations are synchronous. The caller must hold the mutex.
+func (f *FakeAzure) startOperation(complete func()) string {
+	if f.operationPolls <= 0 {
+		complete()
+		return ""
+	}
+	f.nextOperationID++
+	operationURL := fakeAzureOperationURLPrefix + strconv.Itoa(f.nextOperationID)
+	f.operations[operationURL] = &fakeOperation{polls: f.operationPolls, complete: complete}
+	return operationURL
+}
+
+func restError(statusCode int, code, format string, a ...interface{}) error {
+	return fmt.Errorf("Error Code: %d, %s: %s", statusCode, code, fmt.Sprintf(format, a...))
+}
+
+func sdkError(statusCode int, code, format string, a ...interface{}) error {
+	return fmt.Errorf("storage: service returned error: StatusCode=%d, ErrorCode=%s, ErrorMessage=%s", statusCode, code, fmt.Sprintf(format, a...))
+}
+
+// fakeAzureClient has the operations of the SDK and the REST clients of a storage account of the fake
+type fakeAzureClient struct {
+	azure          *FakeAzure
+	logger         lager.Logger
+	cloudConfig    *azurefilebroker.CloudConfig
+	storageAccount *azurefilebroker.StorageAccount
+}
+
+// The SDK and the REST clients only differ in the deletion of the storage account
+type fakeAzureSDKClient struct {
+	*fakeAzureClient
+}
+
+type fakeAzureRESTClient struct {
+	*fakeAzureClient
+}
+
+// begin locks the fake for a call of the operation and returns the error of the call if it fails before it reaches the
+// fake. The caller must unlock the fake.
+func (c *fakeAzureClient) begin(operation string) error {
+	c.azure.mutex.Lock()
+	if err := c.storageAccount.Err(); err != nil {
+		return err
+	}
+	if err := c.azure.injectedFailure(operation); err != nil {
+		c.logger.Info("injected-failure", lager.Data{"operation": operation, "error": err.Error()})
+		return err
+	}
+	c.logger.Info(operation)
+	return nil
+}
+
+func (c *fakeAzureClient) end() {
+	c.azure.mutex.Unlock()
+}
+
+// account returns the storage account of the client, or nil if it does not exist. The caller must hold the mutex.
+func (c *fakeAzureClient) account() *fakeStorageAccount {
+	return c.azure.lookup(c.storageAccount.SubscriptionID, c.storageAccount.ResourceGroupName, c.storageAccount.StorageAccountName)
+}
+
+// fileService returns the storage account of the client if its file service can be reached. The caller must hold the
+// mutex.
+func (c *fakeAzureClient) fileService() (*fakeStorageAccount, error) {
+	account := c.account()
+	if account == nil {
+		return nil, sdkError(404, "ResourceNotFound", "The storage account %q was not found", c.storageAccount.StorageAccountName)
+	}
+	if account.creating {
+		return nil, fmt.Errorf("The storage account %q is still in creating", c.storageAccount.StorageAccountName)
+	}
+	if c.storageAccount.BaseURL == "" {
+		c.storageAccount.BaseURL = c.cloudConfig.Azure.GetFileEndpointSuffix(fakeAzureFileEndpointSuffix)
+	}
+	return account, nil
+}
+
+// fileShare returns the file share of the storage account of the client. The caller must hold the mutex.
+func (c *fakeAzureClient) fileShare(fileShareName string) (*fakeFileShare, error) {
+	account, err := c.fileService()
+	if err != nil {
+		return nil, err
+	}
+	share, ok := account.fileShares[fileShareName]
+	if !ok {
+		return nil, sdkError(404, "ShareNotFound", "The specified share %q does not exist", fileShareName)
+	}
+	return share, nil
+}
+
+func (c *fakeAzureClient) Exists() (bool, error) {
+	defer c.end()
+	if err := c.begin("Exists"); err != nil {
+		return false, err
+	}
+	return c.account() != nil, nil
+}
+
+func (c *fakeAzureClient) GetAccessKey() (string, error) {
+	defer c.end()
+	if err := c.begin("GetAccessKey"); err != nil {
+		return "", err
+	}
+	if c.storageAccount.AccessKey == "" {
+		account := c.account()
+		if account == nil {
+			return "", fmt.Errorf("Failed to list keys: %v", restError(404, "ResourceNotFound", "The storage account %q was not found", c.storageAccount.StorageAccountName))
+		}
+		c.storageAccount.AccessKey = account.keys[0]
+	}
+	return c.storageAccount.AccessKey, nil
+}
+
+func (c *fakeAzureClient) SetStorageAccountTags(tags map[string]string) error {
+	defer c.end()
+	if err := c.begin("SetStorageAccountTags"); err != nil {
+		return err
+	}
+	account := c.account()
+	if account == nil {
+		return restError(404, "ResourceNotFound", "The storage account %q was not found", c.storageAccount.StorageAccountName)
+	}
+	for key, value := range tags {
+		if value == "" {
+			delete(account.tags, key)
+		} else {
+			account.tags[key] = value
+		}
+	}
+	return nil
+}
+
+func (c *fakeAzureClient) HasFileShare(fileShareName string) (bool, error) {
+	defer c.end()
+	if err := c.begin("HasFileShare"); err != nil {
+		return false, err
+	}
+	account, err := c.fileService()
+	if err != nil {
+		return false, err
+	}
+	_, ok := account.fileShares[fileShareName]
+	return ok, nil
+}
+
+func (c *fakeAzureClient) ListFileShares() (map[string]string, error) {
+	defer c.end()
+	if err := c.begin("ListFileShares"); err != nil {
+		return nil, err
+	}
+	account, err := c.fileService()
+	if err != nil {
+		return nil, err
+	}
+	shares := map[string]string{}
+	for name, share := range account.fileShares {
+		shares[name] = share.metadata[fakeAzureCreatorMetadata]
+	}
+	return shares, nil
+}
+
+func (c *fakeAzureClient) CreateFileShare(fileShareName string) error {
+	defer c.end()
+	if err := c.begin("CreateFileShare"); err != nil {
+		return err
+	}
+	account, err := c.fileService()
+	if err != nil {
+		return err
+	}
+	if _, ok := account.fileShares[fileShareName]; ok {
+		return sdkError(409, "ShareAlreadyExists", "The specified share %q already exists", fileShareName)
+	}
+	account.fileShares[fileShareName] = &fakeFileShare{
+		metadata:    map[string]string{fakeAzureCreatorMetadata: c.cloudConfig.Azure.CreatorTagValue},
+		directories: map[string]bool{},
+		files:       map[string]string{},
+		quotaGiB:    fakeAzureFileShareQuota,
+	}
+	return nil
+}
+
+func (c *fakeAzureClient) DeleteFileShare(fileShareName string) error {
+	defer c.end()
+	if err := c.begin("DeleteFileShare"); err != nil {
+		return err
+	}
+	account, err := c.fileService()
+	if err != nil {
+		return err
+	}
+	if _, ok := account.fileShares[fileShareName]; !ok {
+		return sdkError(404, "ShareNotFound", "The specified share %q does not exist", fileShareName)
+	}
+	delete(account.fileShares, fileShareName)
+	return nil
+}
+
+func (c *fakeAzureClient) SetFileShareMetadata(fileShareName, key, value string) error {
+	defer c.end()
+	if err := c.begin("SetFileShareMetadata"); err != nil {
+		return err
+	}
+	share, err := c.fileShare(fileShareName)
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		delete(share.metadata, key)
+	} else {
+		share.metadata[key] = value
+	}
+	return nil
+}
+
+func (c *fakeAzureClient) CreateDirectories(fileShareName string, paths []string) error {
+	defer c.end()
+	if err := c.begin("CreateDirectories"); err != nil {
+		return err
+	}
+	share, err := c.fileShare(fileShareName)
+	if err != nil {
+		return err
+	}
+	for _, directory := range paths {
+		if parent := path.Dir(directory); parent != "." && !share.directories[parent] {
+			return fmt.Errorf("Failed to create the directory %q: %v", directory, sdkError(404, "ParentNotFound", "The specified parent path does not exist"))
+		}
+		share.directories[directory] = true
+	}
+	return nil
+}
+
+func (c *fakeAzureClient) GetShareURL(fileShareName string) (string, error) {
+	defer c.end()
+	if err := c.begin("GetShareURL"); err != nil {
+		return "", err
+	}
+	if _, err := c.fileService(); err != nil {
+		return "", err
+	}
+	return c.cloudConfig.Azure.GetShareURL(c.storageAccount, fileShareName), nil
+}
+
+func (c *fakeAzureClient) VerifyShareSAS(fileShareName, sasToken string) error {
+	defer c.end()
+	if err := c.begin("VerifyShareSAS"); err != nil {
+		return err
+	}
+	share, err := c.fileShare(fileShareName)
+	if err != nil {
+		return err
+	}
+	token, ok := c.azure.sasTokens[strings.TrimPrefix(sasToken, "?")]
+	if !ok || token.storageAccountName != c.storageAccount.StorageAccountName || token.fileShareName != fileShareName {
+		return errors.New("Error Code: 403")
+	}
+	expiry := token.expiry
+	if token.policyID != "" {
+		expiry = time.Time{}
+		for _, policy := range share.policies {
+			if policy.ID == token.policyID {
+				expiry = policy.Expiry
+			}
+		}
+	}
+	if !c.azure.clock.Now().Before(expiry) {
+		return errors.New("Error Code: 403")
+	}
+	return nil
+}
+
+func (c *fakeAzureClient) ListFilesAndDirectories(fileShareName string) ([]string, []string, error) {
+	defer c.end()
+	if err := c.begin("ListFilesAndDirectories"); err != nil {
+		return nil, nil, err
+	}
+	share, err := c.fileShare(fileShareName)
+	if err != nil {
+		return nil, nil, err
+	}
+	directories, files := []string{}, []string{}
+	for directory := range share.directories {
+		directories = append(directories, directory)
+	}
+	for file := range share.files {
+		files = append(files, file)
+	}
+	// A parent is a prefix of its children, so it is sorted before them
+	sort.Strings(directories)
+	sort.Strings(files)
+	return directories, files, nil
+}
+
+func (c *fakeAzureClient) ListDirectory(fileShareName, directoryPath string, maxResults int) ([]azurefilebroker.DirectoryEntry, bool, error) {
+	defer c.end()
+	if err := c.begin("ListDirectory"); err != nil {
+		return nil, false, err
+	}
+	share, err := c.fileShare(fileShareName)
+	if err != nil {
+		return nil, false, err
+	}
+	if directoryPath != "" && !share.directories[directoryPath] {
+		return nil, false, sdkError(404, "ResourceNotFound", "The specified resource does not exist")
+	}
+	parent := directoryPath
+	if parent == "" {
+		parent = "."
+	}
+	entries := []azurefilebroker.DirectoryEntry{}
+	for directory := range share.directories {
+		if path.Dir(directory) == parent {
+			entries = append(entries, azurefilebroker.DirectoryEntry{Name: path.Base(directory), IsDirectory: true})
+		}
+	}
+	for file := range share.files {
+		if path.Dir(file) == parent {
+			entries = append(entries, azurefilebroker.DirectoryEntry{Name: path.Base(file)})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	if len(entries) > maxResults {
+		return entries[:maxResults], true, nil
+	}
+	return entries, false, nil
+}
+
+func (c *fakeAzureClient) CopyFile(fileShareName, filePath, sourceURL string) error {
+	defer c.end()
+	if err := c.begin("CopyFile"); err != nil {
+		return err
+	}
+	share, err := c.fileShare(fileShareName)
+	if err != nil {
+		return err
+	}
+	if parent := path.Dir(filePath); parent != "." && !share.directories[parent] {
+		return sdkError(404, "ParentNotFound", "The specified parent path does not exist")
+	}
+	share.files[filePath] = "success"
+	return nil
+}
+
+func (c *fakeAzureClient) GetFileCopyStatus(fileShareName, filePath, sasToken string) (string, error) {
+	defer c.end()
+	if err := c.begin("GetFileCopyStatus"); err != nil {
+		return "", err
+	}
+	share, err := c.fileShare(fileShareName)
+	if err != nil {
+		return "", err
+	}
+	return share.files[filePath], nil
+}
+
+func (c *fakeAzureClient) CreateStorageAccount() (string, error) {
+	defer c.end()
+	if err := c.begin("CreateStorageAccount"); err != nil {
+		return "", err
+	}
+	name := c.storageAccount.StorageAccountName
+	if !fakeAzureStorageAccountName.MatchString(name) {
+		return "", restError(400, "AccountNameInvalid", "%q is not a valid storage account name", name)
+	}
+	if !c.azure.resourceGroups[resourceGroupKey(c.storageAccount.SubscriptionID, c.storageAccount.ResourceGroupName)] {
+		return "", restError(404, "ResourceGroupNotFound", "Resource group %q could not be found", c.storageAccount.ResourceGroupName)
+	}
+	if _, ok := c.azure.storageAccounts[name]; ok {
+		if c.account() == nil {
+			return "", restError(409, "StorageAccountAlreadyTaken", "The storage account named %s is already taken", name)
+		}
+		// Creating a storage account again updates it
+		return "", nil
+	}
+	if count, limit, _ := c.azure.countStorageAccounts(c.storageAccount.SubscriptionID); count >= limit {
+		return "", restError(409, "StorageAccountQuotaExceeded", "The subscription already contains %d storage accounts", count)
+	}
+
+	tags := map[string]string{"creator": c.cloudConfig.Azure.CreatorTagValue}
+	if c.cloudConfig.Azure.BrokerInstanceID != "" {
+		tags["broker-instance-id"] = c.cloudConfig.Azure.BrokerInstanceID
+	}
+	account := newFakeStorageAccount(c.storageAccount.SubscriptionID, c.storageAccount.ResourceGroupName, c.storageAccount.Location, c.storageAccount.SkuName, tags)
+	account.useHTTPS = c.storageAccount.UseHTTPS
+	account.creating = true
+	c.azure.storageAccounts[name] = account
+	return c.azure.startOperation(func() { account.creating = false }), nil
+}
+
+func (c *fakeAzureSDKClient) DeleteStorageAccount() error {
+	_, err := c.deleteStorageAccount()
+	return err
+}
+
+func (c *fakeAzureRESTClient) DeleteStorageAccount() (string, error) {
+	return c.deleteStorageAccount()
+}
+
+func (c *fakeAzureClient) deleteStorageAccount() (string, error) {
+	defer c.end()
+	if err := c.begin("DeleteStorageAccount"); err != nil {
+		return "", err
+	}
+	account := c.account()
+	if account == nil {
+		return "", nil
+	}
+	name := c.storageAccount.StorageAccountName
+	return c.azure.startOperation(func() {
+		if c.azure.storageAccounts[name] == account {
+			delete(c.azure.storageAccounts, name)
+		}
+	}), nil
+}
+
+func (c *fakeAzureClient) UpdateStorageAccount(skuName storage.SkuName, useHTTPS bool) error {
+	defer c.end()
+	if err := c.begin("UpdateStorageAccount"); err != nil {
+		return err
+	}
+	account := c.account()
+	if account == nil || account.creating {
+		return restError(404, "ResourceNotFound", "The storage account %q was not found", c.storageAccount.StorageAccountName)
+	}
+	account.skuName, account.useHTTPS = skuName, useHTTPS
+	return nil
+}
+
+func (c *fakeAzureClient) CheckCompletion(asyncURL string) (bool, error) {
+	defer c.end()
+	if err := c.begin("CheckCompletion"); err != nil {
+		return false, err
+	}
+	operation, ok := c.azure.operations[asyncURL]
+	if !ok {
+		return false, fmt.Errorf("StatusCode: 404 - The operation %q was not found", asyncURL)
+	}
+	if operation.polls--; operation.polls > 0 {
+		return false, nil
+	}
+	operation.complete()
+	delete(c.azure.operations, asyncURL)
+	return true, nil
+}
+
+func (c *fakeAzureClient) SubscriptionExists() (bool, error) {
+	defer c.end()
+	if err := c.begin("SubscriptionExists"); err != nil {
+		return false, err
+	}
+	prefix := strings.ToLower(c.storageAccount.SubscriptionID + "/")
+	for key := range c.azure.resourceGroups {
+		if strings.HasPrefix(key, prefix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *fakeAzureClient) ResourceGroupExists() (bool, error) {
+	defer c.end()
+	if err := c.begin("ResourceGroupExists"); err != nil {
+		return false, err
+	}
+	return c.azure.resourceGroups[resourceGroupKey(c.storageAccount.SubscriptionID, c.storageAccount.ResourceGroupName)], nil
+}
+
+func (c *fakeAzureClient) GetStorageAccountUsage() (int, int, error) {
+	defer c.end()
+	if err := c.begin("GetStorageAccountUsage"); err != nil {
+		return 0, 0, err
+	}
+	return c.azure.countStorageAccounts(c.storageAccount.SubscriptionID)
+}
+
+// countStorageAccounts returns the storage accounts of the subscription and their limit. The caller must hold the
+// mutex.
+func (f *FakeAzure) countStorageAccounts(subscriptionID string) (int, int, error) {
+	count := 0
+	for _, account := range f.storageAccounts {
+		if strings.EqualFold(account.subscriptionID, subscriptionID) {
+			count++
+		}
+	}
+	return count, f.storageAccountLimit, nil
+}
+
+func (c *fakeAzureClient) IsSkuAvailable(skuName storage.SkuName, location string) (bool, error) {
+	defer c.end()
+	if err := c.begin("IsSkuAvailable"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *fakeAzureClient) ListPermissions() ([]azurefilebroker.Permission, error) {
+	defer c.end()
+	if err := c.begin("ListPermissions"); err != nil {
+		return nil, err
+	}
+	return []azurefilebroker.Permission{{Actions: []string{"*"}, NotActions: []string{}}}, nil
+}
+
+func (c *fakeAzureClient) GetFileShareStats(fileShareName string) (azurefilebroker.ShareStats, error) {
+	defer c.end()
+	if err := c.begin("GetFileShareStats"); err != nil {
+		return azurefilebroker.ShareStats{}, err
+	}
+	share, err := c.fileShare(fileShareName)
+	if err != nil {
+		return azurefilebroker.ShareStats{}, err
+	}
+	return azurefilebroker.ShareStats{UsageBytes: share.usageBytes, QuotaGiB: share.quotaGiB}, nil
+}
+
+func (c *fakeAzureClient) GetFileShareAccessPolicies(fileShareName string) ([]azurefilebroker.ShareAccessPolicy, error) {
+	defer c.end()
+	if err := c.begin("GetFileShareAccessPolicies"); err != nil {
+		return nil, err
+	}
+	share, err := c.fileShare(fileShareName)
+	if err != nil {
+		return nil, err
+	}
+	return append([]azurefilebroker.ShareAccessPolicy{}, share.policies...), nil
+}
+
+func (c *fakeAzureClient) SetFileShareAccessPolicies(fileShareName string, policies []azurefilebroker.ShareAccessPolicy) error {
+	defer c.end()
+	if err := c.begin("SetFileShareAccessPolicies"); err != nil {
+		return err
+	}
+	share, err := c.fileShare(fileShareName)
+	if err != nil {
+		return err
+	}
+	if len(policies) > fakeAzureMaxAccessPolicies {
+		return restError(400, "InvalidXmlDocument", "A share can have at most %d stored access policies", fakeAzureMaxAccessPolicies)
+	}
+	share.policies = append([]azurefilebroker.ShareAccessPolicy{}, policies...)
+	return nil
+}
+
+func (c *fakeAzureClient) GetFileShareSAS(fileShareName, policyID string) (string, error) {
+	defer c.end()
+	if err := c.begin("GetFileShareSAS"); err != nil {
+		return "", err
+	}
+	return c.issueSASToken(fileShareName, policyID, time.Time{}, fmt.Sprintf("si=%s", url.QueryEscape(policyID)))
+}
+
+func (c *fakeAzureClient) GetFileShareReadSAS(fileShareName string, expiry time.Time) (string, error) {
+	defer c.end()
+	if err := c.begin("GetFileShareReadSAS"); err != nil {
+		return "", err
+	}
+	return c.issueSASToken(fileShareName, "", expiry, fmt.Sprintf("sp=r&se=%s", url.QueryEscape(expiry.UTC().Format(time.RFC3339))))
+}
+
+// issueSASToken returns a SAS token of the file share which VerifyShareSAS accepts. The caller must hold the mutex.
+func (c *fakeAzureClient) issueSASToken(fileShareName, policyID string, expiry time.Time, permissions string) (string, error) {
+	if c.account() == nil {
+		return "", restError(404, "ResourceNotFound", "The storage account %q was not found", c.storageAccount.StorageAccountName)
+	}
+	token := fmt.Sprintf("sv=2019-02-02&sr=s&%s&spr=https&sig=%s", permissions, url.QueryEscape(newFakeAzureKey()))
+	c.azure.sasTokens[token] = fakeSASToken{
+		storageAccountName: c.storageAccount.StorageAccountName,
+		fileShareName:      fileShareName,
+		policyID:           policyID,
+		expiry:             expiry,
+	}
+	return token, nil
+}
+
+func (c *fakeAzureClient) RefreshBackupContainers(vaultID string) (string, error) {
+	defer c.end()
+	return "", c.begin("RefreshBackupContainers")
+}
+
+func (c *fakeAzureClient) RegisterBackupContainer(vaultID string) (string, error) {
+	defer c.end()
+	return "", c.begin("RegisterBackupContainer")
+}
+
+func (c *fakeAzureClient) InquireBackupItems(vaultID string) (string, error) {
+	defer c.end()
+	return "", c.begin("InquireBackupItems")
+}
+
+func (c *fakeAzureClient) ProtectFileShare(vaultID, policyName, fileShareName string) (string, error) {
+	defer c.end()
+	if err := c.begin("ProtectFileShare"); err != nil {
+		return "", err
+	}
+	if _, err := c.fileShare(fileShareName); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func (c *fakeAzureClient) CreateMetricAlert(alertName string, alert azurefilebroker.StorageAccountAlert, actionGroupID string) error {
+	defer c.end()
+	if err := c.begin("CreateMetricAlert"); err != nil {
+		return err
+	}
+	account := c.account()
+	if account == nil {
+		return restError(404, "ResourceNotFound", "The storage account %q was not found", c.storageAccount.StorageAccountName)
+	}
+	account.alerts[alertName] = alert
+	return nil
+}
+
+func (c *fakeAzureClient) DeleteMetricAlert(alertName string) error {
+	defer c.end()
+	if err := c.begin("DeleteMetricAlert"); err != nil {
+		return err
+	}
+	if account := c.account(); account != nil {
+		delete(account.alerts, alertName)
+	}
+	return nil
+}
+
+func (c *fakeAzureClient) CreateDiagnosticSetting(settingName string, destination azurefilebroker.AccessLogDestination) error {
+	defer c.end()
+	if err := c.begin("CreateDiagnosticSetting"); err != nil {
+		return err
+	}
+	account := c.account()
+	if account == nil {
+		return restError(404, "ResourceNotFound", "The storage account %q was not found", c.storageAccount.StorageAccountName)
+	}
+	account.diagnostics[settingName] = destination
+	return nil
+}
+
+func (c *fakeAzureClient) DeleteDiagnosticSetting(settingName string) error {
+	defer c.end()
+	if err := c.begin("DeleteDiagnosticSetting"); err != nil {
+		return err
+	}
+	if account := c.account(); account != nil {
+		delete(account.diagnostics, settingName)
+	}
+	return nil
+}
@@ -3,8 +3,10 @@ package azurefilebrokerfakes
 
 import (
 	"sync"
+	"time"
 
 	"code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	"github.com/Azure/azure-sdk-for-go/arm/storage"
 )
 
 type FakeAzureStorageAccountRESTClient struct {
@@ -19,6 +21,29 @@ type FakeAzureStorageAccountRESTClient struct {
 		result1 string
 		result2 error
 	}
+	DeleteStorageAccountStub        func() (string, error)
+	deleteStorageAccountMutex       sync.RWMutex
+	deleteStorageAccountArgsForCall []struct{}
+	deleteStorageAccountReturns     struct {
+		result1 string
+		result2 error
+	}
+	deleteStorageAccountReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	UpdateStorageAccountStub        func(skuName storage.SkuName, useHTTPS bool) error
+	updateStorageAccountMutex       sync.RWMutex
+	updateStorageAccountArgsForCall []struct {
+		skuName  storage.SkuName
+		useHTTPS bool
+	}
+	updateStorageAccountReturns struct {
+		result1 error
+	}
+	updateStorageAccountReturnsOnCall map[int]struct {
+		result1 error
+	}
 	CheckCompletionStub        func(asyncURL string) (bool, error)
 	checkCompletionMutex       sync.RWMutex
 	checkCompletionArgsForCall []struct {
@@ -32,6 +57,233 @@ type FakeAzureStorageAccountRESTClient struct {
 		result1 bool
 		result2 error
 	}
+	SubscriptionExistsStub        func() (bool, error)
+	subscriptionExistsMutex       sync.RWMutex
+	subscriptionExistsArgsForCall []struct{}
+	subscriptionExistsReturns     struct {
+		result1 bool
+		result2 error
+	}
+	subscriptionExistsReturnsOnCall map[int]struct {
+		result1 bool
+		result2 error
+	}
+	GetStorageAccountUsageStub        func() (int, int, error)
+	getStorageAccountUsageMutex       sync.RWMutex
+	getStorageAccountUsageArgsForCall []struct{}
+	getStorageAccountUsageReturns     struct {
+		result1 int
+		result2 int
+		result3 error
+	}
+	getStorageAccountUsageReturnsOnCall map[int]struct {
+		result1 int
+		result2 int
+		result3 error
+	}
+	IsSkuAvailableStub        func(skuName storage.SkuName, location string) (bool, error)
+	isSkuAvailableMutex       sync.RWMutex
+	isSkuAvailableArgsForCall []struct {
+		skuName  storage.SkuName
+		location string
+	}
+	isSkuAvailableReturns struct {
+		result1 bool
+		result2 error
+	}
+	isSkuAvailableReturnsOnCall map[int]struct {
+		result1 bool
+		result2 error
+	}
+	ListPermissionsStub        func() ([]azurefilebroker.Permission, error)
+	listPermissionsMutex       sync.RWMutex
+	listPermissionsArgsForCall []struct{}
+	listPermissionsReturns     struct {
+		result1 []azurefilebroker.Permission
+		result2 error
+	}
+	listPermissionsReturnsOnCall map[int]struct {
+		result1 []azurefilebroker.Permission
+		result2 error
+	}
+	ResourceGroupExistsStub        func() (bool, error)
+	resourceGroupExistsMutex       sync.RWMutex
+	resourceGroupExistsArgsForCall []struct{}
+	resourceGroupExistsReturns     struct {
+		result1 bool
+		result2 error
+	}
+	resourceGroupExistsReturnsOnCall map[int]struct {
+		result1 bool
+		result2 error
+	}
+	GetFileShareStatsStub        func(fileShareName string) (azurefilebroker.ShareStats, error)
+	getFileShareStatsMutex       sync.RWMutex
+	getFileShareStatsArgsForCall []struct {
+		fileShareName string
+	}
+	getFileShareStatsReturns struct {
+		result1 azurefilebroker.ShareStats
+		result2 error
+	}
+	getFileShareStatsReturnsOnCall map[int]struct {
+		result1 azurefilebroker.ShareStats
+		result2 error
+	}
+	GetFileShareAccessPoliciesStub        func(fileShareName string) ([]azurefilebroker.ShareAccessPolicy, error)
+	getFileShareAccessPoliciesMutex       sync.RWMutex
+	getFileShareAccessPoliciesArgsForCall []struct {
+		fileShareName string
+	}
+	getFileShareAccessPoliciesReturns struct {
+		result1 []azurefilebroker.ShareAccessPolicy
+		result2 error
+	}
+	getFileShareAccessPoliciesReturnsOnCall map[int]struct {
+		result1 []azurefilebroker.ShareAccessPolicy
+		result2 error
+	}
+	SetFileShareAccessPoliciesStub        func(fileShareName string, policies []azurefilebroker.ShareAccessPolicy) error
+	setFileShareAccessPoliciesMutex       sync.RWMutex
+	setFileShareAccessPoliciesArgsForCall []struct {
+		fileShareName string
+		policies      []azurefilebroker.ShareAccessPolicy
+	}
+	setFileShareAccessPoliciesReturns struct {
+		result1 error
+	}
+	setFileShareAccessPoliciesReturnsOnCall map[int]struct {
+		result1 error
+	}
+	GetFileShareSASStub        func(fileShareName string, policyID string) (string, error)
+	getFileShareSASMutex       sync.RWMutex
+	getFileShareSASArgsForCall []struct {
+		fileShareName string
+		policyID      string
+	}
+	getFileShareSASReturns struct {
+		result1 string
+		result2 error
+	}
+	getFileShareSASReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	GetFileShareReadSASStub        func(fileShareName string, expiry time.Time) (string, error)
+	getFileShareReadSASMutex       sync.RWMutex
+	getFileShareReadSASArgsForCall []struct {
+		fileShareName string
+		expiry        time.Time
+	}
+	getFileShareReadSASReturns struct {
+		result1 string
+		result2 error
+	}
+	getFileShareReadSASReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	RefreshBackupContainersStub        func(vaultID string) (string, error)
+	refreshBackupContainersMutex       sync.RWMutex
+	refreshBackupContainersArgsForCall []struct {
+		vaultID string
+	}
+	refreshBackupContainersReturns struct {
+		result1 string
+		result2 error
+	}
+	refreshBackupContainersReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	RegisterBackupContainerStub        func(vaultID string) (string, error)
+	registerBackupContainerMutex       sync.RWMutex
+	registerBackupContainerArgsForCall []struct {
+		vaultID string
+	}
+	registerBackupContainerReturns struct {
+		result1 string
+		result2 error
+	}
+	registerBackupContainerReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	InquireBackupItemsStub        func(vaultID string) (string, error)
+	inquireBackupItemsMutex       sync.RWMutex
+	inquireBackupItemsArgsForCall []struct {
+		vaultID string
+	}
+	inquireBackupItemsReturns struct {
+		result1 string
+		result2 error
+	}
+	inquireBackupItemsReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	ProtectFileShareStub        func(vaultID string, policyName string, fileShareName string) (string, error)
+	protectFileShareMutex       sync.RWMutex
+	protectFileShareArgsForCall []struct {
+		vaultID       string
+		policyName    string
+		fileShareName string
+	}
+	protectFileShareReturns struct {
+		result1 string
+		result2 error
+	}
+	protectFileShareReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	CreateMetricAlertStub        func(alertName string, alert azurefilebroker.StorageAccountAlert, actionGroupID string) error
+	createMetricAlertMutex       sync.RWMutex
+	createMetricAlertArgsForCall []struct {
+		alertName     string
+		alert         azurefilebroker.StorageAccountAlert
+		actionGroupID string
+	}
+	createMetricAlertReturns struct {
+		result1 error
+	}
+	createMetricAlertReturnsOnCall map[int]struct {
+		result1 error
+	}
+	DeleteMetricAlertStub        func(alertName string) error
+	deleteMetricAlertMutex       sync.RWMutex
+	deleteMetricAlertArgsForCall []struct {
+		alertName string
+	}
+	deleteMetricAlertReturns struct {
+		result1 error
+	}
+	deleteMetricAlertReturnsOnCall map[int]struct {
+		result1 error
+	}
+	CreateDiagnosticSettingStub        func(settingName string, destination azurefilebroker.AccessLogDestination) error
+	createDiagnosticSettingMutex       sync.RWMutex
+	createDiagnosticSettingArgsForCall []struct {
+		settingName string
+		destination azurefilebroker.AccessLogDestination
+	}
+	createDiagnosticSettingReturns struct {
+		result1 error
+	}
+	createDiagnosticSettingReturnsOnCall map[int]struct {
+		result1 error
+	}
+	DeleteDiagnosticSettingStub        func(settingName string) error
+	deleteDiagnosticSettingMutex       sync.RWMutex
+	deleteDiagnosticSettingArgsForCall []struct {
+		settingName string
+	}
+	deleteDiagnosticSettingReturns struct {
+		result1 error
+	}
+	deleteDiagnosticSettingReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -79,6 +331,98 @@ func (fake *FakeAzureStorageAccountRESTClient) CreateStorageAccountReturnsOnCall
 	}{result1, result2}
 }
 
+func (fake *FakeAzureStorageAccountRESTClient) DeleteStorageAccount() (string, error) {
+	fake.deleteStorageAccountMutex.Lock()
+	ret, specificReturn := fake.deleteStorageAccountReturnsOnCall[len(fake.deleteStorageAccountArgsForCall)]
+	fake.deleteStorageAccountArgsForCall = append(fake.deleteStorageAccountArgsForCall, struct{}{})
+	fake.recordInvocation("DeleteStorageAccount", []interface{}{})
+	fake.deleteStorageAccountMutex.Unlock()
+	if fake.DeleteStorageAccountStub != nil {
+		return fake.DeleteStorageAccountStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.deleteStorageAccountReturns.result1, fake.deleteStorageAccountReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteStorageAccountCallCount() int {
+	fake.deleteStorageAccountMutex.RLock()
+	defer fake.deleteStorageAccountMutex.RUnlock()
+	return len(fake.deleteStorageAccountArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteStorageAccountReturns(result1 string, result2 error) {
+	fake.DeleteStorageAccountStub = nil
+	fake.deleteStorageAccountReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteStorageAccountReturnsOnCall(i int, result1 string, result2 error) {
+	fake.DeleteStorageAccountStub = nil
+	if fake.deleteStorageAccountReturnsOnCall == nil {
+		fake.deleteStorageAccountReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.deleteStorageAccountReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) UpdateStorageAccount(skuName storage.SkuName, useHTTPS bool) error {
+	fake.updateStorageAccountMutex.Lock()
+	ret, specificReturn := fake.updateStorageAccountReturnsOnCall[len(fake.updateStorageAccountArgsForCall)]
+	fake.updateStorageAccountArgsForCall = append(fake.updateStorageAccountArgsForCall, struct {
+		skuName  storage.SkuName
+		useHTTPS bool
+	}{skuName, useHTTPS})
+	fake.recordInvocation("UpdateStorageAccount", []interface{}{skuName, useHTTPS})
+	fake.updateStorageAccountMutex.Unlock()
+	if fake.UpdateStorageAccountStub != nil {
+		return fake.UpdateStorageAccountStub(skuName, useHTTPS)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.updateStorageAccountReturns.result1
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) UpdateStorageAccountCallCount() int {
+	fake.updateStorageAccountMutex.RLock()
+	defer fake.updateStorageAccountMutex.RUnlock()
+	return len(fake.updateStorageAccountArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) UpdateStorageAccountArgsForCall(i int) (storage.SkuName, bool) {
+	fake.updateStorageAccountMutex.RLock()
+	defer fake.updateStorageAccountMutex.RUnlock()
+	return fake.updateStorageAccountArgsForCall[i].skuName, fake.updateStorageAccountArgsForCall[i].useHTTPS
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) UpdateStorageAccountReturns(result1 error) {
+	fake.UpdateStorageAccountStub = nil
+	fake.updateStorageAccountReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) UpdateStorageAccountReturnsOnCall(i int, result1 error) {
+	fake.UpdateStorageAccountStub = nil
+	if fake.updateStorageAccountReturnsOnCall == nil {
+		fake.updateStorageAccountReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.updateStorageAccountReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeAzureStorageAccountRESTClient) CheckCompletion(asyncURL string) (bool, error) {
 	fake.checkCompletionMutex.Lock()
 	ret, specificReturn := fake.checkCompletionReturnsOnCall[len(fake.checkCompletionArgsForCall)]
@@ -130,13 +474,941 @@ func (fake *FakeAzureStorageAccountRESTClient) CheckCompletionReturnsOnCall(i in
 	}{result1, result2}
 }
 
+func (fake *FakeAzureStorageAccountRESTClient) SubscriptionExists() (bool, error) {
+	fake.subscriptionExistsMutex.Lock()
+	ret, specificReturn := fake.subscriptionExistsReturnsOnCall[len(fake.subscriptionExistsArgsForCall)]
+	fake.subscriptionExistsArgsForCall = append(fake.subscriptionExistsArgsForCall, struct{}{})
+	fake.recordInvocation("SubscriptionExists", []interface{}{})
+	fake.subscriptionExistsMutex.Unlock()
+	if fake.SubscriptionExistsStub != nil {
+		return fake.SubscriptionExistsStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.subscriptionExistsReturns.result1, fake.subscriptionExistsReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) SubscriptionExistsCallCount() int {
+	fake.subscriptionExistsMutex.RLock()
+	defer fake.subscriptionExistsMutex.RUnlock()
+	return len(fake.subscriptionExistsArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) SubscriptionExistsReturns(result1 bool, result2 error) {
+	fake.SubscriptionExistsStub = nil
+	fake.subscriptionExistsReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) SubscriptionExistsReturnsOnCall(i int, result1 bool, result2 error) {
+	fake.SubscriptionExistsStub = nil
+	if fake.subscriptionExistsReturnsOnCall == nil {
+		fake.subscriptionExistsReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 error
+		})
+	}
+	fake.subscriptionExistsReturnsOnCall[i] = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetStorageAccountUsage() (int, int, error) {
+	fake.getStorageAccountUsageMutex.Lock()
+	ret, specificReturn := fake.getStorageAccountUsageReturnsOnCall[len(fake.getStorageAccountUsageArgsForCall)]
+	fake.getStorageAccountUsageArgsForCall = append(fake.getStorageAccountUsageArgsForCall, struct{}{})
+	fake.recordInvocation("GetStorageAccountUsage", []interface{}{})
+	fake.getStorageAccountUsageMutex.Unlock()
+	if fake.GetStorageAccountUsageStub != nil {
+		return fake.GetStorageAccountUsageStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fake.getStorageAccountUsageReturns.result1, fake.getStorageAccountUsageReturns.result2, fake.getStorageAccountUsageReturns.result3
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetStorageAccountUsageCallCount() int {
+	fake.getStorageAccountUsageMutex.RLock()
+	defer fake.getStorageAccountUsageMutex.RUnlock()
+	return len(fake.getStorageAccountUsageArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetStorageAccountUsageReturns(result1 int, result2 int, result3 error) {
+	fake.GetStorageAccountUsageStub = nil
+	fake.getStorageAccountUsageReturns = struct {
+		result1 int
+		result2 int
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetStorageAccountUsageReturnsOnCall(i int, result1 int, result2 int, result3 error) {
+	fake.GetStorageAccountUsageStub = nil
+	if fake.getStorageAccountUsageReturnsOnCall == nil {
+		fake.getStorageAccountUsageReturnsOnCall = make(map[int]struct {
+			result1 int
+			result2 int
+			result3 error
+		})
+	}
+	fake.getStorageAccountUsageReturnsOnCall[i] = struct {
+		result1 int
+		result2 int
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) IsSkuAvailable(skuName storage.SkuName, location string) (bool, error) {
+	fake.isSkuAvailableMutex.Lock()
+	ret, specificReturn := fake.isSkuAvailableReturnsOnCall[len(fake.isSkuAvailableArgsForCall)]
+	fake.isSkuAvailableArgsForCall = append(fake.isSkuAvailableArgsForCall, struct {
+		skuName  storage.SkuName
+		location string
+	}{skuName, location})
+	fake.recordInvocation("IsSkuAvailable", []interface{}{skuName, location})
+	fake.isSkuAvailableMutex.Unlock()
+	if fake.IsSkuAvailableStub != nil {
+		return fake.IsSkuAvailableStub(skuName, location)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.isSkuAvailableReturns.result1, fake.isSkuAvailableReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) IsSkuAvailableCallCount() int {
+	fake.isSkuAvailableMutex.RLock()
+	defer fake.isSkuAvailableMutex.RUnlock()
+	return len(fake.isSkuAvailableArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) IsSkuAvailableArgsForCall(i int) (storage.SkuName, string) {
+	fake.isSkuAvailableMutex.RLock()
+	defer fake.isSkuAvailableMutex.RUnlock()
+	return fake.isSkuAvailableArgsForCall[i].skuName, fake.isSkuAvailableArgsForCall[i].location
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) IsSkuAvailableReturns(result1 bool, result2 error) {
+	fake.IsSkuAvailableStub = nil
+	fake.isSkuAvailableReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) IsSkuAvailableReturnsOnCall(i int, result1 bool, result2 error) {
+	fake.IsSkuAvailableStub = nil
+	if fake.isSkuAvailableReturnsOnCall == nil {
+		fake.isSkuAvailableReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 error
+		})
+	}
+	fake.isSkuAvailableReturnsOnCall[i] = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ListPermissions() ([]azurefilebroker.Permission, error) {
+	fake.listPermissionsMutex.Lock()
+	ret, specificReturn := fake.listPermissionsReturnsOnCall[len(fake.listPermissionsArgsForCall)]
+	fake.listPermissionsArgsForCall = append(fake.listPermissionsArgsForCall, struct{}{})
+	fake.recordInvocation("ListPermissions", []interface{}{})
+	fake.listPermissionsMutex.Unlock()
+	if fake.ListPermissionsStub != nil {
+		return fake.ListPermissionsStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.listPermissionsReturns.result1, fake.listPermissionsReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ListPermissionsCallCount() int {
+	fake.listPermissionsMutex.RLock()
+	defer fake.listPermissionsMutex.RUnlock()
+	return len(fake.listPermissionsArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ListPermissionsReturns(result1 []azurefilebroker.Permission, result2 error) {
+	fake.ListPermissionsStub = nil
+	fake.listPermissionsReturns = struct {
+		result1 []azurefilebroker.Permission
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ListPermissionsReturnsOnCall(i int, result1 []azurefilebroker.Permission, result2 error) {
+	fake.ListPermissionsStub = nil
+	if fake.listPermissionsReturnsOnCall == nil {
+		fake.listPermissionsReturnsOnCall = make(map[int]struct {
+			result1 []azurefilebroker.Permission
+			result2 error
+		})
+	}
+	fake.listPermissionsReturnsOnCall[i] = struct {
+		result1 []azurefilebroker.Permission
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ResourceGroupExists() (bool, error) {
+	fake.resourceGroupExistsMutex.Lock()
+	ret, specificReturn := fake.resourceGroupExistsReturnsOnCall[len(fake.resourceGroupExistsArgsForCall)]
+	fake.resourceGroupExistsArgsForCall = append(fake.resourceGroupExistsArgsForCall, struct{}{})
+	fake.recordInvocation("ResourceGroupExists", []interface{}{})
+	fake.resourceGroupExistsMutex.Unlock()
+	if fake.ResourceGroupExistsStub != nil {
+		return fake.ResourceGroupExistsStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.resourceGroupExistsReturns.result1, fake.resourceGroupExistsReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ResourceGroupExistsCallCount() int {
+	fake.resourceGroupExistsMutex.RLock()
+	defer fake.resourceGroupExistsMutex.RUnlock()
+	return len(fake.resourceGroupExistsArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ResourceGroupExistsReturns(result1 bool, result2 error) {
+	fake.ResourceGroupExistsStub = nil
+	fake.resourceGroupExistsReturns = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ResourceGroupExistsReturnsOnCall(i int, result1 bool, result2 error) {
+	fake.ResourceGroupExistsStub = nil
+	if fake.resourceGroupExistsReturnsOnCall == nil {
+		fake.resourceGroupExistsReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 error
+		})
+	}
+	fake.resourceGroupExistsReturnsOnCall[i] = struct {
+		result1 bool
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareStats(fileShareName string) (azurefilebroker.ShareStats, error) {
+	fake.getFileShareStatsMutex.Lock()
+	ret, specificReturn := fake.getFileShareStatsReturnsOnCall[len(fake.getFileShareStatsArgsForCall)]
+	fake.getFileShareStatsArgsForCall = append(fake.getFileShareStatsArgsForCall, struct {
+		fileShareName string
+	}{fileShareName})
+	fake.recordInvocation("GetFileShareStats", []interface{}{fileShareName})
+	fake.getFileShareStatsMutex.Unlock()
+	if fake.GetFileShareStatsStub != nil {
+		return fake.GetFileShareStatsStub(fileShareName)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.getFileShareStatsReturns.result1, fake.getFileShareStatsReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareStatsCallCount() int {
+	fake.getFileShareStatsMutex.RLock()
+	defer fake.getFileShareStatsMutex.RUnlock()
+	return len(fake.getFileShareStatsArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareStatsArgsForCall(i int) string {
+	fake.getFileShareStatsMutex.RLock()
+	defer fake.getFileShareStatsMutex.RUnlock()
+	return fake.getFileShareStatsArgsForCall[i].fileShareName
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareStatsReturns(result1 azurefilebroker.ShareStats, result2 error) {
+	fake.GetFileShareStatsStub = nil
+	fake.getFileShareStatsReturns = struct {
+		result1 azurefilebroker.ShareStats
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareStatsReturnsOnCall(i int, result1 azurefilebroker.ShareStats, result2 error) {
+	fake.GetFileShareStatsStub = nil
+	if fake.getFileShareStatsReturnsOnCall == nil {
+		fake.getFileShareStatsReturnsOnCall = make(map[int]struct {
+			result1 azurefilebroker.ShareStats
+			result2 error
+		})
+	}
+	fake.getFileShareStatsReturnsOnCall[i] = struct {
+		result1 azurefilebroker.ShareStats
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareAccessPolicies(fileShareName string) ([]azurefilebroker.ShareAccessPolicy, error) {
+	fake.getFileShareAccessPoliciesMutex.Lock()
+	ret, specificReturn := fake.getFileShareAccessPoliciesReturnsOnCall[len(fake.getFileShareAccessPoliciesArgsForCall)]
+	fake.getFileShareAccessPoliciesArgsForCall = append(fake.getFileShareAccessPoliciesArgsForCall, struct {
+		fileShareName string
+	}{fileShareName})
+	fake.recordInvocation("GetFileShareAccessPolicies", []interface{}{fileShareName})
+	fake.getFileShareAccessPoliciesMutex.Unlock()
+	if fake.GetFileShareAccessPoliciesStub != nil {
+		return fake.GetFileShareAccessPoliciesStub(fileShareName)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.getFileShareAccessPoliciesReturns.result1, fake.getFileShareAccessPoliciesReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareAccessPoliciesCallCount() int {
+	fake.getFileShareAccessPoliciesMutex.RLock()
+	defer fake.getFileShareAccessPoliciesMutex.RUnlock()
+	return len(fake.getFileShareAccessPoliciesArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareAccessPoliciesArgsForCall(i int) string {
+	fake.getFileShareAccessPoliciesMutex.RLock()
+	defer fake.getFileShareAccessPoliciesMutex.RUnlock()
+	return fake.getFileShareAccessPoliciesArgsForCall[i].fileShareName
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareAccessPoliciesReturns(result1 []azurefilebroker.ShareAccessPolicy, result2 error) {
+	fake.GetFileShareAccessPoliciesStub = nil
+	fake.getFileShareAccessPoliciesReturns = struct {
+		result1 []azurefilebroker.ShareAccessPolicy
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareAccessPoliciesReturnsOnCall(i int, result1 []azurefilebroker.ShareAccessPolicy, result2 error) {
+	fake.GetFileShareAccessPoliciesStub = nil
+	if fake.getFileShareAccessPoliciesReturnsOnCall == nil {
+		fake.getFileShareAccessPoliciesReturnsOnCall = make(map[int]struct {
+			result1 []azurefilebroker.ShareAccessPolicy
+			result2 error
+		})
+	}
+	fake.getFileShareAccessPoliciesReturnsOnCall[i] = struct {
+		result1 []azurefilebroker.ShareAccessPolicy
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) SetFileShareAccessPolicies(fileShareName string, policies []azurefilebroker.ShareAccessPolicy) error {
+	var policiesCopy []azurefilebroker.ShareAccessPolicy
+	if policies != nil {
+		policiesCopy = make([]azurefilebroker.ShareAccessPolicy, len(policies))
+		copy(policiesCopy, policies)
+	}
+	fake.setFileShareAccessPoliciesMutex.Lock()
+	ret, specificReturn := fake.setFileShareAccessPoliciesReturnsOnCall[len(fake.setFileShareAccessPoliciesArgsForCall)]
+	fake.setFileShareAccessPoliciesArgsForCall = append(fake.setFileShareAccessPoliciesArgsForCall, struct {
+		fileShareName string
+		policies      []azurefilebroker.ShareAccessPolicy
+	}{fileShareName, policiesCopy})
+	fake.recordInvocation("SetFileShareAccessPolicies", []interface{}{fileShareName, policiesCopy})
+	fake.setFileShareAccessPoliciesMutex.Unlock()
+	if fake.SetFileShareAccessPoliciesStub != nil {
+		return fake.SetFileShareAccessPoliciesStub(fileShareName, policies)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.setFileShareAccessPoliciesReturns.result1
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) SetFileShareAccessPoliciesCallCount() int {
+	fake.setFileShareAccessPoliciesMutex.RLock()
+	defer fake.setFileShareAccessPoliciesMutex.RUnlock()
+	return len(fake.setFileShareAccessPoliciesArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) SetFileShareAccessPoliciesArgsForCall(i int) (string, []azurefilebroker.ShareAccessPolicy) {
+	fake.setFileShareAccessPoliciesMutex.RLock()
+	defer fake.setFileShareAccessPoliciesMutex.RUnlock()
+	return fake.setFileShareAccessPoliciesArgsForCall[i].fileShareName, fake.setFileShareAccessPoliciesArgsForCall[i].policies
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) SetFileShareAccessPoliciesReturns(result1 error) {
+	fake.SetFileShareAccessPoliciesStub = nil
+	fake.setFileShareAccessPoliciesReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) SetFileShareAccessPoliciesReturnsOnCall(i int, result1 error) {
+	fake.SetFileShareAccessPoliciesStub = nil
+	if fake.setFileShareAccessPoliciesReturnsOnCall == nil {
+		fake.setFileShareAccessPoliciesReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.setFileShareAccessPoliciesReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareSAS(fileShareName string, policyID string) (string, error) {
+	fake.getFileShareSASMutex.Lock()
+	ret, specificReturn := fake.getFileShareSASReturnsOnCall[len(fake.getFileShareSASArgsForCall)]
+	fake.getFileShareSASArgsForCall = append(fake.getFileShareSASArgsForCall, struct {
+		fileShareName string
+		policyID      string
+	}{fileShareName, policyID})
+	fake.recordInvocation("GetFileShareSAS", []interface{}{fileShareName, policyID})
+	fake.getFileShareSASMutex.Unlock()
+	if fake.GetFileShareSASStub != nil {
+		return fake.GetFileShareSASStub(fileShareName, policyID)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.getFileShareSASReturns.result1, fake.getFileShareSASReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareSASCallCount() int {
+	fake.getFileShareSASMutex.RLock()
+	defer fake.getFileShareSASMutex.RUnlock()
+	return len(fake.getFileShareSASArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareSASArgsForCall(i int) (string, string) {
+	fake.getFileShareSASMutex.RLock()
+	defer fake.getFileShareSASMutex.RUnlock()
+	return fake.getFileShareSASArgsForCall[i].fileShareName, fake.getFileShareSASArgsForCall[i].policyID
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareSASReturns(result1 string, result2 error) {
+	fake.GetFileShareSASStub = nil
+	fake.getFileShareSASReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareSASReturnsOnCall(i int, result1 string, result2 error) {
+	fake.GetFileShareSASStub = nil
+	if fake.getFileShareSASReturnsOnCall == nil {
+		fake.getFileShareSASReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.getFileShareSASReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareReadSAS(fileShareName string, expiry time.Time) (string, error) {
+	fake.getFileShareReadSASMutex.Lock()
+	ret, specificReturn := fake.getFileShareReadSASReturnsOnCall[len(fake.getFileShareReadSASArgsForCall)]
+	fake.getFileShareReadSASArgsForCall = append(fake.getFileShareReadSASArgsForCall, struct {
+		fileShareName string
+		expiry        time.Time
+	}{fileShareName, expiry})
+	fake.recordInvocation("GetFileShareReadSAS", []interface{}{fileShareName, expiry})
+	fake.getFileShareReadSASMutex.Unlock()
+	if fake.GetFileShareReadSASStub != nil {
+		return fake.GetFileShareReadSASStub(fileShareName, expiry)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.getFileShareReadSASReturns.result1, fake.getFileShareReadSASReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareReadSASCallCount() int {
+	fake.getFileShareReadSASMutex.RLock()
+	defer fake.getFileShareReadSASMutex.RUnlock()
+	return len(fake.getFileShareReadSASArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareReadSASArgsForCall(i int) (string, time.Time) {
+	fake.getFileShareReadSASMutex.RLock()
+	defer fake.getFileShareReadSASMutex.RUnlock()
+	return fake.getFileShareReadSASArgsForCall[i].fileShareName, fake.getFileShareReadSASArgsForCall[i].expiry
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareReadSASReturns(result1 string, result2 error) {
+	fake.GetFileShareReadSASStub = nil
+	fake.getFileShareReadSASReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) GetFileShareReadSASReturnsOnCall(i int, result1 string, result2 error) {
+	fake.GetFileShareReadSASStub = nil
+	if fake.getFileShareReadSASReturnsOnCall == nil {
+		fake.getFileShareReadSASReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.getFileShareReadSASReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) RefreshBackupContainers(vaultID string) (string, error) {
+	fake.refreshBackupContainersMutex.Lock()
+	ret, specificReturn := fake.refreshBackupContainersReturnsOnCall[len(fake.refreshBackupContainersArgsForCall)]
+	fake.refreshBackupContainersArgsForCall = append(fake.refreshBackupContainersArgsForCall, struct {
+		vaultID string
+	}{vaultID})
+	fake.recordInvocation("RefreshBackupContainers", []interface{}{vaultID})
+	fake.refreshBackupContainersMutex.Unlock()
+	if fake.RefreshBackupContainersStub != nil {
+		return fake.RefreshBackupContainersStub(vaultID)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.refreshBackupContainersReturns.result1, fake.refreshBackupContainersReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) RefreshBackupContainersCallCount() int {
+	fake.refreshBackupContainersMutex.RLock()
+	defer fake.refreshBackupContainersMutex.RUnlock()
+	return len(fake.refreshBackupContainersArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) RefreshBackupContainersArgsForCall(i int) string {
+	fake.refreshBackupContainersMutex.RLock()
+	defer fake.refreshBackupContainersMutex.RUnlock()
+	return fake.refreshBackupContainersArgsForCall[i].vaultID
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) RefreshBackupContainersReturns(result1 string, result2 error) {
+	fake.RefreshBackupContainersStub = nil
+	fake.refreshBackupContainersReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) RefreshBackupContainersReturnsOnCall(i int, result1 string, result2 error) {
+	fake.RefreshBackupContainersStub = nil
+	if fake.refreshBackupContainersReturnsOnCall == nil {
+		fake.refreshBackupContainersReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.refreshBackupContainersReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) RegisterBackupContainer(vaultID string) (string, error) {
+	fake.registerBackupContainerMutex.Lock()
+	ret, specificReturn := fake.registerBackupContainerReturnsOnCall[len(fake.registerBackupContainerArgsForCall)]
+	fake.registerBackupContainerArgsForCall = append(fake.registerBackupContainerArgsForCall, struct {
+		vaultID string
+	}{vaultID})
+	fake.recordInvocation("RegisterBackupContainer", []interface{}{vaultID})
+	fake.registerBackupContainerMutex.Unlock()
+	if fake.RegisterBackupContainerStub != nil {
+		return fake.RegisterBackupContainerStub(vaultID)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.registerBackupContainerReturns.result1, fake.registerBackupContainerReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) RegisterBackupContainerCallCount() int {
+	fake.registerBackupContainerMutex.RLock()
+	defer fake.registerBackupContainerMutex.RUnlock()
+	return len(fake.registerBackupContainerArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) RegisterBackupContainerArgsForCall(i int) string {
+	fake.registerBackupContainerMutex.RLock()
+	defer fake.registerBackupContainerMutex.RUnlock()
+	return fake.registerBackupContainerArgsForCall[i].vaultID
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) RegisterBackupContainerReturns(result1 string, result2 error) {
+	fake.RegisterBackupContainerStub = nil
+	fake.registerBackupContainerReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) RegisterBackupContainerReturnsOnCall(i int, result1 string, result2 error) {
+	fake.RegisterBackupContainerStub = nil
+	if fake.registerBackupContainerReturnsOnCall == nil {
+		fake.registerBackupContainerReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.registerBackupContainerReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) InquireBackupItems(vaultID string) (string, error) {
+	fake.inquireBackupItemsMutex.Lock()
+	ret, specificReturn := fake.inquireBackupItemsReturnsOnCall[len(fake.inquireBackupItemsArgsForCall)]
+	fake.inquireBackupItemsArgsForCall = append(fake.inquireBackupItemsArgsForCall, struct {
+		vaultID string
+	}{vaultID})
+	fake.recordInvocation("InquireBackupItems", []interface{}{vaultID})
+	fake.inquireBackupItemsMutex.Unlock()
+	if fake.InquireBackupItemsStub != nil {
+		return fake.InquireBackupItemsStub(vaultID)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.inquireBackupItemsReturns.result1, fake.inquireBackupItemsReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) InquireBackupItemsCallCount() int {
+	fake.inquireBackupItemsMutex.RLock()
+	defer fake.inquireBackupItemsMutex.RUnlock()
+	return len(fake.inquireBackupItemsArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) InquireBackupItemsArgsForCall(i int) string {
+	fake.inquireBackupItemsMutex.RLock()
+	defer fake.inquireBackupItemsMutex.RUnlock()
+	return fake.inquireBackupItemsArgsForCall[i].vaultID
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) InquireBackupItemsReturns(result1 string, result2 error) {
+	fake.InquireBackupItemsStub = nil
+	fake.inquireBackupItemsReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) InquireBackupItemsReturnsOnCall(i int, result1 string, result2 error) {
+	fake.InquireBackupItemsStub = nil
+	if fake.inquireBackupItemsReturnsOnCall == nil {
+		fake.inquireBackupItemsReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.inquireBackupItemsReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ProtectFileShare(vaultID string, policyName string, fileShareName string) (string, error) {
+	fake.protectFileShareMutex.Lock()
+	ret, specificReturn := fake.protectFileShareReturnsOnCall[len(fake.protectFileShareArgsForCall)]
+	fake.protectFileShareArgsForCall = append(fake.protectFileShareArgsForCall, struct {
+		vaultID       string
+		policyName    string
+		fileShareName string
+	}{vaultID, policyName, fileShareName})
+	fake.recordInvocation("ProtectFileShare", []interface{}{vaultID, policyName, fileShareName})
+	fake.protectFileShareMutex.Unlock()
+	if fake.ProtectFileShareStub != nil {
+		return fake.ProtectFileShareStub(vaultID, policyName, fileShareName)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.protectFileShareReturns.result1, fake.protectFileShareReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ProtectFileShareCallCount() int {
+	fake.protectFileShareMutex.RLock()
+	defer fake.protectFileShareMutex.RUnlock()
+	return len(fake.protectFileShareArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ProtectFileShareArgsForCall(i int) (string, string, string) {
+	fake.protectFileShareMutex.RLock()
+	defer fake.protectFileShareMutex.RUnlock()
+	return fake.protectFileShareArgsForCall[i].vaultID, fake.protectFileShareArgsForCall[i].policyName, fake.protectFileShareArgsForCall[i].fileShareName
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ProtectFileShareReturns(result1 string, result2 error) {
+	fake.ProtectFileShareStub = nil
+	fake.protectFileShareReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) ProtectFileShareReturnsOnCall(i int, result1 string, result2 error) {
+	fake.ProtectFileShareStub = nil
+	if fake.protectFileShareReturnsOnCall == nil {
+		fake.protectFileShareReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.protectFileShareReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) CreateMetricAlert(alertName string, alert azurefilebroker.StorageAccountAlert, actionGroupID string) error {
+	fake.createMetricAlertMutex.Lock()
+	ret, specificReturn := fake.createMetricAlertReturnsOnCall[len(fake.createMetricAlertArgsForCall)]
+	fake.createMetricAlertArgsForCall = append(fake.createMetricAlertArgsForCall, struct {
+		alertName     string
+		alert         azurefilebroker.StorageAccountAlert
+		actionGroupID string
+	}{alertName, alert, actionGroupID})
+	fake.recordInvocation("CreateMetricAlert", []interface{}{alertName, alert, actionGroupID})
+	fake.createMetricAlertMutex.Unlock()
+	if fake.CreateMetricAlertStub != nil {
+		return fake.CreateMetricAlertStub(alertName, alert, actionGroupID)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createMetricAlertReturns.result1
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) CreateMetricAlertCallCount() int {
+	fake.createMetricAlertMutex.RLock()
+	defer fake.createMetricAlertMutex.RUnlock()
+	return len(fake.createMetricAlertArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) CreateMetricAlertArgsForCall(i int) (string, azurefilebroker.StorageAccountAlert, string) {
+	fake.createMetricAlertMutex.RLock()
+	defer fake.createMetricAlertMutex.RUnlock()
+	return fake.createMetricAlertArgsForCall[i].alertName, fake.createMetricAlertArgsForCall[i].alert, fake.createMetricAlertArgsForCall[i].actionGroupID
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) CreateMetricAlertReturns(result1 error) {
+	fake.CreateMetricAlertStub = nil
+	fake.createMetricAlertReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) CreateMetricAlertReturnsOnCall(i int, result1 error) {
+	fake.CreateMetricAlertStub = nil
+	if fake.createMetricAlertReturnsOnCall == nil {
+		fake.createMetricAlertReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createMetricAlertReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteMetricAlert(alertName string) error {
+	fake.deleteMetricAlertMutex.Lock()
+	ret, specificReturn := fake.deleteMetricAlertReturnsOnCall[len(fake.deleteMetricAlertArgsForCall)]
+	fake.deleteMetricAlertArgsForCall = append(fake.deleteMetricAlertArgsForCall, struct {
+		alertName string
+	}{alertName})
+	fake.recordInvocation("DeleteMetricAlert", []interface{}{alertName})
+	fake.deleteMetricAlertMutex.Unlock()
+	if fake.DeleteMetricAlertStub != nil {
+		return fake.DeleteMetricAlertStub(alertName)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.deleteMetricAlertReturns.result1
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteMetricAlertCallCount() int {
+	fake.deleteMetricAlertMutex.RLock()
+	defer fake.deleteMetricAlertMutex.RUnlock()
+	return len(fake.deleteMetricAlertArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteMetricAlertArgsForCall(i int) string {
+	fake.deleteMetricAlertMutex.RLock()
+	defer fake.deleteMetricAlertMutex.RUnlock()
+	return fake.deleteMetricAlertArgsForCall[i].alertName
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteMetricAlertReturns(result1 error) {
+	fake.DeleteMetricAlertStub = nil
+	fake.deleteMetricAlertReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteMetricAlertReturnsOnCall(i int, result1 error) {
+	fake.DeleteMetricAlertStub = nil
+	if fake.deleteMetricAlertReturnsOnCall == nil {
+		fake.deleteMetricAlertReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteMetricAlertReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) CreateDiagnosticSetting(settingName string, destination azurefilebroker.AccessLogDestination) error {
+	fake.createDiagnosticSettingMutex.Lock()
+	ret, specificReturn := fake.createDiagnosticSettingReturnsOnCall[len(fake.createDiagnosticSettingArgsForCall)]
+	fake.createDiagnosticSettingArgsForCall = append(fake.createDiagnosticSettingArgsForCall, struct {
+		settingName string
+		destination azurefilebroker.AccessLogDestination
+	}{settingName, destination})
+	fake.recordInvocation("CreateDiagnosticSetting", []interface{}{settingName, destination})
+	fake.createDiagnosticSettingMutex.Unlock()
+	if fake.CreateDiagnosticSettingStub != nil {
+		return fake.CreateDiagnosticSettingStub(settingName, destination)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createDiagnosticSettingReturns.result1
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) CreateDiagnosticSettingCallCount() int {
+	fake.createDiagnosticSettingMutex.RLock()
+	defer fake.createDiagnosticSettingMutex.RUnlock()
+	return len(fake.createDiagnosticSettingArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) CreateDiagnosticSettingArgsForCall(i int) (string, azurefilebroker.AccessLogDestination) {
+	fake.createDiagnosticSettingMutex.RLock()
+	defer fake.createDiagnosticSettingMutex.RUnlock()
+	return fake.createDiagnosticSettingArgsForCall[i].settingName, fake.createDiagnosticSettingArgsForCall[i].destination
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) CreateDiagnosticSettingReturns(result1 error) {
+	fake.CreateDiagnosticSettingStub = nil
+	fake.createDiagnosticSettingReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) CreateDiagnosticSettingReturnsOnCall(i int, result1 error) {
+	fake.CreateDiagnosticSettingStub = nil
+	if fake.createDiagnosticSettingReturnsOnCall == nil {
+		fake.createDiagnosticSettingReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createDiagnosticSettingReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteDiagnosticSetting(settingName string) error {
+	fake.deleteDiagnosticSettingMutex.Lock()
+	ret, specificReturn := fake.deleteDiagnosticSettingReturnsOnCall[len(fake.deleteDiagnosticSettingArgsForCall)]
+	fake.deleteDiagnosticSettingArgsForCall = append(fake.deleteDiagnosticSettingArgsForCall, struct {
+		settingName string
+	}{settingName})
+	fake.recordInvocation("DeleteDiagnosticSetting", []interface{}{settingName})
+	fake.deleteDiagnosticSettingMutex.Unlock()
+	if fake.DeleteDiagnosticSettingStub != nil {
+		return fake.DeleteDiagnosticSettingStub(settingName)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.deleteDiagnosticSettingReturns.result1
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteDiagnosticSettingCallCount() int {
+	fake.deleteDiagnosticSettingMutex.RLock()
+	defer fake.deleteDiagnosticSettingMutex.RUnlock()
+	return len(fake.deleteDiagnosticSettingArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteDiagnosticSettingArgsForCall(i int) string {
+	fake.deleteDiagnosticSettingMutex.RLock()
+	defer fake.deleteDiagnosticSettingMutex.RUnlock()
+	return fake.deleteDiagnosticSettingArgsForCall[i].settingName
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteDiagnosticSettingReturns(result1 error) {
+	fake.DeleteDiagnosticSettingStub = nil
+	fake.deleteDiagnosticSettingReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountRESTClient) DeleteDiagnosticSettingReturnsOnCall(i int, result1 error) {
+	fake.DeleteDiagnosticSettingStub = nil
+	if fake.deleteDiagnosticSettingReturnsOnCall == nil {
+		fake.deleteDiagnosticSettingReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteDiagnosticSettingReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeAzureStorageAccountRESTClient) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.createStorageAccountMutex.RLock()
 	defer fake.createStorageAccountMutex.RUnlock()
+	fake.deleteStorageAccountMutex.RLock()
+	defer fake.deleteStorageAccountMutex.RUnlock()
+	fake.updateStorageAccountMutex.RLock()
+	defer fake.updateStorageAccountMutex.RUnlock()
 	fake.checkCompletionMutex.RLock()
 	defer fake.checkCompletionMutex.RUnlock()
+	fake.subscriptionExistsMutex.RLock()
+	defer fake.subscriptionExistsMutex.RUnlock()
+	fake.getStorageAccountUsageMutex.RLock()
+	defer fake.getStorageAccountUsageMutex.RUnlock()
+	fake.isSkuAvailableMutex.RLock()
+	defer fake.isSkuAvailableMutex.RUnlock()
+	fake.listPermissionsMutex.RLock()
+	defer fake.listPermissionsMutex.RUnlock()
+	fake.resourceGroupExistsMutex.RLock()
+	defer fake.resourceGroupExistsMutex.RUnlock()
+	fake.getFileShareStatsMutex.RLock()
+	defer fake.getFileShareStatsMutex.RUnlock()
+	fake.getFileShareAccessPoliciesMutex.RLock()
+	defer fake.getFileShareAccessPoliciesMutex.RUnlock()
+	fake.setFileShareAccessPoliciesMutex.RLock()
+	defer fake.setFileShareAccessPoliciesMutex.RUnlock()
+	fake.getFileShareSASMutex.RLock()
+	defer fake.getFileShareSASMutex.RUnlock()
+	fake.getFileShareReadSASMutex.RLock()
+	defer fake.getFileShareReadSASMutex.RUnlock()
+	fake.refreshBackupContainersMutex.RLock()
+	defer fake.refreshBackupContainersMutex.RUnlock()
+	fake.registerBackupContainerMutex.RLock()
+	defer fake.registerBackupContainerMutex.RUnlock()
+	fake.inquireBackupItemsMutex.RLock()
+	defer fake.inquireBackupItemsMutex.RUnlock()
+	fake.protectFileShareMutex.RLock()
+	defer fake.protectFileShareMutex.RUnlock()
+	fake.createMetricAlertMutex.RLock()
+	defer fake.createMetricAlertMutex.RUnlock()
+	fake.deleteMetricAlertMutex.RLock()
+	defer fake.deleteMetricAlertMutex.RUnlock()
+	fake.createDiagnosticSettingMutex.RLock()
+	defer fake.createDiagnosticSettingMutex.RUnlock()
+	fake.deleteDiagnosticSettingMutex.RLock()
+	defer fake.deleteDiagnosticSettingMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -39,6 +39,17 @@ type FakeAzureStorageAccountSDKClient struct {
 	deleteStorageAccountReturnsOnCall map[int]struct {
 		result1 error
 	}
+	SetStorageAccountTagsStub        func(tags map[string]string) error
+	setStorageAccountTagsMutex       sync.RWMutex
+	setStorageAccountTagsArgsForCall []struct {
+		tags map[string]string
+	}
+	setStorageAccountTagsReturns struct {
+		result1 error
+	}
+	setStorageAccountTagsReturnsOnCall map[int]struct {
+		result1 error
+	}
 	HasFileShareStub        func(fileShareName string) (bool, error)
 	hasFileShareMutex       sync.RWMutex
 	hasFileShareArgsForCall []struct {
@@ -52,6 +63,17 @@ type FakeAzureStorageAccountSDKClient struct {
 		result1 bool
 		result2 error
 	}
+	ListFileSharesStub        func() (map[string]string, error)
+	listFileSharesMutex       sync.RWMutex
+	listFileSharesArgsForCall []struct{}
+	listFileSharesReturns     struct {
+		result1 map[string]string
+		result2 error
+	}
+	listFileSharesReturnsOnCall map[int]struct {
+		result1 map[string]string
+		result2 error
+	}
 	CreateFileShareStub        func(fileShareName string) error
 	createFileShareMutex       sync.RWMutex
 	createFileShareArgsForCall []struct {
@@ -74,6 +96,31 @@ type FakeAzureStorageAccountSDKClient struct {
 	deleteFileShareReturnsOnCall map[int]struct {
 		result1 error
 	}
+	SetFileShareMetadataStub        func(fileShareName string, key string, value string) error
+	setFileShareMetadataMutex       sync.RWMutex
+	setFileShareMetadataArgsForCall []struct {
+		fileShareName string
+		key           string
+		value         string
+	}
+	setFileShareMetadataReturns struct {
+		result1 error
+	}
+	setFileShareMetadataReturnsOnCall map[int]struct {
+		result1 error
+	}
+	CreateDirectoriesStub        func(fileShareName string, paths []string) error
+	createDirectoriesMutex       sync.RWMutex
+	createDirectoriesArgsForCall []struct {
+		fileShareName string
+		paths         []string
+	}
+	createDirectoriesReturns struct {
+		result1 error
+	}
+	createDirectoriesReturnsOnCall map[int]struct {
+		result1 error
+	}
 	GetShareURLStub        func(fileShareName string) (string, error)
 	getShareURLMutex       sync.RWMutex
 	getShareURLArgsForCall []struct {
@@ -87,6 +134,78 @@ type FakeAzureStorageAccountSDKClient struct {
 		result1 string
 		result2 error
 	}
+	VerifyShareSASStub        func(fileShareName string, sasToken string) error
+	verifyShareSASMutex       sync.RWMutex
+	verifyShareSASArgsForCall []struct {
+		fileShareName string
+		sasToken      string
+	}
+	verifyShareSASReturns struct {
+		result1 error
+	}
+	verifyShareSASReturnsOnCall map[int]struct {
+		result1 error
+	}
+	ListFilesAndDirectoriesStub        func(fileShareName string) ([]string, []string, error)
+	listFilesAndDirectoriesMutex       sync.RWMutex
+	listFilesAndDirectoriesArgsForCall []struct {
+		fileShareName string
+	}
+	listFilesAndDirectoriesReturns struct {
+		result1 []string
+		result2 []string
+		result3 error
+	}
+	listFilesAndDirectoriesReturnsOnCall map[int]struct {
+		result1 []string
+		result2 []string
+		result3 error
+	}
+	ListDirectoryStub        func(fileShareName string, directoryPath string, maxResults int) ([]azurefilebroker.DirectoryEntry, bool, error)
+	listDirectoryMutex       sync.RWMutex
+	listDirectoryArgsForCall []struct {
+		fileShareName string
+		directoryPath string
+		maxResults    int
+	}
+	listDirectoryReturns struct {
+		result1 []azurefilebroker.DirectoryEntry
+		result2 bool
+		result3 error
+	}
+	listDirectoryReturnsOnCall map[int]struct {
+		result1 []azurefilebroker.DirectoryEntry
+		result2 bool
+		result3 error
+	}
+	CopyFileStub        func(fileShareName string, filePath string, sourceURL string) error
+	copyFileMutex       sync.RWMutex
+	copyFileArgsForCall []struct {
+		fileShareName string
+		filePath      string
+		sourceURL     string
+	}
+	copyFileReturns struct {
+		result1 error
+	}
+	copyFileReturnsOnCall map[int]struct {
+		result1 error
+	}
+	GetFileCopyStatusStub        func(fileShareName string, filePath string, sasToken string) (string, error)
+	getFileCopyStatusMutex       sync.RWMutex
+	getFileCopyStatusArgsForCall []struct {
+		fileShareName string
+		filePath      string
+		sasToken      string
+	}
+	getFileCopyStatusReturns struct {
+		result1 string
+		result2 error
+	}
+	getFileCopyStatusReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -217,6 +336,54 @@ func (fake *FakeAzureStorageAccountSDKClient) DeleteStorageAccountReturnsOnCall(
 	}{result1}
 }
 
+func (fake *FakeAzureStorageAccountSDKClient) SetStorageAccountTags(tags map[string]string) error {
+	fake.setStorageAccountTagsMutex.Lock()
+	ret, specificReturn := fake.setStorageAccountTagsReturnsOnCall[len(fake.setStorageAccountTagsArgsForCall)]
+	fake.setStorageAccountTagsArgsForCall = append(fake.setStorageAccountTagsArgsForCall, struct {
+		tags map[string]string
+	}{tags})
+	fake.recordInvocation("SetStorageAccountTags", []interface{}{tags})
+	fake.setStorageAccountTagsMutex.Unlock()
+	if fake.SetStorageAccountTagsStub != nil {
+		return fake.SetStorageAccountTagsStub(tags)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.setStorageAccountTagsReturns.result1
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) SetStorageAccountTagsCallCount() int {
+	fake.setStorageAccountTagsMutex.RLock()
+	defer fake.setStorageAccountTagsMutex.RUnlock()
+	return len(fake.setStorageAccountTagsArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) SetStorageAccountTagsArgsForCall(i int) map[string]string {
+	fake.setStorageAccountTagsMutex.RLock()
+	defer fake.setStorageAccountTagsMutex.RUnlock()
+	return fake.setStorageAccountTagsArgsForCall[i].tags
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) SetStorageAccountTagsReturns(result1 error) {
+	fake.SetStorageAccountTagsStub = nil
+	fake.setStorageAccountTagsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) SetStorageAccountTagsReturnsOnCall(i int, result1 error) {
+	fake.SetStorageAccountTagsStub = nil
+	if fake.setStorageAccountTagsReturnsOnCall == nil {
+		fake.setStorageAccountTagsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.setStorageAccountTagsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeAzureStorageAccountSDKClient) HasFileShare(fileShareName string) (bool, error) {
 	fake.hasFileShareMutex.Lock()
 	ret, specificReturn := fake.hasFileShareReturnsOnCall[len(fake.hasFileShareArgsForCall)]
@@ -268,6 +435,49 @@ func (fake *FakeAzureStorageAccountSDKClient) HasFileShareReturnsOnCall(i int, r
 	}{result1, result2}
 }
 
+func (fake *FakeAzureStorageAccountSDKClient) ListFileShares() (map[string]string, error) {
+	fake.listFileSharesMutex.Lock()
+	ret, specificReturn := fake.listFileSharesReturnsOnCall[len(fake.listFileSharesArgsForCall)]
+	fake.listFileSharesArgsForCall = append(fake.listFileSharesArgsForCall, struct{}{})
+	fake.recordInvocation("ListFileShares", []interface{}{})
+	fake.listFileSharesMutex.Unlock()
+	if fake.ListFileSharesStub != nil {
+		return fake.ListFileSharesStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.listFileSharesReturns.result1, fake.listFileSharesReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListFileSharesCallCount() int {
+	fake.listFileSharesMutex.RLock()
+	defer fake.listFileSharesMutex.RUnlock()
+	return len(fake.listFileSharesArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListFileSharesReturns(result1 map[string]string, result2 error) {
+	fake.ListFileSharesStub = nil
+	fake.listFileSharesReturns = struct {
+		result1 map[string]string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListFileSharesReturnsOnCall(i int, result1 map[string]string, result2 error) {
+	fake.ListFileSharesStub = nil
+	if fake.listFileSharesReturnsOnCall == nil {
+		fake.listFileSharesReturnsOnCall = make(map[int]struct {
+			result1 map[string]string
+			result2 error
+		})
+	}
+	fake.listFileSharesReturnsOnCall[i] = struct {
+		result1 map[string]string
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeAzureStorageAccountSDKClient) CreateFileShare(fileShareName string) error {
 	fake.createFileShareMutex.Lock()
 	ret, specificReturn := fake.createFileShareReturnsOnCall[len(fake.createFileShareArgsForCall)]
@@ -364,6 +574,110 @@ func (fake *FakeAzureStorageAccountSDKClient) DeleteFileShareReturnsOnCall(i int
 	}{result1}
 }
 
+func (fake *FakeAzureStorageAccountSDKClient) SetFileShareMetadata(fileShareName string, key string, value string) error {
+	fake.setFileShareMetadataMutex.Lock()
+	ret, specificReturn := fake.setFileShareMetadataReturnsOnCall[len(fake.setFileShareMetadataArgsForCall)]
+	fake.setFileShareMetadataArgsForCall = append(fake.setFileShareMetadataArgsForCall, struct {
+		fileShareName string
+		key           string
+		value         string
+	}{fileShareName, key, value})
+	fake.recordInvocation("SetFileShareMetadata", []interface{}{fileShareName, key, value})
+	fake.setFileShareMetadataMutex.Unlock()
+	if fake.SetFileShareMetadataStub != nil {
+		return fake.SetFileShareMetadataStub(fileShareName, key, value)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.setFileShareMetadataReturns.result1
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) SetFileShareMetadataCallCount() int {
+	fake.setFileShareMetadataMutex.RLock()
+	defer fake.setFileShareMetadataMutex.RUnlock()
+	return len(fake.setFileShareMetadataArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) SetFileShareMetadataArgsForCall(i int) (string, string, string) {
+	fake.setFileShareMetadataMutex.RLock()
+	defer fake.setFileShareMetadataMutex.RUnlock()
+	return fake.setFileShareMetadataArgsForCall[i].fileShareName, fake.setFileShareMetadataArgsForCall[i].key, fake.setFileShareMetadataArgsForCall[i].value
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) SetFileShareMetadataReturns(result1 error) {
+	fake.SetFileShareMetadataStub = nil
+	fake.setFileShareMetadataReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) SetFileShareMetadataReturnsOnCall(i int, result1 error) {
+	fake.SetFileShareMetadataStub = nil
+	if fake.setFileShareMetadataReturnsOnCall == nil {
+		fake.setFileShareMetadataReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.setFileShareMetadataReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) CreateDirectories(fileShareName string, paths []string) error {
+	var pathsCopy []string
+	if paths != nil {
+		pathsCopy = make([]string, len(paths))
+		copy(pathsCopy, paths)
+	}
+	fake.createDirectoriesMutex.Lock()
+	ret, specificReturn := fake.createDirectoriesReturnsOnCall[len(fake.createDirectoriesArgsForCall)]
+	fake.createDirectoriesArgsForCall = append(fake.createDirectoriesArgsForCall, struct {
+		fileShareName string
+		paths         []string
+	}{fileShareName, pathsCopy})
+	fake.recordInvocation("CreateDirectories", []interface{}{fileShareName, pathsCopy})
+	fake.createDirectoriesMutex.Unlock()
+	if fake.CreateDirectoriesStub != nil {
+		return fake.CreateDirectoriesStub(fileShareName, paths)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createDirectoriesReturns.result1
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) CreateDirectoriesCallCount() int {
+	fake.createDirectoriesMutex.RLock()
+	defer fake.createDirectoriesMutex.RUnlock()
+	return len(fake.createDirectoriesArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) CreateDirectoriesArgsForCall(i int) (string, []string) {
+	fake.createDirectoriesMutex.RLock()
+	defer fake.createDirectoriesMutex.RUnlock()
+	return fake.createDirectoriesArgsForCall[i].fileShareName, fake.createDirectoriesArgsForCall[i].paths
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) CreateDirectoriesReturns(result1 error) {
+	fake.CreateDirectoriesStub = nil
+	fake.createDirectoriesReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) CreateDirectoriesReturnsOnCall(i int, result1 error) {
+	fake.CreateDirectoriesStub = nil
+	if fake.createDirectoriesReturnsOnCall == nil {
+		fake.createDirectoriesReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createDirectoriesReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeAzureStorageAccountSDKClient) GetShareURL(fileShareName string) (string, error) {
 	fake.getShareURLMutex.Lock()
 	ret, specificReturn := fake.getShareURLReturnsOnCall[len(fake.getShareURLArgsForCall)]
@@ -415,6 +729,268 @@ func (fake *FakeAzureStorageAccountSDKClient) GetShareURLReturnsOnCall(i int, re
 	}{result1, result2}
 }
 
+func (fake *FakeAzureStorageAccountSDKClient) VerifyShareSAS(fileShareName string, sasToken string) error {
+	fake.verifyShareSASMutex.Lock()
+	ret, specificReturn := fake.verifyShareSASReturnsOnCall[len(fake.verifyShareSASArgsForCall)]
+	fake.verifyShareSASArgsForCall = append(fake.verifyShareSASArgsForCall, struct {
+		fileShareName string
+		sasToken      string
+	}{fileShareName, sasToken})
+	fake.recordInvocation("VerifyShareSAS", []interface{}{fileShareName, sasToken})
+	fake.verifyShareSASMutex.Unlock()
+	if fake.VerifyShareSASStub != nil {
+		return fake.VerifyShareSASStub(fileShareName, sasToken)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.verifyShareSASReturns.result1
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) VerifyShareSASCallCount() int {
+	fake.verifyShareSASMutex.RLock()
+	defer fake.verifyShareSASMutex.RUnlock()
+	return len(fake.verifyShareSASArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) VerifyShareSASArgsForCall(i int) (string, string) {
+	fake.verifyShareSASMutex.RLock()
+	defer fake.verifyShareSASMutex.RUnlock()
+	return fake.verifyShareSASArgsForCall[i].fileShareName, fake.verifyShareSASArgsForCall[i].sasToken
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) VerifyShareSASReturns(result1 error) {
+	fake.VerifyShareSASStub = nil
+	fake.verifyShareSASReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) VerifyShareSASReturnsOnCall(i int, result1 error) {
+	fake.VerifyShareSASStub = nil
+	if fake.verifyShareSASReturnsOnCall == nil {
+		fake.verifyShareSASReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.verifyShareSASReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListFilesAndDirectories(fileShareName string) ([]string, []string, error) {
+	fake.listFilesAndDirectoriesMutex.Lock()
+	ret, specificReturn := fake.listFilesAndDirectoriesReturnsOnCall[len(fake.listFilesAndDirectoriesArgsForCall)]
+	fake.listFilesAndDirectoriesArgsForCall = append(fake.listFilesAndDirectoriesArgsForCall, struct {
+		fileShareName string
+	}{fileShareName})
+	fake.recordInvocation("ListFilesAndDirectories", []interface{}{fileShareName})
+	fake.listFilesAndDirectoriesMutex.Unlock()
+	if fake.ListFilesAndDirectoriesStub != nil {
+		return fake.ListFilesAndDirectoriesStub(fileShareName)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fake.listFilesAndDirectoriesReturns.result1, fake.listFilesAndDirectoriesReturns.result2, fake.listFilesAndDirectoriesReturns.result3
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListFilesAndDirectoriesCallCount() int {
+	fake.listFilesAndDirectoriesMutex.RLock()
+	defer fake.listFilesAndDirectoriesMutex.RUnlock()
+	return len(fake.listFilesAndDirectoriesArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListFilesAndDirectoriesArgsForCall(i int) string {
+	fake.listFilesAndDirectoriesMutex.RLock()
+	defer fake.listFilesAndDirectoriesMutex.RUnlock()
+	return fake.listFilesAndDirectoriesArgsForCall[i].fileShareName
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListFilesAndDirectoriesReturns(result1 []string, result2 []string, result3 error) {
+	fake.ListFilesAndDirectoriesStub = nil
+	fake.listFilesAndDirectoriesReturns = struct {
+		result1 []string
+		result2 []string
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListFilesAndDirectoriesReturnsOnCall(i int, result1 []string, result2 []string, result3 error) {
+	fake.ListFilesAndDirectoriesStub = nil
+	if fake.listFilesAndDirectoriesReturnsOnCall == nil {
+		fake.listFilesAndDirectoriesReturnsOnCall = make(map[int]struct {
+			result1 []string
+			result2 []string
+			result3 error
+		})
+	}
+	fake.listFilesAndDirectoriesReturnsOnCall[i] = struct {
+		result1 []string
+		result2 []string
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListDirectory(fileShareName string, directoryPath string, maxResults int) ([]azurefilebroker.DirectoryEntry, bool, error) {
+	fake.listDirectoryMutex.Lock()
+	ret, specificReturn := fake.listDirectoryReturnsOnCall[len(fake.listDirectoryArgsForCall)]
+	fake.listDirectoryArgsForCall = append(fake.listDirectoryArgsForCall, struct {
+		fileShareName string
+		directoryPath string
+		maxResults    int
+	}{fileShareName, directoryPath, maxResults})
+	fake.recordInvocation("ListDirectory", []interface{}{fileShareName, directoryPath, maxResults})
+	fake.listDirectoryMutex.Unlock()
+	if fake.ListDirectoryStub != nil {
+		return fake.ListDirectoryStub(fileShareName, directoryPath, maxResults)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fake.listDirectoryReturns.result1, fake.listDirectoryReturns.result2, fake.listDirectoryReturns.result3
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListDirectoryCallCount() int {
+	fake.listDirectoryMutex.RLock()
+	defer fake.listDirectoryMutex.RUnlock()
+	return len(fake.listDirectoryArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListDirectoryArgsForCall(i int) (string, string, int) {
+	fake.listDirectoryMutex.RLock()
+	defer fake.listDirectoryMutex.RUnlock()
+	return fake.listDirectoryArgsForCall[i].fileShareName, fake.listDirectoryArgsForCall[i].directoryPath, fake.listDirectoryArgsForCall[i].maxResults
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListDirectoryReturns(result1 []azurefilebroker.DirectoryEntry, result2 bool, result3 error) {
+	fake.ListDirectoryStub = nil
+	fake.listDirectoryReturns = struct {
+		result1 []azurefilebroker.DirectoryEntry
+		result2 bool
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) ListDirectoryReturnsOnCall(i int, result1 []azurefilebroker.DirectoryEntry, result2 bool, result3 error) {
+	fake.ListDirectoryStub = nil
+	if fake.listDirectoryReturnsOnCall == nil {
+		fake.listDirectoryReturnsOnCall = make(map[int]struct {
+			result1 []azurefilebroker.DirectoryEntry
+			result2 bool
+			result3 error
+		})
+	}
+	fake.listDirectoryReturnsOnCall[i] = struct {
+		result1 []azurefilebroker.DirectoryEntry
+		result2 bool
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) CopyFile(fileShareName string, filePath string, sourceURL string) error {
+	fake.copyFileMutex.Lock()
+	ret, specificReturn := fake.copyFileReturnsOnCall[len(fake.copyFileArgsForCall)]
+	fake.copyFileArgsForCall = append(fake.copyFileArgsForCall, struct {
+		fileShareName string
+		filePath      string
+		sourceURL     string
+	}{fileShareName, filePath, sourceURL})
+	fake.recordInvocation("CopyFile", []interface{}{fileShareName, filePath, sourceURL})
+	fake.copyFileMutex.Unlock()
+	if fake.CopyFileStub != nil {
+		return fake.CopyFileStub(fileShareName, filePath, sourceURL)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.copyFileReturns.result1
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) CopyFileCallCount() int {
+	fake.copyFileMutex.RLock()
+	defer fake.copyFileMutex.RUnlock()
+	return len(fake.copyFileArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) CopyFileArgsForCall(i int) (string, string, string) {
+	fake.copyFileMutex.RLock()
+	defer fake.copyFileMutex.RUnlock()
+	return fake.copyFileArgsForCall[i].fileShareName, fake.copyFileArgsForCall[i].filePath, fake.copyFileArgsForCall[i].sourceURL
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) CopyFileReturns(result1 error) {
+	fake.CopyFileStub = nil
+	fake.copyFileReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) CopyFileReturnsOnCall(i int, result1 error) {
+	fake.CopyFileStub = nil
+	if fake.copyFileReturnsOnCall == nil {
+		fake.copyFileReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.copyFileReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) GetFileCopyStatus(fileShareName string, filePath string, sasToken string) (string, error) {
+	fake.getFileCopyStatusMutex.Lock()
+	ret, specificReturn := fake.getFileCopyStatusReturnsOnCall[len(fake.getFileCopyStatusArgsForCall)]
+	fake.getFileCopyStatusArgsForCall = append(fake.getFileCopyStatusArgsForCall, struct {
+		fileShareName string
+		filePath      string
+		sasToken      string
+	}{fileShareName, filePath, sasToken})
+	fake.recordInvocation("GetFileCopyStatus", []interface{}{fileShareName, filePath, sasToken})
+	fake.getFileCopyStatusMutex.Unlock()
+	if fake.GetFileCopyStatusStub != nil {
+		return fake.GetFileCopyStatusStub(fileShareName, filePath, sasToken)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.getFileCopyStatusReturns.result1, fake.getFileCopyStatusReturns.result2
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) GetFileCopyStatusCallCount() int {
+	fake.getFileCopyStatusMutex.RLock()
+	defer fake.getFileCopyStatusMutex.RUnlock()
+	return len(fake.getFileCopyStatusArgsForCall)
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) GetFileCopyStatusArgsForCall(i int) (string, string, string) {
+	fake.getFileCopyStatusMutex.RLock()
+	defer fake.getFileCopyStatusMutex.RUnlock()
+	return fake.getFileCopyStatusArgsForCall[i].fileShareName, fake.getFileCopyStatusArgsForCall[i].filePath, fake.getFileCopyStatusArgsForCall[i].sasToken
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) GetFileCopyStatusReturns(result1 string, result2 error) {
+	fake.GetFileCopyStatusStub = nil
+	fake.getFileCopyStatusReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeAzureStorageAccountSDKClient) GetFileCopyStatusReturnsOnCall(i int, result1 string, result2 error) {
+	fake.GetFileCopyStatusStub = nil
+	if fake.getFileCopyStatusReturnsOnCall == nil {
+		fake.getFileCopyStatusReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.getFileCopyStatusReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeAzureStorageAccountSDKClient) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -424,14 +1000,32 @@ func (fake *FakeAzureStorageAccountSDKClient) Invocations() map[string][][]inter
 	defer fake.getAccessKeyMutex.RUnlock()
 	fake.deleteStorageAccountMutex.RLock()
 	defer fake.deleteStorageAccountMutex.RUnlock()
+	fake.setStorageAccountTagsMutex.RLock()
+	defer fake.setStorageAccountTagsMutex.RUnlock()
 	fake.hasFileShareMutex.RLock()
 	defer fake.hasFileShareMutex.RUnlock()
+	fake.listFileSharesMutex.RLock()
+	defer fake.listFileSharesMutex.RUnlock()
 	fake.createFileShareMutex.RLock()
 	defer fake.createFileShareMutex.RUnlock()
 	fake.deleteFileShareMutex.RLock()
 	defer fake.deleteFileShareMutex.RUnlock()
+	fake.setFileShareMetadataMutex.RLock()
+	defer fake.setFileShareMetadataMutex.RUnlock()
+	fake.createDirectoriesMutex.RLock()
+	defer fake.createDirectoriesMutex.RUnlock()
 	fake.getShareURLMutex.RLock()
 	defer fake.getShareURLMutex.RUnlock()
+	fake.verifyShareSASMutex.RLock()
+	defer fake.verifyShareSASMutex.RUnlock()
+	fake.listFilesAndDirectoriesMutex.RLock()
+	defer fake.listFilesAndDirectoriesMutex.RUnlock()
+	fake.listDirectoryMutex.RLock()
+	defer fake.listDirectoryMutex.RUnlock()
+	fake.copyFileMutex.RLock()
+	defer fake.copyFileMutex.RUnlock()
+	fake.getFileCopyStatusMutex.RLock()
+	defer fake.getFileCopyStatusMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
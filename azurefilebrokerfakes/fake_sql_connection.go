@@ -144,6 +144,17 @@ type FakeSqlConnection struct {
 	getInitializeDatabaseSQLReturnsOnCall map[int]struct {
 		result1 []string
 	}
+	GetTableNameStub        func(table string) string
+	getTableNameMutex       sync.RWMutex
+	getTableNameArgsForCall []struct {
+		table string
+	}
+	getTableNameReturns struct {
+		result1 string
+	}
+	getTableNameReturnsOnCall map[int]struct {
+		result1 string
+	}
 	GetAppLockSQLStub        func() string
 	getAppLockSQLMutex       sync.RWMutex
 	getAppLockSQLArgsForCall []struct{}
@@ -162,6 +173,26 @@ type FakeSqlConnection struct {
 	getReleaseAppLockSQLReturnsOnCall map[int]struct {
 		result1 string
 	}
+	GetAppLockSessionSQLStub        func() string
+	getAppLockSessionSQLMutex       sync.RWMutex
+	getAppLockSessionSQLArgsForCall []struct{}
+	getAppLockSessionSQLReturns     struct {
+		result1 string
+	}
+	getAppLockSessionSQLReturnsOnCall map[int]struct {
+		result1 string
+	}
+	GetKillSessionSQLStub        func(session string) string
+	getKillSessionSQLMutex       sync.RWMutex
+	getKillSessionSQLArgsForCall []struct {
+		session string
+	}
+	getKillSessionSQLReturns struct {
+		result1 string
+	}
+	getKillSessionSQLReturnsOnCall map[int]struct {
+		result1 string
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -725,6 +756,54 @@ func (fake *FakeSqlConnection) GetInitializeDatabaseSQLReturnsOnCall(i int, resu
 	}{result1}
 }
 
+func (fake *FakeSqlConnection) GetTableName(table string) string {
+	fake.getTableNameMutex.Lock()
+	ret, specificReturn := fake.getTableNameReturnsOnCall[len(fake.getTableNameArgsForCall)]
+	fake.getTableNameArgsForCall = append(fake.getTableNameArgsForCall, struct {
+		table string
+	}{table})
+	fake.recordInvocation("GetTableName", []interface{}{table})
+	fake.getTableNameMutex.Unlock()
+	if fake.GetTableNameStub != nil {
+		return fake.GetTableNameStub(table)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.getTableNameReturns.result1
+}
+
+func (fake *FakeSqlConnection) GetTableNameCallCount() int {
+	fake.getTableNameMutex.RLock()
+	defer fake.getTableNameMutex.RUnlock()
+	return len(fake.getTableNameArgsForCall)
+}
+
+func (fake *FakeSqlConnection) GetTableNameArgsForCall(i int) string {
+	fake.getTableNameMutex.RLock()
+	defer fake.getTableNameMutex.RUnlock()
+	return fake.getTableNameArgsForCall[i].table
+}
+
+func (fake *FakeSqlConnection) GetTableNameReturns(result1 string) {
+	fake.GetTableNameStub = nil
+	fake.getTableNameReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeSqlConnection) GetTableNameReturnsOnCall(i int, result1 string) {
+	fake.GetTableNameStub = nil
+	if fake.getTableNameReturnsOnCall == nil {
+		fake.getTableNameReturnsOnCall = make(map[int]struct {
+			result1 string
+		})
+	}
+	fake.getTableNameReturnsOnCall[i] = struct {
+		result1 string
+	}{result1}
+}
+
 func (fake *FakeSqlConnection) GetAppLockSQL() string {
 	fake.getAppLockSQLMutex.Lock()
 	ret, specificReturn := fake.getAppLockSQLReturnsOnCall[len(fake.getAppLockSQLArgsForCall)]
@@ -805,6 +884,94 @@ func (fake *FakeSqlConnection) GetReleaseAppLockSQLReturnsOnCall(i int, result1
 	}{result1}
 }
 
+func (fake *FakeSqlConnection) GetAppLockSessionSQL() string {
+	fake.getAppLockSessionSQLMutex.Lock()
+	ret, specificReturn := fake.getAppLockSessionSQLReturnsOnCall[len(fake.getAppLockSessionSQLArgsForCall)]
+	fake.getAppLockSessionSQLArgsForCall = append(fake.getAppLockSessionSQLArgsForCall, struct{}{})
+	fake.recordInvocation("GetAppLockSessionSQL", []interface{}{})
+	fake.getAppLockSessionSQLMutex.Unlock()
+	if fake.GetAppLockSessionSQLStub != nil {
+		return fake.GetAppLockSessionSQLStub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.getAppLockSessionSQLReturns.result1
+}
+
+func (fake *FakeSqlConnection) GetAppLockSessionSQLCallCount() int {
+	fake.getAppLockSessionSQLMutex.RLock()
+	defer fake.getAppLockSessionSQLMutex.RUnlock()
+	return len(fake.getAppLockSessionSQLArgsForCall)
+}
+
+func (fake *FakeSqlConnection) GetAppLockSessionSQLReturns(result1 string) {
+	fake.GetAppLockSessionSQLStub = nil
+	fake.getAppLockSessionSQLReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeSqlConnection) GetAppLockSessionSQLReturnsOnCall(i int, result1 string) {
+	fake.GetAppLockSessionSQLStub = nil
+	if fake.getAppLockSessionSQLReturnsOnCall == nil {
+		fake.getAppLockSessionSQLReturnsOnCall = make(map[int]struct {
+			result1 string
+		})
+	}
+	fake.getAppLockSessionSQLReturnsOnCall[i] = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeSqlConnection) GetKillSessionSQL(session string) string {
+	fake.getKillSessionSQLMutex.Lock()
+	ret, specificReturn := fake.getKillSessionSQLReturnsOnCall[len(fake.getKillSessionSQLArgsForCall)]
+	fake.getKillSessionSQLArgsForCall = append(fake.getKillSessionSQLArgsForCall, struct {
+		session string
+	}{session})
+	fake.recordInvocation("GetKillSessionSQL", []interface{}{session})
+	fake.getKillSessionSQLMutex.Unlock()
+	if fake.GetKillSessionSQLStub != nil {
+		return fake.GetKillSessionSQLStub(session)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.getKillSessionSQLReturns.result1
+}
+
+func (fake *FakeSqlConnection) GetKillSessionSQLCallCount() int {
+	fake.getKillSessionSQLMutex.RLock()
+	defer fake.getKillSessionSQLMutex.RUnlock()
+	return len(fake.getKillSessionSQLArgsForCall)
+}
+
+func (fake *FakeSqlConnection) GetKillSessionSQLArgsForCall(i int) string {
+	fake.getKillSessionSQLMutex.RLock()
+	defer fake.getKillSessionSQLMutex.RUnlock()
+	return fake.getKillSessionSQLArgsForCall[i].session
+}
+
+func (fake *FakeSqlConnection) GetKillSessionSQLReturns(result1 string) {
+	fake.GetKillSessionSQLStub = nil
+	fake.getKillSessionSQLReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeSqlConnection) GetKillSessionSQLReturnsOnCall(i int, result1 string) {
+	fake.GetKillSessionSQLStub = nil
+	if fake.getKillSessionSQLReturnsOnCall == nil {
+		fake.getKillSessionSQLReturnsOnCall = make(map[int]struct {
+			result1 string
+		})
+	}
+	fake.getKillSessionSQLReturnsOnCall[i] = struct {
+		result1 string
+	}{result1}
+}
+
 func (fake *FakeSqlConnection) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
@@ -836,10 +1003,16 @@ func (fake *FakeSqlConnection) Invocations() map[string][][]interface{} {
 	defer fake.driverMutex.RUnlock()
 	fake.getInitializeDatabaseSQLMutex.RLock()
 	defer fake.getInitializeDatabaseSQLMutex.RUnlock()
+	fake.getTableNameMutex.RLock()
+	defer fake.getTableNameMutex.RUnlock()
 	fake.getAppLockSQLMutex.RLock()
 	defer fake.getAppLockSQLMutex.RUnlock()
 	fake.getReleaseAppLockSQLMutex.RLock()
 	defer fake.getReleaseAppLockSQLMutex.RUnlock()
+	fake.getAppLockSessionSQLMutex.RLock()
+	defer fake.getAppLockSessionSQLMutex.RUnlock()
+	fake.getKillSessionSQLMutex.RLock()
+	defer fake.getKillSessionSQLMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
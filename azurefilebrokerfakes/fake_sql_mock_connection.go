@@ -16,6 +16,10 @@ func (fake FakeSQLMockConnection) GetInitializeDatabaseSQL() []string {
 	return nil
 }
 
+func (fake FakeSQLMockConnection) GetTableName(table string) string {
+	return table
+}
+
 func (fake FakeSQLMockConnection) GetAppLockSQL() string {
 	return "fakegetlock ? ?"
 }
@@ -23,3 +27,11 @@ func (fake FakeSQLMockConnection) GetAppLockSQL() string {
 func (fake FakeSQLMockConnection) GetReleaseAppLockSQL() string {
 	return "fakereleaselock ?"
 }
+
+func (fake FakeSQLMockConnection) GetAppLockSessionSQL() string {
+	return "fakelocksession ?"
+}
+
+func (fake FakeSQLMockConnection) GetKillSessionSQL(session string) string {
+	return "fakekill " + session
+}
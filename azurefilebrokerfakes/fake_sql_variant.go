@@ -20,6 +20,12 @@ type FakeSqlVariant struct {
 		result1 sqlshim.SqlDB
 		result2 error
 	}
+	SetCredentialsStub        func(username string, password string)
+	setCredentialsMutex       sync.RWMutex
+	setCredentialsArgsForCall []struct {
+		username string
+		password string
+	}
 	GetInitializeDatabaseSQLStub        func() []string
 	getInitializeDatabaseSQLMutex       sync.RWMutex
 	getInitializeDatabaseSQLArgsForCall []struct{}
@@ -29,6 +35,17 @@ type FakeSqlVariant struct {
 	getInitializeDatabaseSQLReturnsOnCall map[int]struct {
 		result1 []string
 	}
+	GetTableNameStub        func(table string) string
+	getTableNameMutex       sync.RWMutex
+	getTableNameArgsForCall []struct {
+		table string
+	}
+	getTableNameReturns struct {
+		result1 string
+	}
+	getTableNameReturnsOnCall map[int]struct {
+		result1 string
+	}
 	GetAppLockSQLStub        func() string
 	getAppLockSQLMutex       sync.RWMutex
 	getAppLockSQLArgsForCall []struct{}
@@ -47,6 +64,26 @@ type FakeSqlVariant struct {
 	getReleaseAppLockSQLReturnsOnCall map[int]struct {
 		result1 string
 	}
+	GetAppLockSessionSQLStub        func() string
+	getAppLockSessionSQLMutex       sync.RWMutex
+	getAppLockSessionSQLArgsForCall []struct{}
+	getAppLockSessionSQLReturns     struct {
+		result1 string
+	}
+	getAppLockSessionSQLReturnsOnCall map[int]struct {
+		result1 string
+	}
+	GetKillSessionSQLStub        func(session string) string
+	getKillSessionSQLMutex       sync.RWMutex
+	getKillSessionSQLArgsForCall []struct {
+		session string
+	}
+	getKillSessionSQLReturns struct {
+		result1 string
+	}
+	getKillSessionSQLReturnsOnCall map[int]struct {
+		result1 string
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -94,6 +131,31 @@ func (fake *FakeSqlVariant) ConnectReturnsOnCall(i int, result1 sqlshim.SqlDB, r
 	}{result1, result2}
 }
 
+func (fake *FakeSqlVariant) SetCredentials(username string, password string) {
+	fake.setCredentialsMutex.Lock()
+	fake.setCredentialsArgsForCall = append(fake.setCredentialsArgsForCall, struct {
+		username string
+		password string
+	}{username, password})
+	fake.recordInvocation("SetCredentials", []interface{}{username, password})
+	fake.setCredentialsMutex.Unlock()
+	if fake.SetCredentialsStub != nil {
+		fake.SetCredentialsStub(username, password)
+	}
+}
+
+func (fake *FakeSqlVariant) SetCredentialsCallCount() int {
+	fake.setCredentialsMutex.RLock()
+	defer fake.setCredentialsMutex.RUnlock()
+	return len(fake.setCredentialsArgsForCall)
+}
+
+func (fake *FakeSqlVariant) SetCredentialsArgsForCall(i int) (string, string) {
+	fake.setCredentialsMutex.RLock()
+	defer fake.setCredentialsMutex.RUnlock()
+	return fake.setCredentialsArgsForCall[i].username, fake.setCredentialsArgsForCall[i].password
+}
+
 func (fake *FakeSqlVariant) GetInitializeDatabaseSQL() []string {
 	fake.getInitializeDatabaseSQLMutex.Lock()
 	ret, specificReturn := fake.getInitializeDatabaseSQLReturnsOnCall[len(fake.getInitializeDatabaseSQLArgsForCall)]
@@ -134,6 +196,54 @@ func (fake *FakeSqlVariant) GetInitializeDatabaseSQLReturnsOnCall(i int, result1
 	}{result1}
 }
 
+func (fake *FakeSqlVariant) GetTableName(table string) string {
+	fake.getTableNameMutex.Lock()
+	ret, specificReturn := fake.getTableNameReturnsOnCall[len(fake.getTableNameArgsForCall)]
+	fake.getTableNameArgsForCall = append(fake.getTableNameArgsForCall, struct {
+		table string
+	}{table})
+	fake.recordInvocation("GetTableName", []interface{}{table})
+	fake.getTableNameMutex.Unlock()
+	if fake.GetTableNameStub != nil {
+		return fake.GetTableNameStub(table)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.getTableNameReturns.result1
+}
+
+func (fake *FakeSqlVariant) GetTableNameCallCount() int {
+	fake.getTableNameMutex.RLock()
+	defer fake.getTableNameMutex.RUnlock()
+	return len(fake.getTableNameArgsForCall)
+}
+
+func (fake *FakeSqlVariant) GetTableNameArgsForCall(i int) string {
+	fake.getTableNameMutex.RLock()
+	defer fake.getTableNameMutex.RUnlock()
+	return fake.getTableNameArgsForCall[i].table
+}
+
+func (fake *FakeSqlVariant) GetTableNameReturns(result1 string) {
+	fake.GetTableNameStub = nil
+	fake.getTableNameReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeSqlVariant) GetTableNameReturnsOnCall(i int, result1 string) {
+	fake.GetTableNameStub = nil
+	if fake.getTableNameReturnsOnCall == nil {
+		fake.getTableNameReturnsOnCall = make(map[int]struct {
+			result1 string
+		})
+	}
+	fake.getTableNameReturnsOnCall[i] = struct {
+		result1 string
+	}{result1}
+}
+
 func (fake *FakeSqlVariant) GetAppLockSQL() string {
 	fake.getAppLockSQLMutex.Lock()
 	ret, specificReturn := fake.getAppLockSQLReturnsOnCall[len(fake.getAppLockSQLArgsForCall)]
@@ -214,17 +324,113 @@ func (fake *FakeSqlVariant) GetReleaseAppLockSQLReturnsOnCall(i int, result1 str
 	}{result1}
 }
 
+func (fake *FakeSqlVariant) GetAppLockSessionSQL() string {
+	fake.getAppLockSessionSQLMutex.Lock()
+	ret, specificReturn := fake.getAppLockSessionSQLReturnsOnCall[len(fake.getAppLockSessionSQLArgsForCall)]
+	fake.getAppLockSessionSQLArgsForCall = append(fake.getAppLockSessionSQLArgsForCall, struct{}{})
+	fake.recordInvocation("GetAppLockSessionSQL", []interface{}{})
+	fake.getAppLockSessionSQLMutex.Unlock()
+	if fake.GetAppLockSessionSQLStub != nil {
+		return fake.GetAppLockSessionSQLStub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.getAppLockSessionSQLReturns.result1
+}
+
+func (fake *FakeSqlVariant) GetAppLockSessionSQLCallCount() int {
+	fake.getAppLockSessionSQLMutex.RLock()
+	defer fake.getAppLockSessionSQLMutex.RUnlock()
+	return len(fake.getAppLockSessionSQLArgsForCall)
+}
+
+func (fake *FakeSqlVariant) GetAppLockSessionSQLReturns(result1 string) {
+	fake.GetAppLockSessionSQLStub = nil
+	fake.getAppLockSessionSQLReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeSqlVariant) GetAppLockSessionSQLReturnsOnCall(i int, result1 string) {
+	fake.GetAppLockSessionSQLStub = nil
+	if fake.getAppLockSessionSQLReturnsOnCall == nil {
+		fake.getAppLockSessionSQLReturnsOnCall = make(map[int]struct {
+			result1 string
+		})
+	}
+	fake.getAppLockSessionSQLReturnsOnCall[i] = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeSqlVariant) GetKillSessionSQL(session string) string {
+	fake.getKillSessionSQLMutex.Lock()
+	ret, specificReturn := fake.getKillSessionSQLReturnsOnCall[len(fake.getKillSessionSQLArgsForCall)]
+	fake.getKillSessionSQLArgsForCall = append(fake.getKillSessionSQLArgsForCall, struct {
+		session string
+	}{session})
+	fake.recordInvocation("GetKillSessionSQL", []interface{}{session})
+	fake.getKillSessionSQLMutex.Unlock()
+	if fake.GetKillSessionSQLStub != nil {
+		return fake.GetKillSessionSQLStub(session)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.getKillSessionSQLReturns.result1
+}
+
+func (fake *FakeSqlVariant) GetKillSessionSQLCallCount() int {
+	fake.getKillSessionSQLMutex.RLock()
+	defer fake.getKillSessionSQLMutex.RUnlock()
+	return len(fake.getKillSessionSQLArgsForCall)
+}
+
+func (fake *FakeSqlVariant) GetKillSessionSQLArgsForCall(i int) string {
+	fake.getKillSessionSQLMutex.RLock()
+	defer fake.getKillSessionSQLMutex.RUnlock()
+	return fake.getKillSessionSQLArgsForCall[i].session
+}
+
+func (fake *FakeSqlVariant) GetKillSessionSQLReturns(result1 string) {
+	fake.GetKillSessionSQLStub = nil
+	fake.getKillSessionSQLReturns = struct {
+		result1 string
+	}{result1}
+}
+
+func (fake *FakeSqlVariant) GetKillSessionSQLReturnsOnCall(i int, result1 string) {
+	fake.GetKillSessionSQLStub = nil
+	if fake.getKillSessionSQLReturnsOnCall == nil {
+		fake.getKillSessionSQLReturnsOnCall = make(map[int]struct {
+			result1 string
+		})
+	}
+	fake.getKillSessionSQLReturnsOnCall[i] = struct {
+		result1 string
+	}{result1}
+}
+
 func (fake *FakeSqlVariant) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.connectMutex.RLock()
 	defer fake.connectMutex.RUnlock()
+	fake.setCredentialsMutex.RLock()
+	defer fake.setCredentialsMutex.RUnlock()
 	fake.getInitializeDatabaseSQLMutex.RLock()
 	defer fake.getInitializeDatabaseSQLMutex.RUnlock()
+	fake.getTableNameMutex.RLock()
+	defer fake.getTableNameMutex.RUnlock()
 	fake.getAppLockSQLMutex.RLock()
 	defer fake.getAppLockSQLMutex.RUnlock()
 	fake.getReleaseAppLockSQLMutex.RLock()
 	defer fake.getReleaseAppLockSQLMutex.RUnlock()
+	fake.getAppLockSessionSQLMutex.RLock()
+	defer fake.getAppLockSessionSQLMutex.RUnlock()
+	fake.getKillSessionSQLMutex.RLock()
+	defer fake.getKillSessionSQLMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -5,7 +5,6 @@ import (
 	"sync"
 
 	"code.cloudfoundry.org/azurefilebroker/azurefilebroker"
-	"github.com/pivotal-cf/brokerapi"
 )
 
 type FakeStore struct {
@@ -22,17 +21,52 @@ type FakeStore struct {
 		result1 azurefilebroker.ServiceInstance
 		result2 error
 	}
-	RetrieveBindingDetailsStub        func(id string) (brokerapi.BindDetails, error)
+	RetrieveServiceInstancesStub        func() (map[string]azurefilebroker.ServiceInstance, error)
+	retrieveServiceInstancesMutex       sync.RWMutex
+	retrieveServiceInstancesArgsForCall []struct{}
+	retrieveServiceInstancesReturns     struct {
+		result1 map[string]azurefilebroker.ServiceInstance
+		result2 error
+	}
+	retrieveServiceInstancesReturnsOnCall map[int]struct {
+		result1 map[string]azurefilebroker.ServiceInstance
+		result2 error
+	}
+	RetrieveServiceInstancesByTargetNameStub        func(targetName string) (map[string]azurefilebroker.ServiceInstance, error)
+	retrieveServiceInstancesByTargetNameMutex       sync.RWMutex
+	retrieveServiceInstancesByTargetNameArgsForCall []struct {
+		targetName string
+	}
+	retrieveServiceInstancesByTargetNameReturns struct {
+		result1 map[string]azurefilebroker.ServiceInstance
+		result2 error
+	}
+	retrieveServiceInstancesByTargetNameReturnsOnCall map[int]struct {
+		result1 map[string]azurefilebroker.ServiceInstance
+		result2 error
+	}
+	RetrieveBindingDetailsStub        func(id string) (azurefilebroker.BindingDetails, error)
 	retrieveBindingDetailsMutex       sync.RWMutex
 	retrieveBindingDetailsArgsForCall []struct {
 		id string
 	}
 	retrieveBindingDetailsReturns struct {
-		result1 brokerapi.BindDetails
+		result1 azurefilebroker.BindingDetails
 		result2 error
 	}
 	retrieveBindingDetailsReturnsOnCall map[int]struct {
-		result1 brokerapi.BindDetails
+		result1 azurefilebroker.BindingDetails
+		result2 error
+	}
+	RetrieveAllBindingDetailsStub        func() (map[string]azurefilebroker.BindingDetails, error)
+	retrieveAllBindingDetailsMutex       sync.RWMutex
+	retrieveAllBindingDetailsArgsForCall []struct{}
+	retrieveAllBindingDetailsReturns     struct {
+		result1 map[string]azurefilebroker.BindingDetails
+		result2 error
+	}
+	retrieveAllBindingDetailsReturnsOnCall map[int]struct {
+		result1 map[string]azurefilebroker.BindingDetails
 		result2 error
 	}
 	RetrieveFileShareStub        func(id string) (azurefilebroker.FileShare, error)
@@ -48,6 +82,137 @@ type FakeStore struct {
 		result1 azurefilebroker.FileShare
 		result2 error
 	}
+	RetrieveFileSharesStub        func() (map[string]azurefilebroker.FileShare, error)
+	retrieveFileSharesMutex       sync.RWMutex
+	retrieveFileSharesArgsForCall []struct{}
+	retrieveFileSharesReturns     struct {
+		result1 map[string]azurefilebroker.FileShare
+		result2 error
+	}
+	retrieveFileSharesReturnsOnCall map[int]struct {
+		result1 map[string]azurefilebroker.FileShare
+		result2 error
+	}
+	RetrieveStorageAccountOwnerStub        func(id string) (azurefilebroker.StorageAccountOwner, error)
+	retrieveStorageAccountOwnerMutex       sync.RWMutex
+	retrieveStorageAccountOwnerArgsForCall []struct {
+		id string
+	}
+	retrieveStorageAccountOwnerReturns struct {
+		result1 azurefilebroker.StorageAccountOwner
+		result2 error
+	}
+	retrieveStorageAccountOwnerReturnsOnCall map[int]struct {
+		result1 azurefilebroker.StorageAccountOwner
+		result2 error
+	}
+	RetrieveFileShareOwnerStub        func(id string) (azurefilebroker.FileShareOwner, error)
+	retrieveFileShareOwnerMutex       sync.RWMutex
+	retrieveFileShareOwnerArgsForCall []struct {
+		id string
+	}
+	retrieveFileShareOwnerReturns struct {
+		result1 azurefilebroker.FileShareOwner
+		result2 error
+	}
+	retrieveFileShareOwnerReturnsOnCall map[int]struct {
+		result1 azurefilebroker.FileShareOwner
+		result2 error
+	}
+	RetrieveScheduledDeletionStub        func(id string) (azurefilebroker.ScheduledDeletion, error)
+	retrieveScheduledDeletionMutex       sync.RWMutex
+	retrieveScheduledDeletionArgsForCall []struct {
+		id string
+	}
+	retrieveScheduledDeletionReturns struct {
+		result1 azurefilebroker.ScheduledDeletion
+		result2 error
+	}
+	retrieveScheduledDeletionReturnsOnCall map[int]struct {
+		result1 azurefilebroker.ScheduledDeletion
+		result2 error
+	}
+	RetrieveScheduledDeletionsStub        func() (map[string]azurefilebroker.ScheduledDeletion, error)
+	retrieveScheduledDeletionsMutex       sync.RWMutex
+	retrieveScheduledDeletionsArgsForCall []struct{}
+	retrieveScheduledDeletionsReturns     struct {
+		result1 map[string]azurefilebroker.ScheduledDeletion
+		result2 error
+	}
+	retrieveScheduledDeletionsReturnsOnCall map[int]struct {
+		result1 map[string]azurefilebroker.ScheduledDeletion
+		result2 error
+	}
+	RetrieveFeatureFlagsStub        func() (map[string]azurefilebroker.FeatureFlag, error)
+	retrieveFeatureFlagsMutex       sync.RWMutex
+	retrieveFeatureFlagsArgsForCall []struct{}
+	retrieveFeatureFlagsReturns     struct {
+		result1 map[string]azurefilebroker.FeatureFlag
+		result2 error
+	}
+	retrieveFeatureFlagsReturnsOnCall map[int]struct {
+		result1 map[string]azurefilebroker.FeatureFlag
+		result2 error
+	}
+	RetrievePendingShareDeletionsStub        func() (map[string]azurefilebroker.PendingShareDeletion, error)
+	retrievePendingShareDeletionsMutex       sync.RWMutex
+	retrievePendingShareDeletionsArgsForCall []struct{}
+	retrievePendingShareDeletionsReturns     struct {
+		result1 map[string]azurefilebroker.PendingShareDeletion
+		result2 error
+	}
+	retrievePendingShareDeletionsReturnsOnCall map[int]struct {
+		result1 map[string]azurefilebroker.PendingShareDeletion
+		result2 error
+	}
+	RetrievePooledStorageAccountsStub        func() (map[string]azurefilebroker.PooledStorageAccount, error)
+	retrievePooledStorageAccountsMutex       sync.RWMutex
+	retrievePooledStorageAccountsArgsForCall []struct{}
+	retrievePooledStorageAccountsReturns     struct {
+		result1 map[string]azurefilebroker.PooledStorageAccount
+		result2 error
+	}
+	retrievePooledStorageAccountsReturnsOnCall map[int]struct {
+		result1 map[string]azurefilebroker.PooledStorageAccount
+		result2 error
+	}
+	RetrieveInstanceOperationsStub        func(instanceID string) ([]azurefilebroker.InstanceOperation, error)
+	retrieveInstanceOperationsMutex       sync.RWMutex
+	retrieveInstanceOperationsArgsForCall []struct {
+		instanceID string
+	}
+	retrieveInstanceOperationsReturns struct {
+		result1 []azurefilebroker.InstanceOperation
+		result2 error
+	}
+	retrieveInstanceOperationsReturnsOnCall map[int]struct {
+		result1 []azurefilebroker.InstanceOperation
+		result2 error
+	}
+	RetrieveLockHolderStub        func(id string) (azurefilebroker.LockHolder, error)
+	retrieveLockHolderMutex       sync.RWMutex
+	retrieveLockHolderArgsForCall []struct {
+		id string
+	}
+	retrieveLockHolderReturns struct {
+		result1 azurefilebroker.LockHolder
+		result2 error
+	}
+	retrieveLockHolderReturnsOnCall map[int]struct {
+		result1 azurefilebroker.LockHolder
+		result2 error
+	}
+	RetrieveLockHoldersStub        func() (map[string]azurefilebroker.LockHolder, error)
+	retrieveLockHoldersMutex       sync.RWMutex
+	retrieveLockHoldersArgsForCall []struct{}
+	retrieveLockHoldersReturns     struct {
+		result1 map[string]azurefilebroker.LockHolder
+		result2 error
+	}
+	retrieveLockHoldersReturnsOnCall map[int]struct {
+		result1 map[string]azurefilebroker.LockHolder
+		result2 error
+	}
 	CreateServiceInstanceStub        func(id string, instance azurefilebroker.ServiceInstance) error
 	createServiceInstanceMutex       sync.RWMutex
 	createServiceInstanceArgsForCall []struct {
@@ -60,12 +225,11 @@ type FakeStore struct {
 	createServiceInstanceReturnsOnCall map[int]struct {
 		result1 error
 	}
-	CreateBindingDetailsStub        func(id string, details brokerapi.BindDetails, redactRawParameter bool) error
+	CreateBindingDetailsStub        func(id string, details azurefilebroker.BindingDetails) error
 	createBindingDetailsMutex       sync.RWMutex
 	createBindingDetailsArgsForCall []struct {
-		id                 string
-		details            brokerapi.BindDetails
-		redactRawParameter bool
+		id      string
+		details azurefilebroker.BindingDetails
 	}
 	createBindingDetailsReturns struct {
 		result1 error
@@ -85,6 +249,114 @@ type FakeStore struct {
 	createFileShareReturnsOnCall map[int]struct {
 		result1 error
 	}
+	CreateStorageAccountOwnerStub        func(id string, owner azurefilebroker.StorageAccountOwner) error
+	createStorageAccountOwnerMutex       sync.RWMutex
+	createStorageAccountOwnerArgsForCall []struct {
+		id    string
+		owner azurefilebroker.StorageAccountOwner
+	}
+	createStorageAccountOwnerReturns struct {
+		result1 error
+	}
+	createStorageAccountOwnerReturnsOnCall map[int]struct {
+		result1 error
+	}
+	CreateFileShareOwnerStub        func(id string, owner azurefilebroker.FileShareOwner) error
+	createFileShareOwnerMutex       sync.RWMutex
+	createFileShareOwnerArgsForCall []struct {
+		id    string
+		owner azurefilebroker.FileShareOwner
+	}
+	createFileShareOwnerReturns struct {
+		result1 error
+	}
+	createFileShareOwnerReturnsOnCall map[int]struct {
+		result1 error
+	}
+	CreateScheduledDeletionStub        func(id string, deletion azurefilebroker.ScheduledDeletion) error
+	createScheduledDeletionMutex       sync.RWMutex
+	createScheduledDeletionArgsForCall []struct {
+		id       string
+		deletion azurefilebroker.ScheduledDeletion
+	}
+	createScheduledDeletionReturns struct {
+		result1 error
+	}
+	createScheduledDeletionReturnsOnCall map[int]struct {
+		result1 error
+	}
+	CreateFeatureFlagStub        func(id string, flag azurefilebroker.FeatureFlag) error
+	createFeatureFlagMutex       sync.RWMutex
+	createFeatureFlagArgsForCall []struct {
+		id   string
+		flag azurefilebroker.FeatureFlag
+	}
+	createFeatureFlagReturns struct {
+		result1 error
+	}
+	createFeatureFlagReturnsOnCall map[int]struct {
+		result1 error
+	}
+	CreatePendingShareDeletionStub        func(id string, deletion azurefilebroker.PendingShareDeletion) error
+	createPendingShareDeletionMutex       sync.RWMutex
+	createPendingShareDeletionArgsForCall []struct {
+		id       string
+		deletion azurefilebroker.PendingShareDeletion
+	}
+	createPendingShareDeletionReturns struct {
+		result1 error
+	}
+	createPendingShareDeletionReturnsOnCall map[int]struct {
+		result1 error
+	}
+	CreatePooledStorageAccountStub        func(id string, account azurefilebroker.PooledStorageAccount) error
+	createPooledStorageAccountMutex       sync.RWMutex
+	createPooledStorageAccountArgsForCall []struct {
+		id      string
+		account azurefilebroker.PooledStorageAccount
+	}
+	createPooledStorageAccountReturns struct {
+		result1 error
+	}
+	createPooledStorageAccountReturnsOnCall map[int]struct {
+		result1 error
+	}
+	CreateInstanceOperationStub        func(id string, operation azurefilebroker.InstanceOperation) error
+	createInstanceOperationMutex       sync.RWMutex
+	createInstanceOperationArgsForCall []struct {
+		id        string
+		operation azurefilebroker.InstanceOperation
+	}
+	createInstanceOperationReturns struct {
+		result1 error
+	}
+	createInstanceOperationReturnsOnCall map[int]struct {
+		result1 error
+	}
+	CreateLockHolderStub        func(id string, holder azurefilebroker.LockHolder) error
+	createLockHolderMutex       sync.RWMutex
+	createLockHolderArgsForCall []struct {
+		id     string
+		holder azurefilebroker.LockHolder
+	}
+	createLockHolderReturns struct {
+		result1 error
+	}
+	createLockHolderReturnsOnCall map[int]struct {
+		result1 error
+	}
+	UpdateServiceInstanceStub        func(id string, instance azurefilebroker.ServiceInstance) error
+	updateServiceInstanceMutex       sync.RWMutex
+	updateServiceInstanceArgsForCall []struct {
+		id       string
+		instance azurefilebroker.ServiceInstance
+	}
+	updateServiceInstanceReturns struct {
+		result1 error
+	}
+	updateServiceInstanceReturnsOnCall map[int]struct {
+		result1 error
+	}
 	UpdateFileShareStub        func(id string, share azurefilebroker.FileShare) error
 	updateFileShareMutex       sync.RWMutex
 	updateFileShareArgsForCall []struct {
@@ -97,6 +369,66 @@ type FakeStore struct {
 	updateFileShareReturnsOnCall map[int]struct {
 		result1 error
 	}
+	UpdateFileShareOwnerStub        func(id string, owner azurefilebroker.FileShareOwner) error
+	updateFileShareOwnerMutex       sync.RWMutex
+	updateFileShareOwnerArgsForCall []struct {
+		id    string
+		owner azurefilebroker.FileShareOwner
+	}
+	updateFileShareOwnerReturns struct {
+		result1 error
+	}
+	updateFileShareOwnerReturnsOnCall map[int]struct {
+		result1 error
+	}
+	UpdateFeatureFlagStub        func(id string, flag azurefilebroker.FeatureFlag) error
+	updateFeatureFlagMutex       sync.RWMutex
+	updateFeatureFlagArgsForCall []struct {
+		id   string
+		flag azurefilebroker.FeatureFlag
+	}
+	updateFeatureFlagReturns struct {
+		result1 error
+	}
+	updateFeatureFlagReturnsOnCall map[int]struct {
+		result1 error
+	}
+	UpdatePendingShareDeletionStub        func(id string, deletion azurefilebroker.PendingShareDeletion) error
+	updatePendingShareDeletionMutex       sync.RWMutex
+	updatePendingShareDeletionArgsForCall []struct {
+		id       string
+		deletion azurefilebroker.PendingShareDeletion
+	}
+	updatePendingShareDeletionReturns struct {
+		result1 error
+	}
+	updatePendingShareDeletionReturnsOnCall map[int]struct {
+		result1 error
+	}
+	UpdatePooledStorageAccountStub        func(id string, account azurefilebroker.PooledStorageAccount) error
+	updatePooledStorageAccountMutex       sync.RWMutex
+	updatePooledStorageAccountArgsForCall []struct {
+		id      string
+		account azurefilebroker.PooledStorageAccount
+	}
+	updatePooledStorageAccountReturns struct {
+		result1 error
+	}
+	updatePooledStorageAccountReturnsOnCall map[int]struct {
+		result1 error
+	}
+	UpdateLockHolderStub        func(id string, holder azurefilebroker.LockHolder) error
+	updateLockHolderMutex       sync.RWMutex
+	updateLockHolderArgsForCall []struct {
+		id     string
+		holder azurefilebroker.LockHolder
+	}
+	updateLockHolderReturns struct {
+		result1 error
+	}
+	updateLockHolderReturnsOnCall map[int]struct {
+		result1 error
+	}
 	DeleteServiceInstanceStub        func(id string) error
 	deleteServiceInstanceMutex       sync.RWMutex
 	deleteServiceInstanceArgsForCall []struct {
@@ -130,6 +462,72 @@ type FakeStore struct {
 	deleteFileShareReturnsOnCall map[int]struct {
 		result1 error
 	}
+	DeleteStorageAccountOwnerStub        func(id string) error
+	deleteStorageAccountOwnerMutex       sync.RWMutex
+	deleteStorageAccountOwnerArgsForCall []struct {
+		id string
+	}
+	deleteStorageAccountOwnerReturns struct {
+		result1 error
+	}
+	deleteStorageAccountOwnerReturnsOnCall map[int]struct {
+		result1 error
+	}
+	DeleteFileShareOwnerStub        func(id string) error
+	deleteFileShareOwnerMutex       sync.RWMutex
+	deleteFileShareOwnerArgsForCall []struct {
+		id string
+	}
+	deleteFileShareOwnerReturns struct {
+		result1 error
+	}
+	deleteFileShareOwnerReturnsOnCall map[int]struct {
+		result1 error
+	}
+	DeleteScheduledDeletionStub        func(id string) error
+	deleteScheduledDeletionMutex       sync.RWMutex
+	deleteScheduledDeletionArgsForCall []struct {
+		id string
+	}
+	deleteScheduledDeletionReturns struct {
+		result1 error
+	}
+	deleteScheduledDeletionReturnsOnCall map[int]struct {
+		result1 error
+	}
+	DeleteFeatureFlagStub        func(id string) error
+	deleteFeatureFlagMutex       sync.RWMutex
+	deleteFeatureFlagArgsForCall []struct {
+		id string
+	}
+	deleteFeatureFlagReturns struct {
+		result1 error
+	}
+	deleteFeatureFlagReturnsOnCall map[int]struct {
+		result1 error
+	}
+	DeletePendingShareDeletionStub        func(id string) error
+	deletePendingShareDeletionMutex       sync.RWMutex
+	deletePendingShareDeletionArgsForCall []struct {
+		id string
+	}
+	deletePendingShareDeletionReturns struct {
+		result1 error
+	}
+	deletePendingShareDeletionReturnsOnCall map[int]struct {
+		result1 error
+	}
+	DeletePooledStorageAccountStub        func(id string) error
+	deletePooledStorageAccountMutex       sync.RWMutex
+	deletePooledStorageAccountArgsForCall []struct {
+		id string
+	}
+	deletePooledStorageAccountReturns struct {
+		result1 error
+	}
+	deletePooledStorageAccountReturnsOnCall map[int]struct {
+		result1 error
+	}
 	GetLockForUpdateStub        func(lockName string, timeoutInSeconds int) error
 	getLockForUpdateMutex       sync.RWMutex
 	getLockForUpdateArgsForCall []struct {
@@ -208,7 +606,101 @@ func (fake *FakeStore) RetrieveServiceInstanceReturnsOnCall(i int, result1 azure
 	}{result1, result2}
 }
 
-func (fake *FakeStore) RetrieveBindingDetails(id string) (brokerapi.BindDetails, error) {
+func (fake *FakeStore) RetrieveServiceInstances() (map[string]azurefilebroker.ServiceInstance, error) {
+	fake.retrieveServiceInstancesMutex.Lock()
+	ret, specificReturn := fake.retrieveServiceInstancesReturnsOnCall[len(fake.retrieveServiceInstancesArgsForCall)]
+	fake.retrieveServiceInstancesArgsForCall = append(fake.retrieveServiceInstancesArgsForCall, struct{}{})
+	fake.recordInvocation("RetrieveServiceInstances", []interface{}{})
+	fake.retrieveServiceInstancesMutex.Unlock()
+	if fake.RetrieveServiceInstancesStub != nil {
+		return fake.RetrieveServiceInstancesStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrieveServiceInstancesReturns.result1, fake.retrieveServiceInstancesReturns.result2
+}
+
+func (fake *FakeStore) RetrieveServiceInstancesCallCount() int {
+	fake.retrieveServiceInstancesMutex.RLock()
+	defer fake.retrieveServiceInstancesMutex.RUnlock()
+	return len(fake.retrieveServiceInstancesArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveServiceInstancesReturns(result1 map[string]azurefilebroker.ServiceInstance, result2 error) {
+	fake.RetrieveServiceInstancesStub = nil
+	fake.retrieveServiceInstancesReturns = struct {
+		result1 map[string]azurefilebroker.ServiceInstance
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveServiceInstancesReturnsOnCall(i int, result1 map[string]azurefilebroker.ServiceInstance, result2 error) {
+	fake.RetrieveServiceInstancesStub = nil
+	if fake.retrieveServiceInstancesReturnsOnCall == nil {
+		fake.retrieveServiceInstancesReturnsOnCall = make(map[int]struct {
+			result1 map[string]azurefilebroker.ServiceInstance
+			result2 error
+		})
+	}
+	fake.retrieveServiceInstancesReturnsOnCall[i] = struct {
+		result1 map[string]azurefilebroker.ServiceInstance
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveServiceInstancesByTargetName(targetName string) (map[string]azurefilebroker.ServiceInstance, error) {
+	fake.retrieveServiceInstancesByTargetNameMutex.Lock()
+	ret, specificReturn := fake.retrieveServiceInstancesByTargetNameReturnsOnCall[len(fake.retrieveServiceInstancesByTargetNameArgsForCall)]
+	fake.retrieveServiceInstancesByTargetNameArgsForCall = append(fake.retrieveServiceInstancesByTargetNameArgsForCall, struct {
+		targetName string
+	}{targetName})
+	fake.recordInvocation("RetrieveServiceInstancesByTargetName", []interface{}{targetName})
+	fake.retrieveServiceInstancesByTargetNameMutex.Unlock()
+	if fake.RetrieveServiceInstancesByTargetNameStub != nil {
+		return fake.RetrieveServiceInstancesByTargetNameStub(targetName)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrieveServiceInstancesByTargetNameReturns.result1, fake.retrieveServiceInstancesByTargetNameReturns.result2
+}
+
+func (fake *FakeStore) RetrieveServiceInstancesByTargetNameCallCount() int {
+	fake.retrieveServiceInstancesByTargetNameMutex.RLock()
+	defer fake.retrieveServiceInstancesByTargetNameMutex.RUnlock()
+	return len(fake.retrieveServiceInstancesByTargetNameArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveServiceInstancesByTargetNameArgsForCall(i int) string {
+	fake.retrieveServiceInstancesByTargetNameMutex.RLock()
+	defer fake.retrieveServiceInstancesByTargetNameMutex.RUnlock()
+	return fake.retrieveServiceInstancesByTargetNameArgsForCall[i].targetName
+}
+
+func (fake *FakeStore) RetrieveServiceInstancesByTargetNameReturns(result1 map[string]azurefilebroker.ServiceInstance, result2 error) {
+	fake.RetrieveServiceInstancesByTargetNameStub = nil
+	fake.retrieveServiceInstancesByTargetNameReturns = struct {
+		result1 map[string]azurefilebroker.ServiceInstance
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveServiceInstancesByTargetNameReturnsOnCall(i int, result1 map[string]azurefilebroker.ServiceInstance, result2 error) {
+	fake.RetrieveServiceInstancesByTargetNameStub = nil
+	if fake.retrieveServiceInstancesByTargetNameReturnsOnCall == nil {
+		fake.retrieveServiceInstancesByTargetNameReturnsOnCall = make(map[int]struct {
+			result1 map[string]azurefilebroker.ServiceInstance
+			result2 error
+		})
+	}
+	fake.retrieveServiceInstancesByTargetNameReturnsOnCall[i] = struct {
+		result1 map[string]azurefilebroker.ServiceInstance
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveBindingDetails(id string) (azurefilebroker.BindingDetails, error) {
 	fake.retrieveBindingDetailsMutex.Lock()
 	ret, specificReturn := fake.retrieveBindingDetailsReturnsOnCall[len(fake.retrieveBindingDetailsArgsForCall)]
 	fake.retrieveBindingDetailsArgsForCall = append(fake.retrieveBindingDetailsArgsForCall, struct {
@@ -237,24 +729,67 @@ func (fake *FakeStore) RetrieveBindingDetailsArgsForCall(i int) string {
 	return fake.retrieveBindingDetailsArgsForCall[i].id
 }
 
-func (fake *FakeStore) RetrieveBindingDetailsReturns(result1 brokerapi.BindDetails, result2 error) {
+func (fake *FakeStore) RetrieveBindingDetailsReturns(result1 azurefilebroker.BindingDetails, result2 error) {
 	fake.RetrieveBindingDetailsStub = nil
 	fake.retrieveBindingDetailsReturns = struct {
-		result1 brokerapi.BindDetails
+		result1 azurefilebroker.BindingDetails
 		result2 error
 	}{result1, result2}
 }
 
-func (fake *FakeStore) RetrieveBindingDetailsReturnsOnCall(i int, result1 brokerapi.BindDetails, result2 error) {
+func (fake *FakeStore) RetrieveBindingDetailsReturnsOnCall(i int, result1 azurefilebroker.BindingDetails, result2 error) {
 	fake.RetrieveBindingDetailsStub = nil
 	if fake.retrieveBindingDetailsReturnsOnCall == nil {
 		fake.retrieveBindingDetailsReturnsOnCall = make(map[int]struct {
-			result1 brokerapi.BindDetails
+			result1 azurefilebroker.BindingDetails
 			result2 error
 		})
 	}
 	fake.retrieveBindingDetailsReturnsOnCall[i] = struct {
-		result1 brokerapi.BindDetails
+		result1 azurefilebroker.BindingDetails
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveAllBindingDetails() (map[string]azurefilebroker.BindingDetails, error) {
+	fake.retrieveAllBindingDetailsMutex.Lock()
+	ret, specificReturn := fake.retrieveAllBindingDetailsReturnsOnCall[len(fake.retrieveAllBindingDetailsArgsForCall)]
+	fake.retrieveAllBindingDetailsArgsForCall = append(fake.retrieveAllBindingDetailsArgsForCall, struct{}{})
+	fake.recordInvocation("RetrieveAllBindingDetails", []interface{}{})
+	fake.retrieveAllBindingDetailsMutex.Unlock()
+	if fake.RetrieveAllBindingDetailsStub != nil {
+		return fake.RetrieveAllBindingDetailsStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrieveAllBindingDetailsReturns.result1, fake.retrieveAllBindingDetailsReturns.result2
+}
+
+func (fake *FakeStore) RetrieveAllBindingDetailsCallCount() int {
+	fake.retrieveAllBindingDetailsMutex.RLock()
+	defer fake.retrieveAllBindingDetailsMutex.RUnlock()
+	return len(fake.retrieveAllBindingDetailsArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveAllBindingDetailsReturns(result1 map[string]azurefilebroker.BindingDetails, result2 error) {
+	fake.RetrieveAllBindingDetailsStub = nil
+	fake.retrieveAllBindingDetailsReturns = struct {
+		result1 map[string]azurefilebroker.BindingDetails
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveAllBindingDetailsReturnsOnCall(i int, result1 map[string]azurefilebroker.BindingDetails, result2 error) {
+	fake.RetrieveAllBindingDetailsStub = nil
+	if fake.retrieveAllBindingDetailsReturnsOnCall == nil {
+		fake.retrieveAllBindingDetailsReturnsOnCall = make(map[int]struct {
+			result1 map[string]azurefilebroker.BindingDetails
+			result2 error
+		})
+	}
+	fake.retrieveAllBindingDetailsReturnsOnCall[i] = struct {
+		result1 map[string]azurefilebroker.BindingDetails
 		result2 error
 	}{result1, result2}
 }
@@ -310,199 +845,1397 @@ func (fake *FakeStore) RetrieveFileShareReturnsOnCall(i int, result1 azurefilebr
 	}{result1, result2}
 }
 
-func (fake *FakeStore) CreateServiceInstance(id string, instance azurefilebroker.ServiceInstance) error {
-	fake.createServiceInstanceMutex.Lock()
-	ret, specificReturn := fake.createServiceInstanceReturnsOnCall[len(fake.createServiceInstanceArgsForCall)]
-	fake.createServiceInstanceArgsForCall = append(fake.createServiceInstanceArgsForCall, struct {
-		id       string
-		instance azurefilebroker.ServiceInstance
-	}{id, instance})
-	fake.recordInvocation("CreateServiceInstance", []interface{}{id, instance})
-	fake.createServiceInstanceMutex.Unlock()
-	if fake.CreateServiceInstanceStub != nil {
-		return fake.CreateServiceInstanceStub(id, instance)
+func (fake *FakeStore) RetrieveFileShares() (map[string]azurefilebroker.FileShare, error) {
+	fake.retrieveFileSharesMutex.Lock()
+	ret, specificReturn := fake.retrieveFileSharesReturnsOnCall[len(fake.retrieveFileSharesArgsForCall)]
+	fake.retrieveFileSharesArgsForCall = append(fake.retrieveFileSharesArgsForCall, struct{}{})
+	fake.recordInvocation("RetrieveFileShares", []interface{}{})
+	fake.retrieveFileSharesMutex.Unlock()
+	if fake.RetrieveFileSharesStub != nil {
+		return fake.RetrieveFileSharesStub()
 	}
 	if specificReturn {
-		return ret.result1
+		return ret.result1, ret.result2
 	}
-	return fake.createServiceInstanceReturns.result1
+	return fake.retrieveFileSharesReturns.result1, fake.retrieveFileSharesReturns.result2
 }
 
-func (fake *FakeStore) CreateServiceInstanceCallCount() int {
-	fake.createServiceInstanceMutex.RLock()
-	defer fake.createServiceInstanceMutex.RUnlock()
+func (fake *FakeStore) RetrieveFileSharesCallCount() int {
+	fake.retrieveFileSharesMutex.RLock()
+	defer fake.retrieveFileSharesMutex.RUnlock()
+	return len(fake.retrieveFileSharesArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveFileSharesReturns(result1 map[string]azurefilebroker.FileShare, result2 error) {
+	fake.RetrieveFileSharesStub = nil
+	fake.retrieveFileSharesReturns = struct {
+		result1 map[string]azurefilebroker.FileShare
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveFileSharesReturnsOnCall(i int, result1 map[string]azurefilebroker.FileShare, result2 error) {
+	fake.RetrieveFileSharesStub = nil
+	if fake.retrieveFileSharesReturnsOnCall == nil {
+		fake.retrieveFileSharesReturnsOnCall = make(map[int]struct {
+			result1 map[string]azurefilebroker.FileShare
+			result2 error
+		})
+	}
+	fake.retrieveFileSharesReturnsOnCall[i] = struct {
+		result1 map[string]azurefilebroker.FileShare
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveStorageAccountOwner(id string) (azurefilebroker.StorageAccountOwner, error) {
+	fake.retrieveStorageAccountOwnerMutex.Lock()
+	ret, specificReturn := fake.retrieveStorageAccountOwnerReturnsOnCall[len(fake.retrieveStorageAccountOwnerArgsForCall)]
+	fake.retrieveStorageAccountOwnerArgsForCall = append(fake.retrieveStorageAccountOwnerArgsForCall, struct {
+		id string
+	}{id})
+	fake.recordInvocation("RetrieveStorageAccountOwner", []interface{}{id})
+	fake.retrieveStorageAccountOwnerMutex.Unlock()
+	if fake.RetrieveStorageAccountOwnerStub != nil {
+		return fake.RetrieveStorageAccountOwnerStub(id)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrieveStorageAccountOwnerReturns.result1, fake.retrieveStorageAccountOwnerReturns.result2
+}
+
+func (fake *FakeStore) RetrieveStorageAccountOwnerCallCount() int {
+	fake.retrieveStorageAccountOwnerMutex.RLock()
+	defer fake.retrieveStorageAccountOwnerMutex.RUnlock()
+	return len(fake.retrieveStorageAccountOwnerArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveStorageAccountOwnerArgsForCall(i int) string {
+	fake.retrieveStorageAccountOwnerMutex.RLock()
+	defer fake.retrieveStorageAccountOwnerMutex.RUnlock()
+	return fake.retrieveStorageAccountOwnerArgsForCall[i].id
+}
+
+func (fake *FakeStore) RetrieveStorageAccountOwnerReturns(result1 azurefilebroker.StorageAccountOwner, result2 error) {
+	fake.RetrieveStorageAccountOwnerStub = nil
+	fake.retrieveStorageAccountOwnerReturns = struct {
+		result1 azurefilebroker.StorageAccountOwner
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveStorageAccountOwnerReturnsOnCall(i int, result1 azurefilebroker.StorageAccountOwner, result2 error) {
+	fake.RetrieveStorageAccountOwnerStub = nil
+	if fake.retrieveStorageAccountOwnerReturnsOnCall == nil {
+		fake.retrieveStorageAccountOwnerReturnsOnCall = make(map[int]struct {
+			result1 azurefilebroker.StorageAccountOwner
+			result2 error
+		})
+	}
+	fake.retrieveStorageAccountOwnerReturnsOnCall[i] = struct {
+		result1 azurefilebroker.StorageAccountOwner
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveFileShareOwner(id string) (azurefilebroker.FileShareOwner, error) {
+	fake.retrieveFileShareOwnerMutex.Lock()
+	ret, specificReturn := fake.retrieveFileShareOwnerReturnsOnCall[len(fake.retrieveFileShareOwnerArgsForCall)]
+	fake.retrieveFileShareOwnerArgsForCall = append(fake.retrieveFileShareOwnerArgsForCall, struct {
+		id string
+	}{id})
+	fake.recordInvocation("RetrieveFileShareOwner", []interface{}{id})
+	fake.retrieveFileShareOwnerMutex.Unlock()
+	if fake.RetrieveFileShareOwnerStub != nil {
+		return fake.RetrieveFileShareOwnerStub(id)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrieveFileShareOwnerReturns.result1, fake.retrieveFileShareOwnerReturns.result2
+}
+
+func (fake *FakeStore) RetrieveFileShareOwnerCallCount() int {
+	fake.retrieveFileShareOwnerMutex.RLock()
+	defer fake.retrieveFileShareOwnerMutex.RUnlock()
+	return len(fake.retrieveFileShareOwnerArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveFileShareOwnerArgsForCall(i int) string {
+	fake.retrieveFileShareOwnerMutex.RLock()
+	defer fake.retrieveFileShareOwnerMutex.RUnlock()
+	return fake.retrieveFileShareOwnerArgsForCall[i].id
+}
+
+func (fake *FakeStore) RetrieveFileShareOwnerReturns(result1 azurefilebroker.FileShareOwner, result2 error) {
+	fake.RetrieveFileShareOwnerStub = nil
+	fake.retrieveFileShareOwnerReturns = struct {
+		result1 azurefilebroker.FileShareOwner
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveFileShareOwnerReturnsOnCall(i int, result1 azurefilebroker.FileShareOwner, result2 error) {
+	fake.RetrieveFileShareOwnerStub = nil
+	if fake.retrieveFileShareOwnerReturnsOnCall == nil {
+		fake.retrieveFileShareOwnerReturnsOnCall = make(map[int]struct {
+			result1 azurefilebroker.FileShareOwner
+			result2 error
+		})
+	}
+	fake.retrieveFileShareOwnerReturnsOnCall[i] = struct {
+		result1 azurefilebroker.FileShareOwner
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveScheduledDeletion(id string) (azurefilebroker.ScheduledDeletion, error) {
+	fake.retrieveScheduledDeletionMutex.Lock()
+	ret, specificReturn := fake.retrieveScheduledDeletionReturnsOnCall[len(fake.retrieveScheduledDeletionArgsForCall)]
+	fake.retrieveScheduledDeletionArgsForCall = append(fake.retrieveScheduledDeletionArgsForCall, struct {
+		id string
+	}{id})
+	fake.recordInvocation("RetrieveScheduledDeletion", []interface{}{id})
+	fake.retrieveScheduledDeletionMutex.Unlock()
+	if fake.RetrieveScheduledDeletionStub != nil {
+		return fake.RetrieveScheduledDeletionStub(id)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrieveScheduledDeletionReturns.result1, fake.retrieveScheduledDeletionReturns.result2
+}
+
+func (fake *FakeStore) RetrieveScheduledDeletionCallCount() int {
+	fake.retrieveScheduledDeletionMutex.RLock()
+	defer fake.retrieveScheduledDeletionMutex.RUnlock()
+	return len(fake.retrieveScheduledDeletionArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveScheduledDeletionArgsForCall(i int) string {
+	fake.retrieveScheduledDeletionMutex.RLock()
+	defer fake.retrieveScheduledDeletionMutex.RUnlock()
+	return fake.retrieveScheduledDeletionArgsForCall[i].id
+}
+
+func (fake *FakeStore) RetrieveScheduledDeletionReturns(result1 azurefilebroker.ScheduledDeletion, result2 error) {
+	fake.RetrieveScheduledDeletionStub = nil
+	fake.retrieveScheduledDeletionReturns = struct {
+		result1 azurefilebroker.ScheduledDeletion
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveScheduledDeletionReturnsOnCall(i int, result1 azurefilebroker.ScheduledDeletion, result2 error) {
+	fake.RetrieveScheduledDeletionStub = nil
+	if fake.retrieveScheduledDeletionReturnsOnCall == nil {
+		fake.retrieveScheduledDeletionReturnsOnCall = make(map[int]struct {
+			result1 azurefilebroker.ScheduledDeletion
+			result2 error
+		})
+	}
+	fake.retrieveScheduledDeletionReturnsOnCall[i] = struct {
+		result1 azurefilebroker.ScheduledDeletion
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveScheduledDeletions() (map[string]azurefilebroker.ScheduledDeletion, error) {
+	fake.retrieveScheduledDeletionsMutex.Lock()
+	ret, specificReturn := fake.retrieveScheduledDeletionsReturnsOnCall[len(fake.retrieveScheduledDeletionsArgsForCall)]
+	fake.retrieveScheduledDeletionsArgsForCall = append(fake.retrieveScheduledDeletionsArgsForCall, struct{}{})
+	fake.recordInvocation("RetrieveScheduledDeletions", []interface{}{})
+	fake.retrieveScheduledDeletionsMutex.Unlock()
+	if fake.RetrieveScheduledDeletionsStub != nil {
+		return fake.RetrieveScheduledDeletionsStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrieveScheduledDeletionsReturns.result1, fake.retrieveScheduledDeletionsReturns.result2
+}
+
+func (fake *FakeStore) RetrieveScheduledDeletionsCallCount() int {
+	fake.retrieveScheduledDeletionsMutex.RLock()
+	defer fake.retrieveScheduledDeletionsMutex.RUnlock()
+	return len(fake.retrieveScheduledDeletionsArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveScheduledDeletionsReturns(result1 map[string]azurefilebroker.ScheduledDeletion, result2 error) {
+	fake.RetrieveScheduledDeletionsStub = nil
+	fake.retrieveScheduledDeletionsReturns = struct {
+		result1 map[string]azurefilebroker.ScheduledDeletion
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveScheduledDeletionsReturnsOnCall(i int, result1 map[string]azurefilebroker.ScheduledDeletion, result2 error) {
+	fake.RetrieveScheduledDeletionsStub = nil
+	if fake.retrieveScheduledDeletionsReturnsOnCall == nil {
+		fake.retrieveScheduledDeletionsReturnsOnCall = make(map[int]struct {
+			result1 map[string]azurefilebroker.ScheduledDeletion
+			result2 error
+		})
+	}
+	fake.retrieveScheduledDeletionsReturnsOnCall[i] = struct {
+		result1 map[string]azurefilebroker.ScheduledDeletion
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveFeatureFlags() (map[string]azurefilebroker.FeatureFlag, error) {
+	fake.retrieveFeatureFlagsMutex.Lock()
+	ret, specificReturn := fake.retrieveFeatureFlagsReturnsOnCall[len(fake.retrieveFeatureFlagsArgsForCall)]
+	fake.retrieveFeatureFlagsArgsForCall = append(fake.retrieveFeatureFlagsArgsForCall, struct{}{})
+	fake.recordInvocation("RetrieveFeatureFlags", []interface{}{})
+	fake.retrieveFeatureFlagsMutex.Unlock()
+	if fake.RetrieveFeatureFlagsStub != nil {
+		return fake.RetrieveFeatureFlagsStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrieveFeatureFlagsReturns.result1, fake.retrieveFeatureFlagsReturns.result2
+}
+
+func (fake *FakeStore) RetrieveFeatureFlagsCallCount() int {
+	fake.retrieveFeatureFlagsMutex.RLock()
+	defer fake.retrieveFeatureFlagsMutex.RUnlock()
+	return len(fake.retrieveFeatureFlagsArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveFeatureFlagsReturns(result1 map[string]azurefilebroker.FeatureFlag, result2 error) {
+	fake.RetrieveFeatureFlagsStub = nil
+	fake.retrieveFeatureFlagsReturns = struct {
+		result1 map[string]azurefilebroker.FeatureFlag
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveFeatureFlagsReturnsOnCall(i int, result1 map[string]azurefilebroker.FeatureFlag, result2 error) {
+	fake.RetrieveFeatureFlagsStub = nil
+	if fake.retrieveFeatureFlagsReturnsOnCall == nil {
+		fake.retrieveFeatureFlagsReturnsOnCall = make(map[int]struct {
+			result1 map[string]azurefilebroker.FeatureFlag
+			result2 error
+		})
+	}
+	fake.retrieveFeatureFlagsReturnsOnCall[i] = struct {
+		result1 map[string]azurefilebroker.FeatureFlag
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrievePendingShareDeletions() (map[string]azurefilebroker.PendingShareDeletion, error) {
+	fake.retrievePendingShareDeletionsMutex.Lock()
+	ret, specificReturn := fake.retrievePendingShareDeletionsReturnsOnCall[len(fake.retrievePendingShareDeletionsArgsForCall)]
+	fake.retrievePendingShareDeletionsArgsForCall = append(fake.retrievePendingShareDeletionsArgsForCall, struct{}{})
+	fake.recordInvocation("RetrievePendingShareDeletions", []interface{}{})
+	fake.retrievePendingShareDeletionsMutex.Unlock()
+	if fake.RetrievePendingShareDeletionsStub != nil {
+		return fake.RetrievePendingShareDeletionsStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrievePendingShareDeletionsReturns.result1, fake.retrievePendingShareDeletionsReturns.result2
+}
+
+func (fake *FakeStore) RetrievePendingShareDeletionsCallCount() int {
+	fake.retrievePendingShareDeletionsMutex.RLock()
+	defer fake.retrievePendingShareDeletionsMutex.RUnlock()
+	return len(fake.retrievePendingShareDeletionsArgsForCall)
+}
+
+func (fake *FakeStore) RetrievePendingShareDeletionsReturns(result1 map[string]azurefilebroker.PendingShareDeletion, result2 error) {
+	fake.RetrievePendingShareDeletionsStub = nil
+	fake.retrievePendingShareDeletionsReturns = struct {
+		result1 map[string]azurefilebroker.PendingShareDeletion
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrievePendingShareDeletionsReturnsOnCall(i int, result1 map[string]azurefilebroker.PendingShareDeletion, result2 error) {
+	fake.RetrievePendingShareDeletionsStub = nil
+	if fake.retrievePendingShareDeletionsReturnsOnCall == nil {
+		fake.retrievePendingShareDeletionsReturnsOnCall = make(map[int]struct {
+			result1 map[string]azurefilebroker.PendingShareDeletion
+			result2 error
+		})
+	}
+	fake.retrievePendingShareDeletionsReturnsOnCall[i] = struct {
+		result1 map[string]azurefilebroker.PendingShareDeletion
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrievePooledStorageAccounts() (map[string]azurefilebroker.PooledStorageAccount, error) {
+	fake.retrievePooledStorageAccountsMutex.Lock()
+	ret, specificReturn := fake.retrievePooledStorageAccountsReturnsOnCall[len(fake.retrievePooledStorageAccountsArgsForCall)]
+	fake.retrievePooledStorageAccountsArgsForCall = append(fake.retrievePooledStorageAccountsArgsForCall, struct{}{})
+	fake.recordInvocation("RetrievePooledStorageAccounts", []interface{}{})
+	fake.retrievePooledStorageAccountsMutex.Unlock()
+	if fake.RetrievePooledStorageAccountsStub != nil {
+		return fake.RetrievePooledStorageAccountsStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrievePooledStorageAccountsReturns.result1, fake.retrievePooledStorageAccountsReturns.result2
+}
+
+func (fake *FakeStore) RetrievePooledStorageAccountsCallCount() int {
+	fake.retrievePooledStorageAccountsMutex.RLock()
+	defer fake.retrievePooledStorageAccountsMutex.RUnlock()
+	return len(fake.retrievePooledStorageAccountsArgsForCall)
+}
+
+func (fake *FakeStore) RetrievePooledStorageAccountsReturns(result1 map[string]azurefilebroker.PooledStorageAccount, result2 error) {
+	fake.RetrievePooledStorageAccountsStub = nil
+	fake.retrievePooledStorageAccountsReturns = struct {
+		result1 map[string]azurefilebroker.PooledStorageAccount
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrievePooledStorageAccountsReturnsOnCall(i int, result1 map[string]azurefilebroker.PooledStorageAccount, result2 error) {
+	fake.RetrievePooledStorageAccountsStub = nil
+	if fake.retrievePooledStorageAccountsReturnsOnCall == nil {
+		fake.retrievePooledStorageAccountsReturnsOnCall = make(map[int]struct {
+			result1 map[string]azurefilebroker.PooledStorageAccount
+			result2 error
+		})
+	}
+	fake.retrievePooledStorageAccountsReturnsOnCall[i] = struct {
+		result1 map[string]azurefilebroker.PooledStorageAccount
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveInstanceOperations(instanceID string) ([]azurefilebroker.InstanceOperation, error) {
+	fake.retrieveInstanceOperationsMutex.Lock()
+	ret, specificReturn := fake.retrieveInstanceOperationsReturnsOnCall[len(fake.retrieveInstanceOperationsArgsForCall)]
+	fake.retrieveInstanceOperationsArgsForCall = append(fake.retrieveInstanceOperationsArgsForCall, struct {
+		instanceID string
+	}{instanceID})
+	fake.recordInvocation("RetrieveInstanceOperations", []interface{}{instanceID})
+	fake.retrieveInstanceOperationsMutex.Unlock()
+	if fake.RetrieveInstanceOperationsStub != nil {
+		return fake.RetrieveInstanceOperationsStub(instanceID)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrieveInstanceOperationsReturns.result1, fake.retrieveInstanceOperationsReturns.result2
+}
+
+func (fake *FakeStore) RetrieveInstanceOperationsCallCount() int {
+	fake.retrieveInstanceOperationsMutex.RLock()
+	defer fake.retrieveInstanceOperationsMutex.RUnlock()
+	return len(fake.retrieveInstanceOperationsArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveInstanceOperationsArgsForCall(i int) string {
+	fake.retrieveInstanceOperationsMutex.RLock()
+	defer fake.retrieveInstanceOperationsMutex.RUnlock()
+	return fake.retrieveInstanceOperationsArgsForCall[i].instanceID
+}
+
+func (fake *FakeStore) RetrieveInstanceOperationsReturns(result1 []azurefilebroker.InstanceOperation, result2 error) {
+	fake.RetrieveInstanceOperationsStub = nil
+	fake.retrieveInstanceOperationsReturns = struct {
+		result1 []azurefilebroker.InstanceOperation
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveInstanceOperationsReturnsOnCall(i int, result1 []azurefilebroker.InstanceOperation, result2 error) {
+	fake.RetrieveInstanceOperationsStub = nil
+	if fake.retrieveInstanceOperationsReturnsOnCall == nil {
+		fake.retrieveInstanceOperationsReturnsOnCall = make(map[int]struct {
+			result1 []azurefilebroker.InstanceOperation
+			result2 error
+		})
+	}
+	fake.retrieveInstanceOperationsReturnsOnCall[i] = struct {
+		result1 []azurefilebroker.InstanceOperation
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveLockHolder(id string) (azurefilebroker.LockHolder, error) {
+	fake.retrieveLockHolderMutex.Lock()
+	ret, specificReturn := fake.retrieveLockHolderReturnsOnCall[len(fake.retrieveLockHolderArgsForCall)]
+	fake.retrieveLockHolderArgsForCall = append(fake.retrieveLockHolderArgsForCall, struct {
+		id string
+	}{id})
+	fake.recordInvocation("RetrieveLockHolder", []interface{}{id})
+	fake.retrieveLockHolderMutex.Unlock()
+	if fake.RetrieveLockHolderStub != nil {
+		return fake.RetrieveLockHolderStub(id)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrieveLockHolderReturns.result1, fake.retrieveLockHolderReturns.result2
+}
+
+func (fake *FakeStore) RetrieveLockHolderCallCount() int {
+	fake.retrieveLockHolderMutex.RLock()
+	defer fake.retrieveLockHolderMutex.RUnlock()
+	return len(fake.retrieveLockHolderArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveLockHolderArgsForCall(i int) string {
+	fake.retrieveLockHolderMutex.RLock()
+	defer fake.retrieveLockHolderMutex.RUnlock()
+	return fake.retrieveLockHolderArgsForCall[i].id
+}
+
+func (fake *FakeStore) RetrieveLockHolderReturns(result1 azurefilebroker.LockHolder, result2 error) {
+	fake.RetrieveLockHolderStub = nil
+	fake.retrieveLockHolderReturns = struct {
+		result1 azurefilebroker.LockHolder
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveLockHolderReturnsOnCall(i int, result1 azurefilebroker.LockHolder, result2 error) {
+	fake.RetrieveLockHolderStub = nil
+	if fake.retrieveLockHolderReturnsOnCall == nil {
+		fake.retrieveLockHolderReturnsOnCall = make(map[int]struct {
+			result1 azurefilebroker.LockHolder
+			result2 error
+		})
+	}
+	fake.retrieveLockHolderReturnsOnCall[i] = struct {
+		result1 azurefilebroker.LockHolder
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveLockHolders() (map[string]azurefilebroker.LockHolder, error) {
+	fake.retrieveLockHoldersMutex.Lock()
+	ret, specificReturn := fake.retrieveLockHoldersReturnsOnCall[len(fake.retrieveLockHoldersArgsForCall)]
+	fake.retrieveLockHoldersArgsForCall = append(fake.retrieveLockHoldersArgsForCall, struct{}{})
+	fake.recordInvocation("RetrieveLockHolders", []interface{}{})
+	fake.retrieveLockHoldersMutex.Unlock()
+	if fake.RetrieveLockHoldersStub != nil {
+		return fake.RetrieveLockHoldersStub()
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.retrieveLockHoldersReturns.result1, fake.retrieveLockHoldersReturns.result2
+}
+
+func (fake *FakeStore) RetrieveLockHoldersCallCount() int {
+	fake.retrieveLockHoldersMutex.RLock()
+	defer fake.retrieveLockHoldersMutex.RUnlock()
+	return len(fake.retrieveLockHoldersArgsForCall)
+}
+
+func (fake *FakeStore) RetrieveLockHoldersReturns(result1 map[string]azurefilebroker.LockHolder, result2 error) {
+	fake.RetrieveLockHoldersStub = nil
+	fake.retrieveLockHoldersReturns = struct {
+		result1 map[string]azurefilebroker.LockHolder
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) RetrieveLockHoldersReturnsOnCall(i int, result1 map[string]azurefilebroker.LockHolder, result2 error) {
+	fake.RetrieveLockHoldersStub = nil
+	if fake.retrieveLockHoldersReturnsOnCall == nil {
+		fake.retrieveLockHoldersReturnsOnCall = make(map[int]struct {
+			result1 map[string]azurefilebroker.LockHolder
+			result2 error
+		})
+	}
+	fake.retrieveLockHoldersReturnsOnCall[i] = struct {
+		result1 map[string]azurefilebroker.LockHolder
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeStore) CreateServiceInstance(id string, instance azurefilebroker.ServiceInstance) error {
+	fake.createServiceInstanceMutex.Lock()
+	ret, specificReturn := fake.createServiceInstanceReturnsOnCall[len(fake.createServiceInstanceArgsForCall)]
+	fake.createServiceInstanceArgsForCall = append(fake.createServiceInstanceArgsForCall, struct {
+		id       string
+		instance azurefilebroker.ServiceInstance
+	}{id, instance})
+	fake.recordInvocation("CreateServiceInstance", []interface{}{id, instance})
+	fake.createServiceInstanceMutex.Unlock()
+	if fake.CreateServiceInstanceStub != nil {
+		return fake.CreateServiceInstanceStub(id, instance)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createServiceInstanceReturns.result1
+}
+
+func (fake *FakeStore) CreateServiceInstanceCallCount() int {
+	fake.createServiceInstanceMutex.RLock()
+	defer fake.createServiceInstanceMutex.RUnlock()
 	return len(fake.createServiceInstanceArgsForCall)
 }
 
-func (fake *FakeStore) CreateServiceInstanceArgsForCall(i int) (string, azurefilebroker.ServiceInstance) {
-	fake.createServiceInstanceMutex.RLock()
-	defer fake.createServiceInstanceMutex.RUnlock()
-	return fake.createServiceInstanceArgsForCall[i].id, fake.createServiceInstanceArgsForCall[i].instance
+func (fake *FakeStore) CreateServiceInstanceArgsForCall(i int) (string, azurefilebroker.ServiceInstance) {
+	fake.createServiceInstanceMutex.RLock()
+	defer fake.createServiceInstanceMutex.RUnlock()
+	return fake.createServiceInstanceArgsForCall[i].id, fake.createServiceInstanceArgsForCall[i].instance
+}
+
+func (fake *FakeStore) CreateServiceInstanceReturns(result1 error) {
+	fake.CreateServiceInstanceStub = nil
+	fake.createServiceInstanceReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateServiceInstanceReturnsOnCall(i int, result1 error) {
+	fake.CreateServiceInstanceStub = nil
+	if fake.createServiceInstanceReturnsOnCall == nil {
+		fake.createServiceInstanceReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createServiceInstanceReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateBindingDetails(id string, details azurefilebroker.BindingDetails) error {
+	fake.createBindingDetailsMutex.Lock()
+	ret, specificReturn := fake.createBindingDetailsReturnsOnCall[len(fake.createBindingDetailsArgsForCall)]
+	fake.createBindingDetailsArgsForCall = append(fake.createBindingDetailsArgsForCall, struct {
+		id      string
+		details azurefilebroker.BindingDetails
+	}{id, details})
+	fake.recordInvocation("CreateBindingDetails", []interface{}{id, details})
+	fake.createBindingDetailsMutex.Unlock()
+	if fake.CreateBindingDetailsStub != nil {
+		return fake.CreateBindingDetailsStub(id, details)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createBindingDetailsReturns.result1
+}
+
+func (fake *FakeStore) CreateBindingDetailsCallCount() int {
+	fake.createBindingDetailsMutex.RLock()
+	defer fake.createBindingDetailsMutex.RUnlock()
+	return len(fake.createBindingDetailsArgsForCall)
+}
+
+func (fake *FakeStore) CreateBindingDetailsArgsForCall(i int) (string, azurefilebroker.BindingDetails) {
+	fake.createBindingDetailsMutex.RLock()
+	defer fake.createBindingDetailsMutex.RUnlock()
+	return fake.createBindingDetailsArgsForCall[i].id, fake.createBindingDetailsArgsForCall[i].details
+}
+
+func (fake *FakeStore) CreateBindingDetailsReturns(result1 error) {
+	fake.CreateBindingDetailsStub = nil
+	fake.createBindingDetailsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateBindingDetailsReturnsOnCall(i int, result1 error) {
+	fake.CreateBindingDetailsStub = nil
+	if fake.createBindingDetailsReturnsOnCall == nil {
+		fake.createBindingDetailsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createBindingDetailsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateFileShare(id string, share azurefilebroker.FileShare) error {
+	fake.createFileShareMutex.Lock()
+	ret, specificReturn := fake.createFileShareReturnsOnCall[len(fake.createFileShareArgsForCall)]
+	fake.createFileShareArgsForCall = append(fake.createFileShareArgsForCall, struct {
+		id    string
+		share azurefilebroker.FileShare
+	}{id, share})
+	fake.recordInvocation("CreateFileShare", []interface{}{id, share})
+	fake.createFileShareMutex.Unlock()
+	if fake.CreateFileShareStub != nil {
+		return fake.CreateFileShareStub(id, share)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createFileShareReturns.result1
+}
+
+func (fake *FakeStore) CreateFileShareCallCount() int {
+	fake.createFileShareMutex.RLock()
+	defer fake.createFileShareMutex.RUnlock()
+	return len(fake.createFileShareArgsForCall)
+}
+
+func (fake *FakeStore) CreateFileShareArgsForCall(i int) (string, azurefilebroker.FileShare) {
+	fake.createFileShareMutex.RLock()
+	defer fake.createFileShareMutex.RUnlock()
+	return fake.createFileShareArgsForCall[i].id, fake.createFileShareArgsForCall[i].share
+}
+
+func (fake *FakeStore) CreateFileShareReturns(result1 error) {
+	fake.CreateFileShareStub = nil
+	fake.createFileShareReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateFileShareReturnsOnCall(i int, result1 error) {
+	fake.CreateFileShareStub = nil
+	if fake.createFileShareReturnsOnCall == nil {
+		fake.createFileShareReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createFileShareReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateStorageAccountOwner(id string, owner azurefilebroker.StorageAccountOwner) error {
+	fake.createStorageAccountOwnerMutex.Lock()
+	ret, specificReturn := fake.createStorageAccountOwnerReturnsOnCall[len(fake.createStorageAccountOwnerArgsForCall)]
+	fake.createStorageAccountOwnerArgsForCall = append(fake.createStorageAccountOwnerArgsForCall, struct {
+		id    string
+		owner azurefilebroker.StorageAccountOwner
+	}{id, owner})
+	fake.recordInvocation("CreateStorageAccountOwner", []interface{}{id, owner})
+	fake.createStorageAccountOwnerMutex.Unlock()
+	if fake.CreateStorageAccountOwnerStub != nil {
+		return fake.CreateStorageAccountOwnerStub(id, owner)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createStorageAccountOwnerReturns.result1
+}
+
+func (fake *FakeStore) CreateStorageAccountOwnerCallCount() int {
+	fake.createStorageAccountOwnerMutex.RLock()
+	defer fake.createStorageAccountOwnerMutex.RUnlock()
+	return len(fake.createStorageAccountOwnerArgsForCall)
+}
+
+func (fake *FakeStore) CreateStorageAccountOwnerArgsForCall(i int) (string, azurefilebroker.StorageAccountOwner) {
+	fake.createStorageAccountOwnerMutex.RLock()
+	defer fake.createStorageAccountOwnerMutex.RUnlock()
+	return fake.createStorageAccountOwnerArgsForCall[i].id, fake.createStorageAccountOwnerArgsForCall[i].owner
+}
+
+func (fake *FakeStore) CreateStorageAccountOwnerReturns(result1 error) {
+	fake.CreateStorageAccountOwnerStub = nil
+	fake.createStorageAccountOwnerReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateStorageAccountOwnerReturnsOnCall(i int, result1 error) {
+	fake.CreateStorageAccountOwnerStub = nil
+	if fake.createStorageAccountOwnerReturnsOnCall == nil {
+		fake.createStorageAccountOwnerReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createStorageAccountOwnerReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateFileShareOwner(id string, owner azurefilebroker.FileShareOwner) error {
+	fake.createFileShareOwnerMutex.Lock()
+	ret, specificReturn := fake.createFileShareOwnerReturnsOnCall[len(fake.createFileShareOwnerArgsForCall)]
+	fake.createFileShareOwnerArgsForCall = append(fake.createFileShareOwnerArgsForCall, struct {
+		id    string
+		owner azurefilebroker.FileShareOwner
+	}{id, owner})
+	fake.recordInvocation("CreateFileShareOwner", []interface{}{id, owner})
+	fake.createFileShareOwnerMutex.Unlock()
+	if fake.CreateFileShareOwnerStub != nil {
+		return fake.CreateFileShareOwnerStub(id, owner)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createFileShareOwnerReturns.result1
+}
+
+func (fake *FakeStore) CreateFileShareOwnerCallCount() int {
+	fake.createFileShareOwnerMutex.RLock()
+	defer fake.createFileShareOwnerMutex.RUnlock()
+	return len(fake.createFileShareOwnerArgsForCall)
+}
+
+func (fake *FakeStore) CreateFileShareOwnerArgsForCall(i int) (string, azurefilebroker.FileShareOwner) {
+	fake.createFileShareOwnerMutex.RLock()
+	defer fake.createFileShareOwnerMutex.RUnlock()
+	return fake.createFileShareOwnerArgsForCall[i].id, fake.createFileShareOwnerArgsForCall[i].owner
+}
+
+func (fake *FakeStore) CreateFileShareOwnerReturns(result1 error) {
+	fake.CreateFileShareOwnerStub = nil
+	fake.createFileShareOwnerReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateFileShareOwnerReturnsOnCall(i int, result1 error) {
+	fake.CreateFileShareOwnerStub = nil
+	if fake.createFileShareOwnerReturnsOnCall == nil {
+		fake.createFileShareOwnerReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createFileShareOwnerReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateScheduledDeletion(id string, deletion azurefilebroker.ScheduledDeletion) error {
+	fake.createScheduledDeletionMutex.Lock()
+	ret, specificReturn := fake.createScheduledDeletionReturnsOnCall[len(fake.createScheduledDeletionArgsForCall)]
+	fake.createScheduledDeletionArgsForCall = append(fake.createScheduledDeletionArgsForCall, struct {
+		id       string
+		deletion azurefilebroker.ScheduledDeletion
+	}{id, deletion})
+	fake.recordInvocation("CreateScheduledDeletion", []interface{}{id, deletion})
+	fake.createScheduledDeletionMutex.Unlock()
+	if fake.CreateScheduledDeletionStub != nil {
+		return fake.CreateScheduledDeletionStub(id, deletion)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createScheduledDeletionReturns.result1
+}
+
+func (fake *FakeStore) CreateScheduledDeletionCallCount() int {
+	fake.createScheduledDeletionMutex.RLock()
+	defer fake.createScheduledDeletionMutex.RUnlock()
+	return len(fake.createScheduledDeletionArgsForCall)
+}
+
+func (fake *FakeStore) CreateScheduledDeletionArgsForCall(i int) (string, azurefilebroker.ScheduledDeletion) {
+	fake.createScheduledDeletionMutex.RLock()
+	defer fake.createScheduledDeletionMutex.RUnlock()
+	return fake.createScheduledDeletionArgsForCall[i].id, fake.createScheduledDeletionArgsForCall[i].deletion
+}
+
+func (fake *FakeStore) CreateScheduledDeletionReturns(result1 error) {
+	fake.CreateScheduledDeletionStub = nil
+	fake.createScheduledDeletionReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateScheduledDeletionReturnsOnCall(i int, result1 error) {
+	fake.CreateScheduledDeletionStub = nil
+	if fake.createScheduledDeletionReturnsOnCall == nil {
+		fake.createScheduledDeletionReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createScheduledDeletionReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateFeatureFlag(id string, flag azurefilebroker.FeatureFlag) error {
+	fake.createFeatureFlagMutex.Lock()
+	ret, specificReturn := fake.createFeatureFlagReturnsOnCall[len(fake.createFeatureFlagArgsForCall)]
+	fake.createFeatureFlagArgsForCall = append(fake.createFeatureFlagArgsForCall, struct {
+		id   string
+		flag azurefilebroker.FeatureFlag
+	}{id, flag})
+	fake.recordInvocation("CreateFeatureFlag", []interface{}{id, flag})
+	fake.createFeatureFlagMutex.Unlock()
+	if fake.CreateFeatureFlagStub != nil {
+		return fake.CreateFeatureFlagStub(id, flag)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createFeatureFlagReturns.result1
+}
+
+func (fake *FakeStore) CreateFeatureFlagCallCount() int {
+	fake.createFeatureFlagMutex.RLock()
+	defer fake.createFeatureFlagMutex.RUnlock()
+	return len(fake.createFeatureFlagArgsForCall)
+}
+
+func (fake *FakeStore) CreateFeatureFlagArgsForCall(i int) (string, azurefilebroker.FeatureFlag) {
+	fake.createFeatureFlagMutex.RLock()
+	defer fake.createFeatureFlagMutex.RUnlock()
+	return fake.createFeatureFlagArgsForCall[i].id, fake.createFeatureFlagArgsForCall[i].flag
+}
+
+func (fake *FakeStore) CreateFeatureFlagReturns(result1 error) {
+	fake.CreateFeatureFlagStub = nil
+	fake.createFeatureFlagReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateFeatureFlagReturnsOnCall(i int, result1 error) {
+	fake.CreateFeatureFlagStub = nil
+	if fake.createFeatureFlagReturnsOnCall == nil {
+		fake.createFeatureFlagReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createFeatureFlagReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreatePendingShareDeletion(id string, deletion azurefilebroker.PendingShareDeletion) error {
+	fake.createPendingShareDeletionMutex.Lock()
+	ret, specificReturn := fake.createPendingShareDeletionReturnsOnCall[len(fake.createPendingShareDeletionArgsForCall)]
+	fake.createPendingShareDeletionArgsForCall = append(fake.createPendingShareDeletionArgsForCall, struct {
+		id       string
+		deletion azurefilebroker.PendingShareDeletion
+	}{id, deletion})
+	fake.recordInvocation("CreatePendingShareDeletion", []interface{}{id, deletion})
+	fake.createPendingShareDeletionMutex.Unlock()
+	if fake.CreatePendingShareDeletionStub != nil {
+		return fake.CreatePendingShareDeletionStub(id, deletion)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createPendingShareDeletionReturns.result1
+}
+
+func (fake *FakeStore) CreatePendingShareDeletionCallCount() int {
+	fake.createPendingShareDeletionMutex.RLock()
+	defer fake.createPendingShareDeletionMutex.RUnlock()
+	return len(fake.createPendingShareDeletionArgsForCall)
+}
+
+func (fake *FakeStore) CreatePendingShareDeletionArgsForCall(i int) (string, azurefilebroker.PendingShareDeletion) {
+	fake.createPendingShareDeletionMutex.RLock()
+	defer fake.createPendingShareDeletionMutex.RUnlock()
+	return fake.createPendingShareDeletionArgsForCall[i].id, fake.createPendingShareDeletionArgsForCall[i].deletion
+}
+
+func (fake *FakeStore) CreatePendingShareDeletionReturns(result1 error) {
+	fake.CreatePendingShareDeletionStub = nil
+	fake.createPendingShareDeletionReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreatePendingShareDeletionReturnsOnCall(i int, result1 error) {
+	fake.CreatePendingShareDeletionStub = nil
+	if fake.createPendingShareDeletionReturnsOnCall == nil {
+		fake.createPendingShareDeletionReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createPendingShareDeletionReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreatePooledStorageAccount(id string, account azurefilebroker.PooledStorageAccount) error {
+	fake.createPooledStorageAccountMutex.Lock()
+	ret, specificReturn := fake.createPooledStorageAccountReturnsOnCall[len(fake.createPooledStorageAccountArgsForCall)]
+	fake.createPooledStorageAccountArgsForCall = append(fake.createPooledStorageAccountArgsForCall, struct {
+		id      string
+		account azurefilebroker.PooledStorageAccount
+	}{id, account})
+	fake.recordInvocation("CreatePooledStorageAccount", []interface{}{id, account})
+	fake.createPooledStorageAccountMutex.Unlock()
+	if fake.CreatePooledStorageAccountStub != nil {
+		return fake.CreatePooledStorageAccountStub(id, account)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createPooledStorageAccountReturns.result1
+}
+
+func (fake *FakeStore) CreatePooledStorageAccountCallCount() int {
+	fake.createPooledStorageAccountMutex.RLock()
+	defer fake.createPooledStorageAccountMutex.RUnlock()
+	return len(fake.createPooledStorageAccountArgsForCall)
+}
+
+func (fake *FakeStore) CreatePooledStorageAccountArgsForCall(i int) (string, azurefilebroker.PooledStorageAccount) {
+	fake.createPooledStorageAccountMutex.RLock()
+	defer fake.createPooledStorageAccountMutex.RUnlock()
+	return fake.createPooledStorageAccountArgsForCall[i].id, fake.createPooledStorageAccountArgsForCall[i].account
+}
+
+func (fake *FakeStore) CreatePooledStorageAccountReturns(result1 error) {
+	fake.CreatePooledStorageAccountStub = nil
+	fake.createPooledStorageAccountReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreatePooledStorageAccountReturnsOnCall(i int, result1 error) {
+	fake.CreatePooledStorageAccountStub = nil
+	if fake.createPooledStorageAccountReturnsOnCall == nil {
+		fake.createPooledStorageAccountReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createPooledStorageAccountReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateInstanceOperation(id string, operation azurefilebroker.InstanceOperation) error {
+	fake.createInstanceOperationMutex.Lock()
+	ret, specificReturn := fake.createInstanceOperationReturnsOnCall[len(fake.createInstanceOperationArgsForCall)]
+	fake.createInstanceOperationArgsForCall = append(fake.createInstanceOperationArgsForCall, struct {
+		id        string
+		operation azurefilebroker.InstanceOperation
+	}{id, operation})
+	fake.recordInvocation("CreateInstanceOperation", []interface{}{id, operation})
+	fake.createInstanceOperationMutex.Unlock()
+	if fake.CreateInstanceOperationStub != nil {
+		return fake.CreateInstanceOperationStub(id, operation)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createInstanceOperationReturns.result1
+}
+
+func (fake *FakeStore) CreateInstanceOperationCallCount() int {
+	fake.createInstanceOperationMutex.RLock()
+	defer fake.createInstanceOperationMutex.RUnlock()
+	return len(fake.createInstanceOperationArgsForCall)
+}
+
+func (fake *FakeStore) CreateInstanceOperationArgsForCall(i int) (string, azurefilebroker.InstanceOperation) {
+	fake.createInstanceOperationMutex.RLock()
+	defer fake.createInstanceOperationMutex.RUnlock()
+	return fake.createInstanceOperationArgsForCall[i].id, fake.createInstanceOperationArgsForCall[i].operation
+}
+
+func (fake *FakeStore) CreateInstanceOperationReturns(result1 error) {
+	fake.CreateInstanceOperationStub = nil
+	fake.createInstanceOperationReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateInstanceOperationReturnsOnCall(i int, result1 error) {
+	fake.CreateInstanceOperationStub = nil
+	if fake.createInstanceOperationReturnsOnCall == nil {
+		fake.createInstanceOperationReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createInstanceOperationReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateLockHolder(id string, holder azurefilebroker.LockHolder) error {
+	fake.createLockHolderMutex.Lock()
+	ret, specificReturn := fake.createLockHolderReturnsOnCall[len(fake.createLockHolderArgsForCall)]
+	fake.createLockHolderArgsForCall = append(fake.createLockHolderArgsForCall, struct {
+		id     string
+		holder azurefilebroker.LockHolder
+	}{id, holder})
+	fake.recordInvocation("CreateLockHolder", []interface{}{id, holder})
+	fake.createLockHolderMutex.Unlock()
+	if fake.CreateLockHolderStub != nil {
+		return fake.CreateLockHolderStub(id, holder)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.createLockHolderReturns.result1
+}
+
+func (fake *FakeStore) CreateLockHolderCallCount() int {
+	fake.createLockHolderMutex.RLock()
+	defer fake.createLockHolderMutex.RUnlock()
+	return len(fake.createLockHolderArgsForCall)
+}
+
+func (fake *FakeStore) CreateLockHolderArgsForCall(i int) (string, azurefilebroker.LockHolder) {
+	fake.createLockHolderMutex.RLock()
+	defer fake.createLockHolderMutex.RUnlock()
+	return fake.createLockHolderArgsForCall[i].id, fake.createLockHolderArgsForCall[i].holder
+}
+
+func (fake *FakeStore) CreateLockHolderReturns(result1 error) {
+	fake.CreateLockHolderStub = nil
+	fake.createLockHolderReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) CreateLockHolderReturnsOnCall(i int, result1 error) {
+	fake.CreateLockHolderStub = nil
+	if fake.createLockHolderReturnsOnCall == nil {
+		fake.createLockHolderReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.createLockHolderReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) UpdateServiceInstance(id string, instance azurefilebroker.ServiceInstance) error {
+	fake.updateServiceInstanceMutex.Lock()
+	ret, specificReturn := fake.updateServiceInstanceReturnsOnCall[len(fake.updateServiceInstanceArgsForCall)]
+	fake.updateServiceInstanceArgsForCall = append(fake.updateServiceInstanceArgsForCall, struct {
+		id       string
+		instance azurefilebroker.ServiceInstance
+	}{id, instance})
+	fake.recordInvocation("UpdateServiceInstance", []interface{}{id, instance})
+	fake.updateServiceInstanceMutex.Unlock()
+	if fake.UpdateServiceInstanceStub != nil {
+		return fake.UpdateServiceInstanceStub(id, instance)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.updateServiceInstanceReturns.result1
+}
+
+func (fake *FakeStore) UpdateServiceInstanceCallCount() int {
+	fake.updateServiceInstanceMutex.RLock()
+	defer fake.updateServiceInstanceMutex.RUnlock()
+	return len(fake.updateServiceInstanceArgsForCall)
+}
+
+func (fake *FakeStore) UpdateServiceInstanceArgsForCall(i int) (string, azurefilebroker.ServiceInstance) {
+	fake.updateServiceInstanceMutex.RLock()
+	defer fake.updateServiceInstanceMutex.RUnlock()
+	return fake.updateServiceInstanceArgsForCall[i].id, fake.updateServiceInstanceArgsForCall[i].instance
+}
+
+func (fake *FakeStore) UpdateServiceInstanceReturns(result1 error) {
+	fake.UpdateServiceInstanceStub = nil
+	fake.updateServiceInstanceReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) UpdateServiceInstanceReturnsOnCall(i int, result1 error) {
+	fake.UpdateServiceInstanceStub = nil
+	if fake.updateServiceInstanceReturnsOnCall == nil {
+		fake.updateServiceInstanceReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.updateServiceInstanceReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) UpdateFileShare(id string, share azurefilebroker.FileShare) error {
+	fake.updateFileShareMutex.Lock()
+	ret, specificReturn := fake.updateFileShareReturnsOnCall[len(fake.updateFileShareArgsForCall)]
+	fake.updateFileShareArgsForCall = append(fake.updateFileShareArgsForCall, struct {
+		id    string
+		share azurefilebroker.FileShare
+	}{id, share})
+	fake.recordInvocation("UpdateFileShare", []interface{}{id, share})
+	fake.updateFileShareMutex.Unlock()
+	if fake.UpdateFileShareStub != nil {
+		return fake.UpdateFileShareStub(id, share)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.updateFileShareReturns.result1
+}
+
+func (fake *FakeStore) UpdateFileShareCallCount() int {
+	fake.updateFileShareMutex.RLock()
+	defer fake.updateFileShareMutex.RUnlock()
+	return len(fake.updateFileShareArgsForCall)
+}
+
+func (fake *FakeStore) UpdateFileShareArgsForCall(i int) (string, azurefilebroker.FileShare) {
+	fake.updateFileShareMutex.RLock()
+	defer fake.updateFileShareMutex.RUnlock()
+	return fake.updateFileShareArgsForCall[i].id, fake.updateFileShareArgsForCall[i].share
+}
+
+func (fake *FakeStore) UpdateFileShareReturns(result1 error) {
+	fake.UpdateFileShareStub = nil
+	fake.updateFileShareReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) UpdateFileShareReturnsOnCall(i int, result1 error) {
+	fake.UpdateFileShareStub = nil
+	if fake.updateFileShareReturnsOnCall == nil {
+		fake.updateFileShareReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.updateFileShareReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) UpdateFileShareOwner(id string, owner azurefilebroker.FileShareOwner) error {
+	fake.updateFileShareOwnerMutex.Lock()
+	ret, specificReturn := fake.updateFileShareOwnerReturnsOnCall[len(fake.updateFileShareOwnerArgsForCall)]
+	fake.updateFileShareOwnerArgsForCall = append(fake.updateFileShareOwnerArgsForCall, struct {
+		id    string
+		owner azurefilebroker.FileShareOwner
+	}{id, owner})
+	fake.recordInvocation("UpdateFileShareOwner", []interface{}{id, owner})
+	fake.updateFileShareOwnerMutex.Unlock()
+	if fake.UpdateFileShareOwnerStub != nil {
+		return fake.UpdateFileShareOwnerStub(id, owner)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.updateFileShareOwnerReturns.result1
+}
+
+func (fake *FakeStore) UpdateFileShareOwnerCallCount() int {
+	fake.updateFileShareOwnerMutex.RLock()
+	defer fake.updateFileShareOwnerMutex.RUnlock()
+	return len(fake.updateFileShareOwnerArgsForCall)
 }
 
-func (fake *FakeStore) CreateServiceInstanceReturns(result1 error) {
-	fake.CreateServiceInstanceStub = nil
-	fake.createServiceInstanceReturns = struct {
+func (fake *FakeStore) UpdateFileShareOwnerArgsForCall(i int) (string, azurefilebroker.FileShareOwner) {
+	fake.updateFileShareOwnerMutex.RLock()
+	defer fake.updateFileShareOwnerMutex.RUnlock()
+	return fake.updateFileShareOwnerArgsForCall[i].id, fake.updateFileShareOwnerArgsForCall[i].owner
+}
+
+func (fake *FakeStore) UpdateFileShareOwnerReturns(result1 error) {
+	fake.UpdateFileShareOwnerStub = nil
+	fake.updateFileShareOwnerReturns = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeStore) CreateServiceInstanceReturnsOnCall(i int, result1 error) {
-	fake.CreateServiceInstanceStub = nil
-	if fake.createServiceInstanceReturnsOnCall == nil {
-		fake.createServiceInstanceReturnsOnCall = make(map[int]struct {
+func (fake *FakeStore) UpdateFileShareOwnerReturnsOnCall(i int, result1 error) {
+	fake.UpdateFileShareOwnerStub = nil
+	if fake.updateFileShareOwnerReturnsOnCall == nil {
+		fake.updateFileShareOwnerReturnsOnCall = make(map[int]struct {
 			result1 error
 		})
 	}
-	fake.createServiceInstanceReturnsOnCall[i] = struct {
+	fake.updateFileShareOwnerReturnsOnCall[i] = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeStore) CreateBindingDetails(id string, details brokerapi.BindDetails, redactRawParameter bool) error {
-	fake.createBindingDetailsMutex.Lock()
-	ret, specificReturn := fake.createBindingDetailsReturnsOnCall[len(fake.createBindingDetailsArgsForCall)]
-	fake.createBindingDetailsArgsForCall = append(fake.createBindingDetailsArgsForCall, struct {
-		id                 string
-		details            brokerapi.BindDetails
-		redactRawParameter bool
-	}{id, details, redactRawParameter})
-	fake.recordInvocation("CreateBindingDetails", []interface{}{id, details, redactRawParameter})
-	fake.createBindingDetailsMutex.Unlock()
-	if fake.CreateBindingDetailsStub != nil {
-		return fake.CreateBindingDetailsStub(id, details, redactRawParameter)
+func (fake *FakeStore) UpdateFeatureFlag(id string, flag azurefilebroker.FeatureFlag) error {
+	fake.updateFeatureFlagMutex.Lock()
+	ret, specificReturn := fake.updateFeatureFlagReturnsOnCall[len(fake.updateFeatureFlagArgsForCall)]
+	fake.updateFeatureFlagArgsForCall = append(fake.updateFeatureFlagArgsForCall, struct {
+		id   string
+		flag azurefilebroker.FeatureFlag
+	}{id, flag})
+	fake.recordInvocation("UpdateFeatureFlag", []interface{}{id, flag})
+	fake.updateFeatureFlagMutex.Unlock()
+	if fake.UpdateFeatureFlagStub != nil {
+		return fake.UpdateFeatureFlagStub(id, flag)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	return fake.createBindingDetailsReturns.result1
+	return fake.updateFeatureFlagReturns.result1
 }
 
-func (fake *FakeStore) CreateBindingDetailsCallCount() int {
-	fake.createBindingDetailsMutex.RLock()
-	defer fake.createBindingDetailsMutex.RUnlock()
-	return len(fake.createBindingDetailsArgsForCall)
+func (fake *FakeStore) UpdateFeatureFlagCallCount() int {
+	fake.updateFeatureFlagMutex.RLock()
+	defer fake.updateFeatureFlagMutex.RUnlock()
+	return len(fake.updateFeatureFlagArgsForCall)
 }
 
-func (fake *FakeStore) CreateBindingDetailsArgsForCall(i int) (string, brokerapi.BindDetails, bool) {
-	fake.createBindingDetailsMutex.RLock()
-	defer fake.createBindingDetailsMutex.RUnlock()
-	return fake.createBindingDetailsArgsForCall[i].id, fake.createBindingDetailsArgsForCall[i].details, fake.createBindingDetailsArgsForCall[i].redactRawParameter
+func (fake *FakeStore) UpdateFeatureFlagArgsForCall(i int) (string, azurefilebroker.FeatureFlag) {
+	fake.updateFeatureFlagMutex.RLock()
+	defer fake.updateFeatureFlagMutex.RUnlock()
+	return fake.updateFeatureFlagArgsForCall[i].id, fake.updateFeatureFlagArgsForCall[i].flag
 }
 
-func (fake *FakeStore) CreateBindingDetailsReturns(result1 error) {
-	fake.CreateBindingDetailsStub = nil
-	fake.createBindingDetailsReturns = struct {
+func (fake *FakeStore) UpdateFeatureFlagReturns(result1 error) {
+	fake.UpdateFeatureFlagStub = nil
+	fake.updateFeatureFlagReturns = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeStore) CreateBindingDetailsReturnsOnCall(i int, result1 error) {
-	fake.CreateBindingDetailsStub = nil
-	if fake.createBindingDetailsReturnsOnCall == nil {
-		fake.createBindingDetailsReturnsOnCall = make(map[int]struct {
+func (fake *FakeStore) UpdateFeatureFlagReturnsOnCall(i int, result1 error) {
+	fake.UpdateFeatureFlagStub = nil
+	if fake.updateFeatureFlagReturnsOnCall == nil {
+		fake.updateFeatureFlagReturnsOnCall = make(map[int]struct {
 			result1 error
 		})
 	}
-	fake.createBindingDetailsReturnsOnCall[i] = struct {
+	fake.updateFeatureFlagReturnsOnCall[i] = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeStore) CreateFileShare(id string, share azurefilebroker.FileShare) error {
-	fake.createFileShareMutex.Lock()
-	ret, specificReturn := fake.createFileShareReturnsOnCall[len(fake.createFileShareArgsForCall)]
-	fake.createFileShareArgsForCall = append(fake.createFileShareArgsForCall, struct {
-		id    string
-		share azurefilebroker.FileShare
-	}{id, share})
-	fake.recordInvocation("CreateFileShare", []interface{}{id, share})
-	fake.createFileShareMutex.Unlock()
-	if fake.CreateFileShareStub != nil {
-		return fake.CreateFileShareStub(id, share)
+func (fake *FakeStore) UpdatePendingShareDeletion(id string, deletion azurefilebroker.PendingShareDeletion) error {
+	fake.updatePendingShareDeletionMutex.Lock()
+	ret, specificReturn := fake.updatePendingShareDeletionReturnsOnCall[len(fake.updatePendingShareDeletionArgsForCall)]
+	fake.updatePendingShareDeletionArgsForCall = append(fake.updatePendingShareDeletionArgsForCall, struct {
+		id       string
+		deletion azurefilebroker.PendingShareDeletion
+	}{id, deletion})
+	fake.recordInvocation("UpdatePendingShareDeletion", []interface{}{id, deletion})
+	fake.updatePendingShareDeletionMutex.Unlock()
+	if fake.UpdatePendingShareDeletionStub != nil {
+		return fake.UpdatePendingShareDeletionStub(id, deletion)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	return fake.createFileShareReturns.result1
+	return fake.updatePendingShareDeletionReturns.result1
 }
 
-func (fake *FakeStore) CreateFileShareCallCount() int {
-	fake.createFileShareMutex.RLock()
-	defer fake.createFileShareMutex.RUnlock()
-	return len(fake.createFileShareArgsForCall)
+func (fake *FakeStore) UpdatePendingShareDeletionCallCount() int {
+	fake.updatePendingShareDeletionMutex.RLock()
+	defer fake.updatePendingShareDeletionMutex.RUnlock()
+	return len(fake.updatePendingShareDeletionArgsForCall)
 }
 
-func (fake *FakeStore) CreateFileShareArgsForCall(i int) (string, azurefilebroker.FileShare) {
-	fake.createFileShareMutex.RLock()
-	defer fake.createFileShareMutex.RUnlock()
-	return fake.createFileShareArgsForCall[i].id, fake.createFileShareArgsForCall[i].share
+func (fake *FakeStore) UpdatePendingShareDeletionArgsForCall(i int) (string, azurefilebroker.PendingShareDeletion) {
+	fake.updatePendingShareDeletionMutex.RLock()
+	defer fake.updatePendingShareDeletionMutex.RUnlock()
+	return fake.updatePendingShareDeletionArgsForCall[i].id, fake.updatePendingShareDeletionArgsForCall[i].deletion
 }
 
-func (fake *FakeStore) CreateFileShareReturns(result1 error) {
-	fake.CreateFileShareStub = nil
-	fake.createFileShareReturns = struct {
+func (fake *FakeStore) UpdatePendingShareDeletionReturns(result1 error) {
+	fake.UpdatePendingShareDeletionStub = nil
+	fake.updatePendingShareDeletionReturns = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeStore) CreateFileShareReturnsOnCall(i int, result1 error) {
-	fake.CreateFileShareStub = nil
-	if fake.createFileShareReturnsOnCall == nil {
-		fake.createFileShareReturnsOnCall = make(map[int]struct {
+func (fake *FakeStore) UpdatePendingShareDeletionReturnsOnCall(i int, result1 error) {
+	fake.UpdatePendingShareDeletionStub = nil
+	if fake.updatePendingShareDeletionReturnsOnCall == nil {
+		fake.updatePendingShareDeletionReturnsOnCall = make(map[int]struct {
 			result1 error
 		})
 	}
-	fake.createFileShareReturnsOnCall[i] = struct {
+	fake.updatePendingShareDeletionReturnsOnCall[i] = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeStore) UpdateFileShare(id string, share azurefilebroker.FileShare) error {
-	fake.updateFileShareMutex.Lock()
-	ret, specificReturn := fake.updateFileShareReturnsOnCall[len(fake.updateFileShareArgsForCall)]
-	fake.updateFileShareArgsForCall = append(fake.updateFileShareArgsForCall, struct {
-		id    string
-		share azurefilebroker.FileShare
-	}{id, share})
-	fake.recordInvocation("UpdateFileShare", []interface{}{id, share})
-	fake.updateFileShareMutex.Unlock()
-	if fake.UpdateFileShareStub != nil {
-		return fake.UpdateFileShareStub(id, share)
+func (fake *FakeStore) UpdatePooledStorageAccount(id string, account azurefilebroker.PooledStorageAccount) error {
+	fake.updatePooledStorageAccountMutex.Lock()
+	ret, specificReturn := fake.updatePooledStorageAccountReturnsOnCall[len(fake.updatePooledStorageAccountArgsForCall)]
+	fake.updatePooledStorageAccountArgsForCall = append(fake.updatePooledStorageAccountArgsForCall, struct {
+		id      string
+		account azurefilebroker.PooledStorageAccount
+	}{id, account})
+	fake.recordInvocation("UpdatePooledStorageAccount", []interface{}{id, account})
+	fake.updatePooledStorageAccountMutex.Unlock()
+	if fake.UpdatePooledStorageAccountStub != nil {
+		return fake.UpdatePooledStorageAccountStub(id, account)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	return fake.updateFileShareReturns.result1
+	return fake.updatePooledStorageAccountReturns.result1
 }
 
-func (fake *FakeStore) UpdateFileShareCallCount() int {
-	fake.updateFileShareMutex.RLock()
-	defer fake.updateFileShareMutex.RUnlock()
-	return len(fake.updateFileShareArgsForCall)
+func (fake *FakeStore) UpdatePooledStorageAccountCallCount() int {
+	fake.updatePooledStorageAccountMutex.RLock()
+	defer fake.updatePooledStorageAccountMutex.RUnlock()
+	return len(fake.updatePooledStorageAccountArgsForCall)
 }
 
-func (fake *FakeStore) UpdateFileShareArgsForCall(i int) (string, azurefilebroker.FileShare) {
-	fake.updateFileShareMutex.RLock()
-	defer fake.updateFileShareMutex.RUnlock()
-	return fake.updateFileShareArgsForCall[i].id, fake.updateFileShareArgsForCall[i].share
+func (fake *FakeStore) UpdatePooledStorageAccountArgsForCall(i int) (string, azurefilebroker.PooledStorageAccount) {
+	fake.updatePooledStorageAccountMutex.RLock()
+	defer fake.updatePooledStorageAccountMutex.RUnlock()
+	return fake.updatePooledStorageAccountArgsForCall[i].id, fake.updatePooledStorageAccountArgsForCall[i].account
 }
 
-func (fake *FakeStore) UpdateFileShareReturns(result1 error) {
-	fake.UpdateFileShareStub = nil
-	fake.updateFileShareReturns = struct {
+func (fake *FakeStore) UpdatePooledStorageAccountReturns(result1 error) {
+	fake.UpdatePooledStorageAccountStub = nil
+	fake.updatePooledStorageAccountReturns = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *FakeStore) UpdateFileShareReturnsOnCall(i int, result1 error) {
-	fake.UpdateFileShareStub = nil
-	if fake.updateFileShareReturnsOnCall == nil {
-		fake.updateFileShareReturnsOnCall = make(map[int]struct {
+func (fake *FakeStore) UpdatePooledStorageAccountReturnsOnCall(i int, result1 error) {
+	fake.UpdatePooledStorageAccountStub = nil
+	if fake.updatePooledStorageAccountReturnsOnCall == nil {
+		fake.updatePooledStorageAccountReturnsOnCall = make(map[int]struct {
 			result1 error
 		})
 	}
-	fake.updateFileShareReturnsOnCall[i] = struct {
+	fake.updatePooledStorageAccountReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) UpdateLockHolder(id string, holder azurefilebroker.LockHolder) error {
+	fake.updateLockHolderMutex.Lock()
+	ret, specificReturn := fake.updateLockHolderReturnsOnCall[len(fake.updateLockHolderArgsForCall)]
+	fake.updateLockHolderArgsForCall = append(fake.updateLockHolderArgsForCall, struct {
+		id     string
+		holder azurefilebroker.LockHolder
+	}{id, holder})
+	fake.recordInvocation("UpdateLockHolder", []interface{}{id, holder})
+	fake.updateLockHolderMutex.Unlock()
+	if fake.UpdateLockHolderStub != nil {
+		return fake.UpdateLockHolderStub(id, holder)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.updateLockHolderReturns.result1
+}
+
+func (fake *FakeStore) UpdateLockHolderCallCount() int {
+	fake.updateLockHolderMutex.RLock()
+	defer fake.updateLockHolderMutex.RUnlock()
+	return len(fake.updateLockHolderArgsForCall)
+}
+
+func (fake *FakeStore) UpdateLockHolderArgsForCall(i int) (string, azurefilebroker.LockHolder) {
+	fake.updateLockHolderMutex.RLock()
+	defer fake.updateLockHolderMutex.RUnlock()
+	return fake.updateLockHolderArgsForCall[i].id, fake.updateLockHolderArgsForCall[i].holder
+}
+
+func (fake *FakeStore) UpdateLockHolderReturns(result1 error) {
+	fake.UpdateLockHolderStub = nil
+	fake.updateLockHolderReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) UpdateLockHolderReturnsOnCall(i int, result1 error) {
+	fake.UpdateLockHolderStub = nil
+	if fake.updateLockHolderReturnsOnCall == nil {
+		fake.updateLockHolderReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.updateLockHolderReturnsOnCall[i] = struct {
 		result1 error
 	}{result1}
 }
@@ -651,6 +2384,294 @@ func (fake *FakeStore) DeleteFileShareReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeStore) DeleteStorageAccountOwner(id string) error {
+	fake.deleteStorageAccountOwnerMutex.Lock()
+	ret, specificReturn := fake.deleteStorageAccountOwnerReturnsOnCall[len(fake.deleteStorageAccountOwnerArgsForCall)]
+	fake.deleteStorageAccountOwnerArgsForCall = append(fake.deleteStorageAccountOwnerArgsForCall, struct {
+		id string
+	}{id})
+	fake.recordInvocation("DeleteStorageAccountOwner", []interface{}{id})
+	fake.deleteStorageAccountOwnerMutex.Unlock()
+	if fake.DeleteStorageAccountOwnerStub != nil {
+		return fake.DeleteStorageAccountOwnerStub(id)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.deleteStorageAccountOwnerReturns.result1
+}
+
+func (fake *FakeStore) DeleteStorageAccountOwnerCallCount() int {
+	fake.deleteStorageAccountOwnerMutex.RLock()
+	defer fake.deleteStorageAccountOwnerMutex.RUnlock()
+	return len(fake.deleteStorageAccountOwnerArgsForCall)
+}
+
+func (fake *FakeStore) DeleteStorageAccountOwnerArgsForCall(i int) string {
+	fake.deleteStorageAccountOwnerMutex.RLock()
+	defer fake.deleteStorageAccountOwnerMutex.RUnlock()
+	return fake.deleteStorageAccountOwnerArgsForCall[i].id
+}
+
+func (fake *FakeStore) DeleteStorageAccountOwnerReturns(result1 error) {
+	fake.DeleteStorageAccountOwnerStub = nil
+	fake.deleteStorageAccountOwnerReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeleteStorageAccountOwnerReturnsOnCall(i int, result1 error) {
+	fake.DeleteStorageAccountOwnerStub = nil
+	if fake.deleteStorageAccountOwnerReturnsOnCall == nil {
+		fake.deleteStorageAccountOwnerReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteStorageAccountOwnerReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeleteFileShareOwner(id string) error {
+	fake.deleteFileShareOwnerMutex.Lock()
+	ret, specificReturn := fake.deleteFileShareOwnerReturnsOnCall[len(fake.deleteFileShareOwnerArgsForCall)]
+	fake.deleteFileShareOwnerArgsForCall = append(fake.deleteFileShareOwnerArgsForCall, struct {
+		id string
+	}{id})
+	fake.recordInvocation("DeleteFileShareOwner", []interface{}{id})
+	fake.deleteFileShareOwnerMutex.Unlock()
+	if fake.DeleteFileShareOwnerStub != nil {
+		return fake.DeleteFileShareOwnerStub(id)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.deleteFileShareOwnerReturns.result1
+}
+
+func (fake *FakeStore) DeleteFileShareOwnerCallCount() int {
+	fake.deleteFileShareOwnerMutex.RLock()
+	defer fake.deleteFileShareOwnerMutex.RUnlock()
+	return len(fake.deleteFileShareOwnerArgsForCall)
+}
+
+func (fake *FakeStore) DeleteFileShareOwnerArgsForCall(i int) string {
+	fake.deleteFileShareOwnerMutex.RLock()
+	defer fake.deleteFileShareOwnerMutex.RUnlock()
+	return fake.deleteFileShareOwnerArgsForCall[i].id
+}
+
+func (fake *FakeStore) DeleteFileShareOwnerReturns(result1 error) {
+	fake.DeleteFileShareOwnerStub = nil
+	fake.deleteFileShareOwnerReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeleteFileShareOwnerReturnsOnCall(i int, result1 error) {
+	fake.DeleteFileShareOwnerStub = nil
+	if fake.deleteFileShareOwnerReturnsOnCall == nil {
+		fake.deleteFileShareOwnerReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteFileShareOwnerReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeleteScheduledDeletion(id string) error {
+	fake.deleteScheduledDeletionMutex.Lock()
+	ret, specificReturn := fake.deleteScheduledDeletionReturnsOnCall[len(fake.deleteScheduledDeletionArgsForCall)]
+	fake.deleteScheduledDeletionArgsForCall = append(fake.deleteScheduledDeletionArgsForCall, struct {
+		id string
+	}{id})
+	fake.recordInvocation("DeleteScheduledDeletion", []interface{}{id})
+	fake.deleteScheduledDeletionMutex.Unlock()
+	if fake.DeleteScheduledDeletionStub != nil {
+		return fake.DeleteScheduledDeletionStub(id)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.deleteScheduledDeletionReturns.result1
+}
+
+func (fake *FakeStore) DeleteScheduledDeletionCallCount() int {
+	fake.deleteScheduledDeletionMutex.RLock()
+	defer fake.deleteScheduledDeletionMutex.RUnlock()
+	return len(fake.deleteScheduledDeletionArgsForCall)
+}
+
+func (fake *FakeStore) DeleteScheduledDeletionArgsForCall(i int) string {
+	fake.deleteScheduledDeletionMutex.RLock()
+	defer fake.deleteScheduledDeletionMutex.RUnlock()
+	return fake.deleteScheduledDeletionArgsForCall[i].id
+}
+
+func (fake *FakeStore) DeleteScheduledDeletionReturns(result1 error) {
+	fake.DeleteScheduledDeletionStub = nil
+	fake.deleteScheduledDeletionReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeleteScheduledDeletionReturnsOnCall(i int, result1 error) {
+	fake.DeleteScheduledDeletionStub = nil
+	if fake.deleteScheduledDeletionReturnsOnCall == nil {
+		fake.deleteScheduledDeletionReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteScheduledDeletionReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeleteFeatureFlag(id string) error {
+	fake.deleteFeatureFlagMutex.Lock()
+	ret, specificReturn := fake.deleteFeatureFlagReturnsOnCall[len(fake.deleteFeatureFlagArgsForCall)]
+	fake.deleteFeatureFlagArgsForCall = append(fake.deleteFeatureFlagArgsForCall, struct {
+		id string
+	}{id})
+	fake.recordInvocation("DeleteFeatureFlag", []interface{}{id})
+	fake.deleteFeatureFlagMutex.Unlock()
+	if fake.DeleteFeatureFlagStub != nil {
+		return fake.DeleteFeatureFlagStub(id)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.deleteFeatureFlagReturns.result1
+}
+
+func (fake *FakeStore) DeleteFeatureFlagCallCount() int {
+	fake.deleteFeatureFlagMutex.RLock()
+	defer fake.deleteFeatureFlagMutex.RUnlock()
+	return len(fake.deleteFeatureFlagArgsForCall)
+}
+
+func (fake *FakeStore) DeleteFeatureFlagArgsForCall(i int) string {
+	fake.deleteFeatureFlagMutex.RLock()
+	defer fake.deleteFeatureFlagMutex.RUnlock()
+	return fake.deleteFeatureFlagArgsForCall[i].id
+}
+
+func (fake *FakeStore) DeleteFeatureFlagReturns(result1 error) {
+	fake.DeleteFeatureFlagStub = nil
+	fake.deleteFeatureFlagReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeleteFeatureFlagReturnsOnCall(i int, result1 error) {
+	fake.DeleteFeatureFlagStub = nil
+	if fake.deleteFeatureFlagReturnsOnCall == nil {
+		fake.deleteFeatureFlagReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deleteFeatureFlagReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeletePendingShareDeletion(id string) error {
+	fake.deletePendingShareDeletionMutex.Lock()
+	ret, specificReturn := fake.deletePendingShareDeletionReturnsOnCall[len(fake.deletePendingShareDeletionArgsForCall)]
+	fake.deletePendingShareDeletionArgsForCall = append(fake.deletePendingShareDeletionArgsForCall, struct {
+		id string
+	}{id})
+	fake.recordInvocation("DeletePendingShareDeletion", []interface{}{id})
+	fake.deletePendingShareDeletionMutex.Unlock()
+	if fake.DeletePendingShareDeletionStub != nil {
+		return fake.DeletePendingShareDeletionStub(id)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.deletePendingShareDeletionReturns.result1
+}
+
+func (fake *FakeStore) DeletePendingShareDeletionCallCount() int {
+	fake.deletePendingShareDeletionMutex.RLock()
+	defer fake.deletePendingShareDeletionMutex.RUnlock()
+	return len(fake.deletePendingShareDeletionArgsForCall)
+}
+
+func (fake *FakeStore) DeletePendingShareDeletionArgsForCall(i int) string {
+	fake.deletePendingShareDeletionMutex.RLock()
+	defer fake.deletePendingShareDeletionMutex.RUnlock()
+	return fake.deletePendingShareDeletionArgsForCall[i].id
+}
+
+func (fake *FakeStore) DeletePendingShareDeletionReturns(result1 error) {
+	fake.DeletePendingShareDeletionStub = nil
+	fake.deletePendingShareDeletionReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeletePendingShareDeletionReturnsOnCall(i int, result1 error) {
+	fake.DeletePendingShareDeletionStub = nil
+	if fake.deletePendingShareDeletionReturnsOnCall == nil {
+		fake.deletePendingShareDeletionReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deletePendingShareDeletionReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeletePooledStorageAccount(id string) error {
+	fake.deletePooledStorageAccountMutex.Lock()
+	ret, specificReturn := fake.deletePooledStorageAccountReturnsOnCall[len(fake.deletePooledStorageAccountArgsForCall)]
+	fake.deletePooledStorageAccountArgsForCall = append(fake.deletePooledStorageAccountArgsForCall, struct {
+		id string
+	}{id})
+	fake.recordInvocation("DeletePooledStorageAccount", []interface{}{id})
+	fake.deletePooledStorageAccountMutex.Unlock()
+	if fake.DeletePooledStorageAccountStub != nil {
+		return fake.DeletePooledStorageAccountStub(id)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fake.deletePooledStorageAccountReturns.result1
+}
+
+func (fake *FakeStore) DeletePooledStorageAccountCallCount() int {
+	fake.deletePooledStorageAccountMutex.RLock()
+	defer fake.deletePooledStorageAccountMutex.RUnlock()
+	return len(fake.deletePooledStorageAccountArgsForCall)
+}
+
+func (fake *FakeStore) DeletePooledStorageAccountArgsForCall(i int) string {
+	fake.deletePooledStorageAccountMutex.RLock()
+	defer fake.deletePooledStorageAccountMutex.RUnlock()
+	return fake.deletePooledStorageAccountArgsForCall[i].id
+}
+
+func (fake *FakeStore) DeletePooledStorageAccountReturns(result1 error) {
+	fake.DeletePooledStorageAccountStub = nil
+	fake.deletePooledStorageAccountReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeStore) DeletePooledStorageAccountReturnsOnCall(i int, result1 error) {
+	fake.DeletePooledStorageAccountStub = nil
+	if fake.deletePooledStorageAccountReturnsOnCall == nil {
+		fake.deletePooledStorageAccountReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.deletePooledStorageAccountReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *FakeStore) GetLockForUpdate(lockName string, timeoutInSeconds int) error {
 	fake.getLockForUpdateMutex.Lock()
 	ret, specificReturn := fake.getLockForUpdateReturnsOnCall[len(fake.getLockForUpdateArgsForCall)]
@@ -753,24 +2774,92 @@ func (fake *FakeStore) Invocations() map[string][][]interface{} {
 	defer fake.invocationsMutex.RUnlock()
 	fake.retrieveServiceInstanceMutex.RLock()
 	defer fake.retrieveServiceInstanceMutex.RUnlock()
+	fake.retrieveServiceInstancesMutex.RLock()
+	defer fake.retrieveServiceInstancesMutex.RUnlock()
+	fake.retrieveServiceInstancesByTargetNameMutex.RLock()
+	defer fake.retrieveServiceInstancesByTargetNameMutex.RUnlock()
 	fake.retrieveBindingDetailsMutex.RLock()
 	defer fake.retrieveBindingDetailsMutex.RUnlock()
+	fake.retrieveAllBindingDetailsMutex.RLock()
+	defer fake.retrieveAllBindingDetailsMutex.RUnlock()
 	fake.retrieveFileShareMutex.RLock()
 	defer fake.retrieveFileShareMutex.RUnlock()
+	fake.retrieveFileSharesMutex.RLock()
+	defer fake.retrieveFileSharesMutex.RUnlock()
+	fake.retrieveStorageAccountOwnerMutex.RLock()
+	defer fake.retrieveStorageAccountOwnerMutex.RUnlock()
+	fake.retrieveFileShareOwnerMutex.RLock()
+	defer fake.retrieveFileShareOwnerMutex.RUnlock()
+	fake.retrieveScheduledDeletionMutex.RLock()
+	defer fake.retrieveScheduledDeletionMutex.RUnlock()
+	fake.retrieveScheduledDeletionsMutex.RLock()
+	defer fake.retrieveScheduledDeletionsMutex.RUnlock()
+	fake.retrieveFeatureFlagsMutex.RLock()
+	defer fake.retrieveFeatureFlagsMutex.RUnlock()
+	fake.retrievePendingShareDeletionsMutex.RLock()
+	defer fake.retrievePendingShareDeletionsMutex.RUnlock()
+	fake.retrievePooledStorageAccountsMutex.RLock()
+	defer fake.retrievePooledStorageAccountsMutex.RUnlock()
+	fake.retrieveInstanceOperationsMutex.RLock()
+	defer fake.retrieveInstanceOperationsMutex.RUnlock()
+	fake.retrieveLockHolderMutex.RLock()
+	defer fake.retrieveLockHolderMutex.RUnlock()
+	fake.retrieveLockHoldersMutex.RLock()
+	defer fake.retrieveLockHoldersMutex.RUnlock()
 	fake.createServiceInstanceMutex.RLock()
 	defer fake.createServiceInstanceMutex.RUnlock()
 	fake.createBindingDetailsMutex.RLock()
 	defer fake.createBindingDetailsMutex.RUnlock()
 	fake.createFileShareMutex.RLock()
 	defer fake.createFileShareMutex.RUnlock()
+	fake.createStorageAccountOwnerMutex.RLock()
+	defer fake.createStorageAccountOwnerMutex.RUnlock()
+	fake.createFileShareOwnerMutex.RLock()
+	defer fake.createFileShareOwnerMutex.RUnlock()
+	fake.createScheduledDeletionMutex.RLock()
+	defer fake.createScheduledDeletionMutex.RUnlock()
+	fake.createFeatureFlagMutex.RLock()
+	defer fake.createFeatureFlagMutex.RUnlock()
+	fake.createPendingShareDeletionMutex.RLock()
+	defer fake.createPendingShareDeletionMutex.RUnlock()
+	fake.createPooledStorageAccountMutex.RLock()
+	defer fake.createPooledStorageAccountMutex.RUnlock()
+	fake.createInstanceOperationMutex.RLock()
+	defer fake.createInstanceOperationMutex.RUnlock()
+	fake.createLockHolderMutex.RLock()
+	defer fake.createLockHolderMutex.RUnlock()
+	fake.updateServiceInstanceMutex.RLock()
+	defer fake.updateServiceInstanceMutex.RUnlock()
 	fake.updateFileShareMutex.RLock()
 	defer fake.updateFileShareMutex.RUnlock()
+	fake.updateFileShareOwnerMutex.RLock()
+	defer fake.updateFileShareOwnerMutex.RUnlock()
+	fake.updateFeatureFlagMutex.RLock()
+	defer fake.updateFeatureFlagMutex.RUnlock()
+	fake.updatePendingShareDeletionMutex.RLock()
+	defer fake.updatePendingShareDeletionMutex.RUnlock()
+	fake.updatePooledStorageAccountMutex.RLock()
+	defer fake.updatePooledStorageAccountMutex.RUnlock()
+	fake.updateLockHolderMutex.RLock()
+	defer fake.updateLockHolderMutex.RUnlock()
 	fake.deleteServiceInstanceMutex.RLock()
 	defer fake.deleteServiceInstanceMutex.RUnlock()
 	fake.deleteBindingDetailsMutex.RLock()
 	defer fake.deleteBindingDetailsMutex.RUnlock()
 	fake.deleteFileShareMutex.RLock()
 	defer fake.deleteFileShareMutex.RUnlock()
+	fake.deleteStorageAccountOwnerMutex.RLock()
+	defer fake.deleteStorageAccountOwnerMutex.RUnlock()
+	fake.deleteFileShareOwnerMutex.RLock()
+	defer fake.deleteFileShareOwnerMutex.RUnlock()
+	fake.deleteScheduledDeletionMutex.RLock()
+	defer fake.deleteScheduledDeletionMutex.RUnlock()
+	fake.deleteFeatureFlagMutex.RLock()
+	defer fake.deleteFeatureFlagMutex.RUnlock()
+	fake.deletePendingShareDeletionMutex.RLock()
+	defer fake.deletePendingShareDeletionMutex.RUnlock()
+	fake.deletePooledStorageAccountMutex.RLock()
+	defer fake.deletePooledStorageAccountMutex.RUnlock()
 	fake.getLockForUpdateMutex.RLock()
 	defer fake.getLockForUpdateMutex.RUnlock()
 	fake.releaseLockForUpdateMutex.RLock()
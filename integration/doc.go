@@ -0,0 +1,11 @@
+// Package integration is the end-to-end suite of the broker against Azure. Its specs are built only with the tag
+// integration so that go test ./... does not need credentials:
+//
+//	AZURE_TENANT_ID=... AZURE_CLIENT_ID=... AZURE_CLIENT_SECRET=... AZURE_SUBSCRIPTION_ID=... \
+//	DB_DRIVER=mysql DB_HOSTNAME=... DB_PORT=3306 DB_NAME=... DB_USERNAME=... DB_PASSWORD=... \
+//	go test -tags integration -timeout 2h ./integration/...
+//
+// AZURE_ENVIRONMENT defaults to AzureCloud and AZURE_LOCATION to westus. The service principal needs the role
+// Contributor in the subscription. The suite creates a resource group azurefilebroker-integration-<random>, runs
+// provision, bind, unbind and deprovision of the AzureFileShare plan in it, and deletes it with everything in it.
+package integration
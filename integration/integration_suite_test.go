@@ -0,0 +1,106 @@
+//go:build integration
+// +build integration
+
+package integration_test
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// The integration suite runs the broker against Azure and a database. It is built only with the tag integration, e.g.
+//
+//	go test -tags integration -timeout 2h ./integration/...
+//
+// and creates a resource group which it deletes with everything in it when the suite ends.
+var (
+	env           integrationEnvironment
+	resourceGroup *resourceGroupClient
+)
+
+// integrationEnvironment is the configuration of the suite, read from the environment
+type integrationEnvironment struct {
+	Environment       string
+	TenantID          string
+	ClientID          string
+	ClientSecret      string
+	SubscriptionID    string
+	Location          string
+	ResourceGroupName string
+
+	DBDriver   string
+	DBHostname string
+	DBPort     string
+	DBName     string
+	DBUsername string
+	DBPassword string
+}
+
+func TestIntegration(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Azurefilebroker Integration Suite")
+}
+
+var _ = BeforeSuite(func() {
+	rand.Seed(time.Now().UnixNano())
+
+	var missing []string
+	lookup := func(name, defaultValue string) string {
+		value := os.Getenv(name)
+		if value == "" && defaultValue == "" {
+			missing = append(missing, name)
+		} else if value == "" {
+			value = defaultValue
+		}
+		return value
+	}
+	env = integrationEnvironment{
+		Environment:       lookup("AZURE_ENVIRONMENT", azurefilebroker.AzureCloud),
+		TenantID:          lookup("AZURE_TENANT_ID", ""),
+		ClientID:          lookup("AZURE_CLIENT_ID", ""),
+		ClientSecret:      lookup("AZURE_CLIENT_SECRET", ""),
+		SubscriptionID:    lookup("AZURE_SUBSCRIPTION_ID", ""),
+		Location:          lookup("AZURE_LOCATION", "westus"),
+		ResourceGroupName: fmt.Sprintf("azurefilebroker-integration-%s", randomSuffix(10)),
+		DBDriver:          lookup("DB_DRIVER", ""),
+		DBHostname:        lookup("DB_HOSTNAME", ""),
+		DBPort:            lookup("DB_PORT", ""),
+		DBName:            lookup("DB_NAME", ""),
+		DBUsername:        lookup("DB_USERNAME", ""),
+		DBPassword:        lookup("DB_PASSWORD", ""),
+	}
+	Expect(missing).To(BeEmpty(), "The integration suite requires the environment variables %s", strings.Join(missing, ", "))
+	_, ok := azurefilebroker.Environments[env.Environment]
+	Expect(ok).To(BeTrue(), "Unknown AZURE_ENVIRONMENT %q", env.Environment)
+
+	resourceGroup = newResourceGroupClient(env)
+	Expect(resourceGroup.Create()).To(Succeed())
+	fmt.Fprintf(GinkgoWriter, "Created the resource group %s in the subscription %s\n", env.ResourceGroupName, env.SubscriptionID)
+})
+
+var _ = AfterSuite(func() {
+	if resourceGroup == nil {
+		return
+	}
+	// Deleting the resource group deletes the storage accounts which a failed spec left behind
+	Expect(resourceGroup.Delete()).To(Succeed())
+	fmt.Fprintf(GinkgoWriter, "Deleted the resource group %s\n", env.ResourceGroupName)
+})
+
+// randomSuffix returns n random lowercase letters and digits, which are valid in the names of all Azure resources
+func randomSuffix(n int) string {
+	const characters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	suffix := make([]byte, n)
+	for i := range suffix {
+		suffix[i] = characters[rand.Intn(len(characters))]
+	}
+	return string(suffix)
+}
@@ -0,0 +1,159 @@
+//go:build integration
+// +build integration
+
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	file "github.com/Azure/azure-sdk-for-go/storage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+const (
+	operationTimeout      = 30 * time.Minute
+	operationPollInterval = 10 * time.Second
+)
+
+var _ = Describe("AzureFileShare", func() {
+	var (
+		logger             *lagertest.TestLogger
+		broker             *azurefilebroker.Broker
+		smokeTest          *azurefilebroker.SmokeTest
+		storageAccountName string
+		fileShareName      string
+		provisioned        bool
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("integration")
+		logger.RegisterSink(lager.NewWriterSink(GinkgoWriter, lager.INFO))
+
+		store := azurefilebroker.NewStore(logger, env.DBDriver, env.DBUsername, env.DBPassword, env.DBHostname, env.DBPort, env.DBName, "", "", "", nil)
+		Expect(store.EnsureSchema(logger, false)).To(Succeed())
+
+		mount := azurefilebroker.NewAzurefilebrokerMountConfig()
+		mount.ReadConf("share,uid,gid,file_mode,dir_mode,readonly,vers,mount,domain,username,password,sec", "")
+		cloud := azurefilebroker.NewAzurefilebrokerCloudConfig(
+			azurefilebroker.NewAzureConfig(env.Environment, env.TenantID, env.ClientID, env.ClientSecret, env.SubscriptionID, env.ResourceGroupName, env.Location, "", "", "", nil),
+			azurefilebroker.NewControlConfig(true, true, true, true, false, "", false, false, false, 0, 0),
+			azurefilebroker.NewAzureStackConfig("", "", "", ""),
+			azurefilebroker.NewCredHubConfig("", "", "", ""),
+		)
+		Expect(cloud.Validate()).To(Succeed())
+
+		config := azurefilebroker.NewAzurefilebrokerConfig(
+			mount,
+			cloud,
+			azurefilebroker.NewPreexistingConfig(""),
+			azurefilebroker.NewTimeoutConfig(0, 0, 0, 0, 0, 0, 0, 0),
+			azurefilebroker.NewPlacementConfig(""),
+			azurefilebroker.NewNamingConfig("", ""),
+			azurefilebroker.NewIsolationSegmentConfig(""),
+			azurefilebroker.NewCredentialConfig(""),
+			azurefilebroker.NewStoragePoolConfig(""),
+			azurefilebroker.NewBackupConfig("", ""),
+			azurefilebroker.NewAlertConfig("", ""),
+			azurefilebroker.NewAccessLogConfig("", "", ""),
+		)
+		broker = azurefilebroker.New(logger, "azurefile-service", "integration-service-id", clock.NewClock(), store, config)
+
+		suffix := randomSuffix(10)
+		storageAccountName = "afbit" + suffix
+		fileShareName = "share-" + suffix
+		smokeTest = azurefilebroker.NewSmokeTest("instance-"+suffix, "binding-"+suffix, storageAccountName, fileShareName)
+		provisioned = false
+	})
+
+	AfterEach(func() {
+		if !provisioned {
+			return
+		}
+		// The instance of a failed spec is deprovisioned so that the store is clean. The resource group is deleted at
+		// the end of the suite anyway.
+		_, err := broker.Deprovision(context.Background(), smokeTest.InstanceID, brokerapi.DeprovisionDetails{ServiceID: "integration-service-id", PlanID: smokeTest.PlanID}, true)
+		if err != nil {
+			fmt.Fprintf(GinkgoWriter, "Cannot deprovision the instance %s: %s\n", smokeTest.InstanceID, err)
+		}
+	})
+
+	waitForOperation := func(operationData string, deprovisioning bool) {
+		Eventually(func() (brokerapi.LastOperationState, error) {
+			lastOperation, err := broker.LastOperation(context.Background(), smokeTest.InstanceID, operationData)
+			if err == brokerapi.ErrInstanceDoesNotExist && deprovisioning {
+				return brokerapi.Succeeded, nil
+			}
+			return lastOperation.State, err
+		}, operationTimeout, operationPollInterval).Should(Equal(brokerapi.Succeeded))
+	}
+
+	It("provisions, binds, unbinds and deprovisions a file share in a new storage account", func() {
+		By("provisioning the instance")
+		spec, err := broker.Provision(context.Background(), smokeTest.InstanceID, brokerapi.ProvisionDetails{
+			ServiceID:        "integration-service-id",
+			PlanID:           smokeTest.PlanID,
+			OrganizationGUID: "integration-org",
+			SpaceGUID:        "integration-space",
+			RawParameters:    smokeTest.ProvisionParameters,
+		}, true)
+		Expect(err).NotTo(HaveOccurred())
+		provisioned = true
+		if spec.IsAsync {
+			waitForOperation(spec.OperationData, false)
+		}
+		Expect(resourceGroup.StorageAccountExists(storageAccountName)).To(BeTrue())
+
+		By("binding the instance")
+		binding, err := broker.Bind(context.Background(), smokeTest.InstanceID, smokeTest.BindingID, brokerapi.BindDetails{
+			AppGUID:       "integration-app",
+			ServiceID:     "integration-service-id",
+			PlanID:        smokeTest.PlanID,
+			RawParameters: smokeTest.BindParameters,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(binding.VolumeMounts).To(HaveLen(1))
+		Expect(binding.VolumeMounts[0].Driver).To(Equal("smbdriver"))
+		Expect(binding.VolumeMounts[0].Device.VolumeId).NotTo(BeEmpty())
+
+		By("validating the mount config against the file share")
+		mountConfig := binding.VolumeMounts[0].Device.MountConfig
+		Expect(mountConfig["username"]).To(Equal(storageAccountName))
+		Expect(mountConfig["password"]).NotTo(BeEmpty())
+		source, ok := mountConfig["source"].(string)
+		Expect(ok).To(BeTrue())
+		matches := regexp.MustCompile(fmt.Sprintf(`^//%s\.file\.([^/]+)/%s$`, storageAccountName, fileShareName)).FindStringSubmatch(source)
+		Expect(matches).NotTo(BeNil(), "Unexpected source %q", source)
+		// The credentials of the mount config must open the share which the SMB driver mounts
+		client, err := file.NewClient(storageAccountName, mountConfig["password"].(string), matches[1], azurefilebroker.Environments[env.Environment].APIVersions.StorageForSDK, true)
+		Expect(err).NotTo(HaveOccurred())
+		fileService := client.GetFileService()
+		exists, err := fileService.GetShareReference(fileShareName).Exists()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exists).To(BeTrue())
+
+		By("unbinding the instance")
+		Expect(broker.Unbind(context.Background(), smokeTest.InstanceID, smokeTest.BindingID, brokerapi.UnbindDetails{ServiceID: "integration-service-id", PlanID: smokeTest.PlanID})).To(Succeed())
+
+		By("deprovisioning the instance")
+		spec2, err := broker.Deprovision(context.Background(), smokeTest.InstanceID, brokerapi.DeprovisionDetails{ServiceID: "integration-service-id", PlanID: smokeTest.PlanID}, true)
+		Expect(err).NotTo(HaveOccurred())
+		if spec2.IsAsync {
+			waitForOperation(spec2.OperationData, true)
+		}
+		provisioned = false
+
+		By("checking that the storage account which the broker created is deleted")
+		Eventually(func() (bool, error) {
+			return resourceGroup.StorageAccountExists(storageAccountName)
+		}, operationTimeout, operationPollInterval).Should(BeFalse())
+	})
+})
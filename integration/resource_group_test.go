@@ -0,0 +1,158 @@
+//go:build integration
+// +build integration
+
+package integration_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/azurefilebroker/azurefilebroker"
+	resty "gopkg.in/resty.v0"
+)
+
+const (
+	resourceGroupCreatorTag     = "azurefilebroker-integration"
+	resourceGroupDeletionPoll   = 15 * time.Second
+	resourceGroupDeletionExpiry = 30 * time.Minute
+)
+
+// resourceGroupClient creates and deletes the throwaway resource group of the suite and looks up the resources in it.
+// The broker has no API to manage the resource groups, so the suite calls Azure Resource Manager directly.
+type resourceGroupClient struct {
+	env         integrationEnvironment
+	environment azurefilebroker.Environment
+	accessToken string
+	expiresOn   time.Time
+}
+
+func newResourceGroupClient(env integrationEnvironment) *resourceGroupClient {
+	return &resourceGroupClient{env: env, environment: azurefilebroker.Environments[env.Environment]}
+}
+
+// Create creates the resource group with a tag which tells where it comes from if the suite fails to delete it
+func (c *resourceGroupClient) Create() error {
+	body, err := json.Marshal(map[string]interface{}{
+		"location": c.env.Location,
+		"tags":     map[string]string{"creator": resourceGroupCreatorTag},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := c.send(http.MethodPut, c.resourceGroupURL(), c.environment.APIVersions.ResourceManager, body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusOK && resp.StatusCode() != http.StatusCreated {
+		return fmt.Errorf("Cannot create the resource group %q. Error Code: %d, %s", c.env.ResourceGroupName, resp.StatusCode(), resp.String())
+	}
+	return nil
+}
+
+// Delete deletes the resource group and waits until Azure has deleted it with everything in it
+func (c *resourceGroupClient) Delete() error {
+	resp, err := c.send(http.MethodDelete, c.resourceGroupURL(), c.environment.APIVersions.ResourceManager, nil)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode() {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+	case http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("Cannot delete the resource group %q. Error Code: %d, %s", c.env.ResourceGroupName, resp.StatusCode(), resp.String())
+	}
+
+	deadline := time.Now().Add(resourceGroupDeletionExpiry)
+	for time.Now().Before(deadline) {
+		exists, err := c.exists(c.resourceGroupURL(), c.environment.APIVersions.ResourceManager)
+		if err != nil || !exists {
+			return err
+		}
+		time.Sleep(resourceGroupDeletionPoll)
+	}
+	return fmt.Errorf("The resource group %q was not deleted in %s", c.env.ResourceGroupName, resourceGroupDeletionExpiry)
+}
+
+// StorageAccountExists returns true if the storage account is in the resource group
+func (c *resourceGroupClient) StorageAccountExists(storageAccountName string) (bool, error) {
+	return c.exists(fmt.Sprintf("%s/providers/Microsoft.Storage/storageAccounts/%s", c.resourceGroupURL(), storageAccountName), c.environment.APIVersions.StorageForREST)
+}
+
+func (c *resourceGroupClient) resourceGroupURL() string {
+	return fmt.Sprintf("%s/subscriptions/%s/resourcegroups/%s",
+		strings.TrimRight(c.environment.ResourceManagerEndpointURL, "/"),
+		c.env.SubscriptionID,
+		c.env.ResourceGroupName)
+}
+
+func (c *resourceGroupClient) exists(resourceURL, apiVersion string) (bool, error) {
+	resp, err := c.send(http.MethodGet, resourceURL, apiVersion, nil)
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode() {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Cannot get %q. Error Code: %d, %s", resourceURL, resp.StatusCode(), resp.String())
+	}
+}
+
+func (c *resourceGroupClient) send(method, resourceURL, apiVersion string, body []byte) (*resty.Response, error) {
+	if err := c.refreshToken(); err != nil {
+		return nil, err
+	}
+	request := resty.R().
+		SetHeader("Content-Type", "application/json").
+		SetQueryParam("api-version", apiVersion).
+		SetAuthToken(c.accessToken)
+	if body != nil {
+		request.SetBody(body)
+	}
+	return request.Execute(method, resourceURL)
+}
+
+// refreshToken gets a token of the service principal when it is missing or expires in less than a minute
+func (c *resourceGroupClient) refreshToken() error {
+	if c.accessToken != "" && time.Until(c.expiresOn) > time.Minute {
+		return nil
+	}
+	body := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.env.ClientID},
+		"client_secret": {c.env.ClientSecret},
+		"resource":      {c.environment.ResourceManagerEndpointURL},
+	}
+	resp, err := resty.R().
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		SetQueryParam("api-version", c.environment.APIVersions.ActiveDirectory).
+		SetBody(body.Encode()).
+		Post(fmt.Sprintf("%s/%s/oauth2/token", c.environment.ActiveDirectoryEndpointURL, c.env.TenantID))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("Cannot get a token of the client %q. Error Code: %d, %s", c.env.ClientID, resp.StatusCode(), resp.String())
+	}
+	var token struct {
+		ExpiresOn   string `json:"expires_on"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(resp.Body(), &token); err != nil {
+		return err
+	}
+	expiresOn, err := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	if err != nil {
+		return err
+	}
+	c.accessToken, c.expiresOn = token.AccessToken, time.Unix(expiresOn, 0)
+	return nil
+}